@@ -0,0 +1,411 @@
+// Package cache implements a client-side cache of string values, which is kept
+// up-to-date by redis itself using CLIENT TRACKING (redis 6.0 and later).
+//
+// A Cache holds a pool of connections used to read keys, all of which have
+// tracking turned on and redirected to a separate connection subscribed to
+// invalidation messages. When any key read through the Cache is modified redis
+// sends an invalidation message for it, and it's removed from the Cache.
+//
+//	c, err := cache.New("tcp", "127.0.0.1:6379", 10, 10000)
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//
+//	// The first Get for a key will read it from redis, subsequent ones will
+//	// be served from memory until the key is changed.
+//	foo, err := c.Get("foo").Str()
+//
+// A Cache is also a redis.Cmder, so other commands can be sent through it too,
+// which makes sure that keys written through it are never read back stale.
+//
+// If the invalidation connection is lost nothing is cached until it can be
+// re-established, and everything which was cached is thrown away, since
+// invalidations may have been missed in the meantime.
+package cache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/log"
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+const invalidateChannel = "__redis__:invalidate"
+
+// ErrClosed is returned from Get once Close has been called
+var ErrClosed = errors.New("cache closed")
+
+// Stats describes how a Cache has been performing
+type Stats struct {
+	// The number of calls to Get which were served from memory, and the
+	// number which had to go to redis
+	Hits, Misses int64
+
+	// The number of keys which were removed from the cache because redis
+	// said they changed. Every key is counted when the whole cache is
+	// thrown away (e.g. due to FLUSHALL or a lost connection).
+	Invalidations int64
+}
+
+// Cache is a read-through cache of string keys. It is safe to use from
+// multiple go-routines at once.
+type Cache struct {
+	network, addr string
+	poolSize      int
+	prefixes      []string
+	logger        log.Logger
+
+	l   sync.Mutex
+	lru *lru
+	p   *pool.Pool
+
+	// tracking is whether the invalidation connection is currently up
+	tracking bool
+
+	// epoch is incremented on every invalidation, so that values which were
+	// being read while an invalidation came in aren't cached, since the
+	// invalidation may have been for them
+	epoch uint64
+
+	trackConn *redis.Client
+	closeCh   chan struct{}
+	closeOnce sync.Once
+
+	// accessed atomically
+	hits, misses, invalidations int64
+}
+
+var _ redis.Cmder = &Cache{}
+
+// Opts are the options which can be passed into NewWithOpts. Network, Addr,
+// PoolSize and Size are all required, and mean the same as for New.
+type Opts struct {
+	Network, Addr  string
+	PoolSize, Size int
+
+	// If set, at most this many bytes of keys and values are cached, on top
+	// of the limit of Size keys, with the least recently used being evicted
+	// first. Defaults to 0, meaning only Size applies.
+	MaxBytes int64
+
+	// If set only keys with one of these prefixes are cached, e.g. "user:".
+	// Get still works for other keys, but always calls GET. Defaults to every
+	// key being cached.
+	Prefixes []string
+
+	// Used to log the invalidation connection being lost and re-established.
+	// Entries have the component "cache" and the Addr. It's also passed on to
+	// the pool. Defaults to log.Nop.
+	Logger log.Logger
+}
+
+// New creates a Cache which holds up to size keys, reading them through a pool
+// of the given size. All connections are made to the given network/address.
+func New(network, addr string, poolSize, size int) (*Cache, error) {
+	return NewWithOpts(Opts{Network: network, Addr: addr, PoolSize: poolSize, Size: size})
+}
+
+// NewWithOpts is like New, but with more fine-tuned configuration options. See
+// Opts for the available options.
+func NewWithOpts(o Opts) (*Cache, error) {
+	c := &Cache{
+		network:  o.Network,
+		addr:     o.Addr,
+		poolSize: o.PoolSize,
+		prefixes: o.Prefixes,
+		logger:   log.With(o.Logger, log.KV{log.KeyComponent: "cache", log.KeyAddr: o.Addr}),
+		lru:      newLRU(o.Size, o.MaxBytes),
+		closeCh:  make(chan struct{}),
+	}
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	go c.spin()
+	return c, nil
+}
+
+// connect sets up the invalidation connection and a pool whose connections
+// redirect their invalidations to it
+func (c *Cache) connect() error {
+	trackConn, err := redis.Dial(c.network, c.addr)
+	if err != nil {
+		return err
+	}
+	id, err := trackConn.Cmd("CLIENT", "ID").Int64()
+	if err != nil {
+		trackConn.Close()
+		return err
+	}
+	if err := trackConn.Cmd("SUBSCRIBE", invalidateChannel).Err; err != nil {
+		trackConn.Close()
+		return err
+	}
+
+	df := func(_ context.Context, network, addr string) (*redis.Client, error) {
+		client, err := redis.Dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if err := client.Cmd("CLIENT", "TRACKING", "ON", "REDIRECT", id).Err; err != nil {
+			client.Close()
+			return nil, err
+		}
+		return client, nil
+	}
+	// the pool's entries replace the component, but keep the rest
+	p, err := pool.NewWithOpts(pool.Opts{
+		Network: c.network,
+		Addr:    c.addr,
+		Size:    c.poolSize,
+		Dial:    df,
+		Logger:  c.logger,
+	})
+	if err != nil {
+		trackConn.Close()
+		return err
+	}
+
+	c.l.Lock()
+	if c.isClosed() {
+		c.l.Unlock()
+		trackConn.Close()
+		p.Empty()
+		return ErrClosed
+	}
+	oldP := c.p
+	c.trackConn = trackConn
+	c.p = p
+	c.tracking = true
+	c.l.Unlock()
+
+	if oldP != nil {
+		oldP.Empty()
+	}
+	return nil
+}
+
+func (c *Cache) isClosed() bool {
+	select {
+	case <-c.closeCh:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Cache) spin() {
+	for {
+		c.l.Lock()
+		trackConn := c.trackConn
+		c.l.Unlock()
+
+		r := trackConn.ReadResp()
+		if r.Err == nil {
+			c.handleInvalidate(r)
+			continue
+		} else if c.isClosed() {
+			return
+		}
+
+		// invalidations may be missed until the connection is back, so
+		// nothing can be trusted
+		c.logger.Log(log.Warn, "invalidation connection lost", log.KV{
+			log.KeyOperation: "track",
+			log.KeyErr:       r.Err,
+		})
+		c.l.Lock()
+		c.tracking = false
+		c.clear()
+		c.l.Unlock()
+		trackConn.Close()
+
+		for {
+			err := c.connect()
+			if err == nil {
+				c.logger.Log(log.Info, "invalidation connection re-established", log.KV{
+					log.KeyOperation: "track",
+				})
+				break
+			} else if err == ErrClosed {
+				return
+			}
+			c.logger.Log(log.Debug, "reconnecting failed", log.KV{
+				log.KeyOperation: "track",
+				log.KeyErr:       err,
+			})
+			select {
+			case <-time.After(100 * time.Millisecond):
+			case <-c.closeCh:
+				return
+			}
+		}
+	}
+}
+
+// clear must be called with l held
+func (c *Cache) clear() {
+	atomic.AddInt64(&c.invalidations, int64(c.lru.len()))
+	c.lru.clear()
+	c.epoch++
+}
+
+func (c *Cache) handleInvalidate(r *redis.Resp) {
+	arr, err := r.Array()
+	if err != nil || len(arr) != 3 {
+		return
+	}
+	if typ, _ := arr[0].Str(); typ != "message" {
+		return
+	}
+
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.epoch++
+
+	// a nil list of keys means everything was flushed
+	if arr[2].IsType(redis.Nil) {
+		c.clear()
+		return
+	}
+	keys, err := arr[2].List()
+	if err != nil {
+		c.clear()
+		return
+	}
+	for _, key := range keys {
+		if c.lru.remove(key) {
+			atomic.AddInt64(&c.invalidations, 1)
+		}
+	}
+}
+
+// Get returns the value of the given key, either from memory or by calling GET
+// if it isn't cached yet. Keys which don't exist are cached too, and will be
+// returned as Nil Resps.
+func (c *Cache) Get(key string) *redis.Resp {
+	if c.isClosed() {
+		return redis.NewResp(ErrClosed)
+	} else if !c.cacheable(key) {
+		return c.cmd("GET", key)
+	}
+
+	c.l.Lock()
+	if r, ok := c.lru.get(key); ok {
+		c.l.Unlock()
+		atomic.AddInt64(&c.hits, 1)
+		return r
+	}
+	p, epoch, tracking := c.p, c.epoch, c.tracking
+	c.l.Unlock()
+	atomic.AddInt64(&c.misses, 1)
+
+	conn, err := p.Get()
+	if err != nil {
+		return redis.NewResp(err)
+	}
+	r := conn.Cmd("GET", key)
+
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	// If the pool was replaced while the GET was happening then the
+	// connection is redirecting its invalidations to a dead connection, so
+	// it's closed rather than being put back
+	if p != c.p {
+		conn.Close()
+		return r
+	}
+	p.Put(conn)
+
+	if r.Err == nil && tracking && c.tracking && epoch == c.epoch {
+		c.lru.add(key, r)
+	}
+	return r
+}
+
+func (c *Cache) cacheable(key string) bool {
+	if len(c.prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range c.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Cmd makes Cache a redis.Cmder. A GET of a single key is the same as calling
+// Get, and any other command is sent over one of the Cache's connections.
+//
+// Any of the command's arguments which are cached keys are removed from the
+// Cache before it's sent and again once it's returned, without waiting for
+// redis' invalidation message, so that a GET straight after writing to a key
+// through the Cache never returns the old value. Writes which don't go through
+// the Cache are only seen once their invalidation message arrives.
+func (c *Cache) Cmd(cmd string, args ...interface{}) *redis.Resp {
+	if len(args) == 1 && strings.ToUpper(cmd) == "GET" {
+		if key, ok := args[0].(string); ok {
+			return c.Get(key)
+		}
+	}
+	if c.isClosed() {
+		return redis.NewResp(ErrClosed)
+	}
+	c.removeArgs(args)
+	r := c.cmd(cmd, args...)
+	c.removeArgs(args)
+	return r
+}
+
+// cmd sends the command using the current pool, without touching the cache
+func (c *Cache) cmd(cmd string, args ...interface{}) *redis.Resp {
+	c.l.Lock()
+	p := c.p
+	c.l.Unlock()
+	return p.Cmd(cmd, args...)
+}
+
+// removeArgs removes any of the arguments which are cached keys. Like an
+// invalidation it increments the epoch, so that a value which is being read
+// at the same time isn't cached.
+func (c *Cache) removeArgs(args []interface{}) {
+	strs, err := redis.NewRespFlattenedStrings(args).List()
+	if err != nil {
+		return
+	}
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.epoch++
+	for _, s := range strs {
+		c.lru.remove(s)
+	}
+}
+
+// Stats returns the current Stats for the Cache
+func (c *Cache) Stats() Stats {
+	return Stats{
+		Hits:          atomic.LoadInt64(&c.hits),
+		Misses:        atomic.LoadInt64(&c.misses),
+		Invalidations: atomic.LoadInt64(&c.invalidations),
+	}
+}
+
+// Close closes all of the Cache's connections. Once closed Get will always
+// return ErrClosed.
+func (c *Cache) Close() {
+	c.closeOnce.Do(func() {
+		close(c.closeCh)
+		c.l.Lock()
+		defer c.l.Unlock()
+		c.trackConn.Close()
+		c.p.Empty()
+	})
+}
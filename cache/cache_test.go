@@ -0,0 +1,198 @@
+package cache
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	. "testing"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/log"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func randStr() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func TestLRU(t *T) {
+	l := newLRU(2, 0)
+	a, b, c := redis.NewResp("a"), redis.NewResp("b"), redis.NewResp("c")
+	l.add("a", a)
+	l.add("b", b)
+
+	r, ok := l.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, a, r)
+
+	// b is now the least recently used, and so should be evicted
+	l.add("c", c)
+	_, ok = l.get("b")
+	assert.False(t, ok)
+	_, ok = l.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 2, l.len())
+
+	assert.True(t, l.remove("a"))
+	assert.False(t, l.remove("a"))
+	l.clear()
+	assert.Equal(t, 0, l.len())
+	_, ok = l.get("c")
+	assert.False(t, ok)
+}
+
+func TestLRUMaxBytes(t *T) {
+	// each entry has a 1 byte key and a 2 byte value
+	l := newLRU(10, 7)
+	l.add("a", redis.NewResp("aa"))
+	l.add("b", redis.NewResp("bb"))
+	l.add("c", redis.NewResp("cc"))
+	assert.Equal(t, 2, l.len())
+	_, ok := l.get("a")
+	assert.False(t, ok)
+
+	// replacing a value accounts for the difference in size
+	l.add("b", redis.NewResp("bbbbb"))
+	assert.Equal(t, 1, l.len())
+	_, ok = l.get("b")
+	assert.True(t, ok)
+	assert.Equal(t, int64(6), l.bytes)
+
+	l.remove("b")
+	assert.Equal(t, int64(0), l.bytes)
+}
+
+// waitFor polls fn until it returns true, failing the test if it takes too
+// long
+func waitFor(t *T, fn func() bool) {
+	for i := 0; i < 100; i++ {
+		if fn() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("condition never became true")
+}
+
+func TestCache(t *T) {
+	c, err := New("tcp", "127.0.0.1:6379", 2, 100)
+	require.Nil(t, err)
+	defer c.Close()
+	other, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+
+	key := randStr()
+	require.Nil(t, other.Cmd("SET", key, "a").Err)
+
+	s, err := c.Get(key).Str()
+	require.Nil(t, err)
+	assert.Equal(t, "a", s)
+	s, err = c.Get(key).Str()
+	require.Nil(t, err)
+	assert.Equal(t, "a", s)
+	assert.Equal(t, Stats{Hits: 1, Misses: 1}, c.Stats())
+
+	require.Nil(t, other.Cmd("SET", key, "b").Err)
+	waitFor(t, func() bool { return c.Stats().Invalidations == 1 })
+	s, err = c.Get(key).Str()
+	require.Nil(t, err)
+	assert.Equal(t, "b", s)
+
+	// keys which don't exist are cached too
+	dne := randStr()
+	assert.True(t, c.Get(dne).IsType(redis.Nil))
+	assert.True(t, c.Get(dne).IsType(redis.Nil))
+	assert.Equal(t, int64(2), c.Stats().Hits)
+}
+
+func TestCacheReconnect(t *T) {
+	rec := new(log.Recorder)
+	c, err := NewWithOpts(Opts{
+		Network: "tcp", Addr: "127.0.0.1:6379", PoolSize: 2, Size: 100, Logger: rec,
+	})
+	require.Nil(t, err)
+	defer c.Close()
+
+	key := randStr()
+	c.Get(key)
+	c.l.Lock()
+	assert.Equal(t, 1, c.lru.len())
+	oldTrackConn := c.trackConn
+	c.l.Unlock()
+
+	// killing the invalidation connection should clear the cache, and then a
+	// new one should be made
+	require.Nil(t, oldTrackConn.Close())
+	waitFor(t, func() bool {
+		c.l.Lock()
+		defer c.l.Unlock()
+		return c.tracking && c.trackConn != oldTrackConn
+	})
+	c.l.Lock()
+	assert.Equal(t, 0, c.lru.len())
+	c.l.Unlock()
+	assert.Len(t, rec.Entries("invalidation connection lost"), 1)
+	waitFor(t, func() bool {
+		return len(rec.Entries("invalidation connection re-established")) == 1
+	})
+
+	other, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+	c.Get(key)
+	require.Nil(t, other.Cmd("SET", key, "a").Err)
+	waitFor(t, func() bool { return c.Get(key).Err == nil && c.Stats().Invalidations >= 2 })
+	s, err := c.Get(key).Str()
+	require.Nil(t, err)
+	assert.Equal(t, "a", s)
+}
+
+func TestCacheClose(t *T) {
+	c, err := New("tcp", "127.0.0.1:6379", 2, 100)
+	require.Nil(t, err)
+	c.Close()
+	assert.Equal(t, ErrClosed, c.Get("foo").Err)
+}
+
+func TestCacheCmd(t *T) {
+	c, err := New("tcp", "127.0.0.1:6379", 2, 100)
+	require.Nil(t, err)
+	defer c.Close()
+
+	key := randStr()
+	require.Nil(t, c.Cmd("SET", key, "a").Err)
+	s, err := c.Cmd("GET", key).Str()
+	require.Nil(t, err)
+	assert.Equal(t, "a", s)
+
+	// a write through the Cache removes the key straight away, without waiting
+	// for the invalidation
+	require.Nil(t, c.Cmd("SET", key, "b").Err)
+	s, err = c.Get(key).Str()
+	require.Nil(t, err)
+	assert.Equal(t, "b", s)
+	assert.Equal(t, int64(0), c.Stats().Hits)
+}
+
+func TestCachePrefixes(t *T) {
+	prefix := randStr() + ":"
+	c, err := NewWithOpts(Opts{
+		Network: "tcp", Addr: "127.0.0.1:6379", PoolSize: 2, Size: 100,
+		Prefixes: []string{prefix},
+	})
+	require.Nil(t, err)
+	defer c.Close()
+
+	c.Get(prefix + "foo")
+	c.Get(prefix + "foo")
+	c.Get("foo")
+	c.Get("foo")
+	assert.Equal(t, Stats{Hits: 1, Misses: 1}, c.Stats())
+	c.l.Lock()
+	assert.Equal(t, 1, c.lru.len())
+	c.l.Unlock()
+}
@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"container/list"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+type lruEntry struct {
+	key   string
+	r     *redis.Resp
+	bytes int64
+}
+
+// lru is a simple least-recently-used cache of Resps, holding at most size of
+// them, and if maxBytes isn't 0 at most that many bytes of keys and values. It
+// is not safe to use from multiple go-routines at once.
+type lru struct {
+	size     int
+	maxBytes int64
+	bytes    int64
+	l        *list.List
+	m        map[string]*list.Element
+}
+
+func newLRU(size int, maxBytes int64) *lru {
+	return &lru{
+		size:     size,
+		maxBytes: maxBytes,
+		l:        list.New(),
+		m:        map[string]*list.Element{},
+	}
+}
+
+func entryBytes(key string, r *redis.Resp) int64 {
+	b, _ := r.Bytes()
+	return int64(len(key) + len(b))
+}
+
+func (c *lru) get(key string) (*redis.Resp, bool) {
+	el, ok := c.m[key]
+	if !ok {
+		return nil, false
+	}
+	c.l.MoveToFront(el)
+	return el.Value.(*lruEntry).r, true
+}
+
+func (c *lru) add(key string, r *redis.Resp) {
+	bytes := entryBytes(key, r)
+	if el, ok := c.m[key]; ok {
+		e := el.Value.(*lruEntry)
+		c.bytes += bytes - e.bytes
+		e.r, e.bytes = r, bytes
+		c.l.MoveToFront(el)
+	} else {
+		c.m[key] = c.l.PushFront(&lruEntry{key: key, r: r, bytes: bytes})
+		c.bytes += bytes
+	}
+
+	for c.l.Len() > c.size || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		c.removeEl(c.l.Back())
+	}
+}
+
+// remove returns whether or not the key was in the cache
+func (c *lru) remove(key string) bool {
+	el, ok := c.m[key]
+	if !ok {
+		return false
+	}
+	c.removeEl(el)
+	return true
+}
+
+func (c *lru) removeEl(el *list.Element) {
+	e := el.Value.(*lruEntry)
+	c.l.Remove(el)
+	delete(c.m, e.key)
+	c.bytes -= e.bytes
+}
+
+func (c *lru) clear() {
+	c.l.Init()
+	c.m = map[string]*list.Element{}
+	c.bytes = 0
+}
+
+func (c *lru) len() int {
+	return c.l.Len()
+}
@@ -0,0 +1,114 @@
+package cluster
+
+import (
+	"strings"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// getPool returns the pool for the given node, creating it if need be
+func (c *Cluster) getPool(addr string) (*pool.Pool, error) {
+	type resp struct {
+		p   *pool.Pool
+		err error
+	}
+	respCh := make(chan *resp)
+	c.callCh <- func(c *Cluster) {
+		p, ok := c.pools[addr]
+		if !ok {
+			var err error
+			if p, err = c.newPool(addr, false); err != nil {
+				respCh <- &resp{err: err}
+				return
+			}
+			c.pools[addr] = p
+		}
+		respCh <- &resp{p: p}
+	}
+	r := <-respCh
+	return r.p, r.err
+}
+
+// CmdBlocking is like Cmd, but for blocking commands like BLPOP, BRPOPLPUSH
+// or XREAD with BLOCK. The command is sent to the node for its key using that
+// node's pool's DoBlocking, so that it doesn't hold on to one of the pool's
+// usual connections and its reply isn't read with the usual timeout. block
+// should be the duration the command was told to block for, zero meaning
+// forever.
+//
+// MOVED and ASK errors are followed as they are by Cmd, up to MaxRedirects
+// times. Network errors aren't retried, since the command may have already
+// taken something off a list.
+func (c *Cluster) CmdBlocking(block time.Duration, cmd string, args ...interface{}) *redis.Resp {
+	return c.poolCmd(func(p *pool.Pool, fn func(*redis.Client)) error {
+		return p.DoBlocking(block, func(conn *redis.Client) error {
+			fn(conn)
+			return nil
+		})
+	}, cmd, args)
+}
+
+// poolCmd sends the command to the node for its key, using do to run fn on one
+// of that node's pool's connections, and follows MOVED and ASK errors as Cmd
+// does, up to MaxRedirects times. Network errors aren't retried. If do returns
+// an error that's returned as an IOErr.
+func (c *Cluster) poolCmd(
+	do func(p *pool.Pool, fn func(*redis.Client)) error, cmd string, args []interface{},
+) *redis.Resp {
+	if len(args) < 1 {
+		return errorResp(ErrBadCmdNoKey)
+	}
+
+	key, err := redis.KeyFromCmd(cmd, args...)
+	if err != nil {
+		return errorResp(err)
+	}
+
+	addr := c.GetAddrForKey(key)
+	if addr == "" {
+		return errorResp(errNoPools)
+	}
+
+	var ask bool
+	for redirects := 0; ; redirects++ {
+		p, err := c.getPool(addr)
+		if err != nil {
+			return errorResp(err)
+		}
+
+		var r *redis.Resp
+		err = do(p, func(conn *redis.Client) {
+			if ask {
+				if r = conn.Cmd("ASKING"); r.Err != nil {
+					return
+				}
+			}
+			r = conn.Cmd(cmd, args...)
+		})
+		if err != nil {
+			return redis.NewRespIOErr(err)
+		} else if r.Err == nil || !r.IsType(redis.AppErr) {
+			return r
+		}
+
+		msg := r.Err.Error()
+		moved := strings.HasPrefix(msg, "MOVED ")
+		ask = strings.HasPrefix(msg, "ASK ")
+		if !moved && !ask {
+			return r
+		}
+		slot, to, err := redirectInfo(msg, addr)
+		if err != nil {
+			return r
+		}
+
+		c.redirected(moved, slot, to)
+
+		if redirects >= c.o.MaxRedirects {
+			return errorRespf("Too many redirects (%d), last one was to %s", redirects, to)
+		}
+		addr = to
+	}
+}
@@ -0,0 +1,40 @@
+package cluster
+
+import (
+	"strings"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCmdBlocking(t *T) {
+	n1, n2 := newFakeNode(t), newFakeNode(t)
+	defer n1.Close()
+	defer n2.Close()
+	c := newFakeCluster(t, n1)
+	defer c.Close()
+
+	n1.set(n1.Addr().String(), redirect("ASK", "foo", n2.Addr().String()))
+	n2.set(n2.Addr().String(), func(args []string) interface{} {
+		if strings.ToUpper(args[0]) == "ASKING" {
+			return "OK"
+		}
+		return []string{"foo", "bar"}
+	})
+
+	l, err := c.CmdBlocking(time.Second, "BLPOP", "foo", 1).List()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"foo", "bar"}, l)
+	assert.Equal(t, 1, n1.count("BLPOP FOO 1"))
+	assert.Equal(t, 1, n2.count("ASKING"))
+	assert.Equal(t, 1, n2.count("BLPOP FOO 1"))
+
+	// both commands used the nodes' blocking connections
+	for _, addr := range []string{n1.Addr().String(), n2.Addr().String()} {
+		p, err := c.getPool(addr)
+		require.Nil(t, err)
+		assert.Equal(t, 1, p.Stats().BlockingIdle, addr)
+	}
+}
@@ -13,12 +13,16 @@
 package cluster
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/mediocregopher/radix.v2/log"
 	"github.com/mediocregopher/radix.v2/pool"
 	"github.com/mediocregopher/radix.v2/redis"
 )
@@ -27,6 +31,8 @@ const numSlots = 16384
 
 type mapping [numSlots]string
 
+var _ redis.Cmder = &Cluster{}
+
 func errorResp(err error) *redis.Resp {
 	return redis.NewResp(err)
 }
@@ -41,6 +47,7 @@ var (
 	ErrBadCmdNoKey = errors.New("bad command, no key")
 
 	errNoPools = errors.New("no pools to pull from")
+	errNoNodes = errors.New("no available nodes to call CLUSTER SLOTS on")
 )
 
 // DialFunc is a function which can be incorporated into Opts. Note that network
@@ -53,9 +60,28 @@ type Cluster struct {
 	mapping
 	pools         map[string]*pool.Pool
 	poolThrottles map[string]<-chan time.Time
+
+	// Only used if ReadFromReplicas is set. The replicas of each master, by
+	// address, the pools for them, and which replica is to be used next.
+	replicas     map[string][]string
+	replicaPools map[string]*pool.Pool
+	replicaNext  int
+	readOnlyCmds map[string]bool
+
 	resetThrottle *time.Ticker
 	callCh        chan func(*Cluster)
 	stopCh        chan struct{}
+	logger        log.Logger
+
+	// rttL protects rtts, which is written by the prober
+	rttL sync.Mutex
+	rtts map[string]nodeRTT
+
+	// closed once the prober has stopped, nil if it was never started
+	probeDone chan struct{}
+
+	// the topology found by the last reset, nil before the first
+	topo *topology
 
 	// This is written to whenever a slot miss (either a MOVED or ASK) is
 	// encountered. This is mainly for informational purposes, it's not meant to
@@ -92,10 +118,91 @@ type Opts struct {
 	// default is 500 milliseconds
 	ResetThrottle time.Duration
 
+	// The most MOVED or ASK redirects which are followed for a single command,
+	// after which an error is returned, so that a misconfigured cluster can't
+	// send a command round in circles forever. The default is 3
+	MaxRedirects int
+
 	// The function which will be used to create connections within the pool for
 	// each redis cluster instance. The common use-case is to do authentication
-	// for new connections. Defaults to using redis.DialTimeout if not set.
+	// for new connections. Defaults to using redis.DialCtx if not set, in which
+	// case connections which are being made when the Cluster is closed are
+	// cancelled.
 	Dialer DialFunc
+
+	// If set this is called whenever connections to a node are going to be
+	// made, whether that's for a node found by CLUSTER SLOTS or one which a
+	// MOVED or ASK redirected to, and the DialOpts it returns are used for
+	// them. This allows nodes to have different passwords or TLS settings. If
+	// the returned DialOpts has no Timeout then the Timeout above is used.
+	//
+	// If it returns an error then no connections are made to that node, and it
+	// is throttled the same as a node which couldn't be connected to. This is
+	// ignored if Dialer is set.
+	NodeDialOpts func(addr string) (redis.DialOpts, error)
+
+	// Called on every connection made to a node, after those in the DialOpts
+	// returned by NodeDialOpts, see redis.DialOpts' OnConnect. This is
+	// ignored if Dialer is set.
+	OnConnect []redis.ConnFunc
+
+	// If set, commands which only read are sent to a replica of the master
+	// for their key's slot, with connections to replicas being put into
+	// READONLY mode when they're made. Each command goes to the next of the
+	// master's replicas in turn, or if ProbeInterval is set to the one with
+	// the lowest RTT. The command is sent to the master as usual instead if
+	// the master has no replicas, the replica can't be reached, or the replica
+	// redirects it.
+	//
+	// Replication is asynchronous, so a replica may not have a write which was
+	// just made on its master.
+	ReadFromReplicas bool
+
+	// The commands which ReadFromReplicas sends to replicas. Defaults to
+	// DefaultReadOnlyCommands. Commands which are read-only but which can't
+	// be known to be from their name, such as EVALSHA of a script which only
+	// reads, can be added here.
+	ReadOnlyCommands []string
+
+	// If set every node is PINGed this often, over a connection which is
+	// separate from the node's pool, and the round trip time is tracked as a
+	// moving average in the node's NodeStats. A node which fails its PING is
+	// throttled the same as one which couldn't be connected to. If zero no
+	// probing is done.
+	ProbeInterval time.Duration
+
+	// Used to log failures which happen in the background or which are
+	// otherwise recovered from, such as the topology failing to be refreshed
+	// or a node failing its probe. Entries have the component "cluster". It's
+	// also passed on to the pool for each node. Defaults to log.Nop.
+	Logger log.Logger
+
+	// Passed on to the pool for each node, including replicas, see pool.Opts.
+	// The connections used for probing and for refreshing the topology
+	// aren't traced.
+	Trace *redis.Trace
+
+	// If set this is called with every change to the cluster's topology which
+	// is found when it's refreshed, after the first time, e.g. when slots are
+	// moved or a replica is failed over to. See Event. The topology is
+	// refreshed after a MOVED error, after a node can't be reached, and when
+	// Reset or Sync is called. Events are also logged to Logger at Info.
+	//
+	// It's called from the go-routine which all of the Cluster's state is
+	// managed in, so it mustn't call any of the Cluster's methods, and should
+	// return quickly.
+	OnEvent func(Event)
+}
+
+// NodeDialOptsError is returned when the NodeDialOpts function in Opts
+// returned an error for a node
+type NodeDialOptsError struct {
+	Addr string
+	Err  error
+}
+
+func (e *NodeDialOptsError) Error() string {
+	return fmt.Sprintf("NodeDialOpts(%s): %s", e.Addr, e.Err)
 }
 
 // New will perform the following steps to initialize:
@@ -129,10 +236,11 @@ func NewWithOpts(o Opts) (*Cluster, error) {
 	if o.ResetThrottle == 0 {
 		o.ResetThrottle = 500 * time.Millisecond
 	}
-	if o.Dialer == nil {
-		o.Dialer = func(_, addr string) (*redis.Client, error) {
-			return redis.DialTimeout("tcp", addr, o.Timeout)
-		}
+	if o.MaxRedirects == 0 {
+		o.MaxRedirects = 3
+	}
+	if o.ReadOnlyCommands == nil {
+		o.ReadOnlyCommands = DefaultReadOnlyCommands
 	}
 
 	c := Cluster{
@@ -142,10 +250,19 @@ func NewWithOpts(o Opts) (*Cluster, error) {
 		poolThrottles: map[string]<-chan time.Time{},
 		callCh:        make(chan func(*Cluster)),
 		stopCh:        make(chan struct{}),
+		logger:        log.With(o.Logger, log.KV{log.KeyComponent: "cluster"}),
+		rtts:          map[string]nodeRTT{},
+		replicas:      map[string][]string{},
+		replicaPools:  map[string]*pool.Pool{},
+		readOnlyCmds:  map[string]bool{},
 		MissCh:        make(chan struct{}),
 		ChangeCh:      make(chan struct{}),
 	}
 
+	for _, cmd := range o.ReadOnlyCommands {
+		c.readOnlyCmds[strings.ToUpper(cmd)] = true
+	}
+
 	initialPool, err := c.newPool(o.Addr, true)
 	if err != nil {
 		return nil, err
@@ -156,6 +273,10 @@ func NewWithOpts(o Opts) (*Cluster, error) {
 	if err := c.Reset(); err != nil {
 		return nil, err
 	}
+	if o.ProbeInterval > 0 {
+		c.probeDone = make(chan struct{})
+		go c.probeSpin()
+	}
 	return &c, nil
 }
 
@@ -171,10 +292,19 @@ func (c *Cluster) newPool(addr string, clearThrottle bool) (*pool.Pool, error) {
 		}
 	}
 
-	df := func(network, addr string) (*redis.Client, error) {
-		return c.o.Dialer(network, addr)
+	df, err := c.nodeDialer(addr, c.o.Timeout)
+	if err != nil {
+		c.poolThrottles[addr] = time.After(c.o.PoolThrottle)
+		return nil, err
 	}
-	p, err := pool.NewCustom("tcp", addr, c.o.PoolSize, df)
+	p, err := pool.NewWithOpts(pool.Opts{
+		Network: "tcp",
+		Addr:    addr,
+		Size:    c.o.PoolSize,
+		Dial:    df,
+		Logger:  c.o.Logger,
+		Trace:   c.o.Trace,
+	})
 	if err != nil {
 		c.poolThrottles[addr] = time.After(c.o.PoolThrottle)
 		return nil, err
@@ -182,6 +312,34 @@ func (c *Cluster) newPool(addr string, clearThrottle bool) (*pool.Pool, error) {
 	return p, err
 }
 
+// nodeDialer returns the function used to make connections to the given node,
+// based on the Dialer and NodeDialOpts options. The timeout is used if no
+// other is given.
+func (c *Cluster) nodeDialer(addr string, timeout time.Duration) (pool.DialCtxFunc, error) {
+	if c.o.Dialer != nil {
+		return func(_ context.Context, network, addr string) (*redis.Client, error) {
+			return c.o.Dialer(network, addr)
+		}, nil
+	}
+
+	do := redis.DialOpts{Timeout: timeout}
+	if c.o.NodeDialOpts != nil {
+		var err error
+		if do, err = c.o.NodeDialOpts(addr); err != nil {
+			return nil, &NodeDialOptsError{Addr: addr, Err: err}
+		}
+		if do.Timeout == 0 {
+			do.Timeout = timeout
+		}
+	}
+	if len(c.o.OnConnect) > 0 {
+		do.OnConnect = append(append([]redis.ConnFunc(nil), do.OnConnect...), c.o.OnConnect...)
+	}
+	return func(ctx context.Context, network, addr string) (*redis.Client, error) {
+		return redis.DialCtx(ctx, network, addr, do)
+	}, nil
+}
+
 // Anything which requires creating/deleting pools must be done in here
 func (c *Cluster) spin() {
 	for {
@@ -244,11 +402,40 @@ func (c *Cluster) getConn(key, addr string) (*redis.Client, error) {
 	return r.conn, r.err
 }
 
+// getNodeConn returns a connection from the pool for the given node, creating
+// the pool if need be. Unlike getConn no other node is tried if that fails,
+// since this is used when a node has told us exactly where a command should go.
+func (c *Cluster) getNodeConn(addr string) (*redis.Client, error) {
+	type resp struct {
+		conn *redis.Client
+		err  error
+	}
+	respCh := make(chan *resp)
+	c.callCh <- func(c *Cluster) {
+		p, ok := c.pools[addr]
+		if !ok {
+			var err error
+			if p, err = c.newPool(addr, false); err != nil {
+				respCh <- &resp{err: err}
+				return
+			}
+			c.pools[addr] = p
+		}
+		conn, err := p.Get()
+		respCh <- &resp{conn, err}
+	}
+	r := <-respCh
+	return r.conn, r.err
+}
+
 // Put putss the connection back in its pool. To be used alongside any of the
 // Get* methods once use of the redis.Client is done
 func (c *Cluster) Put(conn *redis.Client) {
 	c.callCh <- func(c *Cluster) {
 		p := c.pools[conn.Addr]
+		if p == nil {
+			p = c.replicaPools[conn.Addr]
+		}
 		if p == nil {
 			conn.Close()
 			return
@@ -276,11 +463,53 @@ func (c *Cluster) getRandomPoolInner() *pool.Pool {
 func (c *Cluster) Reset() error {
 	respCh := make(chan error)
 	c.callCh <- func(c *Cluster) {
-		respCh <- c.resetInner()
+		respCh <- c.reset()
 	}
 	return <-respCh
 }
 
+// Sync is like Reset, but isn't throttled, so the topology is always
+// refreshed before it returns
+func (c *Cluster) Sync() error {
+	respCh := make(chan error)
+	c.callCh <- func(c *Cluster) {
+		p := c.getRandomPoolInner()
+		if p == nil {
+			respCh <- c.logReset(errNoNodes)
+			return
+		}
+		respCh <- c.logReset(c.resetInnerUsingPool(p))
+	}
+	return <-respCh
+}
+
+// resetBackground is like Reset, but doesn't wait for the reset to happen. If
+// the Cluster is closed first the reset doesn't happen at all.
+func (c *Cluster) resetBackground() {
+	go func() {
+		select {
+		case c.callCh <- func(c *Cluster) { c.reset() }:
+		case <-c.stopCh:
+		}
+	}()
+}
+
+// reset is called in spin by Reset and resetBackground
+func (c *Cluster) reset() error {
+	return c.logReset(c.resetInner())
+}
+
+// logReset logs the error from refreshing the topology, if any, and returns it
+func (c *Cluster) logReset(err error) error {
+	if err != nil {
+		c.logger.Log(log.Warn, "topology refresh failed", log.KV{
+			log.KeyOperation: "reset",
+			log.KeyErr:       err,
+		})
+	}
+	return err
+}
+
 func (c *Cluster) resetInner() error {
 	// Throttle resetting so a bunch of routines can call Reset at once and the
 	// server won't be spammed. We don't a throttle until the second Reset is
@@ -297,7 +526,7 @@ func (c *Cluster) resetInner() error {
 
 	p := c.getRandomPoolInner()
 	if p == nil {
-		return fmt.Errorf("no available nodes to call CLUSTER SLOTS on")
+		return errNoNodes
 	}
 
 	return c.resetInnerUsingPool(p)
@@ -316,6 +545,8 @@ func (c *Cluster) resetInnerUsingPool(p *pool.Pool) error {
 	defer p.Put(client)
 
 	pools := map[string]*pool.Pool{}
+	replicas := map[string][]string{}
+	roles := map[string]string{}
 
 	elems, err := client.Cmd("CLUSTER", "SLOTS").Array()
 	if err != nil {
@@ -361,11 +592,33 @@ func (c *Cluster) resetInnerUsingPool(p *pool.Pool) error {
 		for i := start; i <= end; i++ {
 			c.mapping[i] = slotAddr
 		}
+		// the rest of the slot group's elements are its replicas
+		slotReplicaAddrs, err := slotReplicas(slotElems[3:], p.Addr)
+		if err != nil {
+			return err
+		}
+		roles[slotAddr] = RoleMaster
+		for _, addr := range slotReplicaAddrs {
+			roles[addr] = RoleReplica
+		}
+		if c.o.ReadFromReplicas {
+			replicas[slotAddr] = slotReplicaAddrs
+		}
 		if slotPool, ok = c.pools[slotAddr]; ok {
 			pools[slotAddr] = slotPool
 		} else {
 			slotPool, err = c.newPool(slotAddr, true)
-			if err != nil {
+			if _, ok := err.(*NodeDialOptsError); ok {
+				// The node is left without a pool, commands for its slots
+				// will go to a random node until NodeDialOpts stops
+				// returning an error for it
+				c.logger.Log(log.Warn, "skipped node", log.KV{
+					log.KeyOperation: "reset",
+					log.KeyAddr:      slotAddr,
+					log.KeyErr:       err,
+				})
+				continue
+			} else if err != nil {
 				return err
 			}
 			changed = true
@@ -375,12 +628,16 @@ func (c *Cluster) resetInnerUsingPool(p *pool.Pool) error {
 
 	for addr := range c.pools {
 		if _, ok := pools[addr]; !ok {
-			c.pools[addr].Empty()
+			c.pools[addr].Close()
 			delete(c.poolThrottles, addr)
 			changed = true
 		}
 	}
 	c.pools = pools
+	if c.o.ReadFromReplicas && c.setReplicas(replicas) {
+		changed = true
+	}
+	c.changed(&topology{slots: c.mapping, roles: roles})
 
 	if changed {
 		select {
@@ -396,13 +653,12 @@ func (c *Cluster) resetInnerUsingPool(p *pool.Pool) error {
 // * Get client for command's slot, try it
 // * If err == nil, return reply
 // * If err is a client error:
+// 		* If MOVED or ASK and we've already followed MaxRedirects, error out
 // 		* If MOVED:
-//			* If node not tried before, go to top with that node
-//			* Otherwise if we haven't Reset, do that and go to top with random
-//			  node
-//			* Otherwise error out
+//			* Point the slot at the node given, Reset in the background, and go
+//			  to top with that node
 //		* If ASK (same as MOVED, but call ASKING beforehand and don't modify
-//		  slots)
+//		  slots or Reset)
 // 		* Otherwise return the error
 // * Otherwise it is a network error
 //		* If we haven't reconnected to this node yet, do that and go to top
@@ -412,24 +668,32 @@ func (c *Cluster) resetInnerUsingPool(p *pool.Pool) error {
 
 // Cmd performs the given command on the correct cluster node and gives back the
 // command's reply. The command *must* have a key parameter (i.e. len(args) >=
-// 1). If any MOVED or ASK errors are returned they will be transparently
-// handled by this method.
+// 1). The key is found using redis.KeyFromCmd, so commands like EVAL are sent
+// to the node for their first key rather than their first argument. If any
+// MOVED or ASK errors are returned they will be transparently handled by this
+// method, up to MaxRedirects times.
 func (c *Cluster) Cmd(cmd string, args ...interface{}) *redis.Resp {
 	if len(args) < 1 {
 		return errorResp(ErrBadCmdNoKey)
 	}
 
-	key, err := redis.KeyFromArgs(args)
+	key, err := redis.KeyFromCmd(cmd, args...)
 	if err != nil {
 		return errorResp(err)
 	}
 
+	if c.o.ReadFromReplicas && c.readOnlyCmds[strings.ToUpper(cmd)] {
+		if r, ok := c.replicaCmd(key, cmd, args); ok {
+			return r
+		}
+	}
+
 	client, err := c.getConn(key, "")
 	if err != nil {
 		return errorResp(err)
 	}
 
-	return c.clientCmd(client, cmd, args, false, nil, false)
+	return c.clientCmd(client, cmd, args, false, nil, false, 0)
 }
 
 func haveTried(tried map[string]bool, addr string) bool {
@@ -449,7 +713,7 @@ func justTried(tried map[string]bool, addr string) map[string]bool {
 
 func (c *Cluster) clientCmd(
 	client *redis.Client, cmd string, args []interface{}, ask bool,
-	tried map[string]bool, haveReset bool,
+	tried map[string]bool, haveReset bool, redirects int,
 ) *redis.Resp {
 	var err error
 	var r *redis.Resp
@@ -483,7 +747,7 @@ func (c *Cluster) clientCmd(
 		// If this is the first time trying this node, try it again
 		if !haveTriedBefore {
 			if client, try2err := c.getConn("", client.Addr); try2err == nil {
-				return c.clientCmd(client, cmd, args, false, tried, haveReset)
+				return c.clientCmd(client, cmd, args, false, tried, haveReset, redirects)
 			}
 		}
 		// Otherwise try calling Reset() and getting a random client
@@ -495,7 +759,7 @@ func (c *Cluster) clientCmd(
 			if getErr != nil {
 				return errorResp(getErr)
 			}
-			return c.clientCmd(client, cmd, args, false, tried, true)
+			return c.clientCmd(client, cmd, args, false, tried, true, redirects)
 		}
 		// Otherwise give up and return the most recent error
 		return r
@@ -505,64 +769,95 @@ func (c *Cluster) clientCmd(
 	msg := err.Error()
 	moved := strings.HasPrefix(msg, "MOVED ")
 	ask = strings.HasPrefix(msg, "ASK ")
-	if moved || ask {
-		_, addr := redirectInfo(msg)
-		c.callCh <- func(c *Cluster) {
-			select {
-			case c.MissCh <- struct{}{}:
-			default:
-			}
-		}
+	if !moved && !ask {
+		// It's a normal application error (like WRONG KEY TYPE or whatever),
+		// return that to the client
+		return r
+	}
+	slot, addr, err := redirectInfo(msg, client.Addr)
+	if err != nil {
+		return r
+	}
 
-		// If we've already called Reset and we're getting MOVED again than the
-		// cluster is having problems, likely telling us to try a node which is
-		// not reachable. Not much which can be done at this point
-		if haveReset {
-			return errorRespf("Cluster doesn't make sense, %s might be gone", addr)
-		}
-		if resetErr := c.Reset(); resetErr != nil {
-			return errorRespf("Could not get cluster info: %s", resetErr)
-		}
-		haveReset = true
+	c.redirected(moved, slot, addr)
 
-		// At this point addr is whatever redis told us it should be. However,
-		// if we can't get a connection to it we'll never actually mark it as
-		// tried, resulting in an infinite loop. Here we mark it as tried
-		// regardless of if it actually was or not
-		tried = justTried(tried, addr)
+	if redirects >= c.o.MaxRedirects {
+		return errorRespf("Too many redirects (%d), last one was to %s", redirects, addr)
+	}
 
-		client, getErr := c.getConn("", addr)
-		if getErr != nil {
-			return errorResp(getErr)
-		}
-		return c.clientCmd(client, cmd, args, ask, tried, haveReset)
+	// The command must go to the node redis told us about, so if a connection
+	// to it can't be gotten that's the error, rather than trying a random node
+	client, getErr := c.getNodeConn(addr)
+	if getErr != nil {
+		return errorResp(getErr)
 	}
+	return c.clientCmd(client, cmd, args, ask, tried, haveReset, redirects+1)
+}
 
-	// It's a normal application error (like WRONG KEY TYPE or whatever), return
-	// that to the client
-	return r
+// redirected is called whenever a command gets a MOVED or ASK error
+func (c *Cluster) redirected(moved bool, slot int, addr string) {
+	c.callCh <- func(c *Cluster) {
+		// A MOVED means the slot belongs to the given node from now on, so
+		// later commands for it can go straight there without waiting for the
+		// Reset
+		if moved {
+			c.mapping[slot] = addr
+		}
+		select {
+		case c.MissCh <- struct{}{}:
+		default:
+		}
+	}
+	if moved {
+		// Other slots have likely moved along with this one
+		c.resetBackground()
+	}
 }
 
-func redirectInfo(msg string) (int, string) {
+// redirectInfo parses the slot and address out of a MOVED or ASK error. Newer
+// versions of redis leave the host out of the address if it's the same as the
+// node's which sent the error, in which case from's host is used.
+func redirectInfo(msg, from string) (int, string, error) {
 	parts := strings.Split(msg, " ")
-	slotStr := parts[1]
-	slot, err := strconv.Atoi(slotStr)
-	if err != nil {
-		// if redis is returning bad integers, we have problems
-		panic(err)
+	if len(parts) != 3 {
+		return 0, "", fmt.Errorf("malformed redirect %q", msg)
+	}
+	slot, err := strconv.Atoi(parts[1])
+	if err != nil || slot < 0 || slot >= numSlots {
+		return 0, "", fmt.Errorf("malformed redirect %q", msg)
 	}
 	addr := parts[2]
-	return slot, addr
+	if strings.HasPrefix(addr, ":") {
+		host, _, err := net.SplitHostPort(from)
+		if err != nil {
+			return 0, "", err
+		}
+		addr = net.JoinHostPort(host, addr[1:])
+	}
+	return slot, addr, nil
 }
 
 func keyToAddr(key string, mapping *mapping) string {
+	return mapping[Slot(key)]
+}
+
+// HashTag returns the part of the key which is used to determine its slot.
+// This is the part within the first pair of curly braces, if there's anything
+// between them, or the whole key otherwise.
+func HashTag(key string) string {
 	if start := strings.Index(key, "{"); start >= 0 {
-		if end := strings.Index(key[start+2:], "}"); end >= 0 {
-			key = key[start+1 : start+2+end]
+		// like redis, only the first closing brace after it counts, so an
+		// empty tag means the whole key is used even if another follows
+		if end := strings.Index(key[start+1:], "}"); end > 0 {
+			return key[start+1 : start+1+end]
 		}
 	}
-	i := CRC16([]byte(key)) % numSlots
-	return mapping[i]
+	return key
+}
+
+// Slot returns the slot the given key belongs to
+func Slot(key string) uint16 {
+	return CRC16([]byte(HashTag(key))) % numSlots
 }
 
 // GetForKey returns the Client which *ought* to handle the given key, based
@@ -620,12 +915,19 @@ func (c *Cluster) GetAddrForKey(key string) string {
 func (c *Cluster) Close() {
 	c.callCh <- func(c *Cluster) {
 		for addr, p := range c.pools {
-			p.Empty()
+			p.Close()
 			delete(c.pools, addr)
 		}
+		for addr, p := range c.replicaPools {
+			p.Close()
+			delete(c.replicaPools, addr)
+		}
 		if c.resetThrottle != nil {
 			c.resetThrottle.Stop()
 		}
 	}
 	close(c.stopCh)
+	if c.probeDone != nil {
+		<-c.probeDone
+	}
 }
@@ -3,11 +3,15 @@ package cluster
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"strings"
 	. "testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	"github.com/mediocregopher/radix.v2/log"
 	"github.com/mediocregopher/radix.v2/pool"
 	"github.com/mediocregopher/radix.v2/redis"
 )
@@ -121,7 +125,7 @@ func TestCmdMiss(t *T) {
 	assert.Nil(t, err)
 
 	args := []interface{}{key}
-	r := cluster.clientCmd(client, "GET", args, false, nil, false)
+	r := cluster.clientCmd(client, "GET", args, false, nil, false, 0)
 	s, err := r.Str()
 	assert.Nil(t, err)
 	assert.Equal(t, "baz", s)
@@ -171,3 +175,93 @@ func TestCmdAsk(t *T) {
 	assert.Nil(t, dst.Cmd("CLUSTER", "SETSLOT", slot, "NODE", srcID).Err)
 	assert.Nil(t, src.Cmd("CLUSTER", "SETSLOT", slot, "NODE", srcID).Err)
 }
+
+func TestNodeDialOpts(t *T) {
+	var calls []string
+	c, err := NewWithOpts(Opts{
+		Addr: addr1,
+		NodeDialOpts: func(addr string) (redis.DialOpts, error) {
+			calls = append(calls, addr)
+			return redis.DialOpts{}, nil
+		},
+	})
+	require.Nil(t, err)
+	defer c.Close()
+	assert.Contains(t, calls, addr1)
+	assert.Contains(t, calls, addr2)
+
+	k := keyForNode(c, addr2)
+	assert.Nil(t, c.Cmd("SET", k, "foo").Err)
+}
+
+func TestNodeDialOptsError(t *T) {
+	errBad := errors.New("no credentials")
+	c := Cluster{
+		o: Opts{
+			PoolThrottle: time.Minute,
+			NodeDialOpts: func(addr string) (redis.DialOpts, error) {
+				return redis.DialOpts{}, errBad
+			},
+		},
+		poolThrottles: map[string]<-chan time.Time{},
+	}
+
+	_, err := c.newPool("127.0.0.1:1", true)
+	nerr, ok := err.(*NodeDialOptsError)
+	require.True(t, ok)
+	assert.Equal(t, "127.0.0.1:1", nerr.Addr)
+	assert.Equal(t, errBad, nerr.Err)
+
+	// the node is throttled, the same as if it couldn't be connected to
+	assert.Contains(t, c.poolThrottles, "127.0.0.1:1")
+	_, err = c.newPool("127.0.0.1:1", false)
+	assert.Contains(t, err.Error(), "throttled")
+}
+
+func TestResetLogger(t *T) {
+	rec := new(log.Recorder)
+	c := Cluster{
+		o:      Opts{ResetThrottle: time.Minute},
+		pools:  map[string]*pool.Pool{},
+		callCh: make(chan func(*Cluster)),
+		stopCh: make(chan struct{}),
+		logger: log.With(rec, log.KV{log.KeyComponent: "cluster"}),
+	}
+	go c.spin()
+	defer close(c.stopCh)
+
+	// there are no pools to call CLUSTER SLOTS on
+	err := c.Reset()
+	require.NotNil(t, err)
+
+	ee := rec.Entries("")
+	require.Len(t, ee, 1)
+	assert.Equal(t, "topology refresh failed", ee[0].Msg)
+	assert.Equal(t, log.Warn, ee[0].Level)
+	assert.Equal(t, log.KV{
+		log.KeyComponent: "cluster",
+		log.KeyOperation: "reset",
+		log.KeyErr:       err,
+	}, ee[0].KV)
+}
+
+func TestSlot(t *T) {
+	assert.Equal(t, "user", HashTag("{user}:ids"))
+	assert.Equal(t, "{}:ids", HashTag("{}:ids"))
+	assert.Equal(t, "ids", HashTag("ids"))
+	assert.Equal(t, Slot("user"), Slot("{user}:ids"))
+	assert.Equal(t, uint16(12182), Slot("foo"))
+
+	// a tag has to be closed and non-empty, and only the first one counts
+	for _, tc := range []struct{ key, tag string }{
+		{"{", "{"},
+		{"foo{", "foo{"},
+		{"{}", "{}"},
+		{"{}{a}", "{}{a}"},
+		{"a{b}{c}", "b"},
+		{"a{{b}}", "{b"},
+	} {
+		assert.Equal(t, tc.tag, HashTag(tc.key), "key %q", tc.key)
+		assert.Equal(t, CRC16([]byte(tc.tag))%numSlots, Slot(tc.key), "key %q", tc.key)
+	}
+}
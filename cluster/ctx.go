@@ -0,0 +1,22 @@
+package cluster
+
+import (
+	"context"
+
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// CmdCtx is like Cmd, but the command is sent using the node's pool's DoCtx,
+// so that it's given up on if the context is done before its reply is read.
+// When that happens the returned Resp is an IOErr whose Err is the context's
+// error, and the connection is closed rather than being put back in the pool.
+//
+// MOVED and ASK errors are followed as they are by Cmd, up to MaxRedirects
+// times, for as long as the context isn't done. Unlike Cmd network errors
+// aren't retried, and the command is never sent to a replica.
+func (c *Cluster) CmdCtx(ctx context.Context, cmd string, args ...interface{}) *redis.Resp {
+	return c.poolCmd(func(p *pool.Pool, fn func(*redis.Client)) error {
+		return p.DoCtx(ctx, fn)
+	}, cmd, args)
+}
@@ -0,0 +1,52 @@
+package cluster
+
+import (
+	"context"
+	"strings"
+	. "testing"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCmdCtx(t *T) {
+	n1, n2 := newFakeNode(t), newFakeNode(t)
+	defer n1.Close()
+	defer n2.Close()
+	c := newFakeCluster(t, n1)
+	defer c.Close()
+
+	n1.set(n1.Addr().String(), redirect("ASK", "foo", n2.Addr().String()))
+	n2.set(n2.Addr().String(), func(args []string) interface{} {
+		switch strings.ToUpper(args[0]) {
+		case "ASKING":
+			return "OK"
+		case "SLOW":
+			time.Sleep(200 * time.Millisecond)
+		}
+		return "bar"
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s, err := c.CmdCtx(ctx, "GET", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "bar", s)
+	assert.Equal(t, 1, n1.count("GET FOO"))
+	assert.Equal(t, 1, n2.count("GET FOO"))
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	r := c.CmdCtx(ctx, "SLOW", "foo")
+	assert.True(t, time.Since(start) < 200*time.Millisecond)
+	assert.Equal(t, context.DeadlineExceeded, r.Err)
+	assert.True(t, r.IsType(redis.IOErr))
+
+	// the interrupted connection wasn't put back in n2's pool
+	p, err := c.getPool(n2.Addr().String())
+	require.Nil(t, err)
+	assert.Equal(t, 0, p.Avail())
+}
@@ -0,0 +1,155 @@
+package cluster
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/mediocregopher/radix.v2/log"
+)
+
+// EventType describes what kind of change to the cluster's topology an Event
+// is about
+type EventType int
+
+// The EventTypes which an Event may have
+const (
+	// A node which wasn't in the cluster before now is, as a master or a
+	// replica
+	NodeAdded EventType = iota
+
+	// A node which was in the cluster isn't any longer
+	NodeRemoved
+
+	// A range of slots is now owned by a different master
+	SlotsMoved
+
+	// A node which was a replica is now a master, or the other way around,
+	// e.g. after a failover
+	RoleChanged
+)
+
+func (t EventType) String() string {
+	switch t {
+	case NodeAdded:
+		return "node added"
+	case NodeRemoved:
+		return "node removed"
+	case SlotsMoved:
+		return "slots moved"
+	case RoleChanged:
+		return "role changed"
+	default:
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+}
+
+// The roles a node may have in an Event
+const (
+	RoleMaster  = "master"
+	RoleReplica = "replica"
+)
+
+// Event describes a single change to the cluster's topology, which was found
+// by comparing the results of successive CLUSTER SLOTS calls. See Opts'
+// OnEvent.
+type Event struct {
+	Type EventType
+
+	// For NodeAdded, NodeRemoved and RoleChanged, the node's address. For
+	// SlotsMoved, the address of the master which now owns the slots.
+	Addr string
+
+	// For NodeAdded and RoleChanged the node's role now, and for NodeRemoved
+	// its role before it was removed. Either RoleMaster or RoleReplica.
+	Role string
+
+	// For SlotsMoved, the first and last slot of the range which was moved,
+	// and the address of the master which owned it before
+	Start, End uint16
+	From       string
+}
+
+func (e Event) String() string {
+	if e.Type == SlotsMoved {
+		return fmt.Sprintf("slots %d-%d moved from %s to %s", e.Start, e.End, e.From, e.Addr)
+	}
+	return fmt.Sprintf("%s: %s (%s)", e.Type, e.Addr, e.Role)
+}
+
+// topology is what's compared between CLUSTER SLOTS calls to make Events
+type topology struct {
+	slots mapping
+	roles map[string]string
+}
+
+func sortedAddrs(roles map[string]string) []string {
+	addrs := make([]string, 0, len(roles))
+	for addr := range roles {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// events returns the Events describing the change from one topology to the
+// next. Slots which aren't owned by anything in next are left out, they keep
+// pointing at their old owner.
+func (prev *topology) events(next *topology) []Event {
+	var events []Event
+	for _, addr := range sortedAddrs(next.roles) {
+		role := next.roles[addr]
+		if prevRole, ok := prev.roles[addr]; !ok {
+			events = append(events, Event{Type: NodeAdded, Addr: addr, Role: role})
+		} else if prevRole != role {
+			events = append(events, Event{Type: RoleChanged, Addr: addr, Role: role})
+		}
+	}
+	for _, addr := range sortedAddrs(prev.roles) {
+		if _, ok := next.roles[addr]; !ok {
+			events = append(events, Event{Type: NodeRemoved, Addr: addr, Role: prev.roles[addr]})
+		}
+	}
+
+	// consecutive slots which moved between the same two masters are one
+	// Event
+	moved := -1 // index of the SlotsMoved Event for the slot before
+	for i := 0; i < numSlots; i++ {
+		from, to := prev.slots[i], next.slots[i]
+		if from == to || to == "" {
+			moved = -1
+			continue
+		}
+		if moved >= 0 && events[moved].From == from && events[moved].Addr == to {
+			events[moved].End = uint16(i)
+			continue
+		}
+		events = append(events, Event{
+			Type:  SlotsMoved,
+			Addr:  to,
+			Start: uint16(i),
+			End:   uint16(i),
+			From:  from,
+		})
+		moved = len(events) - 1
+	}
+	return events
+}
+
+// changed is called by reset with the topology it found. If there was one
+// before then every difference from it is logged and passed to OnEvent.
+func (c *Cluster) changed(next *topology) {
+	prev := c.topo
+	c.topo = next
+	if prev == nil {
+		return
+	}
+	for _, e := range prev.events(next) {
+		c.logger.Log(log.Info, e.String(), log.KV{
+			log.KeyOperation: "reset",
+			log.KeyAddr:      e.Addr,
+		})
+		if c.o.OnEvent != nil {
+			c.o.OnEvent(e)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package cluster
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopologyEvents(t *T) {
+	prev := &topology{roles: map[string]string{
+		"a": RoleMaster, "b": RoleMaster, "c": RoleReplica,
+	}}
+	next := &topology{roles: map[string]string{
+		"b": RoleMaster, "c": RoleMaster, "d": RoleReplica,
+	}}
+	for i := 0; i < numSlots; i++ {
+		prev.slots[i] = "b"
+		next.slots[i] = "b"
+	}
+	for i := 0; i < 100; i++ {
+		prev.slots[i] = "a"
+		next.slots[i] = "c"
+	}
+	next.slots[200] = "c"
+
+	assert.Equal(t, []Event{
+		{Type: RoleChanged, Addr: "c", Role: RoleMaster},
+		{Type: NodeAdded, Addr: "d", Role: RoleReplica},
+		{Type: NodeRemoved, Addr: "a", Role: RoleMaster},
+		{Type: SlotsMoved, Addr: "c", Start: 0, End: 99, From: "a"},
+		{Type: SlotsMoved, Addr: "c", Start: 200, End: 200, From: "b"},
+	}, prev.events(next))
+	assert.Empty(t, next.events(next))
+}
+
+func TestOnEvent(t *T) {
+	n1, n2 := newFakeNode(t), newFakeNode(t)
+	defer n1.Close()
+	defer n2.Close()
+
+	var events []Event
+	c, err := NewWithOpts(Opts{
+		Addr:     n1.Addr().String(),
+		PoolSize: 1,
+		OnEvent:  func(e Event) { events = append(events, e) },
+	})
+	require.Nil(t, err)
+	defer c.Close()
+	assert.Empty(t, events)
+
+	// n2 takes over as master, as if n1 had failed over to it
+	n1.l.Lock()
+	n1.owner, n1.replicas = n2.Addr().String(), []string{n1.Addr().String()}
+	n1.l.Unlock()
+	require.Nil(t, c.Sync())
+
+	addr1, addr2 := n1.Addr().String(), n2.Addr().String()
+	require.Len(t, events, 3)
+	assert.Contains(t, events, Event{Type: RoleChanged, Addr: addr1, Role: RoleReplica})
+	assert.Contains(t, events, Event{Type: NodeAdded, Addr: addr2, Role: RoleMaster})
+	assert.Equal(t, Event{
+		Type: SlotsMoved, Addr: addr2, Start: 0, End: numSlots - 1, From: addr1,
+	}, events[2])
+	assert.Equal(t, addr2, c.GetAddrForKey("foo"))
+}
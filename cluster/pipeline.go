@@ -0,0 +1,197 @@
+package cluster
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// Pipeline is like redis.Pipeline, but for commands on keys which may belong
+// to any node in the cluster. Commands are buffered until Flush, at which
+// point they're split up by the node their key's slot belongs to, and each
+// node is sent its commands as a single pipeline, all of the nodes at once.
+// Resp returns the responses in the order the commands were appended,
+// whichever node they came from.
+//
+// Each command gets its own response, so one failing doesn't affect the rest.
+// A command which gets a MOVED or ASK error is sent again to the node it was
+// redirected to, along with any others redirected there, but only once; if it's
+// redirected again that error is its response. Commands which get an IOErr
+// aren't retried, since they may have been run.
+//
+// All of a Pipeline's commands must have a key. Like redis.Pipeline, Pipeline
+// isn't safe to use from multiple go-routines at once.
+type Pipeline struct {
+	c         *Cluster
+	cmds      []*pipeCmd
+	completed []*redis.Resp
+}
+
+// pipeCmd is a command appended to a Pipeline, along with its response once
+// it's been sent
+type pipeCmd struct {
+	cmd  string
+	args []interface{}
+	key  string
+	ask  bool
+
+	// the node the command was last sent to, and its response from there
+	addr string
+	r    *redis.Resp
+}
+
+// NewPipeline returns a Pipeline which sends its commands to the nodes of the
+// Cluster
+func (c *Cluster) NewPipeline() *Pipeline {
+	return &Pipeline{c: c}
+}
+
+// Append adds the given command to the pipeline
+func (p *Pipeline) Append(cmd string, args ...interface{}) {
+	pc := &pipeCmd{cmd: cmd, args: args}
+	if len(args) < 1 {
+		pc.r = errorResp(ErrBadCmdNoKey)
+	} else if key, err := redis.KeyFromCmd(cmd, args...); err != nil {
+		pc.r = errorResp(err)
+	} else {
+		pc.key = key
+	}
+	p.cmds = append(p.cmds, pc)
+}
+
+// Flush sends every command which has been appended since the last Flush, and
+// reads all of their responses
+func (p *Pipeline) Flush() {
+	if len(p.cmds) == 0 {
+		return
+	}
+	cmds := p.cmds
+	p.cmds = nil
+
+	// commands which couldn't be sent, because they have no key, already have
+	// their response
+	var toSend []*pipeCmd
+	for _, pc := range cmds {
+		if pc.r == nil {
+			toSend = append(toSend, pc)
+		}
+	}
+
+	addrs := make([]string, len(toSend))
+	respCh := make(chan struct{})
+	p.c.callCh <- func(c *Cluster) {
+		for i, pc := range toSend {
+			addrs[i] = keyToAddr(pc.key, &c.mapping)
+		}
+		close(respCh)
+	}
+	<-respCh
+	byAddr := map[string][]*pipeCmd{}
+	for i, pc := range toSend {
+		byAddr[addrs[i]] = append(byAddr[addrs[i]], pc)
+	}
+	p.c.sendPipelines(byAddr, false)
+
+	// Anything which was redirected is sent once more, to where it was
+	// redirected
+	byAddr = map[string][]*pipeCmd{}
+	for _, pc := range toSend {
+		if moved, ask, slot, addr := redirectOf(pc.r, pc.addr); moved || ask {
+			p.c.redirected(moved, slot, addr)
+			pc.ask = ask
+			byAddr[addr] = append(byAddr[addr], pc)
+		}
+	}
+	p.c.sendPipelines(byAddr, true)
+
+	for _, pc := range cmds {
+		p.completed = append(p.completed, pc.r)
+	}
+}
+
+// Resp returns the response for the next command appended to the pipeline,
+// calling Flush first if it hasn't been sent yet. A Resp with an Err of
+// redis.ErrPipelineEmpty is returned if there are no more responses.
+func (p *Pipeline) Resp() *redis.Resp {
+	if len(p.completed) == 0 {
+		p.Flush()
+	}
+	if len(p.completed) == 0 {
+		return redis.NewResp(redis.ErrPipelineEmpty)
+	}
+	r := p.completed[0]
+	p.completed[0] = nil
+	p.completed = p.completed[1:]
+	return r
+}
+
+// sendPipelines sends each node its commands as a pipeline, all at once, and
+// sets each command's response. If redirected is set the commands are being
+// sent to a node which redirected them, so the connection must be to that node
+// rather than a random one.
+func (c *Cluster) sendPipelines(byAddr map[string][]*pipeCmd, redirected bool) {
+	var wg sync.WaitGroup
+	for addr, cmds := range byAddr {
+		wg.Add(1)
+		go func(addr string, cmds []*pipeCmd) {
+			defer wg.Done()
+			var client *redis.Client
+			var err error
+			if redirected {
+				client, err = c.getNodeConn(addr)
+			} else {
+				client, err = c.getConn("", addr)
+			}
+			if err != nil {
+				for _, pc := range cmds {
+					pc.r = errorResp(err)
+				}
+				return
+			}
+			defer c.Put(client)
+			for _, pc := range cmds {
+				pc.addr = client.Addr
+			}
+
+			for _, pc := range cmds {
+				if pc.ask {
+					client.PipeAppend("ASKING")
+				}
+				client.PipeAppend(pc.cmd, pc.args...)
+			}
+			for _, pc := range cmds {
+				if pc.ask {
+					if r := client.PipeResp(); r.Err != nil {
+						// the command's own response still has to be read,
+						// but the ASKING error is what's reported
+						client.PipeResp()
+						pc.r = r
+						continue
+					}
+				}
+				pc.r = client.PipeResp()
+			}
+		}(addr, cmds)
+	}
+	wg.Wait()
+}
+
+// redirectOf returns whether the Resp, from the node at the given address, is a
+// MOVED or ASK error, and if so where to
+func redirectOf(r *redis.Resp, from string) (moved, ask bool, slot int, addr string) {
+	if !r.IsType(redis.AppErr) {
+		return
+	}
+	msg := r.Err.Error()
+	moved = strings.HasPrefix(msg, "MOVED ")
+	ask = strings.HasPrefix(msg, "ASK ")
+	if !moved && !ask {
+		return
+	}
+	var err error
+	if slot, addr, err = redirectInfo(msg, from); err != nil {
+		return false, false, 0, ""
+	}
+	return
+}
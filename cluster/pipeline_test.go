@@ -0,0 +1,86 @@
+package cluster
+
+import (
+	"errors"
+	"strconv"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+func TestClusterPipeline(t *T) {
+	n1, n2 := newFakeNode(t), newFakeNode(t)
+	defer n1.Close()
+	defer n2.Close()
+	c := newFakeCluster(t, n1)
+	defer c.Close()
+
+	// "b" has moved to n2, "d" is somewhere n2 doesn't know about either
+	moved := func(key, addr string) error {
+		return errors.New("MOVED " + strconv.Itoa(int(Slot(key))) + " " + addr)
+	}
+	n1.set(n1.Addr().String(), func(args []string) interface{} {
+		switch args[1] {
+		case "b", "d":
+			return moved(args[1], n2.Addr().String())
+		}
+		return "n1:" + args[1]
+	})
+	n2.set(n2.Addr().String(), func(args []string) interface{} {
+		if args[1] == "d" {
+			return moved("d", n1.Addr().String())
+		}
+		return "n2:" + args[1]
+	})
+
+	p := c.NewPipeline()
+	p.Append("GET", "a")
+	p.Append("GET", "b")
+	p.Append("PING")
+	p.Append("GET", "c")
+	p.Append("GET", "d")
+
+	for _, exp := range []string{"n1:a", "n2:b"} {
+		s, err := p.Resp().Str()
+		require.Nil(t, err)
+		assert.Equal(t, exp, s)
+	}
+	assert.Equal(t, ErrBadCmdNoKey, p.Resp().Err)
+	s, err := p.Resp().Str()
+	require.Nil(t, err)
+	assert.Equal(t, "n1:c", s)
+
+	// a command is only retried once
+	r := p.Resp()
+	require.True(t, r.IsType(redis.AppErr))
+	assert.Contains(t, r.Err.Error(), "MOVED")
+	assert.Equal(t, 1, n1.count("GET D"))
+	assert.Equal(t, 1, n2.count("GET D"))
+
+	assert.Equal(t, redis.ErrPipelineEmpty, p.Resp().Err)
+}
+
+func TestClusterPipelineAsk(t *T) {
+	n1, n2 := newFakeNode(t), newFakeNode(t)
+	defer n1.Close()
+	defer n2.Close()
+	c := newFakeCluster(t, n1)
+	defer c.Close()
+
+	n1.set(n1.Addr().String(), redirect("ASK", "foo", n2.Addr().String()))
+	n2.set(n2.Addr().String(), func(args []string) interface{} { return args[0] })
+
+	p := c.NewPipeline()
+	p.Append("GET", "foo")
+	p.Append("SET", "foo", "bar")
+	for _, exp := range []string{"GET", "SET"} {
+		s, err := p.Resp().Str()
+		require.Nil(t, err)
+		assert.Equal(t, exp, s)
+	}
+	assert.Equal(t, 2, n2.count("ASKING"))
+	assert.Equal(t, n1.Addr().String(), c.GetAddrForKey("foo"))
+}
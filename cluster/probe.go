@@ -0,0 +1,194 @@
+package cluster
+
+import (
+	"context"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/log"
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// probeAlpha is the weight given to each new sample in a node's RTT moving
+// average
+const probeAlpha = 0.3
+
+// nodeRTT is the prober's record for a single node
+type nodeRTT struct {
+	rtt  time.Duration
+	last time.Time
+	err  error
+}
+
+func (nr nodeRTT) add(sample time.Duration) nodeRTT {
+	if nr.rtt == 0 {
+		nr.rtt = sample
+	} else {
+		nr.rtt = time.Duration(probeAlpha*float64(sample) + (1-probeAlpha)*float64(nr.rtt))
+	}
+	return nr
+}
+
+// NodeStats describes a single node in the cluster, as returned by Stats
+type NodeStats struct {
+	// The stats of the node's connection pool
+	Pool pool.Stats
+
+	// Only set if ProbeInterval is set. The moving average of the round trip
+	// time of PINGs to the node, when the last PING was done, and the error
+	// from it if it failed. RTT is zero if no PING has succeeded yet.
+	RTT       time.Duration
+	LastProbe time.Time
+	ProbeErr  error
+}
+
+// Stats returns the NodeStats for every node the Cluster has a pool for,
+// including replicas if ReadFromReplicas is set, keyed by address
+func (c *Cluster) Stats() map[string]NodeStats {
+	respCh := make(chan map[string]NodeStats)
+	c.callCh <- func(c *Cluster) {
+		m := make(map[string]NodeStats, len(c.pools)+len(c.replicaPools))
+		for addr, p := range c.pools {
+			m[addr] = NodeStats{Pool: p.Stats()}
+		}
+		for addr, p := range c.replicaPools {
+			m[addr] = NodeStats{Pool: p.Stats()}
+		}
+		respCh <- m
+	}
+	m := <-respCh
+
+	c.rttL.Lock()
+	defer c.rttL.Unlock()
+	for addr, ns := range m {
+		nr := c.rtts[addr]
+		ns.RTT, ns.LastProbe, ns.ProbeErr = nr.rtt, nr.last, nr.err
+		m[addr] = ns
+	}
+	return m
+}
+
+// NodeRTT returns the moving average of the round trip time to the node at the
+// given address, or false if ProbeInterval isn't set or no PING to the node
+// has succeeded yet
+func (c *Cluster) NodeRTT(addr string) (time.Duration, bool) {
+	c.rttL.Lock()
+	defer c.rttL.Unlock()
+	nr, ok := c.rtts[addr]
+	return nr.rtt, ok && nr.rtt > 0
+}
+
+// call runs fn in the spin go-routine, unless the Cluster is closed first
+func (c *Cluster) call(fn func(*Cluster)) bool {
+	doneCh := make(chan struct{})
+	select {
+	case c.callCh <- func(c *Cluster) {
+		fn(c)
+		close(doneCh)
+	}:
+	case <-c.stopCh:
+		return false
+	}
+	<-doneCh
+	return true
+}
+
+func (c *Cluster) probeSpin() {
+	defer close(c.probeDone)
+	conns := map[string]*redis.Client{}
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	t := time.NewTicker(c.o.ProbeInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-c.stopCh:
+			return
+		}
+
+		var addrs []string
+		if !c.call(func(c *Cluster) {
+			for addr := range c.pools {
+				addrs = append(addrs, addr)
+			}
+			for addr := range c.replicaPools {
+				addrs = append(addrs, addr)
+			}
+		}) {
+			return
+		}
+
+		probed := make(map[string]bool, len(addrs))
+		for _, addr := range addrs {
+			probed[addr] = true
+			c.probe(conns, addr)
+		}
+
+		// forget about nodes which are no longer in the cluster
+		for addr, conn := range conns {
+			if !probed[addr] {
+				conn.Close()
+				delete(conns, addr)
+			}
+		}
+		c.rttL.Lock()
+		for addr := range c.rtts {
+			if !probed[addr] {
+				delete(c.rtts, addr)
+			}
+		}
+		c.rttL.Unlock()
+	}
+}
+
+// probe PINGs a single node, making a connection for it in conns if there
+// isn't one yet
+func (c *Cluster) probe(conns map[string]*redis.Client, addr string) {
+	start := time.Now()
+	var err error
+	conn, ok := conns[addr]
+	if !ok {
+		// The probe interval is used as the timeout if there's no other, so
+		// a node which doesn't respond can't hold up probing
+		var df pool.DialCtxFunc
+		if df, err = c.nodeDialer(addr, c.o.ProbeInterval); err == nil {
+			if conn, err = df(context.Background(), "tcp", addr); err == nil {
+				conns[addr] = conn
+				start = time.Now()
+			}
+		}
+	}
+	if err == nil {
+		err = conn.Cmd("PING").Err
+	}
+	took := time.Since(start)
+
+	c.rttL.Lock()
+	nr := c.rtts[addr]
+	nr.last, nr.err = time.Now(), err
+	if err == nil {
+		nr = nr.add(took)
+	}
+	c.rtts[addr] = nr
+	c.rttL.Unlock()
+
+	if err != nil {
+		c.logger.Log(log.Warn, "probe failed", log.KV{
+			log.KeyOperation: "probe",
+			log.KeyAddr:      addr,
+			log.KeyErr:       err,
+		})
+		if conn != nil {
+			conn.Close()
+			delete(conns, addr)
+		}
+		c.call(func(c *Cluster) {
+			c.poolThrottles[addr] = time.After(c.o.PoolThrottle)
+		})
+	}
+}
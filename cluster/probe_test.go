@@ -0,0 +1,44 @@
+package cluster
+
+import (
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeRTTAdd(t *T) {
+	var nr nodeRTT
+	nr = nr.add(10 * time.Millisecond)
+	assert.Equal(t, 10*time.Millisecond, nr.rtt)
+	nr = nr.add(20 * time.Millisecond)
+	assert.Equal(t, 13*time.Millisecond, nr.rtt)
+}
+
+func TestProbe(t *T) {
+	c, err := NewWithOpts(Opts{Addr: addr1})
+	require.Nil(t, err)
+	assert.Nil(t, c.probeDone)
+	_, ok := c.NodeRTT(addr1)
+	assert.False(t, ok)
+	c.Close()
+
+	c, err = NewWithOpts(Opts{Addr: addr1, ProbeInterval: 10 * time.Millisecond})
+	require.Nil(t, err)
+	defer c.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	stats := c.Stats()
+	for _, addr := range []string{addr1, addr2} {
+		ns, ok := stats[addr]
+		require.True(t, ok)
+		assert.Nil(t, ns.ProbeErr)
+		assert.True(t, ns.RTT > 0)
+		assert.False(t, ns.LastProbe.IsZero())
+
+		rtt, ok := c.NodeRTT(addr)
+		assert.True(t, ok)
+		assert.Equal(t, ns.RTT, rtt)
+	}
+}
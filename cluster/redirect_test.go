@@ -0,0 +1,246 @@
+package cluster
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// fakeNode answers CLUSTER SLOTS with every slot belonging to owner, with the
+// given replicas, and every other command using handle. It records the
+// commands it's sent.
+type fakeNode struct {
+	net.Listener
+	l        sync.Mutex
+	owner    string
+	replicas []string
+	handle   func(args []string) interface{}
+	cmds     []string
+}
+
+func newFakeNode(t *T) *fakeNode {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	fn := &fakeNode{Listener: l, owner: l.Addr().String()}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go fn.serve(conn)
+		}
+	}()
+	return fn
+}
+
+func (fn *fakeNode) serve(conn net.Conn) {
+	defer conn.Close()
+	rr := redis.NewRespReader(conn)
+	for {
+		args, err := rr.Read().List()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.Join(args, " "))
+		fn.l.Lock()
+		fn.cmds = append(fn.cmds, cmd)
+		owner, replicas, handle := fn.owner, fn.replicas, fn.handle
+		fn.l.Unlock()
+
+		var reply interface{}
+		if cmd == "CLUSTER SLOTS" {
+			group := []interface{}{0, numSlots - 1}
+			for _, addr := range append([]string{owner}, replicas...) {
+				host, port, _ := net.SplitHostPort(addr)
+				portN, _ := strconv.Atoi(port)
+				group = append(group, []interface{}{host, portN})
+			}
+			reply = []interface{}{group}
+		} else if handle != nil {
+			reply = handle(args)
+		} else {
+			reply = "OK"
+		}
+		redis.NewResp(reply).WriteTo(conn)
+	}
+}
+
+func (fn *fakeNode) set(owner string, handle func([]string) interface{}) {
+	fn.l.Lock()
+	defer fn.l.Unlock()
+	fn.owner, fn.handle = owner, handle
+}
+
+// count returns how many times the node has been sent the given command
+func (fn *fakeNode) count(cmd string) int {
+	fn.l.Lock()
+	defer fn.l.Unlock()
+	var n int
+	for _, c := range fn.cmds {
+		if c == cmd {
+			n++
+		}
+	}
+	return n
+}
+
+// redirect returns a handler which redirects every command other than ASKING
+// to the given address
+func redirect(kind, key, addr string) func([]string) interface{} {
+	return func(args []string) interface{} {
+		if strings.ToUpper(args[0]) == "ASKING" {
+			return "OK"
+		}
+		return errors.New(kind + " " + strconv.Itoa(int(Slot(key))) + " " + addr)
+	}
+}
+
+func newFakeCluster(t *T, n *fakeNode) *Cluster {
+	c, err := NewWithOpts(Opts{
+		Addr:          n.Addr().String(),
+		PoolSize:      1,
+		ResetThrottle: time.Millisecond,
+		PoolThrottle:  time.Millisecond,
+	})
+	require.Nil(t, err)
+	// let the throttle pass, so that a redirect's Reset isn't skipped
+	time.Sleep(5 * time.Millisecond)
+	return c
+}
+
+func TestRedirectMoved(t *T) {
+	n1, n2 := newFakeNode(t), newFakeNode(t)
+	defer n1.Close()
+	defer n2.Close()
+	c := newFakeCluster(t, n1)
+	defer c.Close()
+
+	// the slot has moved to n2, and n1 now says so
+	n1.set(n2.Addr().String(), redirect("MOVED", "foo", n2.Addr().String()))
+	n2.set(n2.Addr().String(), func([]string) interface{} { return "bar" })
+
+	s, err := c.Cmd("GET", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "bar", s)
+	assert.Equal(t, n2.Addr().String(), c.GetAddrForKey("foo"))
+	waitForCount(t, n1, "CLUSTER SLOTS", 2)
+
+	// from then on the command goes straight to n2
+	require.Nil(t, c.Cmd("GET", "foo").Err)
+	assert.Equal(t, 1, n1.count("GET FOO"))
+	assert.Equal(t, 2, n2.count("GET FOO"))
+}
+
+func TestRedirectMovedNoHost(t *T) {
+	n1, n2 := newFakeNode(t), newFakeNode(t)
+	defer n1.Close()
+	defer n2.Close()
+	c := newFakeCluster(t, n1)
+	defer c.Close()
+
+	_, port, _ := net.SplitHostPort(n2.Addr().String())
+	n1.set(n1.Addr().String(), redirect("MOVED", "foo", ":"+port))
+	n2.set(n2.Addr().String(), func([]string) interface{} { return "bar" })
+
+	s, err := c.Cmd("GET", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "bar", s)
+}
+
+func TestRedirectAsk(t *T) {
+	n1, n2 := newFakeNode(t), newFakeNode(t)
+	defer n1.Close()
+	defer n2.Close()
+	c := newFakeCluster(t, n1)
+	defer c.Close()
+
+	n1.set(n1.Addr().String(), redirect("ASK", "foo", n2.Addr().String()))
+	n2.set(n2.Addr().String(), func(args []string) interface{} {
+		if strings.ToUpper(args[0]) == "ASKING" {
+			return "OK"
+		}
+		return "bar"
+	})
+
+	s, err := c.Cmd("GET", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "bar", s)
+	assert.Equal(t, 1, n2.count("ASKING"))
+
+	// the slot still belongs to n1, and the topology isn't fetched again
+	assert.Equal(t, n1.Addr().String(), c.GetAddrForKey("foo"))
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 1, n1.count("CLUSTER SLOTS"))
+}
+
+func TestRedirectLimit(t *T) {
+	n1, n2 := newFakeNode(t), newFakeNode(t)
+	defer n1.Close()
+	defer n2.Close()
+	c := newFakeCluster(t, n1)
+	defer c.Close()
+
+	// the nodes send the command back and forth between each other
+	n1.set(n1.Addr().String(), redirect("ASK", "foo", n2.Addr().String()))
+	n2.set(n2.Addr().String(), redirect("ASK", "foo", n1.Addr().String()))
+
+	r := c.Cmd("GET", "foo")
+	require.NotNil(t, r.Err)
+	assert.Contains(t, r.Err.Error(), "Too many redirects")
+	assert.Equal(t, 4, n1.count("GET FOO")+n2.count("GET FOO"))
+}
+
+func TestRedirectUnreachable(t *T) {
+	n1 := newFakeNode(t)
+	defer n1.Close()
+	c := newFakeCluster(t, n1)
+	defer c.Close()
+
+	// the node redirected to can't be reached, which isn't hidden by trying
+	// the command on a different node
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	gone := l.Addr().String()
+	l.Close()
+	n1.set(n1.Addr().String(), redirect("ASK", "foo", gone))
+
+	r := c.Cmd("GET", "foo")
+	require.NotNil(t, r.Err)
+	assert.Equal(t, 1, n1.count("GET FOO"))
+}
+
+func TestRedirectInfo(t *T) {
+	slot, addr, err := redirectInfo("MOVED 3999 127.0.0.1:6381", "127.0.0.1:6380")
+	require.Nil(t, err)
+	assert.Equal(t, 3999, slot)
+	assert.Equal(t, "127.0.0.1:6381", addr)
+
+	_, addr, err = redirectInfo("ASK 3999 :6381", "10.0.0.1:6380")
+	require.Nil(t, err)
+	assert.Equal(t, "10.0.0.1:6381", addr)
+
+	for _, msg := range []string{"MOVED", "MOVED foo 127.0.0.1:6381", "MOVED 16384 127.0.0.1:6381"} {
+		_, _, err = redirectInfo(msg, "127.0.0.1:6380")
+		assert.NotNil(t, err, msg)
+	}
+}
+
+func waitForCount(t *T, fn *fakeNode, cmd string, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for fn.count(cmd) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d %q", n, cmd)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,194 @@
+package cluster
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/log"
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// DefaultReadOnlyCommands are the commands which ReadFromReplicas sends to
+// replicas if ReadOnlyCommands isn't set. They're all commands which never
+// write, and which act on a single slot.
+var DefaultReadOnlyCommands = []string{
+	"BITCOUNT", "BITPOS", "DUMP", "EXISTS", "GET", "GETBIT", "GETRANGE",
+	"GEODIST", "GEOHASH", "GEOPOS", "GEORADIUS_RO", "GEORADIUSBYMEMBER_RO",
+	"GEOSEARCH", "HEXISTS", "HGET", "HGETALL", "HKEYS", "HLEN", "HMGET",
+	"HRANDFIELD", "HSCAN", "HSTRLEN", "HVALS", "LINDEX", "LLEN", "LPOS",
+	"LRANGE", "MGET", "PFCOUNT", "PTTL", "SCARD", "SDIFF", "SINTER",
+	"SISMEMBER", "SMEMBERS", "SMISMEMBER", "SRANDMEMBER", "SSCAN", "STRLEN",
+	"SUNION", "TTL", "TYPE", "XLEN", "XRANGE", "XREVRANGE", "ZCARD", "ZCOUNT",
+	"ZLEXCOUNT", "ZMSCORE", "ZRANDMEMBER", "ZRANGE", "ZRANGEBYLEX",
+	"ZRANGEBYSCORE", "ZRANK", "ZREVRANGE", "ZREVRANGEBYLEX",
+	"ZREVRANGEBYSCORE", "ZREVRANK", "ZSCAN", "ZSCORE",
+	"EVAL_RO", "EVALSHA_RO", "FCALL_RO",
+}
+
+// slotReplicas parses the addresses of the replicas in a CLUSTER SLOTS slot
+// group, which are all of its elements after the master. A blank ip is the
+// node which was asked, at the given address.
+func slotReplicas(elems []*redis.Resp, self string) ([]string, error) {
+	addrs := make([]string, 0, len(elems))
+	for _, elem := range elems {
+		addrElems, err := elem.Array()
+		if err != nil {
+			return nil, err
+		}
+		ip, err := addrElems[0].Str()
+		if err != nil {
+			return nil, err
+		}
+		port, err := addrElems[1].Int()
+		if err != nil {
+			return nil, err
+		}
+		if ip == "" {
+			addrs = append(addrs, self)
+		} else {
+			addrs = append(addrs, ip+":"+strconv.Itoa(port))
+		}
+	}
+	return addrs, nil
+}
+
+// setReplicas is called by reset with the replicas of each master, and makes
+// pools for any it doesn't have yet, closing those for replicas which have
+// gone. A replica which can't be connected to is left without a pool until the
+// next reset, with its master being used in its place. It returns whether
+// anything changed.
+func (c *Cluster) setReplicas(replicas map[string][]string) bool {
+	var changed bool
+	pools := map[string]*pool.Pool{}
+	for master, addrs := range replicas {
+		withPools := addrs[:0]
+		for _, addr := range addrs {
+			if p, ok := c.replicaPools[addr]; ok {
+				pools[addr] = p
+				withPools = append(withPools, addr)
+				continue
+			}
+			p, err := c.newReplicaPool(addr)
+			if err != nil {
+				if p != nil {
+					p.Close()
+				}
+				c.logger.Log(log.Warn, "skipped replica", log.KV{
+					log.KeyOperation: "reset",
+					log.KeyMaster:    master,
+					log.KeyAddr:      addr,
+					log.KeyErr:       err,
+				})
+				continue
+			}
+			changed = true
+			pools[addr] = p
+			withPools = append(withPools, addr)
+		}
+		replicas[master] = withPools
+	}
+
+	for addr, p := range c.replicaPools {
+		if _, ok := pools[addr]; !ok {
+			p.Close()
+			changed = true
+		}
+	}
+	c.replicas = replicas
+	c.replicaPools = pools
+	return changed
+}
+
+// newReplicaPool creates the pool for a replica, whose connections are all
+// sent READONLY before being used
+func (c *Cluster) newReplicaPool(addr string) (*pool.Pool, error) {
+	df, err := c.nodeDialer(addr, c.o.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return pool.NewWithOpts(pool.Opts{
+		Network: "tcp",
+		Addr:    addr,
+		Size:    c.o.PoolSize,
+		Dial: func(ctx context.Context, network, addr string) (*redis.Client, error) {
+			conn, err := df(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			if err := conn.Cmd("READONLY").Err; err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return conn, nil
+		},
+		Logger: c.o.Logger,
+		Trace:  c.o.Trace,
+	})
+}
+
+// getReplicaConn returns a connection to one of the replicas of the master for
+// the given key's slot, or nil if it has none or the connection couldn't be
+// gotten
+func (c *Cluster) getReplicaConn(key string) *redis.Client {
+	var p *pool.Pool
+	c.call(func(c *Cluster) {
+		addrs := c.replicas[keyToAddr(key, &c.mapping)]
+		if len(addrs) == 0 {
+			return
+		}
+		addr := c.closestNode(addrs)
+		if addr == "" {
+			addr = addrs[c.replicaNext%len(addrs)]
+			c.replicaNext++
+		}
+		p = c.replicaPools[addr]
+	})
+	if p == nil {
+		return nil
+	}
+	conn, err := p.Get()
+	if err != nil {
+		return nil
+	}
+	return conn
+}
+
+// closestNode returns the node with the lowest RTT, or "" if none of them have
+// been probed
+func (c *Cluster) closestNode(addrs []string) string {
+	var closest string
+	var closestRTT time.Duration
+	for _, addr := range addrs {
+		rtt, ok := c.NodeRTT(addr)
+		if ok && (closest == "" || rtt < closestRTT) {
+			closest, closestRTT = addr, rtt
+		}
+	}
+	return closest
+}
+
+// replicaCmd sends a read-only command to a replica for its key. If that can't
+// be done, or the replica couldn't run it, false is returned and the command
+// should be sent to the master.
+func (c *Cluster) replicaCmd(key, cmd string, args []interface{}) (*redis.Resp, bool) {
+	conn := c.getReplicaConn(key)
+	if conn == nil {
+		return nil, false
+	}
+	defer c.Put(conn)
+
+	r := conn.Cmd(cmd, args...)
+	if r.IsType(redis.IOErr) {
+		return nil, false
+	} else if r.IsType(redis.AppErr) {
+		// a replica for a slot which has moved redirects to the new master
+		msg := r.Err.Error()
+		if strings.HasPrefix(msg, "MOVED ") || strings.HasPrefix(msg, "ASK ") {
+			return nil, false
+		}
+	}
+	return r, true
+}
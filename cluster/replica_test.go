@@ -0,0 +1,102 @@
+package cluster
+
+import (
+	"net"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newReplicaCluster returns a Cluster with ReadFromReplicas set, whose only
+// master is n1 and whose only replica is n2. n2 answers every command with
+// "replica", and n1 with "master".
+func newReplicaCluster(t *T, readOnlyCmds ...string) (*Cluster, *fakeNode, *fakeNode) {
+	n1, n2 := newFakeNode(t), newFakeNode(t)
+	n1.replicas = []string{n2.Addr().String()}
+	n1.set(n1.Addr().String(), func([]string) interface{} { return "master" })
+	n2.set(n1.Addr().String(), func([]string) interface{} { return "replica" })
+
+	c, err := NewWithOpts(Opts{
+		Addr:             n1.Addr().String(),
+		PoolSize:         1,
+		ReadFromReplicas: true,
+		ReadOnlyCommands: readOnlyCmds,
+	})
+	require.Nil(t, err)
+	return c, n1, n2
+}
+
+func TestReadFromReplicas(t *T) {
+	c, n1, n2 := newReplicaCluster(t)
+	defer n1.Close()
+	defer n2.Close()
+	defer c.Close()
+
+	s, err := c.Cmd("GET", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "replica", s)
+	assert.Equal(t, 1, n2.count("READONLY"))
+
+	// writes still go to the master
+	s, err = c.Cmd("SET", "foo", "bar").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "master", s)
+	assert.Contains(t, c.Stats(), n2.Addr().String())
+
+	// a replica which redirects is passed over for the master
+	n2.set(n1.Addr().String(), redirect("MOVED", "foo", n1.Addr().String()))
+	s, err = c.Cmd("GET", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "master", s)
+
+	// as is one which has gone away
+	n2.Close()
+	s, err = c.Cmd("GET", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "master", s)
+}
+
+func TestReadOnlyCommands(t *T) {
+	c, n1, n2 := newReplicaCluster(t, "evalsha")
+	defer n1.Close()
+	defer n2.Close()
+	defer c.Close()
+
+	// the given commands replace the defaults
+	s, err := c.Cmd("EVALSHA", "abc", 1, "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "replica", s)
+	s, err = c.Cmd("GET", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "master", s)
+}
+
+func TestReadFromReplicasUnreachable(t *T) {
+	// a replica which can't be connected to when the topology is fetched is
+	// skipped
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	gone := l.Addr().String()
+	l.Close()
+
+	n1 := newFakeNode(t)
+	defer n1.Close()
+	n1.replicas = []string{gone}
+	n1.set(n1.Addr().String(), func([]string) interface{} { return "master" })
+	c, err := NewWithOpts(Opts{
+		Addr:             n1.Addr().String(),
+		PoolSize:         1,
+		ReadFromReplicas: true,
+		Timeout:          time.Second,
+	})
+	require.Nil(t, err)
+	defer c.Close()
+
+	s, err := c.Cmd("GET", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "master", s)
+	_, ok := c.Stats()[gone]
+	assert.False(t, ok)
+}
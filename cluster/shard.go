@@ -0,0 +1,71 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mediocregopher/radix.v2/pubsub"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// ErrCrossSlot is returned from NewShardSubClient when the shard channels
+// given to it aren't all in the same slot
+var ErrCrossSlot = errors.New("shard channels are not all in the same slot")
+
+// NewShardSubClient returns a PersistentSubClient which is subscribed, with
+// SSUBSCRIBE, to the given shard channels on the node which owns their slot.
+// The channels must all be in the same slot, see HashTag. Messages are
+// published to them with SPUBLISH, which Cmd sends to the same node.
+//
+// The PersistentSubClient has its own connection, rather than one from the
+// node's pool. Whenever it has to reconnect, either because the connection was
+// lost or because the slot was moved to another node, the cluster's topology
+// is Reset first, so that it reconnects to whichever node owns the slot by
+// then and subscribes to the channels again there. Other shard channels
+// subscribed to later on with SSubscribe must be in the same slot too.
+func (c *Cluster) NewShardSubClient(channels ...string) (*pubsub.PersistentSubClient, error) {
+	if len(channels) == 0 {
+		return nil, ErrBadCmdNoKey
+	}
+	slot := Slot(channels[0])
+	args := make([]interface{}, len(channels))
+	for i, ch := range channels {
+		if Slot(ch) != slot {
+			return nil, ErrCrossSlot
+		}
+		args[i] = ch
+	}
+
+	var dialed bool
+	df := func() (*redis.Client, error) {
+		if dialed {
+			if err := c.Reset(); err != nil {
+				return nil, err
+			}
+		}
+		dialed = true
+
+		addr := c.GetAddrForKey(channels[0])
+		if addr == "" {
+			return nil, fmt.Errorf("no node known for slot %d", slot)
+		}
+		ndf, err := c.nodeDialer(addr, c.o.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		return ndf(context.Background(), "tcp", addr)
+	}
+
+	p, err := pubsub.NewPersistentSubClient(df)
+	if err != nil {
+		return nil, err
+	}
+	// ErrReconnecting means the slot had already moved, the channels will be
+	// subscribed to on the new node by the next call to the client
+	if sr := p.SSubscribe(args...); sr.Err != nil && sr.Err != pubsub.ErrReconnecting {
+		p.Close()
+		return nil, sr.Err
+	}
+	return p, nil
+}
@@ -0,0 +1,33 @@
+package cluster
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mediocregopher/radix.v2/pubsub"
+)
+
+func TestShardSubClient(t *T) {
+	c := getCluster(t)
+	defer c.Close()
+
+	ch := randStr()
+	other := "{" + ch + "}" + randStr()
+	sub, err := c.NewShardSubClient(ch, other)
+	require.Nil(t, err)
+	defer sub.Close()
+
+	require.Nil(t, c.Cmd("SPUBLISH", other, "foo").Err)
+	sr := sub.Receive()
+	require.Nil(t, sr.Err)
+	assert.Equal(t, pubsub.Message, sr.Type)
+	assert.Equal(t, other, sr.Channel)
+	assert.Equal(t, "foo", sr.Message)
+
+	_, err = c.NewShardSubClient(keyForNode(c, addr1), keyForNode(c, addr2))
+	assert.Equal(t, ErrCrossSlot, err)
+	_, err = c.NewShardSubClient()
+	assert.Equal(t, ErrBadCmdNoKey, err)
+}
@@ -0,0 +1,224 @@
+// Package log defines the Logger interface which the other radix.v2 packages
+// use to report things which happen in the background, or otherwise wouldn't
+// be seen by the caller, such as a connection being thrown away or a failover
+// being noticed. Every package defaults to discarding these, and accepts a
+// Logger in its options (or constructor) to change that.
+//
+// Entries are made up of a Level, a message, and a set of key/value fields.
+// The keys used by radix.v2 are the constants in this package, so that entries
+// from all its packages can be handled in the same way. Not every key is set on
+// every entry.
+//
+// The standard library's logger can be used via NewStdLogger:
+//
+//	l := log.NewStdLogger(stdlog.New(os.Stderr, "redis ", stdlog.LstdFlags), log.Info)
+//	p, err := pool.NewWithOpts(pool.Opts{Network: "tcp", Addr: "127.0.0.1:6379", Logger: l})
+package log
+
+import (
+	"fmt"
+	stdlog "log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Level describes how important a log entry is
+type Level int
+
+// The Levels entries may be logged at, from least to most important. Debug is
+// for things which are normal but may be interesting, Info for significant
+// but expected events (e.g. a failover), Warn for things which shouldn't
+// normally happen but which have been recovered from, and Error for those
+// which couldn't be.
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+func (l Level) String() string {
+	switch l {
+	case Debug:
+		return "DEBUG"
+	case Info:
+		return "INFO"
+	case Warn:
+		return "WARN"
+	case Error:
+		return "ERROR"
+	default:
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+}
+
+// The keys used in the fields of entries logged by radix.v2
+const (
+	// The package the entry came from, e.g. "pool" or "sentinel"
+	KeyComponent = "component"
+
+	// The address of the redis instance involved
+	KeyAddr = "addr"
+
+	// The name of the sentinel master involved
+	KeyMaster = "master"
+
+	// What was being done when the entry was logged, e.g. "reset" or "put"
+	KeyOperation = "operation"
+
+	// The error which caused the entry to be logged
+	KeyErr = "err"
+)
+
+// KV holds the key/value fields of a log entry
+type KV map[string]interface{}
+
+// Logger is implemented by anything which can receive log entries from
+// radix.v2. Implementations must be safe to use from multiple go-routines at
+// once. The KV passed in mustn't be modified or held onto.
+type Logger interface {
+	Log(lvl Level, msg string, kv KV)
+}
+
+// LoggerFunc is a function which implements Logger
+type LoggerFunc func(lvl Level, msg string, kv KV)
+
+// Log implements the method for the Logger interface
+func (f LoggerFunc) Log(lvl Level, msg string, kv KV) {
+	f(lvl, msg, kv)
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Log(Level, string, KV) {}
+
+// Nop is a Logger which discards everything, and is what all radix.v2
+// packages use when no Logger is given
+var Nop Logger = nopLogger{}
+
+type withLogger struct {
+	l  Logger
+	kv KV
+}
+
+// With returns a Logger which adds the given fields to every entry before
+// passing it on to the given Logger. Fields already on an entry take
+// precedence. If the given Logger is nil Nop is returned.
+func With(l Logger, kv KV) Logger {
+	if l == nil || l == Nop {
+		return Nop
+	}
+	if wl, ok := l.(withLogger); ok {
+		l, kv = wl.l, merge(wl.kv, kv)
+	}
+	return withLogger{l: l, kv: kv}
+}
+
+func (wl withLogger) Log(lvl Level, msg string, kv KV) {
+	wl.l.Log(lvl, msg, merge(wl.kv, kv))
+}
+
+// merge returns a new KV with the fields of both, those of b taking precedence
+func merge(a, b KV) KV {
+	kv := make(KV, len(a)+len(b))
+	for k, v := range a {
+		kv[k] = v
+	}
+	for k, v := range b {
+		kv[k] = v
+	}
+	return kv
+}
+
+type stdLogger struct {
+	l   *stdlog.Logger
+	min Level
+}
+
+// NewStdLogger returns a Logger which writes entries of the given Level or
+// above to the given standard library logger, one per line, like:
+//
+//	WARN discarded connection addr=127.0.0.1:6379 component=pool err="EOF"
+//
+// Fields are written in order of their keys. If the given logger is nil the
+// standard library's default logger is used.
+func NewStdLogger(l *stdlog.Logger, min Level) Logger {
+	return stdLogger{l: l, min: min}
+}
+
+func (sl stdLogger) Log(lvl Level, msg string, kv KV) {
+	if lvl < sl.min {
+		return
+	}
+	s := Format(lvl, msg, kv)
+	if sl.l == nil {
+		stdlog.Print(s)
+		return
+	}
+	sl.l.Print(s)
+}
+
+// Format returns the single line form of an entry, as written by the Logger
+// returned from NewStdLogger
+func Format(lvl Level, msg string, kv KV) string {
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(kv)+2)
+	parts = append(parts, lvl.String(), msg)
+	for _, k := range keys {
+		var v string
+		switch vv := kv[k].(type) {
+		case error:
+			v = fmt.Sprintf("%q", vv.Error())
+		case string:
+			v = vv
+			if strings.ContainsAny(v, " \t\n\"=") {
+				v = fmt.Sprintf("%q", v)
+			}
+		default:
+			v = fmt.Sprint(vv)
+		}
+		parts = append(parts, k+"="+v)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Entry is a single log entry, as recorded by a Recorder
+type Entry struct {
+	Level Level
+	Msg   string
+	KV    KV
+}
+
+// Recorder is a Logger which keeps every entry logged to it, which is mostly
+// useful in tests. The zero value is ready to use.
+type Recorder struct {
+	l       sync.Mutex
+	entries []Entry
+}
+
+// Log implements the method for the Logger interface
+func (r *Recorder) Log(lvl Level, msg string, kv KV) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.entries = append(r.entries, Entry{Level: lvl, Msg: msg, KV: merge(nil, kv)})
+}
+
+// Entries returns all the entries which have been logged so far whose message
+// is the given one, or all of them if it's empty
+func (r *Recorder) Entries(msg string) []Entry {
+	r.l.Lock()
+	defer r.l.Unlock()
+	var ee []Entry
+	for _, e := range r.entries {
+		if msg == "" || e.Msg == msg {
+			ee = append(ee, e)
+		}
+	}
+	return ee
+}
@@ -0,0 +1,47 @@
+package log
+
+import (
+	"bytes"
+	"errors"
+	stdlog "log"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormat(t *T) {
+	s := Format(Warn, "discarded connection", KV{
+		KeyComponent: "pool",
+		KeyAddr:      "127.0.0.1:6379",
+		KeyErr:       errors.New("EOF"),
+		"n":          1,
+		"note":       "has spaces",
+	})
+	assert.Equal(t, `WARN discarded connection addr=127.0.0.1:6379 component=pool err="EOF" n=1 note="has spaces"`, s)
+	assert.Equal(t, "DEBUG foo", Format(Debug, "foo", nil))
+}
+
+func TestStdLogger(t *T) {
+	buf := new(bytes.Buffer)
+	l := NewStdLogger(stdlog.New(buf, "", 0), Info)
+	l.Log(Debug, "skipped", nil)
+	l.Log(Error, "bad", KV{KeyComponent: "cluster"})
+	assert.Equal(t, "ERROR bad component=cluster\n", buf.String())
+}
+
+func TestWith(t *T) {
+	assert.Equal(t, Nop, With(nil, KV{"a": 1}))
+	assert.Equal(t, Nop, With(Nop, KV{"a": 1}))
+
+	r := new(Recorder)
+	l := With(With(r, KV{"a": 1, "b": 1}), KV{"b": 2})
+	l.Log(Info, "foo", KV{"c": 3})
+	l.Log(Info, "bar", KV{"a": 4})
+
+	ee := r.Entries("")
+	require.Len(t, ee, 2)
+	assert.Equal(t, Entry{Level: Info, Msg: "foo", KV: KV{"a": 1, "b": 2, "c": 3}}, ee[0])
+	assert.Equal(t, KV{"a": 4, "b": 2}, ee[1].KV)
+	assert.Len(t, r.Entries("bar"), 1)
+}
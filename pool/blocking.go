@@ -0,0 +1,117 @@
+package pool
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/log"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// blockingSlack is added to the duration a command blocks for to get the read
+// timeout used for its reply, so that redis has time to reply once the block
+// is up
+const blockingSlack = time.Second
+
+// getBlocking returns an idle connection kept for blocking commands, or dials
+// a new one if there aren't any
+func (p *Pool) getBlocking() (*redis.Client, error) {
+	if p.isClosed() {
+		return nil, ErrClosed
+	}
+	select {
+	case conn := <-p.blocking:
+		return conn, nil
+	default:
+	}
+
+	atomic.AddInt64(&p.stats.dials, 1)
+	conn, err := p.df(p.ctx, p.Network, p.Addr)
+	if err != nil {
+		atomic.AddInt64(&p.stats.dialErrors, 1)
+		if p.isClosed() {
+			return nil, ErrClosed
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// putBlocking returns a connection gotten with getBlocking to the idle ones
+// kept for blocking commands, or closes it if there are already BlockingSize
+// of them
+func (p *Pool) putBlocking(conn *redis.Client) {
+	if conn.LastCritical != nil {
+		p.logger.Log(log.Warn, "discarded blocking connection", log.KV{
+			log.KeyOperation: "put",
+			log.KeyErr:       conn.LastCritical,
+		})
+		return
+	}
+
+	select {
+	case p.blocking <- conn:
+	default:
+		conn.Close()
+	}
+
+	// same as in Put
+	if p.isClosed() {
+		p.emptyBlocking()
+	}
+}
+
+func (p *Pool) emptyBlocking() {
+	for {
+		select {
+		case conn := <-p.blocking:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// DoBlocking calls fn with a connection which is only used for blocking
+// commands, like BLPOP, BRPOPLPUSH or XREAD with BLOCK, so that they don't tie
+// up the Pool's other connections or count towards MaxActive. While fn is
+// running the connection's read timeout is raised to block plus a little
+// slack, block being how long the command was told to block for. A block of
+// zero means the command blocks forever, and so there's no read timeout.
+//
+// The connection is kept afterwards for the next blocking command, up to
+// BlockingSize of them, rather than being put back into the Pool.
+func (p *Pool) DoBlocking(block time.Duration, fn func(*redis.Client) error) error {
+	// counted before the connection is gotten, as in Cmd
+	atomic.AddInt64(&p.stats.blockingActive, 1)
+	defer func() {
+		atomic.AddInt64(&p.stats.blockingActive, -1)
+		p.signalReturned()
+	}()
+	conn, err := p.getBlocking()
+	if err != nil {
+		return err
+	}
+	defer p.putBlocking(conn)
+
+	var timeout time.Duration
+	if block > 0 {
+		timeout = block + blockingSlack
+	}
+	conn.DoWithTimeout(timeout, func(conn *redis.Client) { err = fn(conn) })
+	return err
+}
+
+// CmdBlocking is like Cmd, but sends the command using DoBlocking. block should
+// be the duration the command was told to block for.
+func (p *Pool) CmdBlocking(block time.Duration, cmd string, args ...interface{}) *redis.Resp {
+	var r *redis.Resp
+	err := p.DoBlocking(block, func(conn *redis.Client) error {
+		r = conn.Cmd(cmd, args...)
+		return nil
+	})
+	if err != nil {
+		return redis.NewResp(err)
+	}
+	return r
+}
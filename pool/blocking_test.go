@@ -0,0 +1,85 @@
+package pool
+
+import (
+	"net"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+func TestCmdBlocking(t *T) {
+	ps := newPongServer(t)
+	defer ps.Close()
+	p, err := NewWithOpts(Opts{
+		Network:      "tcp",
+		Addr:         ps.Addr().String(),
+		Size:         1,
+		BlockingSize: 1,
+	})
+	require.Nil(t, err)
+	defer p.Close()
+
+	s, err := p.CmdBlocking(time.Second, "BLPOP", "foo", 1).Str()
+	require.Nil(t, err)
+	assert.Equal(t, "PONG", s)
+
+	// the connection is kept for the next blocking command, rather than
+	// being put in the pool
+	stats := p.Stats()
+	assert.Equal(t, 1, stats.BlockingIdle)
+	assert.Equal(t, 0, stats.BlockingActive)
+	assert.Equal(t, 1, stats.Idle)
+	assert.Equal(t, int64(0), stats.Gets)
+	dials := stats.Dials
+
+	require.Nil(t, p.DoBlocking(time.Second, func(conn *redis.Client) error {
+		assert.Equal(t, 1, p.Stats().BlockingActive)
+		return conn.Cmd("BLPOP", "foo", 1).Err
+	}))
+	assert.Equal(t, dials, p.Stats().Dials)
+
+	p.Close()
+	assert.Equal(t, 0, p.Stats().BlockingIdle)
+	assert.Equal(t, ErrClosed, p.CmdBlocking(time.Second, "BLPOP", "foo", 1).Err)
+}
+
+func TestCmdBlockingTimeout(t *T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				rr := redis.NewRespReader(conn)
+				for !rr.Read().IsType(redis.IOErr) {
+					time.Sleep(200 * time.Millisecond)
+					redis.NewResp(nil).WriteTo(conn)
+				}
+			}()
+		}
+	}()
+
+	p, err := NewWithOpts(Opts{
+		Network:  "tcp",
+		Addr:     l.Addr().String(),
+		Size:     1,
+		DialOpts: redis.DialOpts{Timeout: 50 * time.Millisecond},
+	})
+	require.Nil(t, err)
+	defer p.Close()
+
+	// the reply takes longer than the connection's timeout, but not longer
+	// than the block
+	r := p.CmdBlocking(100*time.Millisecond, "BLPOP", "foo", "0.1")
+	require.Nil(t, r.Err)
+	assert.True(t, r.IsType(redis.Nil))
+}
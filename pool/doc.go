@@ -72,4 +72,62 @@
 //		return client, nil
 //	}
 //	p, err := pool.NewCustom("tcp", "127.0.0.1:6379", 10, df)
+//
+// Limiting connections
+//
+// By default a Pool makes a new connection whenever Get is called and there
+// are none idle, so a burst of traffic can open any number of connections. The
+// MaxActive option of NewWithOpts puts a hard limit on the number of
+// connections which are gotten at once, with Gets beyond that waiting in line
+// for a connection to be Put back
+//
+//	p, err := pool.NewWithOpts(pool.Opts{
+//		Network:    "tcp",
+//		Addr:       "127.0.0.1:6379",
+//		MaxActive:  50,
+//		GetTimeout: time.Second,
+//	})
+//
+// The Stats method shows how many Gets are waiting, and for how long.
+//
+// Pipelining
+//
+// When there are lots of small commands coming from many go-routines at once,
+// most of the time each one takes is its round trip. Setting PipelineWindow
+// makes Cmd send them all over one shared connection instead, pipelined
+// together in batches, which gets more done with far fewer connections
+//
+//	p, err := pool.NewWithOpts(pool.Opts{
+//		Network:        "tcp",
+//		Addr:           "127.0.0.1:6379",
+//		PipelineWindow: 150 * time.Microsecond,
+//	})
+//
+// Only Cmd does this. Connections gotten with Get are the same as ever, and
+// are still needed for anything which uses more than one command. If one
+// shared connection isn't enough PipelineConns spreads the commands over
+// more.
+//
+// A single go-routine with many commands to send can pipeline them itself
+// with a Pipeline, which sends them all over one connection from the Pool
+//
+//	pl := p.NewPipeline()
+//	pl.Append("SET", "foo", "bar")
+//	pl.AppendInto(&user, "HGETALL", "user:1")
+//
+//	if err := pl.Resp().Err; err != nil {
+//		// handle SET's error
+//	}
+//	if err := pl.Resp().Err; err != nil {
+//		// handle HGETALL's error, user has been filled otherwise
+//	}
+//
+// Blocking commands
+//
+// A blocking command like BLPOP can hold on to a connection for as long as it
+// blocks, and needs a longer read timeout than usual. CmdBlocking and
+// DoBlocking use a separate few connections for these, so that they don't use
+// up the Pool's
+//
+//	r := p.CmdBlocking(5*time.Second, "BLPOP", "jobs", 5)
 package pool
@@ -0,0 +1,73 @@
+package pool
+
+import (
+	"time"
+
+	"github.com/mediocregopher/radix.v2/log"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// idleConn is a connection sitting in the pool, along with when it was put
+// there
+type idleConn struct {
+	conn  *redis.Client
+	since time.Time
+}
+
+// discard closes a connection which failed a health check. Nothing else is
+// done, since a new connection is only made once one is needed.
+func (p *Pool) discard(conn *redis.Client, op string, err error) {
+	p.logger.Log(log.Debug, "discarded connection", log.KV{
+		log.KeyOperation: op,
+		log.KeyErr:       err,
+	})
+	conn.Close()
+}
+
+// pingSpin pings the idle connections every interval until the Pool is closed
+func (p *Pool) pingSpin(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			p.pingIdle(interval)
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// pingIdle takes each of the connections which are idle at the start out of
+// the pool in turn, and PINGs it if it has been idle for at least the given
+// duration. Those which are fine are put back at the end of the pool.
+func (p *Pool) pingIdle(minIdle time.Duration) {
+	for n := len(p.pool); n > 0; n-- {
+		var ic idleConn
+		select {
+		case ic = <-p.pool:
+		default:
+			return
+		}
+
+		if time.Since(ic.since) >= minIdle {
+			if err := ic.conn.Cmd("PING").Err; err != nil {
+				p.discard(ic.conn, "ping", err)
+				continue
+			}
+			ic.since = time.Now()
+		}
+
+		select {
+		case p.pool <- ic:
+		default:
+			// the pool was filled back up by Puts in the meantime
+			ic.conn.Close()
+		}
+	}
+
+	// as in Put, the Pool may have been closed while a connection was out
+	if p.isClosed() {
+		p.Empty()
+	}
+}
@@ -0,0 +1,138 @@
+package pool
+
+import (
+	"errors"
+	"net"
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/log"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pongServer replies to every command with PONG, and can close every
+// connection which has been made to it
+type pongServer struct {
+	net.Listener
+	l     sync.Mutex
+	conns []net.Conn
+}
+
+func newPongServer(t TB) *pongServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	ps := &pongServer{Listener: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			ps.l.Lock()
+			ps.conns = append(ps.conns, conn)
+			ps.l.Unlock()
+			go func() {
+				rr := redis.NewRespReader(conn)
+				for !rr.Read().IsType(redis.IOErr) {
+					redis.NewResp("PONG").WriteTo(conn)
+				}
+			}()
+		}
+	}()
+	return ps
+}
+
+func (ps *pongServer) killAll() {
+	ps.l.Lock()
+	defer ps.l.Unlock()
+	for _, conn := range ps.conns {
+		conn.Close()
+	}
+	ps.conns = nil
+}
+
+func TestTestOnBorrow(t *T) {
+	ps := newPongServer(t)
+	defer ps.Close()
+
+	var calls int
+	start := time.Now()
+	p, err := NewWithOpts(Opts{
+		Network: "tcp",
+		Addr:    ps.Addr().String(),
+		Size:    2,
+		TestOnBorrow: func(conn *redis.Client, idleSince time.Time) error {
+			assert.False(t, idleSince.Before(start))
+			if calls++; calls == 1 {
+				return errors.New("stale")
+			}
+			return conn.Cmd("PING").Err
+		},
+	})
+	require.Nil(t, err)
+	defer p.Close()
+
+	// the first idle connection fails and is thrown away, the second is used
+	conn, err := p.Get()
+	require.Nil(t, err)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, 0, p.Avail())
+
+	// with no idle connections left a new one is made, without being tested
+	conn2, err := p.Get()
+	require.Nil(t, err)
+	assert.Equal(t, 2, calls)
+	p.Put(conn)
+	p.Put(conn2)
+	assert.Equal(t, 2, p.Avail())
+}
+
+func TestPingInterval(t *T) {
+	ps := newPongServer(t)
+	defer ps.Close()
+	rec := new(log.Recorder)
+	p, err := NewWithOpts(Opts{
+		Network:      "tcp",
+		Addr:         ps.Addr().String(),
+		Size:         2,
+		PingInterval: 20 * time.Millisecond,
+		DialOpts:     redis.DialOpts{Timeout: time.Second},
+		Logger:       rec,
+	})
+	require.Nil(t, err)
+	defer p.Close()
+
+	// connections which are fine are left in the pool, although one may be
+	// out of it briefly while it's being pinged
+	time.Sleep(100 * time.Millisecond)
+	waitAvail(t, p, 2)
+	assert.Empty(t, rec.Entries(""))
+
+	// dead ones are thrown away, and not replaced until they're needed
+	ps.killAll()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(rec.Entries("discarded connection")) < 2 {
+		require.True(t, time.Now().Before(deadline), "timed out")
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, 0, p.Avail())
+	ee := rec.Entries("discarded connection")
+	require.Len(t, ee, 2)
+	assert.Equal(t, "ping", ee[0].KV[log.KeyOperation])
+
+	conn, err := p.Get()
+	require.Nil(t, err)
+	assert.Nil(t, conn.Cmd("PING").Err)
+	p.Put(conn)
+}
+
+func waitAvail(t *T, p *Pool, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Avail() != n {
+		require.True(t, time.Now().Before(deadline), "timed out")
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,123 @@
+package pool
+
+import (
+	"context"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// Pipeline is like redis.Pipeline, but for a Pool. Commands are buffered until
+// Flush, at which point a single connection is gotten from the Pool, all of
+// the commands are written to it at once, all of their responses are read,
+// and the connection is put back. Resp returns the responses in the order the
+// commands were appended.
+//
+// Each command gets its own response, so one failing (e.g. with WRONGTYPE)
+// doesn't affect the rest. If the connection fails part way through reading
+// the responses that command and those after it get the IOErr, and the
+// connection is closed rather than being put back.
+//
+// Like redis.Pipeline, Pipeline isn't safe to use from multiple go-routines at
+// once.
+type Pipeline struct {
+	p         *Pool
+	cmds      []pipeCmd
+	completed []*redis.Resp
+}
+
+// pipeCmd is a command appended to a Pipeline, along with what its response
+// should be decoded into, if anything
+type pipeCmd struct {
+	cmd  string
+	args []interface{}
+	into interface{}
+}
+
+// NewPipeline returns a Pipeline which sends its commands over a connection
+// from the Pool
+func (p *Pool) NewPipeline() *Pipeline {
+	return &Pipeline{p: p}
+}
+
+// Append adds the given command to the pipeline
+func (pl *Pipeline) Append(cmd string, args ...interface{}) {
+	pl.cmds = append(pl.cmds, pipeCmd{cmd: cmd, args: args})
+}
+
+// AppendInto is like Append, but the command's response is also decoded into
+// v, using the response's Into, once it's been read. If the response is an
+// error, or can't be decoded, v is left alone and the command's response is
+// an AppErr with that error.
+func (pl *Pipeline) AppendInto(v interface{}, cmd string, args ...interface{}) {
+	pl.cmds = append(pl.cmds, pipeCmd{cmd: cmd, args: args, into: v})
+}
+
+// Flush sends every command which has been appended since the last Flush, and
+// reads all of their responses. If a connection can't be gotten from the Pool
+// every command gets the error from Get.
+func (pl *Pipeline) Flush() {
+	pl.FlushCtx(context.Background())
+}
+
+// FlushCtx is like Flush, but the connection is gotten with GetCtx and used
+// with DoCtx, so that sending the commands and reading their responses is given
+// up on if the context is done first. Every command whose response wasn't read
+// by then gets an IOErr with the context's error, and the connection is closed
+// rather than being put back.
+func (pl *Pipeline) FlushCtx(ctx context.Context) {
+	if len(pl.cmds) == 0 {
+		return
+	}
+	cmds := pl.cmds
+	pl.cmds = nil
+
+	conn, err := pl.p.GetCtx(ctx)
+	if err != nil {
+		for range cmds {
+			pl.completed = append(pl.completed, redis.NewResp(err))
+		}
+		return
+	}
+	// Put closes the connection if it failed
+	defer pl.p.Put(conn)
+
+	var rr []*redis.Resp
+	err = conn.DoCtx(ctx, func(conn *redis.Client) {
+		rp := redis.NewPipeline(conn, redis.PipelineOpts{})
+		for _, pc := range cmds {
+			rp.Append(pc.cmd, pc.args...)
+		}
+		for range cmds {
+			rr = append(rr, rp.Resp())
+		}
+	})
+	for i, pc := range cmds {
+		if err != nil && (i >= len(rr) || rr[i].IsType(redis.IOErr)) {
+			pl.completed = append(pl.completed, redis.NewRespIOErr(err))
+			continue
+		}
+		r := rr[i]
+		if pc.into != nil && r.Err == nil {
+			if err := r.Into(pc.into); err != nil {
+				r = redis.NewResp(err)
+			}
+		}
+		pl.completed = append(pl.completed, r)
+	}
+}
+
+// Resp returns the response for the next command appended to the pipeline,
+// calling Flush first if it hasn't been sent yet. A Resp with an Err of
+// redis.ErrPipelineEmpty is returned if there are no more responses.
+func (pl *Pipeline) Resp() *redis.Resp {
+	if len(pl.completed) == 0 {
+		pl.Flush()
+	}
+	if len(pl.completed) == 0 {
+		return redis.NewResp(redis.ErrPipelineEmpty)
+	}
+	r := pl.completed[0]
+	pl.completed[0] = nil
+	pl.completed = pl.completed[1:]
+	return r
+}
@@ -0,0 +1,176 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hashServer replies to HGETALL with a hash, to WRONG with a WRONGTYPE error,
+// and to anything else with OK, except KILL which closes the connection
+func hashServer(t *T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				rr := redis.NewRespReader(conn)
+				for {
+					args, err := rr.Read().List()
+					if err != nil {
+						return
+					}
+					var r *redis.Resp
+					switch strings.ToUpper(args[0]) {
+					case "HGETALL":
+						r = redis.NewResp([]interface{}{"name", "bob", "age", 30})
+					case "WRONG":
+						r = redis.NewResp(errors.New("WRONGTYPE wrong kind of value"))
+					case "KILL":
+						return
+					default:
+						r = redis.NewRespSimple("OK")
+					}
+					r.WriteTo(conn)
+				}
+			}()
+		}
+	}()
+	return l
+}
+
+func TestPoolPipeline(t *T) {
+	l := hashServer(t)
+	defer l.Close()
+	p, err := New("tcp", l.Addr().String(), 1)
+	require.Nil(t, err)
+	defer p.Close()
+
+	var user struct {
+		Name string `redis:"name"`
+		Age  int    `redis:"age"`
+	}
+	var bad map[string]int
+	pl := p.NewPipeline()
+	pl.Append("SET", "foo", "bar")
+	pl.AppendInto(&user, "HGETALL", "user")
+	pl.Append("WRONG")
+	pl.AppendInto(&bad, "HGETALL", "user")
+	pl.Append("GET", "foo")
+
+	// nothing is sent until the first response is asked for
+	assert.Equal(t, 1, p.Avail())
+	s, err := pl.Resp().Str()
+	require.Nil(t, err)
+	assert.Equal(t, "OK", s)
+	assert.Equal(t, 1, p.Avail())
+
+	r := pl.Resp()
+	require.Nil(t, r.Err)
+	assert.Equal(t, "bob", user.Name)
+	assert.Equal(t, 30, user.Age)
+
+	r = pl.Resp()
+	assert.True(t, r.IsType(redis.AppErr))
+	assert.True(t, strings.HasPrefix(r.Err.Error(), "WRONGTYPE"))
+
+	// "bob" can't be decoded as an int
+	r = pl.Resp()
+	assert.True(t, r.IsType(redis.AppErr))
+
+	require.Nil(t, pl.Resp().Err)
+	assert.Equal(t, redis.ErrPipelineEmpty, pl.Resp().Err)
+
+	// once the connection fails the rest of the commands get the IOErr, and
+	// the connection isn't put back
+	pl.Append("SET", "foo", "bar")
+	pl.Append("KILL")
+	pl.Append("GET", "foo")
+	require.Nil(t, pl.Resp().Err)
+	assert.True(t, pl.Resp().IsType(redis.IOErr))
+	assert.True(t, pl.Resp().IsType(redis.IOErr))
+	assert.Equal(t, 0, p.Avail())
+
+	p.Close()
+	pl.Append("GET", "foo")
+	assert.Equal(t, ErrClosed, pl.Resp().Err)
+}
+
+func TestPoolTrace(t *T) {
+	l := hashServer(t)
+	defer l.Close()
+
+	var l2 sync.Mutex
+	var connects, gets int
+	var cmds []string
+	trace := &redis.Trace{
+		Connect: func(string, time.Duration, error) { l2.Lock(); connects++; l2.Unlock() },
+		CmdDone: func(cmd string, _ error, _ time.Duration) { l2.Lock(); cmds = append(cmds, cmd); l2.Unlock() },
+		PoolGet: func(time.Duration, error) { l2.Lock(); gets++; l2.Unlock() },
+	}
+	p, err := NewWithOpts(Opts{Network: "tcp", Addr: l.Addr().String(), Size: 1, Trace: trace})
+	require.Nil(t, err)
+	defer p.Close()
+
+	require.Nil(t, p.Cmd("SET", "foo", "bar").Err)
+	pl := p.NewPipeline()
+	pl.Append("GET", "foo")
+	pl.Append("HGETALL", "user")
+	pl.Flush()
+
+	l2.Lock()
+	defer l2.Unlock()
+	assert.Equal(t, 1, connects)
+	assert.Equal(t, 2, gets)
+	assert.Equal(t, []string{"SET", "GET", "HGETALL"}, cmds)
+}
+
+func TestPoolPipelineCtx(t *T) {
+	l := hashServer(t)
+	defer l.Close()
+	p, err := NewWithOpts(Opts{Network: "tcp", Addr: l.Addr().String(), Size: 1})
+	require.Nil(t, err)
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pl := p.NewPipeline()
+	pl.Append("SET", "foo", "bar")
+	pl.Append("WRONG")
+	pl.FlushCtx(ctx)
+	assert.Nil(t, pl.Resp().Err)
+	assert.True(t, pl.Resp().IsType(redis.AppErr))
+	assert.Equal(t, 1, p.Avail())
+
+	// nothing answers, so every command gets the context's error and the
+	// connection isn't put back
+	fl, fp := newFakePool(t, Opts{Size: 1})
+	defer fl.Close()
+	defer fp.Close()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	pl = fp.NewPipeline()
+	pl.Append("GET", "foo")
+	pl.Append("GET", "bar")
+	pl.FlushCtx(ctx)
+	for i := 0; i < 2; i++ {
+		r := pl.Resp()
+		assert.True(t, r.IsType(redis.IOErr))
+		assert.Equal(t, context.DeadlineExceeded, r.Err)
+	}
+	assert.Equal(t, 0, fp.Avail())
+}
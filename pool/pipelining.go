@@ -0,0 +1,57 @@
+package pool
+
+import (
+	"sync/atomic"
+
+	"github.com/mediocregopher/radix.v2/log"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// pipeliner returns the next of the PipeliningClients which Cmd shares between
+// callers, making a new one if there isn't one yet or the last one's
+// connection failed
+func (p *Pool) pipeliner() (*redis.PipeliningClient, error) {
+	i := int(atomic.AddUint32(&p.pipeNext, 1) % uint32(len(p.pipes)))
+
+	p.pipeL.Lock()
+	defer p.pipeL.Unlock()
+	pipe := p.pipes[i]
+	if p.isClosed() {
+		return nil, ErrClosed
+	} else if pipe != nil && pipe.Err() == nil {
+		return pipe, nil
+	} else if pipe != nil {
+		p.logger.Log(log.Debug, "discarded connection", log.KV{
+			log.KeyOperation: "pipelining",
+			log.KeyErr:       pipe.Err(),
+		})
+		pipe.Close()
+		p.pipes[i] = nil
+	}
+
+	// The shared connections don't take a slot, so they're dialed directly
+	// rather than with dial
+	atomic.AddInt64(&p.stats.dials, 1)
+	conn, err := p.df(p.ctx, p.Network, p.Addr)
+	if err != nil {
+		atomic.AddInt64(&p.stats.dialErrors, 1)
+		if p.isClosed() {
+			return nil, ErrClosed
+		}
+		return nil, err
+	}
+	p.pipes[i] = redis.NewPipeliningClient(conn, *p.pipeOpts)
+	return p.pipes[i], nil
+}
+
+// closePipeliner is called by Close
+func (p *Pool) closePipeliner() {
+	p.pipeL.Lock()
+	defer p.pipeL.Unlock()
+	for i, pipe := range p.pipes {
+		if pipe != nil {
+			pipe.Close()
+			p.pipes[i] = nil
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package pool
+
+import (
+	"sort"
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipelining(t *T) {
+	ps := newPongServer(t)
+	defer ps.Close()
+	p, err := NewWithOpts(Opts{
+		Network:        "tcp",
+		Addr:           ps.Addr().String(),
+		Size:           1,
+		DialOpts:       redis.DialOpts{Timeout: 5 * time.Second},
+		PipelineWindow: time.Millisecond,
+	})
+	require.Nil(t, err)
+	defer p.Close()
+
+	// every go-routine's commands go over the one shared connection, without
+	// touching the pool
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				s, err := p.Cmd("PING").Str()
+				assert.Nil(t, err)
+				assert.Equal(t, "PONG", s)
+			}
+		}()
+	}
+	wg.Wait()
+	s := p.Stats()
+	assert.Equal(t, int64(0), s.Gets)
+	assert.Equal(t, int64(1), s.Dials)
+	assert.Equal(t, 1, s.Idle)
+
+	// commands which can't be pipelined use the pool as usual
+	require.Nil(t, p.Cmd("BLPOP", "foo", 0).Err)
+	assert.Equal(t, int64(1), p.Stats().Gets)
+
+	// once the shared connection fails a new one is made
+	ps.killAll()
+	assert.True(t, p.Cmd("PING").IsType(redis.IOErr))
+	require.Nil(t, p.Cmd("PING").Err)
+	assert.Equal(t, int64(2), p.Stats().Dials)
+
+	p.Close()
+	assert.Equal(t, ErrClosed, p.Cmd("PING").Err)
+}
+
+func TestPipeliningConns(t *T) {
+	ps := newPongServer(t)
+	defer ps.Close()
+	p, err := NewWithOpts(Opts{
+		Network:        "tcp",
+		Addr:           ps.Addr().String(),
+		Size:           1,
+		PipelineWindow: time.Millisecond,
+		PipelineConns:  2,
+	})
+	require.Nil(t, err)
+	defer p.Close()
+
+	// commands alternate between the shared connections
+	for i := 0; i < 4; i++ {
+		require.Nil(t, p.Cmd("PING").Err)
+	}
+	assert.Equal(t, int64(2), p.Stats().Dials)
+
+	// every command waiting on a failed connection gets its error, and only
+	// that connection is replaced
+	ps.killAll()
+	assert.True(t, p.Cmd("PING").IsType(redis.IOErr))
+	assert.True(t, p.Cmd("PING").IsType(redis.IOErr))
+	for i := 0; i < 4; i++ {
+		require.Nil(t, p.Cmd("PING").Err)
+	}
+	assert.Equal(t, int64(4), p.Stats().Dials)
+}
+
+// benchmarkCmd calls Cmd from many go-routines at once, as a busy server
+// would, and logs the 99th percentile latency and the throughput alongside the
+// usual time per command
+func benchmarkCmd(b *B, o Opts) {
+	ps := newPongServer(b)
+	defer ps.Close()
+	o.Network, o.Addr = "tcp", ps.Addr().String()
+	p, err := NewWithOpts(o)
+	require.Nil(b, err)
+	defer p.Close()
+
+	var l sync.Mutex
+	lats := make(durations, 0, b.N)
+	b.SetParallelism(16)
+	b.ResetTimer()
+	start := time.Now()
+	b.RunParallel(func(pb *PB) {
+		var local []time.Duration
+		for pb.Next() {
+			cmdStart := time.Now()
+			if err := p.Cmd("PING").Err; err != nil {
+				b.Fatal(err)
+			}
+			local = append(local, time.Since(cmdStart))
+		}
+		l.Lock()
+		lats = append(lats, local...)
+		l.Unlock()
+	})
+	took := time.Since(start)
+	b.StopTimer()
+
+	sort.Sort(lats)
+	b.Logf("p99: %s, ops/s: %.0f", lats.percentile(0.99), float64(len(lats))/took.Seconds())
+}
+
+func BenchmarkCmd(b *B) {
+	b.Run("checkout", func(b *B) {
+		benchmarkCmd(b, Opts{Size: 64})
+	})
+	b.Run("pipelined", func(b *B) {
+		benchmarkCmd(b, Opts{PipelineWindow: 150 * time.Microsecond})
+	})
+	b.Run("pipelined2", func(b *B) {
+		benchmarkCmd(b, Opts{PipelineWindow: 150 * time.Microsecond, PipelineConns: 2})
+	})
+}
@@ -1,16 +1,78 @@
 package pool
 
 import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/log"
 	"github.com/mediocregopher/radix.v2/redis"
 )
 
+var (
+	// ErrClosed is returned from Get once Close has been called on the Pool
+	ErrClosed = errors.New("pool is closed")
+
+	// ErrPoolExhausted is returned from Get when MaxActive connections are in
+	// use and none was Put back within GetTimeout
+	ErrPoolExhausted = errors.New("pool exhausted: timed out waiting for a connection")
+
+	// ErrCloseTimeout is returned from CloseWithTimeout when connections were
+	// still in use once the timeout was reached
+	ErrCloseTimeout = errors.New("timed out waiting for connections to be put back")
+)
+
 // Pool is a simple connection pool for redis Clients. It will create a small
 // pool of initial connections, and if more connections are needed they will be
 // created on demand. If a connection is Put back and the pool is full it will
 // be closed.
 type Pool struct {
-	pool chan *redis.Client
-	df   DialFunc
+	// stats is first so that its counters, which are updated atomically, are
+	// 64-bit aligned
+	stats poolStats
+
+	pool chan idleConn
+	df   DialCtxFunc
+
+	// ctx is cancelled when the Pool is closed, interrupting any connections
+	// being dialed
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	maxActive    int
+	getTimeout   time.Duration
+	testOnBorrow func(*redis.Client, time.Time) error
+	logger       log.Logger
+	trace        *redis.Trace
+
+	// pipeOpts is set if Cmd pipelines commands over pipes, which pipeL
+	// protects. pipeNext is incremented atomically to pick which one each
+	// command goes over.
+	pipeOpts *redis.PipeliningOpts
+	pipeNext uint32
+	pipeL    sync.Mutex
+	pipes    []*redis.PipeliningClient
+
+	// idle connections which are kept for blocking commands, see DoBlocking
+	blocking chan *redis.Client
+
+	// returned is signalled whenever a connection stops being used, so that
+	// CloseWithTimeout can check whether they all have
+	returned chan struct{}
+
+	// l protects everything below it
+	l sync.Mutex
+
+	// The number of connections which have been gotten and not yet Put back,
+	// including those which are still being dialed
+	active int
+
+	// Get calls waiting for a connection because MaxActive has been reached,
+	// oldest first
+	waiters *list.List
 
 	// The network/address that the pool is connecting to. These are going to be
 	// whatever was passed into the New function. These should not be
@@ -18,18 +80,175 @@ type Pool struct {
 	Network, Addr string
 }
 
+var _ redis.Cmder = &Pool{}
+
 // DialFunc is a function which can be passed into NewCustom
 type DialFunc func(network, addr string) (*redis.Client, error)
 
+// DialCtxFunc is like DialFunc, but takes a context which is cancelled if the
+// Pool is closed while the connection is being made
+type DialCtxFunc func(ctx context.Context, network, addr string) (*redis.Client, error)
+
+// Opts are the options which can be passed into NewWithOpts. Only Network and
+// Addr are required.
+type Opts struct {
+	Network, Addr string
+
+	// The maximum number of idle connections to have waiting to be used at
+	// any given moment, which are all created initially. Defaults to 10.
+	Size int
+
+	// The function used to create new connections. Defaults to using
+	// redis.DialCtx with DialOpts.
+	Dial DialCtxFunc
+
+	// The options used by the default Dial
+	DialOpts redis.DialOpts
+
+	// If set, at most this many connections may be gotten from the pool at
+	// once. Once the limit is reached Get blocks until a connection is Put
+	// back. Waiting Gets are served in the order they started waiting, and a
+	// connection which is Put back is handed straight to the one which has
+	// waited longest. If zero there is no limit, and Get creates a new
+	// connection whenever there are none available, as it does with New.
+	MaxActive int
+
+	// When MaxActive is set, the longest a Get will wait for a connection
+	// before returning ErrPoolExhausted. If zero Get waits forever, unless it
+	// was called with GetCtx and the context is cancelled.
+	GetTimeout time.Duration
+
+	// If set, idle connections which have been in the pool for at least this
+	// long are sent a PING in the background, once every PingInterval, and
+	// thrown away if it fails. The PING is only given up on when the
+	// connection's own timeout, from DialOpts or Dial, is reached.
+	PingInterval time.Duration
+
+	// If set, this is called with an idle connection before Get hands it out,
+	// along with when it was last known to be working, i.e. when it was put
+	// back in the pool or last sent a PING because of PingInterval. If it returns an error the
+	// connection is closed and the next idle one is tried, or a new one made
+	// if there are none left. For example, it could PING the connection if it
+	// has been idle for longer than the server's timeout.
+	TestOnBorrow func(conn *redis.Client, idleSince time.Time) error
+
+	// If set, Cmd doesn't get a connection of its own for each command, but
+	// instead pipelines commands from every go-routine over one shared
+	// connection, see redis.PipeliningClient. Each command waits up to this
+	// long to be sent with others, or until PipelineLimit commands are waiting
+	// (defaulting to 128). Commands which can't be pipelined, according to
+	// redis.IsPipelinable, are done as usual on a connection gotten from the
+	// pool, as is everything done through Get. The shared connection is one
+	// more than MaxActive, if that's set.
+	//
+	// If the shared connection fails then every command waiting on it gets
+	// the IOErr, and the next command makes a new one.
+	PipelineWindow time.Duration
+	PipelineLimit  int
+
+	// The number of shared connections used when PipelineWindow is set, with
+	// each command going over the next one in turn. This can help when a
+	// single connection's batches take long enough to read that commands are
+	// kept waiting for them. Defaults to 1.
+	PipelineConns int
+
+	// The most idle connections to keep for DoBlocking and CmdBlocking, which
+	// are separate from the pool's. They're made as they're needed rather
+	// than initially, and aren't counted towards MaxActive. Defaults to 2.
+	BlockingSize int
+
+	// Used to log connections being thrown away. Entries have the component
+	// "pool" and the Pool's Addr. It's also used for the default Dial if
+	// DialOpts doesn't have a Logger of its own. Defaults to log.Nop.
+	Logger log.Logger
+
+	// If set, its PoolGet is called for every Get, and it's set as the Trace
+	// of every connection the Pool makes, unless DialOpts or Dial gave it one
+	// already. See redis.Trace.
+	Trace *redis.Trace
+}
+
 // NewCustom is like New except you can specify a DialFunc which will be
 // used when creating new connections for the pool. The common use-case is to do
 // authentication for new connections.
 func NewCustom(network, addr string, size int, df DialFunc) (*Pool, error) {
+	return newPool(Opts{
+		Network: network,
+		Addr:    addr,
+		Size:    size,
+		Dial: func(_ context.Context, network, addr string) (*redis.Client, error) {
+			return df(network, addr)
+		},
+	})
+}
+
+// New creates a new Pool whose connections are all created using
+// redis.Dial(network, addr). The size indicates the maximum number of idle
+// connections to have waiting to be used at any given moment. If an error is
+// encountered an empty (but still usable) pool is returned alongside that error
+func New(network, addr string, size int) (*Pool, error) {
+	return newPool(Opts{Network: network, Addr: addr, Size: size, Dial: dialCtx(redis.DialOpts{})})
+}
+
+// NewWithOpts is like New, but with more fine-tuned configuration options. See
+// Opts for the available options. As with New, if an error is encountered
+// creating the initial connections an empty (but still usable) pool is
+// returned alongside that error.
+func NewWithOpts(o Opts) (*Pool, error) {
+	if o.Size == 0 {
+		o.Size = 10
+	}
+	if o.PipelineConns == 0 {
+		o.PipelineConns = 1
+	}
+	if o.BlockingSize == 0 {
+		o.BlockingSize = 2
+	}
+	if o.Dial == nil {
+		if o.DialOpts.Logger == nil {
+			o.DialOpts.Logger = o.Logger
+		}
+		if o.DialOpts.Trace == nil {
+			o.DialOpts.Trace = o.Trace
+		}
+		o.Dial = dialCtx(o.DialOpts)
+	} else if o.Trace != nil {
+		o.Dial = traceDial(o.Dial, o.Trace)
+	}
+	return newPool(o)
+}
+
+// traceDial wraps a DialCtxFunc so that connections it makes are traced, as
+// DialCtx would do if given the Trace
+func traceDial(df DialCtxFunc, t *redis.Trace) DialCtxFunc {
+	return func(ctx context.Context, network, addr string) (*redis.Client, error) {
+		start := time.Now()
+		conn, err := df(ctx, network, addr)
+		if t.Connect != nil {
+			t.Connect(addr, time.Since(start), err)
+		}
+		if err == nil && conn.Trace() == nil {
+			conn.SetTrace(t)
+		}
+		return conn, err
+	}
+}
+
+func dialCtx(do redis.DialOpts) DialCtxFunc {
+	return func(ctx context.Context, network, addr string) (*redis.Client, error) {
+		return redis.DialCtx(ctx, network, addr, do)
+	}
+}
+
+// newPool creates the Pool and its initial connections. Unlike NewWithOpts it
+// doesn't apply any defaults.
+func newPool(o Opts) (*Pool, error) {
+	ctx, cancel := context.WithCancel(context.Background())
 	var client *redis.Client
 	var err error
-	pool := make([]*redis.Client, 0, size)
-	for i := 0; i < size; i++ {
-		client, err = df(network, addr)
+	pool := make([]*redis.Client, 0, o.Size)
+	for i := 0; i < o.Size; i++ {
+		client, err = o.Dial(ctx, o.Network, o.Addr)
 		if err != nil {
 			for _, client = range pool {
 				client.Close()
@@ -40,52 +259,283 @@ func NewCustom(network, addr string, size int, df DialFunc) (*Pool, error) {
 		pool = append(pool, client)
 	}
 	p := Pool{
-		Network: network,
-		Addr:    addr,
-		pool:    make(chan *redis.Client, len(pool)),
-		df:      df,
+		Network:      o.Network,
+		Addr:         o.Addr,
+		pool:         make(chan idleConn, len(pool)),
+		df:           o.Dial,
+		ctx:          ctx,
+		cancel:       cancel,
+		maxActive:    o.MaxActive,
+		getTimeout:   o.GetTimeout,
+		testOnBorrow: o.TestOnBorrow,
+		trace:        o.Trace,
+		logger:       log.With(o.Logger, log.KV{log.KeyComponent: "pool", log.KeyAddr: o.Addr}),
+		waiters:      list.New(),
+		blocking:     make(chan *redis.Client, o.BlockingSize),
+		returned:     make(chan struct{}, 1),
 	}
+	if o.PipelineWindow > 0 {
+		p.pipeOpts = &redis.PipeliningOpts{Window: o.PipelineWindow, Limit: o.PipelineLimit}
+		p.pipes = make([]*redis.PipeliningClient, o.PipelineConns)
+	}
+	now := time.Now()
 	for i := range pool {
-		p.pool <- pool[i]
+		p.pool <- idleConn{pool[i], now}
+	}
+	if o.PingInterval > 0 {
+		go p.pingSpin(o.PingInterval)
 	}
 	return &p, err
 }
 
-// New creates a new Pool whose connections are all created using
-// redis.Dial(network, addr). The size indicates the maximum number of idle
-// connections to have waiting to be used at any given moment. If an error is
-// encountered an empty (but still usable) pool is returned alongside that error
-func New(network, addr string, size int) (*Pool, error) {
-	return NewCustom(network, addr, size, redis.Dial)
-}
-
 // Get retrieves an available redis client. If there are none available it will
-// create a new one on the fly
+// create a new one on the fly, unless MaxActive has been reached, in which case
+// it waits for one to be Put back.
 func (p *Pool) Get() (*redis.Client, error) {
+	return p.GetCtx(context.Background())
+}
+
+// waiter is a Get call waiting in line for a connection. It will be sent
+// either a connection which was Put back, or nil meaning a slot has become
+// free and it should make a new connection.
+type waiter struct {
+	ch chan *redis.Client
+}
+
+// GetCtx is like Get, but if it has to wait for a connection because
+// MaxActive has been reached it gives up once the context is done, returning
+// the context's error. The context also cancels making a new connection.
+func (p *Pool) GetCtx(ctx context.Context) (*redis.Client, error) {
+	if p.trace == nil || p.trace.PoolGet == nil {
+		return p.getCtx(ctx)
+	}
+	start := time.Now()
+	conn, err := p.getCtx(ctx)
+	p.trace.PoolGet(time.Since(start), err)
+	return conn, err
+}
+
+func (p *Pool) getCtx(ctx context.Context) (*redis.Client, error) {
+	start := time.Now()
+	p.l.Lock()
+	if p.isClosed() {
+		p.l.Unlock()
+		return nil, ErrClosed
+	}
+	atomic.AddInt64(&p.stats.gets, 1)
+
+	// New Gets wait behind any which are already waiting, even if a slot is
+	// free, so that they can't jump the line
+	if p.maxActive <= 0 || (p.active < p.maxActive && p.waiters.Len() == 0) {
+		p.active++
+		p.l.Unlock()
+		return p.getOrDial(ctx)
+	}
+
+	w := &waiter{ch: make(chan *redis.Client, 1)}
+	el := p.waiters.PushBack(w)
+	p.l.Unlock()
+
+	var timeoutCh <-chan time.Time
+	if p.getTimeout > 0 {
+		t := time.NewTimer(p.getTimeout)
+		defer t.Stop()
+		timeoutCh = t.C
+	}
+
+	var err error
 	select {
-	case conn := <-p.pool:
+	case conn := <-w.ch:
+		return p.handedOff(ctx, conn, start)
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timeoutCh:
+		err = ErrPoolExhausted
+	case <-p.ctx.Done():
+		err = ErrClosed
+	}
+
+	p.l.Lock()
+	select {
+	case conn := <-w.ch:
+		// Something was handed off to this waiter at the same time as it gave
+		// up, take it rather than leaking it
+		p.l.Unlock()
+		return p.handedOff(ctx, conn, start)
+	default:
+	}
+	p.waiters.Remove(el)
+	if err == ErrPoolExhausted {
+		atomic.AddInt64(&p.stats.waitTimeouts, 1)
+	}
+	p.stats.recordWait(time.Since(start))
+	p.l.Unlock()
+	return nil, err
+}
+
+// handedOff is called by a waiter with whatever was handed to it
+func (p *Pool) handedOff(ctx context.Context, conn *redis.Client, start time.Time) (*redis.Client, error) {
+	p.l.Lock()
+	p.stats.recordWait(time.Since(start))
+	p.l.Unlock()
+	if conn != nil {
 		return conn, nil
+	}
+	return p.dial(ctx)
+}
+
+// getOrDial is called once a slot has been taken, and returns an idle
+// connection which passes TestOnBorrow, or dials a new one
+func (p *Pool) getOrDial(ctx context.Context) (*redis.Client, error) {
+	for {
+		select {
+		case ic := <-p.pool:
+			if p.testOnBorrow == nil {
+				return ic.conn, nil
+			} else if err := p.testOnBorrow(ic.conn, ic.since); err != nil {
+				p.discard(ic.conn, "borrow", err)
+				continue
+			}
+			return ic.conn, nil
+		default:
+			return p.dial(ctx)
+		}
+	}
+}
+
+// dial creates a new connection using a slot which has already been taken.
+// If it fails the slot is released.
+func (p *Pool) dial(ctx context.Context) (*redis.Client, error) {
+	// The connection is cancelled if either the given context or the Pool's
+	// is done
+	dialCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-p.ctx.Done():
+			cancel()
+		case <-stop:
+		}
+	}()
+
+	atomic.AddInt64(&p.stats.dials, 1)
+	conn, err := p.df(dialCtx, p.Network, p.Addr)
+	if err != nil {
+		atomic.AddInt64(&p.stats.dialErrors, 1)
+		p.release()
+		if p.isClosed() {
+			return nil, ErrClosed
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// release gives up a slot, handing it to the oldest waiter if there is one
+func (p *Pool) release() {
+	p.l.Lock()
+	defer p.l.Unlock()
+	if !p.handOff(nil) && p.active > 0 {
+		p.active--
+		p.signalReturned()
+	}
+}
+
+func (p *Pool) signalReturned() {
+	select {
+	case p.returned <- struct{}{}:
 	default:
-		return p.df(p.Network, p.Addr)
+	}
+}
+
+// handOff gives the connection, or a free slot if it's nil, to the oldest
+// waiter. It returns false if there were no waiters. l must be held.
+func (p *Pool) handOff(conn *redis.Client) bool {
+	el := p.waiters.Front()
+	if el == nil {
+		return false
+	}
+	p.waiters.Remove(el)
+	el.Value.(*waiter).ch <- conn
+	return true
+}
+
+func (p *Pool) isClosed() bool {
+	select {
+	case <-p.ctx.Done():
+		return true
+	default:
+		return false
 	}
 }
 
 // Put returns a client back to the pool. If the pool is full the client is
 // closed instead. If the client is already closed (due to connection failure or
-// what-have-you) it will not be put back in the pool
+// what-have-you) it will not be put back in the pool, but its slot is still
+// freed up for others when MaxActive is set.
 func (p *Pool) Put(conn *redis.Client) {
-	if conn.LastCritical == nil {
-		select {
-		case p.pool <- conn:
-		default:
-			conn.Close()
-		}
+	if conn.LastCritical != nil {
+		p.logger.Log(log.Warn, "discarded connection", log.KV{
+			log.KeyOperation: "put",
+			log.KeyErr:       conn.LastCritical,
+		})
+		p.release()
+		return
+	} else if p.isClosed() {
+		p.release()
+		conn.Close()
+		return
+	}
+
+	p.l.Lock()
+	if p.handOff(conn) {
+		p.l.Unlock()
+		return
+	}
+	if p.active > 0 {
+		p.active--
+		p.signalReturned()
+	}
+	p.l.Unlock()
+
+	select {
+	case p.pool <- idleConn{conn, time.Now()}:
+	default:
+		p.logger.Log(log.Debug, "closed connection, pool is full", log.KV{
+			log.KeyOperation: "put",
+		})
+		conn.Close()
+	}
+
+	// If the Pool was closed while the client was being put back then it may
+	// have been missed by Close, so empty the pool again
+	if p.isClosed() {
+		p.Empty()
 	}
 }
 
 // Cmd automatically gets one client from the pool, executes the given command
-// (returning its result), and puts the client back in the pool
+// (returning its result), and puts the client back in the pool. If
+// PipelineWindow was set the command is pipelined with those of other callers
+// instead, if it can be.
 func (p *Pool) Cmd(cmd string, args ...interface{}) *redis.Resp {
+	if p.pipeOpts != nil && redis.IsPipelinable(cmd, args...) {
+		// counted before the pipeliner is gotten, so that CloseWithTimeout
+		// can't miss it
+		atomic.AddInt64(&p.stats.pipeActive, 1)
+		defer func() {
+			atomic.AddInt64(&p.stats.pipeActive, -1)
+			p.signalReturned()
+		}()
+		pc, err := p.pipeliner()
+		if err != nil {
+			return redis.NewResp(err)
+		}
+		return pc.Cmd(cmd, args...)
+	}
+
 	c, err := p.Get()
 	if err != nil {
 		return redis.NewResp(err)
@@ -95,21 +545,109 @@ func (p *Pool) Cmd(cmd string, args ...interface{}) *redis.Resp {
 	return c.Cmd(cmd, args...)
 }
 
+// CmdWithTimeout is like Cmd, but uses the Client's CmdWithTimeout, so the
+// given timeout is used for reading the reply rather than the connection's
+// read timeout, e.g. for a blocking command like BLPOP. The command is never
+// sent over the Pool's PipeliningClient. The connection's own timeout is used
+// again once it's been put back.
+func (p *Pool) CmdWithTimeout(timeout time.Duration, cmd string, args ...interface{}) *redis.Resp {
+	c, err := p.Get()
+	if err != nil {
+		return redis.NewResp(err)
+	}
+	defer p.Put(c)
+
+	return c.CmdWithTimeout(timeout, cmd, args...)
+}
+
+// DoCtx gets a client from the pool using GetCtx, calls DoCtx on it with fn,
+// and puts the client back. If the context is done before fn returns the
+// context's error is returned, and the client is closed rather than being put
+// back in the pool, so that the next user of it can't read a reply meant for
+// fn.
+func (p *Pool) DoCtx(ctx context.Context, fn func(*redis.Client)) error {
+	c, err := p.GetCtx(ctx)
+	if err != nil {
+		return err
+	}
+	defer p.Put(c)
+
+	return c.DoCtx(ctx, fn)
+}
+
+// CmdCtx is like Cmd, but uses DoCtx so that the command is given up on if the
+// context is done before its reply is read. It's never pipelined, since a
+// single command can't be given up on without breaking the rest of its batch.
+func (p *Pool) CmdCtx(ctx context.Context, cmd string, args ...interface{}) *redis.Resp {
+	c, err := p.GetCtx(ctx)
+	if err != nil {
+		return redis.NewResp(err)
+	}
+	defer p.Put(c)
+
+	return c.CmdCtx(ctx, cmd, args...)
+}
+
 // Empty removes and calls Close() on all the connections currently in the pool.
 // Assuming there are no other connections waiting to be Put back this method
 // effectively closes and cleans up the pool.
 func (p *Pool) Empty() {
-	var conn *redis.Client
 	for {
 		select {
-		case conn = <-p.pool:
-			conn.Close()
+		case ic := <-p.pool:
+			ic.conn.Close()
 		default:
 			return
 		}
 	}
 }
 
+// Close empties the pool and cancels any connections which are currently
+// being created by Get. Once closed Get will always return ErrClosed, and
+// connections which are Put back are closed.
+func (p *Pool) Close() {
+	p.CloseWithTimeout(0)
+}
+
+// CloseWithTimeout is like Close, but waits up to the given duration for the
+// connections which are in use to be Put back, and for any commands being
+// pipelined by Cmd or run by DoBlocking to finish, before closing the
+// connections Cmd pipelines over. Get returns ErrClosed as soon as it's called,
+// and idle connections are closed straight away, as are connections Put back
+// while it's waiting or after. It returns ErrCloseTimeout if connections were
+// still in use at the end of the duration.
+func (p *Pool) CloseWithTimeout(timeout time.Duration) error {
+	p.cancel()
+	p.Empty()
+	p.emptyBlocking()
+	defer p.closePipeliner()
+
+	if p.inUse() == 0 {
+		return nil
+	} else if timeout <= 0 {
+		return ErrCloseTimeout
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	for p.inUse() > 0 {
+		select {
+		case <-p.returned:
+		case <-t.C:
+			return ErrCloseTimeout
+		}
+	}
+	return nil
+}
+
+// inUse returns the number of connections which have been gotten and not Put
+// back, plus the number of commands being pipelined or run by DoBlocking
+func (p *Pool) inUse() int64 {
+	p.l.Lock()
+	n := int64(p.active)
+	p.l.Unlock()
+	return n + atomic.LoadInt64(&p.stats.pipeActive) + atomic.LoadInt64(&p.stats.blockingActive)
+}
+
 // Avail returns the number of connections currently available to be gotten from
 // the Pool using Get. If the number is zero then subsequent calls to Get will
 // be creating new connections on the fly
@@ -1,9 +1,15 @@
 package pool
 
 import (
+	"context"
+	"errors"
+	"net"
 	"sync"
 	. "testing"
+	"time"
 
+	"github.com/mediocregopher/radix.v2/log"
+	"github.com/mediocregopher/radix.v2/redis"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -71,3 +77,261 @@ func TestPut(t *T) {
 	// network error
 	assert.Equal(t, 9, len(pool.pool))
 }
+
+func TestPoolClose(t *T) {
+	// The first dial fails so that the pool starts out empty, every one after
+	// that blocks until it's cancelled
+	errFirst := errors.New("first dial")
+	var dials int
+	dialing := make(chan struct{})
+	df := func(ctx context.Context, network, addr string) (*redis.Client, error) {
+		if dials++; dials == 1 {
+			return nil, errFirst
+		}
+		close(dialing)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	pool, err := NewWithOpts(Opts{Network: "tcp", Addr: "localhost:6379", Dial: df})
+	require.Equal(t, errFirst, err)
+
+	errCh := make(chan error)
+	go func() {
+		_, err := pool.Get()
+		errCh <- err
+	}()
+	<-dialing
+	pool.Close()
+	assert.Equal(t, ErrClosed, <-errCh)
+
+	_, err = pool.Get()
+	assert.Equal(t, ErrClosed, err)
+}
+
+func TestCloseWithTimeout(t *T) {
+	ps := newPongServer(t)
+	defer ps.Close()
+	p, err := NewWithOpts(Opts{Network: "tcp", Addr: ps.Addr().String(), Size: 1})
+	require.Nil(t, err)
+
+	conn, err := p.Get()
+	require.Nil(t, err)
+	closed := make(chan error)
+	go func() { closed <- p.CloseWithTimeout(5 * time.Second) }()
+	time.Sleep(50 * time.Millisecond)
+
+	// no more connections are handed out, but the one in use still works
+	// until it's put back
+	_, err = p.Get()
+	assert.Equal(t, ErrClosed, err)
+	require.Nil(t, conn.Cmd("PING").Err)
+	select {
+	case <-closed:
+		t.Fatal("CloseWithTimeout returned before the connection was put back")
+	default:
+	}
+	p.Put(conn)
+	assert.Nil(t, <-closed)
+	assert.NotNil(t, conn.Cmd("PING").Err)
+
+	// a connection which isn't put back in time is closed when it is
+	p, err = NewWithOpts(Opts{Network: "tcp", Addr: ps.Addr().String(), Size: 1})
+	require.Nil(t, err)
+	conn, err = p.Get()
+	require.Nil(t, err)
+	assert.Equal(t, ErrCloseTimeout, p.CloseWithTimeout(50*time.Millisecond))
+	p.Put(conn)
+	assert.NotNil(t, conn.Cmd("PING").Err)
+	assert.Equal(t, 0, p.Stats().Active)
+}
+
+// fakeServer accepts connections and never replies to anything, so that a
+// Pool can be tested without a redis instance when no commands are run
+func fakeServer(t *T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			// kept open until the test ends
+			defer conn.Close()
+		}
+	}()
+	return l
+}
+
+func newFakePool(t *T, o Opts) (net.Listener, *Pool) {
+	l := fakeServer(t)
+	o.Network, o.Addr = "tcp", l.Addr().String()
+	p, err := NewWithOpts(o)
+	require.Nil(t, err)
+	return l, p
+}
+
+func TestMaxActive(t *T) {
+	l, p := newFakePool(t, Opts{Size: 1, MaxActive: 2, GetTimeout: 50 * time.Millisecond})
+	defer l.Close()
+	defer p.Close()
+
+	c1, err := p.Get()
+	require.Nil(t, err)
+	c2, err := p.Get()
+	require.Nil(t, err)
+	assert.Equal(t, 2, p.Stats().Active)
+
+	start := time.Now()
+	_, err = p.Get()
+	assert.Equal(t, ErrPoolExhausted, err)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	assert.Equal(t, int64(1), p.Stats().WaitTimeouts)
+
+	// A waiter is handed the connection which is Put back
+	connCh := make(chan *redis.Client)
+	go func() {
+		c, err := p.Get()
+		assert.Nil(t, err)
+		connCh <- c
+	}()
+	for p.Stats().Waiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	p.Put(c1)
+	assert.True(t, c1 == <-connCh)
+
+	// A bad connection still frees its slot, and the waiter makes a new one
+	go func() {
+		c, err := p.Get()
+		assert.Nil(t, err)
+		connCh <- c
+	}()
+	for p.Stats().Waiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	c2.LastCritical = errors.New("broken")
+	p.Put(c2)
+	c3 := <-connCh
+	assert.False(t, c2 == c3)
+
+	p.Put(c1)
+	p.Put(c3)
+	s := p.Stats()
+	assert.Equal(t, 0, s.Active)
+	assert.Equal(t, 0, s.Waiting)
+	assert.Equal(t, int64(5), s.Gets)
+	assert.Equal(t, int64(3), s.Waits)
+}
+
+func TestGetCtx(t *T) {
+	l, p := newFakePool(t, Opts{Size: 1, MaxActive: 1})
+	defer l.Close()
+
+	c, err := p.Get()
+	require.Nil(t, err)
+
+	// A cancelled waiter is removed from the line
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = p.GetCtx(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 0, p.Stats().Waiting)
+
+	// and so the slot goes to the next waiter, not the cancelled one
+	errCh := make(chan error)
+	go func() {
+		c, err := p.Get()
+		if err == nil {
+			p.Put(c)
+		}
+		errCh <- err
+	}()
+	for p.Stats().Waiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	p.Put(c)
+	assert.Nil(t, <-errCh)
+
+	// Closing the pool wakes up waiters
+	c, err = p.Get()
+	require.Nil(t, err)
+	go func() {
+		_, err := p.Get()
+		errCh <- err
+	}()
+	for p.Stats().Waiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	p.Close()
+	assert.Equal(t, ErrClosed, <-errCh)
+	p.Put(c)
+	assert.Equal(t, 0, p.Stats().Active)
+}
+
+func TestPoolFairness(t *T) {
+	l, p := newFakePool(t, Opts{Size: 4, MaxActive: 4})
+	defer l.Close()
+	defer p.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				c, err := p.Get()
+				if !assert.Nil(t, err) {
+					return
+				}
+				time.Sleep(2 * time.Millisecond)
+				p.Put(c)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// With waiters served in order every Get waits roughly as long as it
+	// takes for everyone ahead of it to have a turn, so the slowest shouldn't
+	// be far off the median
+	s := p.Stats()
+	t.Logf("p50:%v p90:%v p99:%v max:%v", s.WaitP50, s.WaitP90, s.WaitP99, s.WaitMax)
+	assert.True(t, s.WaitP50 > 0)
+	assert.True(t, s.WaitMax < 3*s.WaitP50, "p50:%v max:%v", s.WaitP50, s.WaitMax)
+}
+
+func TestCmdCtx(t *T) {
+	l, p := newFakePool(t, Opts{Size: 1})
+	defer l.Close()
+	defer p.Close()
+	assert.Equal(t, 1, p.Avail())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	r := p.CmdCtx(ctx, "PING")
+	assert.Equal(t, context.DeadlineExceeded, r.Err)
+
+	// the interrupted connection wasn't put back
+	assert.Equal(t, 0, p.Avail())
+}
+
+func TestPoolLogger(t *T) {
+	rec := new(log.Recorder)
+	l, p := newFakePool(t, Opts{Size: 1, Logger: rec})
+	defer l.Close()
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	p.CmdCtx(ctx, "PING")
+
+	ee := rec.Entries("")
+	require.Len(t, ee, 1)
+	assert.Equal(t, "discarded connection", ee[0].Msg)
+	assert.Equal(t, log.KV{
+		log.KeyComponent: "pool",
+		log.KeyAddr:      p.Addr,
+		log.KeyOperation: "put",
+		log.KeyErr:       context.DeadlineExceeded,
+	}, ee[0].KV)
+}
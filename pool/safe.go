@@ -0,0 +1,77 @@
+package pool
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// InsufficientReplicasError is returned from WaitReplicas, and so from DoSafe
+// and CmdSafe here and in sentinel, when fewer replicas than required
+// acknowledged the write before the timeout. The write has still been made,
+// it's just not known to be safe from a failover.
+type InsufficientReplicasError struct {
+	Acked, Wanted int
+}
+
+func (e *InsufficientReplicasError) Error() string {
+	return fmt.Sprintf("only %d of %d replicas acknowledged the write", e.Acked, e.Wanted)
+}
+
+// WaitReplicas calls WAIT on the connection, returning an
+// *InsufficientReplicasError if fewer than numReplicas replicas acknowledged
+// the writes made on it within the timeout. A timeout of zero waits forever.
+func WaitReplicas(conn redis.Cmder, numReplicas int, timeout time.Duration) error {
+	ms := int64(timeout / time.Millisecond)
+	if timeout > 0 && ms == 0 {
+		// WAIT's timeout of 0 means forever, which isn't what was asked for
+		ms = 1
+	}
+	acked, err := conn.Cmd("WAIT", numReplicas, ms).Int()
+	if err != nil {
+		return err
+	} else if acked < numReplicas {
+		return &InsufficientReplicasError{Acked: acked, Wanted: numReplicas}
+	}
+	return nil
+}
+
+// DoSafe gets a connection from the pool and calls fn with it. If fn returns
+// no error then WAIT is called on that same connection, so that DoSafe only
+// returns nil once at least numReplicas replicas have acknowledged every write
+// fn made. If they haven't within the timeout an *InsufficientReplicasError is
+// returned. A timeout of zero waits forever.
+//
+// The connection is put back once DoSafe is done, and fn must not use it after
+// returning. See sentinel's DoSafe for the same thing with failovers taken
+// into account.
+func (p *Pool) DoSafe(numReplicas int, timeout time.Duration, fn func(*redis.Client) error) error {
+	conn, err := p.Get()
+	if err != nil {
+		return err
+	}
+	defer p.Put(conn)
+
+	if err := fn(conn); err != nil {
+		return err
+	}
+	return WaitReplicas(conn, numReplicas, timeout)
+}
+
+// CmdSafe is like Cmd, but uses DoSafe to wait for at least numReplicas
+// replicas to acknowledge the command before returning. The command's
+// response is returned even if the error is an *InsufficientReplicasError. It's
+// never pipelined, since WAIT only covers writes made on its own connection.
+func (p *Pool) CmdSafe(
+	numReplicas int, timeout time.Duration, cmd string, args ...interface{},
+) (
+	*redis.Resp, error,
+) {
+	var r *redis.Resp
+	err := p.DoSafe(numReplicas, timeout, func(conn *redis.Client) error {
+		r = conn.Cmd(cmd, args...)
+		return r.Err
+	})
+	return r, err
+}
@@ -0,0 +1,49 @@
+package pool
+
+import (
+	. "testing"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type waitCmder struct {
+	acked int
+	args  []interface{}
+}
+
+func (wc *waitCmder) Cmd(cmd string, args ...interface{}) *redis.Resp {
+	wc.args = append([]interface{}{cmd}, args...)
+	return redis.NewResp(wc.acked)
+}
+
+func TestWaitReplicas(t *T) {
+	wc := &waitCmder{acked: 1}
+	require.Nil(t, WaitReplicas(wc, 1, time.Second))
+	assert.Equal(t, []interface{}{"WAIT", 1, int64(1000)}, wc.args)
+
+	err := WaitReplicas(wc, 2, 500*time.Microsecond)
+	assert.Equal(t, &InsufficientReplicasError{Acked: 1, Wanted: 2}, err)
+	assert.Equal(t, []interface{}{"WAIT", 2, int64(1)}, wc.args)
+
+	require.Nil(t, WaitReplicas(wc, 1, 0))
+	assert.Equal(t, []interface{}{"WAIT", 1, int64(0)}, wc.args)
+}
+
+func TestCmdSafe(t *T) {
+	p, err := New("tcp", "localhost:6379", 1)
+	require.Nil(t, err)
+	defer p.Close()
+
+	// the instance has no replicas, so waiting for zero is all which can
+	// succeed
+	r, err := p.CmdSafe(0, time.Second, "SET", "poolCmdSafe", "foo")
+	require.Nil(t, err)
+	assert.Nil(t, r.Err)
+
+	r, err = p.CmdSafe(1, 10*time.Millisecond, "SET", "poolCmdSafe", "bar")
+	assert.Nil(t, r.Err)
+	assert.Equal(t, &InsufficientReplicasError{Acked: 0, Wanted: 1}, err)
+}
@@ -0,0 +1,125 @@
+package pool
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// waitSamples is how many of the most recent waits are kept for calculating
+// the percentiles in Stats
+const waitSamples = 1024
+
+// poolStats is kept by the Pool. The counters are updated atomically, so that
+// they can be read without taking the Pool's lock, which protects the rest.
+type poolStats struct {
+	gets, waits, waitTimeouts, dials, dialErrors int64
+
+	// the number of commands currently being pipelined by Cmd or run by
+	// DoBlocking
+	pipeActive, blockingActive int64
+
+	// the total time spent waiting, in nanoseconds
+	waitTime int64
+
+	// a ring buffer of the most recent wait durations
+	recent []time.Duration
+	next   int
+}
+
+func (ps *poolStats) recordWait(d time.Duration) {
+	atomic.AddInt64(&ps.waits, 1)
+	atomic.AddInt64(&ps.waitTime, int64(d))
+	if len(ps.recent) < waitSamples {
+		ps.recent = append(ps.recent, d)
+		return
+	}
+	ps.recent[ps.next] = d
+	ps.next = (ps.next + 1) % waitSamples
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+// percentile returns the pth percentile of the sorted durations
+func (d durations) percentile(p float64) time.Duration {
+	if len(d) == 0 {
+		return 0
+	}
+	return d[int(p*float64(len(d)-1))]
+}
+
+// Stats describes the current state of a Pool, and what it has done so far
+type Stats struct {
+	// The most connections which are kept sitting idle in the pool
+	Size int
+
+	// The number of connections sitting idle in the pool, and the number which
+	// have been gotten and not yet Put back
+	Idle, Active int
+
+	// The number of Get calls currently waiting for a connection because
+	// MaxActive was reached
+	Waiting int
+
+	// The total number of Get calls, how many of them had to wait because
+	// MaxActive was reached, and how many of those gave up with
+	// ErrPoolExhausted
+	Gets, Waits, WaitTimeouts int64
+
+	// The number of new connections which had to be made because there were
+	// none idle, and how many of those failed
+	Dials, DialErrors int64
+
+	// The total time Get calls have spent waiting because MaxActive was
+	// reached
+	WaitTime time.Duration
+
+	// Percentiles of how long Get calls which had to wait waited for, over
+	// the most recent 1024 waits. Zero if none have had to wait.
+	WaitP50, WaitP90, WaitP99, WaitMax time.Duration
+
+	// The number of connections sitting idle which are kept for DoBlocking,
+	// and the number of blocking commands currently being run. These aren't
+	// included in Idle and Active.
+	BlockingIdle, BlockingActive int
+}
+
+// Stats returns the current Stats for the Pool. It's cheap enough to be called
+// often, for example by something which scrapes metrics. The counters are each
+// as of some point during the call, but not necessarily the same point.
+func (p *Pool) Stats() Stats {
+	s := Stats{
+		Size:         cap(p.pool),
+		Idle:         len(p.pool),
+		Gets:         atomic.LoadInt64(&p.stats.gets),
+		Waits:        atomic.LoadInt64(&p.stats.waits),
+		WaitTimeouts: atomic.LoadInt64(&p.stats.waitTimeouts),
+		Dials:        atomic.LoadInt64(&p.stats.dials),
+		DialErrors:   atomic.LoadInt64(&p.stats.dialErrors),
+		WaitTime:     time.Duration(atomic.LoadInt64(&p.stats.waitTime)),
+
+		BlockingIdle:   len(p.blocking),
+		BlockingActive: int(atomic.LoadInt64(&p.stats.blockingActive)),
+	}
+
+	p.l.Lock()
+	s.Active = p.active
+	s.Waiting = p.waiters.Len()
+	if len(p.stats.recent) == 0 {
+		p.l.Unlock()
+		return s
+	}
+	recent := append(durations(nil), p.stats.recent...)
+	p.l.Unlock()
+
+	sort.Sort(recent)
+	s.WaitP50 = recent.percentile(0.5)
+	s.WaitP90 = recent.percentile(0.9)
+	s.WaitP99 = recent.percentile(0.99)
+	s.WaitMax = recent.percentile(1)
+	return s
+}
@@ -0,0 +1,62 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	. "testing"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *T) {
+	l, p := newFakePool(t, Opts{Size: 1, MaxActive: 2, GetTimeout: 20 * time.Millisecond})
+	defer l.Close()
+	defer p.Close()
+
+	// the first Get uses the idle connection, the second has to dial
+	c1, err := p.Get()
+	require.Nil(t, err)
+	c2, err := p.Get()
+	require.Nil(t, err)
+	_, err = p.Get()
+	assert.Equal(t, ErrPoolExhausted, err)
+
+	s := p.Stats()
+	assert.Equal(t, 1, s.Size)
+	assert.Equal(t, 0, s.Idle)
+	assert.Equal(t, 2, s.Active)
+	assert.Equal(t, int64(3), s.Gets)
+	assert.Equal(t, int64(1), s.Dials)
+	assert.Equal(t, int64(0), s.DialErrors)
+	assert.Equal(t, int64(1), s.Waits)
+	assert.True(t, s.WaitTime >= 20*time.Millisecond)
+	assert.Equal(t, s.WaitTime, s.WaitMax)
+
+	p.Put(c1)
+	p.Put(c2)
+	s = p.Stats()
+	assert.Equal(t, 1, s.Idle)
+	assert.Equal(t, 0, s.Active)
+}
+
+func TestStatsDialErrors(t *T) {
+	dialErr := errors.New("dial failed")
+	p, err := NewWithOpts(Opts{
+		Network: "tcp",
+		Addr:    "127.0.0.1:0",
+		Dial: func(context.Context, string, string) (*redis.Client, error) {
+			return nil, dialErr
+		},
+	})
+	require.Equal(t, dialErr, err)
+
+	_, err = p.Get()
+	assert.Equal(t, dialErr, err)
+	s := p.Stats()
+	assert.Equal(t, int64(1), s.Dials)
+	assert.Equal(t, int64(1), s.DialErrors)
+	assert.Equal(t, 0, s.Active)
+}
@@ -0,0 +1,347 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// ErrNotifierClosed is returned from a KeyspaceNotifier's methods once Close
+// has been called on it
+var ErrNotifierClosed = errors.New("keyspace notifier is closed")
+
+// KeyspaceEvent is a single keyspace or keyevent notification (see
+// https://redis.io/topics/notifications), parsed from its channel and message
+type KeyspaceEvent struct {
+	DB    int
+	Key   string
+	Event string // e.g. "set", "del" or "expired"
+}
+
+// KeyspaceOpts are options which can be passed into NewKeyspaceNotifier. All
+// fields are optional.
+type KeyspaceOpts struct {
+	// The database whose keys are notified about. Defaults to 0.
+	DB int
+
+	// Whether to subscribe to keyspace notifications, which are published on
+	// a channel per key, and keyevent notifications, which are published on a
+	// channel per event. If both are set every change is delivered twice,
+	// once for each. If neither is set only keyspace notifications are
+	// subscribed to.
+	//
+	// Keyevent notifications can't be filtered by key by redis, so all of
+	// them are received and those for keys which match none of the
+	// KeyspaceNotifier's patterns are dropped.
+	Keyspace, Keyevent bool
+
+	// If set, the notify-keyspace-events config is checked when the
+	// KeyspaceNotifier is created to make sure it includes these classes of
+	// events (e.g. "g$" for generic and string commands, or "A" for all), as
+	// well as whichever of K and E are needed for Keyspace and Keyevent. If
+	// it doesn't, and SetConfig isn't set, an error is returned.
+	Events string
+
+	// If set, any of the classes of events which notify-keyspace-events is
+	// missing are added to it with CONFIG SET, rather than an error being
+	// returned. Events defaults to "A" if this is set.
+	SetConfig bool
+}
+
+// KeyspaceNotifier delivers the keyspace notifications for the keys matching a
+// set of patterns, over a channel. It's built on a PersistentSubClient, so if
+// its connection is lost it reconnects and subscribes again to everything it
+// was subscribed to. Notifications published in the meantime are missed,
+// ReconnectCh can be used to find out when that might have happened.
+//
+// All methods may be called from multiple go-routines at once.
+type KeyspaceNotifier struct {
+	o KeyspaceOpts
+
+	// l guards p, sc, which is p's current SubClient once it's listening, and
+	// keyPatterns, which are the key patterns subscribed to
+	l           sync.Mutex
+	p           *PersistentSubClient
+	sc          *SubClient
+	keyPatterns map[string]bool
+
+	eventCh   chan KeyspaceEvent
+	closeCh   chan struct{}
+	closeOnce sync.Once
+
+	// The same as a PersistentSubClient's ReconnectCh
+	ReconnectCh chan struct{}
+}
+
+// NewKeyspaceNotifier uses the given DialFunc to make a connection and
+// subscribe to notifications for the keys matching the given patterns, which
+// use the same syntax as KEYS (e.g. "user:*"). The DialFunc is used again
+// whenever the connection is lost, and, if KeyspaceOpts' Events or SetConfig
+// are set, to make a separate connection for checking the config.
+//
+// The dialed connections must already have selected the KeyspaceOpts' DB, if
+// it isn't 0.
+func NewKeyspaceNotifier(df DialFunc, o KeyspaceOpts, keyPatterns ...string) (*KeyspaceNotifier, error) {
+	if !o.Keyspace && !o.Keyevent {
+		o.Keyspace = true
+	}
+	if o.SetConfig && o.Events == "" {
+		o.Events = "A"
+	}
+	if o.Events != "" {
+		if err := checkKeyspaceConfig(df, o); err != nil {
+			return nil, err
+		}
+	}
+
+	n := &KeyspaceNotifier{
+		o:           o,
+		keyPatterns: map[string]bool{},
+		eventCh:     make(chan KeyspaceEvent),
+		closeCh:     make(chan struct{}),
+	}
+	var patterns []interface{}
+	if o.Keyevent {
+		patterns = append(patterns, n.keyeventPrefix()+"*")
+	}
+	patterns = append(patterns, n.track(keyPatterns)...)
+
+	p, err := NewPersistentSubClient(df)
+	if err != nil {
+		return nil, err
+	}
+	if len(patterns) > 0 {
+		if sr := p.PSubscribe(patterns...); sr.Err != nil {
+			p.Close()
+			return nil, sr.Err
+		}
+	}
+	n.p = p
+	n.ReconnectCh = p.ReconnectCh
+	go n.spin(n.listen())
+	return n, nil
+}
+
+// allEventClasses is what the A class of notify-keyspace-events stands for
+const allEventClasses = "g$lshzxetd"
+
+// checkKeyspaceConfig makes sure notify-keyspace-events includes every class
+// of events needed
+func checkKeyspaceConfig(df DialFunc, o KeyspaceOpts) error {
+	want := o.Events
+	if o.Keyspace {
+		want += "K"
+	}
+	if o.Keyevent {
+		want += "E"
+	}
+
+	c, err := df()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	l, err := c.Cmd("CONFIG", "GET", "notify-keyspace-events").List()
+	if err != nil {
+		return err
+	} else if len(l) != 2 {
+		return errors.New("notify-keyspace-events config not found")
+	}
+	have := l[1]
+
+	var missing string
+	haveExpanded := strings.Replace(have, "A", allEventClasses, -1)
+	for _, class := range strings.Replace(want, "A", allEventClasses, -1) {
+		if !strings.ContainsRune(haveExpanded+missing, class) {
+			missing += string(class)
+		}
+	}
+	if missing == "" {
+		return nil
+	} else if !o.SetConfig {
+		return fmt.Errorf("notify-keyspace-events is %q, which is missing %q", have, missing)
+	}
+	return c.Cmd("CONFIG", "SET", "notify-keyspace-events", have+missing).Err
+}
+
+func (n *KeyspaceNotifier) keyspacePrefix() string {
+	return "__keyspace@" + strconv.Itoa(n.o.DB) + "__:"
+}
+
+func (n *KeyspaceNotifier) keyeventPrefix() string {
+	return "__keyevent@" + strconv.Itoa(n.o.DB) + "__:"
+}
+
+// track adds the given key patterns to those subscribed to, and returns the
+// channel patterns which need to be subscribed to for them. It must be called
+// with l held, or before spin is started.
+func (n *KeyspaceNotifier) track(keyPatterns []string) []interface{} {
+	var patterns []interface{}
+	for _, kp := range keyPatterns {
+		n.keyPatterns[kp] = true
+		if n.o.Keyspace {
+			patterns = append(patterns, n.keyspacePrefix()+kp)
+		}
+	}
+	return patterns
+}
+
+// Subscribe adds the given key patterns to those which notifications are
+// delivered for. If the connection has been lost the patterns are subscribed
+// to once it's been re-established, and nil is returned.
+func (n *KeyspaceNotifier) Subscribe(keyPatterns ...string) error {
+	n.l.Lock()
+	if n.p == nil {
+		n.l.Unlock()
+		return ErrNotifierClosed
+	}
+	patterns := n.track(keyPatterns)
+	track(n.p.patterns, patterns, true)
+	sc := n.sc
+	n.l.Unlock()
+
+	// If there's no connection the patterns will be subscribed to once
+	// there's a new one, and if this one is lost before they are they'll be
+	// subscribed to on the next
+	if sc == nil || len(patterns) == 0 {
+		return nil
+	}
+	// sc is listening, so this is safe to do alongside spin
+	sr := sc.PSubscribe(patterns...)
+	if sr.Err != nil && !sr.Resp.IsType(redis.IOErr) {
+		return sr.Err
+	}
+	return nil
+}
+
+// Events returns the channel which notifications are written to. It must be
+// read from for the KeyspaceNotifier to make progress, and is closed once Close
+// has been called.
+func (n *KeyspaceNotifier) Events() <-chan KeyspaceEvent {
+	return n.eventCh
+}
+
+// spin delivers the events from the given Messages channel until it's closed,
+// and then from the channels of new connections, until Close is called
+func (n *KeyspaceNotifier) spin(msgs <-chan *SubResp) {
+	defer close(n.eventCh)
+	for {
+		if msgs == nil {
+			if msgs = n.reconnect(); msgs == nil {
+				return
+			}
+		}
+		for sr := range msgs {
+			if sr.Type != Message {
+				continue
+			}
+			e, ok := n.parse(sr.Channel, sr.Message)
+			if !ok {
+				continue
+			}
+			select {
+			case n.eventCh <- e:
+			case <-n.closeCh:
+				return
+			}
+		}
+
+		// the connection has been lost
+		msgs = nil
+		n.l.Lock()
+		if n.p != nil {
+			n.p.lost()
+			n.sc = nil
+		}
+		n.l.Unlock()
+	}
+}
+
+// listen starts the PersistentSubClient's current SubClient listening, and
+// returns its Messages channel, or nil if there isn't one. It must be called
+// with l held, or before spin is started, so that Subscribe never uses a
+// SubClient which isn't listening yet.
+func (n *KeyspaceNotifier) listen() <-chan *SubResp {
+	if n.p.sc == nil {
+		return nil
+	}
+	n.sc = n.p.sc
+	return n.sc.Messages()
+}
+
+// reconnect waits for the PersistentSubClient to reconnect, and returns the new
+// SubClient's Messages channel. It returns nil once the KeyspaceNotifier has
+// been closed.
+func (n *KeyspaceNotifier) reconnect() <-chan *SubResp {
+	for {
+		n.l.Lock()
+		if n.p == nil {
+			n.l.Unlock()
+			return nil
+		}
+		wait := n.p.next.Sub(time.Now())
+		n.l.Unlock()
+
+		if wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-n.closeCh:
+				return nil
+			}
+		}
+
+		n.l.Lock()
+		var msgs <-chan *SubResp
+		if n.p != nil && n.p.subClient() != nil {
+			msgs = n.listen()
+		}
+		n.l.Unlock()
+		if msgs != nil {
+			return msgs
+		}
+	}
+}
+
+// parse returns the event for a message, or false if it isn't a notification
+// for one of the key patterns
+func (n *KeyspaceNotifier) parse(channel, msg string) (KeyspaceEvent, bool) {
+	e := KeyspaceEvent{DB: n.o.DB}
+	if strings.HasPrefix(channel, n.keyspacePrefix()) {
+		e.Key = channel[len(n.keyspacePrefix()):]
+		e.Event = msg
+		return e, true
+	} else if !strings.HasPrefix(channel, n.keyeventPrefix()) {
+		return e, false
+	}
+
+	e.Key = msg
+	e.Event = channel[len(n.keyeventPrefix()):]
+	n.l.Lock()
+	defer n.l.Unlock()
+	for kp := range n.keyPatterns {
+		if MatchPattern(kp, e.Key) {
+			return e, true
+		}
+	}
+	return e, false
+}
+
+// Close closes the connection and the Events channel. The KeyspaceNotifier
+// can't be used afterwards.
+func (n *KeyspaceNotifier) Close() error {
+	var err error
+	n.closeOnce.Do(func() {
+		close(n.closeCh)
+		n.l.Lock()
+		defer n.l.Unlock()
+		err = n.p.Close()
+		n.p = nil
+	})
+	return err
+}
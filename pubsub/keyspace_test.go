@@ -0,0 +1,188 @@
+package pubsub
+
+import (
+	"net"
+	"strings"
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// notifyServer answers CONFIG GET and SET for notify-keyspace-events, and
+// PSUBSCRIBE, and publishes notifications to the connections with a matching
+// pattern
+type notifyServer struct {
+	net.Listener
+
+	l      sync.Mutex
+	config string
+	conns  map[net.Conn][]string
+	subCh  chan string
+}
+
+func newNotifyServer(t *T, config string) *notifyServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	ns := &notifyServer{
+		Listener: l,
+		config:   config,
+		conns:    map[net.Conn][]string{},
+		subCh:    make(chan string, 100),
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go ns.serve(conn)
+		}
+	}()
+	return ns
+}
+
+func (ns *notifyServer) serve(conn net.Conn) {
+	defer conn.Close()
+	rr := redis.NewRespReader(conn)
+	for {
+		args, err := rr.Read().List()
+		if err != nil {
+			return
+		}
+		ns.l.Lock()
+		switch strings.ToUpper(args[0]) {
+		case "CONFIG":
+			if strings.ToUpper(args[1]) == "SET" {
+				ns.config = args[3]
+				redis.NewRespSimple("OK").WriteTo(conn)
+			} else {
+				redis.NewResp([]string{args[2], ns.config}).WriteTo(conn)
+			}
+		case "PSUBSCRIBE":
+			for _, p := range args[1:] {
+				ns.conns[conn] = append(ns.conns[conn], p)
+				redis.NewResp([]interface{}{"psubscribe", p, len(ns.conns[conn])}).WriteTo(conn)
+				ns.subCh <- p
+			}
+		}
+		ns.l.Unlock()
+	}
+}
+
+func (ns *notifyServer) publish(channel, msg string) {
+	ns.l.Lock()
+	defer ns.l.Unlock()
+	for conn, patterns := range ns.conns {
+		for _, p := range patterns {
+			if MatchPattern(p, channel) {
+				redis.NewResp([]string{"pmessage", p, channel, msg}).WriteTo(conn)
+			}
+		}
+	}
+}
+
+func (ns *notifyServer) killAll() {
+	ns.l.Lock()
+	defer ns.l.Unlock()
+	for conn := range ns.conns {
+		conn.Close()
+		delete(ns.conns, conn)
+	}
+}
+
+func (ns *notifyServer) dial() (*redis.Client, error) {
+	return redis.Dial("tcp", ns.Addr().String())
+}
+
+func readEvent(t *T, n *KeyspaceNotifier) KeyspaceEvent {
+	select {
+	case e := <-n.Events():
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+		return KeyspaceEvent{}
+	}
+}
+
+func TestKeyspaceNotifier(t *T) {
+	ns := newNotifyServer(t, "")
+	defer ns.Close()
+
+	n, err := NewKeyspaceNotifier(ns.dial, KeyspaceOpts{DB: 2}, "user:*")
+	require.Nil(t, err)
+	defer n.Close()
+	assert.Equal(t, "__keyspace@2__:user:*", <-ns.subCh)
+
+	ns.publish("__keyspace@2__:other", "set")
+	ns.publish("__keyspace@2__:user:1", "set")
+	assert.Equal(t, KeyspaceEvent{DB: 2, Key: "user:1", Event: "set"}, readEvent(t, n))
+
+	require.Nil(t, n.Subscribe("session:*"))
+	assert.Equal(t, "__keyspace@2__:session:*", <-ns.subCh)
+	ns.publish("__keyspace@2__:session:a", "expired")
+	assert.Equal(t, KeyspaceEvent{DB: 2, Key: "session:a", Event: "expired"}, readEvent(t, n))
+
+	// everything is subscribed to again after reconnecting
+	ns.killAll()
+	select {
+	case <-n.ReconnectCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for reconnect")
+	}
+	subs := []string{<-ns.subCh, <-ns.subCh}
+	assert.Contains(t, subs, "__keyspace@2__:user:*")
+	assert.Contains(t, subs, "__keyspace@2__:session:*")
+	ns.publish("__keyspace@2__:user:2", "del")
+	assert.Equal(t, KeyspaceEvent{DB: 2, Key: "user:2", Event: "del"}, readEvent(t, n))
+
+	require.Nil(t, n.Close())
+	_, ok := <-n.Events()
+	assert.False(t, ok)
+	assert.Equal(t, ErrNotifierClosed, n.Subscribe("foo"))
+}
+
+func TestKeyspaceNotifierKeyevent(t *T) {
+	ns := newNotifyServer(t, "")
+	defer ns.Close()
+
+	n, err := NewKeyspaceNotifier(ns.dial, KeyspaceOpts{Keyevent: true}, "user:*")
+	require.Nil(t, err)
+	defer n.Close()
+	assert.Equal(t, "__keyevent@0__:*", <-ns.subCh)
+
+	ns.publish("__keyevent@0__:set", "other")
+	ns.publish("__keyevent@0__:set", "user:1")
+	assert.Equal(t, KeyspaceEvent{DB: 0, Key: "user:1", Event: "set"}, readEvent(t, n))
+}
+
+func TestKeyspaceNotifierConfig(t *T) {
+	ns := newNotifyServer(t, "Kg")
+	defer ns.Close()
+
+	n, err := NewKeyspaceNotifier(ns.dial, KeyspaceOpts{Events: "g"}, "foo")
+	require.Nil(t, err)
+	n.Close()
+	assert.Equal(t, "Kg", ns.config)
+
+	_, err = NewKeyspaceNotifier(ns.dial, KeyspaceOpts{Events: "gx", Keyevent: true}, "foo")
+	assert.NotNil(t, err)
+	assert.Equal(t, "Kg", ns.config)
+
+	n, err = NewKeyspaceNotifier(ns.dial, KeyspaceOpts{
+		Events:    "gx",
+		Keyevent:  true,
+		SetConfig: true,
+	}, "foo")
+	require.Nil(t, err)
+	n.Close()
+	assert.Equal(t, "KgxE", ns.config)
+
+	n, err = NewKeyspaceNotifier(ns.dial, KeyspaceOpts{SetConfig: true}, "foo")
+	require.Nil(t, err)
+	n.Close()
+	assert.Equal(t, "KgxE$lshzetd", ns.config)
+}
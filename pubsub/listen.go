@@ -0,0 +1,118 @@
+package pubsub
+
+import (
+	"errors"
+
+	"github.com/mediocregopher/radix.v2/log"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// ErrListening is the Err on the SubResp returned from Receive once Messages
+// has been called on a SubClient
+var ErrListening = errors.New("messages are being delivered on the Messages channel")
+
+func errListeningResp() *SubResp {
+	return &SubResp{Resp: redis.NewResp(ErrListening), Type: Error, Err: ErrListening}
+}
+
+// Messages starts a go-routine which reads everything sent by redis over the
+// SubClient's connection, and returns a channel onto which every message is
+// written. Calling it again returns the same channel. Any messages which were
+// already read, e.g. by a Subscribe call, are written to the channel first.
+//
+// Subscribe, PSubscribe, Unsubscribe, PUnsubscribe, Ping and
+// SyncSubscriptions may all still be used, from any go-routine, and will
+// return the confirmation for their command as before. The only confirmations
+// written to the channel are ones which no call is waiting for. Receive can't
+// be used once Messages has been called.
+//
+// Messages are buffered for as long as the channel isn't being read from, so
+// that calls to Subscribe and the like don't wait on it, which means it should
+// be read from promptly. Read timeouts are ignored. When the connection fails
+// (or is closed) a final Error SubResp is written, and then the channel is
+// closed.
+func (c *SubClient) Messages() <-chan *SubResp {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if c.msgCh != nil {
+		return c.msgCh
+	}
+	c.init()
+	c.msgCh = make(chan *SubResp)
+	go c.readSpin()
+	go c.deliverSpin()
+	c.startPinging()
+	return c.msgCh
+}
+
+func (c *SubClient) readSpin() {
+	logger := log.With(c.Logger, log.KV{
+		log.KeyComponent: "pubsub",
+		log.KeyAddr:      c.Client.Addr,
+		log.KeyOperation: "listen",
+	})
+
+	// if a Subscribe call is reading from the connection it's left to finish,
+	// after which readSpin is the only thing which reads from it
+	c.l.Lock()
+	for c.reading {
+		c.cond.Wait()
+	}
+	c.reading = true
+	c.l.Unlock()
+
+	for {
+		r := c.Client.ReadResp()
+		if redis.IsTimeout(r) {
+			continue
+		}
+
+		c.l.Lock()
+		sr := c.parseResp(r)
+		if c.backgroundPong(sr) {
+			c.l.Unlock()
+			continue
+		}
+		sr = c.pingTimeout(sr)
+		if r.IsType(redis.IOErr) {
+			logger.Log(log.Warn, "connection lost", log.KV{log.KeyErr: r.Err})
+			c.dead = sr
+			c.failInFlight(sr)
+			c.messages.PushBack(sr)
+			c.cond.Broadcast()
+			c.l.Unlock()
+			return
+		}
+
+		// Anything other than a message is the reply to the oldest in-flight
+		// command, if there is one
+		if c.confirm(sr) {
+			c.l.Unlock()
+			continue
+		} else if sr.Type != Message {
+			logger.Log(log.Debug, "unexpected reply", nil)
+		}
+
+		c.messages.PushBack(sr)
+		c.cond.Broadcast()
+		c.l.Unlock()
+	}
+}
+
+func (c *SubClient) deliverSpin() {
+	for {
+		c.l.Lock()
+		for c.messages.Len() == 0 && c.dead == nil {
+			c.cond.Wait()
+		}
+		if c.messages.Len() == 0 {
+			c.l.Unlock()
+			close(c.msgCh)
+			return
+		}
+		sr := c.messages.Remove(c.messages.Front()).(*SubResp)
+		c.l.Unlock()
+
+		c.msgCh <- sr
+	}
+}
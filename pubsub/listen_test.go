@@ -0,0 +1,151 @@
+package pubsub
+
+import (
+	"net"
+	"strings"
+	. "testing"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/log"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSubServer answers SUBSCRIBE, UNSUBSCRIBE and PING like redis does, except
+// that before each subscribe confirmation it sends a message on the channel
+// being subscribed to, so messages and confirmations are interleaved
+func fakeSubServer(t *T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rr := redis.NewRespReader(conn)
+		var count int
+		for {
+			args, err := rr.Read().List()
+			if err != nil {
+				return
+			}
+			switch strings.ToUpper(args[0]) {
+			case "SUBSCRIBE":
+				for _, ch := range args[1:] {
+					count++
+					redis.NewResp([]string{"message", ch, "hi"}).WriteTo(conn)
+					redis.NewResp([]interface{}{"subscribe", ch, count}).WriteTo(conn)
+				}
+			case "UNSUBSCRIBE":
+				for _, ch := range args[1:] {
+					count--
+					redis.NewResp([]interface{}{"unsubscribe", ch, count}).WriteTo(conn)
+				}
+			case "PING":
+				redis.NewResp([]string{"pong", ""}).WriteTo(conn)
+			default:
+				redis.NewResp(redis.AppErr).WriteTo(conn)
+			}
+		}
+	}()
+	return l
+}
+
+func TestMessagesFake(t *T) {
+	l := fakeSubServer(t)
+	defer l.Close()
+	c, err := redis.DialTimeout("tcp", l.Addr().String(), 5*time.Second)
+	require.Nil(t, err)
+	sub := NewSubClient(c)
+	rec := new(log.Recorder)
+	sub.Logger = rec
+
+	// the message read during this Subscribe is written to the channel first
+	sr := sub.Subscribe("a")
+	require.Nil(t, sr.Err)
+	msgs := sub.Messages()
+	assert.Equal(t, msgs, sub.Messages())
+	assert.Equal(t, ErrListening, sub.Receive().Err)
+
+	sr = sub.Subscribe("b", "c")
+	require.Nil(t, sr.Err)
+	assert.Equal(t, Subscribe, sr.Type)
+	assert.Equal(t, 3, sr.SubCount)
+
+	for _, ch := range []string{"a", "b", "c"} {
+		sr := <-msgs
+		assert.Equal(t, Message, sr.Type)
+		assert.Equal(t, ch, sr.Channel)
+		assert.Equal(t, "hi", sr.Message)
+	}
+
+	assert.Equal(t, Pong, sub.Ping().Type)
+	sr = sub.Unsubscribe("a", "b")
+	require.Nil(t, sr.Err)
+	assert.Equal(t, Unsubscribe, sr.Type)
+	assert.Equal(t, 1, sr.SubCount)
+
+	ss, err := sub.SyncSubscriptions([]string{"c", "d"}, nil)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"d"}, ss.Subscribed)
+	sr = <-msgs
+	assert.Equal(t, "d", sr.Channel)
+
+	c.Close()
+	sr, ok := <-msgs
+	require.True(t, ok)
+	assert.Equal(t, Error, sr.Type)
+	assert.NotNil(t, sr.Err)
+	_, ok = <-msgs
+	assert.False(t, ok)
+	assert.Equal(t, Error, sub.Subscribe("e").Type)
+
+	ee := rec.Entries("")
+	require.Len(t, ee, 1)
+	assert.Equal(t, "connection lost", ee[0].Msg)
+	assert.Equal(t, "pubsub", ee[0].KV[log.KeyComponent])
+	assert.Equal(t, l.Addr().String(), ee[0].KV[log.KeyAddr])
+}
+
+func TestMessages(t *T) {
+	pub, sub := testClients(t, 500*time.Millisecond)
+	ch1, ch2 := randStr(), randStr()
+
+	require.Nil(t, sub.Subscribe(ch1).Err)
+	msgs := sub.Messages()
+
+	// read timeouts are ignored
+	time.Sleep(time.Second)
+
+	// publishing goes on while subscribing, and Subscribe doesn't wait on the
+	// channel being read from
+	for i := 0; i < 10; i++ {
+		require.Nil(t, pub.Cmd("PUBLISH", ch1, i).Err)
+	}
+	sr := sub.Subscribe(ch2)
+	require.Nil(t, sr.Err)
+	assert.Equal(t, 2, sr.SubCount)
+	require.Nil(t, pub.Cmd("PUBLISH", ch2, "foo").Err)
+
+	for i := 0; i < 10; i++ {
+		sr := <-msgs
+		require.Nil(t, sr.Err)
+		assert.Equal(t, ch1, sr.Channel)
+	}
+	sr = <-msgs
+	require.Nil(t, sr.Err)
+	assert.Equal(t, ch2, sr.Channel)
+	assert.Equal(t, "foo", sr.Message)
+
+	sr = sub.Unsubscribe(ch1, ch2)
+	require.Nil(t, sr.Err)
+	assert.Equal(t, 0, sr.SubCount)
+
+	sub.Client.Close()
+	sr = <-msgs
+	assert.Equal(t, Error, sr.Type)
+	_, ok := <-msgs
+	assert.False(t, ok)
+}
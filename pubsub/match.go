@@ -0,0 +1,107 @@
+package pubsub
+
+// MatchPattern reports whether the given channel name matches the given glob
+// pattern, using the same rules redis uses to deliver messages to PSUBSCRIBE
+// patterns. A '*' matches any sequence of characters (including none), a '?'
+// matches any single character, "[abc]" matches any one of the bracketed
+// characters, "[^abc]" matches any character which isn't bracketed, "[a-z]"
+// matches a range of characters, and a backslash causes the following
+// character to be matched literally.
+func MatchPattern(pattern, channel string) bool {
+	return matchPattern([]byte(pattern), []byte(channel))
+}
+
+func matchPattern(p, s []byte) bool {
+	for len(p) > 0 {
+		switch p[0] {
+		case '*':
+			for len(p) > 1 && p[1] == '*' {
+				p = p[1:]
+			}
+			if len(p) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchPattern(p[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			var ok bool
+			if p, ok = matchClass(p[1:], s[0]); !ok {
+				return false
+			}
+			s = s[1:]
+			// matchClass leaves p on the closing bracket (or at its end if
+			// there wasn't one), skip past it below
+			if len(p) == 0 {
+				continue
+			}
+
+		case '\\':
+			if len(p) > 1 {
+				p = p[1:]
+			}
+			fallthrough
+
+		default:
+			if len(s) == 0 || p[0] != s[0] {
+				return false
+			}
+			s = s[1:]
+		}
+		p = p[1:]
+	}
+	return len(s) == 0
+}
+
+// matchClass matches c against the character class at the start of p (which
+// has already had its opening bracket removed). It returns p positioned on the
+// class's closing bracket, and whether or not c matched
+func matchClass(p []byte, c byte) ([]byte, bool) {
+	not := len(p) > 0 && p[0] == '^'
+	if not {
+		p = p[1:]
+	}
+
+	var match bool
+	for len(p) > 0 && p[0] != ']' {
+		switch {
+		case p[0] == '\\' && len(p) > 1:
+			p = p[1:]
+			if p[0] == c {
+				match = true
+			}
+		case len(p) > 2 && p[1] == '-':
+			start, end := p[0], p[2]
+			if start > end {
+				start, end = end, start
+			}
+			if c >= start && c <= end {
+				match = true
+			}
+			p = p[2:]
+		default:
+			if p[0] == c {
+				match = true
+			}
+		}
+		p = p[1:]
+	}
+
+	if not {
+		match = !match
+	}
+	return p, match
+}
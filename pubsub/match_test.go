@@ -0,0 +1,48 @@
+package pubsub
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchPattern(t *T) {
+	table := []struct {
+		pattern, channel string
+		match            bool
+	}{
+		{"foo", "foo", true},
+		{"foo", "foobar", false},
+		{"", "", true},
+		{"", "a", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"foo*", "foobar", true},
+		{"foo*", "barfoo", false},
+		{"*bar", "foobar", true},
+		{"f*o*r", "foobar", true},
+		{"f**r", "foobar", true},
+		{"f*z", "foobar", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[b-a]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{`h[\]]llo`, "h]llo", true},
+		{"news.*", "news.art.figurative", true},
+		{"news.[ab]*", "news.art", true},
+	}
+
+	for _, test := range table {
+		assert.Equal(
+			t, test.match, MatchPattern(test.pattern, test.channel),
+			"%q %q", test.pattern, test.channel,
+		)
+	}
+}
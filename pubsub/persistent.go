@@ -0,0 +1,300 @@
+package pubsub
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// ErrReconnecting is the Err on the SubResp returned from a
+// PersistentSubClient's methods when its connection has been lost and hasn't
+// been re-established yet. It isn't fatal, the call can be made again.
+var ErrReconnecting = errors.New("pubsub connection lost, reconnecting")
+
+// The range of the exponential backoff between attempts at reconnecting
+const (
+	persistentMinBackoff = 100 * time.Millisecond
+	persistentMaxBackoff = 5 * time.Second
+)
+
+// PersistentSubClient is like a SubClient, but if its connection is lost it
+// makes a new one and subscribes again to all the channels, patterns and shard
+// channels it was subscribed to. The set of them is whatever the
+// PersistentSubClient was created with plus anything subscribed to since,
+// minus anything unsubscribed from, regardless of whether or not redis
+// confirmed the change before the connection was lost.
+//
+// As with SubClient, a PersistentSubClient may only be used from one
+// go-routine at a time.
+type PersistentSubClient struct {
+	df DialFunc
+	sc *SubClient // nil while the connection is lost
+
+	channels, patterns, shardChannels map[string]bool
+
+	// when the next attempt at reconnecting may be made, and how long to wait
+	// after that if it fails
+	next    time.Time
+	backoff time.Duration
+
+	// This is written to whenever the connection has been re-established,
+	// since messages which were published in the meantime will have been
+	// missed. It has a buffer of one, so if nothing is reading from it at the
+	// time the notification is kept until something does, but several
+	// reconnects in a row are only notified once.
+	ReconnectCh chan struct{}
+}
+
+var _ Subscriber = &PersistentSubClient{}
+
+// DialFunc is a function which can be passed into NewPersistentSubClient
+type DialFunc func() (*redis.Client, error)
+
+// NewPersistentSubClient uses the given DialFunc to make a connection and
+// subscribe to the given channels, returning a PersistentSubClient which will
+// use the DialFunc again whenever the connection is lost.
+//
+// Once the connection is lost the next call to any of the
+// PersistentSubClient's methods returns an Error SubResp with Err set to
+// ErrReconnecting. Each call after that tries to reconnect, first waiting out
+// a backoff which grows from 100ms to 5s with each failed attempt, and returns
+// that same error again if the attempt fails. Once reconnected calls behave
+// as normal, and ReconnectCh is written to.
+func NewPersistentSubClient(df DialFunc, channels ...string) (*PersistentSubClient, error) {
+	p := &PersistentSubClient{
+		df:            df,
+		channels:      map[string]bool{},
+		patterns:      map[string]bool{},
+		shardChannels: map[string]bool{},
+		ReconnectCh:   make(chan struct{}, 1),
+	}
+	for _, ch := range channels {
+		p.channels[ch] = true
+	}
+	if err := p.dial(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func setKeys(m map[string]bool) []string {
+	ss := make([]string, 0, len(m))
+	for s := range m {
+		ss = append(ss, s)
+	}
+	return ss
+}
+
+// dial makes a new connection and subscribes it to everything which should be
+// subscribed to
+func (p *PersistentSubClient) dial() error {
+	c, err := p.df()
+	if err != nil {
+		return err
+	}
+	sc := NewSubClient(c)
+	if _, err := sc.SyncSubscriptions(setKeys(p.channels), setKeys(p.patterns)); err != nil {
+		c.Close()
+		return err
+	}
+	// Shard channels are subscribed to one at a time, since those in a single
+	// SSUBSCRIBE must all be in the same cluster slot
+	for ch := range p.shardChannels {
+		if sr := sc.SSubscribe(ch); sr.Err != nil {
+			c.Close()
+			return sr.Err
+		}
+	}
+	p.sc = sc
+	return nil
+}
+
+func reconnectingResp() *SubResp {
+	return &SubResp{
+		Resp: redis.NewRespIOErr(ErrReconnecting),
+		Type: Error,
+		Err:  ErrReconnecting,
+	}
+}
+
+// subClient returns the current SubClient, reconnecting if necessary. If the
+// connection can't be re-established nil is returned.
+func (p *PersistentSubClient) subClient() *SubClient {
+	if p.sc != nil {
+		return p.sc
+	}
+
+	if d := p.next.Sub(time.Now()); d > 0 {
+		time.Sleep(d)
+	}
+	if err := p.dial(); err != nil {
+		if p.backoff *= 2; p.backoff < persistentMinBackoff {
+			p.backoff = persistentMinBackoff
+		} else if p.backoff > persistentMaxBackoff {
+			p.backoff = persistentMaxBackoff
+		}
+		p.next = time.Now().Add(p.backoff)
+		return nil
+	}
+
+	p.backoff = 0
+	select {
+	case p.ReconnectCh <- struct{}{}:
+	default:
+	}
+	return p.sc
+}
+
+// check looks at the SubResp returned from a call on the current SubClient,
+// and if the connection was lost drops the SubClient and returns a
+// reconnecting SubResp in place of the given one
+func (p *PersistentSubClient) check(sr *SubResp) *SubResp {
+	if sr.Type != Error || !sr.Resp.IsType(redis.IOErr) || sr.Timeout() {
+		return sr
+	}
+	p.lost()
+	return reconnectingResp()
+}
+
+// doShard is like do, but also treats the connection as lost if redis says a
+// shard channel's slot is on another node, either with a MOVED error or by
+// having unsubscribed from it unprompted, so that the next call reconnects,
+// hopefully to that node
+func (p *PersistentSubClient) doShard(fn func(*SubClient) *SubResp) *SubResp {
+	sr := p.do(fn)
+	if p.sc == nil {
+		return sr
+	}
+
+	var moved bool
+	switch sr.Type {
+	case Error:
+		moved = sr.Resp.IsType(redis.AppErr) && strings.HasPrefix(sr.Err.Error(), "MOVED ")
+	case Unsubscribe:
+		for ch := range p.shardChannels {
+			if !p.sc.shardChannels[ch] {
+				moved = true
+				break
+			}
+		}
+	}
+	if !moved {
+		return sr
+	}
+	p.lost()
+	return reconnectingResp()
+}
+
+// lost drops the current SubClient, so that the next call reconnects straight
+// away
+func (p *PersistentSubClient) lost() {
+	p.sc.Client.Close()
+	p.sc = nil
+	p.next = time.Time{}
+}
+
+func (p *PersistentSubClient) do(fn func(*SubClient) *SubResp) *SubResp {
+	sc := p.subClient()
+	if sc == nil {
+		return reconnectingResp()
+	}
+	return p.check(fn(sc))
+}
+
+func namesToStrings(names []interface{}) []string {
+	ss := make([]string, len(names))
+	for i := range names {
+		ss[i], _ = redis.NewResp(names[i]).Str()
+	}
+	return ss
+}
+
+// track records the change to a set of names which is being made, whether or
+// not redis ends up confirming it. An empty set of names being unsubscribed
+// from means every one.
+func track(m map[string]bool, names []interface{}, sub bool) {
+	if !sub && len(names) == 0 {
+		for name := range m {
+			delete(m, name)
+		}
+	}
+	for _, name := range namesToStrings(names) {
+		if sub {
+			m[name] = true
+		} else {
+			delete(m, name)
+		}
+	}
+}
+
+// Subscribe makes a Redis "SUBSCRIBE" command on the provided channels, which
+// will be subscribed to again on every reconnect
+func (p *PersistentSubClient) Subscribe(channels ...interface{}) *SubResp {
+	track(p.channels, channels, true)
+	return p.do(func(sc *SubClient) *SubResp { return sc.Subscribe(channels...) })
+}
+
+// PSubscribe makes a Redis "PSUBSCRIBE" command on the provided patterns,
+// which will be subscribed to again on every reconnect
+func (p *PersistentSubClient) PSubscribe(patterns ...interface{}) *SubResp {
+	track(p.patterns, patterns, true)
+	return p.do(func(sc *SubClient) *SubResp { return sc.PSubscribe(patterns...) })
+}
+
+// Unsubscribe makes a Redis "UNSUBSCRIBE" command on the provided channels
+func (p *PersistentSubClient) Unsubscribe(channels ...interface{}) *SubResp {
+	track(p.channels, channels, false)
+	return p.do(func(sc *SubClient) *SubResp { return sc.Unsubscribe(channels...) })
+}
+
+// PUnsubscribe makes a Redis "PUNSUBSCRIBE" command on the provided patterns
+func (p *PersistentSubClient) PUnsubscribe(patterns ...interface{}) *SubResp {
+	track(p.patterns, patterns, false)
+	return p.do(func(sc *SubClient) *SubResp { return sc.PUnsubscribe(patterns...) })
+}
+
+// SSubscribe makes a Redis "SSUBSCRIBE" command on the provided shard
+// channels, which will be subscribed to again on every reconnect. If redis
+// says their slot is on another node then the connection is dropped, and an
+// ErrReconnecting SubResp returned, the same as if it had been lost. This is
+// only useful if the DialFunc then connects to the node which now owns the
+// slot, see cluster's NewShardSubClient.
+func (p *PersistentSubClient) SSubscribe(channels ...interface{}) *SubResp {
+	track(p.shardChannels, channels, true)
+	return p.doShard(func(sc *SubClient) *SubResp { return sc.SSubscribe(channels...) })
+}
+
+// SUnsubscribe makes a Redis "SUNSUBSCRIBE" command on the provided shard
+// channels
+func (p *PersistentSubClient) SUnsubscribe(channels ...interface{}) *SubResp {
+	track(p.shardChannels, channels, false)
+	return p.do(func(sc *SubClient) *SubResp { return sc.SUnsubscribe(channels...) })
+}
+
+// Ping will send a ping command on the connection, and returns a Pong response
+// (or error)
+func (p *PersistentSubClient) Ping() *SubResp {
+	return p.do(func(sc *SubClient) *SubResp { return sc.Ping() })
+}
+
+// Receive returns the next publish resp, the same as SubClient's Receive. If
+// the connection has been lost it returns an ErrReconnecting SubResp instead,
+// see NewPersistentSubClient. That's also returned if redis unsubscribes from a
+// shard channel unprompted, as it does when the channel's slot is moved, see
+// SSubscribe.
+func (p *PersistentSubClient) Receive() *SubResp {
+	return p.doShard(func(sc *SubClient) *SubResp { return sc.Receive() })
+}
+
+// Close closes the current connection, if there is one. The
+// PersistentSubClient shouldn't be used afterwards.
+func (p *PersistentSubClient) Close() error {
+	if p.sc == nil {
+		return nil
+	}
+	err := p.sc.Client.Close()
+	p.sc = nil
+	return err
+}
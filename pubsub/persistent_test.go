@@ -0,0 +1,141 @@
+package pubsub
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	. "testing"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// persistentServer answers SUBSCRIBE and PSUBSCRIBE like redis does, on as many
+// connections as are made to it. Every name subscribed to on a connection is
+// written to subCh, and the connections are written to connCh as they're
+// accepted so they can be killed.
+func persistentServer(t *T) (net.Listener, chan string, chan net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	subCh, connCh := make(chan string, 100), make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			connCh <- conn
+			go func() {
+				defer conn.Close()
+				rr := redis.NewRespReader(conn)
+				var count int
+				for {
+					args, err := rr.Read().List()
+					if err != nil {
+						return
+					}
+					cmd := strings.ToLower(args[0])
+					for _, name := range args[1:] {
+						count++
+						subCh <- name
+						redis.NewResp([]interface{}{cmd, name, count}).WriteTo(conn)
+					}
+				}
+			}()
+		}
+	}()
+	return l, subCh, connCh
+}
+
+func readSubs(t *T, subCh chan string, n int) []string {
+	var subs []string
+	for i := 0; i < n; i++ {
+		select {
+		case s := <-subCh:
+			subs = append(subs, s)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for subscription")
+		}
+	}
+	sort.Strings(subs)
+	return subs
+}
+
+func TestPersistentSubClient(t *T) {
+	l, subCh, connCh := persistentServer(t)
+	defer l.Close()
+
+	var dialErr error
+	df := func() (*redis.Client, error) {
+		if dialErr != nil {
+			return nil, dialErr
+		}
+		// short enough that Receive times out quickly once reconnected
+		return redis.DialTimeout("tcp", l.Addr().String(), 100*time.Millisecond)
+	}
+	p, err := NewPersistentSubClient(df, "a")
+	require.Nil(t, err)
+	defer p.Close()
+	assert.Equal(t, []string{"a"}, readSubs(t, subCh, 1))
+	conn := <-connCh
+
+	require.Nil(t, p.Subscribe("b").Err)
+	require.Nil(t, p.PSubscribe("c*").Err)
+	assert.Equal(t, []string{"b", "c*"}, readSubs(t, subCh, 2))
+
+	// losing the connection isn't fatal
+	conn.Close()
+	sr := p.Receive()
+	assert.Equal(t, Error, sr.Type)
+	assert.Equal(t, ErrReconnecting, sr.Err)
+
+	// while the server can't be reached the error keeps being returned
+	dialErr = errors.New("can't dial")
+	assert.Equal(t, ErrReconnecting, p.Receive().Err)
+	start := time.Now()
+	assert.Equal(t, ErrReconnecting, p.Receive().Err)
+	assert.True(t, time.Since(start) >= persistentMinBackoff)
+	select {
+	case <-p.ReconnectCh:
+		t.Fatal("reconnect notified without reconnecting")
+	default:
+	}
+
+	// once it can be everything is subscribed to again
+	dialErr = nil
+	sr = p.Receive()
+	assert.True(t, sr.Timeout(), "%v", sr.Err)
+	assert.Equal(t, []string{"a", "b", "c*"}, readSubs(t, subCh, 3))
+	select {
+	case <-p.ReconnectCh:
+	default:
+		t.Fatal("reconnect wasn't notified")
+	}
+}
+
+func TestPersistentSubClientShardMoved(t *T) {
+	l, subCh, connCh := persistentServer(t)
+	defer l.Close()
+
+	df := func() (*redis.Client, error) {
+		return redis.DialTimeout("tcp", l.Addr().String(), 100*time.Millisecond)
+	}
+	p, err := NewPersistentSubClient(df)
+	require.Nil(t, err)
+	defer p.Close()
+	conn := <-connCh
+
+	require.Nil(t, p.SSubscribe("s").Err)
+	assert.Equal(t, []string{"s"}, readSubs(t, subCh, 1))
+
+	// redis unsubscribes from a shard channel by itself when its slot moves
+	redis.NewResp([]interface{}{"sunsubscribe", "s", 0}).WriteTo(conn)
+	assert.Equal(t, ErrReconnecting, p.Receive().Err)
+
+	sr := p.Receive()
+	assert.True(t, sr.Timeout(), "%v", sr.Err)
+	assert.Equal(t, []string{"s"}, readSubs(t, subCh, 1))
+}
@@ -0,0 +1,70 @@
+package pubsub
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// ErrPingTimeout is the Err on the SubResp returned once a SubClient with a
+// PingInterval has closed its connection, because a PING it sent in the
+// background wasn't answered before the next one was due
+var ErrPingTimeout = errors.New("pubsub ping wasn't answered in time")
+
+// startPinging starts the go-routine sending PINGs in the background, if the
+// SubClient has a PingInterval and it hasn't already been started
+func (c *SubClient) startPinging() {
+	if c.PingInterval <= 0 {
+		return
+	}
+	c.pingOnce.Do(func() {
+		go c.pingSpin(c.PingInterval)
+	})
+}
+
+func (c *SubClient) pingSpin(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		c.l.Lock()
+		if c.pings > 0 {
+			c.pingErr = ErrPingTimeout
+			c.l.Unlock()
+			// whatever is reading from the connection will see it closed
+			c.Client.Close()
+			return
+		}
+		err := c.Client.WriteCmd("PING")
+		if err == nil {
+			c.pings++
+		}
+		c.l.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// backgroundPong is called with l held for every pong which is read, and
+// returns whether it should be dropped as the reply to a background PING.
+// Pongs can't be told apart, so if a Ping call was made at the same time the
+// caller may be handed the background PING's pong, but either way every PING
+// gets one.
+func (c *SubClient) backgroundPong(sr *SubResp) bool {
+	if sr.Type != Pong || c.pings == 0 {
+		return false
+	}
+	c.pings--
+	return true
+}
+
+// pingTimeout is called with l held for every SubResp which is read, and
+// replaces the Err of one caused by the connection being closed for a ping
+// timeout with ErrPingTimeout
+func (c *SubClient) pingTimeout(sr *SubResp) *SubResp {
+	if c.pingErr != nil && sr.Type == Error && sr.Resp.IsType(redis.IOErr) {
+		sr.Err = c.pingErr
+	}
+	return sr
+}
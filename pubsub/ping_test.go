@@ -0,0 +1,94 @@
+package pubsub
+
+import (
+	"net"
+	"strings"
+	"sync/atomic"
+	. "testing"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pingServer counts the PINGs it's sent, and if answer is set replies to each
+// one with a message followed by a pong
+func pingServer(t *T, answer bool) (net.Listener, *int64) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	var pings int64
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rr := redis.NewRespReader(conn)
+		for {
+			args, err := rr.Read().List()
+			if err != nil {
+				return
+			} else if strings.ToUpper(args[0]) != "PING" {
+				redis.NewResp(redis.AppErr).WriteTo(conn)
+				continue
+			}
+			atomic.AddInt64(&pings, 1)
+			if answer {
+				redis.NewResp([]string{"message", "a", "hi"}).WriteTo(conn)
+				redis.NewResp([]string{"pong", ""}).WriteTo(conn)
+			}
+		}
+	}()
+	return l, &pings
+}
+
+func TestPingMessage(t *T) {
+	l, _ := pingServer(t, true)
+	defer l.Close()
+	c, err := redis.DialTimeout("tcp", l.Addr().String(), 5*time.Second)
+	require.Nil(t, err)
+	defer c.Close()
+	sub := NewSubClient(c)
+
+	// the message read before the pong is kept for Receive
+	assert.Equal(t, Pong, sub.Ping().Type)
+	sr := sub.Receive()
+	assert.Equal(t, Message, sr.Type)
+	assert.Equal(t, "hi", sr.Message)
+}
+
+func TestPingInterval(t *T) {
+	l, pings := pingServer(t, true)
+	defer l.Close()
+	c, err := redis.DialTimeout("tcp", l.Addr().String(), 200*time.Millisecond)
+	require.Nil(t, err)
+	defer c.Close()
+	sub := NewSubClient(c)
+	sub.PingInterval = 20 * time.Millisecond
+
+	// background pongs aren't returned, only the messages sent with them
+	for i := 0; i < 3; i++ {
+		sr := sub.Receive()
+		require.Equal(t, Message, sr.Type, "%v", sr.Err)
+	}
+	assert.True(t, atomic.LoadInt64(pings) >= 3)
+
+	// and a Ping call still gets a pong, whichever PING it was for
+	assert.Equal(t, Pong, sub.Ping().Type)
+}
+
+func TestPingIntervalTimeout(t *T) {
+	l, pings := pingServer(t, false)
+	defer l.Close()
+	c, err := redis.Dial("tcp", l.Addr().String())
+	require.Nil(t, err)
+	sub := NewSubClient(c)
+	sub.PingInterval = 20 * time.Millisecond
+
+	sr := sub.Receive()
+	assert.Equal(t, Error, sr.Type)
+	assert.Equal(t, ErrPingTimeout, sr.Err)
+	assert.False(t, sr.Timeout())
+	assert.Equal(t, int64(1), atomic.LoadInt64(pings))
+}
@@ -0,0 +1,293 @@
+// Package pubsubtest provides an in-memory implementation of the
+// pubsub.Subscriber interface, so that code which consumes publish/subscribe
+// messages can be unit tested without needing a running redis instance.
+//
+//	sub := pubsubtest.NewSubClient()
+//	go consumeMessages(sub) // takes a pubsub.Subscriber
+//	sub.Publish("someChannel", "someMessage")
+//
+// Subscriptions and pattern matching behave the same as they do against a real
+// redis instance (patterns are matched using pubsub.MatchPattern). Timeouts and
+// connection failures can be simulated using QueueTimeout, SetTimeout and Drop.
+package pubsubtest
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/pubsub"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// ErrDropped is the error returned from all methods on a SubClient once Drop
+// has been called on it
+var ErrDropped = errors.New("use of closed network connection")
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+// SubClient is an in-memory implementation of pubsub.Subscriber. All of its
+// methods may be called from multiple go-routines at once, so that a test can
+// Publish messages while the code under test is blocked on Receive.
+type SubClient struct {
+	mu       sync.Mutex
+	channels map[string]bool
+	patterns map[string]bool
+	queue    []*pubsub.SubResp
+	timeout  time.Duration
+	dropped  bool
+
+	// notify is closed and replaced whenever queue or dropped change, in order
+	// to wake up any blocked Receive calls
+	notify chan struct{}
+}
+
+var _ pubsub.Subscriber = &SubClient{}
+
+// NewSubClient returns an initialized SubClient which isn't subscribed to
+// anything
+func NewSubClient() *SubClient {
+	return &SubClient{
+		channels: map[string]bool{},
+		patterns: map[string]bool{},
+		notify:   make(chan struct{}),
+	}
+}
+
+// must be called with mu held
+func (c *SubClient) wake() {
+	close(c.notify)
+	c.notify = make(chan struct{})
+}
+
+func errResp(r *redis.Resp) *pubsub.SubResp {
+	return &pubsub.SubResp{Resp: r, Type: pubsub.Error, Err: r.Err}
+}
+
+func droppedResp() *pubsub.SubResp {
+	return errResp(redis.NewRespIOErr(ErrDropped))
+}
+
+func timeoutResp() *pubsub.SubResp {
+	err := &net.OpError{Op: "read", Net: "tcp", Err: timeoutErr{}}
+	return errResp(redis.NewRespIOErr(err))
+}
+
+// must be called with mu held
+func (c *SubClient) subCount() int {
+	return len(c.channels) + len(c.patterns)
+}
+
+// must be called with mu held, since Drop replaces both sets
+func (c *SubClient) set(cmd string) map[string]bool {
+	if strings.HasPrefix(cmd, "p") {
+		return c.patterns
+	}
+	return c.channels
+}
+
+func (c *SubClient) subscription(cmd string, add bool, names []interface{}) *pubsub.SubResp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dropped {
+		return droppedResp()
+	}
+	set := c.set(cmd)
+
+	var strs []string
+	for _, name := range names {
+		s, err := redis.NewResp(name).Str()
+		if err != nil {
+			return errResp(redis.NewResp(err))
+		}
+		strs = append(strs, s)
+	}
+
+	if add && len(strs) == 0 {
+		err := errors.New("ERR wrong number of arguments for '" + cmd + "' command")
+		return errResp(redis.NewResp(err))
+	} else if len(strs) == 0 {
+		// unsubscribing with no arguments means unsubscribing from everything
+		for name := range set {
+			strs = append(strs, name)
+		}
+	}
+
+	typ := pubsub.Subscribe
+	if !add {
+		typ = pubsub.Unsubscribe
+	}
+
+	// redis replies to an unsubscribe-from-everything with a nil name if there
+	// was nothing to unsubscribe from
+	if len(strs) == 0 {
+		r := redis.NewResp([]interface{}{cmd, nil, c.subCount()})
+		return &pubsub.SubResp{Resp: r, Type: typ, SubCount: c.subCount()}
+	}
+
+	var sr *pubsub.SubResp
+	for _, s := range strs {
+		if add {
+			set[s] = true
+		} else {
+			delete(set, s)
+		}
+		count := c.subCount()
+		r := redis.NewResp([]interface{}{cmd, s, count})
+		sr = &pubsub.SubResp{Resp: r, Type: typ, SubCount: count}
+	}
+	return sr
+}
+
+// Subscribe subscribes to the given channels. Like pubsub.SubClient, only the
+// confirmation for the last channel is returned
+func (c *SubClient) Subscribe(channels ...interface{}) *pubsub.SubResp {
+	return c.subscription("subscribe", true, channels)
+}
+
+// PSubscribe subscribes to the given patterns. Like pubsub.SubClient, only the
+// confirmation for the last pattern is returned
+func (c *SubClient) PSubscribe(patterns ...interface{}) *pubsub.SubResp {
+	return c.subscription("psubscribe", true, patterns)
+}
+
+// Unsubscribe unsubscribes from the given channels, or all channels if none are
+// given
+func (c *SubClient) Unsubscribe(channels ...interface{}) *pubsub.SubResp {
+	return c.subscription("unsubscribe", false, channels)
+}
+
+// PUnsubscribe unsubscribes from the given patterns, or all patterns if none
+// are given
+func (c *SubClient) PUnsubscribe(patterns ...interface{}) *pubsub.SubResp {
+	return c.subscription("punsubscribe", false, patterns)
+}
+
+// Ping returns a Pong SubResp, or an error if the SubClient has been dropped
+func (c *SubClient) Ping() *pubsub.SubResp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dropped {
+		return droppedResp()
+	}
+	r := redis.NewResp([]interface{}{"pong", ""})
+	return &pubsub.SubResp{Resp: r, Type: pubsub.Pong}
+}
+
+// Receive returns the next message which was published to a subscribed channel
+// or pattern, blocking until one is available. If a timeout has been set using
+// SetTimeout and no message becomes available within it a timeout Error is
+// returned, just as it would be by pubsub.SubClient when its connection times
+// out.
+func (c *SubClient) Receive() *pubsub.SubResp {
+	c.mu.Lock()
+	timeout := c.timeout
+	c.mu.Unlock()
+
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	for {
+		c.mu.Lock()
+		if len(c.queue) > 0 {
+			sr := c.queue[0]
+			c.queue = c.queue[1:]
+			c.mu.Unlock()
+			return sr
+		} else if c.dropped {
+			c.mu.Unlock()
+			return droppedResp()
+		}
+		notify := c.notify
+		c.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-timeoutCh:
+			return timeoutResp()
+		}
+	}
+}
+
+// Publish delivers the given message to the SubClient, if it is subscribed to
+// the channel or to any patterns matching it. A message is queued for the
+// channel subscription as well as for every matching pattern, just as redis
+// does. The number of messages queued is returned.
+func (c *SubClient) Publish(channel, message string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dropped {
+		return 0
+	}
+
+	var n int
+	if c.channels[channel] {
+		r := redis.NewResp([]interface{}{"message", channel, message})
+		c.queue = append(c.queue, &pubsub.SubResp{
+			Resp:         r,
+			Type:         pubsub.Message,
+			Channel:      channel,
+			Message:      message,
+			MessageBytes: []byte(message),
+		})
+		n++
+	}
+	for pattern := range c.patterns {
+		if !pubsub.MatchPattern(pattern, channel) {
+			continue
+		}
+		r := redis.NewResp([]interface{}{"pmessage", pattern, channel, message})
+		c.queue = append(c.queue, &pubsub.SubResp{
+			Resp:         r,
+			Type:         pubsub.Message,
+			Channel:      channel,
+			Pattern:      pattern,
+			Message:      message,
+			MessageBytes: []byte(message),
+		})
+		n++
+	}
+	if n > 0 {
+		c.wake()
+	}
+	return n
+}
+
+// QueueTimeout causes the next Receive call to return a timeout Error
+// immediately, once any messages already queued have been received
+func (c *SubClient) QueueTimeout() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.queue = append(c.queue, timeoutResp())
+	c.wake()
+}
+
+// SetTimeout sets how long Receive will block waiting for a message before
+// returning a timeout Error. Zero, the default, means Receive blocks forever.
+func (c *SubClient) SetTimeout(timeout time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timeout = timeout
+}
+
+// Drop simulates the SubClient's connection being closed. Any messages already
+// queued will still be received, after which Receive, as well as every other
+// method, will return an IOErr. All subscriptions are lost.
+func (c *SubClient) Drop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dropped = true
+	c.channels = map[string]bool{}
+	c.patterns = map[string]bool{}
+	c.wake()
+}
@@ -0,0 +1,151 @@
+package pubsubtest
+
+import (
+	. "testing"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/pubsub"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribe(t *T) {
+	sub := NewSubClient()
+
+	sr := sub.Subscribe("foo", "bar")
+	require.Nil(t, sr.Err)
+	assert.Equal(t, pubsub.Subscribe, sr.Type)
+	assert.Equal(t, 2, sr.SubCount)
+
+	assert.Equal(t, 1, sub.Publish("foo", "hi"))
+	assert.Equal(t, 0, sub.Publish("baz", "hi"))
+
+	sr = sub.Receive()
+	require.Nil(t, sr.Err)
+	assert.Equal(t, pubsub.Message, sr.Type)
+	assert.Equal(t, "foo", sr.Channel)
+	assert.Equal(t, "hi", sr.Message)
+	assert.Equal(t, []byte("hi"), sr.MessageBytes)
+	assert.False(t, sr.Timeout())
+
+	sr = sub.Unsubscribe("foo")
+	require.Nil(t, sr.Err)
+	assert.Equal(t, pubsub.Unsubscribe, sr.Type)
+	assert.Equal(t, 1, sr.SubCount)
+	assert.Equal(t, 0, sub.Publish("foo", "hi"))
+
+	sr = sub.Unsubscribe()
+	require.Nil(t, sr.Err)
+	assert.Equal(t, 0, sr.SubCount)
+}
+
+func TestPSubscribe(t *T) {
+	sub := NewSubClient()
+	require.Nil(t, sub.Subscribe("news.art").Err)
+	require.Nil(t, sub.PSubscribe("news.*").Err)
+
+	// One message for the channel subscription and one for the pattern
+	assert.Equal(t, 2, sub.Publish("news.art", "hi"))
+	assert.Equal(t, 1, sub.Publish("news.tech", "bye"))
+
+	sr := sub.Receive()
+	require.Nil(t, sr.Err)
+	assert.Equal(t, "news.art", sr.Channel)
+	assert.Equal(t, "", sr.Pattern)
+
+	sr = sub.Receive()
+	require.Nil(t, sr.Err)
+	assert.Equal(t, "news.art", sr.Channel)
+	assert.Equal(t, "news.*", sr.Pattern)
+	assert.Equal(t, "hi", sr.Message)
+
+	sr = sub.Receive()
+	require.Nil(t, sr.Err)
+	assert.Equal(t, "news.tech", sr.Channel)
+	assert.Equal(t, "news.*", sr.Pattern)
+	assert.Equal(t, "bye", sr.Message)
+}
+
+func TestReceiveBlocking(t *T) {
+	sub := NewSubClient()
+	require.Nil(t, sub.Subscribe("foo").Err)
+
+	srCh := make(chan *pubsub.SubResp)
+	go func() { srCh <- sub.Receive() }()
+
+	time.Sleep(10 * time.Millisecond)
+	sub.Publish("foo", "bar")
+
+	select {
+	case sr := <-srCh:
+		require.Nil(t, sr.Err)
+		assert.Equal(t, "bar", sr.Message)
+	case <-time.After(time.Second):
+		t.Fatal("Receive never returned")
+	}
+}
+
+func TestTimeout(t *T) {
+	sub := NewSubClient()
+	require.Nil(t, sub.Subscribe("foo").Err)
+
+	sub.Publish("foo", "bar")
+	sub.QueueTimeout()
+	assert.Nil(t, sub.Receive().Err)
+	sr := sub.Receive()
+	assert.Equal(t, pubsub.Error, sr.Type)
+	assert.True(t, sr.Timeout())
+
+	sub.SetTimeout(10 * time.Millisecond)
+	sr = sub.Receive()
+	assert.Equal(t, pubsub.Error, sr.Type)
+	assert.True(t, sr.Timeout())
+
+	// Still usable after timing out
+	sub.Publish("foo", "baz")
+	sr = sub.Receive()
+	require.Nil(t, sr.Err)
+	assert.Equal(t, "baz", sr.Message)
+}
+
+func TestDrop(t *T) {
+	sub := NewSubClient()
+	require.Nil(t, sub.Subscribe("foo").Err)
+
+	srCh := make(chan *pubsub.SubResp)
+	go func() { srCh <- sub.Receive() }()
+
+	time.Sleep(10 * time.Millisecond)
+	sub.Drop()
+
+	select {
+	case sr := <-srCh:
+		assert.Equal(t, pubsub.Error, sr.Type)
+		assert.True(t, sr.IsType(redis.IOErr))
+		assert.False(t, sr.Timeout())
+	case <-time.After(time.Second):
+		t.Fatal("Receive never returned")
+	}
+
+	assert.Equal(t, 0, sub.Publish("foo", "bar"))
+	assert.NotNil(t, sub.Subscribe("foo").Err)
+	assert.NotNil(t, sub.Ping().Err)
+}
+
+// Run with -race, Drop replacing the subscriptions mustn't race with them
+// being changed
+func TestDropWhileSubscribing(t *T) {
+	sub := NewSubClient()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			sub.Subscribe("foo")
+			sub.PUnsubscribe()
+		}
+	}()
+	sub.Drop()
+	<-done
+	assert.NotNil(t, sub.Subscribe("foo").Err)
+}
@@ -6,7 +6,12 @@ import (
 	"container/list"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/mediocregopher/radix.v2/log"
 	"github.com/mediocregopher/radix.v2/redis"
 )
 
@@ -23,11 +28,70 @@ const (
 	Pong
 )
 
+// Subscriber describes the subscription and receiving functionality of a
+// SubClient. It exists so that code which consumes messages can be handed
+// something other than a SubClient, for example the in-memory implementation
+// in the pubsubtest package.
+type Subscriber interface {
+	Subscribe(channels ...interface{}) *SubResp
+	PSubscribe(patterns ...interface{}) *SubResp
+	Unsubscribe(channels ...interface{}) *SubResp
+	PUnsubscribe(patterns ...interface{}) *SubResp
+	Ping() *SubResp
+	Receive() *SubResp
+}
+
+var _ Subscriber = &SubClient{}
+
 // SubClient wraps a Redis client to provide convenience methods for Pub/Sub
 // functionality.
 type SubClient struct {
-	Client   *redis.Client
+	Client *redis.Client
+
+	// If set, used to log the connection being lost while Messages is being
+	// used, along with anything unexpected read from it. Entries have the
+	// component "pubsub" and the Client's Addr. Defaults to log.Nop. This
+	// should be set before Messages is called, if at all.
+	Logger log.Logger
+
+	// If set, a PING is sent in the background this often, so that a
+	// connection which nothing is published on isn't seen as idle by anything
+	// in between the client and redis. If a PING hasn't been answered by the
+	// time the next one is due the connection is closed, and the Error SubResp
+	// this results in has Err set to ErrPingTimeout. The pongs aren't
+	// returned from Receive or written to the Messages channel, but to be read
+	// at all Receive must be called regularly, or Messages used. Pinging
+	// starts the first time the SubClient is used, so this should be set
+	// before then, if at all.
+	PingInterval time.Duration
+
+	// Only one go-routine reads from the connection at a time, see read. l
+	// guards all of the SubClient's fields, and cond is signalled whenever
+	// they change. messages is the buffer of SubResps waiting to be returned
+	// from Receive, or written to msgCh once Messages has been called, and
+	// inFlight is the commands which have been written and are waiting for
+	// their confirmations, oldest first.
+	l        sync.Mutex
+	cond     *sync.Cond
+	reading  bool
 	messages *list.List
+	inFlight []*inFlightCmd
+
+	// the channels, patterns and shard channels currently subscribed to, as
+	// confirmed by redis
+	channels, patterns, shardChannels map[string]bool
+
+	// Everything below is only used once Messages has been called
+	msgCh chan *SubResp
+	dead  *SubResp
+
+	// Everything below is used when PingInterval is set. pings is the number
+	// of background PINGs which haven't been answered yet, and pingErr is set
+	// once the connection has been closed because of one. Both are guarded by
+	// l, which is also held whenever anything is written to the connection.
+	pingOnce sync.Once
+	pings    int
+	pingErr  error
 }
 
 // SubResp wraps a Redis resp and provides convenient access to Pub/Sub info.
@@ -40,6 +104,10 @@ type SubResp struct {
 	SubCount int    // Count of subs active after this action (Subscribe or Unsubscribe)
 	Message  string // Publish message (Message)
 	Err      error  // SubResp error (Error)
+
+	// The publish message as it was read (Message), which is the same as
+	// Message but isn't a copy, and so is better for binary payloads
+	MessageBytes []byte
 }
 
 // Timeout determines if this SubResp is an error type
@@ -52,7 +120,13 @@ func (r *SubResp) Timeout() bool {
 // SubClient, returning that. The passed in redis.Client should not be used as
 // long as the SubClient is also being used
 func NewSubClient(client *redis.Client) *SubClient {
-	return &SubClient{client, &list.List{}}
+	return &SubClient{
+		Client:        client,
+		messages:      &list.List{},
+		channels:      map[string]bool{},
+		patterns:      map[string]bool{},
+		shardChannels: map[string]bool{},
+	}
 }
 
 // Subscribe makes a Redis "SUBSCRIBE" command on the provided channels
@@ -75,8 +149,27 @@ func (c *SubClient) PUnsubscribe(patterns ...interface{}) *SubResp {
 	return c.filterMessages("PUNSUBSCRIBE", patterns...)
 }
 
+// SSubscribe makes a Redis "SSUBSCRIBE" command on the provided shard channels,
+// which are messaged with SPUBLISH. This requires redis 7.0 or later. In a
+// cluster every shard channel is sent to the node owning its slot, so the
+// connection must be to that node, and the channels in a single call must all
+// be in the same slot. See cluster's NewShardSubClient.
+//
+// If the slot is moved to another node redis unsubscribes from its channels
+// unprompted, and Receive returns the Unsubscribe SubResp.
+func (c *SubClient) SSubscribe(channels ...interface{}) *SubResp {
+	return c.filterMessages("SSUBSCRIBE", channels...)
+}
+
+// SUnsubscribe makes a Redis "SUNSUBSCRIBE" command on the provided shard
+// channels
+func (c *SubClient) SUnsubscribe(channels ...interface{}) *SubResp {
+	return c.filterMessages("SUNSUBSCRIBE", channels...)
+}
+
 // Ping will send a ping command on the connection, and returns a Pong response
-// (or error)
+// (or error). Any messages read before the pong are returned from later calls
+// to Receive.
 func (c *SubClient) Ping() *SubResp {
 	return c.filterMessages("PING")
 }
@@ -84,37 +177,210 @@ func (c *SubClient) Ping() *SubResp {
 // Receive returns the next publish resp on the Redis client. It is possible
 // Receive will timeout, and the *SubResp will be an Error. You can use the
 // Timeout() method on SubResp to easily determine if that is the case. If this
-// is the case you can call Receive again to continue listening for publishes
+// is the case you can call Receive again to continue listening for publishes.
+//
+// Receive may be called while other go-routines call Subscribe and the like,
+// the confirmations for their commands aren't returned from it. Any
+// confirmation which no call is waiting for is, e.g. redis unsubscribing from
+// shard channels unprompted.
+//
+// Once Messages has been called Receive can't be used, and always returns an
+// Error SubResp.
 func (c *SubClient) Receive() *SubResp {
-	return c.receive(false)
+	c.startPinging()
+	return c.read(nil)
 }
 
-func (c *SubClient) receive(skipBuffer bool) *SubResp {
-	if c.messages.Len() > 0 && !skipBuffer {
-		v := c.messages.Remove(c.messages.Front())
-		return v.(*SubResp)
-	}
-	r := c.Client.ReadResp()
-	return c.parseResp(r)
+// inFlightCmd is a command which has been written by a caller and is waiting
+// for n more replies to it of the given kind, e.g. "unsubscribe" or "pong". The
+// last reply is sent on ch.
+type inFlightCmd struct {
+	kind string
+	n    int
+	ch   chan *SubResp
 }
 
+// filterMessages writes the given command and waits for its confirmation. One
+// confirmation is expected per name given. With none, an unsubscribe is from
+// everything currently subscribed to, which redis confirms one at a time, and
+// anything else gets one. Any messages read in the meantime are buffered for
+// Receive, or written to the Messages channel.
 func (c *SubClient) filterMessages(cmd string, names ...interface{}) *SubResp {
-	sr := c.parseResp(c.Client.Cmd(cmd, names...))
-	i := 0
-	if sr.Type == Message {
-		c.messages.PushBack(sr)
-		i--
-	} else {
-		i++
-	}
-	for ; i < len(names); i++ {
-		sr = c.receive(true)
-		if sr.Type == Message {
+	c.startPinging()
+	ifc := &inFlightCmd{
+		kind: strings.ToLower(cmd),
+		n:    len(names),
+		ch:   make(chan *SubResp, 1),
+	}
+	if cmd == "PING" {
+		ifc.kind = "pong"
+	}
+
+	c.l.Lock()
+	if c.dead != nil {
+		c.l.Unlock()
+		return c.dead
+	}
+	if ifc.n == 0 {
+		switch cmd {
+		case "UNSUBSCRIBE":
+			ifc.n = len(c.channels)
+		case "PUNSUBSCRIBE":
+			ifc.n = len(c.patterns)
+		case "SUNSUBSCRIBE":
+			ifc.n = len(c.shardChannels)
+		}
+		// redis still replies once when there was nothing to unsubscribe from
+		if ifc.n == 0 {
+			ifc.n = 1
+		}
+	}
+	// The command is written while holding l so that the order of inFlight
+	// matches the order commands were written in, and so that it doesn't
+	// interleave with a background PING
+	if err := c.Client.WriteCmd(cmd, names...); err != nil {
+		c.l.Unlock()
+		return c.parseResp(redis.NewRespIOErr(err))
+	}
+	c.inFlight = append(c.inFlight, ifc)
+	c.l.Unlock()
+
+	return c.read(ifc)
+}
+
+// init is called with l held to set up the fields which a SubClient which
+// wasn't made by NewSubClient won't have
+func (c *SubClient) init() {
+	if c.messages == nil {
+		c.messages = new(list.List)
+	}
+	if c.cond == nil {
+		c.cond = sync.NewCond(&c.l)
+	}
+}
+
+// ready returns what read is waiting for, if it's arrived. That's the
+// confirmation for ifc or, if ifc is nil, the oldest buffered SubResp.
+func (c *SubClient) ready(ifc *inFlightCmd) (*SubResp, bool) {
+	if ifc != nil {
+		select {
+		case sr := <-ifc.ch:
+			return sr, true
+		default:
+			return nil, false
+		}
+	} else if c.messages.Len() > 0 {
+		return c.messages.Remove(c.messages.Front()).(*SubResp), true
+	}
+	return nil, false
+}
+
+// read waits for the confirmation for ifc or, if ifc is nil, for the next
+// SubResp for Receive. Whichever go-routine gets to it first reads from the
+// connection on behalf of all of them, handing what it reads to whichever
+// in-flight command it confirms or else buffering it, until it has what it's
+// waiting for itself. The others wait on cond, and one of them takes over
+// once it's done.
+func (c *SubClient) read(ifc *inFlightCmd) *SubResp {
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.init()
+
+	for {
+		if c.msgCh != nil && ifc == nil {
+			return errListeningResp()
+		} else if sr, ok := c.ready(ifc); ok {
+			return sr
+		} else if c.msgCh != nil {
+			// readSpin is reading now, and will send the confirmation on ch
+			c.l.Unlock()
+			sr := <-ifc.ch
+			c.l.Lock()
+			return sr
+		} else if !c.reading {
+			break
+		}
+		c.cond.Wait()
+	}
+
+	c.reading = true
+	defer func() {
+		c.reading = false
+		c.cond.Broadcast()
+	}()
+	for {
+		c.l.Unlock()
+		r := c.Client.ReadResp()
+		c.l.Lock()
+
+		sr := c.parseResp(r)
+		if c.backgroundPong(sr) {
+			continue
+		}
+		sr = c.pingTimeout(sr)
+
+		switch {
+		case sr.Timeout() && ifc == nil:
+			// Receive may be called again after a timeout
+			return sr
+		case r.IsType(redis.IOErr):
+			// As with Cmd, a timeout waiting on the reply to a command leaves
+			// the connection in an unknown state, so it's closed
+			if sr.Timeout() {
+				c.Client.LastCritical = r.Err
+				c.Client.Close()
+			}
+			c.failInFlight(sr)
+			if ifc == nil {
+				return sr
+			}
+		case !c.confirm(sr):
 			c.messages.PushBack(sr)
-			i--
+		}
+		c.cond.Broadcast()
+
+		if sr, ok := c.ready(ifc); ok {
+			return sr
 		}
 	}
-	return sr
+}
+
+// confirm hands sr to the oldest in-flight command if it's a reply to one,
+// returning false if it isn't. An application error means redis didn't accept
+// the command at all, so no more replies to it are coming.
+func (c *SubClient) confirm(sr *SubResp) bool {
+	if sr.Type == Message || len(c.inFlight) == 0 {
+		return false
+	}
+	ifc := c.inFlight[0]
+	if sr.Type != Error && replyKind(sr) != ifc.kind {
+		return false
+	}
+	if ifc.n--; ifc.n == 0 || sr.Type == Error {
+		c.inFlight = c.inFlight[1:]
+		ifc.ch <- sr
+	}
+	return true
+}
+
+// replyKind returns the kind of a subscription reply, which is its first
+// element, e.g. "subscribe"
+func replyKind(sr *SubResp) string {
+	elems, err := sr.Resp.Array()
+	if err != nil || len(elems) == 0 {
+		return ""
+	}
+	kind, _ := elems[0].Str()
+	return kind
+}
+
+// failInFlight hands sr to every in-flight command, once the connection has
+// failed
+func (c *SubClient) failInFlight(sr *SubResp) {
+	for _, ifc := range c.inFlight {
+		ifc.ch <- sr
+	}
+	c.inFlight = nil
 }
 
 func (c *SubClient) parseResp(resp *redis.Resp) *SubResp {
@@ -153,7 +419,7 @@ func (c *SubClient) parseResp(resp *redis.Resp) *SubResp {
 	case "pong":
 		sr.Type = Pong
 
-	case "subscribe", "psubscribe":
+	case "subscribe", "psubscribe", "ssubscribe":
 		sr.Type = Subscribe
 		count, err := elems[2].Int()
 		if err != nil {
@@ -161,9 +427,10 @@ func (c *SubClient) parseResp(resp *redis.Resp) *SubResp {
 			sr.Type = Error
 		} else {
 			sr.SubCount = int(count)
+			c.track(rtype, elems[1])
 		}
 
-	case "unsubscribe", "punsubscribe":
+	case "unsubscribe", "punsubscribe", "sunsubscribe":
 		sr.Type = Unsubscribe
 		count, err := elems[2].Int()
 		if err != nil {
@@ -171,12 +438,13 @@ func (c *SubClient) parseResp(resp *redis.Resp) *SubResp {
 			sr.Type = Error
 		} else {
 			sr.SubCount = int(count)
+			c.track(rtype, elems[1])
 		}
 
-	case "message", "pmessage":
+	case "message", "pmessage", "smessage":
 		var chanI, msgI int
 
-		if rtype == "message" {
+		if rtype != "pmessage" {
 			chanI, msgI = 1, 2
 		} else { // "pmessage"
 			chanI, msgI = 2, 3
@@ -197,12 +465,13 @@ func (c *SubClient) parseResp(resp *redis.Resp) *SubResp {
 			return sr
 		}
 		sr.Channel = channel
-		msg, err := elems[msgI].Str()
+		msg, err := elems[msgI].Bytes()
 		if err != nil {
 			sr.Err = fmt.Errorf("message msg: %s", err)
 			sr.Type = Error
 		} else {
-			sr.Message = msg
+			sr.Message = string(msg)
+			sr.MessageBytes = msg
 		}
 	default:
 		sr.Err = errors.New("suscription multiresp has invalid type: " + rtype)
@@ -210,3 +479,133 @@ func (c *SubClient) parseResp(resp *redis.Resp) *SubResp {
 	}
 	return sr
 }
+
+// track updates the sets of currently subscribed channels and patterns based
+// on a subscribe or unsubscribe confirmation from redis
+func (c *SubClient) track(rtype string, nameResp *redis.Resp) {
+	// redis sends a nil name when unsubscribing while not subscribed to
+	// anything
+	name, err := nameResp.Str()
+	if err != nil {
+		return
+	}
+
+	if c.channels == nil {
+		c.channels = map[string]bool{}
+	}
+	if c.patterns == nil {
+		c.patterns = map[string]bool{}
+	}
+	if c.shardChannels == nil {
+		c.shardChannels = map[string]bool{}
+	}
+
+	switch rtype {
+	case "subscribe":
+		c.channels[name] = true
+	case "psubscribe":
+		c.patterns[name] = true
+	case "unsubscribe":
+		delete(c.channels, name)
+	case "punsubscribe":
+		delete(c.patterns, name)
+	case "ssubscribe":
+		c.shardChannels[name] = true
+	case "sunsubscribe":
+		delete(c.shardChannels, name)
+	}
+}
+
+// SubSync describes the changes which were made by a call to
+// SyncSubscriptions
+type SubSync struct {
+	Subscribed    []string // Channels which were newly subscribed to
+	Unsubscribed  []string // Channels which were unsubscribed from
+	PSubscribed   []string // Patterns which were newly subscribed to
+	PUnsubscribed []string // Patterns which were unsubscribed from
+}
+
+// Changed returns whether or not any subscriptions were actually changed
+func (s SubSync) Changed() bool {
+	return len(s.Subscribed) > 0 || len(s.Unsubscribed) > 0 ||
+		len(s.PSubscribed) > 0 || len(s.PUnsubscribed) > 0
+}
+
+// setDiff returns the elements of want which aren't in have, and the elements
+// of have which aren't in want, both sorted
+func setDiff(have map[string]bool, want []string) ([]string, []string) {
+	wantM := make(map[string]bool, len(want))
+	var add, rm []string
+	for _, w := range want {
+		if wantM[w] {
+			continue
+		}
+		wantM[w] = true
+		if !have[w] {
+			add = append(add, w)
+		}
+	}
+	for h := range have {
+		if !wantM[h] {
+			rm = append(rm, h)
+		}
+	}
+	sort.Strings(add)
+	sort.Strings(rm)
+	return add, rm
+}
+
+func stringsToIfaces(ss []string) []interface{} {
+	is := make([]interface{}, len(ss))
+	for i := range ss {
+		is[i] = ss[i]
+	}
+	return is
+}
+
+// SyncSubscriptions takes the full set of channels and patterns which should be
+// subscribed to, compares them against the set which are currently subscribed
+// to, and issues only the SUBSCRIBE, PSUBSCRIBE, UNSUBSCRIBE and PUNSUBSCRIBE
+// commands necessary to make the two match (at most one of each). The returned
+// SubSync describes what was changed. If nothing needs changing no commands are
+// sent at all.
+//
+// If an error is encountered partway through the returned SubSync will
+// describe the changes which were made before it occurred.
+func (c *SubClient) SyncSubscriptions(
+	channels, patterns []string,
+) (
+	SubSync, error,
+) {
+	var ss SubSync
+	c.l.Lock()
+	subs, unsubs := setDiff(c.channels, channels)
+	psubs, punsubs := setDiff(c.patterns, patterns)
+	c.l.Unlock()
+
+	if len(subs) > 0 {
+		if sr := c.Subscribe(stringsToIfaces(subs)...); sr.Err != nil {
+			return ss, sr.Err
+		}
+		ss.Subscribed = subs
+	}
+	if len(psubs) > 0 {
+		if sr := c.PSubscribe(stringsToIfaces(psubs)...); sr.Err != nil {
+			return ss, sr.Err
+		}
+		ss.PSubscribed = psubs
+	}
+	if len(unsubs) > 0 {
+		if sr := c.Unsubscribe(stringsToIfaces(unsubs)...); sr.Err != nil {
+			return ss, sr.Err
+		}
+		ss.Unsubscribed = unsubs
+	}
+	if len(punsubs) > 0 {
+		if sr := c.PUnsubscribe(stringsToIfaces(punsubs)...); sr.Err != nil {
+			return ss, sr.Err
+		}
+		ss.PUnsubscribed = punsubs
+	}
+	return ss, nil
+}
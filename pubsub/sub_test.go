@@ -1,8 +1,19 @@
 package pubsub
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
 	. "testing"
 	"time"
 
@@ -29,6 +40,41 @@ func testClients(t *T, timeout time.Duration) (*redis.Client, *SubClient) {
 	return pub, NewSubClient(sub)
 }
 
+func TestParseRespMessageBytes(t *T) {
+	bin := "\xff\x00\xfe\r\n"
+	r := redis.NewRespReader(bytes.NewBufferString(
+		"*3\r\n$7\r\nmessage\r\n$3\r\nfoo\r\n$5\r\n" + bin + "\r\n",
+	)).Read()
+	sr := NewSubClient(nil).parseResp(r)
+	require.Nil(t, sr.Err)
+	assert.Equal(t, Message, sr.Type)
+	assert.Equal(t, "foo", sr.Channel)
+	assert.Equal(t, bin, sr.Message)
+	assert.Equal(t, []byte(bin), sr.MessageBytes)
+}
+
+func TestParseRespShard(t *T) {
+	c := NewSubClient(nil)
+	r := redis.NewResp([]interface{}{"ssubscribe", "foo", 1})
+	sr := c.parseResp(r)
+	require.Nil(t, sr.Err)
+	assert.Equal(t, Subscribe, sr.Type)
+	assert.True(t, c.shardChannels["foo"])
+
+	r = redis.NewResp([]interface{}{"smessage", "foo", "bar"})
+	sr = c.parseResp(r)
+	require.Nil(t, sr.Err)
+	assert.Equal(t, Message, sr.Type)
+	assert.Equal(t, "foo", sr.Channel)
+	assert.Equal(t, "bar", sr.Message)
+
+	r = redis.NewResp([]interface{}{"sunsubscribe", "foo", 0})
+	sr = c.parseResp(r)
+	require.Nil(t, sr.Err)
+	assert.Equal(t, Unsubscribe, sr.Type)
+	assert.False(t, c.shardChannels["foo"])
+}
+
 // Test that pubsub is still usable after a timeout
 func TestTimeout(t *T) {
 	go func() {
@@ -157,6 +203,11 @@ func TestMultiSubscribe(t *T) {
 	assertPub(ch1, randStr())
 	assertPub(ch2, randStr())
 
+	// a payload which isn't valid UTF-8 comes through as it was published
+	bin := []byte{0xff, 0x00, 0xfe, '\r', '\n'}
+	assertPub(ch1, string(bin))
+	assert.Equal(t, bin, sr.MessageBytes)
+
 	sr = sub.Unsubscribe(ch1)
 	require.Nil(t, sr.Err)
 	assert.Equal(t, Unsubscribe, sr.Type)
@@ -213,3 +264,245 @@ func TestPing(t *T) {
 	assertRcv(<-msgs)
 	assertPing()
 }
+
+func TestSyncSubscriptions(t *T) {
+	pub, sub := testClients(t, 10*time.Second)
+	ch1, ch2, ch3 := randStr(), randStr(), randStr()
+	pattern := randStr() + "*"
+
+	ss, err := sub.SyncSubscriptions([]string{ch1, ch2}, []string{pattern})
+	require.Nil(t, err)
+	assert.True(t, ss.Changed())
+	assert.Len(t, ss.Subscribed, 2)
+	assert.Contains(t, ss.Subscribed, ch1)
+	assert.Contains(t, ss.Subscribed, ch2)
+	assert.Equal(t, []string{pattern}, ss.PSubscribed)
+	assert.Empty(t, ss.Unsubscribed)
+	assert.Empty(t, ss.PUnsubscribed)
+
+	ss, err = sub.SyncSubscriptions([]string{ch2, ch3}, nil)
+	require.Nil(t, err)
+	assert.Equal(t, []string{ch3}, ss.Subscribed)
+	assert.Equal(t, []string{ch1}, ss.Unsubscribed)
+	assert.Empty(t, ss.PSubscribed)
+	assert.Equal(t, []string{pattern}, ss.PUnsubscribed)
+
+	// Make sure the subscriptions actually took
+	require.Nil(t, pub.Cmd("PUBLISH", ch1, randStr()).Err)
+	msg := randStr()
+	require.Nil(t, pub.Cmd("PUBLISH", ch3, msg).Err)
+	sr := sub.Receive()
+	require.Nil(t, sr.Err)
+	assert.Equal(t, ch3, sr.Channel)
+	assert.Equal(t, msg, sr.Message)
+
+	// Syncing the same set again shouldn't touch the network at all, which we
+	// prove by closing the connection first
+	sub.Client.Close()
+	ss, err = sub.SyncSubscriptions([]string{ch3, ch2, ch2}, nil)
+	require.Nil(t, err)
+	assert.False(t, ss.Changed())
+}
+
+// publishingSubServer answers SUBSCRIBE, UNSUBSCRIBE and PING like redis does,
+// and once the first command is read publishes n messages, "0" to n-1, on the
+// channel "pub" while it carries on answering them
+func publishingSubServer(t *T, n int) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var wl sync.Mutex
+		write := func(r *redis.Resp) {
+			wl.Lock()
+			defer wl.Unlock()
+			r.WriteTo(conn)
+		}
+		var once sync.Once
+		publish := func() {
+			for i := 0; i < n; i++ {
+				write(redis.NewResp([]string{"message", "pub", strconv.Itoa(i)}))
+				if i%10 == 0 {
+					time.Sleep(time.Millisecond)
+				}
+			}
+		}
+
+		rr := redis.NewRespReader(conn)
+		subs := map[string]bool{}
+		for {
+			args, err := rr.Read().List()
+			if err != nil {
+				return
+			}
+			once.Do(func() { go publish() })
+			switch strings.ToUpper(args[0]) {
+			case "SUBSCRIBE":
+				for _, ch := range args[1:] {
+					subs[ch] = true
+					write(redis.NewResp([]interface{}{"subscribe", ch, len(subs)}))
+				}
+			case "UNSUBSCRIBE":
+				chs := args[1:]
+				if len(chs) == 0 {
+					for ch := range subs {
+						chs = append(chs, ch)
+					}
+				}
+				if len(chs) == 0 {
+					write(redis.NewResp([]interface{}{"unsubscribe", nil, 0}))
+				}
+				for _, ch := range chs {
+					delete(subs, ch)
+					write(redis.NewResp([]interface{}{"unsubscribe", ch, len(subs)}))
+				}
+			case "PING":
+				write(redis.NewResp([]string{"pong", ""}))
+			default:
+				write(redis.NewResp(redis.AppErr))
+			}
+		}
+	}()
+	return l
+}
+
+func TestSubscribeWhileReceiving(t *T) {
+	const n = 500
+	l := publishingSubServer(t, n)
+	defer l.Close()
+	c, err := redis.DialTimeout("tcp", l.Addr().String(), 5*time.Second)
+	require.Nil(t, err)
+	sub := NewSubClient(c)
+
+	sr := sub.Subscribe("pub")
+	require.Nil(t, sr.Err)
+	assert.Equal(t, 1, sr.SubCount)
+
+	// every message is received, in order, while another go-routine's
+	// confirmations all go to it
+	subErrCh := make(chan error, 1)
+	go func() {
+		for i := 0; ; i++ {
+			sr := sub.Subscribe("other")
+			if sr.Type != Subscribe || sr.SubCount != 2 {
+				subErrCh <- fmt.Errorf("subscribe %d: %v %v", i, sr.Type, sr.Err)
+				return
+			}
+			sr = sub.Unsubscribe("other")
+			if sr.Type != Unsubscribe || sr.SubCount != 1 {
+				subErrCh <- fmt.Errorf("unsubscribe %d: %v %v", i, sr.Type, sr.Err)
+				return
+			} else if i >= 100 {
+				subErrCh <- nil
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		sr := sub.Receive()
+		require.Nil(t, sr.Err)
+		require.Equal(t, Message, sr.Type)
+		assert.Equal(t, "pub", sr.Channel)
+		assert.Equal(t, strconv.Itoa(i), sr.Message)
+	}
+	assert.Nil(t, <-subErrCh)
+}
+
+func TestUnsubscribeAllWhileSubscribing(t *T) {
+	l := publishingSubServer(t, 0)
+	defer l.Close()
+	c, err := redis.DialTimeout("tcp", l.Addr().String(), 5*time.Second)
+	require.Nil(t, err)
+	sub := NewSubClient(c)
+
+	for i := 0; i < 50; i++ {
+		require.Nil(t, sub.Subscribe("a", "b", "c").Err)
+
+		// redis confirms an unsubscribe from everything once per channel, and
+		// none of those confirmations go to the Subscribe, whichever of the
+		// two is sent first
+		subCh := make(chan *SubResp, 1)
+		go func() { subCh <- sub.Subscribe("d") }()
+		sr := sub.Unsubscribe()
+		require.Nil(t, sr.Err)
+		assert.Equal(t, Unsubscribe, sr.Type)
+		sr = <-subCh
+		require.Nil(t, sr.Err)
+		assert.Equal(t, Subscribe, sr.Type)
+
+		require.Nil(t, sub.Unsubscribe().Err)
+		assert.Equal(t, Pong, sub.Ping().Type)
+	}
+}
+
+// tlsSubServer answers the first SUBSCRIBE over TLS, with a self-signed
+// certificate for 127.0.0.1 which the returned config trusts, and then
+// publishes "foo" on the channel each time publish is written to
+func tlsSubServer(t *T) (net.Listener, *tls.Config, chan<- struct{}) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	l = tls.NewListener(l, &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	publish := make(chan struct{})
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		args, err := redis.NewRespReader(conn).Read().List()
+		if err != nil {
+			return
+		}
+		redis.NewResp([]interface{}{"subscribe", args[1], 1}).WriteTo(conn)
+		for range publish {
+			redis.NewResp([]string{"message", args[1], "foo"}).WriteTo(conn)
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return l, &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}, publish
+}
+
+// Test that pubsub over TLS is still usable after a timeout, as in TestTimeout
+func TestTimeoutTLS(t *T) {
+	l, cfg, publish := tlsSubServer(t)
+	defer l.Close()
+	defer close(publish)
+	c, err := redis.DialTimeoutTLS("tcp", l.Addr().String(), 100*time.Millisecond, cfg)
+	require.Nil(t, err)
+	sub := NewSubClient(c)
+	require.Nil(t, sub.Subscribe("timeoutTestChannel").Err)
+
+	r := sub.Receive()
+	assert.Equal(t, Error, r.Type)
+	assert.True(t, r.Timeout())
+
+	publish <- struct{}{}
+	r = sub.Receive()
+	require.Nil(t, r.Err)
+	assert.Equal(t, Message, r.Type)
+	assert.Equal(t, "timeoutTestChannel", r.Channel)
+	assert.Equal(t, "foo", r.Message)
+}
@@ -0,0 +1,305 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// exclusive returns an error if more than one of the named flags is set
+func exclusive(flags ...interface{}) error {
+	var set []string
+	for i := 0; i < len(flags); i += 2 {
+		if flags[i+1].(bool) {
+			set = append(set, flags[i].(string))
+		}
+	}
+	if len(set) > 1 {
+		return fmt.Errorf("%s can't be used together", strings.Join(set, " and "))
+	}
+	return nil
+}
+
+// ZMember is a member of a sorted set and its score, as given to ZAddArgs
+type ZMember struct {
+	Score  float64
+	Member string
+}
+
+// ZAddArgs describes a ZADD command. Its Args are checked for flags which
+// can't be used together before anything is sent, rather than redis replying
+// with a syntax error:
+//
+//	// ZADD scores GT CH 10 alice 20 bob
+//	n, err := redis.ZAddArgs{
+//		Key:     "scores",
+//		GT:      true,
+//		CH:      true,
+//		Members: []redis.ZMember{
+//			{Score: 10, Member: "alice"},
+//			{Score: 20, Member: "bob"},
+//		},
+//	}.Cmd(client).Int()
+type ZAddArgs struct {
+	Key string
+
+	// Only add new members (NX), or only update existing ones (XX)
+	NX, XX bool
+
+	// Only update a member's score if the new one is greater (GT) or less
+	// (LT) than it. Neither can be used with NX.
+	GT, LT bool
+
+	// Reply with the number of members which were added or had their score
+	// changed, rather than only those added
+	CH bool
+
+	// Increment the member's score rather than setting it, like ZINCRBY. The
+	// reply is the new score, or Nil if the member wasn't changed because of
+	// one of the flags above. Only one member can be given.
+	Incr bool
+
+	Members []ZMember
+}
+
+// Args returns the arguments for ZADD, starting with the Key, or an error if
+// the flags can't be used together or there are no members
+func (a ZAddArgs) Args() ([]interface{}, error) {
+	if err := exclusive("NX", a.NX, "XX", a.XX); err != nil {
+		return nil, err
+	} else if err := exclusive("GT", a.GT, "LT", a.LT, "NX", a.NX); err != nil {
+		return nil, err
+	} else if len(a.Members) == 0 {
+		return nil, errors.New("ZADD needs at least one member")
+	} else if a.Incr && len(a.Members) > 1 {
+		return nil, errors.New("INCR can only be used with one member")
+	}
+
+	args := make([]interface{}, 0, 7+2*len(a.Members))
+	args = append(args, a.Key)
+	args = appendFlags(args, "NX", a.NX, "XX", a.XX, "GT", a.GT, "LT", a.LT, "CH", a.CH, "INCR", a.Incr)
+	for _, m := range a.Members {
+		args = append(args, m.Score, m.Member)
+	}
+	return args, nil
+}
+
+// Cmd sends the ZADD command on the given Cmder, or returns an AppErr Resp
+// without sending anything if Args returns an error
+func (a ZAddArgs) Cmd(c Cmder) *Resp {
+	return cmdArgs(c, "ZADD", a.Args)
+}
+
+// GeoLocation is a member of a geo set. It's given to GeoAddArgs, and
+// GEOSEARCH replies may be decoded into a []GeoLocation with Into, in which
+// case Lon and Lat are only filled in if WITHCOORD was used, and Dist if
+// WITHDIST was.
+type GeoLocation struct {
+	Member   string
+	Lon, Lat float64
+	Dist     float64
+}
+
+// decodeGeoLocation decodes an element of a GEOSEARCH or GEORADIUS reply, which
+// is either just the member's name, or an Array of the name followed by
+// whichever of the distance (a BulkStr), the hash (an Int) and the coordinates
+// (an Array) were asked for, in that order
+func decodeGeoLocation(v reflect.Value, r *Resp) error {
+	var loc GeoLocation
+	if !r.IsType(Array) {
+		s, err := r.Str()
+		if err != nil {
+			return err
+		}
+		loc.Member = s
+		v.Set(reflect.ValueOf(loc))
+		return nil
+	}
+
+	a, err := r.Array()
+	if err != nil {
+		return err
+	} else if len(a) == 0 {
+		return errors.New("empty geo location")
+	}
+	if loc.Member, err = a[0].Str(); err != nil {
+		return err
+	}
+	for _, e := range a[1:] {
+		switch {
+		case e.IsType(Array):
+			coord, err := e.Float64s()
+			if err != nil {
+				return err
+			} else if len(coord) != 2 {
+				return fmt.Errorf("geo coordinates have %d elements", len(coord))
+			}
+			loc.Lon, loc.Lat = coord[0], coord[1]
+		case e.IsType(Str):
+			if loc.Dist, err = e.Float64(); err != nil {
+				return err
+			}
+		}
+		// the hash, an Int, isn't kept
+	}
+	v.Set(reflect.ValueOf(loc))
+	return nil
+}
+
+// GeoAddArgs describes a GEOADD command, see ZAddArgs
+type GeoAddArgs struct {
+	Key string
+
+	// Only add new members (NX), or only update existing ones (XX)
+	NX, XX bool
+
+	// Reply with the number of members which were added or moved, rather
+	// than only those added
+	CH bool
+
+	// The members to add, only their Member, Lon and Lat are used
+	Locations []GeoLocation
+}
+
+// Args returns the arguments for GEOADD, starting with the Key, or an error if
+// the flags can't be used together or there are no locations
+func (a GeoAddArgs) Args() ([]interface{}, error) {
+	if err := exclusive("NX", a.NX, "XX", a.XX); err != nil {
+		return nil, err
+	} else if len(a.Locations) == 0 {
+		return nil, errors.New("GEOADD needs at least one location")
+	}
+
+	args := make([]interface{}, 0, 4+3*len(a.Locations))
+	args = append(args, a.Key)
+	args = appendFlags(args, "NX", a.NX, "XX", a.XX, "CH", a.CH)
+	for _, l := range a.Locations {
+		args = append(args, l.Lon, l.Lat, l.Member)
+	}
+	return args, nil
+}
+
+// Cmd sends the GEOADD command on the given Cmder, or returns an AppErr Resp
+// without sending anything if Args returns an error
+func (a GeoAddArgs) Cmd(c Cmder) *Resp {
+	return cmdArgs(c, "GEOADD", a.Args)
+}
+
+// GeoSearchArgs describes a GEOSEARCH command. The search is either from
+// FromMember or from FromLonLat's Lon and Lat, and is either within Radius or
+// within the box of Width and Height, all in Unit.
+//
+//	// GEOSEARCH places FROMLONLAT -0.1 51.5 BYRADIUS 5 km ASC WITHDIST
+//	var locs []redis.GeoLocation
+//	err := redis.GeoSearchArgs{
+//		Key:        "places",
+//		FromLonLat: true,
+//		Lon:        -0.1,
+//		Lat:        51.5,
+//		Radius:     5,
+//		Unit:       "km",
+//		Asc:        true,
+//		WithDist:   true,
+//	}.Cmd(client).Into(&locs)
+type GeoSearchArgs struct {
+	Key string
+
+	// Exactly one of these must be set
+	FromMember string
+	FromLonLat bool
+	Lon, Lat   float64
+
+	// Exactly one of Radius, or Width and Height, must be set
+	Radius        float64
+	Width, Height float64
+
+	// One of "m", "km", "ft" or "mi". Defaults to "m".
+	Unit string
+
+	// Sort the results by distance, nearest first (Asc) or furthest first
+	// (Desc)
+	Asc, Desc bool
+
+	// If set, at most this many results are returned. With Any they're the
+	// first found rather than the nearest.
+	Count int
+	Any   bool
+
+	// Include each result's coordinates, distance, and hash in the reply
+	WithCoord, WithDist, WithHash bool
+}
+
+// Args returns the arguments for GEOSEARCH, starting with the Key, or an error
+// if they don't describe a single search
+func (a GeoSearchArgs) Args() ([]interface{}, error) {
+	fromMember := a.FromMember != ""
+	byBox := a.Width != 0 || a.Height != 0
+	if err := exclusive("FROMMEMBER", fromMember, "FROMLONLAT", a.FromLonLat); err != nil {
+		return nil, err
+	} else if !fromMember && !a.FromLonLat {
+		return nil, errors.New("GEOSEARCH needs FROMMEMBER or FROMLONLAT")
+	} else if err := exclusive("BYRADIUS", a.Radius != 0, "BYBOX", byBox); err != nil {
+		return nil, err
+	} else if a.Radius == 0 && (a.Width == 0 || a.Height == 0) {
+		return nil, errors.New("GEOSEARCH needs BYRADIUS or BYBOX with a width and height")
+	} else if err := exclusive("ASC", a.Asc, "DESC", a.Desc); err != nil {
+		return nil, err
+	} else if a.Any && a.Count == 0 {
+		return nil, errors.New("ANY can only be used with COUNT")
+	}
+
+	unit := a.Unit
+	if unit == "" {
+		unit = "m"
+	}
+	switch unit {
+	case "m", "km", "ft", "mi":
+	default:
+		return nil, fmt.Errorf("unknown unit %q", a.Unit)
+	}
+
+	args := make([]interface{}, 0, 16)
+	args = append(args, a.Key)
+	if fromMember {
+		args = append(args, "FROMMEMBER", a.FromMember)
+	} else {
+		args = append(args, "FROMLONLAT", a.Lon, a.Lat)
+	}
+	if a.Radius != 0 {
+		args = append(args, "BYRADIUS", a.Radius, unit)
+	} else {
+		args = append(args, "BYBOX", a.Width, a.Height, unit)
+	}
+	args = appendFlags(args, "ASC", a.Asc, "DESC", a.Desc)
+	if a.Count > 0 {
+		args = append(args, "COUNT", a.Count)
+		args = appendFlags(args, "ANY", a.Any)
+	}
+	args = appendFlags(args, "WITHCOORD", a.WithCoord, "WITHDIST", a.WithDist, "WITHHASH", a.WithHash)
+	return args, nil
+}
+
+// Cmd sends the GEOSEARCH command on the given Cmder, or returns an AppErr Resp
+// without sending anything if Args returns an error
+func (a GeoSearchArgs) Cmd(c Cmder) *Resp {
+	return cmdArgs(c, "GEOSEARCH", a.Args)
+}
+
+// appendFlags appends the name of each of the named flags which is set
+func appendFlags(args []interface{}, flags ...interface{}) []interface{} {
+	for i := 0; i < len(flags); i += 2 {
+		if flags[i+1].(bool) {
+			args = append(args, flags[i])
+		}
+	}
+	return args
+}
+
+func cmdArgs(c Cmder, cmd string, argsFn func() ([]interface{}, error)) *Resp {
+	args, err := argsFn()
+	if err != nil {
+		return NewResp(err)
+	}
+	return c.Cmd(cmd, args...)
+}
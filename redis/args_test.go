@@ -0,0 +1,115 @@
+package redis
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// argsCmder records the command it's sent rather than sending it
+type argsCmder struct {
+	cmd  string
+	args []interface{}
+}
+
+func (c *argsCmder) Cmd(cmd string, args ...interface{}) *Resp {
+	c.cmd, c.args = cmd, args
+	return NewResp("OK")
+}
+
+func TestZAddArgs(t *T) {
+	var c argsCmder
+	r := ZAddArgs{
+		Key:     "scores",
+		GT:      true,
+		CH:      true,
+		Members: []ZMember{{10, "alice"}, {2.5, "bob"}},
+	}.Cmd(&c)
+	require.Nil(t, r.Err)
+	assert.Equal(t, "ZADD", c.cmd)
+	assert.Equal(t, []interface{}{"scores", "GT", "CH", float64(10), "alice", 2.5, "bob"}, c.args)
+
+	bad := []ZAddArgs{
+		{Key: "k", NX: true, XX: true, Members: []ZMember{{1, "a"}}},
+		{Key: "k", GT: true, LT: true, Members: []ZMember{{1, "a"}}},
+		{Key: "k", NX: true, GT: true, Members: []ZMember{{1, "a"}}},
+		{Key: "k", Incr: true, Members: []ZMember{{1, "a"}, {2, "b"}}},
+		{Key: "k"},
+	}
+	for _, a := range bad {
+		c = argsCmder{}
+		r := a.Cmd(&c)
+		assert.True(t, r.IsType(AppErr), "%+v", a)
+		assert.Equal(t, "", c.cmd, "%+v", a)
+	}
+}
+
+func TestGeoAddArgs(t *T) {
+	args, err := GeoAddArgs{
+		Key:       "places",
+		XX:        true,
+		Locations: []GeoLocation{{Member: "home", Lon: -0.1, Lat: 51.5}},
+	}.Args()
+	require.Nil(t, err)
+	assert.Equal(t, []interface{}{"places", "XX", -0.1, 51.5, "home"}, args)
+
+	_, err = GeoAddArgs{Key: "places"}.Args()
+	assert.NotNil(t, err)
+}
+
+func TestGeoSearchArgs(t *T) {
+	args, err := GeoSearchArgs{
+		Key:        "places",
+		FromMember: "home",
+		Width:      2,
+		Height:     3,
+		Unit:       "km",
+		Desc:       true,
+		Count:      5,
+		Any:        true,
+		WithCoord:  true,
+		WithDist:   true,
+	}.Args()
+	require.Nil(t, err)
+	assert.Equal(t, []interface{}{
+		"places", "FROMMEMBER", "home", "BYBOX", float64(2), float64(3), "km",
+		"DESC", "COUNT", 5, "ANY", "WITHCOORD", "WITHDIST",
+	}, args)
+
+	args, err = GeoSearchArgs{Key: "places", FromLonLat: true, Lon: 1, Lat: 2, Radius: 10}.Args()
+	require.Nil(t, err)
+	assert.Equal(t, []interface{}{"places", "FROMLONLAT", float64(1), float64(2), "BYRADIUS", float64(10), "m"}, args)
+
+	bad := []GeoSearchArgs{
+		{Key: "k", Radius: 1},
+		{Key: "k", FromMember: "a", FromLonLat: true, Radius: 1},
+		{Key: "k", FromMember: "a"},
+		{Key: "k", FromMember: "a", Radius: 1, Width: 1, Height: 1},
+		{Key: "k", FromMember: "a", Width: 1},
+		{Key: "k", FromMember: "a", Radius: 1, Asc: true, Desc: true},
+		{Key: "k", FromMember: "a", Radius: 1, Any: true},
+		{Key: "k", FromMember: "a", Radius: 1, Unit: "yd"},
+	}
+	for _, a := range bad {
+		_, err := a.Args()
+		assert.NotNil(t, err, "%+v", a)
+	}
+}
+
+func TestIntoGeoLocations(t *T) {
+	var locs []GeoLocation
+	r := NewResp([]interface{}{
+		[]interface{}{"home", "1.5", 3479099956230698, []interface{}{"-0.1", "51.5"}},
+		[]interface{}{"work", "2.25"},
+	})
+	require.Nil(t, r.Into(&locs))
+	assert.Equal(t, []GeoLocation{
+		{Member: "home", Lon: -0.1, Lat: 51.5, Dist: 1.5},
+		{Member: "work", Dist: 2.25},
+	}, locs)
+
+	// without any of the WITH flags the reply is just the members
+	require.Nil(t, NewResp([]string{"home", "work"}).Into(&locs))
+	assert.Equal(t, []GeoLocation{{Member: "home"}, {Member: "work"}}, locs)
+}
@@ -0,0 +1,255 @@
+package redis
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/log"
+)
+
+var (
+	// ErrAsyncBlocking is the error on the Resp of a Future for a command
+	// which was rejected by AsyncClient because it would block the connection
+	// or change it into a different mode, e.g. BLPOP or SUBSCRIBE
+	ErrAsyncBlocking = errors.New("blocking and subscribe commands can't be used with AsyncClient")
+
+	// ErrAsyncClosed is the error on the Resp of a Future for a command which
+	// was sent after Close was called on the AsyncClient, or which was still
+	// outstanding when it was
+	ErrAsyncClosed = errors.New("async client is closed")
+)
+
+// asyncMaxOutstanding is the most commands which may be waiting for their
+// replies at once. CmdAsync blocks once this is reached, until replies are
+// read.
+const asyncMaxOutstanding = 4096
+
+// Future is the eventual reply to a command sent using AsyncClient's CmdAsync
+type Future struct {
+	r    *Resp
+	done chan struct{}
+
+	// set if the Client has a Trace
+	cmd   string
+	start time.Time
+}
+
+func (f *Future) resolve(r *Resp) {
+	f.r = r
+	close(f.done)
+}
+
+// Resp blocks until the reply to the command has been read, and returns it
+func (f *Future) Resp() *Resp {
+	<-f.done
+	return f.r
+}
+
+// Done returns a channel which is closed once the reply to the command has
+// been read, at which point Resp won't block
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// AsyncClient wraps a Client so that commands may be sent without waiting for
+// their replies, keeping the connection busy even when each command takes a
+// long round trip. CmdAsync writes the command and returns immediately, and a
+// single background go-routine reads the replies in the order the commands
+// were sent, resolving their Futures. All methods may be called from multiple
+// go-routines at once.
+//
+// If there's an error writing or reading then every outstanding Future, and
+// every one from a later CmdAsync, is resolved with that error as an IOErr.
+// The AsyncClient can't be used again after that, and should be closed.
+//
+// Commands which block the connection (e.g. BLPOP or WAIT) or change it into
+// a different mode (e.g. SUBSCRIBE or MONITOR) would hold up or break every
+// other command, so they're rejected with ErrAsyncBlocking.
+type AsyncClient struct {
+	c *Client
+
+	// l protects writing to the connection and sending on pending, so that
+	// commands are added to pending in the same order they're written
+	l       sync.Mutex
+	pending chan *Future
+	buf     *bytes.Buffer
+
+	// errL is separate from l so the reader never waits on a writer, which
+	// may itself be waiting on the reader to make room in pending
+	errL sync.Mutex
+	err  error
+
+	readDone chan struct{}
+}
+
+// NewAsyncClient returns an AsyncClient wrapping the given Client. The Client
+// shouldn't be used directly from then on. The Client's timeout, if any, is
+// used as the limit on how long each reply may take once the previous one has
+// been read.
+func NewAsyncClient(c *Client) *AsyncClient {
+	ac := &AsyncClient{
+		c:        c,
+		pending:  make(chan *Future, asyncMaxOutstanding),
+		buf:      new(bytes.Buffer),
+		readDone: make(chan struct{}),
+	}
+	go ac.readSpin()
+	return ac
+}
+
+func (ac *AsyncClient) readSpin() {
+	defer close(ac.readDone)
+	for f := range ac.pending {
+		if err := ac.getErr(); err != nil {
+			ac.resolve(f, NewRespIOErr(err))
+			continue
+		}
+
+		r := ac.read()
+		if r.IsType(IOErr) {
+			ac.poison(r.Err)
+		}
+		ac.resolve(f, r)
+	}
+}
+
+// resolve resolves the Future of a command which has been sent, with its Trace
+// being told first
+func (ac *AsyncClient) resolve(f *Future, r *Resp) {
+	if ac.c.trace != nil {
+		ac.c.traceDone(f.cmd, r, f.start)
+	}
+	f.resolve(r)
+}
+
+// read reads the next reply, handing off any push messages read before it
+func (ac *AsyncClient) read() *Resp {
+	for {
+		if ac.c.readTimeout != 0 {
+			ac.c.conn.SetReadDeadline(time.Now().Add(ac.c.readTimeout))
+		}
+		r := ac.c.respReader.Read()
+		if !r.IsPush() {
+			return r
+		}
+		ac.c.push(r)
+	}
+}
+
+func (ac *AsyncClient) getErr() error {
+	ac.errL.Lock()
+	defer ac.errL.Unlock()
+	return ac.err
+}
+
+// poison records the error which will be returned for every command from now
+// on, and closes the connection so that any blocked read or write returns
+func (ac *AsyncClient) poison(err error) {
+	ac.errL.Lock()
+	defer ac.errL.Unlock()
+	if ac.err != nil {
+		return
+	}
+	ac.err = err
+	ac.c.LastCritical = err
+	ac.c.Close()
+	ac.c.logger.Log(log.Warn, "connection failed", log.KV{
+		log.KeyOperation: "async",
+		log.KeyErr:       err,
+	})
+}
+
+// asyncBlockingCmds are the commands which an AsyncClient rejects
+var asyncBlockingCmds = map[string]bool{
+	"BLPOP": true, "BRPOP": true, "BRPOPLPUSH": true, "BLMOVE": true,
+	"BLMPOP": true, "BZPOPMIN": true, "BZPOPMAX": true, "BZMPOP": true,
+	"WAIT": true, "WAITAOF": true, "MONITOR": true, "SYNC": true, "PSYNC": true,
+	"SUBSCRIBE": true, "PSUBSCRIBE": true, "SSUBSCRIBE": true,
+	"UNSUBSCRIBE": true, "PUNSUBSCRIBE": true, "SUNSUBSCRIBE": true,
+}
+
+func isAsyncBlocking(cmd string, args []interface{}) bool {
+	cmd = strings.ToUpper(cmd)
+	if asyncBlockingCmds[cmd] {
+		return true
+	} else if cmd != "XREAD" && cmd != "XREADGROUP" {
+		return false
+	}
+	for _, arg := range flatten(args) {
+		if s, ok := arg.(string); ok && strings.EqualFold(s, "BLOCK") {
+			return true
+		}
+	}
+	return false
+}
+
+// CmdAsync sends the given command and returns a Future for its reply without
+// waiting for it. It only blocks if the limit on outstanding commands has been
+// reached.
+func (ac *AsyncClient) CmdAsync(cmd string, args ...interface{}) *Future {
+	f := &Future{done: make(chan struct{})}
+	if isAsyncBlocking(cmd, args) {
+		f.resolve(NewResp(ErrAsyncBlocking))
+		return f
+	}
+
+	ac.l.Lock()
+	defer ac.l.Unlock()
+	if err := ac.getErr(); err != nil {
+		f.resolve(NewRespIOErr(err))
+		return f
+	}
+
+	if ac.c.trace != nil {
+		f.cmd, f.start = cmd, ac.c.traceStart(cmd, args)
+	}
+	ac.buf.Reset()
+	if err := encodeRequest(ac.buf, ac.c.writeScratch, request{cmd, args}); err != nil {
+		ac.resolve(f, NewResp(err))
+		return f
+	}
+	if ac.c.writeTimeout != 0 {
+		ac.c.conn.SetWriteDeadline(time.Now().Add(ac.c.writeTimeout))
+	}
+	if _, err := ac.buf.WriteTo(ac.c.conn); err != nil {
+		ac.poison(err)
+		ac.resolve(f, NewRespIOErr(err))
+		return f
+	}
+	ac.pending <- f
+	return f
+}
+
+// Cmd calls CmdAsync and waits for the reply, making AsyncClient a Cmder
+func (ac *AsyncClient) Cmd(cmd string, args ...interface{}) *Resp {
+	return ac.CmdAsync(cmd, args...).Resp()
+}
+
+var _ Cmder = &AsyncClient{}
+
+// Close closes the connection. Any outstanding Futures are resolved with an
+// error, and Close waits for that to be done before returning.
+func (ac *AsyncClient) Close() error {
+	ac.errL.Lock()
+	if ac.err == ErrAsyncClosed {
+		ac.errL.Unlock()
+		return nil
+	}
+	var err error
+	if ac.err == nil {
+		err = ac.c.Close()
+	}
+	ac.err = ErrAsyncClosed
+	ac.errL.Unlock()
+
+	// Once err is set any writer blocked on pending will be let through by
+	// the reader, and then no more will be sent on it
+	ac.l.Lock()
+	close(ac.pending)
+	ac.l.Unlock()
+	<-ac.readDone
+	return err
+}
@@ -0,0 +1,205 @@
+package redis
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func dialAsyncFake(t require.TestingT, l net.Listener) *AsyncClient {
+	c, err := DialTimeout("tcp", l.Addr().String(), 5*time.Second)
+	require.Nil(t, err)
+	return NewAsyncClient(c)
+}
+
+func TestAsyncClient(t *T) {
+	l := echoServer(t)
+	defer l.Close()
+	ac := dialAsyncFake(t, l)
+	defer ac.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ff := make([]*Future, 100)
+			for j := range ff {
+				ff[j] = ac.CmdAsync("ECHO", strconv.Itoa(i*1000+j))
+			}
+			for j, f := range ff {
+				<-f.Done()
+				s, err := f.Resp().Str()
+				assert.Nil(t, err)
+				assert.Equal(t, strconv.Itoa(i*1000+j), s)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, args := range [][]interface{}{
+		{"BLPOP", "foo", 0},
+		{"subscribe", "foo"},
+		{"XREAD", "COUNT", 1, "BLOCK", 0, "STREAMS", "foo", "$"},
+	} {
+		r := ac.Cmd(args[0].(string), args[1:]...)
+		assert.Equal(t, ErrAsyncBlocking, r.Err)
+		assert.True(t, r.IsType(AppErr))
+	}
+	// the connection is still fine after a rejection
+	s, err := ac.Cmd("ECHO", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "foo", s)
+
+	require.Nil(t, ac.Close())
+	assert.Equal(t, ErrAsyncClosed, ac.Cmd("ECHO", "foo").Err)
+	assert.Nil(t, ac.Close())
+}
+
+func TestAsyncClientConnErr(t *T) {
+	// the server reads 3 commands, replies to the first, then hangs up
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		rr := NewRespReader(conn)
+		for i := 0; i < 3; i++ {
+			rr.Read()
+		}
+		NewResp("OK").WriteTo(conn)
+		conn.Close()
+	}()
+
+	rec := new(log.Recorder)
+	c, err := DialCtx(context.Background(), "tcp", l.Addr().String(), DialOpts{
+		Timeout: 5 * time.Second,
+		Logger:  rec,
+	})
+	require.Nil(t, err)
+	ac := NewAsyncClient(c)
+	defer ac.Close()
+	ff := []*Future{ac.CmdAsync("A"), ac.CmdAsync("B"), ac.CmdAsync("C")}
+	assert.Nil(t, ff[0].Resp().Err)
+	r1, r2 := ff[1].Resp(), ff[2].Resp()
+	assert.True(t, r1.IsType(IOErr))
+	assert.True(t, r2.IsType(IOErr))
+	assert.Equal(t, r1.Err, r2.Err)
+
+	// the client is poisoned from then on
+	r := ac.Cmd("D")
+	assert.True(t, r.IsType(IOErr))
+	assert.Equal(t, r1.Err, r.Err)
+
+	ee := rec.Entries("")
+	require.Len(t, ee, 1)
+	assert.Equal(t, "connection failed", ee[0].Msg)
+	assert.Equal(t, log.Warn, ee[0].Level)
+	assert.Equal(t, "redis", ee[0].KV[log.KeyComponent])
+	assert.Equal(t, l.Addr().String(), ee[0].KV[log.KeyAddr])
+	assert.Equal(t, r1.Err, ee[0].KV[log.KeyErr])
+}
+
+// latencyServer is like echoServer, but each reply is only written once rtt
+// has passed since its command was read, simulating a link with that round
+// trip time
+func latencyServer(b *B, rtt time.Duration) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(b, err)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		type reply struct {
+			r   *Resp
+			due time.Time
+		}
+		replyCh := make(chan reply, 100000)
+		go func() {
+			for rep := range replyCh {
+				time.Sleep(rep.due.Sub(time.Now()))
+				rep.r.WriteTo(conn)
+			}
+		}()
+		defer close(replyCh)
+
+		rr := NewRespReader(conn)
+		for {
+			args, err := rr.Read().ListBytes()
+			if err != nil {
+				return
+			}
+			replyCh <- reply{NewResp(args[len(args)-1]), time.Now().Add(rtt)}
+		}
+	}()
+	return l
+}
+
+const benchRTT = 5 * time.Millisecond
+
+func BenchmarkLatencyCmd(b *B) {
+	l := latencyServer(b, benchRTT)
+	defer l.Close()
+	c, err := Dial("tcp", l.Addr().String())
+	require.Nil(b, err)
+	defer c.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Cmd("ECHO", "foo")
+	}
+}
+
+func BenchmarkLatencyPipeline(b *B) {
+	l := latencyServer(b, benchRTT)
+	defer l.Close()
+	c, err := Dial("tcp", l.Addr().String())
+	require.Nil(b, err)
+	defer c.Close()
+
+	// commands arrive in batches of 10, each of which is pipelined
+	b.ResetTimer()
+	for i := 0; i < b.N; i += 10 {
+		for j := 0; j < 10; j++ {
+			c.PipeAppend("ECHO", "foo")
+		}
+		for j := 0; j < 10; j++ {
+			c.PipeResp()
+		}
+	}
+}
+
+func BenchmarkLatencyAsync(b *B) {
+	l := latencyServer(b, benchRTT)
+	defer l.Close()
+	ac := dialAsyncFake(b, l)
+	defer ac.Close()
+
+	// commands are sent as they arrive, without waiting for batches to
+	// complete
+	b.ResetTimer()
+	ff := make([]*Future, 0, 1000)
+	for i := 0; i < b.N; i++ {
+		ff = append(ff, ac.CmdAsync("ECHO", "foo"))
+		if len(ff) == cap(ff) {
+			ff[0].Resp()
+			ff = append(ff[:0], ff[1:]...)
+		}
+	}
+	for _, f := range ff {
+		f.Resp()
+	}
+}
@@ -2,27 +2,62 @@ package redis
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
 	"reflect"
+	"sync"
 	"time"
+
+	"github.com/mediocregopher/radix.v2/log"
 )
 
 // ErrPipelineEmpty is returned from PipeResp() to indicate that all commands
 // which were put into the pipeline have had their responses read
 var ErrPipelineEmpty = errors.New("pipeline queue empty")
 
+// Cmder is implemented by everything in radix.v2 which can run a command and
+// return its response: Client, pool.Pool, cluster.Cluster and sentinel.Master.
+// Code which only needs to run commands can accept a Cmder, and so work with
+// any of them (although, as is the case with Cluster, sometimes with different
+// limitations).
+type Cmder interface {
+	Cmd(cmd string, args ...interface{}) *Resp
+}
+
+var _ Cmder = &Client{}
+
 // Client describes a Redis client.
 type Client struct {
 	conn         net.Conn
 	respReader   *RespReader
-	timeout      time.Duration
 	pending      []request
 	writeScratch []byte
 	writeBuf     *bytes.Buffer
 
 	completed, completedHead []*Resp
+	logger                   log.Logger
+	trace                    *Trace
+
+	// interrupted is set by DoCtx when its context is done, after it has set
+	// the conn's deadline in the past. deadlineL is held whenever the conn's
+	// deadlines are set so that this can't be undone.
+	deadlineL   sync.Mutex
+	interrupted bool
+
+	// The read and write timeouts. deadlines is set once a deadline has been
+	// set on the conn, after which a timeout of 0 means the deadline must be
+	// cleared rather than left alone.
+	readTimeout, writeTimeout time.Duration
+	deadlines                 bool
+
+	// pushL guards pushFn and pushes, the RESP3 push messages read while
+	// reading replies which there was no pushFn for
+	pushL  sync.Mutex
+	pushFn func(*Resp)
+	pushes []*Resp
 
 	// The network/address of the redis instance this client is connected to.
 	// These will be whatever strings were passed into the Dial function when
@@ -55,19 +90,28 @@ func DialTimeout(network, addr string, timeout time.Duration) (*Client, error) {
 	if err != nil {
 		return nil, err
 	}
+	return newClient(conn, network, addr, timeout, RespReaderOpts{}), nil
+}
 
+func newClient(
+	conn net.Conn, network, addr string, timeout time.Duration, rro RespReaderOpts,
+) *Client {
+	// replies are never inline, so a line which isn't RESP is always an error
+	rro.Inline = false
 	completed := make([]*Resp, 0, 10)
 	return &Client{
 		conn:          conn,
-		respReader:    NewRespReader(conn),
-		timeout:       timeout,
+		respReader:    NewRespReaderWithOpts(conn, rro),
+		readTimeout:   timeout,
+		writeTimeout:  timeout,
 		writeScratch:  make([]byte, 0, 128),
 		writeBuf:      bytes.NewBuffer(make([]byte, 0, 128)),
 		completed:     completed,
 		completedHead: completed,
+		logger:        log.Nop,
 		Network:       network,
 		Addr:          addr,
-	}, nil
+	}
 }
 
 // Dial connects to the given Redis server.
@@ -75,6 +119,25 @@ func Dial(network, addr string) (*Client, error) {
 	return DialTimeout(network, addr, time.Duration(0))
 }
 
+// DialTLS is like Dial, but the connection is made over TLS using the given
+// config. As with DialOpts' TLSConfig, either its ServerName or
+// InsecureSkipVerify must be set.
+func DialTLS(network, addr string, cfg *tls.Config) (*Client, error) {
+	return DialTimeoutTLS(network, addr, 0, cfg)
+}
+
+// DialTimeoutTLS is like DialTimeout, but the connection is made over TLS using
+// the given config, see DialTLS. The timeout covers the TLS handshake as well
+// as connecting. To use TLS with a pool.Pool set the TLSConfig of its Opts'
+// DialOpts, with a cluster.Cluster return it from NodeDialOpts, and with a
+// sentinel.Client set it in MasterDialOpts and SentinelDialOpts.
+func DialTimeoutTLS(network, addr string, timeout time.Duration, cfg *tls.Config) (*Client, error) {
+	return DialCtx(context.Background(), network, addr, DialOpts{
+		Timeout:   timeout,
+		TLSConfig: cfg,
+	})
+}
+
 // Close closes the connection.
 func (c *Client) Close() error {
 	return c.conn.Close()
@@ -82,9 +145,46 @@ func (c *Client) Close() error {
 
 // Cmd calls the given Redis command.
 func (c *Client) Cmd(cmd string, args ...interface{}) *Resp {
-	err := c.writeRequest(request{cmd, args})
+	if c.trace != nil {
+		start := c.traceStart(cmd, args)
+		r := c.cmd(cmd, args)
+		c.traceDone(cmd, r, start)
+		return r
+	}
+	return c.cmd(cmd, args)
+}
+
+// CmdWithTimeout is like Cmd, but the given timeout is used for reading the
+// command's reply instead of the Client's read timeout, e.g. so that
+//
+//	client.CmdWithTimeout(35*time.Second, "BLPOP", "queue", 30)
+//
+// can block for longer than the Client's timeout would otherwise allow. A
+// timeout of 0 means there's no timeout at all. The Client's own timeout is
+// used again for whatever comes after, so a Client put back into a pool.Pool
+// is left as it was.
+func (c *Client) CmdWithTimeout(timeout time.Duration, cmd string, args ...interface{}) *Resp {
+	var r *Resp
+	c.DoWithTimeout(timeout, func(c *Client) { r = c.Cmd(cmd, args...) })
+	return r
+}
+
+// DoWithTimeout is like CmdWithTimeout, but the given timeout is used for
+// reading every reply until fn returns, e.g. for a blocking command which must
+// be preceded by another on the same connection.
+func (c *Client) DoWithTimeout(timeout time.Duration, fn func(*Client)) {
+	defaultTimeout := c.readTimeout
+	c.readTimeout = timeout
+	defer func() { c.readTimeout = defaultTimeout }()
+	fn(c)
+}
+
+func (c *Client) cmd(cmd string, args []interface{}) *Resp {
+	encErrs, err := c.writeRequest(request{cmd, args})
 	if err != nil {
 		return NewRespIOErr(err)
+	} else if encErrs != nil {
+		return NewResp(encErrs[0])
 	}
 	return c.readResp(true)
 }
@@ -108,15 +208,35 @@ func (c *Client) PipeResp() *Resp {
 		return NewResp(ErrPipelineEmpty)
 	}
 
-	nreqs := len(c.pending)
-	err := c.writeRequest(c.pending...)
+	reqs := c.pending
+	var start time.Time
+	if c.trace != nil {
+		for _, req := range reqs {
+			start = c.traceStart(req.cmd, req.args)
+		}
+	}
+	encErrs, err := c.writeRequest(reqs...)
 	c.pending = nil
 	if err != nil {
-		return NewRespIOErr(err)
+		r := NewRespIOErr(err)
+		if c.trace != nil {
+			for _, req := range reqs {
+				c.traceDone(req.cmd, r, start)
+			}
+		}
+		return r
 	}
 	c.completed = c.completedHead
-	for i := 0; i < nreqs; i++ {
-		r := c.readResp(true)
+	for i, req := range reqs {
+		var r *Resp
+		if encErrs != nil && encErrs[i] != nil {
+			r = NewResp(encErrs[i])
+		} else {
+			r = c.readResp(true)
+		}
+		if c.trace != nil {
+			c.traceDone(req.cmd, r, start)
+		}
 		c.completed = append(c.completed, r)
 	}
 
@@ -151,51 +271,161 @@ func (c *Client) ReadResp() *Resp {
 	return c.readResp(false)
 }
 
+// WriteCmd writes the given command to the connection without reading its
+// reply, which must then be read using ReadResp. It may be called while
+// another go-routine is blocked in ReadResp.
+//
+// Note: like ReadResp this is a low-level function, and is only really needed
+// when writing your own pub/sub code
+func (c *Client) WriteCmd(cmd string, args ...interface{}) error {
+	encErrs, err := c.writeRequest(request{cmd, args})
+	if encErrs != nil {
+		return encErrs[0]
+	}
+	return err
+}
+
 // strict indicates whether or not to consider timeouts as critical network
-// errors
+// errors. It also indicates that a command's reply is being read, so any push
+// messages read beforehand are handed off rather than returned.
 func (c *Client) readResp(strict bool) *Resp {
-	if c.timeout != 0 {
-		c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+	for {
+		c.setDeadline(c.conn.SetReadDeadline, c.readTimeout)
+		r := c.respReader.Read()
+		if r.IsType(IOErr) && (strict || !IsTimeout(r)) {
+			c.LastCritical = r.Err
+			c.Close()
+		} else if strict && r.IsPush() {
+			c.push(r)
+			continue
+		}
+		return r
 	}
-	r := c.respReader.Read()
-	if r.IsType(IOErr) && (strict || !IsTimeout(r)) {
-		c.LastCritical = r.Err
-		c.Close()
+}
+
+// maxPushes is the most push messages which are kept for Pushes, after which
+// the oldest are dropped
+const maxPushes = 1024
+
+// SetPushHandler sets the function which is called with each RESP3 push
+// message (e.g. a client side caching invalidation) which is read while reading
+// a command's reply, from whichever go-routine is reading the reply. Push
+// messages can only be sent by redis if the connection is using RESP3 (see
+// DialOpts' RESP3). To have this set on every connection in a pool use
+// DialOpts' OnConnect.
+//
+// If no handler is set push messages are kept until Pushes is called, up to
+// 1024 of them, and otherwise ignored. Either way they're never returned as
+// the reply to a command. ReadResp does return them however, since pub/sub
+// messages are push messages in RESP3.
+func (c *Client) SetPushHandler(fn func(*Resp)) {
+	c.pushL.Lock()
+	defer c.pushL.Unlock()
+	c.pushFn = fn
+}
+
+// Pushes returns the push messages which have been read while reading replies
+// since Pushes was last called, and which there was no push handler for. See
+// SetPushHandler.
+func (c *Client) Pushes() []*Resp {
+	c.pushL.Lock()
+	defer c.pushL.Unlock()
+	pushes := c.pushes
+	c.pushes = nil
+	return pushes
+}
+
+func (c *Client) push(r *Resp) {
+	c.pushL.Lock()
+	fn := c.pushFn
+	if fn == nil {
+		if len(c.pushes) == maxPushes {
+			c.pushes = c.pushes[1:]
+		}
+		c.pushes = append(c.pushes, r)
+	}
+	c.pushL.Unlock()
+	if fn != nil {
+		fn(r)
 	}
-	return r
 }
 
-func (c *Client) writeRequest(requests ...request) error {
-	if c.timeout != 0 {
-		c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+// setDeadline calls set with the deadline for the next read or write, given
+// its timeout, if the Client has ever had one
+func (c *Client) setDeadline(set func(time.Time) error, timeout time.Duration) {
+	if timeout == 0 && !c.deadlines {
+		return
+	}
+	c.deadlineL.Lock()
+	defer c.deadlineL.Unlock()
+	if c.interrupted {
+		return
 	}
-	var err error
-outer:
+	var t time.Time
+	if timeout != 0 {
+		t = time.Now().Add(timeout)
+		c.deadlines = true
+	}
+	set(t)
+}
+
+// writeRequest encodes each request and writes it to the connection. A request
+// with an argument which can't be marshaled isn't written at all, and its
+// error is set at the same index in encErrs, which is otherwise nil. That
+// doesn't affect the connection or the other requests. If writing fails the
+// connection is closed and the error returned.
+func (c *Client) writeRequest(requests ...request) (encErrs []error, err error) {
+	c.setDeadline(c.conn.SetWriteDeadline, c.writeTimeout)
 	for i := range requests {
 		c.writeBuf.Reset()
-		elems := flattenedLength(requests[i].args...) + 1
-		_, err = writeArrayHeader(c.writeBuf, c.writeScratch, int64(elems))
-		if err != nil {
-			break
-		}
-
-		_, err = writeTo(c.writeBuf, c.writeScratch, requests[i].cmd, true, true)
-		if err != nil {
-			break
-		}
-
-		for _, arg := range requests[i].args {
-			_, err = writeTo(c.writeBuf, c.writeScratch, arg, true, true)
-			if err != nil {
-				break outer
+		if encErr := encodeRequest(c.writeBuf, c.writeScratch, requests[i]); encErr != nil {
+			if encErrs == nil {
+				encErrs = make([]error, len(requests))
 			}
+			encErrs[i] = encErr
+			continue
 		}
-
 		if _, err = c.writeBuf.WriteTo(c.conn); err != nil {
-			break
+			c.LastCritical = err
+			c.Close()
+			return encErrs, err
 		}
 	}
+	return encErrs, nil
+}
+
+// encodeRequest writes the resp encoded form of the request to the buffer. The
+// only error is from marshaling one of the arguments, in which case nothing of
+// the request is left in the buffer.
+func encodeRequest(buf *bytes.Buffer, scratch []byte, req request) error {
+	start := buf.Len()
+	err := encodeRequestElems(buf, scratch, req)
 	if err != nil {
+		buf.Truncate(start)
+	}
+	return err
+}
+
+func encodeRequestElems(buf *bytes.Buffer, scratch []byte, req request) error {
+	elems := flattenedLength(req.args...) + 1
+	if _, err := writeArrayHeader(buf, scratch, int64(elems)); err != nil {
+		return err
+	}
+	if _, err := writeTo(buf, scratch, req.cmd, true, true); err != nil {
+		return err
+	}
+	for _, arg := range req.args {
+		if _, err := writeTo(buf, scratch, arg, true, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBytes writes already encoded requests to the connection
+func (c *Client) writeBytes(b []byte) error {
+	c.setDeadline(c.conn.SetWriteDeadline, c.writeTimeout)
+	if _, err := c.conn.Write(b); err != nil {
 		c.LastCritical = err
 		c.Close()
 		return err
@@ -1,8 +1,10 @@
 package redis
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"net"
 	. "testing"
 	"time"
 
@@ -178,3 +180,125 @@ func TestKeyFromArg(t *T) {
 		assert.Equal(t, out, key)
 	}
 }
+
+// pushServer sends an invalidation push message ahead of the reply to every
+// command, which are all replied to with OK
+func pushServer(t *T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rr := NewRespReader(conn)
+		for {
+			if err := rr.Read().Err; err != nil {
+				return
+			}
+			conn.Write([]byte(">2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nfoo\r\n+OK\r\n"))
+		}
+	}()
+	return l
+}
+
+func TestClientPushes(t *T) {
+	l := pushServer(t)
+	defer l.Close()
+	c, err := Dial("tcp", l.Addr().String())
+	require.Nil(t, err)
+	defer c.Close()
+
+	// buffered if there's no handler
+	assert.Equal(t, "OK", mustStr(t, c.Cmd("GET", "foo")))
+	pushes := c.Pushes()
+	require.Len(t, pushes, 1)
+	assert.True(t, pushes[0].IsPush())
+	assert.Empty(t, c.Pushes())
+
+	// passed to the handler if there is one, including for pipelines
+	var handled []*Resp
+	c.SetPushHandler(func(r *Resp) { handled = append(handled, r) })
+	c.PipeAppend("GET", "foo")
+	c.PipeAppend("GET", "foo")
+	assert.Equal(t, "OK", mustStr(t, c.PipeResp()))
+	assert.Equal(t, "OK", mustStr(t, c.PipeResp()))
+	assert.Len(t, handled, 2)
+	assert.Empty(t, c.Pushes())
+
+	// and by an AsyncClient too
+	ac := NewAsyncClient(c)
+	assert.Equal(t, "OK", mustStr(t, ac.CmdAsync("GET", "foo").Resp()))
+	assert.Len(t, handled, 3)
+}
+
+func TestClientReadRespPush(t *T) {
+	l := pushServer(t)
+	defer l.Close()
+	c, err := Dial("tcp", l.Addr().String())
+	require.Nil(t, err)
+	defer c.Close()
+
+	// ReadResp returns push messages, since pub/sub needs them
+	require.Nil(t, c.WriteCmd("SUBSCRIBE", "foo"))
+	r := c.ReadResp()
+	assert.True(t, r.IsPush())
+	assert.Equal(t, "OK", mustStr(t, c.ReadResp()))
+	assert.Empty(t, c.Pushes())
+}
+
+// slowServer replies +OK to every command it's sent, each after the given
+// delay
+func slowServer(t *T, delay time.Duration) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rr := NewRespReader(conn)
+		for {
+			if err := rr.Read().Err; err != nil {
+				return
+			}
+			time.Sleep(delay)
+			conn.Write([]byte("+OK\r\n"))
+		}
+	}()
+	return l
+}
+
+func TestCmdWithTimeout(t *T) {
+	l := slowServer(t, 100*time.Millisecond)
+	defer l.Close()
+	c, err := DialCtx(context.Background(), "tcp", l.Addr().String(), DialOpts{
+		ReadTimeout: 50 * time.Millisecond,
+	})
+	require.Nil(t, err)
+	defer c.Close()
+
+	assert.Equal(t, "OK", mustStr(t, c.CmdWithTimeout(time.Second, "BLPOP", "foo", 0)))
+
+	// the ReadTimeout is used again afterwards
+	r := c.Cmd("PING")
+	assert.True(t, r.IsType(IOErr))
+	assert.True(t, IsTimeout(r))
+}
+
+func TestCmdWithTimeoutCleared(t *T) {
+	l := slowServer(t, 50*time.Millisecond)
+	defer l.Close()
+	c, err := Dial("tcp", l.Addr().String())
+	require.Nil(t, err)
+	defer c.Close()
+
+	assert.Equal(t, "OK", mustStr(t, c.CmdWithTimeout(100*time.Millisecond, "PING")))
+
+	// The Client has no timeout of its own, so the deadline set for the
+	// previous command mustn't still apply
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, "OK", mustStr(t, c.Cmd("PING")))
+}
@@ -0,0 +1,82 @@
+package redis
+
+import (
+	"context"
+	"time"
+)
+
+// DoCtx calls fn with the Client, and if the context is done before fn returns
+// then whatever read or write fn is blocked on is interrupted. In that case the
+// Client is closed and its LastCritical set to the context's error, which is
+// returned. The Client can't be used again, since a command may have been only
+// partly written, or written with its reply still to be read. Otherwise nil is
+// returned, and the context isn't used once DoCtx returns.
+//
+// If the context is already done then fn isn't called, the Client is left as
+// it is, and the context's error is returned.
+func (c *Client) DoCtx(ctx context.Context, fn func(*Client)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	} else if ctx.Done() == nil {
+		fn(c)
+		return nil
+	}
+
+	// As in DialCtx, the go-routine interrupting the conn must have exited
+	// before it's known whether or not it did so
+	fnDone := make(chan struct{})
+	watchDone := make(chan struct{})
+	go func() {
+		defer close(watchDone)
+		select {
+		case <-ctx.Done():
+			c.deadlineL.Lock()
+			c.interrupted = true
+			c.conn.SetDeadline(time.Unix(1, 0))
+			c.deadlineL.Unlock()
+		case <-fnDone:
+		}
+	}()
+
+	fn(c)
+	close(fnDone)
+	<-watchDone
+
+	if !c.interrupted {
+		return nil
+	}
+	// fn may have finished just before the conn was interrupted, but there's
+	// no way of knowing that, so the Client is given up on in any case
+	err := ctx.Err()
+	c.LastCritical = err
+	c.Close()
+	return err
+}
+
+// CmdCtx is like Cmd, but uses DoCtx so that the command is given up on if the
+// context is done before its reply is read. When that happens the returned
+// Resp is an IOErr whose Err is the context's error, and the Client is closed.
+func (c *Client) CmdCtx(ctx context.Context, cmd string, args ...interface{}) *Resp {
+	var r *Resp
+	if err := c.DoCtx(ctx, func(c *Client) {
+		r = c.Cmd(cmd, args...)
+	}); err != nil {
+		return NewRespIOErr(err)
+	}
+	return r
+}
+
+// PipeRespCtx is like PipeResp, but uses DoCtx so that sending the pipeline
+// and reading its replies is given up on if the context is done first. When
+// that happens the returned Resp is an IOErr whose Err is the context's error,
+// the Client is closed, and the pipeline is cleared.
+func (c *Client) PipeRespCtx(ctx context.Context) *Resp {
+	var r *Resp
+	if err := c.DoCtx(ctx, func(c *Client) {
+		r = c.PipeResp()
+	}); err != nil {
+		c.PipeClear()
+		return NewRespIOErr(err)
+	}
+	return r
+}
@@ -0,0 +1,96 @@
+package redis
+
+import (
+	"context"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCmdCtx(t *T) {
+	l := echoServer(t)
+	defer l.Close()
+	c, err := DialTimeout("tcp", l.Addr().String(), 5*time.Second)
+	require.Nil(t, err)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s, err := c.CmdCtx(ctx, "ECHO", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "foo", s)
+	assert.Nil(t, c.LastCritical)
+
+	// The Client is still usable afterwards, and doesn't pick up the context's
+	// deadline once it has passed
+	cancel()
+	s, err = c.Cmd("ECHO", "bar").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "bar", s)
+
+	// An already done context doesn't touch the Client at all
+	r := c.CmdCtx(ctx, "ECHO", "baz")
+	assert.True(t, r.IsType(IOErr))
+	assert.Equal(t, context.Canceled, r.Err)
+	assert.Nil(t, c.LastCritical)
+}
+
+func TestCmdCtxInterrupt(t *T) {
+	l := silentListener(t)
+	defer l.Close()
+
+	// The Client's own timeout is much longer than the context's, and mustn't
+	// override it
+	c, err := DialTimeout("tcp", l.Addr().String(), 10*time.Second)
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	r := c.CmdCtx(ctx, "PING")
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.True(t, r.IsType(IOErr))
+	assert.Equal(t, context.DeadlineExceeded, r.Err)
+	assert.Equal(t, context.DeadlineExceeded, c.LastCritical)
+
+	// the Client was closed, since the reply to PING is still in flight
+	assert.NotNil(t, c.Cmd("PING").Err)
+}
+
+func TestPipeRespCtx(t *T) {
+	l := echoServer(t)
+	defer l.Close()
+	c, err := DialTimeout("tcp", l.Addr().String(), 5*time.Second)
+	require.Nil(t, err)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c.PipeAppend("ECHO", "foo")
+	c.PipeAppend("ECHO", "bar")
+	for _, exp := range []string{"foo", "bar"} {
+		s, err := c.PipeRespCtx(ctx).Str()
+		require.Nil(t, err)
+		assert.Equal(t, exp, s)
+	}
+	assert.Equal(t, ErrPipelineEmpty, c.PipeRespCtx(ctx).Err)
+
+	l2 := silentListener(t)
+	defer l2.Close()
+	c2, err := DialTimeout("tcp", l2.Addr().String(), 10*time.Second)
+	require.Nil(t, err)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	c2.PipeAppend("PING")
+	c2.PipeAppend("PING")
+	r := c2.PipeRespCtx(ctx)
+	assert.True(t, r.IsType(IOErr))
+	assert.Equal(t, context.DeadlineExceeded, r.Err)
+
+	// the pipeline was cleared along with the Client being closed
+	assert.Equal(t, ErrPipelineEmpty, c2.PipeResp().Err)
+	assert.NotNil(t, c2.Cmd("PING").Err)
+}
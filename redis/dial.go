@@ -0,0 +1,244 @@
+package redis
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/log"
+)
+
+// DialOpts are options which can be passed into DialCtx. All fields are
+// optional.
+type DialOpts struct {
+	// Used as the timeout for connecting, and then as the read/write timeout
+	// when communicating with redis, the same as with DialTimeout. The
+	// context passed into DialCtx may cut connecting short regardless.
+	Timeout time.Duration
+
+	// If set these replace Timeout as the timeout for reading replies from
+	// redis and for writing commands to it, respectively, once connected,
+	// e.g. for a longer ReadTimeout when using blocking commands. See also
+	// Client's CmdWithTimeout.
+	ReadTimeout, WriteTimeout time.Duration
+
+	// If set this is used to connect, rather than a net.Dialer, e.g. to tunnel
+	// through a SOCKS proxy. It's given the context passed into DialCtx, and
+	// must respect the Timeout itself if it's to apply to connecting.
+	NetDial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// If set, and NetDial isn't, this is used to connect, e.g. to set a
+	// KeepAlive or LocalAddr. Its Timeout is replaced by the Timeout above,
+	// if that's set. The net.Dialer itself is never modified.
+	Dialer *net.Dialer
+
+	// If set the connection is made over TLS using this config. Either its
+	// ServerName or InsecureSkipVerify must be set.
+	TLSConfig *tls.Config
+
+	// If Password is set AUTH is called once connected. Username is only
+	// needed when using redis 6.0 ACLs.
+	Username, Password string
+
+	// If set SELECT is called with this database once connected
+	DB int
+
+	// If set HELLO 3 is called once connected, after any AUTH, switching the
+	// connection to RESP3. Replies are read the same as they would be with
+	// RESP2, e.g. maps as Arrays of alternating keys and values and doubles as
+	// BulkStrs, but the connection may now be sent push messages, see
+	// Client's SetPushHandler. Requires redis 6.0 or later.
+	RESP3 bool
+
+	// The limits for reading replies on the connection, see RespReaderOpts
+	RespReaderOpts RespReaderOpts
+
+	// Called in order once connected, after any AUTH, HELLO and SELECT, to set
+	// up the connection further, e.g. with ConnSetName. If one returns an error
+	// the connection is closed and that error is returned from DialCtx. These
+	// are covered by the Timeout and the context passed into DialCtx like the
+	// rest of the setup.
+	OnConnect []ConnFunc
+
+	// Used to log the connection failing in the background, when it's being
+	// used by an AsyncClient. Entries have the component "redis" and the
+	// address dialed. Defaults to log.Nop.
+	Logger log.Logger
+
+	// If set, its Connect is called once DialCtx is done, and it becomes the
+	// Client's Trace. The setup commands aren't traced.
+	Trace *Trace
+
+	// If set, every byte read from and written to the connection is copied
+	// here, prefixed with which way it went and when, for debugging protocol
+	// errors. Use redistest's ReplayWireTap to feed what was read back through
+	// a RespReader. The setup commands are included, AUTH's password too, and
+	// TLS connections are tapped after decryption. Errors writing to it are
+	// ignored. Each chunk is written with a single Write, but if the same
+	// io.Writer is given to more than one connection it must be safe for
+	// concurrent use, and their chunks can't be told apart.
+	WireTap io.Writer
+}
+
+// DialCtx connects to the given redis server using the given options. The
+// network may be any which net.Dial accepts, e.g. "tcp" or, with the path of
+// a unix socket as the address, "unix". The context covers the whole of setting up the connection: connecting, the TLS
+// handshake, and any AUTH, HELLO and SELECT commands. If it's cancelled, or its
+// deadline passes, part way through then the connection is closed and the
+// context's error is returned. The context isn't used once DialCtx returns.
+func DialCtx(ctx context.Context, network, addr string, o DialOpts) (*Client, error) {
+	if o.Trace == nil || o.Trace.Connect == nil {
+		return dialCtx(ctx, network, addr, o)
+	}
+	start := time.Now()
+	c, err := dialCtx(ctx, network, addr, o)
+	o.Trace.Connect(addr, time.Since(start), err)
+	return c, err
+}
+
+func dialCtx(ctx context.Context, network, addr string, o DialOpts) (*Client, error) {
+	conn, err := netDial(ctx, network, addr, o)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+
+	// The Timeout covers the whole of the setup. It's set before the
+	// go-routine below is started so it can't clobber that go-routine's
+	// deadline.
+	if o.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(o.Timeout))
+	}
+
+	// Cancelling the context interrupts any blocked reads or writes during
+	// setup by setting a deadline in the past. The go-routine doing that must
+	// have exited before the deadline is cleared for good.
+	setupDone := make(chan struct{})
+	watchDone := make(chan struct{})
+	go func() {
+		defer close(watchDone)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-setupDone:
+		}
+	}()
+
+	c, err := setupConn(conn, network, addr, o)
+	close(setupDone)
+	<-watchDone
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		conn.Close()
+		return nil, ctxErr
+	} else if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+func netDial(ctx context.Context, network, addr string, o DialOpts) (net.Conn, error) {
+	if o.NetDial != nil {
+		return o.NetDial(ctx, network, addr)
+	}
+	var d net.Dialer
+	if o.Dialer != nil {
+		d = *o.Dialer
+	}
+	if o.Timeout > 0 {
+		d.Timeout = o.Timeout
+	}
+	return d.DialContext(ctx, network, addr)
+}
+
+func setupConn(conn net.Conn, network, addr string, o DialOpts) (*Client, error) {
+	if o.TLSConfig != nil {
+		tlsConn := tls.Client(conn, o.TLSConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	if o.WireTap != nil {
+		conn = &tapConn{Conn: conn, w: o.WireTap}
+	}
+
+	// The Client is created without a timeout so it doesn't touch the
+	// deadline, which may have been set by a cancelled context, until setup is
+	// done
+	c := newClient(conn, network, addr, 0, o.RespReaderOpts)
+	c.logger = log.With(o.Logger, log.KV{log.KeyComponent: "redis", log.KeyAddr: addr})
+	if o.Password != "" {
+		args := []interface{}{o.Password}
+		if o.Username != "" {
+			args = []interface{}{o.Username, o.Password}
+		}
+		if err := c.Cmd("AUTH", args...).Err; err != nil {
+			return nil, err
+		}
+	}
+	if o.RESP3 {
+		if err := c.Cmd("HELLO", 3).Err; err != nil {
+			return nil, err
+		}
+	}
+	if o.DB != 0 {
+		if err := c.Cmd("SELECT", o.DB).Err; err != nil {
+			return nil, err
+		}
+	}
+
+	for _, fn := range o.OnConnect {
+		if err := fn(c); err != nil {
+			return nil, err
+		}
+	}
+
+	c.readTimeout, c.writeTimeout = o.Timeout, o.Timeout
+	if o.ReadTimeout != 0 {
+		c.readTimeout = o.ReadTimeout
+	}
+	if o.WriteTimeout != 0 {
+		c.writeTimeout = o.WriteTimeout
+	}
+	c.trace = o.Trace
+	return c, nil
+}
+
+// ConnFunc is a function which is called on a newly made connection to set it
+// up, see DialOpts' OnConnect
+type ConnFunc func(*Client) error
+
+// ConnCmd returns a ConnFunc which calls the given command on the connection,
+// and returns its error, if any
+func ConnCmd(cmd string, args ...interface{}) ConnFunc {
+	return func(c *Client) error {
+		return c.Cmd(cmd, args...).Err
+	}
+}
+
+// ConnAuth returns a ConnFunc which calls AUTH with the given password, and the
+// username too if it isn't empty (for redis 6.0 ACLs)
+func ConnAuth(username, password string) ConnFunc {
+	if username == "" {
+		return ConnCmd("AUTH", password)
+	}
+	return ConnCmd("AUTH", username, password)
+}
+
+// ConnSelect returns a ConnFunc which calls SELECT with the given database
+func ConnSelect(db int) ConnFunc {
+	return ConnCmd("SELECT", db)
+}
+
+// ConnSetName returns a ConnFunc which calls CLIENT SETNAME with the given name,
+// so that the connection can be told apart from others in CLIENT LIST
+func ConnSetName(name string) ConnFunc {
+	return ConnCmd("CLIENT", "SETNAME", name)
+}
@@ -0,0 +1,234 @@
+package redis
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialCtx(t *T) {
+	c, err := DialCtx(context.Background(), "tcp", "127.0.0.1:6379", DialOpts{
+		Timeout: 5 * time.Second,
+		DB:      1,
+	})
+	require.Nil(t, err)
+	defer c.Close()
+	s, err := c.Cmd("PING").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "PONG", s)
+}
+
+// silentListener accepts connections but never writes anything to them
+func silentListener(t *T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			// kept open until the test ends
+			defer conn.Close()
+		}
+	}()
+	return l
+}
+
+func TestDialCtxCancelled(t *T) {
+	l := silentListener(t)
+	defer l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := DialCtx(ctx, "tcp", l.Addr().String(), DialOpts{})
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestDialCtxCancelSetup(t *T) {
+	l := silentListener(t)
+	defer l.Close()
+
+	// AUTH will never get a reply, so only the context can stop it
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := DialCtx(ctx, "tcp", l.Addr().String(), DialOpts{Password: "foo"})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < time.Second)
+
+	// Timeout alone stops it too, with a timeout error rather than the
+	// context's
+	_, err = DialCtx(context.Background(), "tcp", l.Addr().String(), DialOpts{
+		Timeout:  50 * time.Millisecond,
+		Password: "foo",
+	})
+	require.NotNil(t, err)
+	nerr, ok := err.(net.Error)
+	require.True(t, ok)
+	assert.True(t, nerr.Timeout())
+}
+
+func TestDialCtxOnConnect(t *T) {
+	c, err := DialCtx(context.Background(), "tcp", "127.0.0.1:6379", DialOpts{
+		OnConnect: []ConnFunc{ConnSelect(2), ConnSetName("radix-test")},
+	})
+	require.Nil(t, err)
+	defer c.Close()
+	name, err := c.Cmd("CLIENT", "GETNAME").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "radix-test", name)
+
+}
+
+func TestDialCtxOnConnectErr(t *T) {
+	l := echoServer(t)
+	defer l.Close()
+
+	// an error from one of them aborts the connection, and the rest aren't
+	// called
+	var called []string
+	_, err := DialCtx(context.Background(), "tcp", l.Addr().String(), DialOpts{
+		OnConnect: []ConnFunc{
+			func(c *Client) error {
+				s, err := c.Cmd("ECHO", "first").Str()
+				called = append(called, s)
+				return err
+			},
+			func(*Client) error { return errors.New("nope") },
+			func(*Client) error { called = append(called, "third"); return nil },
+		},
+	})
+	assert.Equal(t, errors.New("nope"), err)
+	assert.Equal(t, []string{"first"}, called)
+}
+
+func TestDialCtxUnix(t *T) {
+	dir, err := ioutil.TempDir("", "radix")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "redis.sock")
+	l, err := net.Listen("unix", path)
+	require.Nil(t, err)
+	go serveEcho(l)
+	defer l.Close()
+
+	c, err := DialCtx(context.Background(), "unix", path, DialOpts{})
+	require.Nil(t, err)
+	defer c.Close()
+	assert.Equal(t, "foo", mustStr(t, c.Cmd("ECHO", "foo")))
+}
+
+func TestDialCtxDialers(t *T) {
+	l := echoServer(t)
+	defer l.Close()
+
+	// NetDial is used in place of the net.Dialer
+	var dialed string
+	c, err := DialCtx(context.Background(), "tcp", "redis.invalid:6379", DialOpts{
+		NetDial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = addr
+			var d net.Dialer
+			return d.DialContext(ctx, network, l.Addr().String())
+		},
+	})
+	require.Nil(t, err)
+	assert.Equal(t, "redis.invalid:6379", dialed)
+	assert.Equal(t, "foo", mustStr(t, c.Cmd("ECHO", "foo")))
+	c.Close()
+
+	// the Dialer's LocalAddr is used, and it isn't modified by the Timeout
+	l = echoServer(t)
+	defer l.Close()
+	d := &net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1")}}
+	c, err = DialCtx(context.Background(), "tcp", l.Addr().String(), DialOpts{
+		Dialer:  d,
+		Timeout: time.Second,
+	})
+	require.Nil(t, err)
+	defer c.Close()
+	assert.Equal(t, "127.0.0.1", c.conn.LocalAddr().(*net.TCPAddr).IP.String())
+	assert.Equal(t, time.Duration(0), d.Timeout)
+}
+
+// tlsEchoServer is like echoServer, but over TLS with a self-signed
+// certificate for 127.0.0.1, which the returned config trusts. Like
+// echoServer it only accepts one connection.
+func tlsEchoServer(t *T) (net.Listener, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "radix"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	l = tls.NewListener(l, &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	go serveEcho(l)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return l, &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+}
+
+func TestDialTLS(t *T) {
+	l, cfg := tlsEchoServer(t)
+	defer l.Close()
+	c, err := DialTimeoutTLS("tcp", l.Addr().String(), 5*time.Second, cfg)
+	require.Nil(t, err)
+	defer c.Close()
+	assert.Equal(t, "foo", mustStr(t, c.Cmd("ECHO", "foo")))
+	_, ok := c.conn.(*tls.Conn)
+	assert.True(t, ok)
+
+	l, cfg = tlsEchoServer(t)
+	defer l.Close()
+	c, err = DialTLS("tcp", l.Addr().String(), cfg)
+	require.Nil(t, err)
+	defer c.Close()
+	assert.Equal(t, "foo", mustStr(t, c.Cmd("ECHO", "foo")))
+
+	// the certificate isn't trusted without the config's roots
+	l, _ = tlsEchoServer(t)
+	defer l.Close()
+	_, err = DialTLS("tcp", l.Addr().String(), &tls.Config{ServerName: "127.0.0.1"})
+	assert.NotNil(t, err)
+}
+
+func TestDialTLSTimeout(t *T) {
+	// nothing ever answers the handshake
+	l := silentListener(t)
+	defer l.Close()
+
+	start := time.Now()
+	_, err := DialTimeoutTLS("tcp", l.Addr().String(), 50*time.Millisecond, &tls.Config{
+		InsecureSkipVerify: true,
+	})
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
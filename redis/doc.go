@@ -21,6 +21,9 @@
 // Make sure to call Close on the client if you want to clean it up before the
 // end of the program.
 //
+// DialTLS and DialTimeoutTLS connect over TLS instead, and DialCtx takes a
+// DialOpts for everything else, e.g. a password.
+//
 // Cmd and Resp
 //
 // The Cmd method returns a Resp, which has methods for converting to various
@@ -64,6 +67,25 @@
 //		fmt.Println(elemStr)
 //	}
 //
+// Int64s, Float64s and Bools do the same for other types of elements, and
+// MapInt64 and MapFloat64 are like Map. All of them treat Nil elements as the
+// zero value, Present says which elements weren't Nil:
+//
+//	r = client.Cmd("MGET", "count:a", "count:b")
+//	counts, _ := r.Int64s()
+//	present, _ := r.Present() // false for each key which doesn't exist
+//
+// Large Values
+//
+// CmdTo copies a bulk string reply straight from the connection into an
+// io.Writer, without reading it into a Resp first:
+//
+//	f, _ := os.Create("blob")
+//	_, err := client.CmdTo(f, "GET", "blob")
+//	if err == redis.ErrNilReply {
+//		// the key doesn't exist
+//	}
+//
 // Pipelining
 //
 // Pipelining is when the client sends a bunch of commands to the server at
@@ -113,4 +135,36 @@
 // Radix is not picky about the types inside or outside the maps/slices, if they
 // don't match a subset of primitive types it will fall back to reflection to
 // figure out what they are and encode them.
+//
+// FlatCmd goes further, also flattening structs into the names and values of
+// their fields, and returning an error for arguments which can't be encoded
+// rather than sending them formatted as strings:
+//
+//	type Person struct {
+//		Name string `redis:"name"`
+//		Age  int    `redis:"age"`
+//	}
+//
+//	// HSET person:1 name alice age 30
+//	redis.FlatCmd(client, "HSET", "person:1", Person{Name: "alice", Age: 30})
+//
+// Marshaling
+//
+// Some types are sent as a single value of their own rather than being
+// formatted or flattened:
+//
+//	* time.Time is sent as milliseconds since the Unix epoch, or formatted
+//	  with TimeFormat if that's set
+//	* time.Duration is sent as milliseconds
+//	* *big.Int is sent as its decimal string
+//	* anything implementing encoding.BinaryMarshaler or, failing that,
+//	  encoding.TextMarshaler is sent as what that returns, e.g. a net.IP
+//
+// If a marshaler returns an error the command isn't sent, and its Resp is an
+// AppErr with that error. The connection, and any commands pipelined with the
+// one which failed, are unaffected.
+//
+// Resp's Into decodes into each of these the same way, using
+// encoding.BinaryUnmarshaler or encoding.TextUnmarshaler for the last, so a
+// struct sent with FlatCmd can be read back as it was.
 package redis
@@ -0,0 +1,186 @@
+package redis
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// FlatCmd calls the given command on the Cmder with the given key, followed by
+// the rest of the arguments flattened. Cmd already flattens maps and slices
+// (see Flattening in the package docs), FlatCmd also flattens structs, into
+// the names and values of their fields, and checks every argument's type
+// before anything is sent:
+//
+//	type User struct {
+//		Name  string  `redis:"name"`
+//		Age   int     `redis:"age"`
+//		Email *string `redis:"email"`
+//	}
+//
+//	// HSET user:1 name bob age 30
+//	redis.FlatCmd(client, "HSET", "user:1", User{Name: "bob", Age: 30})
+//
+// A field's name is taken from its redis tag, or is the name of the field if
+// it has none. Fields tagged with "-", unexported fields and nil pointers are
+// skipped, and the fields of exported embedded structs are flattened as if they
+// belonged to the struct embedding them. Non-nil pointers are flattened as
+// whatever they point to, as are the fields of embedded pointers to structs.
+// Values of the types which Cmd sends specially, like time.Time or anything
+// implementing encoding.BinaryMarshaler, are sent the same way as Cmd sends
+// them rather than being flattened, so that what FlatCmd sends can be read back
+// with Resp's Into. See Marshaling in the package docs.
+//
+// If an argument, or something within it, is of a type which can't be sent to
+// redis, like a chan or a func, nothing is sent and the returned Resp is an
+// AppErr saying so.
+//
+// Since the key is given separately it's always the first argument, so
+// FlatCmd can be used with cluster.Cluster whatever the rest of the arguments
+// are.
+func FlatCmd(c Cmder, cmd, key string, args ...interface{}) *Resp {
+	return flatCmd(c, cmd, append([]interface{}{key}, args...))
+}
+
+// FlatCmdNoKey is like FlatCmd, but for commands which don't take a key
+func FlatCmdNoKey(c Cmder, cmd string, args ...interface{}) *Resp {
+	return flatCmd(c, cmd, args)
+}
+
+func flatCmd(c Cmder, cmd string, args []interface{}) *Resp {
+	flat := make([]interface{}, 0, len(args))
+	var err error
+	for _, arg := range args {
+		if flat, err = flattenArg(flat, arg); err != nil {
+			return NewResp(err)
+		}
+	}
+	return c.Cmd(cmd, flat...)
+}
+
+// flattenArg appends the flattened form of the argument to flat. Unlike
+// flatten it also flattens structs, and returns an error rather than
+// formatting a value it doesn't know how to send.
+func flattenArg(flat []interface{}, arg interface{}) ([]interface{}, error) {
+	switch arg.(type) {
+	case []byte, string, bool, nil, int, int8, int16, int32, int64, uint,
+		uint8, uint16, uint32, uint64, float32, float64, error, Resp, *Resp:
+		return append(flat, arg), nil
+	}
+	if isMarshaled(arg) {
+		v, err := marshalArg(arg)
+		if err != nil {
+			return nil, err
+		}
+		return append(flat, v), nil
+	}
+	return flattenValue(flat, reflect.ValueOf(arg))
+}
+
+func flattenValue(flat []interface{}, v reflect.Value) ([]interface{}, error) {
+	var err error
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return flat, nil
+		}
+		return flattenArg(flat, v.Elem().Interface())
+
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return append(flat, b), nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if flat, err = flattenArg(flat, v.Index(i).Interface()); err != nil {
+				return nil, err
+			}
+		}
+		return flat, nil
+
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			if flat, err = flattenArg(flat, k.Interface()); err != nil {
+				return nil, err
+			}
+			if flat, err = flattenArg(flat, v.MapIndex(k).Interface()); err != nil {
+				return nil, err
+			}
+		}
+		return flat, nil
+
+	case reflect.Struct:
+		return flattenStruct(flat, v)
+
+	// other named types are sent as their underlying value
+	case reflect.Bool:
+		return append(flat, v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return append(flat, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return append(flat, v.Uint()), nil
+	case reflect.Float32:
+		return append(flat, float32(v.Float())), nil
+	case reflect.Float64:
+		return append(flat, v.Float()), nil
+	case reflect.String:
+		return append(flat, v.String()), nil
+	}
+	return nil, fmt.Errorf("can't use argument of type %s in a command", v.Type())
+}
+
+func flattenStruct(flat []interface{}, v reflect.Value) ([]interface{}, error) {
+	var err error
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fv := v.Field(i)
+		if f.Anonymous && f.PkgPath == "" && indirect(f.Type).Kind() == reflect.Struct {
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if flat, err = flattenStruct(flat, fv); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		name, ok := fieldName(f)
+		if !ok || (fv.Kind() == reflect.Ptr && fv.IsNil()) {
+			continue
+		}
+		l := len(flat)
+		if flat, err = flattenArg(append(flat, name), fv.Interface()); err != nil {
+			return nil, err
+		} else if len(flat) == l+1 {
+			// the value flattened to nothing, e.g. a nil interface
+			flat = flat[:l]
+		}
+	}
+	return flat, nil
+}
+
+// indirect returns the type pointed to, if the given type is a pointer
+func indirect(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
+
+// fieldName returns the name a struct field is sent and read as, or false if
+// it isn't
+func fieldName(f reflect.StructField) (string, bool) {
+	if f.PkgPath != "" {
+		return "", false
+	}
+	name := f.Tag.Get("redis")
+	if name == "-" {
+		return "", false
+	} else if name == "" {
+		name = f.Name
+	}
+	return name, true
+}
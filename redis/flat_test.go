@@ -0,0 +1,87 @@
+package redis
+
+import (
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cmdRecorder is a Cmder which records the commands it's given rather than
+// sending them
+type cmdRecorder struct {
+	cmd  string
+	args []interface{}
+}
+
+func (cr *cmdRecorder) Cmd(cmd string, args ...interface{}) *Resp {
+	cr.cmd, cr.args = cmd, args
+	return NewResp("OK")
+}
+
+type flatBase struct {
+	ID int `redis:"id"`
+}
+
+type FlatEmbedded struct {
+	Created int64 `redis:"created"`
+}
+
+type flatUser struct {
+	FlatEmbedded
+	Name    string        `redis:"name"`
+	Email   *string       `redis:"email"`
+	Phone   *string       `redis:"phone"`
+	Skipped string        `redis:"-"`
+	TTL     time.Duration `redis:"ttl"`
+	Raw     []byte
+	flatBase
+	hidden string
+}
+
+func TestFlatCmd(t *T) {
+	cr := new(cmdRecorder)
+	require.Nil(t, FlatCmd(cr, "RPUSH", "foo", []string{"a", "b"}, [][]int{{1}, {2, 3}}).Err)
+	assert.Equal(t, "RPUSH", cr.cmd)
+	assert.Equal(t, []interface{}{"foo", "a", "b", 1, 2, 3}, cr.args)
+
+	require.Nil(t, FlatCmd(cr, "HSET", "foo", map[string]int{"a": 1}).Err)
+	assert.Equal(t, []interface{}{"foo", "a", 1}, cr.args)
+
+	email := "bob@example.com"
+	u := flatUser{
+		FlatEmbedded: FlatEmbedded{Created: 5},
+		Name:         "bob",
+		Email:        &email,
+		Skipped:      "skipped",
+		TTL:          time.Second,
+		Raw:          []byte("raw"),
+		flatBase:     flatBase{ID: 1},
+		hidden:       "hidden",
+	}
+	require.Nil(t, FlatCmd(cr, "HSET", "foo", &u).Err)
+	assert.Equal(t, []interface{}{
+		"foo",
+		"created", int64(5),
+		"name", "bob",
+		"email", "bob@example.com",
+		"ttl", int64(1000),
+		"Raw", []byte("raw"),
+	}, cr.args)
+
+	require.Nil(t, FlatCmdNoKey(cr, "PING").Err)
+	assert.Equal(t, "PING", cr.cmd)
+	assert.Empty(t, cr.args)
+}
+
+func TestFlatCmdBadArg(t *T) {
+	cr := new(cmdRecorder)
+	r := FlatCmd(cr, "SET", "foo", make(chan int))
+	assert.True(t, r.IsType(AppErr))
+	assert.Empty(t, cr.cmd)
+
+	r = FlatCmd(cr, "HSET", "foo", struct{ F func() }{})
+	assert.True(t, r.IsType(AppErr))
+	assert.Empty(t, cr.cmd)
+}
@@ -0,0 +1,160 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+)
+
+// readInline reads an inline command, i.e. a line of space separated
+// arguments like redis-cli and telnet send, and returns it as an Array of
+// BulkStrs. Empty lines are skipped, as redis does.
+func (rr *RespReader) readInline() (Resp, error) {
+	for {
+		line, err := rr.readInlineLine()
+		if err != nil {
+			return Resp{}, err
+		}
+		args, err := splitInlineArgs(line)
+		if err != nil {
+			return Resp{}, err
+		} else if len(args) == 0 {
+			continue
+		}
+
+		arr := make([]Resp, len(args))
+		for i := range args {
+			arr[i] = Resp{typ: BulkStr, val: args[i]}
+		}
+		return Resp{typ: Array, val: arr}, nil
+	}
+}
+
+// readInlineLine reads a line terminated by either LF or CRLF, returning it
+// without the terminator
+func (rr *RespReader) readInlineLine() ([]byte, error) {
+	var line []byte
+	for {
+		b, err := rr.r.ReadSlice('\n')
+		if int64(len(line)+len(b)) > rr.o.MaxBulkLen+2 {
+			return nil, protocolErrorf("inline command longer than %d bytes", rr.o.MaxBulkLen)
+		}
+		line = append(line, b...)
+		if err == bufio.ErrBufferFull {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		break
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
+
+func isInlineSpace(c byte) bool {
+	switch c {
+	case ' ', '\n', '\r', '\t', '\v', '\f':
+		return true
+	}
+	return false
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// splitInlineArgs splits an inline command into its arguments following the
+// same rules as redis (see sdssplitargs). Arguments are separated by
+// whitespace, and may be quoted. Double quoted arguments may contain the
+// escapes \n, \r, \t, \b, \a and \xHH, and may escape any other character
+// with a backslash, single quoted arguments may only escape \'. A closing
+// quote must be followed by whitespace or the end of the line.
+func splitInlineArgs(line []byte) ([][]byte, error) {
+	var args [][]byte
+	i := 0
+	for {
+		for i < len(line) && isInlineSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		var arg bytes.Buffer
+		var inDQ, inSQ bool
+		for done := false; !done; {
+			if inDQ {
+				if i == len(line) {
+					return nil, protocolErrorf("unbalanced quotes in inline command")
+				}
+				switch c := line[i]; {
+				case c == '\\' && i+3 < len(line) && line[i+1] == 'x' &&
+					isHexDigit(line[i+2]) && isHexDigit(line[i+3]):
+					b, _ := strconv.ParseUint(string(line[i+2:i+4]), 16, 8)
+					arg.WriteByte(byte(b))
+					i += 3
+				case c == '\\' && i+1 < len(line):
+					i++
+					switch line[i] {
+					case 'n':
+						arg.WriteByte('\n')
+					case 'r':
+						arg.WriteByte('\r')
+					case 't':
+						arg.WriteByte('\t')
+					case 'b':
+						arg.WriteByte('\b')
+					case 'a':
+						arg.WriteByte('\a')
+					default:
+						arg.WriteByte(line[i])
+					}
+				case c == '"':
+					if i+1 < len(line) && !isInlineSpace(line[i+1]) {
+						return nil, protocolErrorf("closing quote must be followed by a space in inline command")
+					}
+					done = true
+				default:
+					arg.WriteByte(c)
+				}
+			} else if inSQ {
+				if i == len(line) {
+					return nil, protocolErrorf("unbalanced quotes in inline command")
+				}
+				switch c := line[i]; {
+				case c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					i++
+					arg.WriteByte('\'')
+				case c == '\'':
+					if i+1 < len(line) && !isInlineSpace(line[i+1]) {
+						return nil, protocolErrorf("closing quote must be followed by a space in inline command")
+					}
+					done = true
+				default:
+					arg.WriteByte(c)
+				}
+			} else {
+				if i == len(line) {
+					break
+				}
+				switch c := line[i]; c {
+				case ' ', '\n', '\r', '\t', '\v', '\f':
+					done = true
+				case '"':
+					inDQ = true
+				case '\'':
+					inSQ = true
+				default:
+					arg.WriteByte(c)
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		args = append(args, append([]byte{}, arg.Bytes()...))
+	}
+}
@@ -0,0 +1,78 @@
+package redis
+
+import (
+	"bytes"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitInlineArgs(t *T) {
+	for _, test := range []struct {
+		line string
+		args []string
+	}{
+		{"", nil},
+		{"   \t ", nil},
+		{"PING", []string{"PING"}},
+		{"  SET  foo   bar ", []string{"SET", "foo", "bar"}},
+		{`SET foo "bar baz"`, []string{"SET", "foo", "bar baz"}},
+		{`SET foo ""`, []string{"SET", "foo", ""}},
+		{`SET "a\"b" "\x41\x4a\n\t\\\z"`, []string{"SET", `a"b`, "AJ\n\t\\z"}},
+		{`SET "\xZZ"`, []string{"SET", "xZZ"}},
+		{`SET 'it\'s' '\n'`, []string{"SET", "it's", `\n`}},
+		{`SET foo"bar baz"`, []string{"SET", "foobar baz"}},
+	} {
+		args, err := splitInlineArgs([]byte(test.line))
+		require.Nil(t, err, "line: %q", test.line)
+		var strs []string
+		for _, arg := range args {
+			strs = append(strs, string(arg))
+		}
+		assert.Equal(t, test.args, strs, "line: %q", test.line)
+	}
+
+	for _, line := range []string{
+		`SET "foo`,
+		`SET 'foo`,
+		`SET "foo"bar`,
+		`SET 'foo'bar`,
+	} {
+		_, err := splitInlineArgs([]byte(line))
+		_, ok := err.(*ProtocolError)
+		assert.True(t, ok, "line: %q err: %v", line, err)
+	}
+}
+
+func TestReadInline(t *T) {
+	buf := bytes.NewBufferString("PING\r\n\r\nSET foo \"bar baz\"\n*1\r\n$4\r\nPING\r\n")
+	rr := NewRespReaderWithOpts(buf, RespReaderOpts{Inline: true})
+
+	l, err := rr.Read().List()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"PING"}, l)
+
+	// the empty line is skipped, and a lone LF terminates the line too
+	l, err = rr.Read().List()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"SET", "foo", "bar baz"}, l)
+
+	// normal RESP can still be mixed in
+	l, err = rr.Read().List()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"PING"}, l)
+
+	// without the option inline commands are a protocol error
+	r := pretendRead("PING\r\n")
+	_, ok := r.Err.(*ProtocolError)
+	assert.True(t, ok)
+
+	// the line length is limited by MaxBulkLen
+	rr = NewRespReaderWithOpts(
+		bytes.NewBufferString("SET foo bar\r\n"),
+		RespReaderOpts{Inline: true, MaxBulkLen: 5},
+	)
+	_, ok = rr.Read().Err.(*ProtocolError)
+	assert.True(t, ok)
+}
@@ -0,0 +1,269 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+var (
+	typeOfDuration    = reflect.TypeOf(time.Duration(0))
+	typeOfResp        = reflect.TypeOf(Resp{})
+	typeOfGeoLocation = reflect.TypeOf(GeoLocation{})
+)
+
+// Into decodes the Resp into the value pointed to by v. It's meant for the
+// Array replies of alternating field names and values which commands like
+// HGETALL, CONFIG GET and SENTINEL MASTER return, which can be decoded into a
+// map with string keys and values of any type listed below, or into a struct:
+//
+//	type User struct {
+//		Name  string        `redis:"name"`
+//		Age   int           `redis:"age"`
+//		Email *string       `redis:"email"`
+//		TTL   time.Duration `redis:"ttl"`
+//	}
+//
+//	var u User
+//	err := client.Cmd("HGETALL", "user:1").Into(&u)
+//
+// Struct fields are matched to the names in the reply like FlatCmd names them,
+// by their redis tag or otherwise their name, with the fields of embedded
+// structs being matched as if they belonged to the struct embedding them.
+// Names in the reply which match no field are ignored, and fields which match
+// no name are left as they are, so a pointer field stays nil if its name isn't
+// in the reply.
+//
+// Values may be decoded into strings, []byte, any of the int, uint and float
+// types, bool, time.Duration and time.Time (from an integer number of
+// milliseconds, the inverse of which is how Cmd and FlatCmd send them, or for
+// time.Time a string in TimeFormat), anything implementing
+// encoding.BinaryUnmarshaler or encoding.TextUnmarshaler, like a big.Int,
+// Resp, GeoLocation (from an element of a GEOSEARCH reply), pointers to any of
+// these, and also slices, maps and structs, which are decoded from Array
+// values in the same way as the reply itself. A Nil value is decoded as the
+// zero value, or nil for a pointer.
+//
+// If the Resp is an error that's returned, and nothing is decoded.
+func (r *Resp) Into(v interface{}) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("can't decode into non-pointer %T", v)
+	}
+	return decodeInto(rv.Elem(), r)
+}
+
+func decodeInto(v reflect.Value, r *Resp) error {
+	if r.Err != nil {
+		return r.Err
+	}
+
+	if v.Type() == typeOfResp {
+		v.Set(reflect.ValueOf(*r))
+		return nil
+	} else if v.Kind() == reflect.Ptr {
+		if r.IsType(Nil) {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		} else if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeInto(v.Elem(), r)
+	} else if r.IsType(Nil) {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	} else if v.Type() == typeOfDuration {
+		ms, err := r.Int64()
+		if err != nil {
+			return err
+		}
+		v.SetInt(ms * int64(time.Millisecond))
+		return nil
+	} else if v.Type() == typeOfGeoLocation {
+		return decodeGeoLocation(v, r)
+	} else if ok, err := unmarshalInto(v, r); ok {
+		return err
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		s, err := respStr(r)
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := r.Int64()
+		if err != nil {
+			return err
+		} else if v.OverflowInt(i) {
+			return fmt.Errorf("%d overflows %s", i, v.Type())
+		}
+		v.SetInt(i)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		s, err := respStr(r)
+		if err != nil {
+			return err
+		}
+		u, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return err
+		} else if v.OverflowUint(u) {
+			return fmt.Errorf("%d overflows %s", u, v.Type())
+		}
+		v.SetUint(u)
+
+	case reflect.Float32, reflect.Float64:
+		s, err := respStr(r)
+		if err != nil {
+			return err
+		}
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+
+	case reflect.Bool:
+		s, err := respStr(r)
+		if err != nil {
+			return err
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			s, err := respStr(r)
+			if err != nil {
+				return err
+			}
+			v.SetBytes([]byte(s))
+			return nil
+		}
+		a, err := r.betterArray()
+		if err != nil {
+			return err
+		}
+		s := reflect.MakeSlice(v.Type(), len(a), len(a))
+		for i := range a {
+			if err := decodeInto(s.Index(i), &a[i]); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("can't decode into map with %s keys", v.Type().Key())
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		return decodePairs(r, func(name string, val *Resp) error {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := decodeInto(elem, val); err != nil {
+				return err
+			}
+			v.SetMapIndex(reflect.ValueOf(name).Convert(v.Type().Key()), elem)
+			return nil
+		})
+
+	case reflect.Struct:
+		fields := map[string][]int{}
+		structFields(v.Type(), nil, fields)
+		return decodePairs(r, func(name string, val *Resp) error {
+			index, ok := fields[name]
+			if !ok {
+				return nil
+			}
+			if err := decodeInto(fieldByIndex(v, index), val); err != nil {
+				return fmt.Errorf("field %q: %s", name, err)
+			}
+			return nil
+		})
+
+	default:
+		return fmt.Errorf("can't decode into %s", v.Type())
+	}
+	return nil
+}
+
+// respStr is like Str, but also works for an Int
+func respStr(r *Resp) (string, error) {
+	if i, ok := r.val.(int64); ok {
+		return strconv.FormatInt(i, 10), nil
+	}
+	return r.Str()
+}
+
+// decodePairs calls fn with each of the alternating names and values in an
+// Array
+func decodePairs(r *Resp, fn func(string, *Resp) error) error {
+	a, err := r.betterArray()
+	if err != nil {
+		return err
+	} else if len(a)%2 != 0 {
+		return errors.New("reply has odd number of elements")
+	}
+	for i := 0; i < len(a); i += 2 {
+		name, err := respStr(&a[i])
+		if err != nil {
+			return err
+		}
+		if err := fn(name, &a[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// structFields fills fields with the index of each of the struct type's fields
+// by name, as FlatCmd would name them. The fields of embedded structs come
+// after those of the struct itself, so that, as in Go, the outer field wins if
+// they have the same name.
+func structFields(t reflect.Type, index []int, fields map[string][]int) {
+	var embedded []int
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.PkgPath == "" && indirect(f.Type).Kind() == reflect.Struct {
+			embedded = append(embedded, i)
+			continue
+		}
+		name, ok := fieldName(f)
+		if !ok {
+			continue
+		} else if _, ok := fields[name]; !ok {
+			fields[name] = append(append([]int(nil), index...), i)
+		}
+	}
+	for _, i := range embedded {
+		f := t.Field(i)
+		structFields(indirect(f.Type), append(append([]int(nil), index...), i), fields)
+	}
+}
+
+// fieldByIndex is like reflect.Value's FieldByIndex, but allocates any nil
+// embedded pointers on the way
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for _, i := range index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v
+}
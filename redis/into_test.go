@@ -0,0 +1,140 @@
+package redis
+
+import (
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type IntoEmbedded struct {
+	Created int64 `redis:"created"`
+	Name    string
+}
+
+type intoUser struct {
+	*IntoEmbedded
+	Name   string        `redis:"name"`
+	Email  *string       `redis:"email"`
+	Phone  *string       `redis:"phone"`
+	Age    uint8         `redis:"age"`
+	Score  float64       `redis:"score"`
+	Admin  bool          `redis:"admin"`
+	TTL    time.Duration `redis:"ttl"`
+	Raw    []byte
+	Tags   []string          `redis:"tags"`
+	Counts map[string]int    `redis:"counts"`
+	Resp   Resp              `redis:"resp"`
+	Nested map[string]*int64 `redis:"nested"`
+}
+
+func TestIntoStruct(t *T) {
+	r := NewResp([]interface{}{
+		"name", "bob",
+		"created", 5,
+		"email", "bob@example.com",
+		"age", "30",
+		"score", "1.5",
+		"admin", 1,
+		"ttl", 1500,
+		"Raw", "raw",
+		"tags", []string{"a", "b"},
+		"counts", []interface{}{"x", 1, "y", "2"},
+		"resp", 10,
+		"nested", []interface{}{"n", nil},
+		"unknown", "ignored",
+	})
+
+	var u intoUser
+	require.Nil(t, r.Into(&u))
+	assert.Equal(t, "bob", u.Name)
+	require.NotNil(t, u.IntoEmbedded)
+	assert.Equal(t, int64(5), u.Created)
+	assert.Equal(t, "", u.IntoEmbedded.Name)
+	require.NotNil(t, u.Email)
+	assert.Equal(t, "bob@example.com", *u.Email)
+	assert.Nil(t, u.Phone)
+	assert.Equal(t, uint8(30), u.Age)
+	assert.Equal(t, 1.5, u.Score)
+	assert.True(t, u.Admin)
+	assert.Equal(t, 1500*time.Millisecond, u.TTL)
+	assert.Equal(t, []byte("raw"), u.Raw)
+	assert.Equal(t, []string{"a", "b"}, u.Tags)
+	assert.Equal(t, map[string]int{"x": 1, "y": 2}, u.Counts)
+	assert.True(t, u.Resp.IsType(Int))
+	assert.Equal(t, map[string]*int64{"n": nil}, u.Nested)
+}
+
+func TestIntoMap(t *T) {
+	r := NewResp([]interface{}{"a", "1", "b", 2})
+
+	var ms map[string]string
+	require.Nil(t, r.Into(&ms))
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, ms)
+
+	var mi map[string]int
+	require.Nil(t, r.Into(&mi))
+	assert.Equal(t, map[string]int{"a": 1, "b": 2}, mi)
+
+	var mb map[string][]byte
+	require.Nil(t, r.Into(&mb))
+	assert.Equal(t, map[string][]byte{"a": []byte("1"), "b": []byte("2")}, mb)
+
+	var empty map[string]string
+	require.Nil(t, NewResp([]string{}).Into(&empty))
+	assert.NotNil(t, empty)
+	assert.Empty(t, empty)
+}
+
+func TestIntoErrs(t *T) {
+	var m map[string]string
+	assert.NotNil(t, NewResp([]string{"a"}).Into(&m))
+	assert.NotNil(t, NewResp("foo").Into(&m))
+	assert.NotNil(t, NewResp([]string{"a", "b"}).Into(m))
+
+	errR := NewResp(errBadType)
+	assert.Equal(t, errBadType, errR.Into(&m))
+
+	var u intoUser
+	assert.NotNil(t, NewResp([]string{"age", "300"}).Into(&u))
+	assert.NotNil(t, NewResp([]string{"age", "foo"}).Into(&u))
+}
+
+// TestIntoFlatCmd tests that a struct sent with FlatCmd can be read back with
+// Into
+func TestIntoFlatCmd(t *T) {
+	email := "bob@example.com"
+	in := intoUser{
+		IntoEmbedded: &IntoEmbedded{Created: 5},
+		Name:         "bob",
+		Email:        &email,
+		Age:          30,
+		TTL:          time.Minute,
+		Raw:          []byte("raw"),
+	}
+	// Tags, Counts and Nested are left out, since FlatCmd flattens them inline
+	// rather than as a single value
+	cr := new(cmdRecorder)
+	type flatIn struct {
+		*IntoEmbedded
+		Name  string        `redis:"name"`
+		Email *string       `redis:"email"`
+		Phone *string       `redis:"phone"`
+		Age   uint8         `redis:"age"`
+		TTL   time.Duration `redis:"ttl"`
+		Raw   []byte
+	}
+	require.Nil(t, FlatCmdNoKey(cr, "HSET", flatIn{
+		IntoEmbedded: in.IntoEmbedded,
+		Name:         in.Name,
+		Email:        in.Email,
+		Age:          in.Age,
+		TTL:          in.TTL,
+		Raw:          in.Raw,
+	}).Err)
+
+	var out intoUser
+	require.Nil(t, NewRespFlattenedStrings(cr.args).Into(&out))
+	assert.Equal(t, in, out)
+}
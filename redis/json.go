@@ -0,0 +1,188 @@
+package redis
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// JSONLimits bounds how much of a Resp is written out by MarshalJSONLimits, so
+// that a huge or deeply nested Resp can't produce a huge log line. Values past
+// the limits are dropped, and the JSON for the value they were dropped from has
+// a "truncated" field with the original length of that value.
+type JSONLimits struct {
+	// The maximum length of a string value, in bytes. Zero means no limit.
+	MaxStrLen int
+
+	// The maximum number of elements written for an array. Zero means no
+	// limit.
+	MaxArrayLen int
+
+	// The maximum nesting depth of arrays. The elements of an array nested
+	// deeper than this aren't written. Zero means no limit.
+	MaxDepth int
+}
+
+// DefaultJSONLimits are the JSONLimits used by MarshalJSON
+var DefaultJSONLimits = JSONLimits{
+	MaxStrLen:   1024,
+	MaxArrayLen: 100,
+	MaxDepth:    8,
+}
+
+// jsonResp is the JSON form of a Resp
+type jsonResp struct {
+	Type string      `json:"type"`
+	Val  interface{} `json:"val,omitempty"`
+
+	// Set on strings which aren't valid utf8, in which case Val is base64
+	// encoded
+	Base64 bool `json:"base64,omitempty"`
+
+	// Set on errors which are IOErrs rather than AppErrs
+	IO bool `json:"io,omitempty"`
+
+	// The original length of a string or array which was truncated
+	Truncated int `json:"truncated,omitempty"`
+}
+
+// MarshalJSON implements the json.Marshaler interface, using
+// DefaultJSONLimits. This is useful for logging Resps in a machine readable
+// way. For example:
+//
+//	{"type":"BulkStr","val":"foo"}
+//	{"type":"Array","val":[{"type":"Int","val":1},{"type":"Nil"}]}
+//	{"type":"Error","val":"ERR unknown command"}
+//	{"type":"BulkStr","val":"/w==","base64":true}
+//
+// IOErrs have type "Error" as well, but with "io":true set.
+func (r *Resp) MarshalJSON() ([]byte, error) {
+	return r.MarshalJSONLimits(DefaultJSONLimits)
+}
+
+// MarshalJSONLimits is like MarshalJSON, but uses the given JSONLimits
+func (r *Resp) MarshalJSONLimits(l JSONLimits) ([]byte, error) {
+	return json.Marshal(r.toJSON(l, 1))
+}
+
+func (r *Resp) toJSON(l JSONLimits, depth int) jsonResp {
+	switch r.typ {
+	case SimpleStr, BulkStr:
+		jr := jsonResp{Type: "BulkStr"}
+		if r.typ == SimpleStr {
+			jr.Type = "SimpleStr"
+		}
+		b := r.val.([]byte)
+		if l.MaxStrLen > 0 && len(b) > l.MaxStrLen {
+			jr.Truncated = len(b)
+			b = b[:l.MaxStrLen]
+		}
+		if utf8.Valid(b) {
+			jr.Val = string(b)
+		} else {
+			jr.Val = base64.StdEncoding.EncodeToString(b)
+			jr.Base64 = true
+		}
+		return jr
+	case Int:
+		return jsonResp{Type: "Int", Val: r.val.(int64)}
+	case AppErr, IOErr:
+		return jsonResp{Type: "Error", Val: r.Err.Error(), IO: r.typ == IOErr}
+	case Nil:
+		return jsonResp{Type: "Nil"}
+	case Array:
+		jr := jsonResp{Type: "Array"}
+		arr := r.val.([]Resp)
+		if l.MaxDepth > 0 && depth > l.MaxDepth && len(arr) > 0 {
+			jr.Truncated = len(arr)
+			return jr
+		}
+		if l.MaxArrayLen > 0 && len(arr) > l.MaxArrayLen {
+			jr.Truncated = len(arr)
+			arr = arr[:l.MaxArrayLen]
+		}
+		vals := make([]jsonResp, len(arr))
+		for i := range arr {
+			vals[i] = arr[i].toJSON(l, depth+1)
+		}
+		jr.Val = vals
+		return jr
+	default:
+		return jsonResp{Type: "Unknown"}
+	}
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface, decoding the JSON
+// produced by MarshalJSON back into a Resp. Values which were truncated are
+// decoded as they were written, so the Resp won't be the same as the original.
+func (r *Resp) UnmarshalJSON(b []byte) error {
+	var jr struct {
+		Type   string          `json:"type"`
+		Val    json.RawMessage `json:"val"`
+		Base64 bool            `json:"base64"`
+		IO     bool            `json:"io"`
+	}
+	if err := json.Unmarshal(b, &jr); err != nil {
+		return err
+	}
+
+	switch jr.Type {
+	case "SimpleStr", "BulkStr":
+		var s string
+		if err := unmarshalJSONVal(jr.Val, &s); err != nil {
+			return err
+		}
+		bs := []byte(s)
+		if jr.Base64 {
+			var err error
+			if bs, err = base64.StdEncoding.DecodeString(s); err != nil {
+				return err
+			}
+		}
+		*r = Resp{typ: BulkStr, val: bs}
+		if jr.Type == "SimpleStr" {
+			r.typ = SimpleStr
+		}
+	case "Int":
+		var i int64
+		if err := unmarshalJSONVal(jr.Val, &i); err != nil {
+			return err
+		}
+		*r = Resp{typ: Int, val: i}
+	case "Error":
+		var s string
+		if err := unmarshalJSONVal(jr.Val, &s); err != nil {
+			return err
+		}
+		err := errors.New(s)
+		*r = Resp{typ: AppErr, val: err, Err: err}
+		if jr.IO {
+			r.typ = IOErr
+		}
+	case "Nil":
+		*r = Resp{typ: Nil}
+	case "Array":
+		var vals []Resp
+		if err := unmarshalJSONVal(jr.Val, &vals); err != nil {
+			return err
+		}
+		if vals == nil {
+			vals = []Resp{}
+		}
+		*r = Resp{typ: Array, val: vals}
+	default:
+		return fmt.Errorf("unknown resp type %q", jr.Type)
+	}
+	return nil
+}
+
+// unmarshalJSONVal unmarshals the val field of a Resp's JSON into v. If there
+// was no val field v is left as its zero value.
+func unmarshalJSONVal(b json.RawMessage, v interface{}) error {
+	if len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, v)
+}
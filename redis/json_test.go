@@ -0,0 +1,72 @@
+package redis
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRespMarshalJSON(t *T) {
+	for _, test := range []struct {
+		r        *Resp
+		expected string
+	}{
+		{NewRespSimple("OK"), `{"type":"SimpleStr","val":"OK"}`},
+		{NewResp("foo"), `{"type":"BulkStr","val":"foo"}`},
+		{NewResp(""), `{"type":"BulkStr","val":""}`},
+		{NewResp([]byte{0xff}), `{"type":"BulkStr","val":"/w==","base64":true}`},
+		{NewResp(5), `{"type":"Int","val":5}`},
+		{NewResp(nil), `{"type":"Nil"}`},
+		{NewResp(errors.New("ERR bad")), `{"type":"Error","val":"ERR bad"}`},
+		{NewRespIOErr(errors.New("EOF")), `{"type":"Error","val":"EOF","io":true}`},
+		{NewResp([]interface{}{1, nil}), `{"type":"Array","val":[{"type":"Int","val":1},{"type":"Nil"}]}`},
+		{NewResp([]interface{}{}), `{"type":"Array","val":[]}`},
+	} {
+		b, err := json.Marshal(test.r)
+		require.Nil(t, err)
+		assert.Equal(t, test.expected, string(b))
+
+		var r Resp
+		require.Nil(t, json.Unmarshal(b, &r), "json: %s", b)
+		assert.Equal(t, test.r.String(), r.String())
+		assert.Equal(t, test.r.typ, r.typ)
+	}
+}
+
+func TestRespMarshalJSONLimits(t *T) {
+	l := JSONLimits{MaxStrLen: 3, MaxArrayLen: 2, MaxDepth: 2}
+
+	b, err := NewResp("foobar").MarshalJSONLimits(l)
+	require.Nil(t, err)
+	assert.Equal(t, `{"type":"BulkStr","val":"foo","truncated":6}`, string(b))
+
+	b, err = NewResp([]int{1, 2, 3}).MarshalJSONLimits(l)
+	require.Nil(t, err)
+	assert.Equal(t, `{"type":"Array","val":[{"type":"Int","val":1},{"type":"Int","val":2}],"truncated":3}`, string(b))
+
+	nested := NewResp([]interface{}{[]interface{}{[]interface{}{1}}})
+	b, err = nested.MarshalJSONLimits(l)
+	require.Nil(t, err)
+	assert.Equal(t, `{"type":"Array","val":[{"type":"Array","val":[{"type":"Array","truncated":1}]}]}`, string(b))
+
+	// the default limits apply to MarshalJSON
+	b, err = json.Marshal(NewResp(strings.Repeat("a", 2000)))
+	require.Nil(t, err)
+	assert.Contains(t, string(b), `"truncated":2000`)
+
+	// no limits
+	b, err = NewResp(strings.Repeat("a", 2000)).MarshalJSONLimits(JSONLimits{})
+	require.Nil(t, err)
+	assert.NotContains(t, string(b), "truncated")
+}
+
+func TestRespUnmarshalJSONErrors(t *T) {
+	var r Resp
+	assert.NotNil(t, json.Unmarshal([]byte(`{"type":"Foo"}`), &r))
+	assert.NotNil(t, json.Unmarshal([]byte(`{"type":"Int","val":"a"}`), &r))
+	assert.NotNil(t, json.Unmarshal([]byte(`{"type":"BulkStr","val":"!","base64":true}`), &r))
+}
@@ -0,0 +1,191 @@
+package redis
+
+import (
+	"strconv"
+	"strings"
+)
+
+// keySpec describes where the keys are in a command's arguments. first and
+// last are indexes into the arguments (not including the command name itself),
+// with negative values of last counting back from the end, so -1 is the last
+// argument. step is the distance between keys.
+//
+// If numKeys is not -1 then it's the index of an argument giving the number of
+// keys, which directly follow it. In that case first is the index of a key
+// which comes before the numkeys argument (e.g. the destination of
+// ZUNIONSTORE), or -1 if there isn't one.
+type keySpec struct {
+	first, last, step int
+	numKeys           int
+}
+
+var (
+	specFirst    = keySpec{0, 0, 1, -1}
+	specAll      = keySpec{0, -1, 1, -1}
+	specTwo      = keySpec{0, 1, 1, -1}
+	specPairs    = keySpec{0, -1, 2, -1}
+	specBlocking = keySpec{0, -2, 1, -1}
+)
+
+// keySpecs is the table of known commands. Commands which aren't in it are
+// treated as having unknown keys. Commands whose keys depend on a subcommand or
+// a token (e.g. XREAD) are handled in CmdKeys directly.
+var keySpecs = map[string]keySpec{}
+
+func init() {
+	add := func(spec keySpec, cmds ...string) {
+		for _, cmd := range cmds {
+			keySpecs[cmd] = spec
+		}
+	}
+
+	add(specFirst,
+		// keys and strings
+		"GET", "SET", "SETNX", "SETEX", "PSETEX", "APPEND", "GETSET",
+		"GETDEL", "GETEX", "GETRANGE", "SETRANGE", "STRLEN", "INCR", "INCRBY",
+		"INCRBYFLOAT", "DECR", "DECRBY", "EXPIRE", "PEXPIRE", "EXPIREAT",
+		"PEXPIREAT", "EXPIRETIME", "PEXPIRETIME", "TTL", "PTTL", "PERSIST",
+		"TYPE", "DUMP", "RESTORE", "MOVE", "SORT", "SORT_RO",
+		"SETBIT", "GETBIT", "BITCOUNT", "BITPOS", "BITFIELD", "BITFIELD_RO",
+		// hashes
+		"HSET", "HSETNX", "HGET", "HMSET", "HMGET", "HDEL", "HLEN", "HKEYS",
+		"HVALS", "HGETALL", "HEXISTS", "HINCRBY", "HINCRBYFLOAT", "HSTRLEN",
+		"HSCAN", "HRANDFIELD",
+		// lists
+		"LPUSH", "RPUSH", "LPUSHX", "RPUSHX", "LPOP", "RPOP", "LLEN",
+		"LRANGE", "LINDEX", "LSET", "LREM", "LTRIM", "LINSERT", "LPOS",
+		// sets
+		"SADD", "SREM", "SCARD", "SMEMBERS", "SISMEMBER", "SMISMEMBER",
+		"SPOP", "SRANDMEMBER", "SSCAN",
+		// sorted sets
+		"ZADD", "ZREM", "ZCARD", "ZSCORE", "ZMSCORE", "ZINCRBY", "ZRANK",
+		"ZREVRANK", "ZRANGE", "ZREVRANGE", "ZRANGEBYSCORE", "ZREVRANGEBYSCORE",
+		"ZRANGEBYLEX", "ZREVRANGEBYLEX", "ZCOUNT", "ZLEXCOUNT",
+		"ZREMRANGEBYRANK", "ZREMRANGEBYSCORE", "ZREMRANGEBYLEX", "ZSCAN",
+		"ZPOPMIN", "ZPOPMAX", "ZRANDMEMBER",
+		// hyperloglog, geo, streams
+		"PFADD", "GEOADD", "GEOPOS", "GEODIST", "GEOHASH", "GEORADIUS",
+		"GEORADIUSBYMEMBER", "GEOSEARCH", "XADD", "XLEN", "XRANGE",
+		"XREVRANGE", "XDEL", "XTRIM", "XACK", "XCLAIM", "XAUTOCLAIM",
+		"XPENDING", "XSETID",
+		// sharded pub/sub, whose channels are hashed to slots like keys
+		"SPUBLISH",
+	)
+	add(specAll,
+		"DEL", "UNLINK", "EXISTS", "TOUCH", "MGET", "WATCH", "SINTER",
+		"SUNION", "SDIFF", "SINTERSTORE", "SUNIONSTORE", "SDIFFSTORE",
+		"PFCOUNT", "PFMERGE", "SSUBSCRIBE", "SUNSUBSCRIBE",
+	)
+	add(specTwo,
+		"RENAME", "RENAMENX", "RPOPLPUSH", "BRPOPLPUSH", "SMOVE", "LMOVE",
+		"BLMOVE", "COPY", "GEOSEARCHSTORE", "ZRANGESTORE",
+	)
+	add(specPairs, "MSET", "MSETNX")
+	add(specBlocking, "BLPOP", "BRPOP", "BZPOPMIN", "BZPOPMAX")
+	add(keySpec{1, -1, 1, -1}, "BITOP")
+	add(keySpec{-1, 0, 1, 1},
+		"EVAL", "EVALSHA", "EVAL_RO", "EVALSHA_RO", "FCALL", "FCALL_RO",
+		"BLMPOP", "BZMPOP",
+	)
+	add(keySpec{-1, 0, 1, 0},
+		"SINTERCARD", "ZINTERCARD", "ZUNION", "ZINTER", "ZDIFF", "LMPOP",
+		"ZMPOP",
+	)
+	add(keySpec{0, 0, 1, 1}, "ZUNIONSTORE", "ZINTERSTORE", "ZDIFFSTORE")
+}
+
+// subcommandKeys lists the commands whose first argument is a subcommand and
+// whose second argument is a key, for the subcommands which take one
+var subcommandKeys = map[string]map[string]bool{
+	"OBJECT": {"ENCODING": true, "FREQ": true, "IDLETIME": true, "REFCOUNT": true},
+	"MEMORY": {"USAGE": true},
+	"XGROUP": {
+		"CREATE": true, "DESTROY": true, "CREATECONSUMER": true,
+		"DELCONSUMER": true, "SETID": true,
+	},
+	"XINFO": {"STREAM": true, "GROUPS": true, "CONSUMERS": true},
+}
+
+// CmdKeys returns the indexes of the arguments of the given command which are
+// keys. args are the command's arguments, not including the command name, in
+// the same flattened form they'll be sent to redis in (see
+// NewRespFlattenedStrings).
+//
+// The boolean returned is false if the command isn't one whose keys are known.
+// A known command may still have no keys given in its arguments, in which case
+// an empty slice and true are returned.
+func CmdKeys(cmd string, args []string) ([]int, bool) {
+	cmd = strings.ToUpper(cmd)
+
+	if subs, ok := subcommandKeys[cmd]; ok {
+		if len(args) > 1 && subs[strings.ToUpper(args[0])] {
+			return []int{1}, true
+		}
+		return []int{}, true
+	}
+
+	if cmd == "XREAD" || cmd == "XREADGROUP" {
+		for i := range args {
+			if strings.ToUpper(args[i]) == "STREAMS" {
+				rest := len(args) - i - 1
+				keys := make([]int, 0, rest/2)
+				for j := 0; j < rest/2; j++ {
+					keys = append(keys, i+1+j)
+				}
+				return keys, true
+			}
+		}
+		return []int{}, true
+	}
+
+	spec, ok := keySpecs[cmd]
+	if !ok {
+		return nil, false
+	}
+
+	keys := []int{}
+	if spec.numKeys >= 0 {
+		if spec.first >= 0 && spec.first < len(args) {
+			keys = append(keys, spec.first)
+		}
+		if spec.numKeys >= len(args) {
+			return keys, true
+		}
+		n, err := strconv.Atoi(args[spec.numKeys])
+		if err != nil || n < 0 {
+			return keys, true
+		}
+		for i := spec.numKeys + 1; i <= spec.numKeys+n && i < len(args); i++ {
+			keys = append(keys, i)
+		}
+		return keys, true
+	}
+
+	last := spec.last
+	if last < 0 {
+		last = len(args) + last
+	}
+	for i := spec.first; i <= last && i < len(args); i += spec.step {
+		keys = append(keys, i)
+	}
+	return keys, true
+}
+
+// KeyFromCmd is like KeyFromArgs, but uses the same table of commands as
+// CmdKeys to find the first key, so that commands like EVAL, whose first
+// argument isn't a key, are handled correctly. If the command isn't known, or
+// no key can be found in its arguments, this falls back to KeyFromArgs.
+func KeyFromCmd(cmd string, args ...interface{}) (string, error) {
+	if spec, ok := keySpecs[strings.ToUpper(cmd)]; ok && spec.first == 0 {
+		return KeyFromArgs(args...)
+	}
+
+	argsStr, err := NewRespFlattenedStrings(args).List()
+	if err != nil {
+		return KeyFromArgs(args...)
+	}
+	if keys, _ := CmdKeys(cmd, argsStr); len(keys) > 0 {
+		return argsStr[keys[0]], nil
+	}
+	return KeyFromArgs(args...)
+}
@@ -0,0 +1,70 @@
+package redis
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCmdKeys(t *T) {
+	type test struct {
+		cmd   string
+		args  []string
+		keys  []int
+		known bool
+	}
+
+	tests := []test{
+		{"GET", []string{"foo"}, []int{0}, true},
+		{"get", []string{"foo"}, []int{0}, true},
+		{"SET", []string{"foo", "bar", "EX", "10"}, []int{0}, true},
+		{"DEL", []string{"a", "b", "c"}, []int{0, 1, 2}, true},
+		{"MSET", []string{"a", "1", "b", "2"}, []int{0, 2}, true},
+		{"RENAME", []string{"a", "b"}, []int{0, 1}, true},
+		{"BLPOP", []string{"a", "b", "0"}, []int{0, 1}, true},
+		{"BITOP", []string{"AND", "dst", "a", "b"}, []int{1, 2, 3}, true},
+		{"EVAL", []string{"return 1", "2", "a", "b", "arg"}, []int{2, 3}, true},
+		{"EVAL", []string{"return 1", "0", "arg"}, []int{}, true},
+		{"EVALSHA", []string{"abc", "bad", "a"}, []int{}, true},
+		{"ZUNIONSTORE", []string{"dst", "2", "a", "b", "WEIGHTS", "1", "2"}, []int{0, 2, 3}, true},
+		{"ZUNION", []string{"2", "a", "b", "WITHSCORES"}, []int{1, 2}, true},
+		{"BLMPOP", []string{"0", "1", "a", "LEFT"}, []int{2}, true},
+		{"XREAD", []string{"COUNT", "1", "STREAMS", "a", "b", "0", "0"}, []int{3, 4}, true},
+		{"XREADGROUP", []string{"GROUP", "g", "c", "STREAMS", "a", ">"}, []int{4}, true},
+		{"OBJECT", []string{"ENCODING", "foo"}, []int{1}, true},
+		{"OBJECT", []string{"HELP"}, []int{}, true},
+		{"XINFO", []string{"STREAM", "s"}, []int{1}, true},
+		{"SPUBLISH", []string{"ch", "msg"}, []int{0}, true},
+		{"SSUBSCRIBE", []string{"a", "b"}, []int{0, 1}, true},
+		{"PING", nil, nil, false},
+		{"FOO", []string{"a"}, nil, false},
+	}
+
+	for _, test := range tests {
+		keys, known := CmdKeys(test.cmd, test.args)
+		assert.Equal(t, test.known, known, "test: %v", test)
+		assert.Equal(t, test.keys, keys, "test: %v", test)
+	}
+}
+
+func TestKeyFromCmd(t *T) {
+	key, err := KeyFromCmd("GET", "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", key)
+
+	key, err = KeyFromCmd("EVAL", "return 1", 1, "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", key)
+
+	key, err = KeyFromCmd("XREAD", "COUNT", 1, "STREAMS", []string{"a", "0"})
+	assert.Nil(t, err)
+	assert.Equal(t, "a", key)
+
+	// falls back to the first argument
+	key, err = KeyFromCmd("FOO", "bar")
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", key)
+
+	_, err = KeyFromCmd("FOO")
+	assert.NotNil(t, err)
+}
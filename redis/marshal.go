@@ -0,0 +1,117 @@
+package redis
+
+import (
+	"encoding"
+	"math/big"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// TimeFormat, if set, is the layout which time.Time arguments are formatted
+// with, see time.Time's Format, and which Into parses time.Time values with,
+// e.g. time.RFC3339Nano. By default a time.Time is sent as the number of
+// milliseconds since the Unix epoch. It should only be set before any
+// commands are sent.
+var TimeFormat string
+
+var typeOfTime = reflect.TypeOf(time.Time{})
+
+// isMarshaled returns whether the argument is one of the types which
+// marshalArg converts, so that it's sent as a single value rather than being
+// flattened
+func isMarshaled(m interface{}) bool {
+	switch m.(type) {
+	case time.Time, time.Duration, *big.Int, encoding.BinaryMarshaler, encoding.TextMarshaler:
+		return true
+	}
+	return false
+}
+
+// marshalArg converts an argument for which isMarshaled is true into a value
+// which can be written as is: a time.Time into milliseconds since the Unix
+// epoch or a string formatted with TimeFormat, a time.Duration into
+// milliseconds, a *big.Int into its decimal string, and anything else using
+// its MarshalBinary or MarshalText method, in that order of preference. A nil
+// pointer is converted to nil.
+func marshalArg(m interface{}) (interface{}, error) {
+	switch mt := m.(type) {
+	case time.Time:
+		if TimeFormat != "" {
+			return mt.Format(TimeFormat), nil
+		}
+		return mt.Unix()*1000 + int64(mt.Nanosecond())/int64(time.Millisecond), nil
+	case time.Duration:
+		return int64(mt / time.Millisecond), nil
+	}
+
+	if v := reflect.ValueOf(m); v.Kind() == reflect.Ptr && v.IsNil() {
+		return nil, nil
+	}
+	switch mt := m.(type) {
+	case *big.Int:
+		return mt.String(), nil
+	case encoding.BinaryMarshaler:
+		return mt.MarshalBinary()
+	case encoding.TextMarshaler:
+		return mt.MarshalText()
+	}
+	return m, nil
+}
+
+// unmarshalInto is the inverse of marshalArg, for Into. It returns false if
+// the value isn't of a type which marshalArg would have converted.
+func unmarshalInto(v reflect.Value, r *Resp) (bool, error) {
+	if v.Type() == typeOfTime {
+		t, err := parseTime(r)
+		if err == nil {
+			v.Set(reflect.ValueOf(t))
+		}
+		return true, err
+	} else if !v.CanAddr() {
+		return false, nil
+	}
+
+	// big.Int is a TextUnmarshaler of its decimal string
+	switch u := v.Addr().Interface().(type) {
+	case encoding.BinaryUnmarshaler:
+		b, err := respBytes(r)
+		if err != nil {
+			return true, err
+		}
+		return true, u.UnmarshalBinary(b)
+	case encoding.TextUnmarshaler:
+		b, err := respBytes(r)
+		if err != nil {
+			return true, err
+		}
+		return true, u.UnmarshalText(b)
+	}
+	return false, nil
+}
+
+// parseTime parses a time.Time as marshalArg formats it. An integer is always
+// taken to be milliseconds since the Unix epoch, whatever TimeFormat is.
+func parseTime(r *Resp) (time.Time, error) {
+	s, err := respStr(r)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond)), nil
+	}
+	layout := TimeFormat
+	if layout == "" {
+		layout = time.RFC3339Nano
+	}
+	return time.Parse(layout, s)
+}
+
+// respBytes is like Bytes, but also works for an Int
+func respBytes(r *Resp) ([]byte, error) {
+	if _, ok := r.val.(int64); ok {
+		s, err := respStr(r)
+		return []byte(s), err
+	}
+	return r.Bytes()
+}
@@ -0,0 +1,179 @@
+package redis
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"net"
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// point implements encoding.BinaryMarshaler and BinaryUnmarshaler
+type point struct{ X, Y byte }
+
+func (p point) MarshalBinary() ([]byte, error) { return []byte{p.X, p.Y}, nil }
+
+func (p *point) UnmarshalBinary(b []byte) error {
+	if len(b) != 2 {
+		return errors.New("bad point")
+	}
+	p.X, p.Y = b[0], b[1]
+	return nil
+}
+
+type marshaled struct {
+	Created time.Time     `redis:"created"`
+	TTL     time.Duration `redis:"ttl"`
+	Big     *big.Int      `redis:"big"`
+	IP      net.IP        `redis:"ip"`
+	Point   point         `redis:"point"`
+}
+
+// encodeArgs returns the args as they would be sent over the wire
+func encodeArgs(t *T, args ...interface{}) *Resp {
+	buf := new(bytes.Buffer)
+	require.Nil(t, encodeRequest(buf, nil, request{cmd: "CMD", args: args}))
+	r := NewRespReader(buf).Read()
+	require.Nil(t, r.Err)
+	a, err := r.Array()
+	require.Nil(t, err)
+	return NewResp(a[1:])
+}
+
+func TestMarshalArgs(t *T) {
+	created := time.Unix(1500000000, 123*int64(time.Millisecond))
+	r := encodeArgs(t,
+		created, 1500*time.Millisecond, big.NewInt(0).Lsh(big.NewInt(1), 70),
+		net.ParseIP("127.0.0.1"), point{1, 2}, []interface{}{net.ParseIP("::1")},
+	)
+	l, err := r.List()
+	require.Nil(t, err)
+	assert.Equal(t, []string{
+		"1500000000123", "1500", "1180591620717411303424", "127.0.0.1", "\x01\x02", "::1",
+	}, l)
+
+	TimeFormat = time.RFC3339
+	defer func() { TimeFormat = "" }()
+	l, err = encodeArgs(t, created.UTC()).List()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"2017-07-14T02:40:00Z"}, l)
+}
+
+func TestMarshalRoundTrip(t *T) {
+	in := marshaled{
+		Created: time.Unix(1500000000, 123*int64(time.Millisecond)),
+		TTL:     90 * time.Second,
+		Big:     big.NewInt(0).Lsh(big.NewInt(1), 70),
+		IP:      net.ParseIP("10.0.0.1"),
+		Point:   point{3, 4},
+	}
+
+	var c argsCmder
+	require.Nil(t, FlatCmd(&c, "HSET", "k", in).Err)
+	var out marshaled
+	require.Nil(t, encodeArgs(t, c.args[1:]...).Into(&out))
+	assert.True(t, in.Created.Equal(out.Created), "%s != %s", in.Created, out.Created)
+	assert.Equal(t, in.TTL, out.TTL)
+	assert.Equal(t, 0, in.Big.Cmp(out.Big))
+	assert.True(t, in.IP.Equal(out.IP))
+	assert.Equal(t, in.Point, out.Point)
+
+	// times formatted with TimeFormat are read back too
+	TimeFormat = time.RFC3339Nano
+	defer func() { TimeFormat = "" }()
+	require.Nil(t, FlatCmd(&c, "HSET", "k", in).Err)
+	out = marshaled{}
+	require.Nil(t, encodeArgs(t, c.args[1:]...).Into(&out))
+	assert.True(t, in.Created.Equal(out.Created), "%s != %s", in.Created, out.Created)
+}
+
+// badMarshaler always fails to marshal
+type badMarshaler struct{}
+
+var errBadMarshaler = errors.New("can't marshal")
+
+func (badMarshaler) MarshalText() ([]byte, error) { return nil, errBadMarshaler }
+
+func assertMarshalErr(t *T, r *Resp) {
+	assert.Equal(t, errBadMarshaler, r.Err)
+	assert.True(t, r.IsType(AppErr))
+}
+
+func TestMarshalErr(t *T) {
+	buf := bytes.NewBufferString("foo")
+	assert.Equal(t, errBadMarshaler, encodeRequest(buf, nil, request{"SET", []interface{}{"k", badMarshaler{}}}))
+	assert.Equal(t, "foo", buf.String())
+
+	l := echoServer(t)
+	defer l.Close()
+	c, err := DialTimeout("tcp", l.Addr().String(), 5*time.Second)
+	require.Nil(t, err)
+	defer c.Close()
+
+	// nothing of the failed command is sent, so the connection stays usable
+	assertMarshalErr(t, c.Cmd("ECHO", badMarshaler{}))
+	assert.Nil(t, c.LastCritical)
+	assert.Equal(t, "a", mustStr(t, c.Cmd("ECHO", "a")))
+
+	c.PipeAppend("ECHO", "a")
+	c.PipeAppend("ECHO", badMarshaler{})
+	c.PipeAppend("ECHO", "c")
+	assert.Equal(t, "a", mustStr(t, c.PipeResp()))
+	assertMarshalErr(t, c.PipeResp())
+	assert.Equal(t, "c", mustStr(t, c.PipeResp()))
+	assert.Nil(t, c.LastCritical)
+
+	p := NewPipeline(c, PipelineOpts{MaxOutstanding: 3})
+	p.Append("ECHO", "a")
+	p.Append("ECHO", badMarshaler{})
+	p.Append("ECHO", "c")
+	p.Append("ECHO", badMarshaler{})
+	assert.Equal(t, "a", mustStr(t, p.Resp()))
+	assertMarshalErr(t, p.Resp())
+	assert.Equal(t, "c", mustStr(t, p.Resp()))
+	assertMarshalErr(t, p.Resp())
+	assert.Equal(t, ErrPipelineEmpty, p.Resp().Err)
+	assert.Nil(t, c.LastCritical)
+}
+
+func TestMarshalErrPipelining(t *T) {
+	l := echoServer(t)
+	defer l.Close()
+	pc := dialPipeliningFake(t, l, PipeliningOpts{Window: time.Second, Limit: 3})
+	defer pc.Close()
+
+	// the two good commands fill the batch once the bad one is left out
+	args := []interface{}{"a", badMarshaler{}, "c"}
+	rr := make([]*Resp, len(args))
+	var wg sync.WaitGroup
+	for i := range args {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rr[i] = pc.Cmd("ECHO", args[i])
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "d", mustStr(t, pc.Cmd("ECHO", "d")))
+	wg.Wait()
+	assert.Equal(t, "a", mustStr(t, rr[0]))
+	assertMarshalErr(t, rr[1])
+	assert.Equal(t, "c", mustStr(t, rr[2]))
+	assert.Nil(t, pc.Err())
+
+	l = echoServer(t)
+	defer l.Close()
+	ac := dialAsyncFake(t, l)
+	defer ac.Close()
+	fa := ac.CmdAsync("ECHO", "a")
+	fb := ac.CmdAsync("ECHO", badMarshaler{})
+	fc := ac.CmdAsync("ECHO", "c")
+	assert.Equal(t, "a", mustStr(t, fa.Resp()))
+	assertMarshalErr(t, fb.Resp())
+	assert.Equal(t, "c", mustStr(t, fc.Resp()))
+}
@@ -0,0 +1,140 @@
+package redis
+
+import (
+	"bytes"
+	"time"
+)
+
+// PipelineOpts are options which can be passed into NewPipeline. All fields
+// are optional.
+type PipelineOpts struct {
+	// The most commands which are buffered before they're sent. Once this many
+	// have been appended they're all sent and their responses read, before
+	// Append returns. If zero there is no limit.
+	MaxOutstanding int
+
+	// Like MaxOutstanding, but limits the total size in bytes of the buffered
+	// commands, as they'll be sent to redis. If zero there is no limit.
+	MaxOutstandingBytes int
+
+	// If set this is called with every response, in the same order their
+	// commands were appended, as soon as the chunk they're in has been read.
+	// The responses aren't buffered at all in this case, and Resp will never
+	// return any.
+	OnResp func(*Resp)
+}
+
+// Pipeline is like the PipeAppend and PipeResp methods on Client, but can
+// split the commands into chunks so that memory use stays bounded however
+// many commands are appended. Commands are buffered until one of the limits in
+// PipelineOpts is reached, at which point the chunk is sent and its responses
+// read. Together with OnResp this means only one chunk of commands and
+// responses is ever held in memory.
+//
+// A chunk is always completely sent and read before Append, Flush or Resp
+// return, so the Client may be used for other commands in between calls on
+// the Pipeline. Like Client, Pipeline isn't safe to use from multiple
+// go-routines at once.
+type Pipeline struct {
+	c *Client
+	o PipelineOpts
+
+	buf       *bytes.Buffer
+	n         int
+	completed []*Resp
+
+	// the names of the buffered commands, only kept if the Client has a Trace
+	traced []string
+
+	// the errors of buffered commands whose arguments couldn't be marshaled,
+	// indexed like traced, and only kept once there's been one
+	encErrs []error
+}
+
+// NewPipeline returns a Pipeline which sends its commands over the given
+// Client
+func NewPipeline(c *Client, o PipelineOpts) *Pipeline {
+	return &Pipeline{
+		c:   c,
+		o:   o,
+		buf: new(bytes.Buffer),
+	}
+}
+
+// Append adds the given command to the pipeline. If this reaches one of the
+// limits set in PipelineOpts the buffered chunk of commands is sent and its
+// responses read.
+func (p *Pipeline) Append(cmd string, args ...interface{}) {
+	// a command which can't be encoded isn't sent, but still gets a response
+	// in its place, so the rest line up with their commands
+	if err := encodeRequest(p.buf, p.c.writeScratch, request{cmd, args}); err != nil {
+		for len(p.encErrs) < p.n {
+			p.encErrs = append(p.encErrs, nil)
+		}
+		p.encErrs = append(p.encErrs, err)
+	}
+	p.n++
+	if p.c.trace != nil {
+		p.c.traceStart(cmd, args)
+		p.traced = append(p.traced, cmd)
+	}
+	if (p.o.MaxOutstanding > 0 && p.n >= p.o.MaxOutstanding) ||
+		(p.o.MaxOutstandingBytes > 0 && p.buf.Len() >= p.o.MaxOutstandingBytes) {
+		p.Flush()
+	}
+}
+
+// Flush sends any commands which are still buffered and reads their responses
+func (p *Pipeline) Flush() {
+	if p.n == 0 {
+		return
+	}
+	n := p.n
+	p.n = 0
+	traced, encErrs := p.traced, p.encErrs
+	p.traced, p.encErrs = nil, nil
+	var start time.Time
+	if p.c.trace != nil {
+		start = time.Now()
+	}
+	err := p.c.writeBytes(p.buf.Bytes())
+	p.buf.Reset()
+
+	// Unlike PipeResp, if the chunk couldn't be sent every one of its
+	// commands gets the error, so responses still line up with their commands
+	for i := 0; i < n; i++ {
+		var r *Resp
+		if i < len(encErrs) && encErrs[i] != nil {
+			r = NewResp(encErrs[i])
+		} else if err != nil {
+			r = NewRespIOErr(err)
+		} else {
+			r = p.c.readResp(true)
+		}
+		if i < len(traced) {
+			p.c.traceDone(traced[i], r, start)
+		}
+		if p.o.OnResp != nil {
+			p.o.OnResp(r)
+		} else {
+			p.completed = append(p.completed, r)
+		}
+	}
+}
+
+// Resp returns the response for the next command appended to the pipeline,
+// calling Flush first if its chunk hasn't been sent yet. A Resp with an Err of
+// ErrPipelineEmpty is returned if there are no more responses, which is always
+// the case if OnResp is set.
+func (p *Pipeline) Resp() *Resp {
+	if len(p.completed) == 0 {
+		p.Flush()
+	}
+	if len(p.completed) == 0 {
+		return NewResp(ErrPipelineEmpty)
+	}
+	r := p.completed[0]
+	p.completed[0] = nil
+	p.completed = p.completed[1:]
+	return r
+}
@@ -0,0 +1,98 @@
+package redis
+
+import (
+	"net"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipelineChunked(t *T) {
+	c := dial(t)
+	var got []string
+	p := NewPipeline(c, PipelineOpts{
+		MaxOutstanding: 3,
+		OnResp: func(r *Resp) {
+			s, err := r.Str()
+			require.Nil(t, err)
+			got = append(got, s)
+		},
+	})
+
+	for i := 0; i < 10; i++ {
+		p.Append("ECHO", i)
+		assert.Len(t, got, (i+1)/3*3)
+	}
+	p.Flush()
+	assert.Equal(t, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}, got)
+	assert.Equal(t, ErrPipelineEmpty, p.Resp().Err)
+}
+
+// countingServer replies to every command it receives with an Int, counting
+// up from 0. It uses a real listener rather than net.Pipe so that a whole
+// chunk of commands can be written before any replies are read.
+func countingServer(t *T) (net.Listener, *Client) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rr := NewRespReader(conn)
+		for i := 0; ; i++ {
+			if rr.Read().Err != nil {
+				return
+			}
+			NewResp(i).WriteTo(conn)
+		}
+	}()
+
+	c, err := DialTimeout("tcp", l.Addr().String(), 5*time.Second)
+	require.Nil(t, err)
+	return l, c
+}
+
+func TestPipelineLimits(t *T) {
+	l, c := countingServer(t)
+	defer l.Close()
+	defer c.Close()
+
+	// with no limits nothing is sent until Resp is called
+	p := NewPipeline(c, PipelineOpts{})
+	for i := 0; i < 5; i++ {
+		p.Append("PING")
+	}
+	assert.Empty(t, p.completed)
+	for i := 0; i < 5; i++ {
+		n, err := p.Resp().Int()
+		require.Nil(t, err)
+		assert.Equal(t, i, n)
+	}
+	assert.Equal(t, ErrPipelineEmpty, p.Resp().Err)
+
+	// each PING is 14 bytes encoded, so a chunk is sent every 3 commands.
+	// Responses are made available as soon as their chunk completes.
+	p = NewPipeline(c, PipelineOpts{MaxOutstandingBytes: 40})
+	for i := 0; i < 7; i++ {
+		p.Append("PING")
+		assert.Len(t, p.completed, (i+1)/3*3)
+	}
+	for i := 5; i < 12; i++ {
+		n, err := p.Resp().Int()
+		require.Nil(t, err)
+		assert.Equal(t, i, n)
+	}
+
+	// once the connection is gone every command in the chunk gets an error
+	c.Close()
+	p = NewPipeline(c, PipelineOpts{})
+	p.Append("PING")
+	p.Append("PING")
+	assert.True(t, p.Resp().IsType(IOErr))
+	assert.True(t, p.Resp().IsType(IOErr))
+	assert.Equal(t, ErrPipelineEmpty, p.Resp().Err)
+}
@@ -0,0 +1,298 @@
+package redis
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/log"
+)
+
+var (
+	// ErrPipeliningUnsafe is the error on the Resp of a command which was
+	// rejected by PipeliningClient's Cmd because it changes the state of the
+	// connection for the commands after it, e.g. MULTI or SUBSCRIBE. Such
+	// commands can be used within PipeliningClient's Do.
+	ErrPipeliningUnsafe = errors.New("transaction and subscribe commands must be used within PipeliningClient's Do")
+
+	// ErrPipeliningClosed is the error on the Resp of a command which was
+	// sent after Close was called on the PipeliningClient, or which was still
+	// waiting to be sent when it was
+	ErrPipeliningClosed = errors.New("pipelining client is closed")
+)
+
+// PipeliningOpts are options which can be passed into NewPipeliningClient. All
+// fields are optional.
+type PipeliningOpts struct {
+	// How long a command waits for others to be sent with it. Defaults to
+	// 150µs.
+	Window time.Duration
+
+	// The most commands which are sent together. Once this many are waiting
+	// they're sent straight away, without waiting for the rest of the Window.
+	// Defaults to 128.
+	Limit int
+}
+
+// PipeliningClient wraps a Client so that commands from multiple go-routines
+// share the connection, by being pipelined together. A command waits up to
+// the Window for others to arrive, then all of them are written at once and
+// their replies read and handed back to each caller. This gets the throughput
+// of many connections out of one when there are lots of small concurrent
+// commands, at the cost of up to the Window of extra latency for each. All
+// methods may be called from multiple go-routines at once.
+//
+// If there's an error writing or reading then every command in the batch, and
+// every later one, gets that error as an IOErr. The PipeliningClient can't be
+// used again after that, and should be closed.
+//
+// Commands which block the connection (e.g. BLPOP or WAIT) are sent on their
+// own, after the batch in flight, and hold up every other command until they
+// return. Commands which change the state of the connection for those after
+// them (e.g. MULTI or SUBSCRIBE) are rejected with ErrPipeliningUnsafe, and
+// must be used within Do.
+type PipeliningClient struct {
+	c *Client
+	o PipeliningOpts
+
+	// l protects batch, err and scratch
+	l       sync.Mutex
+	batch   *pipeBatch
+	err     error
+	scratch []byte
+
+	// connL is held while the connection is in use, by a batch being written
+	// and its replies read, a blocking command or Do
+	connL sync.Mutex
+}
+
+// pipeBatch is a set of commands which are written together
+type pipeBatch struct {
+	buf     bytes.Buffer
+	futures []*Future
+	timer   *time.Timer
+	sent    bool
+}
+
+// NewPipeliningClient returns a PipeliningClient wrapping the given Client.
+// The Client shouldn't be used directly from then on, except from within Do.
+// The Client's timeout, if any, applies to writing each batch and to reading
+// each of its replies.
+func NewPipeliningClient(c *Client, o PipeliningOpts) *PipeliningClient {
+	if o.Window == 0 {
+		o.Window = 150 * time.Microsecond
+	}
+	if o.Limit == 0 {
+		o.Limit = 128
+	}
+	return &PipeliningClient{
+		c:       c,
+		o:       o,
+		scratch: make([]byte, 0, 128),
+	}
+}
+
+var _ Cmder = &PipeliningClient{}
+
+// pipeliningUnsafeCmds are the commands which PipeliningClient's Cmd rejects
+var pipeliningUnsafeCmds = map[string]bool{
+	"MULTI": true, "EXEC": true, "DISCARD": true, "WATCH": true, "UNWATCH": true,
+	"MONITOR": true, "SYNC": true, "PSYNC": true,
+	"SUBSCRIBE": true, "PSUBSCRIBE": true, "SSUBSCRIBE": true,
+	"UNSUBSCRIBE": true, "PUNSUBSCRIBE": true, "SUNSUBSCRIBE": true,
+}
+
+// IsPipelinable returns whether the given command may be pipelined together
+// with those of other callers by PipeliningClient, i.e. it neither blocks the
+// connection nor changes its state for the commands after it
+func IsPipelinable(cmd string, args ...interface{}) bool {
+	return !pipeliningUnsafeCmds[strings.ToUpper(cmd)] && !isAsyncBlocking(cmd, args)
+}
+
+// Cmd calls the given Redis command, pipelining it with any others which are
+// called within the Window, and returns its reply
+func (pc *PipeliningClient) Cmd(cmd string, args ...interface{}) *Resp {
+	if pipeliningUnsafeCmds[strings.ToUpper(cmd)] {
+		return NewResp(ErrPipeliningUnsafe)
+	} else if isAsyncBlocking(cmd, args) {
+		return pc.cmdAlone(cmd, args)
+	}
+
+	f := &Future{done: make(chan struct{})}
+	if pc.c.trace != nil {
+		f.cmd, f.start = cmd, pc.c.traceStart(cmd, args)
+	}
+	pc.l.Lock()
+	if pc.err != nil {
+		err := pc.err
+		pc.l.Unlock()
+		return NewRespIOErr(err)
+	}
+	b := pc.batch
+	if b == nil {
+		b = new(pipeBatch)
+		pc.batch = b
+		b.timer = time.AfterFunc(pc.o.Window, func() { pc.send(b) })
+	}
+	if err := encodeRequest(&b.buf, pc.scratch, request{cmd, args}); err != nil {
+		pc.l.Unlock()
+		r := NewResp(err)
+		pc.resolve(f, r)
+		return r
+	}
+	b.futures = append(b.futures, f)
+	full := len(b.futures) >= pc.o.Limit
+	pc.l.Unlock()
+
+	if full {
+		b.timer.Stop()
+		pc.send(b)
+	}
+	return f.Resp()
+}
+
+// send writes the batch and reads its replies, unless that's already been
+// done. It's called by the batch's timer, or by the command which filled the
+// batch, whichever happens first.
+func (pc *PipeliningClient) send(b *pipeBatch) {
+	pc.l.Lock()
+	if b.sent {
+		pc.l.Unlock()
+		return
+	}
+	b.sent = true
+	if pc.batch == b {
+		pc.batch = nil
+	}
+	err := pc.err
+	pc.l.Unlock()
+
+	if err != nil {
+		pc.fail(b, err)
+		return
+	}
+
+	pc.connL.Lock()
+	defer pc.connL.Unlock()
+	if pc.c.trace != nil {
+		start := time.Now()
+		for _, f := range b.futures {
+			f.start = start
+		}
+	}
+
+	// Like Pipeline, if the batch couldn't be sent every one of its commands
+	// gets the error, and once a reply couldn't be read none of the rest can be
+	// either
+	if err := pc.c.writeBytes(b.buf.Bytes()); err != nil {
+		pc.poison(err)
+		pc.fail(b, err)
+		return
+	}
+	for i, f := range b.futures {
+		r := pc.c.readResp(true)
+		if r.IsType(IOErr) {
+			pc.poison(r.Err)
+			b.futures = b.futures[i:]
+			pc.fail(b, r.Err)
+			return
+		}
+		pc.resolve(f, r)
+	}
+}
+
+// fail resolves every Future in the batch with the given error
+func (pc *PipeliningClient) fail(b *pipeBatch, err error) {
+	for _, f := range b.futures {
+		pc.resolve(f, NewRespIOErr(err))
+	}
+}
+
+// resolve resolves the Future of a command in a batch, with the Trace being
+// told first
+func (pc *PipeliningClient) resolve(f *Future, r *Resp) {
+	if pc.c.trace != nil {
+		pc.c.traceDone(f.cmd, r, f.start)
+	}
+	f.resolve(r)
+}
+
+// poison records the error which will be returned for every command from now
+// on. The Client will already have closed the connection.
+func (pc *PipeliningClient) poison(err error) {
+	pc.l.Lock()
+	defer pc.l.Unlock()
+	if pc.err != nil {
+		return
+	}
+	pc.err = err
+	pc.c.logger.Log(log.Warn, "connection failed", log.KV{
+		log.KeyOperation: "pipelining",
+		log.KeyErr:       err,
+	})
+}
+
+// cmdAlone calls a command which blocks the connection on its own
+func (pc *PipeliningClient) cmdAlone(cmd string, args []interface{}) *Resp {
+	pc.connL.Lock()
+	defer pc.connL.Unlock()
+	if err := pc.Err(); err != nil {
+		return NewRespIOErr(err)
+	}
+	r := pc.c.Cmd(cmd, args...)
+	if r.IsType(IOErr) {
+		pc.poison(r.Err)
+	}
+	return r
+}
+
+// Do calls fn with the underlying Client, once the batch in flight, if any, has
+// been read, and with nothing else using the Client until fn returns. This is
+// how transactions and other commands rejected by Cmd are done. The Client
+// must not be used once fn has returned. As with SyncClient's Do, any commands
+// left in its pipeline are cleared when fn returns.
+func (pc *PipeliningClient) Do(fn func(*Client)) {
+	pc.connL.Lock()
+	defer pc.connL.Unlock()
+	defer pc.c.PipeClear()
+	fn(pc.c)
+	if pc.c.LastCritical != nil {
+		pc.poison(pc.c.LastCritical)
+	}
+}
+
+// Err returns the error which the connection failed with, or
+// ErrPipeliningClosed once Close has been called. Once this isn't nil every
+// command returns it.
+func (pc *PipeliningClient) Err() error {
+	pc.l.Lock()
+	defer pc.l.Unlock()
+	return pc.err
+}
+
+// Close closes the connection. Commands which were waiting to be sent get
+// ErrPipeliningClosed, and any which are in flight get the IOErr from the
+// connection being closed under them.
+func (pc *PipeliningClient) Close() error {
+	pc.l.Lock()
+	if pc.err == ErrPipeliningClosed {
+		pc.l.Unlock()
+		return nil
+	}
+	// if the connection failed the Client has already closed it
+	failed := pc.err != nil
+	pc.err = ErrPipeliningClosed
+	b := pc.batch
+	pc.l.Unlock()
+
+	if b != nil {
+		b.timer.Stop()
+		pc.send(b)
+	}
+	if failed {
+		return nil
+	}
+	return pc.c.Close()
+}
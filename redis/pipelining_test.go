@@ -0,0 +1,160 @@
+package redis
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func dialPipeliningFake(t *T, l net.Listener, o PipeliningOpts) *PipeliningClient {
+	c, err := DialTimeout("tcp", l.Addr().String(), 5*time.Second)
+	require.Nil(t, err)
+	return NewPipeliningClient(c, o)
+}
+
+func TestPipeliningClient(t *T) {
+	l := echoServer(t)
+	defer l.Close()
+	pc := dialPipeliningFake(t, l, PipeliningOpts{Window: time.Millisecond, Limit: 8})
+	defer pc.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s := strconv.Itoa(i*1000 + j)
+				got, err := pc.Cmd("ECHO", s).Str()
+				assert.Nil(t, err)
+				assert.Equal(t, s, got)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// blocking commands are sent on their own, and those which change the
+	// state of the connection have to be done within Do
+	s, err := pc.Cmd("BLPOP", "foo", 0).Str()
+	require.Nil(t, err)
+	assert.Equal(t, "0", s)
+	for _, cmd := range []string{"MULTI", "exec", "SUBSCRIBE"} {
+		r := pc.Cmd(cmd, "foo")
+		assert.Equal(t, ErrPipeliningUnsafe, r.Err)
+		assert.True(t, r.IsType(AppErr))
+	}
+	pc.Do(func(c *Client) {
+		s, err := c.Cmd("MULTI", "foo").Str()
+		assert.Nil(t, err)
+		assert.Equal(t, "foo", s)
+	})
+
+	require.Nil(t, pc.Close())
+	assert.Equal(t, ErrPipeliningClosed, pc.Cmd("ECHO", "foo").Err)
+	assert.Nil(t, pc.Close())
+}
+
+func TestPipeliningClientLimit(t *T) {
+	l := echoServer(t)
+	defer l.Close()
+
+	// with a Window this long the commands are only sent because there are
+	// enough of them
+	pc := dialPipeliningFake(t, l, PipeliningOpts{Window: time.Hour, Limit: 4})
+	defer pc.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got, err := pc.Cmd("ECHO", strconv.Itoa(i)).Str()
+			assert.Nil(t, err)
+			assert.Equal(t, strconv.Itoa(i), got)
+		}(i)
+	}
+	wg.Wait()
+
+	// and a command which is never joined by others is still sent once the
+	// Window is up
+	l2 := echoServer(t)
+	defer l2.Close()
+	pc = dialPipeliningFake(t, l2, PipeliningOpts{Window: 20 * time.Millisecond, Limit: 4})
+	defer pc.Close()
+	start := time.Now()
+	got, err := pc.Cmd("ECHO", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "foo", got)
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+}
+
+func TestPipeliningClientConnErr(t *T) {
+	// the server reads 3 commands, replies to the first, then hangs up
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		rr := NewRespReader(conn)
+		for i := 0; i < 3; i++ {
+			rr.Read()
+		}
+		NewResp("OK").WriteTo(conn)
+		conn.Close()
+	}()
+
+	rec := new(log.Recorder)
+	c, err := DialCtx(context.Background(), "tcp", l.Addr().String(), DialOpts{
+		Timeout: 5 * time.Second,
+		Logger:  rec,
+	})
+	require.Nil(t, err)
+	pc := NewPipeliningClient(c, PipeliningOpts{Window: time.Hour, Limit: 3})
+	defer pc.Close()
+
+	// every command in the batch after the first gets the error
+	rr := make([]*Resp, 3)
+	var wg sync.WaitGroup
+	for i := range rr {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rr[i] = pc.Cmd("ECHO", strconv.Itoa(i))
+		}(i)
+	}
+	wg.Wait()
+	var oks, errs int
+	var ioErr error
+	for _, r := range rr {
+		if r.IsType(IOErr) {
+			errs++
+			ioErr = r.Err
+		} else if r.Err == nil {
+			oks++
+		}
+	}
+	assert.Equal(t, 1, oks)
+	assert.Equal(t, 2, errs)
+
+	// the client is poisoned from then on
+	r := pc.Cmd("ECHO", "foo")
+	assert.True(t, r.IsType(IOErr))
+	assert.Equal(t, ioErr, r.Err)
+	assert.Equal(t, ioErr, pc.Err())
+
+	ee := rec.Entries("")
+	require.Len(t, ee, 1)
+	assert.Equal(t, "connection failed", ee[0].Msg)
+	assert.Equal(t, log.Warn, ee[0].Level)
+	assert.Equal(t, "pipelining", ee[0].KV[log.KeyOperation])
+	assert.Equal(t, ioErr, ee[0].KV[log.KeyErr])
+}
@@ -0,0 +1,426 @@
+// Package redistest provides helpers for testing code which uses radix.v2,
+// without needing to have a redis instance running.
+//
+// The main helper is Mock, which can be used anywhere a Cmd method is
+// expected (e.g. a redis.Cmder). It has two modes. A scripted Mock, created by
+// NewMock, expects a specific sequence of commands and replies to each with a
+// given Resp:
+//
+//	m := redistest.NewMock(t)
+//	m.Expect("GET", "foo").Return(redistest.Str("bar"))
+//	m.Expect("INCR", "count").Return(redistest.Int(1))
+//
+//	codeUnderTest(m)
+//	m.Done() // fails the test if any expected commands weren't called
+//
+// A functional Mock, created by NewFunctionalMock, implements a handful of
+// the most common commands over an in-memory map, for tests which only care
+// about the resulting state:
+//
+//	m := redistest.NewFunctionalMock()
+//	m.Cmd("SET", "foo", "bar", "EX", 10)
+//	m.Advance(11 * time.Second)
+//	m.Cmd("GET", "foo") // Nil Resp, it has expired
+//
+// For golden-file tests, a Recorder wraps a real client and writes every
+// command and reply to a file, which a Replayer can later serve back without a
+// redis instance:
+//
+//	// once, against a real redis
+//	rec := redistest.NewRecorder(client, f)
+//	codeUnderTest(rec)
+//
+//	// in the test
+//	rep := redistest.NewReplayer(t, f)
+//	codeUnderTest(rep)
+//	rep.Done()
+//
+// For protocol errors, ReplayWireTap feeds the raw bytes dumped by a
+// redis.DialOpts' WireTap back through the reply parser, so a failure seen
+// once can be reproduced in a test:
+//
+//	replies, err := redistest.ReplayWireTap(f, redis.RespReaderOpts{})
+package redistest
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// ErrorReporter is the subset of testing.TB which is used by Mock
+type ErrorReporter interface {
+	Errorf(format string, args ...interface{})
+}
+
+// ErrUnexpected is the error of the IOErr Resp which a scripted Mock returns
+// when it's given a command which it wasn't expecting
+var ErrUnexpected = errors.New("unexpected command")
+
+// Expectation is a single command which a scripted Mock expects to be called,
+// see Mock's Expect method
+type Expectation struct {
+	args []string
+	r    *redis.Resp
+}
+
+// Return sets the Resp which will be returned when the expected command is
+// called. If it's never set an OK Resp is returned.
+func (e *Expectation) Return(r *redis.Resp) {
+	e.r = r
+}
+
+// Mock is a fake redis connection, see the package docs for how it's used. A
+// Mock may be used from multiple go-routines at once.
+type Mock struct {
+	t ErrorReporter
+
+	l       sync.Mutex
+	expects []*Expectation
+
+	functional bool
+	offset     time.Duration
+	keys       map[string]*mockKey
+}
+
+var _ redis.Cmder = &Mock{}
+
+// NewMock returns a scripted Mock. Any failures in it will be reported to the
+// given ErrorReporter, which will usually be a *testing.T.
+func NewMock(t ErrorReporter) *Mock {
+	return &Mock{t: t}
+}
+
+// NewFunctionalMock returns a functional Mock, whose data starts off empty
+func NewFunctionalMock() *Mock {
+	return &Mock{
+		functional: true,
+		keys:       map[string]*mockKey{},
+	}
+}
+
+// flattenArgs turns a command and its arguments into the strings which would
+// be sent to redis
+func flattenArgs(cmd string, args []interface{}) []string {
+	l, err := redis.NewRespFlattenedStrings(append([]interface{}{cmd}, args...)).List()
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// Expect adds a command (with its arguments) to the sequence which a scripted
+// Mock expects to be called. Arguments are compared by how they would be sent
+// to redis, so Expect("EXPIRE", "foo", 10) matches Cmd("EXPIRE", "foo", "10").
+// The command name is compared case-insensitively.
+func (m *Mock) Expect(cmd string, args ...interface{}) *Expectation {
+	e := &Expectation{args: flattenArgs(cmd, args), r: redis.NewRespSimple("OK")}
+	m.l.Lock()
+	defer m.l.Unlock()
+	m.expects = append(m.expects, e)
+	return e
+}
+
+// Done reports an error for each expected command which hasn't
+// been called
+func (m *Mock) Done() {
+	m.l.Lock()
+	defer m.l.Unlock()
+	for _, e := range m.expects {
+		m.t.Errorf("expected command never called: %s", strings.Join(e.args, " "))
+	}
+	m.expects = nil
+}
+
+// Cmd implements the method used by radix.v2's clients. For a scripted Mock
+// the command must be the next which was expected, otherwise an error is
+// reported and an IOErr with ErrUnexpected is returned.
+func (m *Mock) Cmd(cmd string, args ...interface{}) *redis.Resp {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	argsStr := flattenArgs(cmd, args)
+	if m.functional {
+		return m.functionalCmd(strings.ToUpper(argsStr[0]), argsStr[1:])
+	}
+
+	if len(m.expects) == 0 {
+		m.t.Errorf("unexpected command: %s", strings.Join(argsStr, " "))
+		return redis.NewRespIOErr(ErrUnexpected)
+	}
+
+	e := m.expects[0]
+	if !argsEqual(e.args, argsStr) {
+		m.t.Errorf(
+			"unexpected command:\n\texpected: %s\n\tgot:      %s",
+			strings.Join(e.args, " "), strings.Join(argsStr, " "),
+		)
+		return redis.NewRespIOErr(ErrUnexpected)
+	}
+	m.expects = m.expects[1:]
+	return e.r
+}
+
+func argsEqual(a, b []string) bool {
+	return argsDiff(a, b) == ""
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type mockKey struct {
+	str      string
+	hash     map[string]string
+	expireAt time.Time
+}
+
+// Advance moves a functional Mock's clock forward by the given duration, so
+// keys with a TTL can be expired without waiting
+func (m *Mock) Advance(d time.Duration) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	m.offset += d
+}
+
+func (m *Mock) now() time.Time {
+	return time.Now().Add(m.offset)
+}
+
+// get returns the key, or nil if it doesn't exist or has expired
+func (m *Mock) get(key string) *mockKey {
+	k, ok := m.keys[key]
+	if !ok {
+		return nil
+	} else if !k.expireAt.IsZero() && !m.now().Before(k.expireAt) {
+		delete(m.keys, key)
+		return nil
+	}
+	return k
+}
+
+var (
+	errWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errSyntax    = errors.New("ERR syntax error")
+	errNotInt    = errors.New("ERR value is not an integer or out of range")
+)
+
+func errWrongArgs(cmd string) *redis.Resp {
+	return redis.NewResp(fmt.Errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+}
+
+func (m *Mock) functionalCmd(cmd string, args []string) *redis.Resp {
+	minArgs := map[string]int{
+		"GET": 1, "SET": 2, "DEL": 1, "EXISTS": 1, "EXPIRE": 2, "PEXPIRE": 2,
+		"TTL": 1, "PTTL": 1, "INCR": 1, "INCRBY": 2, "HSET": 3, "HGET": 2,
+		"HDEL": 2, "HGETALL": 1,
+	}
+	n, ok := minArgs[cmd]
+	if !ok {
+		return redis.NewResp(fmt.Errorf("ERR unknown command '%s'", cmd))
+	} else if len(args) < n {
+		return errWrongArgs(cmd)
+	}
+
+	switch cmd {
+	case "GET":
+		k := m.get(args[0])
+		if k == nil {
+			return redis.NewResp(nil)
+		} else if k.hash != nil {
+			return redis.NewResp(errWrongType)
+		}
+		return redis.NewResp(k.str)
+
+	case "SET":
+		return m.set(args)
+
+	case "DEL", "EXISTS":
+		var count int64
+		for _, key := range args {
+			if m.get(key) != nil {
+				count++
+				if cmd == "DEL" {
+					delete(m.keys, key)
+				}
+			}
+		}
+		return redis.NewResp(count)
+
+	case "EXPIRE", "PEXPIRE":
+		i, err := parseInt(args[1])
+		if err != nil {
+			return redis.NewResp(err)
+		}
+		unit := time.Second
+		if cmd == "PEXPIRE" {
+			unit = time.Millisecond
+		}
+		k := m.get(args[0])
+		if k == nil {
+			return redis.NewResp(0)
+		}
+		k.expireAt = m.now().Add(time.Duration(i) * unit)
+		return redis.NewResp(1)
+
+	case "TTL", "PTTL":
+		k := m.get(args[0])
+		if k == nil {
+			return redis.NewResp(-2)
+		} else if k.expireAt.IsZero() {
+			return redis.NewResp(-1)
+		}
+		unit := time.Second
+		if cmd == "PTTL" {
+			unit = time.Millisecond
+		}
+		return redis.NewResp(int64(k.expireAt.Sub(m.now()) / unit))
+
+	case "INCR", "INCRBY":
+		by := int64(1)
+		if cmd == "INCRBY" {
+			var err error
+			if by, err = parseInt(args[1]); err != nil {
+				return redis.NewResp(err)
+			}
+		}
+		k := m.get(args[0])
+		if k == nil {
+			k = &mockKey{str: "0"}
+			m.keys[args[0]] = k
+		} else if k.hash != nil {
+			return redis.NewResp(errWrongType)
+		}
+		i, err := parseInt(k.str)
+		if err != nil {
+			return redis.NewResp(err)
+		}
+		i += by
+		k.str = strconv.FormatInt(i, 10)
+		return redis.NewResp(i)
+
+	case "HSET":
+		if len(args)%2 != 1 {
+			return errWrongArgs(cmd)
+		}
+		k := m.get(args[0])
+		if k == nil {
+			k = &mockKey{hash: map[string]string{}}
+			m.keys[args[0]] = k
+		} else if k.hash == nil {
+			return redis.NewResp(errWrongType)
+		}
+		var added int64
+		for i := 1; i < len(args); i += 2 {
+			if _, ok := k.hash[args[i]]; !ok {
+				added++
+			}
+			k.hash[args[i]] = args[i+1]
+		}
+		return redis.NewResp(added)
+
+	case "HGET":
+		k := m.get(args[0])
+		if k == nil {
+			return redis.NewResp(nil)
+		} else if k.hash == nil {
+			return redis.NewResp(errWrongType)
+		}
+		v, ok := k.hash[args[1]]
+		if !ok {
+			return redis.NewResp(nil)
+		}
+		return redis.NewResp(v)
+
+	case "HDEL":
+		k := m.get(args[0])
+		if k == nil {
+			return redis.NewResp(0)
+		} else if k.hash == nil {
+			return redis.NewResp(errWrongType)
+		}
+		var removed int64
+		for _, field := range args[1:] {
+			if _, ok := k.hash[field]; ok {
+				removed++
+				delete(k.hash, field)
+			}
+		}
+		if len(k.hash) == 0 {
+			delete(m.keys, args[0])
+		}
+		return redis.NewResp(removed)
+
+	case "HGETALL":
+		k := m.get(args[0])
+		if k == nil {
+			return redis.NewResp([]string{})
+		} else if k.hash == nil {
+			return redis.NewResp(errWrongType)
+		}
+		l := make([]string, 0, len(k.hash)*2)
+		for field, v := range k.hash {
+			l = append(l, field, v)
+		}
+		return redis.NewResp(l)
+	}
+
+	// unreachable, all commands in minArgs are handled above
+	panic("unhandled command " + cmd)
+}
+
+func (m *Mock) set(args []string) *redis.Resp {
+	key, val := args[0], args[1]
+	var nx, xx bool
+	var ttl time.Duration
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "EX", "PX":
+			if i+1 >= len(args) {
+				return redis.NewResp(errSyntax)
+			}
+			n, err := parseInt(args[i+1])
+			if err != nil {
+				return redis.NewResp(err)
+			}
+			unit := time.Second
+			if strings.ToUpper(args[i]) == "PX" {
+				unit = time.Millisecond
+			}
+			ttl = time.Duration(n) * unit
+			i++
+		default:
+			return redis.NewResp(errSyntax)
+		}
+	}
+	if nx && xx {
+		return redis.NewResp(errSyntax)
+	}
+
+	exists := m.get(key) != nil
+	if (nx && exists) || (xx && !exists) {
+		return redis.NewResp(nil)
+	}
+
+	k := &mockKey{str: val}
+	if ttl > 0 {
+		k.expireAt = m.now().Add(ttl)
+	}
+	m.keys[key] = k
+	return redis.NewRespSimple("OK")
+}
+
+func parseInt(s string) (int64, error) {
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errNotInt
+	}
+	return i, nil
+}
@@ -0,0 +1,130 @@
+package redistest
+
+import (
+	"fmt"
+	. "testing"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordT struct {
+	errs []string
+}
+
+func (rt *recordT) Errorf(format string, args ...interface{}) {
+	rt.errs = append(rt.errs, fmt.Sprintf(format, args...))
+}
+
+func TestMockScripted(t *T) {
+	rt := new(recordT)
+	m := NewMock(rt)
+	m.Expect("GET", "foo").Return(Str("bar"))
+	m.Expect("EXPIRE", "foo", 10)
+	m.Expect("HGETALL", "h").Return(Array("a", "1"))
+
+	s, err := m.Cmd("GET", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "bar", s)
+
+	// arguments are compared by their string form, and the command
+	// case-insensitively
+	s, err = m.Cmd("expire", "foo", "10").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "OK", s)
+	assert.Empty(t, rt.errs)
+
+	r := m.Cmd("HGETALL", "other")
+	assert.True(t, r.IsType(redis.IOErr))
+	assert.Equal(t, ErrUnexpected, r.Err)
+	assert.Len(t, rt.errs, 1)
+
+	m.Done()
+	assert.Len(t, rt.errs, 2)
+
+	m.Cmd("GET", "foo")
+	assert.Len(t, rt.errs, 3)
+}
+
+func TestRespHelpers(t *T) {
+	assert.True(t, Str("a").IsType(redis.Str))
+	assert.True(t, Simple("a").IsType(redis.SimpleStr))
+	assert.True(t, Int(1).IsType(redis.Int))
+	assert.True(t, Nil().IsType(redis.Nil))
+	assert.True(t, Err("ERR bad").IsType(redis.AppErr))
+	assert.True(t, IOErr(ErrUnexpected).IsType(redis.IOErr))
+
+	arr, err := Array("a", 1, Nil(), Array()).Array()
+	require.Nil(t, err)
+	require.Len(t, arr, 4)
+	assert.True(t, arr[2].IsType(redis.Nil))
+	inner, err := arr[3].Array()
+	require.Nil(t, err)
+	assert.Empty(t, inner)
+}
+
+func TestMockFunctional(t *T) {
+	m := NewFunctionalMock()
+
+	assert.True(t, m.Cmd("GET", "foo").IsType(redis.Nil))
+	require.Nil(t, m.Cmd("SET", "foo", "bar").Err)
+	s, err := m.Cmd("GET", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "bar", s)
+
+	assert.True(t, m.Cmd("SET", "foo", "baz", "NX").IsType(redis.Nil))
+	assert.True(t, m.Cmd("SET", "dne", "baz", "XX").IsType(redis.Nil))
+
+	i, err := m.Cmd("INCR", "count").Int()
+	require.Nil(t, err)
+	assert.Equal(t, 1, i)
+	i, err = m.Cmd("INCRBY", "count", 5).Int()
+	require.Nil(t, err)
+	assert.Equal(t, 6, i)
+	assert.NotNil(t, m.Cmd("INCR", "foo").Err)
+
+	i, err = m.Cmd("HSET", "h", "a", "1", "b", "2").Int()
+	require.Nil(t, err)
+	assert.Equal(t, 2, i)
+	h, err := m.Cmd("HGETALL", "h").Map()
+	require.Nil(t, err)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, h)
+	assert.True(t, m.Cmd("GET", "h").IsType(redis.AppErr))
+	assert.True(t, m.Cmd("HGET", "foo", "a").IsType(redis.AppErr))
+
+	i, err = m.Cmd("DEL", "foo", "h", "dne").Int()
+	require.Nil(t, err)
+	assert.Equal(t, 2, i)
+
+	assert.True(t, m.Cmd("FLUSHALL").IsType(redis.AppErr))
+	assert.True(t, m.Cmd("GET").IsType(redis.AppErr))
+}
+
+func TestMockFunctionalTTL(t *T) {
+	m := NewFunctionalMock()
+	require.Nil(t, m.Cmd("SET", "foo", "bar", "EX", 10).Err)
+	require.Nil(t, m.Cmd("SET", "baz", "buz").Err)
+
+	ttl, err := m.Cmd("TTL", "foo").Int()
+	require.Nil(t, err)
+	assert.True(t, ttl > 8 && ttl <= 10)
+	ttl, err = m.Cmd("TTL", "baz").Int()
+	require.Nil(t, err)
+	assert.Equal(t, -1, ttl)
+
+	i, err := m.Cmd("EXPIRE", "baz", 5).Int()
+	require.Nil(t, err)
+	assert.Equal(t, 1, i)
+
+	m.Advance(6 * time.Second)
+	assert.True(t, m.Cmd("GET", "baz").IsType(redis.Nil))
+	assert.False(t, m.Cmd("GET", "foo").IsType(redis.Nil))
+
+	m.Advance(5 * time.Second)
+	assert.True(t, m.Cmd("GET", "foo").IsType(redis.Nil))
+	ttl, err = m.Cmd("TTL", "foo").Int()
+	require.Nil(t, err)
+	assert.Equal(t, -2, ttl)
+}
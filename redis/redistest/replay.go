@@ -0,0 +1,220 @@
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// Each recorded call is written as three resp messages: a simple string tag
+// saying how to interpret the reply, the command as an array of bulk strings,
+// and then the reply itself
+const (
+	tagResp  = "RESP"
+	tagIOErr = "IOERR"
+)
+
+// Recorder wraps a redis.Cmder, passing every command through to it and writing
+// the command and its reply to an io.Writer. The written data can be replayed
+// later using a Replayer, so that tests which were run against a real redis
+// instance once can be run again without one. A Recorder may be used from
+// multiple go-routines at once, although the order of commands will then
+// depend on timing.
+type Recorder struct {
+	c redis.Cmder
+
+	l   sync.Mutex
+	w   io.Writer
+	err error
+}
+
+// NewRecorder returns a Recorder which passes commands through to the given
+// redis.Cmder, and records them to the given io.Writer
+func NewRecorder(c redis.Cmder, w io.Writer) *Recorder {
+	return &Recorder{c: c, w: w}
+}
+
+// Cmd calls the command on the underlying redis.Cmder, records it, and returns
+// its reply
+func (rec *Recorder) Cmd(cmd string, args ...interface{}) *redis.Resp {
+	argsStr := flattenArgs(cmd, args)
+	r := rec.c.Cmd(cmd, args...)
+
+	rec.l.Lock()
+	defer rec.l.Unlock()
+	if rec.err != nil {
+		return r
+	}
+
+	tag := redis.NewRespSimple(tagResp)
+	reply := r
+	if r.IsType(redis.IOErr) {
+		tag = redis.NewRespSimple(tagIOErr)
+		reply = redis.NewResp(r.Err)
+	}
+	for _, m := range []*redis.Resp{tag, redis.NewResp(argsStr), reply} {
+		if _, err := m.WriteTo(rec.w); err != nil {
+			rec.err = err
+			break
+		}
+	}
+	return r
+}
+
+// Err returns the first error encountered while writing to the io.Writer, if
+// any. Once an error is encountered nothing more is recorded.
+func (rec *Recorder) Err() error {
+	rec.l.Lock()
+	defer rec.l.Unlock()
+	return rec.err
+}
+
+var (
+	_ redis.Cmder = &Recorder{}
+	_ redis.Cmder = &Replayer{}
+)
+
+type recordedCmd struct {
+	args []string
+	r    *redis.Resp
+}
+
+// Replayer replays the commands and replies recorded by a Recorder. Commands
+// must be called on a Replayer in the same order they were recorded, otherwise
+// the difference is reported and an IOErr with ErrUnexpected is returned. A
+// Replayer may be used from multiple go-routines at once.
+type Replayer struct {
+	t  ErrorReporter
+	rr *redis.RespReader
+
+	// Normalize, if set, is called on both the recorded and the actual
+	// command (the command name followed by its arguments) before they're
+	// compared. This can be used to ignore parts of commands which change on
+	// every run, e.g. randomly generated keys. The returned slice is only used
+	// for comparison.
+	Normalize func(args []string) []string
+
+	l    sync.Mutex
+	done bool
+}
+
+// NewReplayer returns a Replayer which reads recorded commands from the given
+// io.Reader. Any failures will be reported to the given ErrorReporter, which
+// will usually be a *testing.T.
+func NewReplayer(t ErrorReporter, r io.Reader) *Replayer {
+	return &Replayer{t: t, rr: redis.NewRespReader(bufio.NewReader(r))}
+}
+
+// next returns the next recorded command, or nil if there are none left
+func (rep *Replayer) next() (*recordedCmd, error) {
+	if rep.done {
+		return nil, nil
+	}
+
+	tagR := rep.rr.Read()
+	if tagR.IsType(redis.IOErr) {
+		if tagR.Err == io.EOF {
+			rep.done = true
+			return nil, nil
+		}
+		return nil, tagR.Err
+	}
+	tag, err := tagR.Str()
+	if err != nil {
+		return nil, err
+	}
+
+	argsR := rep.rr.Read()
+	if argsR.IsType(redis.IOErr) {
+		return nil, argsR.Err
+	}
+	args, err := argsR.List()
+	if err != nil {
+		return nil, err
+	}
+
+	r := rep.rr.Read()
+	if r.IsType(redis.IOErr) {
+		return nil, r.Err
+	}
+	if tag == tagIOErr {
+		r = redis.NewRespIOErr(r.Err)
+	}
+	return &recordedCmd{args: args, r: r}, nil
+}
+
+func (rep *Replayer) normalize(args []string) []string {
+	if rep.Normalize == nil {
+		return args
+	}
+	return rep.Normalize(append([]string(nil), args...))
+}
+
+// Cmd returns the recorded reply for the next recorded command, which must
+// match the given one
+func (rep *Replayer) Cmd(cmd string, args ...interface{}) *redis.Resp {
+	rep.l.Lock()
+	defer rep.l.Unlock()
+
+	argsStr := flattenArgs(cmd, args)
+	rc, err := rep.next()
+	if err != nil {
+		rep.t.Errorf("error reading recorded command: %s", err)
+		return redis.NewRespIOErr(err)
+	} else if rc == nil {
+		rep.t.Errorf("unexpected command after end of recording: %s", strings.Join(argsStr, " "))
+		return redis.NewRespIOErr(ErrUnexpected)
+	}
+
+	expected, got := rep.normalize(rc.args), rep.normalize(argsStr)
+	if diff := argsDiff(expected, got); diff != "" {
+		rep.t.Errorf(
+			"command doesn't match recording (%s):\n\trecorded: %s\n\tgot:      %s",
+			diff, strings.Join(expected, " "), strings.Join(got, " "),
+		)
+		return redis.NewRespIOErr(ErrUnexpected)
+	}
+	return rc.r
+}
+
+// argsDiff describes the first difference between the two commands, or returns
+// empty string if they're the same
+func argsDiff(expected, got []string) string {
+	for i := 0; i < len(expected) && i < len(got); i++ {
+		if i == 0 && !strings.EqualFold(expected[i], got[i]) {
+			return "different command"
+		} else if i > 0 && expected[i] != got[i] {
+			return fmt.Sprintf("argument %d differs: %q != %q", i, expected[i], got[i])
+		}
+	}
+	if len(expected) != len(got) {
+		return fmt.Sprintf("%d arguments recorded, got %d", len(expected)-1, len(got)-1)
+	}
+	return ""
+}
+
+// Done reports an error if there are recorded commands which haven't been
+// replayed
+func (rep *Replayer) Done() {
+	rep.l.Lock()
+	defer rep.l.Unlock()
+
+	var left int
+	for {
+		rc, err := rep.next()
+		if err != nil {
+			rep.t.Errorf("error reading recorded command: %s", err)
+			return
+		} else if rc == nil {
+			break
+		}
+		left++
+	}
+	if left > 0 {
+		rep.t.Errorf("%d recorded commands were never called", left)
+	}
+}
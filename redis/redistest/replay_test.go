@@ -0,0 +1,93 @@
+package redistest
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	. "testing"
+
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordReplay(t *T) {
+	buf := new(bytes.Buffer)
+	real := NewMock(t)
+	real.Expect("SET", "foo", "bar").Return(OK())
+	real.Expect("GET", "foo").Return(Str("bar"))
+	real.Expect("HGETALL", "h").Return(Array("a", "1", "b", Nil()))
+	real.Expect("GET", "dne").Return(Nil())
+	real.Expect("INCR", "foo").Return(Err("ERR not an int"))
+	real.Expect("PING").Return(IOErr(errors.New("closed")))
+
+	rec := NewRecorder(real, buf)
+	rec.Cmd("SET", "foo", "bar")
+	rec.Cmd("GET", "foo")
+	rec.Cmd("HGETALL", "h")
+	rec.Cmd("GET", "dne")
+	rec.Cmd("INCR", "foo")
+	rec.Cmd("PING")
+	require.Nil(t, rec.Err())
+	real.Done()
+
+	rep := NewReplayer(t, buf)
+	s, err := rep.Cmd("SET", "foo", "bar").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "OK", s)
+	s, err = rep.Cmd("get", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "bar", s)
+	arr, err := rep.Cmd("HGETALL", "h").Array()
+	require.Nil(t, err)
+	require.Len(t, arr, 4)
+	assert.True(t, arr[3].IsType(redis.Nil))
+	assert.True(t, rep.Cmd("GET", "dne").IsType(redis.Nil))
+	r := rep.Cmd("INCR", "foo")
+	assert.True(t, r.IsType(redis.AppErr))
+	assert.Equal(t, "ERR not an int", r.Err.Error())
+	r = rep.Cmd("PING")
+	assert.True(t, r.IsType(redis.IOErr))
+	assert.Equal(t, "closed", r.Err.Error())
+	rep.Done()
+}
+
+func TestReplayMismatch(t *T) {
+	buf := new(bytes.Buffer)
+	rec := NewRecorder(NewFunctionalMock(), buf)
+	rec.Cmd("SET", "key:1234", "bar")
+	rec.Cmd("GET", "key:1234")
+	rec.Cmd("GET", "other")
+
+	rt := new(recordT)
+	rep := NewReplayer(rt, bytes.NewReader(buf.Bytes()))
+	rep.Normalize = func(args []string) []string {
+		for i := range args {
+			if strings.HasPrefix(args[i], "key:") {
+				args[i] = "key:*"
+			}
+		}
+		return args
+	}
+
+	// the normalization hook makes the randomized key match
+	require.Nil(t, rep.Cmd("SET", "key:5678", "bar").Err)
+	s, err := rep.Cmd("GET", "key:5678").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "bar", s)
+	assert.Empty(t, rt.errs)
+
+	r := rep.Cmd("GET", "different")
+	assert.Equal(t, ErrUnexpected, r.Err)
+	require.Len(t, rt.errs, 1)
+	assert.Contains(t, rt.errs[0], `argument 1 differs: "other" != "different"`)
+
+	rep.Cmd("GET", "too", "far")
+	assert.Len(t, rt.errs, 2)
+
+	rt = new(recordT)
+	rep = NewReplayer(rt, bytes.NewReader(buf.Bytes()))
+	rep.Done()
+	require.Len(t, rt.errs, 1)
+	assert.Contains(t, rt.errs[0], "3 recorded commands")
+}
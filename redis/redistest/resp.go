@@ -0,0 +1,55 @@
+package redistest
+
+import (
+	"errors"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// Str returns a Resp of type Str (a bulk string) with the given value
+func Str(s string) *redis.Resp {
+	return redis.NewResp(s)
+}
+
+// Simple returns a Resp which will be encoded as a simple string, which is
+// what redis uses for replies like OK
+func Simple(s string) *redis.Resp {
+	return redis.NewRespSimple(s)
+}
+
+// OK returns the simple string Resp "OK"
+func OK() *redis.Resp {
+	return Simple("OK")
+}
+
+// Int returns a Resp of type Int with the given value
+func Int(i int64) *redis.Resp {
+	return redis.NewResp(i)
+}
+
+// Nil returns a Resp of type Nil
+func Nil() *redis.Resp {
+	return redis.NewResp(nil)
+}
+
+// Array returns a Resp of type Array whose elements are the given values. Each
+// value may be anything which can be passed into redis.NewResp, including
+// another *redis.Resp.
+func Array(vv ...interface{}) *redis.Resp {
+	if vv == nil {
+		vv = []interface{}{}
+	}
+	return redis.NewResp(vv)
+}
+
+// Err returns a Resp of type AppErr, i.e. an error like redis itself would
+// reply with, e.g. Err("WRONGTYPE Operation against a key...")
+func Err(msg string) *redis.Resp {
+	return redis.NewResp(errors.New(msg))
+}
+
+// IOErr returns a Resp of type IOErr, i.e. an error like the connection being
+// closed
+func IOErr(err error) *redis.Resp {
+	return redis.NewRespIOErr(err)
+}
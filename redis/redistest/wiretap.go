@@ -0,0 +1,109 @@
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// WireChunk is a single read from or write to a connection, as copied to a
+// redis.DialOpts' WireTap
+type WireChunk struct {
+	// Either redis.WireTapRead or redis.WireTapWrite
+	Dir  byte
+	Time time.Time
+	Data []byte
+}
+
+// ReadWireTap parses everything which was written to a redis.DialOpts'
+// WireTap
+func ReadWireTap(r io.Reader) ([]WireChunk, error) {
+	br := bufio.NewReader(r)
+	var chunks []WireChunk
+	for {
+		header, err := br.ReadString('\n')
+		if err == io.EOF && header == "" {
+			return chunks, nil
+		} else if err != nil {
+			return chunks, err
+		}
+
+		var c WireChunk
+		var ts string
+		var n int
+		if _, err := fmt.Sscanf(header, "%c %s %d\n", &c.Dir, &ts, &n); err != nil {
+			return chunks, fmt.Errorf("malformed wire tap header %q: %s", header, err)
+		} else if c.Dir != redis.WireTapRead && c.Dir != redis.WireTapWrite {
+			return chunks, fmt.Errorf("malformed wire tap header %q", header)
+		}
+		if c.Time, err = time.Parse(time.RFC3339Nano, ts); err != nil {
+			return chunks, err
+		}
+
+		c.Data = make([]byte, n+1)
+		if _, err := io.ReadFull(br, c.Data); err != nil {
+			return chunks, err
+		} else if c.Data[n] != '\n' {
+			return chunks, fmt.Errorf("wire tap chunk at %s isn't %d bytes long", ts, n)
+		}
+		c.Data = c.Data[:n]
+		chunks = append(chunks, c)
+	}
+}
+
+// chunkReader returns the data of each chunk from a separate Read, so that
+// a RespReader sees the data split up the same way it was originally
+type chunkReader struct {
+	chunks []WireChunk
+	cur    []byte
+}
+
+func (cr *chunkReader) Read(b []byte) (int, error) {
+	for len(cr.cur) == 0 {
+		if len(cr.chunks) == 0 {
+			return 0, io.EOF
+		}
+		cr.cur, cr.chunks = cr.chunks[0].Data, cr.chunks[1:]
+	}
+	n := copy(b, cr.cur)
+	cr.cur = cr.cur[n:]
+	return n, nil
+}
+
+// ReplayWireTap reads back what was written to a redis.DialOpts' WireTap, and
+// feeds everything which was read from the connection through a RespReader
+// with the given options, the same way a Client would have read it. It
+// returns every reply parsed, up to the first IOErr other than the data
+// running out, which is returned as the error. Since nothing depends on
+// timing this reproduces a parsing failure, like "unexpected reply type",
+// every time.
+func ReplayWireTap(r io.Reader, o redis.RespReaderOpts) ([]*redis.Resp, error) {
+	chunks, err := ReadWireTap(r)
+	if err != nil {
+		return nil, err
+	}
+	var reads []WireChunk
+	for _, c := range chunks {
+		if c.Dir == redis.WireTapRead {
+			reads = append(reads, c)
+		}
+	}
+
+	// replies are never inline, as in a Client
+	o.Inline = false
+	rr := redis.NewRespReaderWithOpts(&chunkReader{chunks: reads}, o)
+	var replies []*redis.Resp
+	for {
+		r := rr.Read()
+		if r.IsType(redis.IOErr) {
+			if r.Err == io.EOF {
+				return replies, nil
+			}
+			return replies, fmt.Errorf("reply %d: %s", len(replies), r.Err)
+		}
+		replies = append(replies, r)
+	}
+}
@@ -0,0 +1,79 @@
+package redistest
+
+import (
+	"bytes"
+	"context"
+	"net"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// tapped returns the WireTap dump of a connection which is sent a command for
+// each of the given raw replies, which the server replies with
+func tapped(t *T, replies ...string) []byte {
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		rr := redis.NewRespReader(server)
+		for _, reply := range replies {
+			if rr.Read().IsType(redis.IOErr) {
+				return
+			}
+			// each reply is written in two parts, to check that they're
+			// replayed split up the same way
+			server.Write([]byte(reply[:len(reply)/2]))
+			server.Write([]byte(reply[len(reply)/2:]))
+		}
+	}()
+
+	var buf bytes.Buffer
+	c, err := redis.DialCtx(context.Background(), "tcp", "fake", redis.DialOpts{
+		NetDial: func(context.Context, string, string) (net.Conn, error) {
+			return client, nil
+		},
+		WireTap: &buf,
+	})
+	require.Nil(t, err)
+	defer c.Close()
+	for range replies {
+		c.Cmd("PING")
+	}
+	return buf.Bytes()
+}
+
+func TestReadWireTap(t *T) {
+	dump := tapped(t, "+PONG\r\n")
+	chunks, err := ReadWireTap(bytes.NewReader(dump))
+	require.Nil(t, err)
+	require.Len(t, chunks, 3)
+	assert.Equal(t, byte(redis.WireTapWrite), chunks[0].Dir)
+	assert.Equal(t, "*1\r\n$4\r\nPING\r\n", string(chunks[0].Data))
+	assert.Equal(t, byte(redis.WireTapRead), chunks[1].Dir)
+	assert.Equal(t, "+PO", string(chunks[1].Data))
+	assert.Equal(t, "NG\r\n", string(chunks[2].Data))
+	assert.False(t, chunks[1].Time.Before(chunks[0].Time))
+
+	_, err = ReadWireTap(bytes.NewReader([]byte("? nonsense\n")))
+	assert.NotNil(t, err)
+}
+
+func TestReplayWireTap(t *T) {
+	dump := tapped(t, "+PONG\r\n", ":1\r\n")
+	replies, err := ReplayWireTap(bytes.NewReader(dump), redis.RespReaderOpts{})
+	require.Nil(t, err)
+	require.Len(t, replies, 2)
+	s, _ := replies[0].Str()
+	assert.Equal(t, "PONG", s)
+	i, _ := replies[1].Int()
+	assert.Equal(t, 1, i)
+
+	// a reply which the parser chokes on is reproduced, after those before it
+	dump = tapped(t, "+PONG\r\n", "!oops\r\n")
+	replies, err = ReplayWireTap(bytes.NewReader(dump), redis.RespReaderOpts{})
+	assert.NotNil(t, err)
+	assert.Len(t, replies, 1)
+}
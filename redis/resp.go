@@ -48,13 +48,22 @@ var (
 	intPrefix       = []byte{':'}
 	bulkStrPrefix   = []byte{'$'}
 	arrayPrefix     = []byte{'*'}
+	mapPrefix       = []byte{'%'}
+	attrPrefix      = []byte{'|'}
+	setPrefix       = []byte{'~'}
+	pushPrefix      = []byte{'>'}
+	nullPrefix      = []byte{'_'}
+	doublePrefix    = []byte{','}
+	boolPrefix      = []byte{'#'}
+	bigNumPrefix    = []byte{'('}
+	blobErrPrefix   = []byte{'!'}
+	verbatimPrefix  = []byte{'='}
 	nilFormatted    = []byte("$-1\r\n")
 )
 
 // Parse errors
 var (
 	errBadType  = errors.New("wrong type")
-	errParse    = errors.New("parse error")
 	errNotStr   = errors.New("could not convert to string")
 	errNotInt   = errors.New("could not convert to int")
 	errNotArray = errors.New("could not convert to array")
@@ -71,6 +80,12 @@ type Resp struct {
 	// connection level or the application level. Use IsType if you need to
 	// determine which, otherwise you can simply check if this is nil
 	Err error
+
+	// attributes sent by a RESP3 server ahead of this reply, if any
+	attrs map[string]*Resp
+
+	// set if this was sent by a RESP3 server as a push message
+	push bool
 }
 
 // NewResp takes the given value and interprets it into a resp encoded byte
@@ -109,143 +124,418 @@ func NewRespIOErr(err error) *Resp {
 	return r
 }
 
+// ProtocolError is returned (as the Err of an IOErr Resp) by a RespReader when
+// the data it's reading isn't valid RESP, or breaks one of the limits in its
+// RespReaderOpts. This usually means the connection isn't to a redis server,
+// or the stream has been corrupted. Once a RespReader has encountered a
+// ProtocolError every subsequent Read will return it, since there's no way to
+// find where the next valid message begins.
+type ProtocolError struct {
+	Msg string
+}
+
+func (pe *ProtocolError) Error() string {
+	return "resp protocol error: " + pe.Msg
+}
+
+func protocolErrorf(format string, args ...interface{}) error {
+	return &ProtocolError{Msg: fmt.Sprintf(format, args...)}
+}
+
+// RespReaderOpts are limits on what a RespReader will read, to protect against
+// data which is corrupt or malicious. The defaults are generous enough that a
+// real redis server should never break them.
+type RespReaderOpts struct {
+	// The maximum length, in bytes, of a bulk string, or of the line holding a
+	// simple string, error or integer. Defaults to 512MB, the largest bulk
+	// string redis allows by default.
+	MaxBulkLen int64
+
+	// The maximum number of elements in an array. Defaults to 2^32-1.
+	MaxArrayLen int64
+
+	// The maximum nesting depth of arrays. Attribute frames count as a level
+	// of nesting as well. Defaults to 128.
+	MaxDepth int
+
+	// If set then a line which doesn't start with a RESP type byte is read as
+	// an inline command, the plain space separated form which redis-cli and
+	// telnet send, and returned as an Array of BulkStrs. Quoting follows the
+	// same rules as redis. MaxBulkLen applies to the whole line. This is only
+	// useful when reading commands sent to a server, replies from redis are
+	// never inline.
+	Inline bool
+}
+
+func (o RespReaderOpts) withDefaults() RespReaderOpts {
+	if o.MaxBulkLen == 0 {
+		o.MaxBulkLen = 512 * 1024 * 1024
+	}
+	if o.MaxArrayLen == 0 {
+		o.MaxArrayLen = 1<<32 - 1
+	}
+	if o.MaxDepth == 0 {
+		o.MaxDepth = 128
+	}
+	return o
+}
+
 // RespReader is a wrapper around an io.Reader which will read Resp messages off
 // of the io.Reader
 type RespReader struct {
 	r *bufio.Reader
+	o RespReaderOpts
+
+	// set once a ProtocolError is encountered
+	err error
 }
 
 // NewRespReader creates and returns a new RespReader which will read from the
 // given io.Reader. Once passed in the io.Reader shouldn't be read from by any
 // other processes
 func NewRespReader(r io.Reader) *RespReader {
+	return NewRespReaderWithOpts(r, RespReaderOpts{})
+}
+
+// NewRespReaderWithOpts is like NewRespReader, but uses the given limits
+// rather than the defaults
+func NewRespReaderWithOpts(r io.Reader, o RespReaderOpts) *RespReader {
 	br, ok := r.(*bufio.Reader)
 	if !ok {
 		br = bufio.NewReader(r)
 	}
-	return &RespReader{br}
+	return &RespReader{r: br, o: o.withDefaults()}
 }
 
 // ReadResp attempts to read a message object from the given io.Reader, parse
 // it, and return a Resp representing it
 func (rr *RespReader) Read() *Resp {
-	res, err := bufioReadResp(rr.r)
+	if rr.err != nil {
+		return &Resp{typ: IOErr, val: rr.err, Err: rr.err}
+	}
+	res, err := rr.read(0)
 	if err != nil {
-		res = Resp{typ: IOErr, val: err, Err: err}
+		return rr.errResp(err)
 	}
 	return &res
 }
 
-func bufioReadResp(r *bufio.Reader) (Resp, error) {
-	b, err := r.Peek(1)
+// errResp returns the IOErr Resp for an error encountered while reading, which
+// is returned from every later Read too if it's a ProtocolError
+func (rr *RespReader) errResp(err error) *Resp {
+	if _, ok := err.(*ProtocolError); ok {
+		rr.err = err
+	}
+	return &Resp{typ: IOErr, val: err, Err: err}
+}
+
+func (rr *RespReader) read(depth int) (Resp, error) {
+	b, err := rr.r.Peek(1)
 	if err != nil {
 		return Resp{}, err
 	}
 	switch b[0] {
 	case simpleStrPrefix[0]:
-		return readSimpleStr(r)
+		return rr.readSimpleStr()
 	case errPrefix[0]:
-		return readError(r)
+		return rr.readError()
 	case intPrefix[0]:
-		return readInt(r)
+		return rr.readInt()
 	case bulkStrPrefix[0]:
-		return readBulkStr(r)
+		return rr.readBulkStr()
 	case arrayPrefix[0]:
-		return readArray(r)
+		return rr.readArray(depth, 1)
+	case mapPrefix[0]:
+		return rr.readArray(depth, 2)
+	case setPrefix[0]:
+		return rr.readArray(depth, 1)
+	case pushPrefix[0]:
+		m, err := rr.readArray(depth, 1)
+		m.push = m.typ == Array
+		return m, err
+	case nullPrefix[0]:
+		return rr.readNull()
+	case doublePrefix[0], bigNumPrefix[0]:
+		return rr.readNumStr()
+	case boolPrefix[0]:
+		return rr.readBool()
+	case blobErrPrefix[0]:
+		return rr.readBlobErr()
+	case verbatimPrefix[0]:
+		return rr.readVerbatimStr()
+	case attrPrefix[0]:
+		attrs, err := rr.readAttrs(depth)
+		if err != nil {
+			return Resp{}, err
+		}
+		m, err := rr.read(depth)
+		if err != nil {
+			return Resp{}, err
+		}
+		// if there were multiple attribute frames in a row then the later ones
+		// take precedence
+		for k, v := range m.attrs {
+			attrs[k] = v
+		}
+		m.attrs = attrs
+		return m, nil
 	default:
-		return Resp{}, errBadType
+		if rr.o.Inline && depth == 0 {
+			return rr.readInline()
+		}
+		return Resp{}, protocolErrorf("unknown type byte %q", b[0])
 	}
 }
 
-func readSimpleStr(r *bufio.Reader) (Resp, error) {
-	b, err := r.ReadBytes(delimEnd)
+// readLine reads a line, returning it without its type prefix or delimiter
+func (rr *RespReader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		b, err := rr.r.ReadSlice(delimEnd)
+		if int64(len(line)+len(b)) > rr.o.MaxBulkLen+3 {
+			return nil, protocolErrorf("line longer than %d bytes", rr.o.MaxBulkLen)
+		}
+		line = append(line, b...)
+		if err == bufio.ErrBufferFull {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		break
+	}
+	if len(line) < 3 || line[len(line)-2] != delim[0] {
+		return nil, protocolErrorf("line not terminated by CRLF")
+	}
+	return line[1 : len(line)-2], nil
+}
+
+// readLength reads the line giving the length of a bulk string or array, which
+// must be -1 (meaning nil) or between 0 and max
+func (rr *RespReader) readLength(max int64) (int64, error) {
+	b, err := rr.readLine()
+	if err != nil {
+		return 0, err
+	}
+	size, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return 0, protocolErrorf("invalid length %q", b)
+	} else if size < -1 {
+		return 0, protocolErrorf("negative length %d", size)
+	} else if size > max {
+		return 0, protocolErrorf("length %d is larger than the limit of %d", size, max)
+	}
+	return size, nil
+}
+
+func (rr *RespReader) readSimpleStr() (Resp, error) {
+	b, err := rr.readLine()
 	if err != nil {
 		return Resp{}, err
 	}
-	return Resp{typ: SimpleStr, val: b[1 : len(b)-2]}, nil
+	return Resp{typ: SimpleStr, val: b}, nil
 }
 
-func readError(r *bufio.Reader) (Resp, error) {
-	b, err := r.ReadBytes(delimEnd)
+func (rr *RespReader) readError() (Resp, error) {
+	b, err := rr.readLine()
 	if err != nil {
 		return Resp{}, err
 	}
-	err = errors.New(string(b[1 : len(b)-2]))
+	err = errors.New(string(b))
 	return Resp{typ: AppErr, val: err, Err: err}, nil
 }
 
-func readInt(r *bufio.Reader) (Resp, error) {
-	b, err := r.ReadBytes(delimEnd)
+func (rr *RespReader) readInt() (Resp, error) {
+	b, err := rr.readLine()
 	if err != nil {
 		return Resp{}, err
 	}
-	i, err := strconv.ParseInt(string(b[1:len(b)-2]), 10, 64)
+	i, err := strconv.ParseInt(string(b), 10, 64)
 	if err != nil {
-		return Resp{}, errParse
+		return Resp{}, protocolErrorf("invalid integer %q", b)
 	}
 	return Resp{typ: Int, val: i}, nil
 }
 
-func readBulkStr(r *bufio.Reader) (Resp, error) {
-	b, err := r.ReadBytes(delimEnd)
+// The RESP3 types below are all returned as whichever type a RESP2 server
+// would have sent in their place, so code written against RESP2 replies works
+// the same whichever protocol the connection is using.
+
+// readNull reads a RESP3 null, as a Nil
+func (rr *RespReader) readNull() (Resp, error) {
+	b, err := rr.readLine()
+	if err != nil {
+		return Resp{}, err
+	} else if len(b) != 0 {
+		return Resp{}, protocolErrorf("invalid null %q", b)
+	}
+	return Resp{typ: Nil}, nil
+}
+
+// readNumStr reads a RESP3 double or big number, as a BulkStr holding the
+// number's text, so Float64 or Str can be used on it
+func (rr *RespReader) readNumStr() (Resp, error) {
+	b, err := rr.readLine()
+	if err != nil {
+		return Resp{}, err
+	}
+	return Resp{typ: BulkStr, val: b}, nil
+}
+
+// readBool reads a RESP3 boolean, as an Int of 1 or 0
+func (rr *RespReader) readBool() (Resp, error) {
+	b, err := rr.readLine()
+	if err != nil {
+		return Resp{}, err
+	}
+	switch string(b) {
+	case "t":
+		return Resp{typ: Int, val: int64(1)}, nil
+	case "f":
+		return Resp{typ: Int, val: int64(0)}, nil
+	}
+	return Resp{}, protocolErrorf("invalid boolean %q", b)
+}
+
+// readBlobErr reads a RESP3 blob error, as an AppErr
+func (rr *RespReader) readBlobErr() (Resp, error) {
+	m, err := rr.readBulkStr()
 	if err != nil {
 		return Resp{}, err
+	} else if m.typ == Nil {
+		return Resp{}, protocolErrorf("blob error has a nil length")
 	}
-	size, err := strconv.ParseInt(string(b[1:len(b)-2]), 10, 64)
+	err = errors.New(string(m.val.([]byte)))
+	return Resp{typ: AppErr, val: err, Err: err}, nil
+}
+
+// readVerbatimStr reads a RESP3 verbatim string, as a BulkStr without the
+// format (e.g. "txt:") it's prefixed with
+func (rr *RespReader) readVerbatimStr() (Resp, error) {
+	m, err := rr.readBulkStr()
 	if err != nil {
-		return Resp{}, errParse
+		return Resp{}, err
+	} else if m.typ == Nil {
+		return Resp{}, protocolErrorf("verbatim string has a nil length")
+	}
+	b := m.val.([]byte)
+	if len(b) < 4 || b[3] != ':' {
+		return Resp{}, protocolErrorf("verbatim string has no format")
 	}
-	if size < 0 {
+	return Resp{typ: BulkStr, val: b[4:]}, nil
+}
+
+// bulkChunk is the most which is allocated for a bulk string before its data
+// has actually been read, so that a bogus length can't cause a huge allocation
+const bulkChunk = 64 * 1024
+
+func (rr *RespReader) readBulkStr() (Resp, error) {
+	size, err := rr.readLength(rr.o.MaxBulkLen)
+	if err != nil {
+		return Resp{}, err
+	} else if size < 0 {
 		return Resp{typ: Nil}, nil
 	}
-	total := make([]byte, size)
-	b2 := total
-	var n int
-	for len(b2) > 0 {
-		n, err = r.Read(b2)
-		if err != nil {
+
+	var total []byte
+	for int64(len(total)) < size {
+		chunk := size - int64(len(total))
+		if chunk > bulkChunk {
+			chunk = bulkChunk
+		}
+		start := len(total)
+		total = append(total, make([]byte, chunk)...)
+		if _, err := io.ReadFull(rr.r, total[start:]); err != nil {
 			return Resp{}, err
 		}
-		b2 = b2[n:]
+	}
+	if total == nil {
+		total = []byte{}
 	}
 
 	// There's a hanging \r\n there, gotta read past it
-	trail := make([]byte, 2)
 	for i := 0; i < 2; i++ {
-		c, err := r.ReadByte()
+		c, err := rr.r.ReadByte()
 		if err != nil {
 			return Resp{}, err
+		} else if c != delim[i] {
+			return Resp{}, protocolErrorf("bulk string not terminated by CRLF")
 		}
-		trail[i] = c
 	}
 
 	return Resp{typ: BulkStr, val: total}, nil
 }
 
-func readArray(r *bufio.Reader) (Resp, error) {
-	b, err := r.ReadBytes(delimEnd)
-	if err != nil {
-		return Resp{}, err
+// arrayChunk is the most elements which are allocated for an array before
+// they've actually been read, for the same reason as bulkChunk
+const arrayChunk = 1024
+
+// readArray reads an array, or a RESP3 map if per is 2. A map's length is its
+// number of key/value pairs, and it's returned as an Array of alternating keys
+// and values, the same as a RESP2 server would send it.
+func (rr *RespReader) readArray(depth int, per int64) (Resp, error) {
+	if depth >= rr.o.MaxDepth {
+		return Resp{}, protocolErrorf("arrays nested deeper than %d", rr.o.MaxDepth)
 	}
-	size, err := strconv.ParseInt(string(b[1:len(b)-2]), 10, 64)
+	size, err := rr.readLength(rr.o.MaxArrayLen / per)
 	if err != nil {
-		return Resp{}, errParse
-	}
-	if size < 0 {
+		return Resp{}, err
+	} else if size < 0 {
 		return Resp{typ: Nil}, nil
 	}
+	size *= per
 
-	arr := make([]Resp, size)
-	for i := range arr {
-		m, err := bufioReadResp(r)
+	capacity := size
+	if capacity > arrayChunk {
+		capacity = arrayChunk
+	}
+	arr := make([]Resp, 0, capacity)
+	for i := int64(0); i < size; i++ {
+		m, err := rr.read(depth + 1)
 		if err != nil {
 			return Resp{}, err
 		}
-		arr[i] = m
+		arr = append(arr, m)
 	}
 	return Resp{typ: Array, val: arr}, nil
 }
 
+// readAttrs reads a RESP3 attribute frame, whose contents are laid out the
+// same as a map's
+func (rr *RespReader) readAttrs(depth int) (map[string]*Resp, error) {
+	m, err := rr.readArray(depth, 2)
+	if err != nil {
+		return nil, err
+	} else if m.typ == Nil {
+		return nil, protocolErrorf("attribute frame has a nil length")
+	}
+	arr := m.val.([]Resp)
+	attrs := make(map[string]*Resp, len(arr)/2)
+	for i := 0; i < len(arr); i += 2 {
+		k, err := arr[i].Str()
+		if err != nil {
+			return nil, protocolErrorf("attribute key is not a string: %s", arr[i].String())
+		}
+		attrs[k] = &arr[i+1]
+	}
+	return attrs, nil
+}
+
+// Attributes returns the attributes a RESP3 server attached to this reply
+// using an attribute frame, or nil if there were none. Attribute values which
+// are maps are returned as Arrays, so Map can be used on them. Attributes are
+// only ever read, they're never written by WriteTo.
+func (r *Resp) Attributes() map[string]*Resp {
+	return r.attrs
+}
+
+// IsPush returns whether this was sent by a RESP3 server as a push message,
+// rather than in reply to a command. Push messages are Arrays, and are laid
+// out the same as the messages a RESP2 server sends to a subscribed
+// connection. See Client's SetPushHandler.
+func (r *Resp) IsPush() bool {
+	return r.push
+}
+
 // IsType returns whether or or not the reply is of a given type
 //
 //	isStr := r.IsType(redis.Str)
@@ -328,7 +618,7 @@ func (r *Resp) Int64() (int64, error) {
 	return 0, errNotInt
 }
 
-// Float64 returns a float64 representing the value of the Resp. Only valud for
+// Float64 returns a float64 representing the value of the Resp. Only valid for
 // a Resp of type Str which represents an actual float. If r.Err != nil that
 // will be returned
 func (r *Resp) Float64() (float64, error) {
@@ -415,39 +705,174 @@ func (r *Resp) ListBytes() ([][]byte, error) {
 	return l, nil
 }
 
-// Map is a wrapper around Array which returns the result as a map of strings,
-// calling Str() on alternating key/values for the map. All value fields of type
-// Nil will be treated as empty strings, keys must all be of type Str
-func (r *Resp) Map() (map[string]string, error) {
-	l, err := r.betterArray()
+// Present is a wrapper around Array which returns, for each element, whether
+// it isn't Nil. List, ListBytes, Int64s, Float64s and Bools all treat a Nil
+// element as the zero value, so this tells those apart from elements which
+// really are zero, e.g. the keys missing from an MGET.
+func (r *Resp) Present() ([]bool, error) {
+	m, err := r.betterArray()
+	if err != nil {
+		return nil, err
+	}
+	l := make([]bool, len(m))
+	for i := range m {
+		l[i] = !m[i].IsType(Nil)
+	}
+	return l, nil
+}
+
+// Int64s is a wrapper around Array which returns the result as a list of
+// int64s, calling Int64() on each Resp which Array returns. Any errors
+// encountered are immediately returned. Any Nil replies are interpreted as 0,
+// see Present.
+func (r *Resp) Int64s() ([]int64, error) {
+	m, err := r.betterArray()
 	if err != nil {
 		return nil, err
 	}
+	l := make([]int64, len(m))
+	for i := range m {
+		if m[i].IsType(Nil) {
+			continue
+		}
+		if l[i], err = m[i].Int64(); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Float64s is like Int64s, but calls Float64() on each Resp
+func (r *Resp) Float64s() ([]float64, error) {
+	m, err := r.betterArray()
+	if err != nil {
+		return nil, err
+	}
+	l := make([]float64, len(m))
+	for i := range m {
+		if m[i].IsType(Nil) {
+			continue
+		}
+		if l[i], err = m[i].Float64(); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Bool returns whether the Resp is a non-zero integer, either of type Int or
+// a Str which parses as one, or the Str "OK". Any other Str is an error, and
+// Nil is false. If r.Err != nil that will be returned
+func (r *Resp) Bool() (bool, error) {
+	if r.Err != nil {
+		return false, r.Err
+	} else if r.IsType(Nil) {
+		return false, nil
+	} else if s, err := r.Str(); err == nil && s == "OK" {
+		return true, nil
+	}
+	i, err := r.Int64()
+	if err != nil {
+		return false, err
+	}
+	return i != 0, nil
+}
+
+// Bools is like Int64s, but calls Bool() on each Resp, e.g. for the reply to
+// SMISMEMBER
+func (r *Resp) Bools() ([]bool, error) {
+	m, err := r.betterArray()
+	if err != nil {
+		return nil, err
+	}
+	l := make([]bool, len(m))
+	for i := range m {
+		if l[i], err = m[i].Bool(); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// eachPair calls fn with each key/value pair of an Array of alternating keys
+// and values, stopping at the first error. Keys must all be of type Str.
+func (r *Resp) eachPair(fn func(k string, v *Resp) error) error {
+	l, err := r.betterArray()
+	if err != nil {
+		return err
+	}
 	if len(l)%2 != 0 {
-		return nil, errors.New("reply has odd number of elements")
+		return errors.New("reply has odd number of elements")
 	}
+	for i := 0; i < len(l); i += 2 {
+		k, err := l[i].Str()
+		if err != nil {
+			return err
+		}
+		if err := fn(k, &l[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// Map is a wrapper around Array which returns the result as a map of strings,
+// calling Str() on alternating key/values for the map. All value fields of type
+// Nil will be treated as empty strings, keys must all be of type Str. See Into
+// for decoding into structs and maps of other types.
+func (r *Resp) Map() (map[string]string, error) {
 	m := map[string]string{}
-	for {
-		if len(l) == 0 {
-			return m, nil
+	err := r.eachPair(func(k string, v *Resp) error {
+		if v.IsType(Nil) {
+			m[k] = ""
+			return nil
 		}
-		k, v := l[0], l[1]
-		l = l[2:]
+		s, err := v.Str()
+		m[k] = s
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
 
-		ks, err := k.Str()
-		if err != nil {
-			return nil, err
+// MapInt64 is like Map, but calls Int64() on the values, e.g. for the reply
+// to HGETALL on a hash of counters. Nil values are treated as 0.
+func (r *Resp) MapInt64() (map[string]int64, error) {
+	m := map[string]int64{}
+	err := r.eachPair(func(k string, v *Resp) error {
+		if v.IsType(Nil) {
+			m[k] = 0
+			return nil
 		}
+		i, err := v.Int64()
+		m[k] = i
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
 
-		var vs string
+// MapFloat64 is like Map, but calls Float64() on the values, e.g. for the
+// reply to ZRANGE WITHSCORES. Nil values are treated as 0.
+func (r *Resp) MapFloat64() (map[string]float64, error) {
+	m := map[string]float64{}
+	err := r.eachPair(func(k string, v *Resp) error {
 		if v.IsType(Nil) {
-			vs = ""
-		} else if vs, err = v.Str(); err != nil {
-			return nil, err
+			m[k] = 0
+			return nil
 		}
-		m[ks] = vs
+		f, err := v.Float64()
+		m[k] = f
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
+	return m, nil
 }
 
 // String returns a string representation of the Resp. This method is for
@@ -499,6 +924,10 @@ func flattenedLength(mm ...interface{}) int {
 			total += flattenedLength(m.([]interface{})...)
 
 		default:
+			if isMarshaled(m) {
+				total++
+				continue
+			}
 			t := reflect.TypeOf(m)
 
 			switch t.Kind() {
@@ -531,8 +960,9 @@ func flattenedLength(mm ...interface{}) int {
 func flatten(m interface{}) []interface{} {
 	t := reflect.TypeOf(m)
 
-	// If it's a byte-slice we don't want to flatten
-	if t == typeOfBytes {
+	// If it's a byte-slice, or something sent as a single value like a
+	// net.IP, we don't want to flatten
+	if t == typeOfBytes || isMarshaled(m) {
 		return []interface{}{m}
 	}
 
@@ -690,6 +1120,14 @@ func writeTo(
 		return writeTo(w, buf, mt.val, forceString, noArrayHeader)
 
 	default:
+		if isMarshaled(m) {
+			v, err := marshalArg(m)
+			if err != nil {
+				return 0, err
+			}
+			return writeTo(w, buf, v, forceString, noArrayHeader)
+		}
+
 		// Fallback to reflect-based.
 		switch reflect.TypeOf(m).Kind() {
 		case reflect.Slice:
@@ -875,6 +1313,14 @@ func format(m interface{}, forceString bool) Resp {
 		return mt
 
 	default:
+		if isMarshaled(m) {
+			v, err := marshalArg(m)
+			if err != nil {
+				return Resp{typ: AppErr, val: err, Err: err}
+			}
+			return format(v, forceString)
+		}
+
 		// Fallback to reflect-based.
 		switch reflect.TypeOf(m).Kind() {
 		case reflect.Slice:
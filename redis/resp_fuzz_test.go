@@ -0,0 +1,48 @@
+//go:build go1.18
+// +build go1.18
+
+package redis
+
+import (
+	"bytes"
+	. "testing"
+)
+
+func FuzzRespReader(f *F) {
+	for _, s := range []string{
+		"+OK\r\n",
+		"-ERR bad\r\n",
+		":123\r\n",
+		"$3\r\nfoo\r\n",
+		"$-1\r\n",
+		"*2\r\n$1\r\na\r\n*1\r\n:1\r\n",
+		"*-1\r\n",
+	} {
+		f.Add([]byte(s))
+	}
+
+	f.Fuzz(func(t *T, b []byte) {
+		rr := NewRespReaderWithOpts(bytes.NewReader(b), RespReaderOpts{
+			MaxBulkLen:  1024,
+			MaxArrayLen: 1024,
+			MaxDepth:    16,
+		})
+		for i := 0; i < 16; i++ {
+			r := rr.Read()
+			if r.IsType(IOErr) {
+				return
+			}
+
+			// anything which was read successfully should be written back
+			// out, and read back in, the same
+			buf := new(bytes.Buffer)
+			if _, err := r.WriteTo(buf); err != nil {
+				t.Fatalf("couldn't write %v: %s", r, err)
+			}
+			r2 := NewRespReader(buf).Read()
+			if r.String() != r2.String() {
+				t.Fatalf("%v was read back as %v", r, r2)
+			}
+		}
+	})
+}
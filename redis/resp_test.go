@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"math"
 	. "testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func pretendRead(s string) *Resp {
@@ -222,3 +224,217 @@ func TestFloat64(t *T) {
 	assert.Equal(t, float64(5.0), f)
 
 }
+
+func TestTypedLists(t *T) {
+	// e.g. the reply to BITFIELD with an overflowed INCRBY
+	r := pretendRead("*3\r\n:1\r\n$-1\r\n$2\r\n-3\r\n")
+	is, err := r.Int64s()
+	require.Nil(t, err)
+	assert.Equal(t, []int64{1, 0, -3}, is)
+	present, err := r.Present()
+	require.Nil(t, err)
+	assert.Equal(t, []bool{true, false, true}, present)
+
+	fs, err := pretendRead("*3\r\n$4\r\n3.14\r\n$-1\r\n,2.5\r\n").Float64s()
+	require.Nil(t, err)
+	assert.Equal(t, []float64{3.14, 0, 2.5}, fs)
+
+	bs, err := pretendRead("*5\r\n:1\r\n:0\r\n+OK\r\n$-1\r\n#t\r\n").Bools()
+	require.Nil(t, err)
+	assert.Equal(t, []bool{true, false, true, false, true}, bs)
+
+	_, err = pretendRead("*1\r\n+foo\r\n").Int64s()
+	assert.NotNil(t, err)
+	_, err = pretendRead("*1\r\n+foo\r\n").Bools()
+	assert.NotNil(t, err)
+	_, err = NewResp(1).Int64s()
+	assert.NotNil(t, err)
+}
+
+func TestTypedMaps(t *T) {
+	mi, err := pretendRead("*4\r\n+a\r\n$2\r\n10\r\n+b\r\n:-2\r\n").MapInt64()
+	require.Nil(t, err)
+	assert.Equal(t, map[string]int64{"a": 10, "b": -2}, mi)
+
+	mf, err := pretendRead("*4\r\n+a\r\n$3\r\n1.5\r\n+b\r\n$-1\r\n").MapFloat64()
+	require.Nil(t, err)
+	assert.Equal(t, map[string]float64{"a": 1.5, "b": 0}, mf)
+
+	_, err = pretendRead("*3\r\n+a\r\n:1\r\n+b\r\n").MapInt64()
+	assert.NotNil(t, err)
+	_, err = pretendRead("*2\r\n+a\r\n+x\r\n").MapFloat64()
+	assert.NotNil(t, err)
+}
+
+func TestReadProtocolErrors(t *T) {
+	for _, s := range []string{
+		"!foo\r\n",
+		"+\n",
+		"+foo\n",
+		":abc\r\n",
+		"$-2\r\n",
+		"$abc\r\n",
+		"$3\r\nfooXX",
+		"*-5\r\n",
+		"$1099511627776\r\nfoo\r\n",
+		"*1099511627776\r\n",
+	} {
+		r := pretendRead(s)
+		assert.True(t, r.IsType(IOErr), "s: %q", s)
+		_, ok := r.Err.(*ProtocolError)
+		assert.True(t, ok, "s: %q err: %v", s, r.Err)
+	}
+
+	// a huge length with no data behind it shouldn't be allocated up front,
+	// so this should simply be an EOF
+	r := pretendRead("$536870912\r\nfoo")
+	assert.True(t, r.IsType(IOErr))
+	assert.Equal(t, "unexpected EOF", r.Err.Error())
+
+	// once a protocol error has happened every Read returns it
+	rr := NewRespReader(bytes.NewBufferString("!foo\r\n+OK\r\n"))
+	r = rr.Read()
+	assert.True(t, r.IsType(IOErr))
+	assert.Equal(t, r.Err, rr.Read().Err)
+}
+
+func TestReadLimits(t *T) {
+	read := func(s string, o RespReaderOpts) *Resp {
+		return NewRespReaderWithOpts(bytes.NewBufferString(s), o).Read()
+	}
+	o := RespReaderOpts{MaxBulkLen: 3, MaxArrayLen: 2, MaxDepth: 2}
+
+	assert.Nil(t, read("$3\r\nfoo\r\n", o).Err)
+	assert.NotNil(t, read("$4\r\nfoob\r\n", o).Err)
+	assert.Nil(t, read("+foo\r\n", o).Err)
+	assert.NotNil(t, read("+foob\r\n", o).Err)
+	assert.Nil(t, read("*2\r\n:1\r\n:2\r\n", o).Err)
+	assert.NotNil(t, read("*3\r\n:1\r\n:2\r\n:3\r\n", o).Err)
+	assert.Nil(t, read("*1\r\n*1\r\n:1\r\n", o).Err)
+	assert.NotNil(t, read("*1\r\n*1\r\n*1\r\n:1\r\n", o).Err)
+
+	// the default depth limit
+	deep := ""
+	for i := 0; i < 200; i++ {
+		deep += "*1\r\n"
+	}
+	r := pretendRead(deep + ":1\r\n")
+	_, ok := r.Err.(*ProtocolError)
+	assert.True(t, ok)
+}
+
+func TestReadAttributes(t *T) {
+	// An attribute frame ahead of a top-level reply
+	r := pretendRead("|1\r\n+key-popularity\r\n%2\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n" +
+		"*2\r\n:2527\r\n:9683\r\n")
+	require.Nil(t, r.Err)
+	l, err := r.Array()
+	require.Nil(t, err)
+	require.Len(t, l, 2)
+	attrs := r.Attributes()
+	require.Len(t, attrs, 1)
+	pop, err := attrs["key-popularity"].Map()
+	require.Nil(t, err)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, pop)
+
+	// An attribute frame on an element within an array, which doesn't affect
+	// the array itself
+	r = pretendRead("*2\r\n:1\r\n|1\r\n+ttl\r\n:3600\r\n:2\r\n")
+	require.Nil(t, r.Err)
+	assert.Nil(t, r.Attributes())
+	l, err = r.Array()
+	require.Nil(t, err)
+	require.Len(t, l, 2)
+	assert.Nil(t, l[0].Attributes())
+	i, err := l[1].Int()
+	require.Nil(t, err)
+	assert.Equal(t, 2, i)
+	ttl, err := l[1].Attributes()["ttl"].Int()
+	require.Nil(t, err)
+	assert.Equal(t, 3600, ttl)
+
+	// The reader stays in sync, so following replies are read normally
+	rr := NewRespReader(bytes.NewBufferString("|1\r\n+a\r\n+b\r\n+OK\r\n:5\r\n"))
+	r = rr.Read()
+	assert.Equal(t, "OK", mustStr(t, r))
+	assert.Equal(t, "b", mustStr(t, r.Attributes()["a"]))
+	r = rr.Read()
+	assert.Nil(t, r.Attributes())
+	i, err = r.Int()
+	require.Nil(t, err)
+	assert.Equal(t, 5, i)
+
+	// Consecutive attribute frames are merged
+	r = pretendRead("|1\r\n+a\r\n:1\r\n|1\r\n+b\r\n:2\r\n+OK\r\n")
+	require.Nil(t, r.Err)
+	assert.Len(t, r.Attributes(), 2)
+
+	// Keys must be strings
+	r = pretendRead("|1\r\n:1\r\n:1\r\n+OK\r\n")
+	_, ok := r.Err.(*ProtocolError)
+	assert.True(t, ok)
+
+	// Attributes aren't written out
+	r = pretendRead("|1\r\n+a\r\n+b\r\n+OK\r\n")
+	buf := new(bytes.Buffer)
+	r.WriteTo(buf)
+	assert.Equal(t, "+OK\r\n", buf.String())
+}
+
+func TestReadRESP3(t *T) {
+	// Each type is read as what RESP2 would have sent in its place
+	r := pretendRead("_\r\n")
+	assert.True(t, r.IsType(Nil))
+
+	r = pretendRead(",3.14\r\n")
+	assert.True(t, r.IsType(BulkStr))
+	f, err := r.Float64()
+	require.Nil(t, err)
+	assert.Equal(t, 3.14, f)
+	f, err = pretendRead(",-inf\r\n").Float64()
+	require.Nil(t, err)
+	assert.True(t, math.IsInf(f, -1))
+
+	i, err := pretendRead("#t\r\n").Int()
+	require.Nil(t, err)
+	assert.Equal(t, 1, i)
+	i, err = pretendRead("#f\r\n").Int()
+	require.Nil(t, err)
+	assert.Equal(t, 0, i)
+
+	assert.Equal(t, "3492890328409238509324850943850943825024385",
+		mustStr(t, pretendRead("(3492890328409238509324850943850943825024385\r\n")))
+
+	r = pretendRead("!21\r\nSYNTAX invalid syntax\r\n")
+	assert.True(t, r.IsType(AppErr))
+	assert.Equal(t, "SYNTAX invalid syntax", r.Err.Error())
+
+	assert.Equal(t, "Some string", mustStr(t, pretendRead("=15\r\ntxt:Some string\r\n")))
+
+	l, err := pretendRead("~2\r\n+a\r\n+b\r\n").List()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, l)
+
+	// Maps can be decoded into Go maps with Into
+	var m map[string]float64
+	require.Nil(t, pretendRead("%2\r\n+a\r\n,1.5\r\n+b\r\n:2\r\n").Into(&m))
+	assert.Equal(t, map[string]float64{"a": 1.5, "b": 2}, m)
+
+	r = pretendRead(">3\r\n+message\r\n+foo\r\n+bar\r\n")
+	assert.True(t, r.IsPush())
+	l, err = r.List()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"message", "foo", "bar"}, l)
+	assert.False(t, pretendRead("*1\r\n+message\r\n").IsPush())
+
+	for _, bad := range []string{"_foo\r\n", "#x\r\n", "!-1\r\n", "=3\r\nfoo\r\n"} {
+		_, ok := pretendRead(bad).Err.(*ProtocolError)
+		assert.True(t, ok, "%q", bad)
+	}
+}
+
+func mustStr(t *T, r *Resp) string {
+	s, err := r.Str()
+	require.Nil(t, err)
+	return s
+}
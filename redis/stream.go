@@ -0,0 +1,124 @@
+package redis
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrNilReply is returned from CmdTo when the reply is nil, e.g. for a GET of a
+// key which doesn't exist. Nothing is written in that case.
+var ErrNilReply = errors.New("nil reply")
+
+// CmdTo is like Cmd, but if the reply is a bulk string, e.g. for GET, its data
+// is copied straight from the connection's read buffer into w as it arrives,
+// rather than being read into a Resp first. This saves holding a large value in
+// memory, possibly more than once, when all that's done with it is writing it
+// somewhere. The number of bytes written to w is returned.
+//
+// If the reply is nil nothing is written and ErrNilReply is returned, and if
+// it's an application error that's returned. Any other kind of string reply is
+// written to w after being read as normal, and anything else is an error.
+//
+// If w returns an error the rest of the reply is still read, and discarded, so
+// that the Client can carry on being used, and then that error is returned. An
+// error reading the reply closes the Client, as with Cmd. To use CmdTo with a
+// pool.Pool, Get a Client and Put it back afterwards.
+func (c *Client) CmdTo(w io.Writer, cmd string, args ...interface{}) (int64, error) {
+	if c.trace != nil {
+		start := c.traceStart(cmd, args)
+		n, err := c.cmdTo(w, cmd, args)
+		r := new(Resp)
+		if err != ErrNilReply {
+			r.Err = err
+		}
+		c.traceDone(cmd, r, start)
+		return n, err
+	}
+	return c.cmdTo(w, cmd, args)
+}
+
+func (c *Client) cmdTo(w io.Writer, cmd string, args []interface{}) (int64, error) {
+	if err := c.WriteCmd(cmd, args...); err != nil {
+		return 0, err
+	}
+
+	var r *Resp
+	for {
+		c.setDeadline(c.conn.SetReadDeadline, c.readTimeout)
+		var n int64
+		var werr error
+		if r, n, werr = c.respReader.readTo(w); r == nil {
+			return n, werr
+		} else if r.IsType(IOErr) {
+			c.LastCritical = r.Err
+			c.Close()
+			return n, r.Err
+		} else if r.IsPush() {
+			c.push(r)
+			continue
+		}
+		break
+	}
+
+	if r.IsType(Nil) {
+		return 0, ErrNilReply
+	}
+	b, err := r.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// readTo is like Read, except that if what's read is a bulk string its data is
+// written to w as it's read from the buffer, rather than being kept, and the
+// returned Resp is nil. If w returns an error the rest of the data is still
+// read, and the error is returned once it has been. The int64 is the number of
+// bytes written to w, which for an IOErr may be less than the whole string.
+func (rr *RespReader) readTo(w io.Writer) (*Resp, int64, error) {
+	if rr.err != nil {
+		return rr.Read(), 0, nil
+	} else if b, err := rr.r.Peek(1); err != nil || b[0] != bulkStrPrefix[0] {
+		return rr.Read(), 0, nil
+	}
+
+	size, err := rr.readLength(rr.o.MaxBulkLen)
+	if err != nil {
+		return rr.errResp(err), 0, nil
+	} else if size < 0 {
+		return &Resp{typ: Nil}, 0, nil
+	}
+
+	var n int64
+	var werr error
+	for left := size; left > 0; {
+		// Peek fills the buffer if it's empty, after which everything in it
+		// which is part of the string is written straight from it
+		if _, err := rr.r.Peek(1); err != nil {
+			return rr.errResp(err), n, werr
+		}
+		chunk := rr.r.Buffered()
+		if int64(chunk) > left {
+			chunk = int(left)
+		}
+		b, _ := rr.r.Peek(chunk)
+		if werr == nil {
+			var wn int
+			wn, werr = w.Write(b)
+			n += int64(wn)
+		}
+		rr.r.Discard(chunk)
+		left -= int64(chunk)
+	}
+
+	for i := 0; i < 2; i++ {
+		c, err := rr.r.ReadByte()
+		if err != nil {
+			return rr.errResp(err), n, werr
+		} else if c != delim[i] {
+			return rr.errResp(protocolErrorf("bulk string not terminated by CRLF")), n, werr
+		}
+	}
+	return nil, n, werr
+}
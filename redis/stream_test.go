@@ -0,0 +1,170 @@
+package redis
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net"
+	"strings"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// getServer answers GET with the value from vals, or nil if there isn't one,
+// and everything else with an error
+func getServer(t require.TestingT, vals map[string][]byte) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				rr := NewRespReader(conn)
+				for {
+					args, err := rr.Read().List()
+					if err != nil {
+						return
+					} else if strings.ToUpper(args[0]) != "GET" {
+						NewResp(errors.New("ERR unknown command")).WriteTo(conn)
+					} else if v, ok := vals[args[1]]; ok {
+						NewResp(v).WriteTo(conn)
+					} else {
+						NewResp(nil).WriteTo(conn)
+					}
+				}
+			}()
+		}
+	}()
+	return l
+}
+
+// failingWriter takes n bytes and then returns an error
+type failingWriter struct {
+	bytes.Buffer
+	n int
+}
+
+func (fw *failingWriter) Write(b []byte) (int, error) {
+	if fw.Len()+len(b) > fw.n {
+		n, _ := fw.Buffer.Write(b[:fw.n-fw.Len()])
+		return n, errors.New("writer full")
+	}
+	return fw.Buffer.Write(b)
+}
+
+func TestCmdTo(t *T) {
+	big := bytes.Repeat([]byte("0123456789"), 100000)
+	l := getServer(t, map[string][]byte{"big": big, "empty": {}})
+	defer l.Close()
+	c, err := DialTimeout("tcp", l.Addr().String(), 5*time.Second)
+	require.Nil(t, err)
+	defer c.Close()
+
+	buf := new(bytes.Buffer)
+	n, err := c.CmdTo(buf, "GET", "big")
+	require.Nil(t, err)
+	assert.Equal(t, int64(len(big)), n)
+	assert.True(t, bytes.Equal(big, buf.Bytes()))
+
+	buf.Reset()
+	n, err = c.CmdTo(buf, "GET", "empty")
+	require.Nil(t, err)
+	assert.Equal(t, int64(0), n)
+
+	n, err = c.CmdTo(buf, "GET", "missing")
+	assert.Equal(t, ErrNilReply, err)
+	assert.Equal(t, int64(0), n)
+	assert.Equal(t, 0, buf.Len())
+
+	_, err = c.CmdTo(buf, "SET", "big", "foo")
+	require.NotNil(t, err)
+	assert.Equal(t, "ERR unknown command", err.Error())
+
+	// the rest of the value is still read when the writer fails, so the
+	// connection can carry on being used
+	fw := &failingWriter{n: 1000}
+	n, err = c.CmdTo(fw, "GET", "big")
+	assert.Equal(t, "writer full", err.Error())
+	assert.Equal(t, int64(1000), n)
+	assert.Nil(t, c.LastCritical)
+
+	b, err := c.Cmd("GET", "big").Bytes()
+	require.Nil(t, err)
+	assert.True(t, bytes.Equal(big, b))
+}
+
+func TestCmdToNotBulk(t *T) {
+	cc, sc := net.Pipe()
+	defer sc.Close()
+	c := newClient(cc, "", "", 5*time.Second, RespReaderOpts{})
+	defer c.Close()
+	go func() {
+		rr := NewRespReader(sc)
+		for _, reply := range []string{
+			">2\r\n$10\r\ninvalidate\r\n*0\r\n+OK\r\n",
+			":1\r\n",
+		} {
+			if rr.Read().Err != nil {
+				return
+			}
+			sc.Write([]byte(reply))
+		}
+	}()
+
+	// push messages are handed off, and simple strings written as they are
+	buf := new(bytes.Buffer)
+	n, err := c.CmdTo(buf, "SET", "foo", "bar")
+	require.Nil(t, err)
+	assert.Equal(t, int64(2), n)
+	assert.Equal(t, "OK", buf.String())
+	assert.Len(t, c.Pushes(), 1)
+
+	_, err = c.CmdTo(buf, "INCR", "foo")
+	assert.Equal(t, errBadType, err)
+}
+
+const benchValueLen = 1024 * 1024
+
+func benchGet(b *B, get func(c *Client) error) {
+	l := getServer(b, map[string][]byte{
+		"big": bytes.Repeat([]byte{'a'}, benchValueLen),
+	})
+	defer l.Close()
+	c, err := Dial("tcp", l.Addr().String())
+	require.Nil(b, err)
+	defer c.Close()
+
+	b.SetBytes(benchValueLen)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := get(c); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetCmd(b *B) {
+	benchGet(b, func(c *Client) error {
+		bb, err := c.Cmd("GET", "big").Bytes()
+		if err != nil {
+			return err
+		}
+		_, err = ioutil.Discard.Write(bb)
+		return err
+	})
+}
+
+func BenchmarkGetCmdTo(b *B) {
+	benchGet(b, func(c *Client) error {
+		_, err := c.CmdTo(ioutil.Discard, "GET", "big")
+		return err
+	})
+}
@@ -0,0 +1,59 @@
+package redis
+
+import "sync"
+
+// SyncClient wraps a single Client so that it may be used from multiple
+// go-routines at once. Each Cmd holds an internal lock for the whole of
+// writing the command and reading its response, so commands from different
+// go-routines are never interleaved on the connection.
+//
+// This is useful for small programs which only want one connection, but it
+// means only one command is ever in flight at a time: every go-routine waits
+// on the round trip of every other. When throughput matters a pool.Pool, which
+// gives each go-routine a connection of its own, will do much better.
+type SyncClient struct {
+	l sync.Mutex
+	c *Client
+}
+
+var _ Cmder = &SyncClient{}
+
+// NewSyncClient returns a SyncClient wrapping the given Client. The Client
+// shouldn't be used directly from then on, except from within Do.
+func NewSyncClient(c *Client) *SyncClient {
+	return &SyncClient{c: c}
+}
+
+// Cmd calls the given Redis command, like Client's Cmd
+func (sc *SyncClient) Cmd(cmd string, args ...interface{}) *Resp {
+	sc.l.Lock()
+	defer sc.l.Unlock()
+	return sc.c.Cmd(cmd, args...)
+}
+
+// Do calls fn with the underlying Client while holding the lock, so nothing
+// else uses the Client until fn returns. This is how pipelining is done with a
+// SyncClient, the whole of the pipeline is done within fn:
+//
+//	var rr []*redis.Resp
+//	sc.Do(func(c *redis.Client) {
+//		c.PipeAppend("GET", "foo")
+//		c.PipeAppend("GET", "bar")
+//		rr = append(rr, c.PipeResp(), c.PipeResp())
+//	})
+//
+// The Client must not be used once fn has returned. Any commands left in its
+// pipeline are cleared when fn returns, so they can't be mixed up with those
+// of a later call.
+func (sc *SyncClient) Do(fn func(*Client)) {
+	sc.l.Lock()
+	defer sc.l.Unlock()
+	defer sc.c.PipeClear()
+	fn(sc.c)
+}
+
+// Close closes the underlying Client's connection. It doesn't wait for
+// commands which are in flight.
+func (sc *SyncClient) Close() error {
+	return sc.c.Close()
+}
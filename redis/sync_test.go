@@ -0,0 +1,87 @@
+package redis
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoServer replies to every command with its last argument, so that a
+// response can be matched up with the command it's for
+func echoServer(t *T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	go serveEcho(l)
+	return l
+}
+
+// serveEcho accepts a single connection on the listener, replying to each
+// command with its last argument
+func serveEcho(l net.Listener) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	rr := NewRespReader(conn)
+	for {
+		args, err := rr.Read().ListBytes()
+		if err != nil {
+			return
+		}
+		NewResp(args[len(args)-1]).WriteTo(conn)
+	}
+}
+
+func testSyncClient(t *T, sc *SyncClient) {
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s := strconv.Itoa(i*1000 + j)
+				if j%10 == 0 {
+					var got []string
+					sc.Do(func(c *Client) {
+						c.PipeAppend("ECHO", s+"a")
+						c.PipeAppend("ECHO", s+"b")
+						for k := 0; k < 2; k++ {
+							s, _ := c.PipeResp().Str()
+							got = append(got, s)
+						}
+						// left over, and cleared by Do
+						c.PipeAppend("ECHO", "leftover")
+					})
+					assert.Equal(t, []string{s + "a", s + "b"}, got)
+					continue
+				}
+				got, err := sc.Cmd("ECHO", s).Str()
+				assert.Nil(t, err)
+				assert.Equal(t, s, got)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestSyncClient(t *T) {
+	sc := NewSyncClient(dial(t))
+	defer sc.Close()
+	testSyncClient(t, sc)
+}
+
+func TestSyncClientFake(t *T) {
+	l := echoServer(t)
+	defer l.Close()
+	c, err := DialTimeout("tcp", l.Addr().String(), 5*time.Second)
+	require.Nil(t, err)
+	sc := NewSyncClient(c)
+	defer sc.Close()
+	testSyncClient(t, sc)
+}
@@ -0,0 +1,64 @@
+package redis
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// The directions which prefix each chunk written to a DialOpts' WireTap
+const (
+	WireTapRead  = '<'
+	WireTapWrite = '>'
+)
+
+// tapConn copies everything read from and written to the net.Conn it wraps to
+// a WireTap. Each Read or Write is written as a line holding its direction,
+// the time as RFC3339Nano and the number of bytes, followed by the bytes
+// themselves and a newline, e.g.
+//
+//	> 2017-06-01T12:00:00.000000001Z 14
+//	*1\r\n$4\r\nPING\r\n
+//	< 2017-06-01T12:00:00.000200001Z 7
+//	+PONG\r\n
+//
+// See redistest's ReadWireTap for reading it back.
+type tapConn struct {
+	net.Conn
+
+	// l is held while writing a chunk, so that chunks from a concurrent Read
+	// and Write aren't interleaved
+	l   sync.Mutex
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+func (tc *tapConn) tap(dir byte, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	tc.l.Lock()
+	defer tc.l.Unlock()
+	tc.buf.Reset()
+	fmt.Fprintf(&tc.buf, "%c %s %d\n", dir, time.Now().UTC().Format(time.RFC3339Nano), len(b))
+	tc.buf.Write(b)
+	tc.buf.WriteByte('\n')
+	// the tap is only for debugging, so failing to write to it doesn't fail
+	// the connection
+	tc.w.Write(tc.buf.Bytes())
+}
+
+func (tc *tapConn) Read(b []byte) (int, error) {
+	n, err := tc.Conn.Read(b)
+	tc.tap(WireTapRead, b[:n])
+	return n, err
+}
+
+func (tc *tapConn) Write(b []byte) (int, error) {
+	n, err := tc.Conn.Write(b)
+	tc.tap(WireTapWrite, b[:n])
+	return n, err
+}
@@ -0,0 +1,39 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"regexp"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWireTap(t *T) {
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		rr := NewRespReader(server)
+		for !rr.Read().IsType(IOErr) {
+			NewRespSimple("PONG").WriteTo(server)
+		}
+	}()
+
+	var buf bytes.Buffer
+	c, err := DialCtx(context.Background(), "tcp", "fake", DialOpts{
+		NetDial: func(context.Context, string, string) (net.Conn, error) {
+			return client, nil
+		},
+		WireTap: &buf,
+	})
+	require.Nil(t, err)
+	defer c.Close()
+
+	s, err := c.Cmd("PING").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "PONG", s)
+	re := regexp.MustCompile(`^> \S+ 14\n\*1\r\n\$4\r\nPING\r\n\n< \S+ 7\n\+PONG\r\n\n$`)
+	assert.True(t, re.MatchString(buf.String()), "%q", buf.String())
+}
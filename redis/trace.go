@@ -0,0 +1,66 @@
+package redis
+
+import "time"
+
+// Trace holds functions which are called as connections are made and commands
+// are run, e.g. to record metrics. Any of them may be nil. A Client only does
+// any extra work for a Trace, such as looking at the time, if it has one, so
+// Clients without one aren't slowed down at all.
+//
+// The functions are called synchronously, from whichever go-routine is
+// running the command (or, for AsyncClient, reading its reply), so they should
+// be quick. They may be called from multiple go-routines at once.
+//
+// A Trace is given to a Client with DialOpts or SetTrace, and pool.Pool,
+// cluster.Cluster and sentinel.Client each take one in their Opts, which they
+// set on every connection they make.
+type Trace struct {
+	// Called once a connection has been made and set up, or has failed to be,
+	// with the address, how long it took and the error, if any
+	Connect func(addr string, took time.Duration, err error)
+
+	// Called as each command is sent, with its name and its first key (see
+	// KeyFromArgs), or "" if it has none. Each of the commands in a pipeline
+	// is reported separately, as it's appended.
+	CmdStart func(cmd, key string)
+
+	// Called once each command's reply has been read, with the command's
+	// name, the reply's error, if any, and how long it took from being sent.
+	// For commands in a pipeline this includes the time it took to read the
+	// replies to the commands before it.
+	CmdDone func(cmd string, err error, took time.Duration)
+
+	// Called by pool.Pool each time a connection is gotten from it, with how
+	// long it took, including any wait because MaxActive was reached and any
+	// time spent making a new connection, and the error, if any
+	PoolGet func(took time.Duration, err error)
+}
+
+// SetTrace sets the Trace of the Client, which may be nil to remove one. It
+// must not be called while the Client is in use.
+func (c *Client) SetTrace(t *Trace) {
+	c.trace = t
+}
+
+// Trace returns the Trace of the Client, or nil if it has none
+func (c *Client) Trace() *Trace {
+	return c.trace
+}
+
+// traceStart calls the CmdStart hook, if there is one, and returns the time to
+// pass into traceDone. It must only be called if the Client has a Trace.
+func (c *Client) traceStart(cmd string, args []interface{}) time.Time {
+	if c.trace.CmdStart != nil {
+		key, _ := KeyFromArgs(args...)
+		c.trace.CmdStart(cmd, key)
+	}
+	return time.Now()
+}
+
+// traceDone calls the CmdDone hook, if there is one. It must only be called if
+// the Client has a Trace.
+func (c *Client) traceDone(cmd string, r *Resp, start time.Time) {
+	if c.trace.CmdDone != nil {
+		c.trace.CmdDone(cmd, r.Err, time.Since(start))
+	}
+}
@@ -0,0 +1,126 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// traceRecorder records the commands a Trace is told about
+type traceRecorder struct {
+	l            sync.Mutex
+	starts, done []string
+	errs         []error
+}
+
+func (tr *traceRecorder) trace() *Trace {
+	return &Trace{
+		CmdStart: func(cmd, key string) {
+			tr.l.Lock()
+			defer tr.l.Unlock()
+			tr.starts = append(tr.starts, cmd+" "+key)
+		},
+		CmdDone: func(cmd string, err error, took time.Duration) {
+			tr.l.Lock()
+			defer tr.l.Unlock()
+			tr.done = append(tr.done, cmd)
+			tr.errs = append(tr.errs, err)
+		},
+	}
+}
+
+func (tr *traceRecorder) get() ([]string, []string) {
+	tr.l.Lock()
+	defer tr.l.Unlock()
+	return tr.starts, tr.done
+}
+
+func tracedEchoClient(t *T) (*Client, *traceRecorder) {
+	l := echoServer(t)
+	c, err := Dial("tcp", l.Addr().String())
+	require.Nil(t, err)
+	l.Close()
+	tr := new(traceRecorder)
+	c.SetTrace(tr.trace())
+	return c, tr
+}
+
+func TestTraceCmd(t *T) {
+	c, tr := tracedEchoClient(t)
+	defer c.Close()
+
+	require.Nil(t, c.Cmd("ECHO", "foo").Err)
+	c.PipeAppend("GET", "a")
+	c.PipeAppend("GET", "b")
+	require.Nil(t, c.PipeResp().Err)
+	require.Nil(t, c.PipeResp().Err)
+
+	p := NewPipeline(c, PipelineOpts{})
+	p.Append("SET", "c", "1")
+	p.Append("PING")
+	p.Flush()
+
+	starts, done := tr.get()
+	assert.Equal(t, []string{"ECHO foo", "GET a", "GET b", "SET c", "PING "}, starts)
+	assert.Equal(t, []string{"ECHO", "GET", "GET", "SET", "PING"}, done)
+	assert.Equal(t, []error{nil, nil, nil, nil, nil}, tr.errs)
+
+	// a failed connection is reported as each command's error
+	c.Close()
+	assert.NotNil(t, c.Cmd("GET", "d").Err)
+	_, done = tr.get()
+	assert.Len(t, done, 6)
+	assert.NotNil(t, tr.errs[5])
+}
+
+func TestTraceAsync(t *T) {
+	c, tr := tracedEchoClient(t)
+	ac := NewAsyncClient(c)
+	defer ac.Close()
+	require.Nil(t, ac.Cmd("GET", "a").Err)
+
+	c2, tr2 := tracedEchoClient(t)
+	pc := NewPipeliningClient(c2, PipeliningOpts{})
+	defer pc.Close()
+	require.Nil(t, pc.Cmd("GET", "b").Err)
+
+	starts, done := tr.get()
+	assert.Equal(t, []string{"GET a"}, starts)
+	assert.Equal(t, []string{"GET"}, done)
+	starts, done = tr2.get()
+	assert.Equal(t, []string{"GET b"}, starts)
+	assert.Equal(t, []string{"GET"}, done)
+}
+
+func TestTraceConnect(t *T) {
+	l := echoServer(t)
+	defer l.Close()
+
+	var addrs []string
+	var errs []error
+	trace := &Trace{Connect: func(addr string, took time.Duration, err error) {
+		addrs = append(addrs, addr)
+		errs = append(errs, err)
+	}}
+	c, err := DialCtx(context.Background(), "tcp", l.Addr().String(), DialOpts{Trace: trace})
+	require.Nil(t, err)
+	defer c.Close()
+	assert.Equal(t, trace, c.Trace())
+
+	dialErr := errors.New("can't connect")
+	_, err = DialCtx(context.Background(), "tcp", "foo:6379", DialOpts{
+		Trace: trace,
+		NetDial: func(context.Context, string, string) (net.Conn, error) {
+			return nil, dialErr
+		},
+	})
+	assert.Equal(t, dialErr, err)
+	assert.Equal(t, []string{l.Addr().String(), "foo:6379"}, addrs)
+	assert.Equal(t, []error{nil, dialErr}, errs)
+}
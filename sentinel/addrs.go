@@ -0,0 +1,150 @@
+package sentinel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/log"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// How often the addresses of the other sentinels are fetched again
+const sentinelAddrsInterval = time.Minute
+
+// dialSentinel connects to the first of the known sentinels which can be
+// reached, starting with the one which was last connected to. If that isn't
+// the first the list is rotated so that it is from then on, keeping the order
+// the others are tried in.
+func (c *Client) dialSentinel() (*redis.Client, error) {
+	c.addrsL.Lock()
+	addrs := c.addrs
+	c.addrsL.Unlock()
+
+	err := errors.New("no sentinel addresses")
+	for i, addr := range addrs {
+		var conn *redis.Client
+		if conn, err = c.dialSentinelAddr(addr); err != nil {
+			continue
+		}
+		if i > 0 {
+			rotated := make([]string, 0, len(addrs))
+			rotated = append(rotated, addrs[i:]...)
+			rotated = append(rotated, addrs[:i]...)
+			c.addrsL.Lock()
+			c.addrs = rotated
+			c.addrsL.Unlock()
+		}
+		return conn, nil
+	}
+	return nil, err
+}
+
+// dialSentinelAddr connects to the sentinel at the given address. Errors say
+// which sentinel they're from, since a failure to AUTH with a sentinel would
+// otherwise look the same as one with a master.
+func (c *Client) dialSentinelAddr(addr string) (*redis.Client, error) {
+	conn, err := redis.DialCtx(context.Background(), c.network, addr, c.sentinelDialOpts)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to sentinel at %s: %s", addr, err)
+	}
+	return conn, nil
+}
+
+// sentinelAddr returns the address of the sentinel which was last connected to
+func (c *Client) sentinelAddr() string {
+	c.addrsL.Lock()
+	defer c.addrsL.Unlock()
+	if len(c.addrs) == 0 {
+		return ""
+	}
+	return c.addrs[0]
+}
+
+// runID returns the run ID of the instance conn is connected to
+func runID(conn redis.Cmder) (string, error) {
+	info, err := conn.Cmd("INFO", "server").Str()
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(info, "\n") {
+		if strings.HasPrefix(line, "run_id:") {
+			return strings.TrimSpace(line[len("run_id:"):]), nil
+		}
+	}
+	return "", errors.New("run_id not found in INFO")
+}
+
+// sentinelAddrs returns the address of every sentinel monitoring any of the
+// given masters, as reported by the sentinel conn is connected to, starting
+// with that one's address. Sentinels are told apart by their run ID, so one
+// which is known by more than one address is only returned once.
+func sentinelAddrs(conn redis.Cmder, addr string, names []string) ([]string, error) {
+	id, err := runID(conn)
+	if err != nil {
+		return nil, err
+	}
+	seenIDs := map[string]bool{id: true}
+	seenAddrs := map[string]bool{addr: true}
+	addrs := []string{addr}
+	for _, name := range names {
+		nis, err := parseNodeInfos(conn.Cmd("SENTINEL", "SENTINELS", name))
+		if err != nil {
+			return nil, err
+		}
+		for _, ni := range nis {
+			if seenAddrs[ni.Addr] || (ni.RunID != "" && seenIDs[ni.RunID]) {
+				continue
+			}
+			seenIDs[ni.RunID] = true
+			seenAddrs[ni.Addr] = true
+			addrs = append(addrs, ni.Addr)
+		}
+	}
+	return addrs, nil
+}
+
+// refreshAddrs replaces the known sentinel addresses with those reported by
+// the sentinel conn is connected to
+func (c *Client) refreshAddrs(conn *redis.Client) error {
+	if len(c.names) == 0 {
+		// there's nothing to ask about
+		return nil
+	}
+	addrs, err := sentinelAddrs(conn, conn.Addr, c.names)
+	if err != nil {
+		return err
+	}
+	c.addrsL.Lock()
+	c.addrs = addrs
+	c.addrsL.Unlock()
+	return nil
+}
+
+// addrsSpin periodically fetches the addresses of the other sentinels, until
+// the Client is closed
+func (c *Client) addrsSpin() {
+	t := time.NewTicker(sentinelAddrsInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-c.closeCh:
+			return
+		}
+
+		conn, err := c.dialSentinel()
+		if err == nil {
+			err = c.refreshAddrs(conn)
+			conn.Close()
+		}
+		if err != nil {
+			c.logger.Log(log.Warn, "refreshing sentinel addresses failed", log.KV{
+				log.KeyOperation: "sentinels",
+				log.KeyErr:       err,
+			})
+		}
+	}
+}
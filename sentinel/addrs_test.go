@@ -0,0 +1,82 @@
+package sentinel
+
+import (
+	"net"
+	. "testing"
+
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sentinelFields(addr, runID string) map[string]string {
+	m := nodeFields(addr, "sentinel")
+	m["runid"] = runID
+	return m
+}
+
+func TestSentinelAddrs(t *T) {
+	fs := newFakeSentinel(t)
+	defer fs.Close()
+	fs.runID = "a"
+	fs.sentinels = []map[string]string{
+		sentinelFields("127.0.0.1:1", "b"),
+		// the same sentinel under another address
+		sentinelFields("127.0.0.2:1", "b"),
+		// and the one being asked, which sentinel wouldn't normally return
+		sentinelFields("127.0.0.2:2", "a"),
+		sentinelFields("127.0.0.1:3", "c"),
+	}
+
+	conn, err := redis.Dial("tcp", fs.Addr().String())
+	require.Nil(t, err)
+	defer conn.Close()
+	addrs, err := sentinelAddrs(conn, fs.Addr().String(), []string{"test", "test2"})
+	require.Nil(t, err)
+	assert.Equal(t, []string{fs.Addr().String(), "127.0.0.1:1", "127.0.0.1:3"}, addrs)
+}
+
+func TestSentinelFailover(t *T) {
+	fs1, fs2 := newFakeSentinel(t), newFakeSentinel(t)
+	defer fs2.Close()
+	fs1.runID, fs2.runID = "a", "b"
+	fs1.sentinels = []map[string]string{sentinelFields(fs2.Addr().String(), "b")}
+	fs2.sentinels = []map[string]string{sentinelFields(fs1.Addr().String(), "a")}
+	m1, m2 := listen(t), listen(t)
+	fs1.setMaster(m1)
+	fs2.setMaster(m2)
+
+	// the first seed address can't be reached, and the second sentinel is
+	// learned of from the first
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	dead := l.Addr().String()
+	l.Close()
+	c, err := NewClientWithOpts(Opts{
+		Network:  "tcp",
+		Addr:     dead,
+		Addrs:    []string{fs1.Addr().String()},
+		Names:    []string{"test"},
+		PoolSize: 1,
+	})
+	require.Nil(t, err)
+	defer c.Close()
+	assert.Equal(t, fs1.Addr().String(), c.sentinelAddr())
+
+	masterAddr := func() string {
+		conn, err := c.GetMaster("test")
+		require.Nil(t, err)
+		defer c.PutMaster("test", conn)
+		return conn.Addr
+	}
+	assert.Equal(t, m1, masterAddr())
+
+	// once the first sentinel goes away the second one is used
+	fs1.Close()
+	fs1.killSubs()
+	waitFor(t, func() bool { return masterAddr() == m2 })
+	assert.Equal(t, fs2.Addr().String(), c.sentinelAddr())
+	c.addrsL.Lock()
+	assert.Equal(t, []string{fs2.Addr().String(), fs1.Addr().String()}, c.addrs)
+	c.addrsL.Unlock()
+}
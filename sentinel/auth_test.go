@@ -0,0 +1,92 @@
+package sentinel
+
+import (
+	"errors"
+	"net"
+	"strings"
+	. "testing"
+
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// authMaster requires AUTH with the given password, and replies to DB with the
+// database which was last selected
+func authMaster(t *T, password string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				rr := redis.NewRespReader(conn)
+				var authed bool
+				var db string
+				for {
+					args, err := rr.Read().List()
+					if err != nil {
+						return
+					}
+					var r interface{}
+					switch cmd := strings.ToUpper(args[0]); {
+					case cmd == "AUTH" && args[1] == password:
+						authed, r = true, "OK"
+					case cmd == "AUTH":
+						r = errors.New("WRONGPASS invalid password")
+					case !authed:
+						r = errors.New("NOAUTH Authentication required")
+					case cmd == "SELECT":
+						db, r = args[1], "OK"
+					case cmd == "DB":
+						r = db
+					default:
+						r = errors.New("ERR unknown command")
+					}
+					redis.NewResp(r).WriteTo(conn)
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestAuth(t *T) {
+	fs := newFakeSentinel(t)
+	defer fs.Close()
+	fs.password = "sentinelpass"
+	fs.setMaster(authMaster(t, "masterpass"))
+
+	o := Opts{
+		Network:          "tcp",
+		Addr:             fs.Addr().String(),
+		Names:            []string{"test"},
+		PoolSize:         1,
+		SentinelDialOpts: redis.DialOpts{Password: "wrong"},
+		MasterDialOpts:   redis.DialOpts{Password: "wrong", DB: 3},
+	}
+	_, err := NewClientWithOpts(o)
+	require.NotNil(t, err)
+	assert.True(t, err.(*ClientError).SentinelErr)
+	assert.Contains(t, err.Error(), "sentinel")
+	assert.Contains(t, err.Error(), "WRONGPASS")
+
+	o.SentinelDialOpts.Password = "sentinelpass"
+	_, err = NewClientWithOpts(o)
+	require.NotNil(t, err)
+	assert.False(t, err.(*ClientError).SentinelErr)
+	assert.Contains(t, err.Error(), `master "test"`)
+	assert.Contains(t, err.Error(), "WRONGPASS")
+
+	o.MasterDialOpts.Password = "masterpass"
+	c, err := NewClientWithOpts(o)
+	require.Nil(t, err)
+	defer c.Close()
+	db, err := c.Master("test").Cmd("DB").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "3", db)
+}
@@ -0,0 +1,121 @@
+package sentinel
+
+import (
+	"strings"
+
+	"github.com/mediocregopher/radix.v2/pubsub"
+)
+
+// Event is a single event published by sentinel, e.g. "+sdown" or
+// "+switch-master". See the sentinel documentation for the full list.
+type Event struct {
+	// The name of the event, which is the channel it was published on, e.g.
+	// "+odown"
+	Event string
+
+	// The name of the master the event is about, and the role ("master",
+	// "slave" or "sentinel") and address of the instance it's about. For
+	// +switch-master Addr is the address of the new master. These are empty if
+	// the event's payload isn't about an instance, e.g. for +tilt.
+	MasterName, Role, Addr string
+
+	// Whatever follows the instance details in the payload, e.g. "#quorum
+	// 2/2" for +odown. For +switch-master this is the address of the old
+	// master. For events whose payload couldn't be parsed this is the whole
+	// payload.
+	Details string
+
+	// The payload exactly as sentinel published it
+	Raw string
+
+	// Set on the last Event sent before the channel is closed, if it was
+	// closed because of an error from the Subscriber
+	Err error
+}
+
+var instanceRoles = map[string]bool{
+	"master":   true,
+	"slave":    true,
+	"sentinel": true,
+}
+
+// parseEvent parses a payload in the instance details format sentinel uses for
+// most events:
+//
+//	<instance-type> <name> <ip> <port> @ <master-name> <master-ip> <master-port> [details]
+//
+// where the part from the @ on is left off if the instance is a master.
+func parseEvent(event, payload string) Event {
+	e := Event{Event: event, Raw: payload, Details: payload}
+	fields := strings.Fields(payload)
+
+	if event == "+switch-master" {
+		if len(fields) == 5 {
+			e.MasterName = fields[0]
+			e.Role = "master"
+			e.Addr = fields[3] + ":" + fields[4]
+			e.Details = fields[1] + ":" + fields[2]
+		}
+		return e
+	}
+
+	if len(fields) < 4 || !instanceRoles[fields[0]] {
+		return e
+	}
+	e.Role = fields[0]
+	e.MasterName = fields[1]
+	e.Addr = fields[2] + ":" + fields[3]
+	rest := fields[4:]
+	if len(rest) >= 4 && rest[0] == "@" {
+		e.MasterName = rest[1]
+		rest = rest[4:]
+	}
+	e.Details = strings.Join(rest, " ")
+	return e
+}
+
+// Events PSubscribes the Subscriber, which should be connected to a sentinel,
+// to every channel, and delivers each event published by sentinel on the
+// returned channel. If any names are given only events about masters with
+// those names, or events which aren't about a master at all, are delivered.
+// Event types which aren't known to this package are still delivered, with
+// their payload parsed as far as possible.
+//
+// The Subscriber shouldn't be used for anything else once passed in. The
+// returned channel is closed when the Subscriber returns an error other than
+// a timeout, for example because its connection was closed. The last Event
+// sent will have that error as its Err. The channel is unbuffered, so events
+// must be read from it promptly.
+func Events(sub pubsub.Subscriber, names ...string) (<-chan Event, error) {
+	if r := sub.PSubscribe("*"); r.Err != nil {
+		return nil, r.Err
+	}
+
+	filter := map[string]bool{}
+	for _, name := range names {
+		filter[name] = true
+	}
+
+	ch := make(chan Event)
+	go func() {
+		defer close(ch)
+		for {
+			r := sub.Receive()
+			if r.Timeout() {
+				continue
+			} else if r.Err != nil {
+				ch <- Event{Err: r.Err}
+				return
+			} else if r.Type != pubsub.Message {
+				continue
+			}
+
+			e := parseEvent(r.Channel, r.Message)
+			if len(filter) > 0 && e.MasterName != "" && !filter[e.MasterName] {
+				continue
+			}
+			ch <- e
+		}
+	}()
+	return ch, nil
+}
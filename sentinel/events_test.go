@@ -0,0 +1,76 @@
+package sentinel
+
+import (
+	. "testing"
+
+	"github.com/mediocregopher/radix.v2/pubsub/pubsubtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEvent(t *T) {
+	for _, test := range []struct {
+		event, payload string
+		out            Event
+	}{
+		{
+			"+sdown", "master test 127.0.0.1 8000",
+			Event{MasterName: "test", Role: "master", Addr: "127.0.0.1:8000"},
+		},
+		{
+			"+odown", "master test 127.0.0.1 8000 #quorum 2/2",
+			Event{MasterName: "test", Role: "master", Addr: "127.0.0.1:8000", Details: "#quorum 2/2"},
+		},
+		{
+			"+slave", "slave 127.0.0.1:8001 127.0.0.1 8001 @ test 127.0.0.1 8000",
+			Event{MasterName: "test", Role: "slave", Addr: "127.0.0.1:8001"},
+		},
+		{
+			"+failover-state-select-slave", "master test 127.0.0.1 8000",
+			Event{MasterName: "test", Role: "master", Addr: "127.0.0.1:8000"},
+		},
+		{
+			"+switch-master", "test 127.0.0.1 8000 127.0.0.1 8001",
+			Event{MasterName: "test", Role: "master", Addr: "127.0.0.1:8001", Details: "127.0.0.1:8000"},
+		},
+		{
+			"+tilt", "#tilt mode entered",
+			Event{Details: "#tilt mode entered"},
+		},
+		{
+			"+some-new-event", "whatever format",
+			Event{Details: "whatever format"},
+		},
+	} {
+		test.out.Event = test.event
+		test.out.Raw = test.payload
+		assert.Equal(t, test.out, parseEvent(test.event, test.payload))
+	}
+}
+
+func TestEvents(t *T) {
+	sub := pubsubtest.NewSubClient()
+	ch, err := Events(sub, "test")
+	require.Nil(t, err)
+
+	sub.Publish("+sdown", "master other 127.0.0.1 9000")
+	sub.Publish("+sdown", "master test 127.0.0.1 8000")
+	sub.Publish("+tilt", "#tilt mode entered")
+	sub.QueueTimeout()
+	sub.Publish("+slave", "slave 127.0.0.1:8001 127.0.0.1 8001 @ test 127.0.0.1 8000")
+
+	e := <-ch
+	assert.Equal(t, "+sdown", e.Event)
+	assert.Equal(t, "test", e.MasterName)
+	e = <-ch
+	assert.Equal(t, "+tilt", e.Event)
+	e = <-ch
+	assert.Equal(t, "+slave", e.Event)
+	assert.Equal(t, "127.0.0.1:8001", e.Addr)
+
+	sub.Drop()
+	e = <-ch
+	assert.NotNil(t, e.Err)
+	_, ok := <-ch
+	assert.False(t, ok)
+}
@@ -0,0 +1,228 @@
+package sentinel
+
+import (
+	"errors"
+
+	"github.com/mediocregopher/radix.v2/log"
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// replicaSet is the pools for the healthy replicas of a single master, which
+// are handed out in turn
+type replicaSet struct {
+	pools []*pool.Pool
+	next  int
+}
+
+// call runs fn in the Client's spin go-routine and waits for it to return. It
+// returns false, without fn being run, if the Client has been closed.
+func (c *Client) call(fn func(*Client)) bool {
+	doneCh := make(chan struct{})
+	select {
+	case c.callCh <- func(c *Client) {
+		fn(c)
+		close(doneCh)
+	}:
+	case <-c.closeCh:
+		return false
+	}
+	<-doneCh
+	return true
+}
+
+// healthyReplica returns whether sentinel considers the replica usable
+func healthyReplica(ni NodeInfo) bool {
+	return !ni.HasFlag("s_down") && !ni.HasFlag("o_down") && !ni.HasFlag("disconnected")
+}
+
+// refreshReplicas fetches the replicas of the given master from sentinel, over
+// a new connection, and replaces the Client's pools for them with pools for
+// those which are healthy. Only one refresh happens at a time, so that an
+// older set of replicas can't replace a newer one.
+func (c *Client) refreshReplicas(name string) error {
+	c.replicaL.Lock()
+	defer c.replicaL.Unlock()
+
+	conn, err := c.dialSentinel()
+	if err != nil {
+		return &ClientError{err: err, SentinelErr: true}
+	}
+	defer conn.Close()
+
+	// SLAVES is used rather than REPLICAS, as in Topology, since the latter
+	// only exists from redis 5
+	nis, err := parseNodeInfos(conn.Cmd("SENTINEL", "SLAVES", name))
+	if err != nil {
+		return &ClientError{err: err, SentinelErr: true}
+	}
+	var addrs []string
+	for _, ni := range nis {
+		if healthyReplica(ni) {
+			addrs = append(addrs, ni.Addr)
+		}
+	}
+	c.call(func(c *Client) { c.setReplicas(name, addrs) })
+	return nil
+}
+
+// reloadReplicas is called when sentinel announces a change to the replicas
+// of the given master, and refreshes them if they're in use
+func (c *Client) reloadReplicas(name string) {
+	var loaded bool
+	c.call(func(c *Client) { _, loaded = c.replicas[name] })
+	if !loaded {
+		return
+	}
+	if err := c.refreshReplicas(name); err != nil {
+		c.logger.Log(log.Warn, "refreshing replicas failed", log.KV{
+			log.KeyOperation: "replicas",
+			log.KeyMaster:    name,
+			log.KeyErr:       err,
+		})
+	}
+}
+
+// replicaEvent is called by subSpin with every event other than
+// +switch-master. A replica going down is taken out of the rotation straight
+// away, anything else means the replicas are fetched again.
+func (c *Client) replicaEvent(e Event) {
+	if e.Role != "slave" || e.MasterName == "" {
+		return
+	}
+	switch e.Event {
+	case "+sdown":
+		c.call(func(c *Client) { c.removeReplica(e.MasterName, e.Addr) })
+	case "+slave", "-sdown":
+		go c.reloadReplicas(e.MasterName)
+	}
+}
+
+// setReplicas is called in spin, and makes the given addresses the replicas of
+// the given master, keeping the pools for any which were already replicas. The
+// address of the master itself is left out, in case a replica was promoted
+// since sentinel was asked.
+func (c *Client) setReplicas(name string, addrs []string) {
+	old := map[string]*pool.Pool{}
+	rs, ok := c.replicas[name]
+	if ok {
+		for _, p := range rs.pools {
+			old[p.Addr] = p
+		}
+	} else {
+		rs = new(replicaSet)
+		c.replicas[name] = rs
+	}
+
+	masterAddr := c.lazyAddrs[name]
+	if p := c.masterPools[name]; p != nil {
+		masterAddr = p.Addr
+	}
+
+	rs.pools = rs.pools[:0]
+	for _, addr := range addrs {
+		if addr == masterAddr {
+			continue
+		} else if p, ok := old[addr]; ok {
+			rs.pools = append(rs.pools, p)
+			delete(old, addr)
+			continue
+		}
+		// as with a new master's pool, the pool is still usable if its initial
+		// connections couldn't be made
+		p, err := c.newPool(addr)
+		if err != nil {
+			c.logger.Log(log.Warn, "connecting to replica failed", log.KV{
+				log.KeyOperation: "replicas",
+				log.KeyMaster:    name,
+				log.KeyAddr:      addr,
+				log.KeyErr:       err,
+			})
+		}
+		rs.pools = append(rs.pools, p)
+	}
+	for _, p := range old {
+		p.Close()
+	}
+}
+
+// removeReplica is called in spin, and takes the replica with the given
+// address out of the rotation for the given master
+func (c *Client) removeReplica(name, addr string) {
+	rs, ok := c.replicas[name]
+	if !ok {
+		return
+	}
+	for i, p := range rs.pools {
+		if p.Addr == addr {
+			p.Close()
+			rs.pools = append(rs.pools[:i], rs.pools[i+1:]...)
+			return
+		}
+	}
+}
+
+// GetReplica retrieves a connection to one of the healthy replicas of the
+// master of the given name, for commands which only read. Each call uses the
+// next replica in turn. If the master has no healthy replicas a connection to
+// the master is returned instead. The connection should be returned with
+// PutReplica. The returned error is a *ClientError.
+//
+// The replicas are fetched from sentinel the first time this is called for a
+// name, and pools of connections to them are kept from then on. Sentinel's
+// announcements of replicas being added, going down or coming back up cause
+// them to be fetched again, and a replica which is promoted to master is taken
+// out of the rotation as soon as the failover is seen.
+//
+// Replication is asynchronous, so a replica may not have a write which was
+// just made on the master.
+func (c *Client) GetReplica(name string) (*redis.Client, error) {
+	var known, loaded bool
+	if !c.call(func(c *Client) {
+		known = c.knownName(name)
+		_, loaded = c.replicas[name]
+	}) {
+		return nil, &ClientError{err: ErrClosed}
+	} else if !known {
+		return nil, &ClientError{err: errors.New("unknown name: " + name)}
+	} else if !loaded {
+		if err := c.refreshReplicas(name); err != nil {
+			return nil, err
+		}
+	}
+
+	var p *pool.Pool
+	c.call(func(c *Client) {
+		p, _ = c.masterPool(name)
+		if rs := c.replicas[name]; rs != nil && len(rs.pools) > 0 {
+			rs.next = (rs.next + 1) % len(rs.pools)
+			p = rs.pools[rs.next]
+		}
+	})
+	if p == nil {
+		return nil, &ClientError{err: ErrClosed}
+	}
+	return c.getConn(p)
+}
+
+// PutReplica returns a connection retrieved with GetReplica. If the replica
+// it's for has since been taken out of the rotation the connection is closed.
+func (c *Client) PutReplica(name string, client *redis.Client) {
+	select {
+	case c.putCh <- &putReq{name: name, conn: client, replica: true}:
+	case <-c.doneCh:
+		client.Close()
+	}
+}
+
+// DoReplica gets a connection using GetReplica, calls fn with it, and puts it
+// back with PutReplica. fn must not use the connection after returning. The
+// error from GetReplica, if any, is returned, otherwise fn's error is.
+func (c *Client) DoReplica(name string, fn func(*redis.Client) error) error {
+	conn, err := c.GetReplica(name)
+	if err != nil {
+		return err
+	}
+	defer c.PutReplica(name, conn)
+	return fn(conn)
+}
@@ -0,0 +1,107 @@
+package sentinel
+
+import (
+	"net"
+	. "testing"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/log"
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func listen(t *T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	// connections complete without being accepted, which is all the pools need
+	return l.Addr().String()
+}
+
+func TestHealthyReplica(t *T) {
+	assert.True(t, healthyReplica(NodeInfo{Flags: []string{"slave"}}))
+	assert.False(t, healthyReplica(NodeInfo{Flags: []string{"slave", "s_down"}}))
+	assert.False(t, healthyReplica(NodeInfo{Flags: []string{"slave", "o_down"}}))
+	assert.False(t, healthyReplica(NodeInfo{Flags: []string{"slave", "disconnected"}}))
+}
+
+func TestReplicas(t *T) {
+	fs := newFakeSentinel(t)
+	defer fs.Close()
+	m, r1, r2, r3 := listen(t), listen(t), listen(t), listen(t)
+	fs.setReplicas([2]string{"", r1}, [2]string{"", r2}, [2]string{"s_down", r3})
+
+	c := &Client{
+		network:        "tcp",
+		addrs:          []string{fs.Addr().String()},
+		masterPools:    map[string]*pool.Pool{},
+		lastFailover:   map[string]time.Time{},
+		replicas:       map[string]*replicaSet{},
+		poolOpts:       pool.Opts{Network: "tcp", Size: 1},
+		logger:         log.Nop,
+		putCh:          make(chan *putReq),
+		callCh:         make(chan func(*Client)),
+		closeCh:        make(chan struct{}),
+		doneCh:         make(chan struct{}),
+		switchMasterCh: make(chan *switchMaster),
+	}
+	var err error
+	c.masterPools["test"], err = c.newPool(m)
+	require.Nil(t, err)
+	go c.spin()
+
+	addrs := func(n int) []string {
+		var addrs []string
+		for i := 0; i < n; i++ {
+			conn, err := c.GetReplica("test")
+			require.Nil(t, err)
+			addrs = append(addrs, conn.Addr)
+			c.PutReplica("test", conn)
+		}
+		return addrs
+	}
+
+	// the replica which is down is never used, the others are used in turn
+	got := addrs(4)
+	assert.NotEqual(t, got[0], got[1])
+	assert.Contains(t, []string{r1, r2}, got[0])
+	assert.Contains(t, []string{r1, r2}, got[1])
+	assert.Equal(t, got[:2], got[2:])
+
+	// a replica going down is taken out straight away
+	c.replicaEvent(parseEvent("+sdown", "slave "+r1+" "+hostPort(r1)+" @ test "+hostPort(m)))
+	assert.Equal(t, []string{r2, r2}, addrs(2))
+
+	// so is one which is promoted, and once there are no replicas left the
+	// master is used
+	fs.setReplicas([2]string{"s_down", r1})
+	c.switchMasterCh <- &switchMaster{name: "test", addr: r2}
+	assert.Equal(t, []string{r2, r2}, addrs(2))
+
+	// replicas coming back up are used again
+	fs.setReplicas([2]string{"", r1}, [2]string{"", r3})
+	c.replicaEvent(parseEvent("-sdown", "slave "+r1+" "+hostPort(r1)+" @ test "+hostPort(r2)))
+	waitFor(t, func() bool {
+		got := addrs(2)
+		return assert.ObjectsAreEqual([]string{r1, r3}, got) ||
+			assert.ObjectsAreEqual([]string{r3, r1}, got)
+	})
+
+	_, err = c.GetReplica("dne")
+	assert.NotNil(t, err)
+}
+
+func hostPort(addr string) string {
+	host, port, _ := net.SplitHostPort(addr)
+	return host + " " + port
+}
+
+func waitFor(t *T, fn func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !fn() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
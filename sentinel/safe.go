@@ -0,0 +1,78 @@
+package sentinel
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// ErrFailedOver is returned from DoSafe and CmdSafe when the master failed over
+// while the write was being made or waited on. The write may or may not have
+// made it to the new master, and the WAIT, if it was made, was only on the old
+// one, so nothing is known about whether the write is safe.
+var ErrFailedOver = errors.New("master failed over during the write")
+
+// DoSafe gets a connection to the master of the given name and calls fn with
+// it. If fn returns no error then WAIT is called on that same connection, so
+// that DoSafe only returns nil once at least numReplicas replicas have
+// acknowledged every write fn made. If they haven't within the timeout a
+// *pool.InsufficientReplicasError is returned. A timeout of zero waits forever.
+//
+// This protects writes against being lost by a failover which happens right
+// after they were made. If the Client sees a failover happen at any point
+// before DoSafe returns, ErrFailedOver is returned instead of the result of
+// the WAIT. The connection is put back once DoSafe is done, and fn must not
+// use it after returning.
+func (c *Client) DoSafe(
+	name string, numReplicas int, timeout time.Duration, fn func(*redis.Client) error,
+) error {
+	before, err := c.failoverTime(name)
+	if err != nil {
+		return err
+	}
+	conn, err := c.GetMaster(name)
+	if err != nil {
+		return err
+	}
+	defer c.PutMaster(name, conn)
+
+	if err := fn(conn); err != nil {
+		return err
+	}
+	err = pool.WaitReplicas(conn, numReplicas, timeout)
+	if after, ferr := c.failoverTime(name); ferr != nil {
+		return ferr
+	} else if !after.Equal(before) {
+		return ErrFailedOver
+	}
+	return err
+}
+
+// failoverTime returns when the master of the given name last failed over, or
+// the zero time if it hasn't since the Client was created
+func (c *Client) failoverTime(name string) (time.Time, error) {
+	var t time.Time
+	if !c.call(func(c *Client) { t = c.lastFailover[name] }) {
+		return t, &ClientError{err: ErrClosed}
+	}
+	return t, nil
+}
+
+// CmdSafe is like Cmd, but uses DoSafe to wait for at least numReplicas
+// replicas to acknowledge the command before returning. The command's
+// response is returned even if the error is a *pool.InsufficientReplicasError
+// or ErrFailedOver.
+func (m *Master) CmdSafe(
+	numReplicas int, timeout time.Duration, cmd string, args ...interface{},
+) (
+	*redis.Resp, error,
+) {
+	var r *redis.Resp
+	err := m.c.DoSafe(m.name, numReplicas, timeout, func(conn *redis.Client) error {
+		r = conn.Cmd(cmd, args...)
+		return r.Err
+	})
+	return r, err
+}
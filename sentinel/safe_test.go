@@ -0,0 +1,65 @@
+package sentinel
+
+import (
+	. "testing"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/log"
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCmdSafe(t *T) {
+	s := getSentinel(t)
+	m := s.Master("test")
+	k := randStr()
+
+	r, err := m.CmdSafe(1, 5*time.Second, "SET", k, "foo")
+	require.Nil(t, err)
+	str, err := r.Str()
+	require.Nil(t, err)
+	assert.Equal(t, "OK", str)
+
+	// there's only one replica, so waiting for two will time out
+	r, err = m.CmdSafe(2, 100*time.Millisecond, "SET", k, "bar")
+	assert.Nil(t, r.Err)
+	ierr, ok := err.(*pool.InsufficientReplicasError)
+	require.True(t, ok)
+	assert.Equal(t, 2, ierr.Wanted)
+}
+
+func TestDoSafeFailover(t *T) {
+	m1, m2 := listen(t), listen(t)
+	c := &Client{
+		masterPools:  map[string]*pool.Pool{},
+		lastFailover: map[string]time.Time{},
+		replicas:     map[string]*replicaSet{},
+		poolOpts: pool.Opts{
+			Network:  "tcp",
+			Size:     1,
+			DialOpts: redis.DialOpts{Timeout: 100 * time.Millisecond},
+		},
+		logger:         log.Nop,
+		getCh:          make(chan *getReq),
+		putCh:          make(chan *putReq),
+		callCh:         make(chan func(*Client)),
+		closeCh:        make(chan struct{}),
+		doneCh:         make(chan struct{}),
+		switchMasterCh: make(chan *switchMaster),
+	}
+	var err error
+	c.masterPools["test"], err = c.newPool(m1)
+	require.Nil(t, err)
+	go c.spin()
+	defer close(c.closeCh)
+
+	// the master fails over between the write and the WAIT, which m1 never
+	// answers
+	err = c.DoSafe("test", 1, time.Second, func(conn *redis.Client) error {
+		c.switchMasterCh <- &switchMaster{name: "test", addr: m2}
+		return nil
+	})
+	assert.Equal(t, ErrFailedOver, err)
+}
@@ -50,9 +50,13 @@
 package sentinel
 
 import (
+	"context"
 	"errors"
-	"strings"
+	"fmt"
+	"sync"
+	"time"
 
+	"github.com/mediocregopher/radix.v2/log"
 	"github.com/mediocregopher/radix.v2/pool"
 	"github.com/mediocregopher/radix.v2/pubsub"
 	"github.com/mediocregopher/radix.v2/redis"
@@ -77,7 +81,7 @@ func (ce *ClientError) Error() string {
 }
 
 type getReqRet struct {
-	conn *redis.Client
+	pool *pool.Pool
 	err  *ClientError
 }
 
@@ -87,8 +91,9 @@ type getReq struct {
 }
 
 type putReq struct {
-	name string
-	conn *redis.Client
+	name    string
+	conn    *redis.Client
+	replica bool
 }
 
 type switchMaster struct {
@@ -99,27 +104,122 @@ type switchMaster struct {
 // Client communicates with a sentinel instance and manages connection pools of
 // active masters
 type Client struct {
-	poolSize    int
+	network string
+	names   []string
+
+	// the addresses of the known sentinels, starting with the one last
+	// connected to
+	addrsL sync.Mutex
+	addrs  []string
+
 	masterPools map[string]*pool.Pool
-	subClient   *pubsub.SubClient
 
-	// This is pool.DialFunc instead of the package's DialFunc
-	// as it's only used when calling pool.NewCustom. Otherwise it
-	// will have to be cast on each invocation.
-	dialFunc pool.DialFunc
+	// only used in spin, and only with LazyPools. The address of each master
+	// which hasn't had its pool created yet.
+	lazyAddrs map[string]string
+
+	// the connection to sentinel which events are received on, which is
+	// replaced by subSpin whenever it's lost. subL is held while it's being
+	// replaced, so that Close can close it.
+	subL      sync.Mutex
+	subClient *pubsub.SubClient
+
+	// when each master's pool was last replaced due to a +switch-master
+	lastFailover map[string]time.Time
 
-	getCh   chan *getReq
-	putCh   chan *putReq
-	closeCh chan struct{}
+	// the options every master's pool is created with, apart from Addr, and
+	// those every connection to sentinel is made with
+	poolOpts         pool.Opts
+	sentinelDialOpts redis.DialOpts
+	logger           log.Logger
+
+	getCh     chan *getReq
+	putCh     chan *putReq
+	callCh    chan func(*Client)
+	closeCh   chan struct{}
+	closeOnce sync.Once
+
+	// monitors is the go-routines which keep track of sentinel, and wg is
+	// spin, which closes doneCh once it's done. closeTimeout is how long spin
+	// waits for connections to be put back once the Client is closed, and
+	// closeErr is set by it if they weren't.
+	monitors     sync.WaitGroup
+	wg           sync.WaitGroup
+	doneCh       chan struct{}
+	closeTimeout time.Duration
+	closeErr     error
 
-	alwaysErr      *ClientError
-	alwaysErrCh    chan *ClientError
 	switchMasterCh chan *switchMaster
+
+	topoL sync.Mutex
+	topo  *Topology
+
+	// only used in spin, the replicas of each master which GetReplica has
+	// been called for. replicaL is held while they're being refreshed.
+	replicas map[string]*replicaSet
+	replicaL sync.Mutex
 }
 
 // DialFunc is a function which can be passed into NewClientCustom
 type DialFunc func(network, addr string) (*redis.Client, error)
 
+// Opts are the options which can be passed into NewClientWithOpts. Only
+// Network and Addr are required.
+type Opts struct {
+	// The sentinel instance to connect to
+	Network, Addr string
+
+	// Further sentinel instances to try connecting to, in order, if Addr
+	// can't be reached. Once connected the addresses of every sentinel
+	// monitoring the named masters are fetched, and used from then on
+	// whenever the connection to sentinel is lost.
+	Addrs []string
+
+	// The names of the masters to create pools for
+	Names []string
+
+	// The size of the connection pool to use for each master. Defaults to 10.
+	PoolSize int
+
+	// If set, the pool for each master isn't created until the master is first
+	// used, e.g. by GetMaster, so that no connections are made to masters
+	// which are rarely or never used. Their failovers are still tracked in
+	// the meantime. Stats returns empty Stats for a master which hasn't been
+	// used yet.
+	LazyPools bool
+
+	// Passed on to the pool for each master and replica, see pool.Opts. When
+	// MaxActive is set GetMaster may block, which holds up nothing but the
+	// call itself.
+	MaxActive  int
+	GetTimeout time.Duration
+
+	// The function used to create all new connections to the master
+	// instances. If not set MasterDialOpts is used instead. Masters are always
+	// given as a "tcp" network and the ip:port sentinel announces them with,
+	// so this is where they can be mapped to some other address, e.g. the
+	// path of a unix socket on the same host.
+	Dial DialFunc
+
+	// The options used to connect to the masters when Dial isn't set, for
+	// example the Password and DB to use on them.
+	MasterDialOpts redis.DialOpts
+
+	// The options used to connect to the sentinels, for example the
+	// Password set with requirepass on them. DB isn't used.
+	SentinelDialOpts redis.DialOpts
+
+	// Used to log failovers, and failures which happen in the background such
+	// as the connection to sentinel being lost. Entries have the component
+	// "sentinel", and the master's name if there is one. It's also passed on
+	// to the pool for each master. Defaults to log.Nop.
+	Logger log.Logger
+
+	// Passed on to the pool for each master and replica, see pool.Opts. The
+	// connections to the sentinels aren't traced.
+	Trace *redis.Trace
+}
+
 // NewClient creates a sentinel client. Connects to the given sentinel instance,
 // pulls the information for the masters of the given names, and creates an
 // initial pool of connections for each master. The client will automatically
@@ -133,6 +233,16 @@ func NewClient(
 	return NewClientCustom(network, address, poolSize, redis.Dial, names...)
 }
 
+// NewClientWithOpts is like NewClient, but with more fine-tuned configuration
+// options. See Opts for the available options. The returned error is a
+// *ClientError.
+func NewClientWithOpts(o Opts) (*Client, error) {
+	if o.PoolSize == 0 {
+		o.PoolSize = 10
+	}
+	return newClient(o)
+}
+
 // NewClientCustom is the same as NewClient, except it takes in a DialFunc which
 // will be used to create all new connections to the master instances. This can
 // be used to implement authentication, custom timeouts, etc...
@@ -141,147 +251,608 @@ func NewClientCustom(
 ) (
 	*Client, error,
 ) {
+	return newClient(Opts{
+		Network:  network,
+		Addr:     address,
+		Names:    names,
+		PoolSize: poolSize,
+		Dial:     df,
+	})
+}
+
+// newClient creates the Client. Unlike NewClientWithOpts it doesn't apply any
+// defaults.
+func newClient(o Opts) (*Client, error) {
+	po := pool.Opts{
+		Network:    "tcp",
+		Size:       o.PoolSize,
+		MaxActive:  o.MaxActive,
+		GetTimeout: o.GetTimeout,
+		DialOpts:   o.MasterDialOpts,
+		Logger:     o.Logger,
+		Trace:      o.Trace,
+	}
+	if o.Dial != nil {
+		po.Dial = func(_ context.Context, network, addr string) (*redis.Client, error) {
+			return o.Dial(network, addr)
+		}
+	}
+	// sentinels don't have databases
+	o.SentinelDialOpts.DB = 0
+
+	c := &Client{
+		network:          o.Network,
+		addrs:            append([]string{o.Addr}, o.Addrs...),
+		names:            o.Names,
+		masterPools:      map[string]*pool.Pool{},
+		lazyAddrs:        map[string]string{},
+		lastFailover:     map[string]time.Time{},
+		replicas:         map[string]*replicaSet{},
+		poolOpts:         po,
+		sentinelDialOpts: o.SentinelDialOpts,
+		logger:           log.With(o.Logger, log.KV{log.KeyComponent: "sentinel"}),
+		getCh:            make(chan *getReq),
+		putCh:            make(chan *putReq),
+		callCh:           make(chan func(*Client)),
+		closeCh:          make(chan struct{}),
+		doneCh:           make(chan struct{}),
+		switchMasterCh:   make(chan *switchMaster),
+	}
 
 	// We use this to fetch initial details about masters before we upgrade it
 	// to a pubsub client
-	client, err := redis.Dial(network, address)
+	client, err := c.dialSentinel()
 	if err != nil {
-		return nil, &ClientError{err: err}
+		return nil, &ClientError{err: err, SentinelErr: true}
+	}
+	if err := c.refreshAddrs(client); err != nil {
+		return nil, &ClientError{err: err, SentinelErr: true}
 	}
 
-	masterPools := map[string]*pool.Pool{}
-	for _, name := range names {
+	for _, name := range o.Names {
 		r := client.Cmd("SENTINEL", "MASTER", name)
 		l, err := r.List()
 		if err != nil {
 			return nil, &ClientError{err: err, SentinelErr: true}
 		}
 		addr := l[3] + ":" + l[5]
-		pool, err := pool.NewCustom("tcp", addr, poolSize, (pool.DialFunc)(df))
+		if o.LazyPools {
+			c.lazyAddrs[name] = addr
+			continue
+		}
+		pool, err := c.newPool(addr)
 		if err != nil {
+			err = fmt.Errorf("connecting to master %q at %s: %s", name, addr, err)
 			return nil, &ClientError{err: err}
 		}
-		masterPools[name] = pool
+		c.masterPools[name] = pool
 	}
 
-	subClient := pubsub.NewSubClient(client)
-	r := subClient.Subscribe("+switch-master")
+	c.subClient = pubsub.NewSubClient(client)
+	r := c.subClient.Subscribe(sentinelChannels...)
 	if r.Err != nil {
 		return nil, &ClientError{err: r.Err, SentinelErr: true}
 	}
 
-	c := &Client{
-		poolSize:       poolSize,
-		masterPools:    masterPools,
-		subClient:      subClient,
-		dialFunc:       (pool.DialFunc)(df),
-		getCh:          make(chan *getReq),
-		putCh:          make(chan *putReq),
-		closeCh:        make(chan struct{}),
-		alwaysErrCh:    make(chan *ClientError),
-		switchMasterCh: make(chan *switchMaster),
-	}
-
-	go c.subSpin()
-	go c.spin()
+	c.monitors.Add(2)
+	go func() {
+		defer c.monitors.Done()
+		c.subSpin()
+	}()
+	go func() {
+		defer c.monitors.Done()
+		c.addrsSpin()
+	}()
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.spin()
+	}()
 	return c, nil
 }
 
+// The channels the connection to sentinel is subscribed to
+var sentinelChannels = []interface{}{"+switch-master", "+slave", "+sdown", "-sdown"}
+
+func (c *Client) newPool(addr string) (*pool.Pool, error) {
+	o := c.poolOpts
+	o.Addr = addr
+	return pool.NewWithOpts(o)
+}
+
+// subSpin receives events from sentinel until the Client is closed. When the
+// connection to sentinel is lost it's made again, backing off between
+// attempts, and in the meantime the Client carries on using the masters it
+// already knows of.
 func (c *Client) subSpin() {
-	alwaysErr := func(err error) {
+	backoff := 100 * time.Millisecond
+	for {
+		err := c.receiveEvents()
 		select {
-		case c.alwaysErrCh <- &ClientError{err: err, SentinelErr: true}:
 		case <-c.closeCh:
+			return
+		default:
+		}
+		c.logger.Log(log.Error, "sentinel connection lost", log.KV{
+			log.KeyOperation: "subscribe",
+			log.KeyAddr:      c.sentinelAddr(),
+			log.KeyErr:       err,
+		})
+
+		for {
+			select {
+			case <-time.After(backoff):
+			case <-c.closeCh:
+				return
+			}
+			if err = c.resubscribe(); err == nil {
+				break
+			} else if err == ErrClosed {
+				return
+			}
+			c.logger.Log(log.Debug, "reconnecting to sentinel failed", log.KV{
+				log.KeyOperation: "subscribe",
+				log.KeyAddr:      c.sentinelAddr(),
+				log.KeyErr:       err,
+			})
+			if backoff *= 2; backoff > 5*time.Second {
+				backoff = 5 * time.Second
+			}
 		}
+		backoff = 100 * time.Millisecond
+		c.logger.Log(log.Info, "sentinel connection re-established", log.KV{
+			log.KeyOperation: "subscribe",
+			log.KeyAddr:      c.sentinelAddr(),
+		})
 	}
+}
+
+// receiveEvents handles the events received on the current connection to
+// sentinel until there's an error reading from it
+func (c *Client) receiveEvents() error {
+	c.subL.Lock()
+	sub := c.subClient
+	c.subL.Unlock()
 
 	for {
-		if err := c.subClient.Ping().Err; err != nil {
-			alwaysErr(err)
-			return
+		if err := sub.Ping().Err; err != nil {
+			return err
 		}
 
-		r := c.subClient.Receive()
+		r := sub.Receive()
 		if r.Timeout() {
 			continue
+		} else if r.Err != nil {
+			return r.Err
+		} else if r.Type != pubsub.Message {
+			continue
 		}
-		if r.Err != nil {
-			alwaysErr(r.Err)
-			return
+		e := parseEvent(r.Channel, r.Message)
+		if e.Event != "+switch-master" {
+			c.replicaEvent(e)
+			continue
+		} else if e.MasterName == "" {
+			continue
 		}
-		sMsg := strings.Split(r.Message, " ")
-		name := sMsg[0]
-		newAddr := sMsg[3] + ":" + sMsg[4]
 		select {
-		case c.switchMasterCh <- &switchMaster{name, newAddr}:
+		case c.switchMasterCh <- &switchMaster{e.MasterName, e.Addr}:
 		case <-c.closeCh:
-			return
+			return ErrClosed
+		}
+	}
+}
+
+// resubscribe makes a new connection to sentinel to replace the one which was
+// lost, to whichever sentinel can be reached. Once it's subscribed the address
+// of every master is checked again on a second connection, in case a failover
+// was missed in the meantime.
+func (c *Client) resubscribe() error {
+	client, err := c.dialSentinel()
+	if err != nil {
+		return err
+	}
+	sub := pubsub.NewSubClient(client)
+	if r := sub.Subscribe(sentinelChannels...); r.Err != nil {
+		client.Close()
+		return r.Err
+	}
+
+	conn, err := c.dialSentinelAddr(client.Addr)
+	if err != nil {
+		client.Close()
+		return err
+	}
+	defer conn.Close()
+	if err := c.refreshAddrs(conn); err != nil {
+		client.Close()
+		return err
+	}
+	sms := make([]*switchMaster, 0, len(c.names))
+	for _, name := range c.names {
+		ni, err := parseNodeInfo(conn.Cmd("SENTINEL", "MASTER", name))
+		if err != nil {
+			client.Close()
+			return err
+		}
+		sms = append(sms, &switchMaster{name, ni.Addr})
+	}
+
+	c.subL.Lock()
+	select {
+	case <-c.closeCh:
+		c.subL.Unlock()
+		client.Close()
+		return ErrClosed
+	default:
+	}
+	c.subClient = sub
+	c.subL.Unlock()
+
+	for _, sm := range sms {
+		select {
+		case c.switchMasterCh <- sm:
+		case <-c.closeCh:
+			return ErrClosed
 		}
 	}
+	for _, name := range c.names {
+		go c.reloadReplicas(name)
+	}
+	return nil
 }
 
 func (c *Client) spin() {
+	defer close(c.doneCh)
 	for {
 		select {
+		// The connection is gotten from the pool by GetMaster rather than
+		// here, since Get may wait for a connection to be Put back when
+		// MaxActive is set
 		case req := <-c.getCh:
-			if c.alwaysErr != nil {
-				req.retCh <- &getReqRet{nil, c.alwaysErr}
-				continue
-			}
-			pool, ok := c.masterPools[req.name]
+			pool, ok := c.masterPool(req.name)
 			if !ok {
 				err := errors.New("unknown name: " + req.name)
 				req.retCh <- &getReqRet{nil, &ClientError{err: err}}
 				continue
 			}
-			conn, err := pool.Get()
-			if err != nil {
-				req.retCh <- &getReqRet{nil, &ClientError{err: err}}
-				continue
-			}
-			req.retCh <- &getReqRet{conn, nil}
+			req.retCh <- &getReqRet{pool, nil}
 
 		case req := <-c.putCh:
-			if pool, ok := c.masterPools[req.name]; ok {
-				pool.Put(req.conn)
-			}
+			c.put(req)
 
-		case err := <-c.alwaysErrCh:
-			c.alwaysErr = err
+		case f := <-c.callCh:
+			f(c)
 
 		case sm := <-c.switchMasterCh:
-			if p, ok := c.masterPools[sm.name]; ok {
-				p.Empty()
-				p, _ = pool.NewCustom("tcp", sm.addr, c.poolSize, c.dialFunc)
+			// a master is checked after reconnecting to sentinel whether or
+			// not it has changed
+			if p, ok := c.masterPools[sm.name]; ok && p.Addr != sm.addr {
+				// closing the old pool wakes any GetMaster calls waiting on
+				// it because of MaxActive
+				p.Close()
+				c.logger.Log(log.Info, "master failed over", log.KV{
+					log.KeyOperation: "switch-master",
+					log.KeyMaster:    sm.name,
+					log.KeyAddr:      sm.addr,
+				})
+				// The pool is still usable even if its initial connections
+				// couldn't be made, it'll keep trying as it's used
+				var err error
+				if p, err = c.newPool(sm.addr); err != nil {
+					c.logger.Log(log.Warn, "connecting to new master failed", log.KV{
+						log.KeyOperation: "switch-master",
+						log.KeyMaster:    sm.name,
+						log.KeyAddr:      sm.addr,
+						log.KeyErr:       err,
+					})
+				}
 				c.masterPools[sm.name] = p
+				c.lastFailover[sm.name] = time.Now()
+
+				// the new master was most likely one of the replicas
+				if _, ok := c.replicas[sm.name]; ok {
+					c.removeReplica(sm.name, sm.addr)
+					go c.reloadReplicas(sm.name)
+				}
+			} else if addr, ok := c.lazyAddrs[sm.name]; ok && addr != sm.addr {
+				// there's no pool to replace yet, the new master's is made
+				// when it's first used
+				c.logger.Log(log.Info, "master failed over", log.KV{
+					log.KeyOperation: "switch-master",
+					log.KeyMaster:    sm.name,
+					log.KeyAddr:      sm.addr,
+				})
+				c.lazyAddrs[sm.name] = sm.addr
+				c.lastFailover[sm.name] = time.Now()
 			}
 
 		case <-c.closeCh:
-			for name := range c.masterPools {
-				c.masterPools[name].Empty()
-			}
-			c.subClient.Client.Close()
-			close(c.getCh)
-			close(c.putCh)
+			c.closePools()
 			return
 		}
 	}
 }
 
+// put puts a connection back in the pool for the master or replica it's for.
+// A connection to a master which has since failed over isn't put in the new
+// master's pool, and one to a replica which has since been taken out of the
+// rotation is closed. Only called from spin.
+func (c *Client) put(req *putReq) {
+	if p := c.masterPools[req.name]; p != nil && p.Addr == req.conn.Addr {
+		p.Put(req.conn)
+		return
+	}
+	if rs := c.replicas[req.name]; rs != nil && req.replica {
+		for _, p := range rs.pools {
+			if p.Addr == req.conn.Addr {
+				p.Put(req.conn)
+				return
+			}
+		}
+	}
+	req.conn.Close()
+}
+
+// closePools is called by spin once the Client has been closed and sentinel
+// is no longer being monitored. It closes every pool using CloseWithTimeout
+// with the Client's closeTimeout, and puts back connections in the meantime so
+// that they can be waited for.
+func (c *Client) closePools() {
+	c.monitors.Wait()
+
+	var pools []*pool.Pool
+	for _, p := range c.masterPools {
+		pools = append(pools, p)
+	}
+	for _, rs := range c.replicas {
+		pools = append(pools, rs.pools...)
+	}
+
+	errCh := make(chan error, len(pools))
+	for _, p := range pools {
+		go func(p *pool.Pool) { errCh <- p.CloseWithTimeout(c.closeTimeout) }(p)
+	}
+	for n := 0; n < len(pools); {
+		select {
+		case req := <-c.putCh:
+			c.put(req)
+		case err := <-errCh:
+			if n++; err != nil {
+				c.closeErr = err
+			}
+		}
+	}
+}
+
+// masterPool returns the pool for the master of the given name, creating it
+// first if LazyPools is set and it hasn't been yet. It returns false if the
+// name isn't one of the Client's. Only called from spin.
+func (c *Client) masterPool(name string) (*pool.Pool, bool) {
+	if p, ok := c.masterPools[name]; ok {
+		return p, true
+	}
+	addr, ok := c.lazyAddrs[name]
+	if !ok {
+		return nil, false
+	}
+	// The pool is still usable even if its initial connections couldn't be
+	// made, as with one made because of a failover
+	p, err := c.newPool(addr)
+	if err != nil {
+		c.logger.Log(log.Warn, "connecting to master failed", log.KV{
+			log.KeyOperation: "get",
+			log.KeyMaster:    name,
+			log.KeyAddr:      addr,
+			log.KeyErr:       err,
+		})
+	}
+	delete(c.lazyAddrs, name)
+	c.masterPools[name] = p
+	return p, true
+}
+
+// knownName returns whether the name is one of the Client's, whether or not
+// its pool has been created. Only called from spin.
+func (c *Client) knownName(name string) bool {
+	_, pooled := c.masterPools[name]
+	_, lazy := c.lazyAddrs[name]
+	return pooled || lazy
+}
+
 // GetMaster retrieves a connection for the master of the given name. If
-// sentinel has become unreachable this will always return an error. Close
-// should be called in that case. The returned error is a *ClientError.
+// sentinel has become unreachable the master as of when it was last reachable
+// is used. The returned error is a *ClientError.
+//
+// If MaxActive is set and GetMaster is waiting for a connection when the
+// master fails over, it returns an error whose Error is that of
+// ErrMasterChanged, rather than waiting on the new master's pool.
 func (c *Client) GetMaster(name string) (*redis.Client, error) {
+	// checked first, since the select below picks either at random if the
+	// Client is closed while spin is still running
+	if c.closing() {
+		return nil, &ClientError{err: ErrClosed}
+	}
+
 	req := getReq{name, make(chan *getReqRet)}
-	c.getCh <- &req
+	select {
+	case c.getCh <- &req:
+	case <-c.closeCh:
+		return nil, &ClientError{err: ErrClosed}
+	}
 	ret := <-req.retCh
 	if ret.err != nil {
 		return nil, ret.err
 	}
-	return ret.conn, nil
+	return c.getConn(ret.pool)
+}
+
+// ErrMasterChanged is what the *ClientError returned by GetMaster or
+// GetReplica wraps, if the pool it was waiting on was closed because the
+// master failed over
+var ErrMasterChanged = errors.New("master changed while waiting for a connection")
+
+// ErrClosed is what the *ClientError returned by GetMaster, GetReplica,
+// Topology and the methods which use them wraps, once Close or
+// CloseWithTimeout has been called
+var ErrClosed = errors.New("client is closed")
+
+// getConn gets a connection from one of the Client's pools. A pool is closed
+// either because its master failed over or because the Client was closed.
+func (c *Client) getConn(p *pool.Pool) (*redis.Client, error) {
+	conn, err := p.Get()
+	if err == pool.ErrClosed && c.closing() {
+		return nil, &ClientError{err: ErrClosed}
+	} else if err == pool.ErrClosed {
+		return nil, &ClientError{err: ErrMasterChanged}
+	} else if err != nil {
+		return nil, &ClientError{err: err}
+	}
+	return conn, nil
+}
+
+// Close stops the Client's go-routines, and closes its connection to sentinel
+// and its pools of connections to the masters and replicas. Connections which
+// are still in use are closed when they're put back. Once it's called
+// GetMaster and GetReplica return ErrClosed.
+func (c *Client) Close() {
+	c.CloseWithTimeout(0)
+}
+
+// CloseWithTimeout is like Close, but the pools are closed with the pool's
+// CloseWithTimeout, so that connections which are in use can be put back and
+// the commands on them finish, up to the given duration. Sentinel stops being
+// monitored first, so no failovers are acted on while waiting, and GetMaster
+// and GetReplica return ErrClosed straight away. It returns
+// pool.ErrCloseTimeout if some connections weren't put back in time, and
+// returns the same if it's called again.
+func (c *Client) CloseWithTimeout(timeout time.Duration) error {
+	c.closeOnce.Do(func() {
+		c.closeTimeout = timeout
+		close(c.closeCh)
+		c.subL.Lock()
+		c.subClient.Client.Close()
+		c.subL.Unlock()
+		c.wg.Wait()
+	})
+	return c.closeErr
+}
+
+func (c *Client) closing() bool {
+	select {
+	case <-c.closeCh:
+		return true
+	default:
+		return false
+	}
 }
 
 // PutMaster return a connection for a master of a given name
 func (c *Client) PutMaster(name string, client *redis.Client) {
-	c.putCh <- &putReq{name, client}
+	select {
+	case c.putCh <- &putReq{name: name, conn: client}:
+	case <-c.doneCh:
+		client.Close()
+	}
+}
+
+// DoMaster gets a connection using GetMaster, calls fn with it, and puts it
+// back with PutMaster. fn must not use the connection after returning. The
+// error from GetMaster, if any, is returned, otherwise fn's error is.
+func (c *Client) DoMaster(name string, fn func(*redis.Client) error) error {
+	conn, err := c.GetMaster(name)
+	if err != nil {
+		return err
+	}
+	defer c.PutMaster(name, conn)
+	return fn(conn)
+}
+
+// Stats returns the Stats of the pool for the master of the given name. The
+// pool is replaced when the master fails over, so the counters start again
+// from zero when that happens. The returned error is a *ClientError.
+func (c *Client) Stats(name string) (pool.Stats, error) {
+	var p *pool.Pool
+	var known bool
+	if !c.call(func(c *Client) { p, known = c.masterPools[name], c.knownName(name) }) {
+		return pool.Stats{}, &ClientError{err: ErrClosed}
+	} else if !known {
+		return pool.Stats{}, &ClientError{err: errors.New("unknown name: " + name)}
+	} else if p == nil {
+		// LazyPools is set and the master hasn't been used yet
+		return pool.Stats{}, nil
+	}
+	return p.Stats(), nil
+}
+
+// Master is a handle on the master of a single name, as returned by the Master
+// method on Client. Its Cmd method makes it usable wherever a redis.Cmder is
+// expected, for example with the util package.
+type Master struct {
+	c    *Client
+	name string
+}
+
+var _ redis.Cmder = &Master{}
+
+// Master returns a Master handle for the master of the given name. No
+// connections are made by calling this, and the name is not checked until the
+// handle is used.
+func (c *Client) Master(name string) *Master {
+	return &Master{c: c, name: name}
+}
+
+// Get is a shortcut for calling GetMaster on the parent Client
+func (m *Master) Get() (*redis.Client, error) {
+	return m.c.GetMaster(m.name)
+}
+
+// Put is a shortcut for calling PutMaster on the parent Client
+func (m *Master) Put(client *redis.Client) {
+	m.c.PutMaster(m.name, client)
+}
+
+// Stats is a shortcut for calling Stats on the parent Client
+func (m *Master) Stats() (pool.Stats, error) {
+	return m.c.Stats(m.name)
+}
+
+// Cmd automatically gets a connection to the master, calls Cmd on it, and puts
+// the connection back. If the connection couldn't be retrieved the returned
+// Resp will have its Err set.
+func (m *Master) Cmd(cmd string, args ...interface{}) *redis.Resp {
+	client, err := m.Get()
+	if err != nil {
+		return redis.NewResp(err)
+	}
+	defer m.Put(client)
+
+	return client.Cmd(cmd, args...)
+}
+
+// DoCtx gets a connection to the master, calls DoCtx on it with fn, and puts
+// the connection back. If the context is done before fn returns the context's
+// error is returned and the connection is closed rather than being reused.
+func (m *Master) DoCtx(ctx context.Context, fn func(*redis.Client)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	client, err := m.Get()
+	if err != nil {
+		return err
+	}
+	defer m.Put(client)
+
+	return client.DoCtx(ctx, fn)
+}
+
+// CmdCtx is like Cmd, but uses DoCtx so that the command is given up on if the
+// context is done before its reply is read
+func (m *Master) CmdCtx(ctx context.Context, cmd string, args ...interface{}) *redis.Resp {
+	var r *redis.Resp
+	if err := m.DoCtx(ctx, func(client *redis.Client) {
+		r = client.Cmd(cmd, args...)
+	}); err != nil {
+		return redis.NewRespIOErr(err)
+	}
+	return r
 }
@@ -1,11 +1,19 @@
 package sentinel
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"sync"
 	. "testing"
 	"time"
 
+	"github.com/mediocregopher/radix.v2/log"
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/pubsub"
 	"github.com/mediocregopher/radix.v2/redis"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -22,6 +30,141 @@ func getSentinel(t *T) *Client {
 	return s
 }
 
+// fakeSentinel answers SENTINEL MASTER and SENTINEL SLAVES with the master and
+// replicas it's been given, and SUBSCRIBE and PING like redis does. Events can
+// be published to every connection which has subscribed.
+type fakeSentinel struct {
+	net.Listener
+	l         sync.Mutex
+	runID     string
+	password  string
+	master    map[string]string
+	replicas  []map[string]string
+	sentinels []map[string]string
+	subs      []net.Conn
+}
+
+func newFakeSentinel(t *T) *fakeSentinel {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	fs := &fakeSentinel{Listener: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go fs.serve(conn)
+		}
+	}()
+	return fs
+}
+
+func (fs *fakeSentinel) serve(conn net.Conn) {
+	defer conn.Close()
+	rr := redis.NewRespReader(conn)
+	var subscribed bool
+	fs.l.Lock()
+	authed := fs.password == ""
+	fs.l.Unlock()
+	for {
+		args, err := rr.Read().List()
+		if err != nil {
+			return
+		}
+		fs.l.Lock()
+		cmd := strings.ToUpper(args[0])
+		if cmd == "AUTH" {
+			if args[len(args)-1] == fs.password {
+				authed = true
+				redis.NewResp("OK").WriteTo(conn)
+			} else {
+				redis.NewResp(errors.New("WRONGPASS invalid password")).WriteTo(conn)
+			}
+			fs.l.Unlock()
+			continue
+		} else if !authed {
+			redis.NewResp(errors.New("NOAUTH Authentication required")).WriteTo(conn)
+			fs.l.Unlock()
+			continue
+		}
+		switch cmd {
+		case "SENTINEL":
+			switch strings.ToUpper(args[1]) {
+			case "MASTER":
+				r := []string{"name", args[2]}
+				for _, k := range []string{"ip", "port", "flags"} {
+					r = append(r, k, fs.master[k])
+				}
+				redis.NewResp(r).WriteTo(conn)
+			case "SLAVES":
+				redis.NewResp(fs.replicas).WriteTo(conn)
+			default:
+				redis.NewResp(fs.sentinels).WriteTo(conn)
+			}
+		case "INFO":
+			redis.NewResp("# Server\r\nrun_id:" + fs.runID + "\r\n").WriteTo(conn)
+		case "SUBSCRIBE":
+			if !subscribed {
+				subscribed = true
+				fs.subs = append(fs.subs, conn)
+			}
+			for i, ch := range args[1:] {
+				redis.NewResp([]interface{}{"subscribe", ch, i + 1}).WriteTo(conn)
+			}
+		case "PING":
+			if subscribed {
+				redis.NewResp([]string{"pong", ""}).WriteTo(conn)
+			} else {
+				redis.NewResp("PONG").WriteTo(conn)
+			}
+		default:
+			redis.NewResp(redis.AppErr).WriteTo(conn)
+		}
+		fs.l.Unlock()
+	}
+}
+
+func nodeFields(addr, flags string) map[string]string {
+	host, port, _ := net.SplitHostPort(addr)
+	return map[string]string{"ip": host, "port": port, "flags": flags}
+}
+
+func (fs *fakeSentinel) setMaster(addr string) {
+	fs.l.Lock()
+	defer fs.l.Unlock()
+	fs.master = nodeFields(addr, "master")
+}
+
+// setReplicas sets the replicas to reply with. Each is an address, with the
+// flags it should have before it if there are any.
+func (fs *fakeSentinel) setReplicas(replicas ...[2]string) {
+	fs.l.Lock()
+	defer fs.l.Unlock()
+	fs.replicas = fs.replicas[:0]
+	for _, r := range replicas {
+		fs.replicas = append(fs.replicas, nodeFields(r[1], "slave,"+r[0]))
+	}
+}
+
+func (fs *fakeSentinel) publish(channel, msg string) {
+	fs.l.Lock()
+	defer fs.l.Unlock()
+	for _, conn := range fs.subs {
+		redis.NewResp([]string{"message", channel, msg}).WriteTo(conn)
+	}
+}
+
+// killSubs closes every connection which has subscribed
+func (fs *fakeSentinel) killSubs() {
+	fs.l.Lock()
+	defer fs.l.Unlock()
+	for _, conn := range fs.subs {
+		conn.Close()
+	}
+	fs.subs = nil
+}
+
 func randStr() string {
 	b := make([]byte, 16)
 	if _, err := rand.Read(b); err != nil {
@@ -47,6 +190,36 @@ func TestBasic(t *T) {
 	s.PutMaster("test", c)
 }
 
+func TestMaster(t *T) {
+	s := getSentinel(t)
+	m := s.Master("test")
+	k := randStr()
+
+	require.Nil(t, m.Cmd("SET", k, "foo").Err)
+	foo, err := m.Cmd("GET", k).Str()
+	require.Nil(t, err)
+	assert.Equal(t, "foo", foo)
+
+	err = s.Master("dne").Cmd("GET", k).Err
+	assert.NotNil(t, err)
+}
+
+func TestMasterCmdCtx(t *T) {
+	s := getSentinel(t)
+	m := s.Master("test")
+	k := randStr()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.Nil(t, m.CmdCtx(ctx, "SET", k, "foo").Err)
+
+	// BLPOP on a key which doesn't exist blocks until the context is done
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	r := m.CmdCtx(ctx, "BLPOP", randStr(), 0)
+	assert.Equal(t, context.DeadlineExceeded, r.Err)
+}
+
 // Test a basic manual failover
 func TestFailover(t *T) {
 	s := getSentinel(t)
@@ -80,3 +253,239 @@ func TestFailover(t *T) {
 	assert.Equal(t, "bar", bar)
 	s.PutMaster("test", c)
 }
+
+func TestFailoverLogger(t *T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer l.Close()
+
+	old, err := pool.New("tcp", listen(t), 1)
+	require.Nil(t, err)
+
+	rec := new(log.Recorder)
+	c := &Client{
+		masterPools:    map[string]*pool.Pool{"test": old},
+		lastFailover:   map[string]time.Time{},
+		poolOpts:       pool.Opts{Network: "tcp", Size: 1},
+		logger:         log.With(rec, log.KV{log.KeyComponent: "sentinel"}),
+		callCh:         make(chan func(*Client)),
+		switchMasterCh: make(chan *switchMaster),
+	}
+	go c.spin()
+
+	c.switchMasterCh <- &switchMaster{name: "test", addr: l.Addr().String()}
+	doneCh := make(chan struct{})
+	c.callCh <- func(*Client) { close(doneCh) }
+	<-doneCh
+
+	ee := rec.Entries("")
+	require.Len(t, ee, 1)
+	assert.Equal(t, "master failed over", ee[0].Msg)
+	assert.Equal(t, log.Info, ee[0].Level)
+	assert.Equal(t, log.KV{
+		log.KeyComponent: "sentinel",
+		log.KeyOperation: "switch-master",
+		log.KeyMaster:    "test",
+		log.KeyAddr:      l.Addr().String(),
+	}, ee[0].KV)
+}
+
+func TestSentinelReconnect(t *T) {
+	fs := newFakeSentinel(t)
+	defer fs.Close()
+	m1, m2, m3 := listen(t), listen(t), listen(t)
+	fs.setMaster(m1)
+
+	rec := new(log.Recorder)
+	c, err := NewClientWithOpts(Opts{
+		Network:  "tcp",
+		Addr:     fs.Addr().String(),
+		Names:    []string{"test"},
+		PoolSize: 1,
+		Logger:   rec,
+	})
+	require.Nil(t, err)
+
+	masterAddr := func() string {
+		conn, err := c.GetMaster("test")
+		require.Nil(t, err)
+		defer c.PutMaster("test", conn)
+		return conn.Addr
+	}
+	assert.Equal(t, m1, masterAddr())
+
+	// a failover which happens while the connection to sentinel is down is
+	// caught once it's back
+	fs.setMaster(m2)
+	fs.killSubs()
+	waitFor(t, func() bool { return masterAddr() == m2 })
+	waitFor(t, func() bool {
+		return len(rec.Entries("sentinel connection re-established")) == 1
+	})
+	assert.Len(t, rec.Entries("sentinel connection lost"), 1)
+
+	// and events are received on the new connection
+	fs.publish("+switch-master", "test "+hostPort(m2)+" "+hostPort(m3))
+	waitFor(t, func() bool { return masterAddr() == m3 })
+
+	// the Stats are those of the current master's pool
+	s, err := c.Master("test").Stats()
+	require.Nil(t, err)
+	assert.Equal(t, 1, s.Idle)
+	_, err = c.Stats("dne")
+	assert.NotNil(t, err)
+
+	c.Close()
+	c.Close()
+}
+
+func TestMaxActiveFailover(t *T) {
+	m1, m2 := listen(t), listen(t)
+	c := &Client{
+		masterPools:    map[string]*pool.Pool{},
+		lastFailover:   map[string]time.Time{},
+		replicas:       map[string]*replicaSet{},
+		poolOpts:       pool.Opts{Network: "tcp", Size: 1, MaxActive: 1},
+		logger:         log.Nop,
+		getCh:          make(chan *getReq),
+		putCh:          make(chan *putReq),
+		callCh:         make(chan func(*Client)),
+		closeCh:        make(chan struct{}),
+		doneCh:         make(chan struct{}),
+		switchMasterCh: make(chan *switchMaster),
+	}
+	var err error
+	c.masterPools["test"], err = c.newPool(m1)
+	require.Nil(t, err)
+	go c.spin()
+
+	old, err := c.GetMaster("test")
+	require.Nil(t, err)
+
+	// a GetMaster waiting on the old master is woken by the failover, without
+	// holding up anything else in the meantime
+	errCh := make(chan error)
+	go func() {
+		_, err := c.GetMaster("test")
+		errCh <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	c.switchMasterCh <- &switchMaster{name: "test", addr: m2}
+	select {
+	case err := <-errCh:
+		require.NotNil(t, err)
+		assert.Equal(t, ErrMasterChanged.Error(), err.Error())
+	case <-time.After(5 * time.Second):
+		t.Fatal("GetMaster still waiting after failover")
+	}
+
+	// the new master's pool is used from then on, and the old master's
+	// connection doesn't end up in it
+	conn, err := c.GetMaster("test")
+	require.Nil(t, err)
+	assert.Equal(t, m2, conn.Addr)
+	c.PutMaster("test", old)
+	c.call(func(c *Client) {
+		assert.Equal(t, 0, c.masterPools["test"].Avail())
+	})
+	c.PutMaster("test", conn)
+}
+
+func TestCloseWithTimeout(t *T) {
+	conn, err := redis.Dial("tcp", listen(t))
+	require.Nil(t, err)
+	c := &Client{
+		masterPools:    map[string]*pool.Pool{},
+		lastFailover:   map[string]time.Time{},
+		replicas:       map[string]*replicaSet{},
+		poolOpts:       pool.Opts{Network: "tcp", Size: 1},
+		logger:         log.Nop,
+		subClient:      pubsub.NewSubClient(conn),
+		getCh:          make(chan *getReq),
+		putCh:          make(chan *putReq),
+		callCh:         make(chan func(*Client)),
+		closeCh:        make(chan struct{}),
+		doneCh:         make(chan struct{}),
+		switchMasterCh: make(chan *switchMaster),
+	}
+	c.masterPools["test"], err = c.newPool(listen(t))
+	require.Nil(t, err)
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.spin()
+	}()
+
+	m, err := c.GetMaster("test")
+	require.Nil(t, err)
+	closed := make(chan error)
+	go func() { closed <- c.CloseWithTimeout(5 * time.Second) }()
+	time.Sleep(50 * time.Millisecond)
+
+	// nothing new is handed out, but the connection in use is waited for
+	_, err = c.GetMaster("test")
+	require.NotNil(t, err)
+	assert.Equal(t, ErrClosed.Error(), err.Error())
+	assert.Equal(t, ErrClosed.Error(), c.DoMaster("test", func(*redis.Client) error {
+		return nil
+	}).Error())
+	select {
+	case <-closed:
+		t.Fatal("CloseWithTimeout returned before the connection was put back")
+	default:
+	}
+	c.PutMaster("test", m)
+	assert.Nil(t, <-closed)
+
+	// putting back after the Client is done doesn't block
+	c.PutMaster("test", m)
+	assert.Nil(t, c.CloseWithTimeout(time.Second))
+}
+
+func TestLazyPools(t *T) {
+	fs := newFakeSentinel(t)
+	defer fs.Close()
+	m1, m2 := listen(t), listen(t)
+	fs.setMaster(m1)
+
+	c, err := NewClientWithOpts(Opts{
+		Network:   "tcp",
+		Addr:      fs.Addr().String(),
+		Names:     []string{"a", "b"},
+		PoolSize:  1,
+		LazyPools: true,
+	})
+	require.Nil(t, err)
+	defer c.Close()
+
+	// nothing is connected to until it's used
+	for _, name := range []string{"a", "b"} {
+		s, err := c.Stats(name)
+		require.Nil(t, err)
+		assert.Equal(t, pool.Stats{}, s)
+	}
+
+	// a failover of a master which hasn't been used yet is still followed
+	fs.publish("+switch-master", "a "+hostPort(m1)+" "+hostPort(m2))
+	masterAddr := func(name string) string {
+		var addr string
+		require.Nil(t, c.DoMaster(name, func(conn *redis.Client) error {
+			addr = conn.Addr
+			return nil
+		}))
+		return addr
+	}
+	waitFor(t, func() bool { return masterAddr("a") == m2 })
+	s, err := c.Stats("a")
+	require.Nil(t, err)
+	assert.Equal(t, 1, s.Idle)
+
+	// and only the master which failed over is changed
+	assert.Equal(t, m1, masterAddr("b"))
+
+	err = c.DoMaster("dne", func(*redis.Client) error { return nil })
+	require.NotNil(t, err)
+	assert.Equal(t, "unknown name: dne", err.Error())
+	_, err = c.Stats("dne")
+	assert.NotNil(t, err)
+}
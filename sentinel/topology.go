@@ -0,0 +1,195 @@
+package sentinel
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// NodeInfo describes a single master, replica or sentinel, as it was reported
+// by sentinel
+type NodeInfo struct {
+	Addr  string
+	RunID string
+
+	// The flags sentinel has set on the node, e.g. "master", "slave",
+	// "s_down", "o_down" or "disconnected"
+	Flags []string
+
+	// How long before the topology was fetched sentinel last got a valid reply
+	// to a PING from the node
+	LastOKPing time.Duration
+
+	// Only set for sentinels. How long before the topology was fetched the
+	// node last sent a hello message.
+	LastHello time.Duration
+
+	// Every field sentinel returned for the node, including those above
+	Fields map[string]string
+}
+
+// HasFlag returns whether the given flag is set on the node
+func (ni NodeInfo) HasFlag(flag string) bool {
+	for _, f := range ni.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// MasterTopology describes everything known about a single master name
+type MasterTopology struct {
+	Name string
+
+	// The master and its replicas as returned by SENTINEL MASTER and SENTINEL
+	// SLAVES, as of when the topology was fetched
+	Master   NodeInfo
+	Replicas []NodeInfo
+
+	// The other sentinels monitoring this master, as returned by SENTINEL
+	// SENTINELS, as of when the topology was fetched. The sentinel the Client
+	// is connected to isn't included.
+	Sentinels []NodeInfo
+
+	// The address of the master the Client's pool for this name is currently
+	// connected to. This is always current, even if the rest of the topology
+	// is not, and may differ from Master's Addr after a failover.
+	PoolAddr string
+
+	// When the Client last switched its pool for this name to a new master
+	// because of a failover, or the zero value if it never has
+	LastFailover time.Time
+}
+
+// Topology describes every master the Client has pools for, along with their
+// replicas and the sentinels monitoring them
+type Topology struct {
+	Masters map[string]MasterTopology
+
+	// When the data from sentinel was fetched
+	FetchedAt time.Time
+}
+
+// Topology returns the Client's view of the deployment. The data from
+// sentinel is cached, and only fetched when Topology is first called or when
+// RefreshTopology is called, but the Client's own bookkeeping (PoolAddr and
+// LastFailover) is always current. The returned error is a *ClientError.
+func (c *Client) Topology() (*Topology, error) {
+	c.topoL.Lock()
+	defer c.topoL.Unlock()
+	if c.topo == nil {
+		if err := c.refreshTopology(); err != nil {
+			return nil, err
+		}
+	}
+
+	topo := &Topology{
+		Masters:   make(map[string]MasterTopology, len(c.topo.Masters)),
+		FetchedAt: c.topo.FetchedAt,
+	}
+	if !c.call(func(c *Client) {
+		for name, mt := range c.topo.Masters {
+			if p, ok := c.masterPools[name]; ok && p != nil {
+				mt.PoolAddr = p.Addr
+			}
+			mt.LastFailover = c.lastFailover[name]
+			topo.Masters[name] = mt
+		}
+	}) {
+		return nil, &ClientError{err: ErrClosed}
+	}
+	return topo, nil
+}
+
+// RefreshTopology fetches the latest data from sentinel for the cache used by
+// Topology. A new connection to sentinel is made to do so. The returned error
+// is a *ClientError.
+func (c *Client) RefreshTopology() error {
+	c.topoL.Lock()
+	defer c.topoL.Unlock()
+	return c.refreshTopology()
+}
+
+func (c *Client) refreshTopology() error {
+	conn, err := c.dialSentinel()
+	if err != nil {
+		return &ClientError{err: err, SentinelErr: true}
+	}
+	defer conn.Close()
+
+	topo := &Topology{
+		Masters:   make(map[string]MasterTopology, len(c.names)),
+		FetchedAt: time.Now(),
+	}
+	for _, name := range c.names {
+		mt, err := fetchMasterTopology(conn, name)
+		if err != nil {
+			return &ClientError{err: err, SentinelErr: true}
+		}
+		topo.Masters[name] = mt
+	}
+	c.topo = topo
+	return nil
+}
+
+func fetchMasterTopology(conn redis.Cmder, name string) (MasterTopology, error) {
+	mt := MasterTopology{Name: name}
+	var err error
+	if mt.Master, err = parseNodeInfo(conn.Cmd("SENTINEL", "MASTER", name)); err != nil {
+		return mt, err
+	}
+	if mt.Replicas, err = parseNodeInfos(conn.Cmd("SENTINEL", "SLAVES", name)); err != nil {
+		return mt, err
+	}
+	if mt.Sentinels, err = parseNodeInfos(conn.Cmd("SENTINEL", "SENTINELS", name)); err != nil {
+		return mt, err
+	}
+	return mt, nil
+}
+
+func parseNodeInfos(r *redis.Resp) ([]NodeInfo, error) {
+	arr, err := r.Array()
+	if err != nil {
+		return nil, err
+	}
+	nis := make([]NodeInfo, len(arr))
+	for i := range arr {
+		if nis[i], err = parseNodeInfo(arr[i]); err != nil {
+			return nil, err
+		}
+	}
+	return nis, nil
+}
+
+// parseNodeInfo parses the flat list of fields and values sentinel returns for
+// a single node
+func parseNodeInfo(r *redis.Resp) (NodeInfo, error) {
+	m, err := r.Map()
+	if err != nil {
+		return NodeInfo{}, err
+	}
+	ni := NodeInfo{
+		Addr:       m["ip"] + ":" + m["port"],
+		RunID:      m["runid"],
+		LastOKPing: msField(m, "last-ok-ping-reply"),
+		LastHello:  msField(m, "last-hello-message"),
+		Fields:     m,
+	}
+	if flags := m["flags"]; flags != "" {
+		ni.Flags = strings.Split(flags, ",")
+	}
+	return ni, nil
+}
+
+// msField returns the field, which is a number of milliseconds, as a
+// Duration, or zero if it's not set
+func msField(m map[string]string, field string) time.Duration {
+	ms, err := strconv.ParseInt(m[field], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(ms) * time.Millisecond
+}
@@ -0,0 +1,104 @@
+package sentinel
+
+import (
+	. "testing"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/log"
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/pubsub"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNodeInfo(t *T) {
+	r := redis.NewResp([]string{
+		"name", "127.0.0.1:28001",
+		"ip", "127.0.0.1",
+		"port", "28001",
+		"runid", "abc",
+		"flags", "sentinel,s_down",
+		"last-ok-ping-reply", "250",
+		"last-hello-message", "1500",
+	})
+	ni, err := parseNodeInfo(r)
+	require.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:28001", ni.Addr)
+	assert.Equal(t, "abc", ni.RunID)
+	assert.Equal(t, []string{"sentinel", "s_down"}, ni.Flags)
+	assert.True(t, ni.HasFlag("s_down"))
+	assert.False(t, ni.HasFlag("o_down"))
+	assert.Equal(t, 250*time.Millisecond, ni.LastOKPing)
+	assert.Equal(t, 1500*time.Millisecond, ni.LastHello)
+	assert.Equal(t, "abc", ni.Fields["runid"])
+
+	nis, err := parseNodeInfos(redis.NewResp([]interface{}{}))
+	require.Nil(t, err)
+	assert.Empty(t, nis)
+}
+
+func TestTopology(t *T) {
+	s := getSentinel(t)
+	topo, err := s.Topology()
+	require.Nil(t, err)
+
+	mt, ok := topo.Masters["test"]
+	require.True(t, ok)
+	assert.Equal(t, "test", mt.Name)
+	assert.True(t, mt.Master.HasFlag("master"))
+	assert.Equal(t, mt.Master.Addr, mt.PoolAddr)
+	assert.True(t, mt.LastFailover.IsZero())
+	for _, r := range mt.Replicas {
+		assert.True(t, r.HasFlag("slave"))
+	}
+
+	// served from the cache until refreshed
+	topo2, err := s.Topology()
+	require.Nil(t, err)
+	assert.Equal(t, topo.FetchedAt, topo2.FetchedAt)
+	require.Nil(t, s.RefreshTopology())
+	topo2, err = s.Topology()
+	require.Nil(t, err)
+	assert.True(t, topo2.FetchedAt.After(topo.FetchedAt))
+}
+
+func TestTopologyClosed(t *T) {
+	conn, err := redis.Dial("tcp", listen(t))
+	require.Nil(t, err)
+	c := &Client{
+		masterPools:    map[string]*pool.Pool{},
+		lastFailover:   map[string]time.Time{},
+		replicas:       map[string]*replicaSet{},
+		logger:         log.Nop,
+		subClient:      pubsub.NewSubClient(conn),
+		getCh:          make(chan *getReq),
+		putCh:          make(chan *putReq),
+		callCh:         make(chan func(*Client)),
+		closeCh:        make(chan struct{}),
+		doneCh:         make(chan struct{}),
+		switchMasterCh: make(chan *switchMaster),
+		topo:           &Topology{Masters: map[string]MasterTopology{}},
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.spin()
+	}()
+	_, err = c.Topology()
+	require.Nil(t, err)
+	c.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := c.Topology()
+		errCh <- err
+	}()
+	select {
+	case err := <-errCh:
+		require.NotNil(t, err)
+		assert.Equal(t, ErrClosed.Error(), err.Error())
+	case <-time.After(time.Second):
+		t.Fatal("Topology blocked after Close")
+	}
+}
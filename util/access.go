@@ -0,0 +1,267 @@
+package util
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/cluster"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// KeyAccess describes how recently or how often a key has been accessed, as
+// reported by OBJECT IDLETIME or OBJECT FREQ. Which one is used depends on the
+// instance's maxmemory-policy: when it's one of the LFU policies only Freq is
+// filled in and LFU is true, otherwise only Idle is.
+type KeyAccess struct {
+	Key  string
+	LFU  bool
+	Idle time.Duration
+	Freq int64
+}
+
+// AccessOpts are options which can be passed into SampleAccess. All fields are
+// optional.
+type AccessOpts struct {
+	// The maximum number of keys to sample. If zero every key matched by the
+	// ScanOpts is looked at. When used with a Cluster the keys are split
+	// between the instances in proportion to the number of keys each holds
+	// (as reported by DBSIZE).
+	Sample int
+
+	// How many OBJECT calls are pipelined together. Defaults to 100.
+	BatchSize int
+
+	// When used with a Cluster, how many instances are scanned at once.
+	// Defaults to 1.
+	Concurrency int
+}
+
+// SampleAccess SCANs over the keys matching the given ScanOpts (whose Command
+// must be SCAN), looks up how each has been accessed, and calls fn with the
+// result. The lookups are pipelined in batches, and keys which are deleted
+// before they can be looked at are skipped. If fn returns an error
+// SampleAccess stops and returns that error.
+//
+// Whether to use OBJECT IDLETIME or OBJECT FREQ is decided by calling CONFIG
+// GET maxmemory-policy. If CONFIG isn't available, or the policy is changed
+// while sampling, the error redis returns for the wrong OBJECT subcommand is
+// used to switch to the other one.
+//
+// If the Cmder is a Cluster every master will be sampled, possibly at the same
+// time depending on the Concurrency option. fn will never be called
+// concurrently.
+func SampleAccess(c Cmder, so ScanOpts, o AccessOpts, fn func(KeyAccess) error) error {
+	if o.BatchSize == 0 {
+		o.BatchSize = 100
+	}
+	if o.Concurrency == 0 {
+		o.Concurrency = 1
+	}
+
+	cc, ok := c.(*cluster.Cluster)
+	if !ok {
+		var err error
+		if cerr := withClientForKey(c, "", func(c Cmder) {
+			err = sampleAccess(c, so, o, o.Sample, fn)
+		}); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+
+	limits := map[string]int{}
+	if o.Sample > 0 {
+		var err error
+		if limits, err = clusterSampleLimits(cc, o.Sample); err != nil {
+			return err
+		}
+	}
+
+	// fnL makes sure fn isn't called concurrently, and once it returns an
+	// error it isn't called again
+	var fnL sync.Mutex
+	var fnErr error
+	lockedFn := func(ka KeyAccess) error {
+		fnL.Lock()
+		defer fnL.Unlock()
+		if fnErr == nil {
+			fnErr = fn(ka)
+		}
+		return fnErr
+	}
+
+	return withEveryClientConcurrently(cc, o.Concurrency, func(addr string, client *redis.Client) error {
+		limit := 0
+		if o.Sample > 0 {
+			if limit = limits[addr]; limit == 0 {
+				return nil
+			}
+		}
+		return sampleAccess(client, so, o, limit, lockedFn)
+	})
+}
+
+// clusterSampleLimits splits sample between the instances of the cluster in
+// proportion to their DBSIZE. Every instance with at least one key gets at
+// least one sample.
+func clusterSampleLimits(c *cluster.Cluster, sample int) (map[string]int, error) {
+	sizes := map[string]int64{}
+	var total int64
+	err := withEveryClient(c, func(addr string, client Cmder) error {
+		size, err := client.Cmd("DBSIZE").Int64()
+		sizes[addr] = size
+		total += size
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	limits := make(map[string]int, len(sizes))
+	for addr, size := range sizes {
+		if size == 0 {
+			continue
+		}
+		limit := int(int64(sample) * size / total)
+		if limit == 0 {
+			limit = 1
+		}
+		limits[addr] = limit
+	}
+	return limits, nil
+}
+
+func isLFUPolicy(c Cmder) bool {
+	params, err := ConfigGet(c, "maxmemory-policy")
+	if err != nil {
+		return false
+	}
+	return strings.Contains(strings.ToLower(params["maxmemory-policy"]), "lfu")
+}
+
+// isWrongPolicy returns whether the error is the one redis returns when OBJECT
+// FREQ or OBJECT IDLETIME is called under the wrong maxmemory-policy
+func isWrongPolicy(r *redis.Resp) bool {
+	return r.IsType(redis.AppErr) &&
+		strings.Contains(strings.ToLower(r.Err.Error()), "maxmemory policy")
+}
+
+func sampleAccess(c Cmder, so ScanOpts, o AccessOpts, limit int, fn func(KeyAccess) error) error {
+	lfu := isLFUPolicy(c)
+	lookup := func(keys []string) []*redis.Resp {
+		sub := "IDLETIME"
+		if lfu {
+			sub = "FREQ"
+		}
+		cmds := make([][]interface{}, len(keys))
+		for i, key := range keys {
+			cmds[i] = []interface{}{"OBJECT", sub, key}
+		}
+		return pipelineCmds(c, cmds)
+	}
+
+	s := NewScanner(c, so)
+	keys := make([]string, 0, o.BatchSize)
+	flush := func() error {
+		rr := lookup(keys)
+		for _, r := range rr {
+			if isWrongPolicy(r) {
+				lfu = !lfu
+				rr = lookup(keys)
+				break
+			}
+		}
+
+		for i, r := range rr {
+			if r.IsType(redis.Nil) {
+				continue
+			}
+			n, err := r.Int64()
+			if err != nil {
+				return err
+			}
+			ka := KeyAccess{Key: keys[i], LFU: lfu}
+			if lfu {
+				ka.Freq = n
+			} else {
+				ka.Idle = time.Duration(n) * time.Second
+			}
+			if err := fn(ka); err != nil {
+				return err
+			}
+		}
+		keys = keys[:0]
+		return nil
+	}
+
+	var n int
+	for (limit <= 0 || n < limit) && s.HasNext() {
+		n++
+		if keys = append(keys, s.Next()); len(keys) == o.BatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// AccessBucket summarizes the access patterns of a group of keys. Only the
+// Idle fields are filled in for keys sampled with OBJECT IDLETIME, and only the
+// Freq fields for keys sampled with OBJECT FREQ.
+type AccessBucket struct {
+	Keys int64
+
+	// The shortest, longest, and total idle times of the keys
+	MinIdle, MaxIdle, TotalIdle time.Duration
+
+	// The highest and total access frequency of the keys
+	MaxFreq, TotalFreq int64
+}
+
+// SampleAccessByBucket is like SampleAccess, but rather than calling a function
+// for every key it groups the keys into buckets, using the given function to
+// choose each key's bucket, and returns the summary for each bucket. For
+// example, to find key prefixes which haven't been used in a day:
+//
+//	buckets, err := util.SampleAccessByBucket(c, so, o, func(key string) string {
+//		return strings.SplitN(key, ":", 2)[0]
+//	})
+//	for prefix, b := range buckets {
+//		if b.MinIdle > 24*time.Hour {
+//			fmt.Println(prefix)
+//		}
+//	}
+func SampleAccessByBucket(
+	c Cmder, so ScanOpts, o AccessOpts, bucket func(key string) string,
+) (
+	map[string]AccessBucket, error,
+) {
+	m := map[string]AccessBucket{}
+	err := SampleAccess(c, so, o, func(ka KeyAccess) error {
+		b := bucket(ka.Key)
+		ab := m[b]
+		if ab.Keys == 0 || ka.Idle < ab.MinIdle {
+			ab.MinIdle = ka.Idle
+		}
+		if ka.Idle > ab.MaxIdle {
+			ab.MaxIdle = ka.Idle
+		}
+		if ka.Freq > ab.MaxFreq {
+			ab.MaxFreq = ka.Freq
+		}
+		ab.Keys++
+		ab.TotalIdle += ka.Idle
+		ab.TotalFreq += ka.Freq
+		m[b] = ab
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,110 @@
+package util
+
+import (
+	"errors"
+	"strings"
+	. "testing"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/cluster"
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampleAccess(t *T) {
+	p, err := pool.New("tcp", "127.0.0.1:6379", 10)
+	require.Nil(t, err)
+	c, err := cluster.New("127.0.0.1:7000")
+	require.Nil(t, err)
+
+	for _, c := range []Cmder{p, c} {
+		prefix, fullMap := randPrefix(t, c, 250)
+		so := ScanOpts{Command: "SCAN", Pattern: prefix + ":*"}
+		o := AccessOpts{BatchSize: 30, Concurrency: 2}
+
+		testMap := map[string]bool{}
+		err := SampleAccess(c, so, o, func(ka KeyAccess) error {
+			assert.False(t, ka.LFU)
+			testMap[ka.Key] = true
+			return nil
+		})
+		require.Nil(t, err)
+		assert.Equal(t, fullMap, testMap)
+
+		buckets, err := SampleAccessByBucket(c, so, o, func(key string) string {
+			return strings.SplitN(key, ":", 2)[0]
+		})
+		require.Nil(t, err)
+		require.Len(t, buckets, 1)
+		assert.Equal(t, int64(250), buckets[prefix].Keys)
+
+		o.Sample = 50
+		var n int
+		err = SampleAccess(c, so, o, func(KeyAccess) error {
+			n++
+			return nil
+		})
+		require.Nil(t, err)
+		assert.True(t, n > 0 && n <= 50+3, "n:%d", n)
+	}
+}
+
+func TestSampleAccessScripted(t *T) {
+	policy := func(p string) *redis.Resp {
+		return redis.NewResp([]string{"maxmemory-policy", p})
+	}
+
+	c := &scriptedCmder{replies: []*redis.Resp{
+		policy("allkeys-lfu"),
+		scanReply("0", "a", "b", "c"),
+		redis.NewResp(10),
+		redis.NewResp(nil),
+		redis.NewResp(30),
+	}}
+	var kas []KeyAccess
+	fn := func(ka KeyAccess) error {
+		kas = append(kas, ka)
+		return nil
+	}
+	err := SampleAccess(c, ScanOpts{Command: "SCAN"}, AccessOpts{}, fn)
+	require.Nil(t, err)
+	assert.Equal(t, []KeyAccess{
+		{Key: "a", LFU: true, Freq: 10},
+		{Key: "c", LFU: true, Freq: 30},
+	}, kas)
+	assert.Equal(t, []interface{}{"OBJECT", "FREQ", "a"}, c.calls[2])
+
+	// CONFIG isn't available, so IDLETIME is tried first, and then FREQ once
+	// that fails
+	wrong := redis.NewResp(errors.New("ERR An LFU maxmemory policy is selected, idle time not tracked"))
+	c = &scriptedCmder{replies: []*redis.Resp{
+		redis.NewResp(errors.New("ERR unknown command 'CONFIG'")),
+		scanReply("0", "a", "b"),
+		wrong, wrong,
+		redis.NewResp(1), redis.NewResp(2),
+	}}
+	kas = nil
+	err = SampleAccess(c, ScanOpts{Command: "SCAN"}, AccessOpts{}, fn)
+	require.Nil(t, err)
+	assert.Equal(t, []KeyAccess{
+		{Key: "a", LFU: true, Freq: 1},
+		{Key: "b", LFU: true, Freq: 2},
+	}, kas)
+
+	// Sample limits the number of keys looked at
+	c = &scriptedCmder{replies: []*redis.Resp{
+		policy("allkeys-lru"),
+		scanReply("0", "a", "b", "c"),
+		redis.NewResp(5), redis.NewResp(7),
+	}}
+	kas = nil
+	err = SampleAccess(c, ScanOpts{Command: "SCAN"}, AccessOpts{Sample: 2}, fn)
+	require.Nil(t, err)
+	assert.Equal(t, []KeyAccess{
+		{Key: "a", Idle: 5 * time.Second},
+		{Key: "b", Idle: 7 * time.Second},
+	}, kas)
+	assert.Empty(t, c.replies)
+}
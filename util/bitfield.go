@@ -0,0 +1,173 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// BitFieldOps is a list of BITFIELD operations, built up by chaining its
+// methods and then run against a key with Exec. Each operation is checked as
+// it's added, and the first invalid one causes Exec to return an error without
+// sending anything.
+//
+//	res, err := util.BitField().Get("u8", 0).IncrBy("i16", "#1", 5).Exec(c, "foo")
+//
+// See http://redis.io/commands/bitfield for more on each operation.
+type BitFieldOps struct {
+	args []interface{}
+	n    int
+	err  error
+}
+
+// BitField returns an empty BitFieldOps
+func BitField() *BitFieldOps {
+	return &BitFieldOps{}
+}
+
+// validBitFieldType checks a type specifier like "u8" or "i16". Signed types
+// may be up to 64 bits and unsigned ones up to 63.
+func validBitFieldType(typ string) error {
+	if len(typ) < 2 {
+		return fmt.Errorf("invalid bitfield type %q", typ)
+	}
+	bits, err := strconv.Atoi(typ[1:])
+	if err != nil || typ[1] == '+' || typ[1] == '-' {
+		return fmt.Errorf("invalid bitfield type %q", typ)
+	}
+	switch typ[0] {
+	case 'i', 'I':
+		if bits < 1 || bits > 64 {
+			return fmt.Errorf("bitfield type %q must be between i1 and i64", typ)
+		}
+	case 'u', 'U':
+		if bits < 1 || bits > 63 {
+			return fmt.Errorf("bitfield type %q must be between u1 and u63", typ)
+		}
+	default:
+		return fmt.Errorf("invalid bitfield type %q", typ)
+	}
+	return nil
+}
+
+// bitFieldOffset checks an offset, which may be a non-negative integer or a
+// string. A string may be either a plain number or "#N", meaning N times the
+// width of the operation's type.
+func bitFieldOffset(offset interface{}) (string, error) {
+	var s string
+	switch o := offset.(type) {
+	case int:
+		s = strconv.FormatInt(int64(o), 10)
+	case int64:
+		s = strconv.FormatInt(o, 10)
+	case uint:
+		s = strconv.FormatUint(uint64(o), 10)
+	case uint64:
+		s = strconv.FormatUint(o, 10)
+	case string:
+		s = o
+	default:
+		return "", fmt.Errorf("invalid bitfield offset type %T", offset)
+	}
+
+	n := strings.TrimPrefix(s, "#")
+	if i, err := strconv.ParseUint(n, 10, 64); err != nil || n == "" || n[0] == '+' {
+		return "", fmt.Errorf("invalid bitfield offset %q", s)
+	} else if s[0] != '#' && i >= 1<<32 {
+		return "", fmt.Errorf("bitfield offset %q is too large", s)
+	}
+	return s, nil
+}
+
+func (b *BitFieldOps) add(op, typ string, offset interface{}, value ...interface{}) *BitFieldOps {
+	if b.err != nil {
+		return b
+	}
+	if err := validBitFieldType(typ); err != nil {
+		b.err = err
+		return b
+	}
+	off, err := bitFieldOffset(offset)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	b.args = append(b.args, op, strings.ToLower(typ), off)
+	b.args = append(b.args, value...)
+	b.n++
+	return b
+}
+
+// Get adds a GET operation, whose result is the value of the field
+func (b *BitFieldOps) Get(typ string, offset interface{}) *BitFieldOps {
+	return b.add("GET", typ, offset)
+}
+
+// Set adds a SET operation, whose result is the previous value of the field
+func (b *BitFieldOps) Set(typ string, offset interface{}, value int64) *BitFieldOps {
+	return b.add("SET", typ, offset, value)
+}
+
+// IncrBy adds an INCRBY operation, whose result is the new value of the field,
+// or nil if the FAIL overflow mode is in effect and the increment overflowed
+func (b *BitFieldOps) IncrBy(typ string, offset interface{}, incr int64) *BitFieldOps {
+	return b.add("INCRBY", typ, offset, incr)
+}
+
+// Overflow sets the overflow mode (WRAP, SAT or FAIL) of all SET and INCRBY
+// operations which come after it. It doesn't have a result.
+func (b *BitFieldOps) Overflow(mode string) *BitFieldOps {
+	if b.err != nil {
+		return b
+	}
+	switch mode = strings.ToUpper(mode); mode {
+	case "WRAP", "SAT", "FAIL":
+		b.args = append(b.args, "OVERFLOW", mode)
+	default:
+		b.err = fmt.Errorf("invalid bitfield overflow mode %q", mode)
+	}
+	return b
+}
+
+// Exec runs the operations against the given key, returning one result for
+// each GET, SET and INCRBY, in the order they were added. A nil result means
+// that an INCRBY (or SET) was not performed because it would have overflowed
+// in FAIL mode. SAT and WRAP never produce nil results.
+func (b *BitFieldOps) Exec(c Cmder, key string) ([]*int64, error) {
+	if b.err != nil {
+		return nil, b.err
+	} else if b.n == 0 {
+		return nil, errors.New("no bitfield operations given")
+	}
+
+	r := c.Cmd("BITFIELD", key, b.args)
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	return decodeBitField(r, b.n)
+}
+
+func decodeBitField(r *redis.Resp, n int) ([]*int64, error) {
+	arr, err := r.Array()
+	if err != nil {
+		return nil, err
+	} else if len(arr) != n {
+		return nil, fmt.Errorf("expected %d bitfield results, got %d", n, len(arr))
+	}
+
+	res := make([]*int64, len(arr))
+	for i, ir := range arr {
+		if ir.IsType(redis.Nil) {
+			continue
+		}
+		i64, err := ir.Int64()
+		if err != nil {
+			return nil, err
+		}
+		res[i] = &i64
+	}
+	return res, nil
+}
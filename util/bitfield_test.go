@@ -0,0 +1,103 @@
+package util
+
+import (
+	. "testing"
+
+	"github.com/levenlabs/golib/testutil"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func int64Ptrs(is ...interface{}) []*int64 {
+	res := make([]*int64, len(is))
+	for i := range is {
+		if is[i] != nil {
+			i64 := int64(is[i].(int))
+			res[i] = &i64
+		}
+	}
+	return res
+}
+
+func TestBitFieldArgs(t *T) {
+	b := BitField().Get("u8", 0).IncrBy("I16", "#1", 5).Overflow("sat").Set("i5", "100", -3)
+	require.Nil(t, b.err)
+	assert.Equal(t, []interface{}{
+		"GET", "u8", "0",
+		"INCRBY", "i16", "#1", int64(5),
+		"OVERFLOW", "SAT",
+		"SET", "i5", "100", int64(-3),
+	}, b.args)
+	assert.Equal(t, 3, b.n)
+
+	for _, b := range []*BitFieldOps{
+		BitField().Get("u64", 0),
+		BitField().Get("i65", 0),
+		BitField().Get("i0", 0),
+		BitField().Get("x8", 0),
+		BitField().Get("u", 0),
+		BitField().Get("u+8", 0),
+		BitField().Get("u8", -1),
+		BitField().Get("u8", "#"),
+		BitField().Get("u8", "#-1"),
+		BitField().Get("u8", "abc"),
+		BitField().Get("u8", 1.5),
+		BitField().Get("u8", int64(1)<<32),
+		BitField().Overflow("NOPE"),
+	} {
+		_, err := b.Exec(nil, "foo")
+		assert.NotNil(t, err, "args: %v", b.args)
+	}
+
+	// the first error sticks
+	b = BitField().Get("x8", 0).Get("u8", 0)
+	assert.NotNil(t, b.err)
+	assert.Empty(t, b.args)
+
+	_, err := BitField().Exec(nil, "foo")
+	assert.NotNil(t, err)
+}
+
+func TestBitFieldScripted(t *T) {
+	c := &scriptedCmder{replies: []*redis.Resp{
+		redis.NewResp([]interface{}{1, nil, 255}),
+		redis.NewResp([]interface{}{1}),
+	}}
+	b := BitField().Get("u8", 0).Overflow("FAIL").IncrBy("u8", 0, 255).IncrBy("u8", 8, 255)
+	res, err := b.Exec(c, "foo")
+	require.Nil(t, err)
+	assert.Equal(t, int64Ptrs(1, nil, 255), res)
+	assert.Equal(t, []interface{}{"BITFIELD", "foo", b.args}, c.calls[0])
+
+	// a mismatched number of results is an error
+	_, err = b.Exec(c, "foo")
+	assert.NotNil(t, err)
+}
+
+func TestBitField(t *T) {
+	c, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+	key := testutil.RandStr()
+
+	res, err := BitField().Set("u8", 0, 250).Get("u8", 0).Exec(c, key)
+	require.Nil(t, err)
+	assert.Equal(t, int64Ptrs(0, 250), res)
+
+	res, err = BitField().
+		IncrBy("u8", 0, 10).
+		Overflow("SAT").IncrBy("u8", 0, 10).
+		Overflow("FAIL").IncrBy("u8", 0, 10).IncrBy("u8", "#1", 1).
+		Exec(c, key)
+	require.Nil(t, err)
+	assert.Equal(t, int64Ptrs(4, 14, 24, 1), res)
+
+	res, err = BitField().
+		Set("u8", 0, 250).
+		Overflow("SAT").IncrBy("u8", 0, 10).
+		Overflow("FAIL").IncrBy("u8", 0, 10).
+		Overflow("WRAP").IncrBy("i8", 8, 200).
+		Exec(c, key)
+	require.Nil(t, err)
+	assert.Equal(t, int64Ptrs(24, 255, nil, -55), res)
+}
@@ -0,0 +1,89 @@
+package util
+
+import (
+	"errors"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// ErrCASConflict is returned from CASWith when the key was modified by someone
+// else on every attempt at swapping its value
+var ErrCASConflict = errors.New("compare-and-swap conflicted on every attempt")
+
+// The key's TTL is read and set again explicitly, rather than using KEEPTTL,
+// so that this works on versions of redis before 6.0
+var casScript = NewScript(1, `
+	local cur = redis.call("GET", KEYS[1])
+	if not cur then
+		return -1
+	elseif cur ~= ARGV[1] then
+		return 0
+	end
+	local ttl = redis.call("PTTL", KEYS[1])
+	if ttl > 0 then
+		redis.call("SET", KEYS[1], ARGV[2], "PX", ttl)
+	else
+		redis.call("SET", KEYS[1], ARGV[2])
+	end
+	return 1
+`)
+
+// CAS atomically sets the given key to newValue, but only if its current value
+// is oldValue, returning whether or not it was set. The key keeps whatever TTL
+// it had. If the key doesn't exist ErrKeyMissing is returned, rather than just
+// false.
+//
+// This is done with a single lua script, and so works with any of the Cmders
+// implemented in radix.v2, including Cluster.
+func CAS(c Cmder, key, oldValue, newValue string) (bool, error) {
+	n, err := casScript.Cmd(c, key, oldValue, newValue).Int()
+	if err != nil {
+		return false, err
+	} else if n < 0 {
+		return false, ErrKeyMissing
+	}
+	return n == 1, nil
+}
+
+// CASWith reads the given key, calls fn with its value, and then uses CAS to
+// set the key to the value fn returns. If the key was modified in between
+// being read and being set this is all done again from scratch, up to
+// maxRetries times, after which ErrCASConflict is returned. If fn returns an
+// error CASWith returns it immediately. Otherwise the value the key was set to
+// is returned.
+//
+// As with CAS, if the key doesn't exist ErrKeyMissing is returned and fn isn't
+// called.
+//
+//	_, err := util.CASWith(p, "foo", func(old string) (string, error) {
+//		return strings.ToUpper(old), nil
+//	}, 10)
+func CASWith(
+	c Cmder, key string, fn func(string) (string, error), maxRetries int,
+) (
+	string, error,
+) {
+	for i := 0; i <= maxRetries; i++ {
+		r := c.Cmd("GET", key)
+		if r.Err != nil {
+			return "", r.Err
+		} else if r.IsType(redis.Nil) {
+			return "", ErrKeyMissing
+		}
+		old, err := r.Str()
+		if err != nil {
+			return "", err
+		}
+
+		newValue, err := fn(old)
+		if err != nil {
+			return "", err
+		}
+		if ok, err := CAS(c, key, old, newValue); err != nil {
+			return "", err
+		} else if ok {
+			return newValue, nil
+		}
+	}
+	return "", ErrCASConflict
+}
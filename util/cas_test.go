@@ -0,0 +1,89 @@
+package util
+
+import (
+	"encoding/json"
+	"sync"
+	. "testing"
+
+	"github.com/levenlabs/golib/testutil"
+	"github.com/mediocregopher/radix.v2/cluster"
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCAS(t *T) {
+	p, err := pool.New("tcp", "127.0.0.1:6379", 10)
+	require.Nil(t, err)
+	c, err := cluster.New("127.0.0.1:7000")
+	require.Nil(t, err)
+
+	for _, c := range []Cmder{p, c} {
+		key := testutil.RandStr()
+		_, err := CAS(c, key, "foo", "bar")
+		assert.Equal(t, ErrKeyMissing, err)
+
+		require.Nil(t, c.Cmd("SET", key, "foo", "EX", 100).Err)
+		ok, err := CAS(c, key, "bar", "baz")
+		require.Nil(t, err)
+		assert.False(t, ok)
+
+		ok, err = CAS(c, key, "foo", "bar")
+		require.Nil(t, err)
+		assert.True(t, ok)
+		s, err := c.Cmd("GET", key).Str()
+		require.Nil(t, err)
+		assert.Equal(t, "bar", s)
+
+		// the TTL is kept
+		ttl, err := c.Cmd("TTL", key).Int()
+		require.Nil(t, err)
+		assert.True(t, ttl > 90)
+	}
+}
+
+func TestCASWith(t *T) {
+	p, err := pool.New("tcp", "127.0.0.1:6379", 10)
+	require.Nil(t, err)
+	c, err := cluster.New("127.0.0.1:7000")
+	require.Nil(t, err)
+
+	type counter struct {
+		N int `json:"n"`
+	}
+	incr := func(old string) (string, error) {
+		var ctr counter
+		if err := json.Unmarshal([]byte(old), &ctr); err != nil {
+			return "", err
+		}
+		ctr.N++
+		b, err := json.Marshal(ctr)
+		return string(b), err
+	}
+
+	for _, c := range []Cmder{p, c} {
+		key := testutil.RandStr()
+		_, err := CASWith(c, key, incr, 10)
+		assert.Equal(t, ErrKeyMissing, err)
+
+		require.Nil(t, c.Cmd("SET", key, `{"n":0}`).Err)
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					_, err := CASWith(c, key, incr, 1000)
+					assert.Nil(t, err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		var ctr counter
+		b, err := c.Cmd("GET", key).Bytes()
+		require.Nil(t, err)
+		require.Nil(t, json.Unmarshal(b, &ctr))
+		assert.Equal(t, 200, ctr.N)
+	}
+}
@@ -0,0 +1,123 @@
+package util
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// ClientInfo describes a single client connection, as given by CLIENT LIST.
+// See http://redis.io/commands/client-list for the meaning of each field.
+type ClientInfo struct {
+	ID    int64
+	Addr  string
+	Name  string
+	Age   time.Duration
+	Idle  time.Duration
+	Flags string
+	DB    int
+	Sub   int
+	PSub  int
+	Multi int
+	Cmd   string
+
+	// Raw holds every field which was given for the client, including those
+	// parsed into the other fields
+	Raw map[string]string
+}
+
+// ParseClientList parses the response from CLIENT LIST into a ClientInfo per
+// client. Fields which aren't known are only put into Raw, so that newer
+// versions of redis don't cause errors.
+//
+//	clients, err := util.ParseClientList(c.Cmd("CLIENT", "LIST"))
+func ParseClientList(r *redis.Resp) ([]ClientInfo, error) {
+	s, err := r.Str()
+	if err != nil {
+		return nil, err
+	}
+
+	var clients []ClientInfo
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		ci := ClientInfo{Raw: map[string]string{}}
+		for _, kv := range strings.Fields(line) {
+			i := strings.IndexByte(kv, '=')
+			if i < 0 {
+				continue
+			}
+			k, v := kv[:i], kv[i+1:]
+			ci.Raw[k] = v
+
+			switch k {
+			case "id":
+				ci.ID, _ = strconv.ParseInt(v, 10, 64)
+			case "addr":
+				ci.Addr = v
+			case "name":
+				ci.Name = v
+			case "age":
+				ci.Age = parseSeconds(v)
+			case "idle":
+				ci.Idle = parseSeconds(v)
+			case "flags":
+				ci.Flags = v
+			case "db":
+				ci.DB, _ = strconv.Atoi(v)
+			case "sub":
+				ci.Sub, _ = strconv.Atoi(v)
+			case "psub":
+				ci.PSub, _ = strconv.Atoi(v)
+			case "multi":
+				ci.Multi, _ = strconv.Atoi(v)
+			case "cmd":
+				ci.Cmd = v
+			}
+		}
+		clients = append(clients, ci)
+	}
+	return clients, nil
+}
+
+func parseSeconds(v string) time.Duration {
+	i, _ := strconv.ParseInt(v, 10, 64)
+	return time.Duration(i) * time.Second
+}
+
+// FindClients returns the clients for which fn returns true. For example, to
+// find clients which haven't run a command in the last 5 minutes:
+//
+//	idle := util.FindClients(clients, func(ci util.ClientInfo) bool {
+//		return ci.Idle > 5*time.Minute && ci.Cmd == "NULL"
+//	})
+func FindClients(clients []ClientInfo, fn func(ClientInfo) bool) []ClientInfo {
+	var found []ClientInfo
+	for _, ci := range clients {
+		if fn(ci) {
+			found = append(found, ci)
+		}
+	}
+	return found
+}
+
+// KillClients calls CLIENT KILL ID for each of the given clients, and returns
+// the number which were killed. Clients which had already disconnected are
+// not counted. The Cmder must be connected to the same instance the clients
+// were listed from.
+func KillClients(c Cmder, clients []ClientInfo) (int, error) {
+	var killed int
+	for _, ci := range clients {
+		n, err := c.Cmd("CLIENT", "KILL", "ID", ci.ID).Int()
+		if err != nil {
+			return killed, err
+		}
+		killed += n
+	}
+	return killed, nil
+}
@@ -0,0 +1,58 @@
+package util
+
+import (
+	. "testing"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseClientList(t *T) {
+	s := "id=3 addr=127.0.0.1:5000 name= age=10 idle=400 flags=N db=0 sub=0 psub=0 multi=-1 qbuf=0 cmd=NULL resp=2\n" +
+		"id=7 addr=127.0.0.1:5001 name=worker age=5 idle=0 flags=P db=2 sub=1 psub=2 multi=-1 cmd=subscribe\n"
+	clients, err := ParseClientList(redis.NewResp(s))
+	require.Nil(t, err)
+	require.Len(t, clients, 2)
+
+	assert.Equal(t, int64(3), clients[0].ID)
+	assert.Equal(t, "127.0.0.1:5000", clients[0].Addr)
+	assert.Equal(t, "", clients[0].Name)
+	assert.Equal(t, 10*time.Second, clients[0].Age)
+	assert.Equal(t, 400*time.Second, clients[0].Idle)
+	assert.Equal(t, -1, clients[0].Multi)
+	assert.Equal(t, "NULL", clients[0].Cmd)
+	assert.Equal(t, "2", clients[0].Raw["resp"])
+
+	assert.Equal(t, "worker", clients[1].Name)
+	assert.Equal(t, 2, clients[1].DB)
+	assert.Equal(t, 1, clients[1].Sub)
+	assert.Equal(t, 2, clients[1].PSub)
+
+	idle := FindClients(clients, func(ci ClientInfo) bool {
+		return ci.Idle > 300*time.Second && ci.Cmd == "NULL"
+	})
+	require.Len(t, idle, 1)
+	assert.Equal(t, int64(3), idle[0].ID)
+}
+
+func TestKillClients(t *T) {
+	c, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+	victim, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+	require.Nil(t, victim.Cmd("CLIENT", "SETNAME", "victim").Err)
+
+	clients, err := ParseClientList(c.Cmd("CLIENT", "LIST"))
+	require.Nil(t, err)
+	found := FindClients(clients, func(ci ClientInfo) bool {
+		return ci.Name == "victim"
+	})
+	require.Len(t, found, 1)
+
+	n, err := KillClients(c, found)
+	require.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.NotNil(t, victim.Cmd("PING").Err)
+}
@@ -0,0 +1,159 @@
+package util
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/mediocregopher/radix.v2/cluster"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// ConfigGet calls CONFIG GET with the given glob-style pattern, and returns
+// the matching parameters and their values
+func ConfigGet(c Cmder, pattern string) (map[string]string, error) {
+	return c.Cmd("CONFIG", "GET", pattern).Map()
+}
+
+// ConfigSetError is returned from ConfigSet when one or more parameters
+// couldn't be set. It maps each such parameter to the error redis returned
+// for it.
+type ConfigSetError map[string]error
+
+func (cse ConfigSetError) Error() string {
+	return errMapString("could not set config: ", cse)
+}
+
+// errMapString formats a map of name to error, sorted by name
+func errMapString(prefix string, m map[string]error) string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		msgs[i] = name + ": " + m[name].Error()
+	}
+	return prefix + strings.Join(msgs, ", ")
+}
+
+// ConfigSet sets all of the given configuration parameters using CONFIG SET.
+//
+// If there's more than one parameter then they're first all set in one call,
+// which is supported by redis 7.0 and later, and is atomic. If that fails,
+// either because redis is older or because one of the parameters is invalid,
+// each parameter is set in its own call, so that errors can be attributed to
+// individual parameters, and a ConfigSetError is returned if any fail. Note
+// that in that case the valid parameters will be set.
+func ConfigSet(c Cmder, params map[string]string) error {
+	if len(params) > 1 {
+		args := make([]interface{}, 0, len(params)*2+1)
+		args = append(args, "SET")
+		for k, v := range params {
+			args = append(args, k, v)
+		}
+		if c.Cmd("CONFIG", args...).Err == nil {
+			return nil
+		}
+	}
+
+	cse := ConfigSetError{}
+	for k, v := range params {
+		if err := c.Cmd("CONFIG", "SET", k, v).Err; err != nil {
+			cse[k] = err
+		}
+	}
+	if len(cse) > 0 {
+		return cse
+	}
+	return nil
+}
+
+// ClusterConfigGet calls ConfigGet on every instance in the cluster, and
+// returns the results keyed by instance address
+func ClusterConfigGet(c *cluster.Cluster, pattern string) (map[string]map[string]string, error) {
+	m := map[string]map[string]string{}
+	err := withEveryClient(c, func(addr string, client Cmder) error {
+		params, err := ConfigGet(client, pattern)
+		m[addr] = params
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ClusterConfigSet calls ConfigSet on every instance in the cluster. The
+// returned map is keyed by instance address, with the value being the error
+// ConfigSet returned for that instance (nil if it succeeded). The returned
+// error is only set if connections to the instances couldn't be retrieved.
+func ClusterConfigSet(c *cluster.Cluster, params map[string]string) (map[string]error, error) {
+	m := map[string]error{}
+	err := withEveryClient(c, func(addr string, client Cmder) error {
+		m[addr] = ConfigSet(client, params)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// withEveryClient calls fn with a client for every instance in the cluster, in
+// turn, stopping if fn returns an error. The clients are all put back once
+// it's done.
+func withEveryClient(c *cluster.Cluster, fn func(addr string, client Cmder) error) error {
+	clients, err := c.GetEvery()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, client := range clients {
+			c.Put(client)
+		}
+	}()
+
+	for addr, client := range clients {
+		if err := fn(addr, client); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withEveryClientConcurrently is like withEveryClient, but calls fn for up to
+// concurrency instances at once. Every call to fn is made regardless of
+// errors, and the first error encountered is returned.
+func withEveryClientConcurrently(
+	c *cluster.Cluster, concurrency int, fn func(addr string, client *redis.Client) error,
+) error {
+	clients, err := c.GetEvery()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, client := range clients {
+			c.Put(client)
+		}
+	}()
+
+	sem := make(chan struct{}, concurrency)
+	errCh := make(chan error, len(clients))
+	for addr, client := range clients {
+		sem <- struct{}{}
+		go func(addr string, client *redis.Client) {
+			errCh <- fn(addr, client)
+			<-sem
+		}(addr, client)
+	}
+
+	var firstErr error
+	for range clients {
+		if err := <-errCh; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,86 @@
+package util
+
+import (
+	"errors"
+	. "testing"
+
+	"github.com/mediocregopher/radix.v2/cluster"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig(t *T) {
+	c, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+
+	orig, err := ConfigGet(c, "slowlog-*")
+	require.Nil(t, err)
+	assert.Contains(t, orig, "slowlog-max-len")
+	assert.Contains(t, orig, "slowlog-log-slower-than")
+	defer ConfigSet(c, orig)
+
+	require.Nil(t, ConfigSet(c, map[string]string{
+		"slowlog-max-len":         "200",
+		"slowlog-log-slower-than": "20000",
+	}))
+	m, err := ConfigGet(c, "slowlog-*")
+	require.Nil(t, err)
+	assert.Equal(t, "200", m["slowlog-max-len"])
+	assert.Equal(t, "20000", m["slowlog-log-slower-than"])
+
+	err = ConfigSet(c, map[string]string{
+		"slowlog-max-len": "300",
+		"dne-param":       "1",
+	})
+	require.NotNil(t, err)
+	cse, ok := err.(ConfigSetError)
+	require.True(t, ok)
+	assert.Len(t, cse, 1)
+	assert.NotNil(t, cse["dne-param"])
+}
+
+func TestClusterConfig(t *T) {
+	c, err := cluster.New("127.0.0.1:7000")
+	require.Nil(t, err)
+
+	orig, err := ClusterConfigGet(c, "slowlog-max-len")
+	require.Nil(t, err)
+	assert.NotEmpty(t, orig)
+
+	res, err := ClusterConfigSet(c, map[string]string{"slowlog-max-len": "200"})
+	require.Nil(t, err)
+	assert.Len(t, res, len(orig))
+	for _, err := range res {
+		assert.Nil(t, err)
+	}
+
+	m, err := ClusterConfigGet(c, "slowlog-max-len")
+	require.Nil(t, err)
+	for addr, params := range m {
+		assert.Equal(t, "200", params["slowlog-max-len"])
+		client, err := redis.Dial("tcp", addr)
+		require.Nil(t, err)
+		require.Nil(t, ConfigSet(client, orig[addr]))
+	}
+}
+
+func TestConfigSetScripted(t *T) {
+	c := &scriptedCmder{replies: []*redis.Resp{
+		redis.NewResp(errors.New("ERR wrong number of arguments")),
+		redis.NewRespSimple("OK"),
+		redis.NewRespSimple("OK"),
+	}}
+	require.Nil(t, ConfigSet(c, map[string]string{"a": "1", "b": "2"}))
+	assert.Len(t, c.calls, 3)
+	assert.Len(t, c.calls[0], 6)
+
+	c = &scriptedCmder{replies: []*redis.Resp{redis.NewRespSimple("OK")}}
+	require.Nil(t, ConfigSet(c, map[string]string{"a": "1", "b": "2"}))
+	assert.Len(t, c.calls, 1)
+}
+
+func TestErrMapString(t *T) {
+	err := ConfigSetError{"b": errors.New("bad b"), "a": errors.New("bad a")}
+	assert.Equal(t, "could not set config: a: bad a, b: bad b", err.Error())
+}
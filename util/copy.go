@@ -0,0 +1,245 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mediocregopher/radix.v2/cluster"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// ErrKeyMissing is the Err on a CopyKeyResult for a key which didn't exist on
+// the source, unless SkipMissing was set. It's also returned from CAS and
+// CASWith when the key doesn't exist.
+var ErrKeyMissing = errors.New("key does not exist")
+
+// DumpVersionError is the Err on a CopyKeyResult for a key whose DUMP payload
+// uses a newer RDB version than the destination supports
+type DumpVersionError struct {
+	Version, MaxVersion int
+}
+
+func (e DumpVersionError) Error() string {
+	return fmt.Sprintf(
+		"dump payload uses RDB version %d, but destination only supports up to %d",
+		e.Version, e.MaxVersion,
+	)
+}
+
+// CopyKeysOpts are options which can be passed into CopyKeys. All fields are
+// optional.
+type CopyKeysOpts struct {
+	// If set keys which already exist on the destination are overwritten,
+	// otherwise they cause a BUSYKEY error for that key
+	Replace bool
+
+	// If set keys keep their remaining TTL on the destination, otherwise they
+	// are created without one
+	PreserveTTL bool
+
+	// If set keys which don't exist on the source are reported as not copied
+	// with no error, otherwise their Err is ErrKeyMissing
+	SkipMissing bool
+
+	// How many keys are DUMPed or RESTOREd in each pipeline. Defaults to 100.
+	BatchSize int
+}
+
+// CopyKeyResult describes the outcome of copying a single key with CopyKeys
+type CopyKeyResult struct {
+	Key    string
+	Copied bool
+	Err    error
+}
+
+// CopyKeys copies the given keys from src to dst using DUMP, PTTL and
+// RESTORE, returning a result for every key in the same order they were
+// given. The commands are pipelined in batches. The returned error is only set
+// if the copy couldn't be done at all, errors for individual keys are on their
+// results.
+//
+// Before RESTOREing a key its DUMP payload's RDB version is checked against
+// the version of redis dst is running, so that copying from a newer redis to
+// an older one fails with a DumpVersionError rather than the error redis gives
+// for a bad payload.
+func CopyKeys(src, dst Cmder, keys []string, o CopyKeysOpts) ([]CopyKeyResult, error) {
+	if o.BatchSize == 0 {
+		o.BatchSize = 100
+	}
+
+	maxVersion, err := maxRDBVersion(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]CopyKeyResult, 0, len(keys))
+	err = withSingleClient(src, func(src Cmder) error {
+		return withSingleClient(dst, func(dst Cmder) error {
+			for i := 0; i < len(keys); i += o.BatchSize {
+				end := i + o.BatchSize
+				if end > len(keys) {
+					end = len(keys)
+				}
+				res = append(res, copyKeys(src, dst, keys[i:end], maxVersion, o)...)
+			}
+			return nil
+		})
+	})
+	return res, err
+}
+
+// withSingleClient calls fn with a single connection from c if c is a Pool or
+// similar, so that pipelining can be used. Clusters are passed through as-is,
+// since different keys may need different connections.
+func withSingleClient(c Cmder, fn func(Cmder) error) error {
+	if _, ok := c.(*cluster.Cluster); ok {
+		return fn(c)
+	}
+	var err error
+	if cerr := withClientForKey(c, "", func(c Cmder) {
+		err = fn(c)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+func copyKeys(src, dst Cmder, keys []string, maxVersion int, o CopyKeysOpts) []CopyKeyResult {
+	res := make([]CopyKeyResult, len(keys))
+	cmds := make([][]interface{}, 0, len(keys)*2)
+	for i, key := range keys {
+		res[i].Key = key
+		cmds = append(cmds, []interface{}{"DUMP", key}, []interface{}{"PTTL", key})
+	}
+	dumpRR := pipelineCmds(src, cmds)
+
+	var replace []interface{}
+	if o.Replace {
+		replace = []interface{}{"REPLACE"}
+	}
+
+	// restoring holds the indexes into res of the keys being RESTOREd
+	restoring := make([]int, 0, len(keys))
+	cmds = cmds[:0]
+	for i := range keys {
+		dumpR, ttlR := dumpRR[i*2], dumpRR[i*2+1]
+		if dumpR.IsType(redis.Nil) {
+			if !o.SkipMissing {
+				res[i].Err = ErrKeyMissing
+			}
+			continue
+		}
+
+		payload, err := dumpR.Bytes()
+		if err != nil {
+			res[i].Err = err
+			continue
+		}
+		if err := checkDumpVersion(payload, maxVersion); err != nil {
+			res[i].Err = err
+			continue
+		}
+
+		var ttl int64
+		if o.PreserveTTL {
+			if ttl, err = ttlR.Int64(); err != nil {
+				res[i].Err = err
+				continue
+			} else if ttl == -2 {
+				// The key expired or was deleted between DUMP and PTTL
+				if !o.SkipMissing {
+					res[i].Err = ErrKeyMissing
+				}
+				continue
+			} else if ttl < 0 {
+				ttl = 0
+			}
+		}
+
+		restoring = append(restoring, i)
+		cmds = append(cmds, []interface{}{"RESTORE", keys[i], ttl, payload, replace})
+	}
+
+	for j, r := range pipelineCmds(dst, cmds) {
+		i := restoring[j]
+		if r.Err != nil {
+			res[i].Err = r.Err
+			continue
+		}
+		res[i].Copied = true
+	}
+	return res
+}
+
+// dumpVersion returns the RDB version a DUMP payload was encoded with, which
+// is stored as two little-endian bytes just before the 8 byte checksum at the
+// end of the payload
+func dumpVersion(payload []byte) (int, error) {
+	if len(payload) < 10 {
+		return 0, errors.New("dump payload is too short")
+	}
+	l := len(payload)
+	return int(payload[l-10]) | int(payload[l-9])<<8, nil
+}
+
+func checkDumpVersion(payload []byte, maxVersion int) error {
+	version, err := dumpVersion(payload)
+	if err != nil {
+		return err
+	} else if maxVersion > 0 && version > maxVersion {
+		return DumpVersionError{Version: version, MaxVersion: maxVersion}
+	}
+	return nil
+}
+
+// rdbVersions maps redis major.minor versions to the newest RDB version they
+// can load, in ascending order
+var rdbVersions = []struct {
+	major, minor, rdb int
+}{
+	{2, 6, 6},
+	{3, 2, 7},
+	{4, 0, 8},
+	{5, 0, 9},
+	{7, 0, 10},
+	{7, 2, 11},
+	{7, 4, 12},
+}
+
+// maxRDBVersion returns the newest RDB version the given instance can load,
+// based on its redis_version. If this can't be determined, e.g. because the
+// instance is newer than any version known here, 0 is returned.
+func maxRDBVersion(c Cmder) (int, error) {
+	info, err := ParseInfo(c.Cmd("INFO", "server"))
+	if err != nil {
+		return 0, err
+	}
+	return rdbVersionFor(info.Server.RedisVersion), nil
+}
+
+func rdbVersionFor(version string) int {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return 0
+	}
+	major, err1 := strconv.Atoi(parts[0])
+	minor, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		return 0
+	}
+
+	last := rdbVersions[len(rdbVersions)-1]
+	if major > last.major || (major == last.major && minor > last.minor) {
+		return 0
+	}
+
+	var rdb int
+	for _, v := range rdbVersions {
+		if major > v.major || (major == v.major && minor >= v.minor) {
+			rdb = v.rdb
+		}
+	}
+	return rdb
+}
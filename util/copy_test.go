@@ -0,0 +1,115 @@
+package util
+
+import (
+	"errors"
+	. "testing"
+
+	"github.com/levenlabs/golib/testutil"
+	"github.com/mediocregopher/radix.v2/cluster"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyKeys(t *T) {
+	src, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+	dst, err := cluster.New("127.0.0.1:7000")
+	require.Nil(t, err)
+
+	keyA, keyB, keyMissing := testutil.RandStr(), testutil.RandStr(), testutil.RandStr()
+	// a value which isn't valid utf8, to make sure the payload is treated as
+	// binary
+	val := string([]byte{0xff, 0x00, 0xfe, '\r', '\n'})
+	require.Nil(t, src.Cmd("SET", keyA, val, "EX", 100).Err)
+	require.Nil(t, src.Cmd("RPUSH", keyB, "a", "b").Err)
+	require.Nil(t, dst.Cmd("SET", keyB, "old").Err)
+
+	keys := []string{keyA, keyB, keyMissing}
+	res, err := CopyKeys(src, dst, keys, CopyKeysOpts{PreserveTTL: true, BatchSize: 2})
+	require.Nil(t, err)
+	require.Len(t, res, 3)
+	assert.Equal(t, CopyKeyResult{Key: keyA, Copied: true}, res[0])
+	assert.False(t, res[1].Copied)
+	assert.NotNil(t, res[1].Err) // BUSYKEY
+	assert.Equal(t, CopyKeyResult{Key: keyMissing, Err: ErrKeyMissing}, res[2])
+
+	got, err := dst.Cmd("GET", keyA).Str()
+	require.Nil(t, err)
+	assert.Equal(t, val, got)
+	ttl, err := dst.Cmd("TTL", keyA).Int()
+	require.Nil(t, err)
+	assert.True(t, ttl > 0 && ttl <= 100)
+
+	res, err = CopyKeys(src, dst, keys, CopyKeysOpts{Replace: true, SkipMissing: true})
+	require.Nil(t, err)
+	assert.Equal(t, []CopyKeyResult{
+		{Key: keyA, Copied: true},
+		{Key: keyB, Copied: true},
+		{Key: keyMissing},
+	}, res)
+	l, err := dst.Cmd("LRANGE", keyB, 0, -1).List()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, l)
+	ttl, err = dst.Cmd("TTL", keyA).Int()
+	require.Nil(t, err)
+	assert.Equal(t, -1, ttl)
+}
+
+func dumpPayload(version int) []byte {
+	return []byte{0, 1, 'a', byte(version), byte(version >> 8), 1, 2, 3, 4, 5, 6, 7, 8}
+}
+
+func TestCopyKeysScripted(t *T) {
+	info := redis.NewResp("# Server\r\nredis_version:6.2.6\r\n")
+	src := &scriptedCmder{replies: []*redis.Resp{
+		redis.NewResp(dumpPayload(9)),
+		redis.NewResp(5000),
+		redis.NewResp(dumpPayload(10)),
+		redis.NewResp(-1),
+		redis.NewResp(nil),
+		redis.NewResp(-2),
+	}}
+	dst := &scriptedCmder{replies: []*redis.Resp{
+		info,
+		redis.NewResp(errors.New("BUSYKEY Target key name already exists.")),
+	}}
+
+	res, err := CopyKeys(src, dst, []string{"a", "b", "c"}, CopyKeysOpts{PreserveTTL: true})
+	require.Nil(t, err)
+	assert.Equal(t, "a", res[0].Key)
+	assert.Equal(t, "BUSYKEY Target key name already exists.", res[0].Err.Error())
+	assert.Equal(t, CopyKeyResult{Key: "b", Err: DumpVersionError{10, 9}}, res[1])
+	assert.Equal(t, CopyKeyResult{Key: "c", Err: ErrKeyMissing}, res[2])
+
+	require.Len(t, dst.calls, 2)
+	assert.Equal(t, []interface{}{
+		"RESTORE", "a", int64(5000), dumpPayload(9), []interface{}(nil),
+	}, dst.calls[1])
+}
+
+func TestRDBVersionFor(t *T) {
+	for version, rdb := range map[string]int{
+		"2.6.17": 6,
+		"3.0.7":  6,
+		"3.2.12": 7,
+		"4.0.14": 8,
+		"6.2.6":  9,
+		"7.0.0":  10,
+		"7.2.4":  11,
+		"7.4.1":  12,
+		"8.0.0":  0,
+		"2.4.0":  0,
+		"bad":    0,
+	} {
+		assert.Equal(t, rdb, rdbVersionFor(version), "version: %s", version)
+	}
+
+	v, err := dumpVersion(dumpPayload(0x10a))
+	require.Nil(t, err)
+	assert.Equal(t, 0x10a, v)
+	_, err = dumpVersion([]byte("short"))
+	assert.NotNil(t, err)
+
+	assert.Nil(t, checkDumpVersion(dumpPayload(12), 0))
+}
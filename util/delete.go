@@ -0,0 +1,201 @@
+package util
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/cluster"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// DeleteOpts are options which can be passed into DeleteByPattern. All fields
+// are optional.
+type DeleteOpts struct {
+	// How many keys are deleted in each pipeline. Defaults to 100.
+	BatchSize int
+
+	// The maximum number of keys to delete per second. When used with a
+	// Cluster this is the limit across all instances. If zero there is no
+	// limit.
+	RatePerSec int
+
+	// If set nothing is deleted, and the keys which would have been are
+	// returned in Keys on DeleteProgress instead
+	DryRun bool
+
+	// If set UNLINK is used rather than DEL, so the memory of large keys is
+	// freed in the background. If the instance doesn't support UNLINK (redis
+	// 4.0 and later) DEL is used.
+	Unlink bool
+
+	// When used with a Cluster, how many instances are scanned at once.
+	// Defaults to 1.
+	Concurrency int
+
+	// If set this is called after every batch with the progress made so far.
+	// It will never be called concurrently.
+	Progress func(DeleteProgress)
+}
+
+// DeleteProgress describes how far a DeleteByPattern call has gotten
+type DeleteProgress struct {
+	// The number of keys which have been scanned, and how many of them have
+	// been deleted. A key which was deleted by something else in between being
+	// scanned and being deleted isn't counted in Deleted. When DryRun is set
+	// Deleted is always zero.
+	Scanned, Deleted int64
+
+	// How long has passed since DeleteByPattern was called
+	Elapsed time.Duration
+
+	// Only filled in when DryRun is set, in which case it's every key which
+	// would've been deleted. This is only filled in on the DeleteProgress
+	// returned from DeleteByPattern, not in those passed to Progress.
+	Keys []string
+}
+
+// deleteLimiter limits the rate at which keys are deleted, shared between all
+// the instances being scanned
+type deleteLimiter struct {
+	rate  int
+	start time.Time
+
+	l sync.Mutex
+	n int64
+}
+
+// wait blocks until n more keys can be deleted
+func (dl *deleteLimiter) wait(n int) {
+	if dl.rate <= 0 {
+		return
+	}
+	dl.l.Lock()
+	dl.n += int64(n)
+	until := dl.start.Add(time.Duration(dl.n) * time.Second / time.Duration(dl.rate))
+	dl.l.Unlock()
+	if d := until.Sub(time.Now()); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// DeleteByPattern SCANs for every key matching the given pattern and deletes
+// them in pipelined batches, sleeping as needed so as not to exceed the
+// configured rate. This is much safer to run against a busy instance than KEYS
+// followed by DEL, since no single command blocks redis for long.
+//
+// If the Cmder is a Cluster every master is scanned, possibly at the same time
+// depending on the Concurrency option. The returned DeleteProgress is the
+// total across all instances, and is returned even if there's an error.
+func DeleteByPattern(c Cmder, pattern string, o DeleteOpts) (DeleteProgress, error) {
+	if o.BatchSize == 0 {
+		o.BatchSize = 100
+	}
+	if o.Concurrency == 0 {
+		o.Concurrency = 1
+	}
+
+	start := time.Now()
+	dl := &deleteLimiter{rate: o.RatePerSec, start: start}
+
+	// progL protects prog, and makes sure Progress isn't called concurrently
+	var progL sync.Mutex
+	var prog DeleteProgress
+	report := func(scanned, deleted int64, keys []string) {
+		progL.Lock()
+		defer progL.Unlock()
+		prog.Scanned += scanned
+		prog.Deleted += deleted
+		prog.Elapsed = time.Since(start)
+		if o.DryRun {
+			prog.Keys = append(prog.Keys, keys...)
+		}
+		if o.Progress != nil {
+			p := prog
+			p.Keys = nil
+			o.Progress(p)
+		}
+	}
+
+	so := ScanOpts{Command: "SCAN", Pattern: pattern, Count: o.BatchSize}
+	var err error
+	if cc, ok := c.(*cluster.Cluster); ok {
+		err = withEveryClientConcurrently(cc, o.Concurrency, func(_ string, client *redis.Client) error {
+			return deleteByPattern(client, so, o, dl, report)
+		})
+	} else if cerr := withClientForKey(c, "", func(c Cmder) {
+		err = deleteByPattern(c, so, o, dl, report)
+	}); cerr != nil {
+		err = cerr
+	}
+
+	progL.Lock()
+	defer progL.Unlock()
+	prog.Elapsed = time.Since(start)
+	return prog, err
+}
+
+func deleteByPattern(
+	c Cmder, so ScanOpts, o DeleteOpts, dl *deleteLimiter,
+	report func(scanned, deleted int64, keys []string),
+) error {
+	delCmd := "DEL"
+	if o.Unlink {
+		delCmd = "UNLINK"
+	}
+
+	s := NewScanner(c, so)
+	keys := make([]string, 0, o.BatchSize)
+	flush := func() error {
+		if len(keys) == 0 {
+			return nil
+		} else if o.DryRun {
+			report(int64(len(keys)), 0, keys)
+			keys = keys[:0]
+			return nil
+		}
+
+		dl.wait(len(keys))
+		del := func() []*redis.Resp {
+			cmds := make([][]interface{}, len(keys))
+			for i, key := range keys {
+				cmds[i] = []interface{}{delCmd, key}
+			}
+			return pipelineCmds(c, cmds)
+		}
+		rr := del()
+		if delCmd == "UNLINK" && len(rr) > 0 && isUnknownCommand(rr[0]) {
+			delCmd = "DEL"
+			rr = del()
+		}
+
+		var deleted int64
+		for _, r := range rr {
+			n, err := r.Int64()
+			if err != nil {
+				return err
+			}
+			deleted += n
+		}
+		report(int64(len(keys)), deleted, nil)
+		keys = keys[:0]
+		return nil
+	}
+
+	for s.HasNext() {
+		if keys = append(keys, s.Next()); len(keys) == o.BatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+func isUnknownCommand(r *redis.Resp) bool {
+	return r.IsType(redis.AppErr) &&
+		strings.Contains(strings.ToLower(r.Err.Error()), "unknown command")
+}
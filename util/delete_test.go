@@ -0,0 +1,94 @@
+package util
+
+import (
+	"errors"
+	. "testing"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/cluster"
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteByPattern(t *T) {
+	p, err := pool.New("tcp", "127.0.0.1:6379", 10)
+	require.Nil(t, err)
+	c, err := cluster.New("127.0.0.1:7000")
+	require.Nil(t, err)
+
+	for _, c := range []Cmder{p, c} {
+		prefix, fullMap := randPrefix(t, c, 250)
+		pattern := prefix + ":*"
+
+		prog, err := DeleteByPattern(c, pattern, DeleteOpts{DryRun: true})
+		require.Nil(t, err)
+		assert.Equal(t, int64(250), prog.Scanned)
+		assert.Equal(t, int64(0), prog.Deleted)
+		keys := map[string]bool{}
+		for _, key := range prog.Keys {
+			keys[key] = true
+		}
+		assert.Equal(t, fullMap, keys)
+
+		var calls int
+		prog, err = DeleteByPattern(c, pattern, DeleteOpts{
+			BatchSize:   50,
+			Unlink:      true,
+			Concurrency: 2,
+			Progress:    func(DeleteProgress) { calls++ },
+		})
+		require.Nil(t, err)
+		assert.Equal(t, int64(250), prog.Scanned)
+		assert.Equal(t, int64(250), prog.Deleted)
+		assert.Nil(t, prog.Keys)
+		assert.True(t, calls >= 5)
+
+		prog, err = DeleteByPattern(c, pattern, DeleteOpts{})
+		require.Nil(t, err)
+		assert.Equal(t, int64(0), prog.Scanned)
+	}
+}
+
+func TestDeleteByPatternScripted(t *T) {
+	unknown := redis.NewResp(errors.New("ERR unknown command 'UNLINK'"))
+	c := &scriptedCmder{replies: []*redis.Resp{
+		scanReply("5", "a", "b"),
+		unknown, unknown,
+		redis.NewResp(1), redis.NewResp(0),
+		scanReply("0", "c"),
+		redis.NewResp(1),
+	}}
+
+	var progs []DeleteProgress
+	prog, err := DeleteByPattern(c, "*", DeleteOpts{
+		BatchSize: 2,
+		Unlink:    true,
+		Progress:  func(p DeleteProgress) { progs = append(progs, p) },
+	})
+	require.Nil(t, err)
+	assert.Equal(t, int64(3), prog.Scanned)
+	assert.Equal(t, int64(2), prog.Deleted)
+	require.Len(t, progs, 2)
+	assert.Equal(t, int64(2), progs[0].Scanned)
+	assert.Equal(t, int64(1), progs[0].Deleted)
+
+	assert.Equal(t, []interface{}{"SCAN", "", "MATCH", "*", "COUNT", 2}, c.calls[0])
+	assert.Equal(t, []interface{}{"UNLINK", "a"}, c.calls[1])
+	assert.Equal(t, []interface{}{"DEL", "a"}, c.calls[3])
+	assert.Equal(t, []interface{}{"DEL", "c"}, c.calls[6])
+}
+
+func TestDeleteLimiter(t *T) {
+	start := time.Now()
+	dl := &deleteLimiter{rate: 100, start: start}
+	dl.wait(5)
+	dl.wait(5)
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+
+	// no rate means no waiting
+	dl = &deleteLimiter{start: time.Now()}
+	dl.wait(1e6)
+	assert.True(t, time.Since(dl.start) < 10*time.Millisecond)
+}
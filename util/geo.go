@@ -0,0 +1,220 @@
+package util
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// GeoLocation is a member of a geospatial index, as returned by GeoPos and
+// GeoSearch. Fields which weren't asked for are left as their zero values.
+type GeoLocation struct {
+	Member   string
+	Lon, Lat float64
+	Dist     float64
+	Hash     int64
+}
+
+// GeoPos calls GEOPOS for the given members of the index at key. The returned
+// slice has an entry per member, which will be nil if the member doesn't
+// exist in the index.
+func GeoPos(c Cmder, key string, members ...string) ([]*GeoLocation, error) {
+	arr, err := c.Cmd("GEOPOS", key, members).Array()
+	if err != nil {
+		return nil, err
+	} else if len(arr) != len(members) {
+		return nil, errors.New("wrong number of positions returned")
+	}
+
+	locs := make([]*GeoLocation, len(arr))
+	for i, r := range arr {
+		if r.IsType(redis.Nil) {
+			continue
+		}
+		loc := &GeoLocation{Member: members[i]}
+		if loc.Lon, loc.Lat, err = parseGeoCoord(r); err != nil {
+			return nil, err
+		}
+		locs[i] = loc
+	}
+	return locs, nil
+}
+
+func parseGeoCoord(r *redis.Resp) (float64, float64, error) {
+	coord, err := r.List()
+	if err != nil {
+		return 0, 0, err
+	} else if len(coord) != 2 {
+		return 0, 0, errors.New("malformed coordinate")
+	}
+	lon, err := strconv.ParseFloat(coord[0], 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	lat, err := strconv.ParseFloat(coord[1], 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return lon, lat, nil
+}
+
+// GeoSearchOpts are the options which can be passed into GeoSearch. See
+// http://redis.io/commands/geosearch for more on each.
+type GeoSearchOpts struct {
+	// The center of the search is FromMember if it's set, or FromLon/FromLat
+	// otherwise
+	FromMember       string
+	FromLon, FromLat float64
+
+	// Exactly one of Radius (BYRADIUS) or Width/Height (BYBOX) must be set
+	Radius        float64
+	Width, Height float64
+
+	// One of "m", "km", "ft" or "mi". Defaults to "m".
+	Unit string
+
+	// If set, at most Count results are returned. If Any is also set then
+	// redis may return as soon as it's found enough, rather than the closest.
+	Count int
+	Any   bool
+
+	// Either "ASC" or "DESC" to sort the results by distance from the
+	// center, or empty to not sort
+	Sort string
+
+	WithCoord, WithDist, WithHash bool
+}
+
+func (o GeoSearchOpts) args() ([]interface{}, error) {
+	unit := o.Unit
+	if unit == "" {
+		unit = "m"
+	}
+
+	var args []interface{}
+	if o.FromMember != "" {
+		args = append(args, "FROMMEMBER", o.FromMember)
+	} else {
+		args = append(args, "FROMLONLAT", o.FromLon, o.FromLat)
+	}
+
+	byRadius, byBox := o.Radius > 0, o.Width > 0 || o.Height > 0
+	switch {
+	case byRadius && byBox:
+		return nil, errors.New("only one of Radius or Width/Height may be set")
+	case byRadius:
+		args = append(args, "BYRADIUS", o.Radius, unit)
+	case byBox:
+		args = append(args, "BYBOX", o.Width, o.Height, unit)
+	default:
+		return nil, errors.New("one of Radius or Width/Height must be set")
+	}
+
+	switch o.Sort {
+	case "":
+	case "ASC", "DESC":
+		args = append(args, o.Sort)
+	default:
+		return nil, errors.New("Sort must be ASC or DESC")
+	}
+
+	if o.Count > 0 {
+		args = append(args, "COUNT", o.Count)
+		if o.Any {
+			args = append(args, "ANY")
+		}
+	} else if o.Any {
+		return nil, errors.New("Any requires Count to be set")
+	}
+
+	if o.WithCoord {
+		args = append(args, "WITHCOORD")
+	}
+	if o.WithDist {
+		args = append(args, "WITHDIST")
+	}
+	if o.WithHash {
+		args = append(args, "WITHHASH")
+	}
+	return args, nil
+}
+
+// GeoSearch calls GEOSEARCH on the index at key using the given options, and
+// returns the matching members. GEOSEARCH requires redis 6.2 or later.
+func GeoSearch(c Cmder, key string, o GeoSearchOpts) ([]GeoLocation, error) {
+	args, err := o.args()
+	if err != nil {
+		return nil, err
+	}
+	return parseGeoSearch(c.Cmd("GEOSEARCH", key, args), o)
+}
+
+func parseGeoSearch(r *redis.Resp, o GeoSearchOpts) ([]GeoLocation, error) {
+	arr, err := r.Array()
+	if err != nil {
+		return nil, err
+	}
+
+	locs := make([]GeoLocation, len(arr))
+	for i, lr := range arr {
+		loc := &locs[i]
+		if !o.WithCoord && !o.WithDist && !o.WithHash {
+			if loc.Member, err = lr.Str(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// Each result is the member followed by whichever of the dist, hash
+		// and coordinate were asked for, always in that order
+		parts, err := lr.Array()
+		if err != nil {
+			return nil, err
+		} else if len(parts) == 0 {
+			return nil, errors.New("malformed search result")
+		}
+		if loc.Member, err = parts[0].Str(); err != nil {
+			return nil, err
+		}
+		parts = parts[1:]
+
+		next := func() (*redis.Resp, error) {
+			if len(parts) == 0 {
+				return nil, errors.New("malformed search result")
+			}
+			p := parts[0]
+			parts = parts[1:]
+			return p, nil
+		}
+
+		if o.WithDist {
+			p, err := next()
+			if err != nil {
+				return nil, err
+			}
+			if loc.Dist, err = p.Float64(); err != nil {
+				return nil, err
+			}
+		}
+		if o.WithHash {
+			p, err := next()
+			if err != nil {
+				return nil, err
+			}
+			if loc.Hash, err = p.Int64(); err != nil {
+				return nil, err
+			}
+		}
+		if o.WithCoord {
+			p, err := next()
+			if err != nil {
+				return nil, err
+			}
+			if loc.Lon, loc.Lat, err = parseGeoCoord(p); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return locs, nil
+}
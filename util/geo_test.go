@@ -0,0 +1,108 @@
+package util
+
+import (
+	. "testing"
+
+	"github.com/levenlabs/golib/testutil"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeoSearchOptsArgs(t *T) {
+	args, err := GeoSearchOpts{
+		FromMember: "a",
+		Radius:     10,
+		Unit:       "km",
+		Count:      5,
+		Any:        true,
+		Sort:       "ASC",
+		WithCoord:  true,
+		WithDist:   true,
+	}.args()
+	require.Nil(t, err)
+	assert.Equal(t, []interface{}{
+		"FROMMEMBER", "a", "BYRADIUS", 10.0, "km", "ASC", "COUNT", 5, "ANY",
+		"WITHCOORD", "WITHDIST",
+	}, args)
+
+	args, err = GeoSearchOpts{FromLon: 1, FromLat: 2, Width: 3, Height: 4}.args()
+	require.Nil(t, err)
+	assert.Equal(t, []interface{}{
+		"FROMLONLAT", 1.0, 2.0, "BYBOX", 3.0, 4.0, "m",
+	}, args)
+
+	for _, o := range []GeoSearchOpts{
+		{},
+		{Radius: 1, Width: 1},
+		{Radius: 1, Sort: "UP"},
+		{Radius: 1, Any: true},
+	} {
+		_, err := o.args()
+		assert.NotNil(t, err, "o:%#v", o)
+	}
+}
+
+func TestParseGeoSearch(t *T) {
+	r := redis.NewResp([]interface{}{
+		[]interface{}{"a", "1.5", 123, []string{"13.36", "38.11"}},
+	})
+	locs, err := parseGeoSearch(r, GeoSearchOpts{WithCoord: true, WithDist: true, WithHash: true})
+	require.Nil(t, err)
+	assert.Equal(t, []GeoLocation{
+		{Member: "a", Dist: 1.5, Hash: 123, Lon: 13.36, Lat: 38.11},
+	}, locs)
+
+	r = redis.NewResp([]interface{}{[]interface{}{"a", "1.5"}})
+	locs, err = parseGeoSearch(r, GeoSearchOpts{WithDist: true})
+	require.Nil(t, err)
+	assert.Equal(t, []GeoLocation{{Member: "a", Dist: 1.5}}, locs)
+
+	r = redis.NewResp([]string{"a", "b"})
+	locs, err = parseGeoSearch(r, GeoSearchOpts{})
+	require.Nil(t, err)
+	assert.Equal(t, []GeoLocation{{Member: "a"}, {Member: "b"}}, locs)
+
+	r = redis.NewResp([]interface{}{[]interface{}{"a"}})
+	_, err = parseGeoSearch(r, GeoSearchOpts{WithDist: true})
+	assert.NotNil(t, err)
+}
+
+func TestGeo(t *T) {
+	c, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+	key := testutil.RandStr()
+
+	require.Nil(t, c.Cmd(
+		"GEOADD", key,
+		13.361389, 38.115556, "Palermo",
+		15.087269, 37.502669, "Catania",
+	).Err)
+
+	locs, err := GeoPos(c, key, "Palermo", "DNE", "Catania")
+	require.Nil(t, err)
+	require.Len(t, locs, 3)
+	assert.Nil(t, locs[1])
+	assert.Equal(t, "Palermo", locs[0].Member)
+	assert.InDelta(t, 13.361389, locs[0].Lon, 0.0001)
+	assert.InDelta(t, 38.115556, locs[0].Lat, 0.0001)
+	assert.InDelta(t, 15.087269, locs[2].Lon, 0.0001)
+	assert.InDelta(t, 37.502669, locs[2].Lat, 0.0001)
+
+	res, err := GeoSearch(c, key, GeoSearchOpts{
+		FromLon:   15,
+		FromLat:   37,
+		Radius:    200,
+		Unit:      "km",
+		Sort:      "ASC",
+		WithCoord: true,
+		WithDist:  true,
+	})
+	require.Nil(t, err)
+	require.Len(t, res, 2)
+	assert.Equal(t, "Catania", res[0].Member)
+	assert.InDelta(t, 56.4413, res[0].Dist, 0.01)
+	assert.InDelta(t, 15.087269, res[0].Lon, 0.0001)
+	assert.Equal(t, "Palermo", res[1].Member)
+	assert.InDelta(t, 190.4424, res[1].Dist, 0.01)
+}
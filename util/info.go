@@ -0,0 +1,231 @@
+package util
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// InfoServer holds the commonly used fields from the server section of INFO
+type InfoServer struct {
+	RedisVersion    string `info:"redis_version"`
+	RedisMode       string `info:"redis_mode"`
+	OS              string `info:"os"`
+	ProcessID       int    `info:"process_id"`
+	RunID           string `info:"run_id"`
+	TCPPort         int    `info:"tcp_port"`
+	UptimeInSeconds int64  `info:"uptime_in_seconds"`
+}
+
+// InfoClients holds the commonly used fields from the clients section of INFO
+type InfoClients struct {
+	ConnectedClients int `info:"connected_clients"`
+	BlockedClients   int `info:"blocked_clients"`
+}
+
+// InfoMemory holds the commonly used fields from the memory section of INFO
+type InfoMemory struct {
+	UsedMemory            int64   `info:"used_memory"`
+	UsedMemoryRSS         int64   `info:"used_memory_rss"`
+	UsedMemoryPeak        int64   `info:"used_memory_peak"`
+	MaxMemory             int64   `info:"maxmemory"`
+	MaxMemoryPolicy       string  `info:"maxmemory_policy"`
+	MemFragmentationRatio float64 `info:"mem_fragmentation_ratio"`
+}
+
+// InfoPersistence holds the commonly used fields from the persistence section
+// of INFO
+type InfoPersistence struct {
+	Loading                 bool   `info:"loading"`
+	RDBChangesSinceLastSave int64  `info:"rdb_changes_since_last_save"`
+	RDBBgsaveInProgress     bool   `info:"rdb_bgsave_in_progress"`
+	RDBLastSaveTime         int64  `info:"rdb_last_save_time"`
+	RDBLastBgsaveStatus     string `info:"rdb_last_bgsave_status"`
+	AOFEnabled              bool   `info:"aof_enabled"`
+	AOFRewriteInProgress    bool   `info:"aof_rewrite_in_progress"`
+}
+
+// InfoStats holds the commonly used fields from the stats section of INFO
+type InfoStats struct {
+	TotalConnectionsReceived int64 `info:"total_connections_received"`
+	TotalCommandsProcessed   int64 `info:"total_commands_processed"`
+	InstantaneousOpsPerSec   int64 `info:"instantaneous_ops_per_sec"`
+	RejectedConnections      int64 `info:"rejected_connections"`
+	ExpiredKeys              int64 `info:"expired_keys"`
+	EvictedKeys              int64 `info:"evicted_keys"`
+	KeyspaceHits             int64 `info:"keyspace_hits"`
+	KeyspaceMisses           int64 `info:"keyspace_misses"`
+}
+
+// InfoReplica describes a single replica connected to a master, as given by
+// the slaveN fields of the replication section of INFO
+type InfoReplica struct {
+	IP     string `info:"ip"`
+	Port   int    `info:"port"`
+	State  string `info:"state"`
+	Offset int64  `info:"offset"`
+	Lag    int64  `info:"lag"`
+}
+
+// InfoReplication holds the commonly used fields from the replication section
+// of INFO
+type InfoReplication struct {
+	Role             string `info:"role"`
+	MasterHost       string `info:"master_host"`
+	MasterPort       int    `info:"master_port"`
+	MasterLinkStatus string `info:"master_link_status"`
+	ConnectedSlaves  int    `info:"connected_slaves"`
+	MasterReplOffset int64  `info:"master_repl_offset"`
+
+	// Replicas is made up of the slaveN fields, in order
+	Replicas []InfoReplica
+}
+
+// InfoKeyspace describes a single database, as given in the keyspace section
+// of INFO
+type InfoKeyspace struct {
+	Keys    int64 `info:"keys"`
+	Expires int64 `info:"expires"`
+	AvgTTL  int64 `info:"avg_ttl"`
+}
+
+// Info is the parsed form of the output of the INFO command
+type Info struct {
+	Server      InfoServer
+	Clients     InfoClients
+	Memory      InfoMemory
+	Persistence InfoPersistence
+	Stats       InfoStats
+	Replication InfoReplication
+
+	// Keyspace is keyed by database number
+	Keyspace map[int]InfoKeyspace
+
+	// Raw holds every field which was returned, including those parsed into
+	// the other fields, keyed by the lowercased section name and then the
+	// field name. Fields which aren't covered by the other fields, such as
+	// any added in newer versions of redis, can be found here.
+	Raw map[string]map[string]string
+}
+
+// ParseInfo parses the response from an INFO command (with any or no section
+// argument) into an Info. Parsing is lenient: fields which aren't known are
+// only put into Raw, and known fields whose values can't be parsed are left at
+// their zero value.
+//
+//	info, err := util.ParseInfo(c.Cmd("INFO"))
+func ParseInfo(r *redis.Resp) (*Info, error) {
+	s, err := r.Str()
+	if err != nil {
+		return nil, err
+	}
+	return parseInfo(s), nil
+}
+
+func parseInfo(s string) *Info {
+	info := &Info{
+		Keyspace: map[int]InfoKeyspace{},
+		Raw:      map[string]map[string]string{},
+	}
+
+	var section string
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		} else if line[0] == '#' {
+			section = strings.ToLower(strings.TrimSpace(line[1:]))
+			continue
+		}
+
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		k, v := line[:i], line[i+1:]
+		if info.Raw[section] == nil {
+			info.Raw[section] = map[string]string{}
+		}
+		info.Raw[section][k] = v
+
+		switch section {
+		case "server":
+			setInfoField(&info.Server, k, v)
+		case "clients":
+			setInfoField(&info.Clients, k, v)
+		case "memory":
+			setInfoField(&info.Memory, k, v)
+		case "persistence":
+			setInfoField(&info.Persistence, k, v)
+		case "stats":
+			setInfoField(&info.Stats, k, v)
+		case "replication":
+			if isNumbered(k, "slave") {
+				var replica InfoReplica
+				setInfoFields(&replica, v)
+				info.Replication.Replicas = append(info.Replication.Replicas, replica)
+			} else {
+				setInfoField(&info.Replication, k, v)
+			}
+		case "keyspace":
+			if isNumbered(k, "db") {
+				db, _ := strconv.Atoi(k[2:])
+				var ks InfoKeyspace
+				setInfoFields(&ks, v)
+				info.Keyspace[db] = ks
+			}
+		}
+	}
+	return info
+}
+
+// isNumbered returns whether k is the prefix followed by only digits, e.g.
+// "slave0" for "slave" (but not "slave_read_only")
+func isNumbered(k, prefix string) bool {
+	if !strings.HasPrefix(k, prefix) || len(k) == len(prefix) {
+		return false
+	}
+	_, err := strconv.Atoi(k[len(prefix):])
+	return err == nil
+}
+
+// setInfoFields sets fields on the struct pointed to by ptr from a value like
+// "ip=127.0.0.1,port=6380,state=online"
+func setInfoFields(ptr interface{}, v string) {
+	for _, kv := range strings.Split(v, ",") {
+		if i := strings.IndexByte(kv, '='); i >= 0 {
+			setInfoField(ptr, kv[:i], kv[i+1:])
+		}
+	}
+}
+
+// setInfoField sets the field whose info tag is k, on the struct pointed to by
+// ptr, to v. If there's no such field, or v can't be parsed, nothing is done.
+func setInfoField(ptr interface{}, k, v string) {
+	rv := reflect.ValueOf(ptr).Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		if rt.Field(i).Tag.Get("info") != k {
+			continue
+		}
+
+		f := rv.Field(i)
+		switch f.Kind() {
+		case reflect.String:
+			f.SetString(v)
+		case reflect.Int, reflect.Int64:
+			if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+				f.SetInt(i)
+			}
+		case reflect.Float64:
+			if fl, err := strconv.ParseFloat(v, 64); err == nil {
+				f.SetFloat(fl)
+			}
+		case reflect.Bool:
+			f.SetBool(v == "1")
+		}
+		return
+	}
+}
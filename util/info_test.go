@@ -0,0 +1,95 @@
+package util
+
+import (
+	. "testing"
+
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testInfo = "# Server\r\n" +
+	"redis_version:6.2.6\r\n" +
+	"redis_mode:standalone\r\n" +
+	"process_id:42\r\n" +
+	"tcp_port:6379\r\n" +
+	"uptime_in_seconds:100\r\n" +
+	"some_new_field:whatever\r\n" +
+	"\r\n" +
+	"# Clients\r\n" +
+	"connected_clients:3\r\n" +
+	"\r\n" +
+	"# Memory\r\n" +
+	"used_memory:1024\r\n" +
+	"maxmemory_policy:noeviction\r\n" +
+	"mem_fragmentation_ratio:1.5\r\n" +
+	"\r\n" +
+	"# Persistence\r\n" +
+	"loading:0\r\n" +
+	"aof_enabled:1\r\n" +
+	"rdb_last_save_time:notanumber\r\n" +
+	"\r\n" +
+	"# Stats\r\n" +
+	"keyspace_hits:10\r\n" +
+	"keyspace_misses:5\r\n" +
+	"\r\n" +
+	"# Replication\r\n" +
+	"role:master\r\n" +
+	"connected_slaves:2\r\n" +
+	"slave0:ip=10.0.0.1,port=6380,state=online,offset=100,lag=0\r\n" +
+	"slave1:ip=10.0.0.2,port=6381,state=wait_bgsave,offset=0,lag=1\r\n" +
+	"slave_read_only:1\r\n" +
+	"master_repl_offset:100\r\n" +
+	"\r\n" +
+	"# Keyspace\r\n" +
+	"db0:keys=10,expires=2,avg_ttl=300\r\n" +
+	"db3:keys=1,expires=0,avg_ttl=0\r\n"
+
+func TestParseInfo(t *T) {
+	info, err := ParseInfo(redis.NewResp(testInfo))
+	require.Nil(t, err)
+
+	assert.Equal(t, InfoServer{
+		RedisVersion:    "6.2.6",
+		RedisMode:       "standalone",
+		ProcessID:       42,
+		TCPPort:         6379,
+		UptimeInSeconds: 100,
+	}, info.Server)
+	assert.Equal(t, 3, info.Clients.ConnectedClients)
+	assert.Equal(t, int64(1024), info.Memory.UsedMemory)
+	assert.Equal(t, "noeviction", info.Memory.MaxMemoryPolicy)
+	assert.Equal(t, 1.5, info.Memory.MemFragmentationRatio)
+	assert.False(t, info.Persistence.Loading)
+	assert.True(t, info.Persistence.AOFEnabled)
+	assert.Equal(t, int64(0), info.Persistence.RDBLastSaveTime)
+	assert.Equal(t, int64(10), info.Stats.KeyspaceHits)
+	assert.Equal(t, InfoReplication{
+		Role:             "master",
+		ConnectedSlaves:  2,
+		MasterReplOffset: 100,
+		Replicas: []InfoReplica{
+			{IP: "10.0.0.1", Port: 6380, State: "online", Offset: 100},
+			{IP: "10.0.0.2", Port: 6381, State: "wait_bgsave", Lag: 1},
+		},
+	}, info.Replication)
+	assert.Equal(t, map[int]InfoKeyspace{
+		0: {Keys: 10, Expires: 2, AvgTTL: 300},
+		3: {Keys: 1},
+	}, info.Keyspace)
+
+	assert.Equal(t, "whatever", info.Raw["server"]["some_new_field"])
+	assert.Equal(t, "notanumber", info.Raw["persistence"]["rdb_last_save_time"])
+	assert.Equal(t, "1", info.Raw["replication"]["slave_read_only"])
+}
+
+func TestParseInfoLive(t *T) {
+	c, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+
+	info, err := ParseInfo(c.Cmd("INFO"))
+	require.Nil(t, err)
+	assert.NotEmpty(t, info.Server.RedisVersion)
+	assert.NotEmpty(t, info.Replication.Role)
+	assert.True(t, info.Clients.ConnectedClients > 0)
+}
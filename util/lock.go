@@ -0,0 +1,221 @@
+package util
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+var (
+	// ErrLockNotAcquired is returned from AcquireLock when the lock is held by
+	// someone else, and it could not be acquired within the MaxWait time
+	ErrLockNotAcquired = errors.New("lock not acquired")
+
+	// ErrLockLost is returned from the methods on Lock when the lock is no
+	// longer held by that Lock, either because its TTL ran out or because
+	// someone else has since acquired it
+	ErrLockLost = errors.New("lock no longer held")
+)
+
+var (
+	lockReleaseScript = NewScript(1, `
+		if redis.call("GET", KEYS[1]) == ARGV[1] then
+			return redis.call("DEL", KEYS[1])
+		end
+		return 0
+	`)
+
+	lockExtendScript = NewScript(1, `
+		if redis.call("GET", KEYS[1]) == ARGV[1] then
+			return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+		end
+		return 0
+	`)
+)
+
+// LockOpts are the options which can be passed into AcquireLock. All fields
+// are optional.
+type LockOpts struct {
+	// How long the lock will be held for before being automatically released,
+	// unless Extend is called. Defaults to 10 seconds.
+	TTL time.Duration
+
+	// How long AcquireLock will keep trying to acquire the lock, if it's
+	// currently held by someone else. Defaults to 0, meaning only one attempt
+	// is made.
+	MaxWait time.Duration
+
+	// How long to wait between the first attempts at acquiring the lock. The
+	// wait time doubles after every attempt, up to MaxRetryInterval. Defaults
+	// to 10 milliseconds.
+	RetryInterval time.Duration
+
+	// The maximum amount of time to wait between attempts at acquiring the
+	// lock. Defaults to 1 second.
+	MaxRetryInterval time.Duration
+}
+
+// Lock is a lock on a single key which is held until it's either Released or
+// its TTL runs out. It is created using AcquireLock.
+//
+// Lock is implemented using a single redis instance (SET with NX and PX, and a
+// random token identifying the owner), it is NOT an implementation of
+// Redlock. If that instance fails over to a replica which didn't receive the
+// lock then the lock may be acquired by two owners at once, so a Lock should
+// not be relied on where correctness depends on mutual exclusion.
+type Lock struct {
+	c     Cmder
+	key   string
+	token string
+
+	// kaL guards kaStopCh and kaDoneCh, which are set while KeepAlive's
+	// go-routine is running
+	kaL      sync.Mutex
+	kaStopCh chan struct{}
+	kaDoneCh chan struct{}
+}
+
+// AcquireLock attempts to acquire a lock on the given key, using the given
+// options. If the lock is held by someone else for longer than MaxWait then
+// ErrLockNotAcquired is returned.
+//
+// This works with any of the Cmders implemented in radix.v2, since only a
+// single key is involved.
+func AcquireLock(c Cmder, key string, o LockOpts) (*Lock, error) {
+	if o.TTL == 0 {
+		o.TTL = 10 * time.Second
+	}
+	if o.RetryInterval == 0 {
+		o.RetryInterval = 10 * time.Millisecond
+	}
+	if o.MaxRetryInterval == 0 {
+		o.MaxRetryInterval = 1 * time.Second
+	}
+
+	tokenB := make([]byte, 16)
+	if _, err := rand.Read(tokenB); err != nil {
+		return nil, err
+	}
+	l := &Lock{
+		c:     c,
+		key:   key,
+		token: hex.EncodeToString(tokenB),
+	}
+
+	deadline := time.Now().Add(o.MaxWait)
+	interval := o.RetryInterval
+	for {
+		r := c.Cmd("SET", key, l.token, "PX", durationMS(o.TTL), "NX")
+		if r.Err != nil {
+			return nil, r.Err
+		} else if !r.IsType(redis.Nil) {
+			return l, nil
+		}
+
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			return nil, ErrLockNotAcquired
+		} else if interval > remaining {
+			interval = remaining
+		}
+		time.Sleep(interval)
+
+		if interval *= 2; interval > o.MaxRetryInterval {
+			interval = o.MaxRetryInterval
+		}
+	}
+}
+
+// Key returns the key the Lock is on
+func (l *Lock) Key() string {
+	return l.key
+}
+
+// Release releases the lock, so that it may be acquired by someone else. If the
+// lock isn't held by this Lock anymore then ErrLockLost is returned. If
+// KeepAlive was called it's stopped first.
+func (l *Lock) Release() error {
+	l.stopKeepAlive()
+	return l.check(lockReleaseScript.Cmd(l.c, l.key, l.token))
+}
+
+// KeepAlive starts a go-routine which calls Extend with the given TTL every
+// third of that TTL, so that the lock is held for as long as it's needed
+// rather than for a fixed time, until Release is called.
+//
+// If an Extend fails, either because the lock has been lost or because of
+// some other error (e.g. the connection was lost), the error is written to the
+// returned channel and the go-routine stops. In that case the lock may be, or
+// may soon be, held by someone else, and whatever it was protecting should be
+// stopped. The channel is buffered, so the error is never dropped if it isn't
+// being read, and is closed once the go-routine has stopped, whether because
+// of an error or because Release was called.
+//
+// Calling KeepAlive again stops the previous go-routine, whose channel is
+// closed, and starts a new one with the new TTL.
+func (l *Lock) KeepAlive(ttl time.Duration) <-chan error {
+	l.stopKeepAlive()
+
+	stopCh, doneCh := make(chan struct{}), make(chan struct{})
+	errCh := make(chan error, 1)
+	l.kaL.Lock()
+	l.kaStopCh, l.kaDoneCh = stopCh, doneCh
+	l.kaL.Unlock()
+
+	go func() {
+		defer close(doneCh)
+		defer close(errCh)
+		t := time.NewTicker(ttl / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+			case <-stopCh:
+				return
+			}
+			if err := l.Extend(ttl); err != nil {
+				errCh <- err
+				return
+			}
+		}
+	}()
+	return errCh
+}
+
+// stopKeepAlive stops KeepAlive's go-routine, if it's running, and waits for it
+// to have stopped, so that it doesn't Extend the lock after it's Released
+func (l *Lock) stopKeepAlive() {
+	l.kaL.Lock()
+	stopCh, doneCh := l.kaStopCh, l.kaDoneCh
+	l.kaStopCh, l.kaDoneCh = nil, nil
+	l.kaL.Unlock()
+	if stopCh != nil {
+		close(stopCh)
+		<-doneCh
+	}
+}
+
+// Extend sets the lock's TTL to the given duration, starting from now. If the
+// lock isn't held by this Lock anymore then ErrLockLost is returned, and the
+// lock is not acquired again.
+func (l *Lock) Extend(ttl time.Duration) error {
+	return l.check(lockExtendScript.Cmd(l.c, l.key, l.token, durationMS(ttl)))
+}
+
+func (l *Lock) check(r *redis.Resp) error {
+	i, err := r.Int()
+	if err != nil {
+		return err
+	} else if i == 0 {
+		return ErrLockLost
+	}
+	return nil
+}
+
+func durationMS(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
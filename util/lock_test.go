@@ -0,0 +1,99 @@
+package util
+
+import (
+	. "testing"
+	"time"
+
+	"github.com/levenlabs/golib/testutil"
+	"github.com/mediocregopher/radix.v2/cluster"
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLock(t *T) {
+	p, err := pool.New("tcp", "127.0.0.1:6379", 10)
+	require.Nil(t, err)
+	c, err := cluster.New("127.0.0.1:7000")
+	require.Nil(t, err)
+
+	for _, c := range []Cmder{p, c} {
+		key := testutil.RandStr()
+		l, err := AcquireLock(c, key, LockOpts{})
+		require.Nil(t, err)
+		assert.Equal(t, key, l.Key())
+
+		_, err = AcquireLock(c, key, LockOpts{})
+		assert.Equal(t, ErrLockNotAcquired, err)
+
+		require.Nil(t, l.Extend(5*time.Second))
+		ttl, err := c.Cmd("PTTL", key).Int()
+		require.Nil(t, err)
+		assert.True(t, ttl > 4000)
+
+		require.Nil(t, l.Release())
+		assert.Equal(t, ErrLockLost, l.Release())
+		assert.Equal(t, ErrLockLost, l.Extend(5*time.Second))
+
+		// someone else acquiring the lock means the original can't release it
+		l2, err := AcquireLock(c, key, LockOpts{})
+		require.Nil(t, err)
+		assert.Equal(t, ErrLockLost, l.Release())
+		require.Nil(t, l2.Release())
+	}
+}
+
+func TestLockWait(t *T) {
+	c, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+	key := testutil.RandStr()
+
+	_, err = AcquireLock(c, key, LockOpts{TTL: 100 * time.Millisecond})
+	require.Nil(t, err)
+
+	// the first lock will expire while the second is waiting for it
+	start := time.Now()
+	l, err := AcquireLock(c, key, LockOpts{MaxWait: 1 * time.Second})
+	require.Nil(t, err)
+	assert.True(t, time.Since(start) < 1*time.Second)
+	require.Nil(t, l.Release())
+
+	_, err = AcquireLock(c, key, LockOpts{TTL: 1 * time.Second})
+	require.Nil(t, err)
+	start = time.Now()
+	_, err = AcquireLock(c, key, LockOpts{MaxWait: 100 * time.Millisecond})
+	assert.Equal(t, ErrLockNotAcquired, err)
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+}
+
+func TestLockKeepAlive(t *T) {
+	c := &scriptedCmder{replies: []*redis.Resp{
+		redis.NewResp(1),
+		redis.NewResp(1),
+		redis.NewResp(0),
+	}}
+	l := &Lock{c: c, key: "foo", token: "bar"}
+
+	// the third Extend finds the lock has been lost
+	errCh := l.KeepAlive(30 * time.Millisecond)
+	select {
+	case err := <-errCh:
+		assert.Equal(t, ErrLockLost, err)
+	case <-time.After(1 * time.Second):
+		t.Fatal("KeepAlive didn't report the lock being lost")
+	}
+	_, ok := <-errCh
+	assert.False(t, ok)
+	require.Len(t, c.calls, 3)
+	assert.Equal(t, "EVALSHA", c.calls[0][0])
+
+	// Release stops KeepAlive before anything has been extended
+	c = &scriptedCmder{replies: []*redis.Resp{redis.NewResp(1)}}
+	l = &Lock{c: c, key: "foo", token: "bar"}
+	errCh = l.KeepAlive(1 * time.Hour)
+	require.Nil(t, l.Release())
+	_, ok = <-errCh
+	assert.False(t, ok)
+	assert.Len(t, c.calls, 1)
+}
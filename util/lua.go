@@ -4,6 +4,7 @@ import (
 	"crypto/sha1"
 	"encoding/hex"
 	"strings"
+	"sync"
 
 	"github.com/mediocregopher/radix.v2/redis"
 )
@@ -22,20 +23,144 @@ import (
 //	r := util.LuaEval(c, `return redis.call('GET', KEYS[1])`, 1, "foo")
 //
 func LuaEval(c Cmder, script string, keys int, args ...interface{}) *redis.Resp {
-	mainKey, _ := redis.KeyFromArgs(args...)
+	// as with Script, the first argument is only a key if the script has any
+	var mainKey string
+	if keys > 0 {
+		mainKey, _ = redis.KeyFromArgs(args...)
+	}
+	sum := scriptSum(script)
+
+	var r *redis.Resp
+	if err := withClientForKey(c, mainKey, func(cc Cmder) {
+		r = cc.Cmd("EVALSHA", sum, keys, args)
+		if isNoScript(r) {
+			r = cc.Cmd("EVAL", script, keys, args)
+		}
+	}); err != nil {
+		return redis.NewResp(err)
+	}
+
+	return r
+}
 
+func scriptSum(script string) string {
 	sumRaw := sha1.Sum([]byte(script))
-	sum := hex.EncodeToString(sumRaw[:])
+	return hex.EncodeToString(sumRaw[:])
+}
+
+func isNoScript(r *redis.Resp) bool {
+	return r.Err != nil && strings.HasPrefix(r.Err.Error(), "NOSCRIPT")
+}
+
+// Script is a lua script which is expected to be called many times. Unlike
+// LuaEval, which must hash the script on every call, a Script's sha1 is
+// computed once when it's created. Scripts can be used from multiple
+// go-routines at once.
+//
+//	var getScript = util.NewScript(1, `return redis.call('GET', KEYS[1])`)
+//
+//	func get(c util.Cmder, key string) (string, error) {
+//		return getScript.Cmd(c, key).Str()
+//	}
+type Script struct {
+	script  string
+	sum     string
+	numKeys int
+
+	// if set, called with the address of the instance (if known) whenever the
+	// script needs to be loaded. Used by ScriptRegistry.
+	onNoScript func(addr string)
+
+	l sync.Mutex
+	// addresses which are currently having the script loaded onto them. The
+	// channel is closed when the load is done.
+	loading map[string]chan struct{}
+}
+
+// NewScript returns a Script for the given script body, which will take in the
+// given number of keys. See http://redis.io/commands/eval for the meaning of
+// the number of keys.
+func NewScript(numKeys int, script string) *Script {
+	return &Script{
+		script:  script,
+		sum:     scriptSum(script),
+		numKeys: numKeys,
+		loading: map[string]chan struct{}{},
+	}
+}
+
+// Cmd calls the Script on the given Cmder, using the given list of keys and
+// arguments (keys first, like LuaEval).
+//
+// EVALSHA is always tried first. If the script hasn't been loaded onto the
+// instance yet then Cmd falls back to EVAL, which loads it. If many calls hit
+// the same unloaded instance at once only one will use EVAL, the rest will
+// wait for it to finish and then use EVALSHA.
+//
+// Like LuaEval, this works with any of the Cmders implemented in radix.v2. For
+// a Cluster the first key is used to choose which instance to call the Script
+// on.
+func (s *Script) Cmd(c Cmder, args ...interface{}) *redis.Resp {
+	var mainKey string
+	if s.numKeys > 0 {
+		mainKey, _ = redis.KeyFromArgs(args...)
+	}
 
 	var r *redis.Resp
 	if err := withClientForKey(c, mainKey, func(cc Cmder) {
-		r = c.Cmd("EVALSHA", sum, keys, args)
-		if r.Err != nil && strings.HasPrefix(r.Err.Error(), "NOSCRIPT") {
-			r = c.Cmd("EVAL", script, keys, args)
-		}
+		r = s.cmd(cc, args)
 	}); err != nil {
 		return redis.NewResp(err)
 	}
+	return r
+}
+
+func (s *Script) cmd(c Cmder, args []interface{}) *redis.Resp {
+	r := c.Cmd("EVALSHA", s.sum, s.numKeys, args)
+	if !isNoScript(r) {
+		return r
+	}
 
+	// If we don't know what instance the Cmder is talking to there's no way
+	// to coordinate with other calls
+	client, ok := c.(*redis.Client)
+	if !ok {
+		s.noScript("")
+		return c.Cmd("EVAL", s.script, s.numKeys, args)
+	}
+	addr := client.Network + "://" + client.Addr
+
+	s.l.Lock()
+	ch, isLoading := s.loading[addr]
+	if !isLoading {
+		ch = make(chan struct{})
+		s.loading[addr] = ch
+	}
+	s.l.Unlock()
+
+	if !isLoading {
+		s.noScript(client.Addr)
+		r = c.Cmd("EVAL", s.script, s.numKeys, args)
+		s.l.Lock()
+		delete(s.loading, addr)
+		s.l.Unlock()
+		close(ch)
+		return r
+	}
+
+	<-ch
+	// The EVAL may have failed before the script got loaded (e.g. a
+	// compilation error or a dropped connection), in which case this will
+	// simply get the error itself
+	r = c.Cmd("EVALSHA", s.sum, s.numKeys, args)
+	if isNoScript(r) {
+		r = c.Cmd("EVAL", s.script, s.numKeys, args)
+	}
 	return r
 }
+
+func (s *Script) noScript(addr string) {
+	if s.onNoScript != nil {
+		s.onNoScript(addr)
+	}
+}
@@ -2,10 +2,13 @@ package util
 
 import (
 	"crypto/rand"
+	"errors"
 	"fmt"
+	"sync"
 	. "testing"
 
 	"github.com/mediocregopher/radix.v2/cluster"
+	"github.com/mediocregopher/radix.v2/pool"
 	"github.com/mediocregopher/radix.v2/redis"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -61,3 +64,63 @@ func TestLuaEval(t *T) {
 		assert.Equal(t, val, s)
 	}
 }
+
+func TestScript(t *T) {
+	c1, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+	c2, err := cluster.New("127.0.0.1:7000")
+	require.Nil(t, err)
+	p, err := pool.New("tcp", "127.0.0.1:6379", 10)
+	require.Nil(t, err)
+
+	for _, c := range []Cmder{c1, c2, p} {
+		script, key, val := randTestScript()
+		s := NewScript(1, script)
+		s2, err := s.Cmd(c, key, val).Str()
+		require.Nil(t, err)
+		assert.Equal(t, "OK", s2)
+
+		s2, err = c.Cmd("GET", key).Str()
+		require.Nil(t, err)
+		assert.Equal(t, val, s2)
+	}
+
+	// Make sure many concurrent first calls all succeed
+	script, key, val := randTestScript()
+	s := NewScript(1, script)
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s2, err := s.Cmd(p, key, val).Str()
+			assert.Nil(t, err)
+			assert.Equal(t, "OK", s2)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestScriptScripted(t *T) {
+	script := `return 1`
+	sum := scriptSum(script)
+	s := NewScript(1, script)
+
+	c := &scriptedCmder{replies: []*redis.Resp{
+		redis.NewResp(errors.New("NOSCRIPT No matching script")),
+		redis.NewResp(1),
+		redis.NewResp(1),
+	}}
+	for i := 0; i < 2; i++ {
+		i, err := s.Cmd(c, "foo", "bar").Int()
+		require.Nil(t, err)
+		assert.Equal(t, 1, i)
+	}
+
+	args := []interface{}{"foo", "bar"}
+	assert.Equal(t, [][]interface{}{
+		{"EVALSHA", sum, 1, args},
+		{"EVAL", script, 1, args},
+		{"EVALSHA", sum, 1, args},
+	}, c.calls)
+}
@@ -0,0 +1,173 @@
+package util
+
+import (
+	"sync"
+
+	"github.com/mediocregopher/radix.v2/cluster"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// KeyMemory is the number of bytes a key is using, as reported by MEMORY USAGE
+type KeyMemory struct {
+	Key   string
+	Bytes int64
+}
+
+// MemoryUsageOpts are options which can be passed into MemoryUsage. All fields
+// are optional.
+type MemoryUsageOpts struct {
+	// The number of nested values to sample, passed in as the SAMPLES
+	// argument. If zero redis's default is used.
+	Samples int
+
+	// How many MEMORY USAGE calls are pipelined together. Defaults to 100.
+	BatchSize int
+
+	// When used with a Cluster, how many instances are scanned at once.
+	// Defaults to 1.
+	Concurrency int
+}
+
+// MemoryUsage SCANs over the keys matching the given ScanOpts (whose Command
+// must be SCAN), calling MEMORY USAGE for each, and calls fn with the result.
+// MEMORY USAGE calls are pipelined in batches. Keys which are deleted before
+// they can be looked at are skipped. If fn returns an error MemoryUsage stops
+// and returns that error.
+//
+// If the Cmder is a Cluster every instance will be scanned, possibly at the
+// same time depending on the Concurrency option. fn will never be called
+// concurrently.
+func MemoryUsage(c Cmder, so ScanOpts, o MemoryUsageOpts, fn func(KeyMemory) error) error {
+	if o.BatchSize == 0 {
+		o.BatchSize = 100
+	}
+	if o.Concurrency == 0 {
+		o.Concurrency = 1
+	}
+
+	cc, ok := c.(*cluster.Cluster)
+	if !ok {
+		var err error
+		if cerr := withClientForKey(c, "", func(c Cmder) {
+			err = memoryUsage(c, so, o, fn)
+		}); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+
+	// fnL makes sure fn isn't called concurrently, and once it returns an
+	// error it isn't called again
+	var fnL sync.Mutex
+	var fnErr error
+	lockedFn := func(km KeyMemory) error {
+		fnL.Lock()
+		defer fnL.Unlock()
+		if fnErr == nil {
+			fnErr = fn(km)
+		}
+		return fnErr
+	}
+
+	return withEveryClientConcurrently(cc, o.Concurrency, func(_ string, client *redis.Client) error {
+		return memoryUsage(client, so, o, lockedFn)
+	})
+}
+
+func memoryUsage(c Cmder, so ScanOpts, o MemoryUsageOpts, fn func(KeyMemory) error) error {
+	var samples []interface{}
+	if o.Samples > 0 {
+		samples = []interface{}{"SAMPLES", o.Samples}
+	}
+
+	s := NewScanner(c, so)
+	keys := make([]string, 0, o.BatchSize)
+	flush := func() error {
+		cmds := make([][]interface{}, len(keys))
+		for i, key := range keys {
+			cmds[i] = []interface{}{"MEMORY", "USAGE", key, samples}
+		}
+		for i, r := range pipelineCmds(c, cmds) {
+			if r.IsType(redis.Nil) {
+				continue
+			}
+			bytes, err := r.Int64()
+			if err != nil {
+				return err
+			}
+			if err := fn(KeyMemory{Key: keys[i], Bytes: bytes}); err != nil {
+				return err
+			}
+		}
+		keys = keys[:0]
+		return nil
+	}
+
+	for s.HasNext() {
+		if keys = append(keys, s.Next()); len(keys) == o.BatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// pipelineCmds calls each of the given commands, each being the command name
+// followed by its arguments, returning their responses in order. If the Cmder
+// is a Client the commands are pipelined.
+func pipelineCmds(c Cmder, cmds [][]interface{}) []*redis.Resp {
+	rr := make([]*redis.Resp, len(cmds))
+	client, ok := c.(*redis.Client)
+	if !ok {
+		for i, cmd := range cmds {
+			rr[i] = c.Cmd(cmd[0].(string), cmd[1:]...)
+		}
+		return rr
+	}
+
+	for _, cmd := range cmds {
+		client.PipeAppend(cmd[0].(string), cmd[1:]...)
+	}
+	for i := range cmds {
+		rr[i] = client.PipeResp()
+	}
+	return rr
+}
+
+// MemoryBucket is the total memory used by a group of keys
+type MemoryBucket struct {
+	Keys  int64
+	Bytes int64
+}
+
+// MemoryUsageByBucket is like MemoryUsage, but rather than calling a function
+// for every key it groups the keys into buckets, using the given function to
+// choose each key's bucket, and returns the totals for each bucket. For
+// example, to group keys by their prefix:
+//
+//	buckets, err := util.MemoryUsageByBucket(c, so, o, func(key string) string {
+//		return strings.SplitN(key, ":", 2)[0]
+//	})
+func MemoryUsageByBucket(
+	c Cmder, so ScanOpts, o MemoryUsageOpts, bucket func(key string) string,
+) (
+	map[string]MemoryBucket, error,
+) {
+	m := map[string]MemoryBucket{}
+	err := MemoryUsage(c, so, o, func(km KeyMemory) error {
+		b := bucket(km.Key)
+		mb := m[b]
+		mb.Keys++
+		mb.Bytes += km.Bytes
+		m[b] = mb
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
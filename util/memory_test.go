@@ -0,0 +1,61 @@
+package util
+
+import (
+	"strings"
+	. "testing"
+
+	"github.com/mediocregopher/radix.v2/cluster"
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryUsage(t *T) {
+	p, err := pool.New("tcp", "127.0.0.1:6379", 10)
+	require.Nil(t, err)
+	c, err := cluster.New("127.0.0.1:7000")
+	require.Nil(t, err)
+
+	for _, c := range []Cmder{p, c} {
+		prefix, fullMap := randPrefix(t, c, 250)
+		so := ScanOpts{Command: "SCAN", Pattern: prefix + ":*"}
+		o := MemoryUsageOpts{BatchSize: 30, Concurrency: 2}
+
+		testMap := map[string]bool{}
+		err := MemoryUsage(c, so, o, func(km KeyMemory) error {
+			assert.True(t, km.Bytes > 0)
+			testMap[km.Key] = true
+			return nil
+		})
+		require.Nil(t, err)
+		assert.Equal(t, fullMap, testMap)
+
+		buckets, err := MemoryUsageByBucket(c, so, o, func(key string) string {
+			return strings.SplitN(key, ":", 2)[0]
+		})
+		require.Nil(t, err)
+		require.Len(t, buckets, 1)
+		assert.Equal(t, int64(250), buckets[prefix].Keys)
+		assert.True(t, buckets[prefix].Bytes > 0)
+	}
+}
+
+func TestMemoryUsageScripted(t *T) {
+	c := &scriptedCmder{replies: []*redis.Resp{
+		scanReply("0", "a", "b", "c"),
+		redis.NewResp(10),
+		redis.NewResp(nil),
+		redis.NewResp(30),
+	}}
+	var kms []KeyMemory
+	err := MemoryUsage(c, ScanOpts{Command: "SCAN"}, MemoryUsageOpts{Samples: 5}, func(km KeyMemory) error {
+		kms = append(kms, km)
+		return nil
+	})
+	require.Nil(t, err)
+	assert.Equal(t, []KeyMemory{{"a", 10}, {"c", 30}}, kms)
+	assert.Equal(t, []interface{}{
+		"MEMORY", "USAGE", "a", []interface{}{"SAMPLES", 5},
+	}, c.calls[1])
+}
@@ -0,0 +1,125 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// CmderFunc is an adapter which allows a plain function to be used as a Cmder,
+// mostly useful for implementing Middleware
+type CmderFunc func(cmd string, args ...interface{}) *redis.Resp
+
+// Cmd calls f(cmd, args...)
+func (f CmderFunc) Cmd(cmd string, args ...interface{}) *redis.Resp {
+	return f(cmd, args...)
+}
+
+// Middleware wraps a Cmder in another Cmder, which will usually do something
+// before and/or after calling Cmd on the wrapped one
+type Middleware func(Cmder) Cmder
+
+// Chain wraps the base Cmder in all the given Middleware. The first Middleware
+// given is the outermost, so it sees each command first and its reply last.
+//
+// Note that the returned Cmder is not a Pool, Cluster, etc, so helpers in this
+// package which treat those specially (e.g. NewScanner on a Cluster) will treat
+// it like a single connection.
+func Chain(base Cmder, mw ...Middleware) Cmder {
+	c := base
+	for i := len(mw) - 1; i >= 0; i-- {
+		c = mw[i](c)
+	}
+	return c
+}
+
+// LogMiddleware returns a Middleware which calls logf once for each command
+// which is run, with the command, its arguments, how long it took, and the
+// error it returned (if any). Arguments longer than maxArgLen bytes are
+// truncated, and if maxArgLen is less than zero arguments are not logged at all.
+func LogMiddleware(logf func(format string, args ...interface{}), maxArgLen int) Middleware {
+	return func(c Cmder) Cmder {
+		return CmderFunc(func(cmd string, args ...interface{}) *redis.Resp {
+			start := time.Now()
+			r := c.Cmd(cmd, args...)
+			took := time.Since(start)
+
+			cmdStr := cmd
+			if maxArgLen >= 0 {
+				cmdStr = strings.Join(append([]string{cmd}, logArgs(args, maxArgLen)...), " ")
+			}
+			if r.Err != nil {
+				logf("redis %s (%s): %s", cmdStr, took, r.Err)
+			} else {
+				logf("redis %s (%s)", cmdStr, took)
+			}
+			return r
+		})
+	}
+}
+
+func logArgs(args []interface{}, maxArgLen int) []string {
+	argsStr, err := redis.NewRespFlattenedStrings(args).List()
+	if err != nil {
+		return []string{fmt.Sprintf("<%s>", err)}
+	}
+	for i, arg := range argsStr {
+		if len(arg) > maxArgLen {
+			argsStr[i] = fmt.Sprintf("%q...(%d bytes)", arg[:maxArgLen], len(arg))
+		} else {
+			argsStr[i] = fmt.Sprintf("%q", arg)
+		}
+	}
+	return argsStr
+}
+
+// MetricsMiddleware returns a Middleware which calls fn after each command is
+// run, with the command's name, how long it took, and the error it returned,
+// which is nil if it was successful. fn is called synchronously, so it should
+// be fast.
+func MetricsMiddleware(fn func(cmd string, took time.Duration, err error)) Middleware {
+	return func(c Cmder) Cmder {
+		return CmderFunc(func(cmd string, args ...interface{}) *redis.Resp {
+			start := time.Now()
+			r := c.Cmd(cmd, args...)
+			fn(cmd, time.Since(start), r.Err)
+			return r
+		})
+	}
+}
+
+// PrefixMiddleware returns a Middleware which adds the given prefix to every
+// key in the commands it's given, which is useful for keeping multiple tenants
+// of a single redis instance apart. Keys are found using redis.CmdKeys, which
+// is the same table a Cluster uses to route commands, and commands not in that
+// table are passed through unchanged.
+//
+// Only keys in commands are changed, keys in replies (e.g. from KEYS or SCAN)
+// and key patterns (e.g. SCAN's MATCH) will not have the prefix added or
+// removed. If the prefix contains a hash tag ({...}) every key will be sent to
+// the same cluster slot.
+func PrefixMiddleware(prefix string) Middleware {
+	return func(c Cmder) Cmder {
+		return CmderFunc(func(cmd string, args ...interface{}) *redis.Resp {
+			argsStr, err := redis.NewRespFlattenedStrings(args).List()
+			if err != nil {
+				return redis.NewResp(err)
+			}
+			keys, _ := redis.CmdKeys(cmd, argsStr)
+			if len(keys) == 0 {
+				return c.Cmd(cmd, args...)
+			}
+
+			for _, i := range keys {
+				argsStr[i] = prefix + argsStr[i]
+			}
+			newArgs := make([]interface{}, len(argsStr))
+			for i := range argsStr {
+				newArgs[i] = argsStr[i]
+			}
+			return c.Cmd(cmd, newArgs...)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package util
+
+import (
+	"errors"
+	. "testing"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChain(t *T) {
+	var order []string
+	mw := func(name string) Middleware {
+		return func(c Cmder) Cmder {
+			return CmderFunc(func(cmd string, args ...interface{}) *redis.Resp {
+				order = append(order, name+" before")
+				r := c.Cmd(cmd, args...)
+				order = append(order, name+" after")
+				return r
+			})
+		}
+	}
+
+	base := &scriptedCmder{replies: []*redis.Resp{redis.NewResp("bar")}}
+	c := Chain(base, mw("a"), mw("b"))
+	s, err := c.Cmd("GET", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "bar", s)
+	assert.Equal(t, []string{"a before", "b before", "b after", "a after"}, order)
+	assert.Equal(t, [][]interface{}{{"GET", "foo"}}, base.calls)
+}
+
+func TestPrefixMiddleware(t *T) {
+	base := &scriptedCmder{replies: []*redis.Resp{
+		redis.NewResp("OK"),
+		redis.NewResp(1),
+		redis.NewResp(1),
+		redis.NewResp("PONG"),
+	}}
+	c := Chain(base, PrefixMiddleware("t1:"))
+
+	c.Cmd("MSET", map[string]string{"a": "1"})
+	c.Cmd("EVAL", "return 1", 2, []string{"a", "b"}, "arg")
+	c.Cmd("DEL", "a")
+	c.Cmd("PING")
+
+	assert.Equal(t, [][]interface{}{
+		{"MSET", "t1:a", "1"},
+		{"EVAL", "return 1", "2", "t1:a", "t1:b", "arg"},
+		{"DEL", "t1:a"},
+		{"PING"},
+	}, base.calls)
+}
+
+func TestLogMiddleware(t *T) {
+	var lines []string
+	logf := func(format string, args ...interface{}) {
+		lines = append(lines, format)
+		assert.Contains(t, args[0], "SET")
+	}
+	base := &scriptedCmder{replies: []*redis.Resp{
+		redis.NewResp("OK"),
+		redis.NewResp(errors.New("ERR bad")),
+	}}
+	c := Chain(base, LogMiddleware(logf, 3))
+	c.Cmd("SET", "foo", "barbaz")
+	c.Cmd("SET", "foo", "bar")
+	assert.Equal(t, []string{"redis %s (%s)", "redis %s (%s): %s"}, lines)
+
+	assert.Equal(t, []string{`"foo"`, `"bar"...(6 bytes)`}, logArgs([]interface{}{"foo", "barbaz"}, 3))
+}
+
+func TestMetricsMiddleware(t *T) {
+	var cmds []string
+	var errs []error
+	fn := func(cmd string, took time.Duration, err error) {
+		cmds = append(cmds, cmd)
+		errs = append(errs, err)
+	}
+	appErr := errors.New("ERR bad")
+	base := &scriptedCmder{replies: []*redis.Resp{
+		redis.NewResp("OK"),
+		redis.NewResp(appErr),
+	}}
+	c := Chain(base, MetricsMiddleware(fn))
+	c.Cmd("SET", "foo", "bar")
+	c.Cmd("INCR", "foo")
+	assert.Equal(t, []string{"SET", "INCR"}, cmds)
+	assert.Nil(t, errs[0])
+	assert.Equal(t, appErr.Error(), errs[1].Error())
+}
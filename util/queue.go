@@ -0,0 +1,366 @@
+package util
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/cluster"
+	"github.com/mediocregopher/radix.v2/log"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+var (
+	// ErrQueueEmpty is returned from Reserve and ReserveBlocking when there
+	// are no messages in the queue
+	ErrQueueEmpty = errors.New("queue is empty")
+
+	// ErrMessageLost is returned from Ack when the message is no longer
+	// reserved by the consumer, because its visibility timeout ran out and it
+	// was put back in the queue
+	ErrMessageLost = errors.New("message is no longer reserved")
+)
+
+var (
+	queueReserveScript = NewScript(3, `
+		local el = redis.call("LMOVE", KEYS[1], KEYS[2], "RIGHT", "LEFT")
+		if not el then
+			return false
+		end
+		redis.call("ZADD", KEYS[3], ARGV[1], ARGV[2] .. "\n" .. el)
+		return el
+	`)
+
+	queueAckScript = NewScript(2, `
+		local n = redis.call("LREM", KEYS[1], 1, ARGV[1])
+		redis.call("ZREM", KEYS[2], ARGV[2] .. "\n" .. ARGV[1])
+		return n
+	`)
+
+	// The processing lists of other consumers can't be passed in as KEYS,
+	// since they aren't known ahead of time, so they're built from ARGV[2].
+	// They share the queue's hash tag, so this is still safe in a cluster.
+	queueReapScript = NewScript(2, `
+		local expired = redis.call("ZRANGEBYSCORE", KEYS[2], "-inf", ARGV[1], "LIMIT", 0, ARGV[3])
+		local n = 0
+		for _, member in ipairs(expired) do
+			local i = string.find(member, "\n", 1, true)
+			local el = string.sub(member, i+1)
+			if redis.call("LREM", ARGV[2] .. string.sub(member, 1, i-1), 1, el) > 0 then
+				redis.call("RPUSH", KEYS[1], el)
+				n = n + 1
+			end
+			redis.call("ZREM", KEYS[2], member)
+		end
+		return {n, #expired}
+	`)
+
+	queueRecoverScript = NewScript(3, `
+		local n = 0
+		while true do
+			local el = redis.call("LMOVE", KEYS[2], KEYS[1], "LEFT", "RIGHT")
+			if not el then
+				return n
+			end
+			redis.call("ZREM", KEYS[3], ARGV[1] .. "\n" .. el)
+			n = n + 1
+		end
+	`)
+)
+
+// QueueOpts are the options which can be passed into NewQueue
+type QueueOpts struct {
+	// Required. The name of this consumer, which must be unique amongst all
+	// consumers of the queue and the same across restarts of a consumer, so
+	// that Recover can find the messages it had reserved.
+	Consumer string
+
+	// How often messages whose visibility timeout has run out are put back in
+	// the queue. Defaults to 1 second. If negative it's never done in the
+	// background, and Reap must be called manually.
+	ReapInterval time.Duration
+
+	// Used to log errors from reaping in the background. Entries have the
+	// component "util". Defaults to log.Nop.
+	Logger log.Logger
+}
+
+// QueueMessage is a message which has been reserved from a Queue
+type QueueMessage struct {
+	// A random ID assigned to the message when it was pushed
+	ID      string
+	Payload string
+
+	// When the message's visibility timeout runs out, as of when it was
+	// reserved
+	Deadline time.Time
+
+	el string
+}
+
+// Queue is a reliable work queue built on redis lists. Messages which are
+// reserved by a consumer are moved into a list for that consumer, and if they
+// aren't acknowledged within their visibility timeout they're put back in the
+// queue to be reserved again. This means a message will be processed at least
+// once, but possibly more than once.
+//
+// All of a Queue's keys share a hash tag, so it can be used with a Cluster.
+// Deadlines are based on the clocks of the consumers, which should therefore
+// be roughly in sync.
+//
+// Queue's methods may be called from multiple go-routines at once.
+type Queue struct {
+	c        Cmder
+	o        QueueOpts
+	prefix   string
+	pending  string
+	deadline string
+	proc     string
+
+	logger    log.Logger
+	closeOnce sync.Once
+	closeCh   chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewQueue returns a Queue with the given name, and starts its background
+// reaper. Close should be called on it when it's no longer needed.
+//
+// The Cmder may be a Client, Pool, Cluster or sentinel.Master, which is needed
+// for ReserveBlocking. A Client will only ever have one command run on it at a
+// time, meaning a ReserveBlocking call will hold up the reaper and any other
+// calls.
+func NewQueue(c Cmder, name string, o QueueOpts) (*Queue, error) {
+	if o.Consumer == "" {
+		return nil, errors.New("Consumer is required")
+	} else if strings.Contains(o.Consumer, "\n") {
+		return nil, errors.New("Consumer may not contain a newline")
+	}
+	if o.ReapInterval == 0 {
+		o.ReapInterval = time.Second
+	}
+	// the background reaper means a single Client will be used from multiple
+	// go-routines
+	if client, ok := c.(*redis.Client); ok {
+		c = redis.NewSyncClient(client)
+	}
+
+	prefix := "{" + name + "}:"
+	q := &Queue{
+		c:        c,
+		o:        o,
+		prefix:   prefix,
+		pending:  prefix + "pending",
+		deadline: prefix + "deadlines",
+		proc:     prefix + "processing:" + o.Consumer,
+		closeCh:  make(chan struct{}),
+		logger: log.With(o.Logger, log.KV{
+			log.KeyComponent: "util",
+			log.KeyOperation: "reap",
+		}),
+	}
+
+	if o.ReapInterval > 0 {
+		q.wg.Add(1)
+		go q.reapSpin()
+	}
+	return q, nil
+}
+
+func (q *Queue) reapSpin() {
+	defer q.wg.Done()
+	t := time.NewTicker(q.o.ReapInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			// the reap will be tried again next tick
+			if _, err := q.Reap(); err != nil {
+				q.logger.Log(log.Warn, "reaping queue failed", log.KV{log.KeyErr: err})
+			}
+		case <-q.closeCh:
+			return
+		}
+	}
+}
+
+// Push adds a message with the given payload to the queue
+func (q *Queue) Push(payload string) error {
+	idB := make([]byte, 8)
+	if _, err := rand.Read(idB); err != nil {
+		return err
+	}
+	return q.c.Cmd("LPUSH", q.pending, hex.EncodeToString(idB)+":"+payload).Err
+}
+
+func (q *Queue) message(el string, deadline time.Time) (*QueueMessage, error) {
+	i := strings.IndexByte(el, ':')
+	if i < 0 {
+		return nil, errors.New("malformed queue message: " + el)
+	}
+	return &QueueMessage{
+		ID:       el[:i],
+		Payload:  el[i+1:],
+		Deadline: deadline,
+		el:       el,
+	}, nil
+}
+
+func msTimestamp(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// Reserve takes the oldest message from the queue, reserving it for this
+// consumer. If it isn't passed to Ack within the visibility timeout it will be
+// put back in the queue. If the queue is empty ErrQueueEmpty is returned, any
+// other error is from redis or the connection to it.
+func (q *Queue) Reserve(vt time.Duration) (*QueueMessage, error) {
+	deadline := time.Now().Add(vt)
+	r := queueReserveScript.Cmd(
+		q.c, q.pending, q.proc, q.deadline, msTimestamp(deadline), q.o.Consumer,
+	)
+	if r.Err != nil {
+		return nil, r.Err
+	} else if r.IsType(redis.Nil) {
+		return nil, ErrQueueEmpty
+	}
+	el, err := r.Str()
+	if err != nil {
+		return nil, err
+	}
+	return q.message(el, deadline)
+}
+
+// ReserveBlocking is like Reserve, but if the queue is empty it will block for
+// up to the given timeout for a message to be pushed, using BLMOVE on a
+// connection of its own. A timeout of zero blocks forever. If the timeout is
+// reached ErrQueueEmpty is returned.
+//
+// Since BLMOVE can't be called from a script, if the consumer crashes in
+// between BLMOVE returning and the message's deadline being set the message
+// will never be put back in the queue by Reap. Calling Recover when a consumer
+// starts up takes care of this.
+func (q *Queue) ReserveBlocking(vt, timeout time.Duration) (*QueueMessage, error) {
+	var r *redis.Resp
+	err := withBlockingConn(q.c, q.pending, func(c Cmder) {
+		r = c.Cmd("BLMOVE", q.pending, q.proc, "RIGHT", "LEFT",
+			strconv.FormatFloat(timeout.Seconds(), 'f', -1, 64))
+	})
+	if err != nil {
+		return nil, err
+	} else if r.Err != nil {
+		return nil, r.Err
+	} else if r.IsType(redis.Nil) {
+		return nil, ErrQueueEmpty
+	}
+
+	el, err := r.Str()
+	if err != nil {
+		return nil, err
+	}
+	m, err := q.message(el, time.Now().Add(vt))
+	if err != nil {
+		return nil, err
+	}
+	member := q.o.Consumer + "\n" + el
+	if err := q.c.Cmd("ZADD", q.deadline, msTimestamp(m.Deadline), member).Err; err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// withBlockingConn calls fn with a connection which isn't being used by
+// anything else, so that it may block
+func withBlockingConn(c Cmder, key string, fn func(Cmder)) error {
+	switch cc := c.(type) {
+	case *cluster.Cluster:
+		client, err := cc.GetForKey(key)
+		if err != nil {
+			return err
+		}
+		defer cc.Put(client)
+		fn(client)
+	case getPutter:
+		client, err := cc.Get()
+		if err != nil {
+			return err
+		}
+		defer cc.Put(client)
+		fn(client)
+	case *redis.SyncClient:
+		fn(cc)
+	default:
+		return errors.New("blocking needs a Client, Pool, Cluster, or sentinel.Master")
+	}
+	return nil
+}
+
+// Ack acknowledges that the message has been processed, removing it from the
+// queue for good. If the message's visibility timeout ran out and it was put
+// back in the queue then ErrMessageLost is returned.
+func (q *Queue) Ack(m *QueueMessage) error {
+	n, err := queueAckScript.Cmd(q.c, q.proc, q.deadline, m.el, q.o.Consumer).Int()
+	if err != nil {
+		return err
+	} else if n == 0 {
+		return ErrMessageLost
+	}
+	return nil
+}
+
+// Reap puts every message, from any consumer, whose visibility timeout has run
+// out back in the queue, returning how many were put back. This is called
+// periodically in the background unless ReapInterval is negative.
+func (q *Queue) Reap() (int, error) {
+	const limit = 100
+	var total int
+	for {
+		arr, err := queueReapScript.Cmd(
+			q.c, q.pending, q.deadline,
+			msTimestamp(time.Now()), q.prefix+"processing:", limit,
+		).Array()
+		if err != nil {
+			return total, err
+		} else if len(arr) != 2 {
+			return total, errors.New("unexpected reply from reap script")
+		}
+
+		n, err := arr[0].Int()
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if expired, err := arr[1].Int(); err != nil {
+			return total, err
+		} else if expired < limit {
+			return total, nil
+		}
+	}
+}
+
+// Recover puts every message currently reserved by this consumer back in the
+// queue, returning how many were put back. It should be called when a
+// consumer starts up, to recover the messages it had reserved before it last
+// stopped.
+func (q *Queue) Recover() (int, error) {
+	return queueRecoverScript.Cmd(q.c, q.pending, q.proc, q.deadline, q.o.Consumer).Int()
+}
+
+// Len returns the number of messages waiting in the queue, not including those
+// which are reserved
+func (q *Queue) Len() (int, error) {
+	return q.c.Cmd("LLEN", q.pending).Int()
+}
+
+// Close stops the background reaper. It doesn't close the Cmder the Queue was
+// created with.
+func (q *Queue) Close() {
+	q.closeOnce.Do(func() {
+		close(q.closeCh)
+		q.wg.Wait()
+	})
+}
@@ -0,0 +1,136 @@
+package util
+
+import (
+	"errors"
+	. "testing"
+	"time"
+
+	"github.com/levenlabs/golib/testutil"
+	"github.com/mediocregopher/radix.v2/cluster"
+	"github.com/mediocregopher/radix.v2/log"
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueue(t *T) {
+	p, err := pool.New("tcp", "127.0.0.1:6379", 10)
+	require.Nil(t, err)
+	c, err := cluster.New("127.0.0.1:7000")
+	require.Nil(t, err)
+
+	for _, c := range []Cmder{p, c} {
+		name := testutil.RandStr()
+		q1, err := NewQueue(c, name, QueueOpts{Consumer: "a", ReapInterval: -1})
+		require.Nil(t, err)
+		defer q1.Close()
+		q2, err := NewQueue(c, name, QueueOpts{Consumer: "b", ReapInterval: -1})
+		require.Nil(t, err)
+		defer q2.Close()
+
+		_, err = q1.Reserve(time.Minute)
+		assert.Equal(t, ErrQueueEmpty, err)
+
+		require.Nil(t, q1.Push("foo"))
+		require.Nil(t, q1.Push("bar"))
+		require.Nil(t, q1.Push("baz"))
+		l, err := q1.Len()
+		require.Nil(t, err)
+		assert.Equal(t, 3, l)
+
+		m, err := q1.Reserve(time.Minute)
+		require.Nil(t, err)
+		assert.Equal(t, "foo", m.Payload)
+		assert.NotEmpty(t, m.ID)
+		require.Nil(t, q1.Ack(m))
+		assert.Equal(t, ErrMessageLost, q1.Ack(m))
+
+		// bar's visibility timeout runs out, so the reaper gives it to q2
+		m, err = q1.Reserve(time.Millisecond)
+		require.Nil(t, err)
+		assert.Equal(t, "bar", m.Payload)
+		time.Sleep(10 * time.Millisecond)
+		n, err := q2.Reap()
+		require.Nil(t, err)
+		assert.Equal(t, 1, n)
+		assert.Equal(t, ErrMessageLost, q1.Ack(m))
+
+		m2, err := q2.ReserveBlocking(time.Minute, time.Second)
+		require.Nil(t, err)
+		assert.Equal(t, "bar", m2.Payload)
+		require.Nil(t, q2.Ack(m2))
+
+		// baz is left reserved by q1, and then recovered
+		m, err = q1.ReserveBlocking(time.Minute, time.Second)
+		require.Nil(t, err)
+		assert.Equal(t, "baz", m.Payload)
+		n, err = q1.Recover()
+		require.Nil(t, err)
+		assert.Equal(t, 1, n)
+		n, err = q1.Reap()
+		require.Nil(t, err)
+		assert.Equal(t, 0, n)
+
+		m, err = q2.Reserve(time.Minute)
+		require.Nil(t, err)
+		assert.Equal(t, "baz", m.Payload)
+		require.Nil(t, q2.Ack(m))
+
+		start := time.Now()
+		_, err = q2.ReserveBlocking(time.Minute, 100*time.Millisecond)
+		assert.Equal(t, ErrQueueEmpty, err)
+		assert.True(t, time.Since(start) >= 100*time.Millisecond)
+	}
+}
+
+func TestQueueBackgroundReap(t *T) {
+	c, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+	q, err := NewQueue(c, testutil.RandStr(), QueueOpts{
+		Consumer:     "a",
+		ReapInterval: 10 * time.Millisecond,
+	})
+	require.Nil(t, err)
+	defer q.Close()
+
+	require.Nil(t, q.Push("foo"))
+	_, err = q.Reserve(time.Millisecond)
+	require.Nil(t, err)
+	time.Sleep(100 * time.Millisecond)
+
+	l, err := q.Len()
+	require.Nil(t, err)
+	assert.Equal(t, 1, l)
+}
+
+func TestQueueOpts(t *T) {
+	_, err := NewQueue(nil, "foo", QueueOpts{})
+	assert.NotNil(t, err)
+	_, err = NewQueue(nil, "foo", QueueOpts{Consumer: "a\nb"})
+	assert.NotNil(t, err)
+}
+
+func TestQueueReapLogger(t *T) {
+	replies := make([]*redis.Resp, 1000)
+	for i := range replies {
+		replies[i] = redis.NewResp(errors.New("ERR something went wrong"))
+	}
+	c := &scriptedCmder{replies: replies}
+	rec := new(log.Recorder)
+	q, err := NewQueue(c, testutil.RandStr(), QueueOpts{
+		Consumer:     "a",
+		ReapInterval: 10 * time.Millisecond,
+		Logger:       rec,
+	})
+	require.Nil(t, err)
+	time.Sleep(35 * time.Millisecond)
+	q.Close()
+
+	// every failed reap is logged once
+	ee := rec.Entries("reaping queue failed")
+	require.NotEmpty(t, ee)
+	assert.Len(t, ee, len(c.calls))
+	assert.Equal(t, "util", ee[0].KV[log.KeyComponent])
+	assert.Equal(t, "reap", ee[0].KV[log.KeyOperation])
+}
@@ -0,0 +1,94 @@
+package util
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// The sorted set holds one member per allowed request, scored by the time (in
+// microseconds) it was allowed at. Members older than the window are trimmed
+// on every call, so the set never holds more than limit members.
+var rateLimitScript = NewScript(1, `
+	local now = tonumber(ARGV[1])
+	local window = tonumber(ARGV[2])
+	local limit = tonumber(ARGV[3])
+
+	redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+	local count = redis.call("ZCARD", KEYS[1])
+	if count < limit then
+		redis.call("ZADD", KEYS[1], now, ARGV[4])
+		redis.call("PEXPIRE", KEYS[1], math.ceil(window / 1000))
+		return {1, limit - count - 1, 0}
+	end
+
+	local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+	return {0, 0, tonumber(oldest[2]) + window - now}
+`)
+
+// RateLimiter limits how many times something identified by an id may happen
+// within a window of time. It uses a sliding window log: every allowed call is
+// recorded in a sorted set, and a call is only allowed if there are fewer than
+// limit calls recorded within the last window. Unlike counting with INCR and
+// EXPIRE this doesn't allow bursts of up to twice the limit around the edges of
+// a window.
+//
+// All work is done in a single lua script, so a RateLimiter can be shared
+// between many go-routines and processes. Times are taken from the clock of the
+// process calling Allow, so processes sharing a limit should have reasonably
+// synchronized clocks.
+type RateLimiter struct {
+	c      Cmder
+	prefix string
+	limit  int
+	window time.Duration
+}
+
+// NewRateLimiter returns a RateLimiter which will allow up to limit calls per
+// id within any period of the given window. The key for each id's sorted set
+// is the prefix followed by the id in a hash tag, so the RateLimiter can be
+// used with a Cluster.
+func NewRateLimiter(c Cmder, prefix string, limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		c:      c,
+		prefix: prefix,
+		limit:  limit,
+		window: window,
+	}
+}
+
+// Allow records a call for the given id, returning whether or not it's allowed
+// and how many more calls are allowed within the current window. If the call
+// isn't allowed then retryAfter is how long until the next call would be.
+func (rl *RateLimiter) Allow(id string) (allowed bool, remaining int, retryAfter time.Duration, err error) {
+	memberB := make([]byte, 8)
+	if _, err = rand.Read(memberB); err != nil {
+		return
+	}
+
+	now := time.Now().UnixNano() / int64(time.Microsecond)
+	window := int64(rl.window / time.Microsecond)
+	member := strconv.FormatInt(now, 10) + "-" + hex.EncodeToString(memberB)
+	key := rl.prefix + "{" + id + "}"
+
+	l, err := rateLimitScript.Cmd(rl.c, key, now, window, rl.limit, member).Array()
+	if err != nil {
+		return
+	} else if len(l) != 3 {
+		err = errors.New("unexpected reply from rate limit script")
+		return
+	}
+
+	var ints [3]int64
+	for i := range ints {
+		if ints[i], err = l[i].Int64(); err != nil {
+			return
+		}
+	}
+	allowed = ints[0] == 1
+	remaining = int(ints[1])
+	retryAfter = time.Duration(ints[2]) * time.Microsecond
+	return
+}
@@ -0,0 +1,86 @@
+package util
+
+import (
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/levenlabs/golib/testutil"
+	"github.com/mediocregopher/radix.v2/cluster"
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *T) {
+	p, err := pool.New("tcp", "127.0.0.1:6379", 10)
+	require.Nil(t, err)
+	c, err := cluster.New("127.0.0.1:7000")
+	require.Nil(t, err)
+
+	for _, c := range []Cmder{p, c} {
+		rl := NewRateLimiter(c, testutil.RandStr()+":", 3, 200*time.Millisecond)
+		id := testutil.RandStr()
+		for i := 0; i < 3; i++ {
+			allowed, remaining, _, err := rl.Allow(id)
+			require.Nil(t, err)
+			assert.True(t, allowed)
+			assert.Equal(t, 2-i, remaining)
+		}
+
+		allowed, remaining, retryAfter, err := rl.Allow(id)
+		require.Nil(t, err)
+		assert.False(t, allowed)
+		assert.Equal(t, 0, remaining)
+		assert.True(t, retryAfter > 0)
+		assert.True(t, retryAfter <= 200*time.Millisecond)
+
+		// other ids aren't affected
+		allowed, _, _, err = rl.Allow(id + "2")
+		require.Nil(t, err)
+		assert.True(t, allowed)
+
+		time.Sleep(retryAfter)
+		allowed, _, _, err = rl.Allow(id)
+		require.Nil(t, err)
+		assert.True(t, allowed)
+	}
+}
+
+func TestRateLimiterConcurrent(t *T) {
+	p, err := pool.New("tcp", "127.0.0.1:6379", 10)
+	require.Nil(t, err)
+
+	limit := 20
+	rl := NewRateLimiter(p, testutil.RandStr()+":", limit, 10*time.Second)
+	id := testutil.RandStr()
+
+	var l sync.Mutex
+	var allowedCount int
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				allowed, _, _, err := rl.Allow(id)
+				assert.Nil(t, err)
+				if allowed {
+					l.Lock()
+					allowedCount++
+					l.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, limit, allowedCount)
+}
+
+func TestRateLimiterBadReply(t *T) {
+	c := &scriptedCmder{replies: []*redis.Resp{redis.NewResp([]interface{}{1, 2})}}
+	rl := NewRateLimiter(c, "rl:", 3, time.Second)
+	_, _, _, err := rl.Allow("foo")
+	assert.NotNil(t, err)
+}
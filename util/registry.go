@@ -0,0 +1,104 @@
+package util
+
+import (
+	"sync"
+
+	"github.com/mediocregopher/radix.v2/cluster"
+)
+
+// ScriptRegistry holds a set of named Scripts, so that they can all be loaded
+// onto redis at once when an application starts (see Preload) rather than
+// being loaded as each is first used. A ScriptRegistry can be used from
+// multiple go-routines at once.
+//
+// Scripts retrieved from a ScriptRegistry will still transparently reload
+// themselves if redis no longer has them, for example after a SCRIPT FLUSH or
+// a failover to a replica whose script cache is empty.
+type ScriptRegistry struct {
+	l        sync.RWMutex
+	scripts  map[string]*Script
+	onReload func(name, addr string)
+}
+
+// NewScriptRegistry returns an empty ScriptRegistry. If onReload is not nil it
+// will be called whenever one of the registry's scripts is found to be missing
+// on a redis instance during a call, and so has to be reloaded. addr will be
+// the address of the instance, if it's known, or empty string otherwise.
+func NewScriptRegistry(onReload func(name, addr string)) *ScriptRegistry {
+	return &ScriptRegistry{
+		scripts:  map[string]*Script{},
+		onReload: onReload,
+	}
+}
+
+// Register adds the given Script to the registry under the given name,
+// overwriting any Script previously registered under it. The registry keeps a
+// copy of the Script, retrieve it using Get.
+func (sr *ScriptRegistry) Register(name string, s *Script) {
+	s = NewScript(s.numKeys, s.script)
+	if sr.onReload != nil {
+		s.onNoScript = func(addr string) { sr.onReload(name, addr) }
+	}
+
+	sr.l.Lock()
+	defer sr.l.Unlock()
+	sr.scripts[name] = s
+}
+
+// Get returns the Script registered under the given name, or nil if there
+// isn't one
+func (sr *ScriptRegistry) Get(name string) *Script {
+	sr.l.RLock()
+	defer sr.l.RUnlock()
+	return sr.scripts[name]
+}
+
+// PreloadError is returned from Preload when one or more of the registry's
+// scripts could not be loaded. It maps the name of each such script to the
+// error which was encountered loading it.
+type PreloadError map[string]error
+
+func (pe PreloadError) Error() string {
+	return errMapString("could not load scripts: ", pe)
+}
+
+// Preload calls SCRIPT LOAD for every Script in the registry. If the given
+// Cmder is a Cluster this is done on every instance of the cluster. If any
+// scripts couldn't be loaded (e.g. because of a syntax error) a PreloadError
+// is returned, any other error means that the connections to redis couldn't
+// be retrieved.
+func (sr *ScriptRegistry) Preload(c Cmder) error {
+	var clients []Cmder
+	if cc, ok := c.(*cluster.Cluster); ok {
+		clientsM, err := cc.GetEvery()
+		if err != nil {
+			return err
+		}
+		for _, client := range clientsM {
+			defer cc.Put(client)
+			clients = append(clients, client)
+		}
+	} else {
+		clients = []Cmder{c}
+	}
+
+	sr.l.RLock()
+	defer sr.l.RUnlock()
+
+	pe := PreloadError{}
+	for _, client := range clients {
+		for name, s := range sr.scripts {
+			if _, ok := pe[name]; ok {
+				continue
+			}
+			if err := client.Cmd("SCRIPT", "LOAD", s.script).Err; err != nil {
+				pe[name] = err
+			}
+		}
+	}
+
+	if len(pe) > 0 {
+		return pe
+	}
+	return nil
+}
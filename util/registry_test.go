@@ -0,0 +1,70 @@
+package util
+
+import (
+	"errors"
+	. "testing"
+
+	"github.com/mediocregopher/radix.v2/cluster"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScriptRegistry(t *T) {
+	c1, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+	c2, err := cluster.New("127.0.0.1:7000")
+	require.Nil(t, err)
+
+	for _, c := range []Cmder{c1, c2} {
+		script, key, val := randTestScript()
+		sr := NewScriptRegistry(nil)
+		sr.Register("set", NewScript(1, script))
+		require.Nil(t, sr.Preload(c))
+
+		s, err := sr.Get("set").Cmd(c, key, val).Str()
+		require.Nil(t, err)
+		assert.Equal(t, "OK", s)
+		assert.Nil(t, sr.Get("dne"))
+	}
+
+	// a script which doesn't compile should be reported by name
+	sr := NewScriptRegistry(nil)
+	sr.Register("good", NewScript(0, `return 1`))
+	sr.Register("bad", NewScript(0, `return (`))
+	err = sr.Preload(c1)
+	require.NotNil(t, err)
+	pe, ok := err.(PreloadError)
+	require.True(t, ok)
+	assert.Len(t, pe, 1)
+	assert.NotNil(t, pe["bad"])
+}
+
+func TestScriptRegistryReload(t *T) {
+	var reloaded []string
+	sr := NewScriptRegistry(func(name, addr string) {
+		reloaded = append(reloaded, name)
+	})
+	sr.Register("foo", NewScript(0, `return 1`))
+
+	c := &scriptedCmder{replies: []*redis.Resp{
+		redis.NewResp(1),
+		redis.NewResp(errors.New("NOSCRIPT No matching script")),
+		redis.NewResp(1),
+	}}
+	for i := 0; i < 2; i++ {
+		i, err := sr.Get("foo").Cmd(c).Int()
+		require.Nil(t, err)
+		assert.Equal(t, 1, i)
+	}
+	assert.Equal(t, []string{"foo"}, reloaded)
+	assert.Equal(t, "EVAL", c.calls[2][0])
+}
+
+func TestPreloadError(t *T) {
+	pe := PreloadError{
+		"b": errors.New("bad b"),
+		"a": errors.New("bad a"),
+	}
+	assert.Equal(t, "could not load scripts: a: bad a, b: bad b", pe.Error())
+}
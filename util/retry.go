@@ -0,0 +1,251 @@
+package util
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/cluster"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// RetryPolicy decides whether or not a command which has failed should be
+// tried again, and if so how long to wait before doing so. attempt is the
+// number of attempts made so far (so 1 after the first failure), elapsed is how
+// much time has passed since the first attempt was made, and r is the response
+// of the most recent attempt.
+type RetryPolicy interface {
+	Retry(attempt int, elapsed time.Duration, r *redis.Resp) (time.Duration, bool)
+}
+
+// retryableErrPrefixes are the prefixes of AppErrs which redis returns when a
+// command couldn't be run at the moment, but may succeed later
+var retryableErrPrefixes = []string{
+	"LOADING",
+	"TRYAGAIN",
+	"CLUSTERDOWN",
+	"MASTERDOWN",
+}
+
+// IsRetryable returns true if the given Resp is an error which may not happen
+// again if the command were tried again. This is the case for IOErrs (e.g.
+// a connection being closed), for network errors from getting a connection
+// (e.g. a Pool failing to dial) and for AppErrs which indicate redis wasn't
+// ready to handle the command yet (e.g. LOADING). Other AppErrs, like WRONGTYPE,
+// will not go away by retrying and so are not considered retryable.
+func IsRetryable(r *redis.Resp) bool {
+	if r.IsType(redis.IOErr) {
+		return true
+	} else if !r.IsType(redis.AppErr) {
+		return false
+	} else if _, ok := r.Err.(net.Error); ok {
+		return true
+	}
+	msg := r.Err.Error()
+	for _, prefix := range retryableErrPrefixes {
+		if strings.HasPrefix(msg, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// BackoffPolicy is a RetryPolicy which retries after an exponentially
+// increasing, randomly jittered, delay. All fields are optional.
+type BackoffPolicy struct {
+	// The maximum number of attempts to make, including the first. Defaults to
+	// 3.
+	MaxAttempts int
+
+	// If set, no more attempts will be made once this much time has passed
+	// since the first attempt.
+	MaxElapsed time.Duration
+
+	// The delay is chosen randomly between zero and BaseDelay*2^(attempt-1),
+	// but never more than MaxDelay. These default to 10 milliseconds and 1
+	// second, respectively.
+	BaseDelay, MaxDelay time.Duration
+
+	// Decides which errors will be retried. Defaults to IsRetryable.
+	IsRetryable func(*redis.Resp) bool
+}
+
+// Retry implements the method for the RetryPolicy interface
+func (bp BackoffPolicy) Retry(attempt int, elapsed time.Duration, r *redis.Resp) (time.Duration, bool) {
+	if bp.MaxAttempts == 0 {
+		bp.MaxAttempts = 3
+	}
+	if bp.BaseDelay == 0 {
+		bp.BaseDelay = 10 * time.Millisecond
+	}
+	if bp.MaxDelay == 0 {
+		bp.MaxDelay = 1 * time.Second
+	}
+	if bp.IsRetryable == nil {
+		bp.IsRetryable = IsRetryable
+	}
+
+	if attempt >= bp.MaxAttempts || !bp.IsRetryable(r) {
+		return 0, false
+	}
+
+	delay := bp.MaxDelay
+	// avoid overflowing the shift for large numbers of attempts
+	if attempt < 32 {
+		if d := bp.BaseDelay << uint(attempt-1); d > 0 && d < delay {
+			delay = d
+		}
+	}
+	delay = time.Duration(rand.Int63n(int64(delay) + 1))
+
+	if bp.MaxElapsed > 0 && elapsed+delay >= bp.MaxElapsed {
+		return 0, false
+	}
+	return delay, true
+}
+
+// RetryError is the error on the Resp returned by a Retrier when a command
+// was retried and still failed. The Resp is of the same type, IOErr or AppErr,
+// as that of the last attempt.
+type RetryError struct {
+	// The number of attempts which were made, including the first
+	Attempts int
+
+	// The error from the last attempt
+	Err error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("failed after %d attempts: %s", e.Attempts, e.Err)
+}
+
+// idempotentCmds are the commands, other than those which only read, which a
+// Retrier retries after an IOErr, since running them twice has the same effect
+// and gives the same reply as running them once. That rules out commands like
+// DEL or SADD, which reply with how much they changed, and so give a smaller
+// count the second time. SET is only retried without the options in
+// nonIdempotentSetOpts, see isIdempotent.
+var idempotentCmds = map[string]bool{
+	"SET": true, "MSET": true, "HMSET": true,
+	"EXPIREAT": true, "PEXPIREAT": true,
+	"PING": true, "ECHO": true,
+}
+
+func init() {
+	for _, cmd := range cluster.DefaultReadOnlyCommands {
+		idempotentCmds[cmd] = true
+	}
+}
+
+// nonIdempotentSetOpts are the SET options with which running it a second time
+// can fail, or reply differently, where the first time succeeded
+var nonIdempotentSetOpts = map[string]bool{"NX": true, "XX": true, "GET": true}
+
+// isIdempotent returns whether the given command is in idempotentCmds, taking
+// SET's options into account
+func isIdempotent(cmd string, args []interface{}) bool {
+	cmd = strings.ToUpper(cmd)
+	if cmd != "SET" {
+		return idempotentCmds[cmd]
+	} else if len(args) < 2 {
+		return true
+	}
+	// the options come after the key and value
+	for _, arg := range args[2:] {
+		var opt string
+		switch argt := arg.(type) {
+		case string:
+			opt = argt
+		case []byte:
+			opt = string(argt)
+		}
+		if nonIdempotentSetOpts[strings.ToUpper(opt)] {
+			return false
+		}
+	}
+	return true
+}
+
+// Retrier wraps a Cmder so that failed commands are retried according to a
+// RetryPolicy. It is itself a Cmder, and so can be used with the rest of this
+// package.
+//
+// Wrapping a Pool, Cluster or sentinel Master means each attempt is made on a
+// fresh connection, since they each get a connection per command, and one
+// which failed with an IOErr is closed rather than being reused.
+type Retrier struct {
+	c Cmder
+	p RetryPolicy
+}
+
+// Retry returns a Retrier which will call commands on the given Cmder, retrying
+// them according to the given RetryPolicy.
+//
+//	c := util.Retry(util.BackoffPolicy{MaxAttempts: 5}, p)
+//	foo, err := c.Cmd("GET", "foo").Str()
+func Retry(p RetryPolicy, c Cmder) *Retrier {
+	return &Retrier{c: c, p: p}
+}
+
+// Cmd calls the given command on the underlying Cmder, retrying it as long as
+// the RetryPolicy says to. If it never succeeds the response from the last
+// attempt is returned, with its error wrapped in a *RetryError if there was
+// more than one.
+//
+// An IOErr doesn't mean a command wasn't run by redis, since the connection
+// may have failed after it was written. So, whatever the RetryPolicy says,
+// commands which change something or reply differently when run again (e.g.
+// INCR, DEL or SET with NX) aren't retried after an IOErr, only after errors
+// which mean they weren't run (e.g. LOADING). Use CmdIdempotent to retry such
+// a command anyway.
+func (r *Retrier) Cmd(cmd string, args ...interface{}) *redis.Resp {
+	return r.cmd(isIdempotent(cmd, args), cmd, args)
+}
+
+// CmdIdempotent is like Cmd, but the command is retried after an IOErr even if
+// it's one which changes something when run again. This is for when the caller
+// knows that running the command twice is safe, e.g. an INCR of a counter
+// which doesn't need to be exact, or a DEL whose reply isn't looked at.
+func (r *Retrier) CmdIdempotent(cmd string, args ...interface{}) *redis.Resp {
+	return r.cmd(true, cmd, args)
+}
+
+func (r *Retrier) cmd(idempotent bool, cmd string, args []interface{}) *redis.Resp {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		resp := r.c.Cmd(cmd, args...)
+		if resp.Err == nil {
+			return resp
+		} else if resp.IsType(redis.IOErr) && !idempotent {
+			return retryFailed(attempt, resp)
+		}
+
+		delay, ok := r.p.Retry(attempt, time.Since(start), resp)
+		if !ok {
+			return retryFailed(attempt, resp)
+		}
+		time.Sleep(delay)
+	}
+}
+
+// retryFailed returns the Resp of the last of the given number of attempts,
+// with its error wrapped if there was more than one
+func retryFailed(attempts int, r *redis.Resp) *redis.Resp {
+	if attempts == 1 {
+		return r
+	}
+	err := &RetryError{Attempts: attempts, Err: r.Err}
+	if r.IsType(redis.IOErr) {
+		return redis.NewRespIOErr(err)
+	}
+	return redis.NewResp(err)
+}
+
+// CmdOnce calls the given command on the underlying Cmder without ever
+// retrying it. This should be used for commands which mustn't be run again
+// even when redis says it didn't run them, which is rare.
+func (r *Retrier) CmdOnce(cmd string, args ...interface{}) *redis.Resp {
+	return r.c.Cmd(cmd, args...)
+}
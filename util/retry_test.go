@@ -0,0 +1,112 @@
+package util
+
+import (
+	"errors"
+	"net"
+	. "testing"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRetryable(t *T) {
+	assert.True(t, IsRetryable(redis.NewRespIOErr(errors.New("closed"))))
+	assert.True(t, IsRetryable(redis.NewResp(errors.New("LOADING loading"))))
+	assert.True(t, IsRetryable(redis.NewResp(errors.New("TRYAGAIN later"))))
+	assert.False(t, IsRetryable(redis.NewResp(errors.New("WRONGTYPE bad"))))
+	assert.False(t, IsRetryable(redis.NewResp("OK")))
+
+	// a Pool failing to dial gives an AppErr, but with a network error
+	_, err := net.Dial("tcp", "127.0.0.1:0")
+	require.NotNil(t, err)
+	assert.True(t, IsRetryable(redis.NewResp(err)))
+}
+
+func TestBackoffPolicy(t *T) {
+	loading := redis.NewResp(errors.New("LOADING loading"))
+	bp := BackoffPolicy{
+		MaxAttempts: 4,
+		BaseDelay:   10 * time.Millisecond,
+		MaxDelay:    25 * time.Millisecond,
+		MaxElapsed:  time.Second,
+	}
+
+	for attempt := 1; attempt < 4; attempt++ {
+		delay, ok := bp.Retry(attempt, 0, loading)
+		assert.True(t, ok)
+		assert.True(t, delay <= 25*time.Millisecond)
+	}
+
+	_, ok := bp.Retry(4, 0, loading)
+	assert.False(t, ok)
+	_, ok = bp.Retry(1, time.Second, loading)
+	assert.False(t, ok)
+	_, ok = bp.Retry(1, 0, redis.NewResp(errors.New("WRONGTYPE bad")))
+	assert.False(t, ok)
+}
+
+func TestRetrier(t *T) {
+	ioErr := redis.NewRespIOErr(errors.New("closed"))
+	bp := BackoffPolicy{BaseDelay: time.Millisecond}
+
+	c := &scriptedCmder{replies: []*redis.Resp{ioErr, ioErr, redis.NewResp("bar")}}
+	s, err := Retry(bp, c).Cmd("GET", "foo").Str()
+	require.Nil(t, err)
+	assert.Equal(t, "bar", s)
+	assert.Len(t, c.calls, 3)
+
+	c = &scriptedCmder{replies: []*redis.Resp{ioErr, ioErr, ioErr}}
+	r := Retry(bp, c).Cmd("GET", "foo")
+	assert.True(t, r.IsType(redis.IOErr))
+	assert.Equal(t, &RetryError{Attempts: 3, Err: ioErr.Err}, r.Err)
+	assert.Len(t, c.calls, 3)
+
+	wrongType := redis.NewResp(errors.New("WRONGTYPE bad"))
+	c = &scriptedCmder{replies: []*redis.Resp{wrongType}}
+	assert.Equal(t, wrongType, Retry(bp, c).Cmd("GET", "foo"))
+	assert.Len(t, c.calls, 1)
+
+	c = &scriptedCmder{replies: []*redis.Resp{ioErr}}
+	assert.Equal(t, ioErr, Retry(bp, c).CmdOnce("INCR", "foo"))
+	assert.Len(t, c.calls, 1)
+
+	// INCR may have been run before the IOErr, so it's not retried unless
+	// asked for, but redis not having run it at all is fine
+	c = &scriptedCmder{replies: []*redis.Resp{ioErr}}
+	assert.Equal(t, ioErr, Retry(bp, c).Cmd("INCR", "foo"))
+	assert.Len(t, c.calls, 1)
+
+	c = &scriptedCmder{replies: []*redis.Resp{ioErr, redis.NewResp(1)}}
+	assert.Nil(t, Retry(bp, c).CmdIdempotent("INCR", "foo").Err)
+	assert.Len(t, c.calls, 2)
+
+	// SET is idempotent, but not when its result depends on what's already
+	// there
+	c = &scriptedCmder{replies: []*redis.Resp{ioErr, redis.NewResp("OK")}}
+	assert.Nil(t, Retry(bp, c).Cmd("SET", "foo", "bar", "EX", 10).Err)
+	assert.Len(t, c.calls, 2)
+	for _, opt := range []string{"NX", "xx", "GET"} {
+		c = &scriptedCmder{replies: []*redis.Resp{ioErr}}
+		assert.Equal(t, ioErr, Retry(bp, c).Cmd("SET", "foo", "bar", "EX", 10, opt))
+		assert.Len(t, c.calls, 1)
+	}
+
+	// DEL has the same effect when run again, but replies 0 the second time
+	c = &scriptedCmder{replies: []*redis.Resp{ioErr}}
+	assert.Equal(t, ioErr, Retry(bp, c).Cmd("DEL", "foo"))
+	assert.Len(t, c.calls, 1)
+
+	loading := redis.NewResp(errors.New("LOADING loading"))
+	c = &scriptedCmder{replies: []*redis.Resp{loading, loading, ioErr}}
+	r = Retry(bp, c).Cmd("incr", "foo")
+	assert.True(t, r.IsType(redis.IOErr))
+	assert.Equal(t, &RetryError{Attempts: 3, Err: ioErr.Err}, r.Err)
+	assert.Len(t, c.calls, 3)
+
+	c = &scriptedCmder{replies: []*redis.Resp{loading, loading, loading}}
+	r = Retry(bp, c).Cmd("INCR", "foo")
+	assert.True(t, r.IsType(redis.AppErr))
+	assert.Equal(t, &RetryError{Attempts: 3, Err: loading.Err}, r.Err)
+}
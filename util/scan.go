@@ -1,7 +1,10 @@
 package util
 
 import (
+	"context"
 	"errors"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/mediocregopher/radix.v2/cluster"
@@ -24,11 +27,27 @@ type ScanOpts struct {
 	// return per call. This does not affect the actual results of the scan
 	// command, but it may be useful for optimizing certain datasets
 	Count int
+
+	// An optional type (e.g. "string", "hash", "zset") to filter returned keys
+	// by, which is done by redis itself. Only valid when Command is "SCAN", and
+	// requires redis 6.0 or later
+	Type string
+
+	// An optional checkpoint, previously retrieved from a Scanner or
+	// PairScanner with the same options, to resume scanning from
+	Resume *ScanCheckpoint
+}
+
+func (o ScanOpts) validate() error {
+	if o.Type != "" && strings.ToUpper(o.Command) != "SCAN" {
+		return errors.New("ScanOpts Type is only valid for the SCAN command")
+	}
+	return nil
 }
 
 func doScanPart(c Cmder, o ScanOpts, cursor string) (string, []string, error) {
 	cmd := strings.ToUpper(o.Command)
-	args := make([]interface{}, 0, 4)
+	args := make([]interface{}, 0, 8)
 	if cmd != "SCAN" {
 		args = append(args, o.Key)
 	}
@@ -39,6 +58,9 @@ func doScanPart(c Cmder, o ScanOpts, cursor string) (string, []string, error) {
 	if o.Count > 0 {
 		args = append(args, "COUNT", o.Count)
 	}
+	if o.Type != "" {
+		args = append(args, "TYPE", o.Type)
+	}
 	parts, err := c.Cmd(cmd, args...).Array()
 	if err != nil {
 		return "", nil, err
@@ -181,6 +203,15 @@ func Scan(r Cmder, ch chan string, cmd, key, pattern string) error {
 // false, call Err() to potentially retrieve an error which stopped the
 // iteration.
 //
+// Each chunk of results is fetched with its own call to the Cmder's Cmd, so
+// when scanning a Pool a connection is only held for as long as it takes to
+// fetch one chunk, and a long scan doesn't keep one out of the Pool.
+//
+// Like SCAN itself, a Scanner may return the same result more than once, if it
+// appears in more than one chunk (e.g. because the keyspace was rehashed part
+// way through). Nothing is done to prevent this, so if it matters the caller
+// must dedupe the results.
+//
 // Example SCAN command
 //
 //	s := util.NewScanner(cmder, util.ScanOpts{Command: "SCAN"})
@@ -203,12 +234,96 @@ func Scan(r Cmder, ch chan string, cmd, key, pattern string) error {
 //
 // HasNext MUST be called before every call to Next. Err MUST be called after
 // HasNext returns false.
+//
+// A Scanner returns the results of HSCAN and ZSCAN as a flat list, see
+// PairScanner for getting them back as field/value or member/score pairs.
 type Scanner interface {
 	HasNext() bool
 	Next() string
 	Err() error
 }
 
+// ScanChan runs the given Scanner in a separate go-routine, writing each of its
+// results to the returned string channel. Once the scan is done, either
+// because it completed, it encountered an error, or the given context was
+// cancelled, the string channel is closed and the result of the scan (the
+// context's error if it was cancelled, otherwise the Scanner's Err) is written
+// to the error channel, which is then closed as well.
+//
+// The string channel is unbuffered, so the scan only progresses as quickly as
+// results are read off of it. Cancelling the context is the only way to stop
+// a scan early, reading must otherwise continue until the string channel is
+// closed.
+//
+//	ctx, cancel := context.WithCancel(context.Background())
+//	defer cancel()
+//	keyCh, errCh := util.ScanChan(ctx, util.NewScanner(cmder, util.ScanOpts{Command: "SCAN"}))
+//	for key := range keyCh {
+//		log.Printf("next: %q", key)
+//	}
+//	if err := <-errCh; err != nil {
+//		log.Fatal(err)
+//	}
+//
+// The usual SCAN guarantees apply: a key present for the whole duration of the
+// scan will be returned at least once, but keys may be returned more than once,
+// and keys which are added or removed during the scan may or may not be
+// returned.
+func ScanChan(ctx context.Context, s Scanner) (<-chan string, <-chan error) {
+	ch := make(chan string)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(errCh)
+		defer close(ch)
+		for ctx.Err() == nil && s.HasNext() {
+			key := s.Next()
+			if ctx.Err() != nil {
+				break
+			}
+			select {
+			case ch <- key:
+			case <-ctx.Done():
+			}
+		}
+
+		// Err must always be called, so the Scanner can clean up
+		err := s.Err()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+		errCh <- err
+	}()
+	return ch, errCh
+}
+
+// ScanCheckpoint records how far along a Scanner or PairScanner has gotten, so
+// that a new one can later be created which picks up where it left off (see the
+// Resume field on ScanOpts). It can be serialized using encoding/json, but its
+// contents should otherwise be treated as opaque.
+//
+// Resuming from a checkpoint may cause some results which were already
+// returned to be returned again, but will not cause any to be missed (barring
+// the normal SCAN guarantees).
+type ScanCheckpoint struct {
+	// The cursor to resume from, for scans of a single instance
+	Cursor string `json:"cursor,omitempty"`
+
+	// The cursor to resume from for each node, keyed by node ID, for scans
+	// over a whole cluster. Nodes whose ID isn't present will be scanned from
+	// the beginning.
+	Nodes map[string]string `json:"nodes,omitempty"`
+}
+
+// Checkpointer is implemented by every Scanner returned from NewScanner, as
+// well as every PairScanner. Checkpoint may be called at any point during
+// iteration, for example:
+//
+//	cp := s.(util.Checkpointer).Checkpoint()
+//	b, err := json.Marshal(cp)
+type Checkpointer interface {
+	Checkpoint() ScanCheckpoint
+}
+
 type singleScanner struct {
 	c Cmder
 	o ScanOpts
@@ -216,18 +331,39 @@ type singleScanner struct {
 	err    error
 	cursor string
 	buf    []string
+
+	// the cursor which was used to retrieve buf
+	bufCursor string
 }
 
 // NewScanner initializes a Scanner struct with the given options and returns
-// it.
+// it. If the options are invalid the returned Scanner's HasNext will return
+// false, and Err will return why.
+//
+// If the Cmder is a Cluster and the Command is SCAN, every master in the
+// cluster is scanned, one after the other. A node which fails part way through
+// doesn't stop the others being scanned, and Err returns a *ScanPartialError
+// saying which nodes failed. If slots are moved between nodes during the scan,
+// keys may be returned by both the node they moved from and the one they moved
+// to. As a best effort against this, keys from any one slot are only returned
+// from the first node they're seen on, but a slot which was empty on the node
+// it moved from, or which only moved part way, may still have its keys
+// returned twice.
 func NewScanner(c Cmder, o ScanOpts) Scanner {
+	if err := o.validate(); err != nil {
+		return &singleScanner{err: err}
+	}
 	if cc, ok := c.(*cluster.Cluster); ok && strings.ToUpper(o.Command) == "SCAN" {
 		return &clusterScanner{c: cc, o: o}
 	}
-	return &singleScanner{
+	s := &singleScanner{
 		c: c,
 		o: o,
 	}
+	if o.Resume != nil {
+		s.cursor = o.Resume.Cursor
+	}
+	return s
 }
 
 func (s *singleScanner) HasNext() bool {
@@ -248,7 +384,12 @@ func (s *singleScanner) HasNext() bool {
 			return false
 		}
 
-		s.cursor, s.buf, s.err = doScanPart(s.c, s.o, s.cursor)
+		cursor, buf, err := doScanPart(s.c, s.o, s.cursor)
+		if err != nil {
+			s.err = err
+			continue
+		}
+		s.bufCursor, s.cursor, s.buf = s.cursor, cursor, buf
 	}
 }
 
@@ -264,51 +405,172 @@ func (s *singleScanner) Err() error {
 	return s.err
 }
 
+func (s *singleScanner) checkpointCursor() string {
+	// If there's still results in the buffer which haven't been returned we
+	// have to resume from the cursor which retrieved them
+	if len(s.buf) > 0 {
+		return s.bufCursor
+	}
+	return s.cursor
+}
+
+func (s *singleScanner) Checkpoint() ScanCheckpoint {
+	return ScanCheckpoint{Cursor: s.checkpointCursor()}
+}
+
+// ScanPartialError is returned from the Err method of a Scanner over a whole
+// cluster when some of its nodes couldn't be scanned completely, e.g. because
+// they went away part way through. The scan carries on with the other nodes
+// regardless, so every result from them was still returned, as were those from
+// the failed nodes up until they failed. A Checkpoint taken once the scan is
+// done can be used to retry just the failed nodes.
+type ScanPartialError struct {
+	// The error each failed node failed with, keyed by its address
+	Errs map[string]error
+}
+
+func (e *ScanPartialError) Error() string {
+	addrs := make([]string, 0, len(e.Errs))
+	for addr := range e.Errs {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	strs := make([]string, len(addrs))
+	for i, addr := range addrs {
+		strs[i] = addr + ": " + e.Errs[addr].Error()
+	}
+	return "scan failed on some nodes: " + strings.Join(strs, ", ")
+}
+
 type clusterScanner struct {
 	c *cluster.Cluster
 	o ScanOpts
 
-	err         error
-	clients     []*redis.Client
-	currScanner Scanner
+	// err is what stopped the scan altogether, nodeErrs those of nodes which
+	// were given up on
+	err      error
+	nodeErrs map[string]error
+
+	// which node, by ID, keys from each slot have been returned from
+	slotNodes map[uint16]string
+
+	// clients and ids are in parallel, clients[0] is the one currently being
+	// scanned
+	clients []*redis.Client
+	ids     []string
+
+	// the checkpointed cursors of nodes which have already been scanned
+	// completely, or which haven't been reached yet
+	cursors     map[string]string
+	currScanner *singleScanner
+}
+
+func (cs *clusterScanner) init() error {
+	clientsM, err := cs.c.GetEvery()
+	if err != nil {
+		return err
+	}
+
+	cs.clients = make([]*redis.Client, 0, len(clientsM))
+	for _, client := range clientsM {
+		cs.clients = append(cs.clients, client)
+	}
+
+	// Node IDs are used for checkpointing rather than addresses, since
+	// addresses may change between the checkpoint being made and it being
+	// resumed
+	cs.ids = make([]string, 0, len(cs.clients))
+	clients := cs.clients[:0]
+	for _, client := range cs.clients {
+		id, err := client.Cmd("CLUSTER", "MYID").Str()
+		if err != nil {
+			cs.nodeFailed(client, err)
+			continue
+		}
+		clients = append(clients, client)
+		cs.ids = append(cs.ids, id)
+	}
+	cs.clients = clients
+	cs.slotNodes = map[uint16]string{}
+
+	// Only the cursors of nodes which still exist are kept
+	cs.cursors = map[string]string{}
+	if cs.o.Resume != nil {
+		for _, id := range cs.ids {
+			if cursor, ok := cs.o.Resume.Nodes[id]; ok {
+				cs.cursors[id] = cursor
+			}
+		}
+	}
+	return nil
 }
 
 func (cs *clusterScanner) HasNext() bool {
 	// if clients is nil then HasNext has never been called, and we need to get
 	// some clients
-	if cs.clients == nil {
-		clientsM, err := cs.c.GetEvery()
-		if err != nil {
-			cs.err = err
+	if cs.clients == nil && cs.err == nil {
+		if cs.err = cs.init(); cs.err != nil {
 			return false
 		}
-
-		cs.clients = make([]*redis.Client, 0, len(clientsM))
-		for _, client := range clientsM {
-			cs.clients = append(cs.clients, client)
-		}
 	}
 
 	for {
 		if len(cs.clients) == 0 || cs.err != nil {
 			return false
 		} else if cs.currScanner == nil {
-			cs.currScanner = NewScanner(cs.clients[0], cs.o)
+			cs.currScanner = &singleScanner{
+				c:      cs.clients[0],
+				o:      cs.o,
+				cursor: cs.cursors[cs.ids[0]],
+			}
 		}
 
 		if cs.currScanner.HasNext() {
-			return true
+			if cs.claim(cs.currScanner.buf[0]) {
+				return true
+			}
+			cs.currScanner.Next()
+			continue
 		}
 
-		// if err isn't nil, cleanup will happen when Err is called on
-		// clusterScanner
-		cs.err = cs.currScanner.Err()
+		// A node which fails is given up on, but the rest are still scanned.
+		// Its cursor is kept so that a checkpoint can resume it.
+		if err := cs.currScanner.Err(); err != nil {
+			cs.cursors[cs.ids[0]] = cs.currScanner.checkpointCursor()
+			cs.nodeFailed(cs.clients[0], err)
+		} else {
+			cs.cursors[cs.ids[0]] = "0"
+			cs.c.Put(cs.clients[0])
+		}
 		cs.currScanner = nil
-		cs.c.Put(cs.clients[0])
 		cs.clients = cs.clients[1:]
+		cs.ids = cs.ids[1:]
 	}
 }
 
+// claim returns whether the key should be returned. Keys are only returned
+// from the first node which any key in their slot was seen on, so that if the
+// slot is moved to a node which hasn't been scanned yet its keys aren't
+// returned a second time.
+func (cs *clusterScanner) claim(key string) bool {
+	slot := cluster.Slot(key)
+	id, ok := cs.slotNodes[slot]
+	if !ok {
+		cs.slotNodes[slot] = cs.ids[0]
+		return true
+	}
+	return id == cs.ids[0]
+}
+
+// nodeFailed records the error of a node which is being given up on
+func (cs *clusterScanner) nodeFailed(client *redis.Client, err error) {
+	if cs.nodeErrs == nil {
+		cs.nodeErrs = map[string]error{}
+	}
+	cs.nodeErrs[client.Addr] = err
+	cs.c.Put(client)
+}
+
 func (cs *clusterScanner) Next() string {
 	return cs.currScanner.Next()
 }
@@ -320,5 +582,161 @@ func (cs *clusterScanner) Err() error {
 		cs.c.Put(client)
 	}
 	cs.clients = nil
+	if cs.err == nil && len(cs.nodeErrs) > 0 {
+		return &ScanPartialError{Errs: cs.nodeErrs}
+	}
 	return cs.err
 }
+
+func (cs *clusterScanner) Checkpoint() ScanCheckpoint {
+	// If HasNext has never been called then we have nothing new to say
+	if cs.cursors == nil {
+		if cs.o.Resume != nil {
+			return ScanCheckpoint{Nodes: copyCursors(cs.o.Resume.Nodes)}
+		}
+		return ScanCheckpoint{Nodes: map[string]string{}}
+	}
+
+	nodes := copyCursors(cs.cursors)
+	if cs.currScanner != nil && len(cs.ids) > 0 {
+		nodes[cs.ids[0]] = cs.currScanner.checkpointCursor()
+	}
+	return ScanCheckpoint{Nodes: nodes}
+}
+
+func copyCursors(m map[string]string) map[string]string {
+	m2 := make(map[string]string, len(m))
+	for k, v := range m {
+		m2[k] = v
+	}
+	return m2
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ScanPair is a single element returned from a PairScanner. For HSCAN Key is
+// the hash field and Val its value. For ZSCAN Key is the sorted set member, Val
+// its score as returned by redis, and Score that same score parsed as a float.
+type ScanPair struct {
+	Key, Val string
+	Score    float64
+}
+
+// PairScanner is like Scanner, but is used for HSCAN and ZSCAN, whose results
+// are made up of field/value or member/score pairs, rather than single
+// elements. It is used in exactly the same way as a Scanner.
+//
+// Example HSCAN command
+//
+//	s := util.NewPairScanner(cmder, util.ScanOpts{Command: "HSCAN", Key: "somekey"})
+//	for s.HasNext() {
+//		p := s.Next()
+//		log.Printf("field: %q value: %q", p.Key, p.Val)
+//	}
+//	if err := s.Err(); err != nil {
+//		log.Fatal(err)
+//	}
+//
+// As with SCAN itself, the Count in ScanOpts is only a hint, redis may return
+// more or fewer pairs per call, and some calls may return none at all even
+// though the scan isn't finished. Neither affects the results of the scan.
+type PairScanner interface {
+	HasNext() bool
+	Next() ScanPair
+	Err() error
+	Checkpointer
+}
+
+type pairScanner struct {
+	c     Cmder
+	o     ScanOpts
+	score bool
+
+	err    error
+	cursor string
+	buf    []ScanPair
+
+	// the cursor which was used to retrieve buf
+	bufCursor string
+}
+
+// NewPairScanner initializes a PairScanner with the given options and returns
+// it. The Command in the options must be either HSCAN or ZSCAN.
+func NewPairScanner(c Cmder, o ScanOpts) PairScanner {
+	ps := &pairScanner{c: c, o: o}
+	switch strings.ToUpper(o.Command) {
+	case "HSCAN":
+	case "ZSCAN":
+		ps.score = true
+	default:
+		ps.err = errors.New("PairScanner only supports HSCAN and ZSCAN")
+	}
+	if ps.err == nil {
+		ps.err = o.validate()
+	}
+	if o.Resume != nil {
+		ps.cursor = o.Resume.Cursor
+	}
+	return ps
+}
+
+func (ps *pairScanner) HasNext() bool {
+	for {
+		if ps.err != nil {
+			return false
+		} else if len(ps.buf) > 0 {
+			return true
+		} else if ps.cursor == "0" {
+			return false
+		}
+
+		cursor, elems, err := doScanPart(ps.c, ps.o, ps.cursor)
+		if err != nil {
+			ps.err = err
+			continue
+		}
+		buf, err := ps.pairs(elems)
+		if err != nil {
+			ps.err = err
+			continue
+		}
+		ps.bufCursor, ps.cursor, ps.buf = ps.cursor, cursor, buf
+	}
+}
+
+func (ps *pairScanner) pairs(elems []string) ([]ScanPair, error) {
+	if len(elems)%2 != 0 {
+		return nil, errors.New("odd number of elements returned")
+	}
+	pairs := make([]ScanPair, 0, len(elems)/2)
+	for i := 0; i < len(elems); i += 2 {
+		p := ScanPair{Key: elems[i], Val: elems[i+1]}
+		if ps.score {
+			var err error
+			if p.Score, err = strconv.ParseFloat(p.Val, 64); err != nil {
+				return nil, err
+			}
+		}
+		pairs = append(pairs, p)
+	}
+	return pairs, nil
+}
+
+func (ps *pairScanner) Next() ScanPair {
+	// we assume they called HasNext first, which garauntees that this won't
+	// panic
+	p := ps.buf[0]
+	ps.buf = ps.buf[1:]
+	return p
+}
+
+func (ps *pairScanner) Err() error {
+	return ps.err
+}
+
+func (ps *pairScanner) Checkpoint() ScanCheckpoint {
+	if len(ps.buf) > 0 {
+		return ScanCheckpoint{Cursor: ps.bufCursor}
+	}
+	return ScanCheckpoint{Cursor: ps.cursor}
+}
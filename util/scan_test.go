@@ -1,7 +1,13 @@
 package util
 
 import (
+	"context"
+	"encoding/json"
+	"math"
+	"net"
+	"sort"
 	"strconv"
+	"strings"
 	. "testing"
 
 	"github.com/levenlabs/golib/testutil"
@@ -209,3 +215,465 @@ func TestScannerCluster(t *T) {
 	require.Nil(t, sc.Err())
 	assert.Empty(t, testMap)
 }
+
+// scriptedCmder returns the given replies, in order, one per call to Cmd,
+// recording the arguments it was called with
+type scriptedCmder struct {
+	replies []*redis.Resp
+	calls   [][]interface{}
+}
+
+func (sc *scriptedCmder) Cmd(cmd string, args ...interface{}) *redis.Resp {
+	sc.calls = append(sc.calls, append([]interface{}{cmd}, args...))
+	r := sc.replies[0]
+	sc.replies = sc.replies[1:]
+	return r
+}
+
+func scanReply(cursor string, elems ...string) *redis.Resp {
+	return redis.NewResp([]interface{}{cursor, elems})
+}
+
+func randHash(t *T, c Cmder, count int) (string, map[string]string) {
+	key := testutil.RandStr()
+	fullMap := map[string]string{}
+	for i := 0; i < count; i++ {
+		field, val := strconv.Itoa(i), testutil.RandStr()
+		fullMap[field] = val
+		require.Nil(t, c.Cmd("HSET", key, field, val).Err)
+	}
+	return key, fullMap
+}
+
+func TestPairScannerHash(t *T) {
+	client, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+	// large enough that redis won't store it as a ziplist, which would mean the
+	// whole thing gets returned in one call regardless of COUNT
+	key, fullMap := randHash(t, client, 1000)
+
+	for _, count := range []int{0, 1, 10, 5000} {
+		testMap := map[string]string{}
+		sc := NewPairScanner(client, ScanOpts{Command: "HSCAN", Key: key, Count: count})
+		for sc.HasNext() {
+			p := sc.Next()
+			testMap[p.Key] = p.Val
+		}
+		require.Nil(t, sc.Err())
+		assert.Equal(t, fullMap, testMap, "count: %d", count)
+	}
+
+	// a pattern matching only a single field will result in many calls which
+	// return nothing, which mustn't end the scan early
+	testMap := map[string]string{}
+	sc := NewPairScanner(client, ScanOpts{Command: "HSCAN", Key: key, Pattern: "999", Count: 10})
+	for sc.HasNext() {
+		p := sc.Next()
+		testMap[p.Key] = p.Val
+	}
+	require.Nil(t, sc.Err())
+	assert.Equal(t, map[string]string{"999": fullMap["999"]}, testMap)
+}
+
+func TestPairScannerZSet(t *T) {
+	client, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+	key := testutil.RandStr()
+	fullMap := map[string]float64{}
+	for i := 0; i < 200; i++ {
+		member, score := testutil.RandStr(), float64(i)+0.5
+		fullMap[member] = score
+		require.Nil(t, client.Cmd("ZADD", key, score, member).Err)
+	}
+
+	testMap := map[string]float64{}
+	sc := NewPairScanner(client, ScanOpts{Command: "ZSCAN", Key: key, Count: 10})
+	for sc.HasNext() {
+		p := sc.Next()
+		testMap[p.Key] = p.Score
+	}
+	require.Nil(t, sc.Err())
+	assert.Equal(t, fullMap, testMap)
+}
+
+func TestPairScannerScripted(t *T) {
+	// empty batches with a non-zero cursor must be skipped over
+	c := &scriptedCmder{replies: []*redis.Resp{
+		scanReply("5"),
+		scanReply("7", "f1", "v1"),
+		scanReply("9"),
+		scanReply("0", "f2", "", "f3", "v3"),
+	}}
+	var pairs []ScanPair
+	sc := NewPairScanner(c, ScanOpts{Command: "HSCAN", Key: "foo", Count: 1})
+	for sc.HasNext() {
+		pairs = append(pairs, sc.Next())
+	}
+	require.Nil(t, sc.Err())
+	assert.Equal(t, []ScanPair{{Key: "f1", Val: "v1"}, {Key: "f2"}, {Key: "f3", Val: "v3"}}, pairs)
+	assert.Len(t, c.calls, 4)
+	assert.Equal(t, []interface{}{"HSCAN", "foo", "", "COUNT", 1}, c.calls[0])
+	assert.Equal(t, []interface{}{"HSCAN", "foo", "9", "COUNT", 1}, c.calls[3])
+
+	// scores are parsed for ZSCAN
+	c = &scriptedCmder{replies: []*redis.Resp{scanReply("0", "m1", "1.5", "m2", "-inf")}}
+	pairs = nil
+	sc = NewPairScanner(c, ScanOpts{Command: "ZSCAN", Key: "foo"})
+	for sc.HasNext() {
+		pairs = append(pairs, sc.Next())
+	}
+	require.Nil(t, sc.Err())
+	require.Len(t, pairs, 2)
+	assert.Equal(t, 1.5, pairs[0].Score)
+	assert.True(t, math.IsInf(pairs[1].Score, -1))
+
+	// odd number of elements is an error
+	c = &scriptedCmder{replies: []*redis.Resp{scanReply("0", "f1")}}
+	sc = NewPairScanner(c, ScanOpts{Command: "HSCAN", Key: "foo"})
+	assert.False(t, sc.HasNext())
+	assert.NotNil(t, sc.Err())
+
+	// SSCAN isn't made of pairs
+	sc = NewPairScanner(c, ScanOpts{Command: "SSCAN", Key: "foo"})
+	assert.False(t, sc.HasNext())
+	assert.NotNil(t, sc.Err())
+}
+
+// batchRecorder wraps a Cmder and records the keys returned from each SCAN
+// call made through it
+type batchRecorder struct {
+	Cmder
+	batches [][]string
+}
+
+func (br *batchRecorder) Cmd(cmd string, args ...interface{}) *redis.Resp {
+	r := br.Cmder.Cmd(cmd, args...)
+	if parts, err := r.Array(); err == nil && len(parts) == 2 {
+		batch, _ := parts[1].List()
+		br.batches = append(br.batches, batch)
+	}
+	return r
+}
+
+func TestScannerType(t *T) {
+	client, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+
+	prefix := testutil.RandStr()
+	strKeys := map[string]bool{}
+	for i := 0; i < 50; i++ {
+		key := prefix + ":str:" + strconv.Itoa(i)
+		strKeys[key] = true
+		require.Nil(t, client.Cmd("SET", key, "1").Err)
+		require.Nil(t, client.Cmd("SADD", prefix+":set:"+strconv.Itoa(i), "1").Err)
+	}
+
+	br := &batchRecorder{Cmder: client}
+	testMap := map[string]bool{}
+	sc := NewScanner(br, ScanOpts{Command: "SCAN", Pattern: prefix + ":*", Count: 10, Type: "string"})
+	for sc.HasNext() {
+		testMap[sc.Next()] = true
+	}
+	require.Nil(t, sc.Err())
+	assert.Equal(t, strKeys, testMap)
+
+	// The filtering must have been done by redis, not after the fact
+	require.NotEmpty(t, br.batches)
+	for _, batch := range br.batches {
+		for _, key := range batch {
+			assert.True(t, strKeys[key], "unexpected key %q in batch", key)
+		}
+	}
+}
+
+func TestScanOptsArgs(t *T) {
+	c := &scriptedCmder{replies: []*redis.Resp{scanReply("0")}}
+	sc := NewScanner(c, ScanOpts{Command: "SCAN"})
+	assert.False(t, sc.HasNext())
+	require.Nil(t, sc.Err())
+	assert.Equal(t, []interface{}{"SCAN", ""}, c.calls[0])
+
+	c = &scriptedCmder{replies: []*redis.Resp{scanReply("0")}}
+	o := ScanOpts{Command: "scan", Pattern: "foo*", Count: 10, Type: "hash"}
+	sc = NewScanner(c, o)
+	assert.False(t, sc.HasNext())
+	require.Nil(t, sc.Err())
+	assert.Equal(t, []interface{}{"SCAN", "", "MATCH", "foo*", "COUNT", 10, "TYPE", "hash"}, c.calls[0])
+
+	// Type isn't valid for anything but SCAN, and nothing should be sent
+	c = &scriptedCmder{}
+	sc = NewScanner(c, ScanOpts{Command: "HSCAN", Key: "foo", Type: "hash"})
+	assert.False(t, sc.HasNext())
+	assert.NotNil(t, sc.Err())
+	assert.Empty(t, c.calls)
+
+	psc := NewPairScanner(c, ScanOpts{Command: "HSCAN", Key: "foo", Type: "hash"})
+	assert.False(t, psc.HasNext())
+	assert.NotNil(t, psc.Err())
+	assert.Empty(t, c.calls)
+}
+
+func TestScanChan(t *T) {
+	client, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+	prefix, fullMap := randPrefix(t, client, 100)
+
+	sc := NewScanner(client, ScanOpts{Command: "SCAN", Pattern: prefix + ":*"})
+	keyCh, errCh := ScanChan(context.Background(), sc)
+	testMap := map[string]bool{}
+	for key := range keyCh {
+		testMap[key] = true
+	}
+	require.Nil(t, <-errCh)
+	assert.Equal(t, fullMap, testMap)
+}
+
+func TestScanChanCancel(t *T) {
+	c := &scriptedCmder{replies: []*redis.Resp{
+		scanReply("5", "a", "b"),
+		scanReply("0", "c", "d"),
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	keyCh, errCh := ScanChan(ctx, NewScanner(c, ScanOpts{Command: "SCAN"}))
+	assert.Equal(t, "a", <-keyCh)
+	cancel()
+
+	// the channel may give back at most the key which was already being
+	// written when cancel was called, after that it must be closed
+	var n int
+	for range keyCh {
+		n++
+	}
+	assert.True(t, n <= 1)
+	assert.Equal(t, context.Canceled, <-errCh)
+	_, ok := <-errCh
+	assert.False(t, ok)
+}
+
+func TestScannerCheckpoint(t *T) {
+	c := &scriptedCmder{replies: []*redis.Resp{
+		scanReply("5", "a", "b"),
+		scanReply("9", "c"),
+	}}
+	sc := NewScanner(c, ScanOpts{Command: "SCAN"})
+	assert.Equal(t, ScanCheckpoint{}, sc.(Checkpointer).Checkpoint())
+
+	// mid-buffer the checkpoint must point at the cursor which retrieved the
+	// buffer, so nothing unreturned is lost
+	require.True(t, sc.HasNext())
+	assert.Equal(t, "a", sc.Next())
+	assert.Equal(t, ScanCheckpoint{Cursor: ""}, sc.(Checkpointer).Checkpoint())
+	require.True(t, sc.HasNext())
+	assert.Equal(t, "b", sc.Next())
+	assert.Equal(t, ScanCheckpoint{Cursor: "5"}, sc.(Checkpointer).Checkpoint())
+	require.True(t, sc.HasNext())
+	assert.Equal(t, "c", sc.Next())
+	cp := sc.(Checkpointer).Checkpoint()
+	assert.Equal(t, ScanCheckpoint{Cursor: "9"}, cp)
+
+	b, err := json.Marshal(cp)
+	require.Nil(t, err)
+	var cp2 ScanCheckpoint
+	require.Nil(t, json.Unmarshal(b, &cp2))
+
+	c = &scriptedCmder{replies: []*redis.Resp{scanReply("0", "d")}}
+	sc = NewScanner(c, ScanOpts{Command: "SCAN", Resume: &cp2})
+	require.True(t, sc.HasNext())
+	assert.Equal(t, "d", sc.Next())
+	assert.False(t, sc.HasNext())
+	require.Nil(t, sc.Err())
+	assert.Equal(t, []interface{}{"SCAN", "9"}, c.calls[0])
+
+	// resuming a finished scan yields nothing
+	cp = sc.(Checkpointer).Checkpoint()
+	assert.Equal(t, ScanCheckpoint{Cursor: "0"}, cp)
+	c = &scriptedCmder{}
+	sc = NewScanner(c, ScanOpts{Command: "SCAN", Resume: &cp})
+	assert.False(t, sc.HasNext())
+	require.Nil(t, sc.Err())
+	assert.Empty(t, c.calls)
+}
+
+func TestPairScannerCheckpoint(t *T) {
+	c := &scriptedCmder{replies: []*redis.Resp{scanReply("3", "f1", "v1")}}
+	ps := NewPairScanner(c, ScanOpts{Command: "HSCAN", Key: "foo"})
+	require.True(t, ps.HasNext())
+	ps.Next()
+	cp := ps.Checkpoint()
+	assert.Equal(t, ScanCheckpoint{Cursor: "3"}, cp)
+
+	c = &scriptedCmder{replies: []*redis.Resp{scanReply("0", "f2", "v2")}}
+	ps = NewPairScanner(c, ScanOpts{Command: "HSCAN", Key: "foo", Resume: &cp})
+	require.True(t, ps.HasNext())
+	assert.Equal(t, ScanPair{Key: "f2", Val: "v2"}, ps.Next())
+	assert.False(t, ps.HasNext())
+	assert.Equal(t, []interface{}{"HSCAN", "foo", "3"}, c.calls[0])
+}
+
+func TestScannerClusterCheckpoint(t *T) {
+	cluster, err := cluster.New("127.0.0.1:7000")
+	require.Nil(t, err)
+	prefix, fullMap := randPrefix(t, cluster, 100)
+	o := ScanOpts{Command: "SCAN", Pattern: prefix + ":*", Count: 5}
+
+	// scan part way, then checkpoint and resume with a new scanner
+	testMap := map[string]bool{}
+	sc := NewScanner(cluster, o)
+	for i := 0; i < 30 && sc.HasNext(); i++ {
+		testMap[sc.Next()] = true
+	}
+	cp := sc.(Checkpointer).Checkpoint()
+	require.Nil(t, sc.Err())
+	assert.NotEmpty(t, cp.Nodes)
+
+	o.Resume = &cp
+	sc = NewScanner(cluster, o)
+	for sc.HasNext() {
+		testMap[sc.Next()] = true
+	}
+	require.Nil(t, sc.Err())
+	assert.Equal(t, fullMap, testMap)
+}
+
+// scanNode is a fake cluster node which owns the given slots, and answers SCAN
+// from its pages, keyed by cursor. If failAt is set and a SCAN is sent with
+// that cursor the connection is closed instead.
+type scanNode struct {
+	net.Listener
+	id     string
+	slots  [2]int
+	pages  map[string][]interface{}
+	failAt string
+
+	// every node's slots, for CLUSTER SLOTS
+	all []*scanNode
+}
+
+func newScanNode(t *T, id string, start, end int) *scanNode {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	sn := &scanNode{Listener: l, id: id, slots: [2]int{start, end}}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go sn.serve(conn)
+		}
+	}()
+	return sn
+}
+
+func (sn *scanNode) serve(conn net.Conn) {
+	defer conn.Close()
+	rr := redis.NewRespReader(conn)
+	for {
+		args, err := rr.Read().List()
+		if err != nil {
+			return
+		}
+		var reply interface{}
+		switch cmd := strings.ToUpper(strings.Join(args[:2], " ")); {
+		case cmd == "CLUSTER SLOTS":
+			var slots []interface{}
+			for _, n := range sn.all {
+				host, port, _ := net.SplitHostPort(n.Addr().String())
+				portN, _ := strconv.Atoi(port)
+				slots = append(slots, []interface{}{n.slots[0], n.slots[1], []interface{}{host, portN}})
+			}
+			reply = slots
+		case cmd == "CLUSTER MYID":
+			reply = sn.id
+		case strings.HasPrefix(cmd, "SCAN "):
+			if sn.failAt != "" && args[1] == sn.failAt {
+				return
+			}
+			reply = sn.pages[args[1]]
+		}
+		redis.NewResp(reply).WriteTo(conn)
+	}
+}
+
+func newScanCluster(t *T) (*cluster.Cluster, *scanNode, *scanNode) {
+	n1 := newScanNode(t, "n1", 0, 8191)
+	n2 := newScanNode(t, "n2", 8192, 16383)
+	n1.all = []*scanNode{n1, n2}
+	n2.all = n1.all
+
+	// "{foo}dup" is in n2's slots, but is also returned by n1, as if its slot
+	// was moved from n1 to n2 during the scan
+	n1.pages = map[string][]interface{}{
+		"":  {"7", []string{"a1", "{foo}dup"}},
+		"7": {"0", []string{"a2"}},
+	}
+	n2.pages = map[string][]interface{}{
+		"":  {"3", []string{"b1", "{foo}dup"}},
+		"3": {"0", []string{"b2"}},
+	}
+	require.True(t, cluster.Slot("{foo}dup") > 8191)
+
+	c, err := cluster.NewWithOpts(cluster.Opts{Addr: n1.Addr().String(), PoolSize: 1})
+	require.Nil(t, err)
+	return c, n1, n2
+}
+
+func TestScannerClusterFake(t *T) {
+	c, n1, n2 := newScanCluster(t)
+	defer n1.Close()
+	defer n2.Close()
+	defer c.Close()
+
+	var got []string
+	sc := NewScanner(c, ScanOpts{Command: "SCAN"})
+	for sc.HasNext() {
+		got = append(got, sc.Next())
+	}
+	require.Nil(t, sc.Err())
+	sort.Strings(got)
+	assert.Equal(t, []string{"a1", "a2", "b1", "b2", "{foo}dup"}, got)
+}
+
+func TestScannerClusterPartial(t *T) {
+	c, n1, n2 := newScanCluster(t)
+	defer n1.Close()
+	defer n2.Close()
+	defer c.Close()
+	n2.failAt = "3"
+
+	// n2 going away only loses the rest of its own keys
+	got := map[string]bool{}
+	sc := NewScanner(c, ScanOpts{Command: "SCAN"})
+	for sc.HasNext() {
+		got[sc.Next()] = true
+	}
+	err := sc.Err()
+	perr, ok := err.(*ScanPartialError)
+	require.True(t, ok, "%v", err)
+	assert.Len(t, perr.Errs, 1)
+	assert.Contains(t, perr.Errs, n2.Addr().String())
+	for _, key := range []string{"a1", "a2", "b1", "{foo}dup"} {
+		assert.True(t, got[key], key)
+	}
+	assert.False(t, got["b2"])
+
+	// and its scan can be picked up again where it failed
+	cp := sc.(Checkpointer).Checkpoint()
+	assert.Equal(t, map[string]string{"n1": "0", "n2": "3"}, cp.Nodes)
+}
+
+func TestScannerDuplicates(t *T) {
+	c := &scriptedCmder{replies: []*redis.Resp{
+		scanReply("5", "a", "b"),
+		scanReply("0", "b", "c"),
+	}}
+	sc := NewScanner(c, ScanOpts{Command: "SCAN"})
+	var got []string
+	for sc.HasNext() {
+		got = append(got, sc.Next())
+	}
+	require.Nil(t, sc.Err())
+	assert.Equal(t, []string{"a", "b", "b", "c"}, got)
+	// one Cmd per chunk, so a Pool's connection is only held for each chunk
+	assert.Len(t, c.calls, 2)
+}
@@ -0,0 +1,119 @@
+package util
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// SetOpts are the options which can be passed into Set. All fields are
+// optional, see http://redis.io/commands/set for more on each.
+type SetOpts struct {
+	// At most one of TTL (PX), ExpireAt (PXAT, redis 6.2 and later) and
+	// KeepTTL (KEEPTTL, redis 6.0 and later) may be set
+	TTL      time.Duration
+	ExpireAt time.Time
+	KeepTTL  bool
+
+	// At most one of NX and XX may be set
+	NX, XX bool
+
+	// Return the key's previous value (GET, redis 6.2 and later, or 7.0 and
+	// later along with NX)
+	Get bool
+}
+
+func (o SetOpts) args() ([]interface{}, error) {
+	var args []interface{}
+
+	var expiries int
+	if o.TTL != 0 {
+		expiries++
+		if o.TTL < time.Millisecond {
+			return nil, errors.New("TTL must be at least one millisecond")
+		}
+		args = append(args, "PX", durationMS(o.TTL))
+	}
+	if !o.ExpireAt.IsZero() {
+		expiries++
+		args = append(args, "PXAT", o.ExpireAt.UnixNano()/int64(time.Millisecond))
+	}
+	if o.KeepTTL {
+		expiries++
+		args = append(args, "KEEPTTL")
+	}
+	if expiries > 1 {
+		return nil, errors.New("only one of TTL, ExpireAt and KeepTTL may be set")
+	}
+
+	if o.NX && o.XX {
+		return nil, errors.New("only one of NX and XX may be set")
+	} else if o.NX {
+		args = append(args, "NX")
+	} else if o.XX {
+		args = append(args, "XX")
+	}
+
+	if o.Get {
+		args = append(args, "GET")
+	}
+	return args, nil
+}
+
+// SetResult describes the outcome of a call to Set
+type SetResult struct {
+	// Whether or not the value was set. This may only be false if NX or XX
+	// were given.
+	Set bool
+
+	// The previous value of the key, and whether there was one. These are
+	// only filled in if Get was given.
+	Prev       string
+	PrevExists bool
+}
+
+// Set calls SET with the given key, value and options. The options are checked
+// for conflicts before anything is sent.
+//
+//	res, err := util.Set(c, "foo", "bar", util.SetOpts{TTL: time.Minute, NX: true})
+//	if err != nil {
+//		return err
+//	} else if !res.Set {
+//		// foo already existed
+//	}
+func Set(c Cmder, key string, value interface{}, o SetOpts) (SetResult, error) {
+	args, err := o.args()
+	if err != nil {
+		return SetResult{}, err
+	}
+
+	r := c.Cmd("SET", key, value, args)
+	if r.Err != nil {
+		return SetResult{}, r.Err
+	}
+
+	if !o.Get {
+		// SET replies with nil when NX or XX prevented it from setting
+		return SetResult{Set: !r.IsType(redis.Nil)}, nil
+	}
+
+	// With GET the reply is always the previous value, so whether the value
+	// was set has to be worked out from whether there was one
+	var res SetResult
+	if !r.IsType(redis.Nil) {
+		if res.Prev, err = r.Str(); err != nil {
+			return SetResult{}, err
+		}
+		res.PrevExists = true
+	}
+	switch {
+	case o.NX:
+		res.Set = !res.PrevExists
+	case o.XX:
+		res.Set = res.PrevExists
+	default:
+		res.Set = true
+	}
+	return res, nil
+}
@@ -0,0 +1,86 @@
+package util
+
+import (
+	. "testing"
+	"time"
+
+	"github.com/levenlabs/golib/testutil"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetOptsArgs(t *T) {
+	args, err := SetOpts{TTL: 2 * time.Second, NX: true, Get: true}.args()
+	require.Nil(t, err)
+	assert.Equal(t, []interface{}{"PX", int64(2000), "NX", "GET"}, args)
+
+	args, err = SetOpts{ExpireAt: time.Unix(100, 0), XX: true}.args()
+	require.Nil(t, err)
+	assert.Equal(t, []interface{}{"PXAT", int64(100000), "XX"}, args)
+
+	args, err = SetOpts{KeepTTL: true}.args()
+	require.Nil(t, err)
+	assert.Equal(t, []interface{}{"KEEPTTL"}, args)
+
+	for _, o := range []SetOpts{
+		{NX: true, XX: true},
+		{TTL: time.Second, KeepTTL: true},
+		{TTL: time.Second, ExpireAt: time.Now()},
+		{TTL: time.Microsecond},
+	} {
+		_, err := o.args()
+		assert.NotNil(t, err, "o:%#v", o)
+	}
+}
+
+func TestSetScripted(t *T) {
+	ok := redis.NewRespSimple("OK")
+	nilResp := redis.NewResp(nil)
+	for _, test := range []struct {
+		o        SetOpts
+		reply    *redis.Resp
+		expected SetResult
+	}{
+		{SetOpts{}, ok, SetResult{Set: true}},
+		{SetOpts{NX: true}, ok, SetResult{Set: true}},
+		{SetOpts{NX: true}, nilResp, SetResult{}},
+		{SetOpts{XX: true}, nilResp, SetResult{}},
+		{SetOpts{Get: true}, nilResp, SetResult{Set: true}},
+		{SetOpts{Get: true}, redis.NewResp("old"), SetResult{Set: true, Prev: "old", PrevExists: true}},
+		{SetOpts{Get: true, NX: true}, nilResp, SetResult{Set: true}},
+		{SetOpts{Get: true, NX: true}, redis.NewResp("old"), SetResult{Prev: "old", PrevExists: true}},
+		{SetOpts{Get: true, XX: true}, nilResp, SetResult{}},
+		{SetOpts{Get: true, XX: true}, redis.NewResp("old"), SetResult{Set: true, Prev: "old", PrevExists: true}},
+	} {
+		c := &scriptedCmder{replies: []*redis.Resp{test.reply}}
+		res, err := Set(c, "foo", "bar", test.o)
+		require.Nil(t, err)
+		assert.Equal(t, test.expected, res, "o:%#v", test.o)
+	}
+}
+
+func TestSet(t *T) {
+	c, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+	key := testutil.RandStr()
+
+	res, err := Set(c, key, "a", SetOpts{XX: true})
+	require.Nil(t, err)
+	assert.False(t, res.Set)
+
+	res, err = Set(c, key, "a", SetOpts{NX: true, TTL: time.Minute})
+	require.Nil(t, err)
+	assert.True(t, res.Set)
+
+	res, err = Set(c, key, "b", SetOpts{NX: true})
+	require.Nil(t, err)
+	assert.False(t, res.Set)
+
+	res, err = Set(c, key, "b", SetOpts{Get: true, KeepTTL: true})
+	require.Nil(t, err)
+	assert.Equal(t, SetResult{Set: true, Prev: "a", PrevExists: true}, res)
+	ttl, err := c.Cmd("PTTL", key).Int()
+	require.Nil(t, err)
+	assert.True(t, ttl > 0)
+}
@@ -0,0 +1,108 @@
+package util
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/cluster"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// SlowlogEntry is a single entry from the slow log, as returned by SLOWLOG GET
+type SlowlogEntry struct {
+	ID       int64
+	Time     time.Time
+	Duration time.Duration
+	Args     []string
+
+	// These are only given by redis 4.0 and later
+	ClientAddr, ClientName string
+
+	// The address of the instance the entry was retrieved from. This is only
+	// set by ClusterSlowlogGet.
+	Addr string
+}
+
+// SlowlogGet returns up to n of the most recent entries in the slow log of the
+// instance the Cmder is connected to, newest first. If n is negative all
+// entries are returned.
+func SlowlogGet(c Cmder, n int) ([]SlowlogEntry, error) {
+	return parseSlowlog(c.Cmd("SLOWLOG", "GET", n))
+}
+
+// ClusterSlowlogGet calls SlowlogGet on every instance in the cluster, and
+// returns all of the entries together, newest first. The Addr field is set on
+// each entry.
+func ClusterSlowlogGet(c *cluster.Cluster, n int) ([]SlowlogEntry, error) {
+	var entries []SlowlogEntry
+	err := withEveryClient(c, func(addr string, client Cmder) error {
+		clientEntries, err := SlowlogGet(client, n)
+		if err != nil {
+			return err
+		}
+		for i := range clientEntries {
+			clientEntries[i].Addr = addr
+		}
+		entries = append(entries, clientEntries...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Stable(slowlogByTime(entries))
+	return entries, nil
+}
+
+type slowlogByTime []SlowlogEntry
+
+func (s slowlogByTime) Len() int           { return len(s) }
+func (s slowlogByTime) Less(i, j int) bool { return s[i].Time.After(s[j].Time) }
+func (s slowlogByTime) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+var errBadSlowlogEntry = errors.New("malformed slowlog entry")
+
+func parseSlowlog(r *redis.Resp) ([]SlowlogEntry, error) {
+	arr, err := r.Array()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]SlowlogEntry, len(arr))
+	for i, er := range arr {
+		parts, err := er.Array()
+		if err != nil {
+			return nil, err
+		} else if len(parts) < 4 {
+			return nil, errBadSlowlogEntry
+		}
+
+		e := &entries[i]
+		if e.ID, err = parts[0].Int64(); err != nil {
+			return nil, err
+		}
+		ts, err := parts[1].Int64()
+		if err != nil {
+			return nil, err
+		}
+		e.Time = time.Unix(ts, 0)
+		micros, err := parts[2].Int64()
+		if err != nil {
+			return nil, err
+		}
+		e.Duration = time.Duration(micros) * time.Microsecond
+		if e.Args, err = parts[3].List(); err != nil {
+			return nil, err
+		}
+
+		if len(parts) >= 6 {
+			if e.ClientAddr, err = parts[4].Str(); err != nil {
+				return nil, err
+			}
+			if e.ClientName, err = parts[5].Str(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return entries, nil
+}
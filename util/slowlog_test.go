@@ -0,0 +1,87 @@
+package util
+
+import (
+	. "testing"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/cluster"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSlowlog(t *T) {
+	r := redis.NewResp([]interface{}{
+		[]interface{}{6, 1500000000, 20000, []string{"KEYS", "*"}, "127.0.0.1:5000", "worker"},
+		[]interface{}{5, 1400000000, 15, []string{"GET", "foo"}},
+	})
+	entries, err := parseSlowlog(r)
+	require.Nil(t, err)
+	assert.Equal(t, []SlowlogEntry{
+		{
+			ID:         6,
+			Time:       time.Unix(1500000000, 0),
+			Duration:   20 * time.Millisecond,
+			Args:       []string{"KEYS", "*"},
+			ClientAddr: "127.0.0.1:5000",
+			ClientName: "worker",
+		},
+		{
+			ID:       5,
+			Time:     time.Unix(1400000000, 0),
+			Duration: 15 * time.Microsecond,
+			Args:     []string{"GET", "foo"},
+		},
+	}, entries)
+
+	_, err = parseSlowlog(redis.NewResp([]interface{}{[]interface{}{1, 2}}))
+	assert.Equal(t, errBadSlowlogEntry, err)
+}
+
+func testSlowlog(t *T, c Cmder) {
+	require.Nil(t, c.Cmd("CONFIG", "SET", "slowlog-log-slower-than", 0).Err)
+	defer c.Cmd("CONFIG", "SET", "slowlog-log-slower-than", 10000)
+	require.Nil(t, c.Cmd("SLOWLOG", "RESET").Err)
+	require.Nil(t, c.Cmd("ECHO", "slow").Err)
+}
+
+func TestSlowlogGet(t *T) {
+	c, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+	testSlowlog(t, c)
+
+	entries, err := SlowlogGet(c, 10)
+	require.Nil(t, err)
+	require.NotEmpty(t, entries)
+
+	var found bool
+	for _, e := range entries {
+		if len(e.Args) == 2 && e.Args[0] == "ECHO" && e.Args[1] == "slow" {
+			found = true
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestClusterSlowlogGet(t *T) {
+	c, err := cluster.New("127.0.0.1:7000")
+	require.Nil(t, err)
+
+	clients, err := c.GetEvery()
+	require.Nil(t, err)
+	for _, client := range clients {
+		testSlowlog(t, client)
+		c.Put(client)
+	}
+
+	entries, err := ClusterSlowlogGet(c, 10)
+	require.Nil(t, err)
+	addrs := map[string]bool{}
+	for i, e := range entries {
+		addrs[e.Addr] = true
+		if i > 0 {
+			assert.False(t, e.Time.After(entries[i-1].Time))
+		}
+	}
+	assert.Len(t, addrs, len(clients))
+}
@@ -0,0 +1,112 @@
+package util
+
+import (
+	"github.com/mediocregopher/radix.v2/pubsub"
+)
+
+// SubscribeAndSnapshot is for keeping a local copy of some state in redis up to
+// date, where every change to that state is followed by a PUBLISH on the given
+// channel. It subscribes to the channel, and once redis has confirmed the
+// subscription it calls snapshot with the given Cmder so the current state can
+// be read. Messages which arrive while snapshot is running are buffered, and
+// once it returns they're written to the returned channel, followed by live
+// messages as they arrive.
+//
+// Since the subscription is confirmed before snapshot is called, no message
+// published after the snapshot's reads is missed. However a message which was
+// published in between the subscription being confirmed and the snapshot's
+// reads will be both reflected in the snapshot and written to the returned
+// channel, so applying messages must be idempotent, or they must carry enough
+// information (e.g. a version) to be recognised as already applied. For this to
+// work the state must always be changed before the message about it is
+// published, never after.
+//
+// Only Message SubResps are written to the returned channel, timeouts are
+// ignored. If the connection fails a final SubResp with Type Error is written,
+// and the channel is closed. Closing sub.Client is how delivery is stopped,
+// and the channel should be read until it's closed. As with any pubsub
+// subscription messages are lost if the connection fails, so when that happens
+// the whole thing should be done again on a new connection.
+//
+// sub is used by a go-routine of its own from the moment this is called, and
+// mustn't be used for anything else. If snapshot returns an error sub.Client is
+// closed and that error is returned.
+func SubscribeAndSnapshot(
+	sub *pubsub.SubClient, c Cmder, channel string, snapshot func(Cmder) error,
+) (
+	<-chan *pubsub.SubResp, error,
+) {
+	if sr := sub.Subscribe(channel); sr.Err != nil {
+		return nil, sr.Err
+	}
+
+	in := make(chan *pubsub.SubResp)
+	go func() {
+		defer close(in)
+		for {
+			sr := sub.Receive()
+			if sr.Type == pubsub.Message {
+				in <- sr
+			} else if sr.Type == pubsub.Error && !sr.Timeout() {
+				in <- sr
+				return
+			}
+		}
+	}()
+
+	// Until snapshot returns everything from in is buffered. After that the
+	// buffer is drained, and in is only read from while the buffer is empty,
+	// so that a slow reader holds up reading from redis rather than the buffer
+	// growing forever.
+	out := make(chan *pubsub.SubResp)
+	snapDone := make(chan struct{})
+	abortCh := make(chan struct{})
+	abortDone := make(chan struct{})
+	go func() {
+		var buf []*pubsub.SubResp
+		var snapshotted bool
+		ch := in
+		for {
+			inCh := ch
+			var outCh chan *pubsub.SubResp
+			var next *pubsub.SubResp
+			if snapshotted && len(buf) > 0 {
+				inCh, outCh, next = nil, out, buf[0]
+			} else if snapshotted && ch == nil {
+				close(out)
+				return
+			}
+
+			select {
+			case sr, ok := <-inCh:
+				if !ok {
+					ch = nil
+					continue
+				}
+				buf = append(buf, sr)
+			case outCh <- next:
+				buf[0] = nil
+				buf = buf[1:]
+			case <-snapDone:
+				snapshotted = true
+				snapDone = nil
+			case <-abortCh:
+				// in is closed once the go-routine reading from sub sees the
+				// connection was closed
+				for range in {
+				}
+				close(abortDone)
+				return
+			}
+		}
+	}()
+
+	if err := snapshot(c); err != nil {
+		sub.Client.Close()
+		close(abortCh)
+		<-abortDone
+		return nil, err
+	}
+	close(snapDone)
+	return out, nil
+}
@@ -0,0 +1,109 @@
+package util
+
+import (
+	"errors"
+	"strconv"
+	. "testing"
+	"time"
+
+	"github.com/levenlabs/golib/testutil"
+	"github.com/mediocregopher/radix.v2/pubsub"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribeAndSnapshot(t *T) {
+	c, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+	defer c.Close()
+	subC, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+	sub := pubsub.NewSubClient(subC)
+
+	key, channel := testutil.RandStr(), testutil.RandStr()
+
+	// The publisher increments the key and then publishes its new value as
+	// fast as it can, until it's told to stop
+	pub, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+	defer pub.Close()
+	stopCh, lastCh := make(chan struct{}), make(chan int)
+	go func() {
+		var n int
+		for {
+			select {
+			case <-stopCh:
+				lastCh <- n
+				return
+			default:
+			}
+			var err error
+			if n, err = pub.Cmd("INCR", key).Int(); err != nil {
+				panic(err)
+			}
+			pub.Cmd("PUBLISH", channel, n)
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	var snap int
+	ch, err := SubscribeAndSnapshot(sub, c, channel, func(c Cmder) error {
+		// give the publisher time to publish plenty while the snapshot is
+		// being taken
+		time.Sleep(50 * time.Millisecond)
+		var err error
+		snap, err = c.Cmd("GET", key).Int()
+		time.Sleep(50 * time.Millisecond)
+		return err
+	})
+	require.Nil(t, err)
+	assert.True(t, snap > 0)
+
+	time.Sleep(50 * time.Millisecond)
+	close(stopCh)
+	last := <-lastCh
+	assert.True(t, last > snap)
+
+	// Every value after the snapshot must be seen, in order. Values up to it
+	// may be seen as well.
+	expect := snap + 1
+	for expect <= last {
+		sr := <-ch
+		require.Equal(t, pubsub.Message, sr.Type, "%v", sr.Err)
+		n, err := strconv.Atoi(sr.Message)
+		require.Nil(t, err)
+		if n < expect {
+			continue
+		}
+		require.Equal(t, expect, n)
+		expect++
+	}
+
+	subC.Close()
+	sr, ok := <-ch
+	require.True(t, ok)
+	assert.Equal(t, pubsub.Error, sr.Type)
+	assert.NotNil(t, sr.Err)
+	_, ok = <-ch
+	assert.False(t, ok)
+}
+
+func TestSubscribeAndSnapshotErr(t *T) {
+	c, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+	defer c.Close()
+	subC, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+	sub := pubsub.NewSubClient(subC)
+
+	snapErr := errors.New("snapshot failed")
+	ch, err := SubscribeAndSnapshot(sub, c, testutil.RandStr(), func(Cmder) error {
+		return snapErr
+	})
+	assert.Equal(t, snapErr, err)
+	assert.Nil(t, ch)
+
+	// the SubClient's connection is closed
+	assert.NotNil(t, subC.Cmd("PING").Err)
+}
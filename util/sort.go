@@ -0,0 +1,177 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mediocregopher/radix.v2/cluster"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// SortLimit is the LIMIT option of SortOpts
+type SortLimit struct {
+	Offset, Count int
+}
+
+// SortOpts are the options which can be passed into Sort. See
+// http://redis.io/commands/sort for more on each. All fields are optional.
+type SortOpts struct {
+	// A pattern, e.g. "weight_*" or "object_*->weight", whose values are
+	// sorted by instead of the elements themselves. "nosort" skips sorting
+	// altogether.
+	By string
+
+	// Patterns for the values to return for each element instead of the
+	// element itself. "#" returns the element.
+	Get []string
+
+	// If set only this part of the sorted elements is returned
+	Limit *SortLimit
+
+	// Either "ASC" or "DESC". Defaults to "ASC".
+	Order string
+
+	// If set elements are sorted lexicographically rather than as numbers
+	Alpha bool
+
+	// If set the result is stored as a list at this key, instead of being
+	// returned
+	Store string
+}
+
+func (o SortOpts) args(key string) ([]interface{}, error) {
+	args := []interface{}{key}
+	if o.By != "" {
+		args = append(args, "BY", o.By)
+	}
+	if o.Limit != nil {
+		if o.Limit.Offset < 0 || o.Limit.Count < 0 {
+			return nil, errors.New("Limit's Offset and Count may not be negative")
+		}
+		args = append(args, "LIMIT", o.Limit.Offset, o.Limit.Count)
+	}
+	for _, get := range o.Get {
+		if get == "" {
+			return nil, errors.New("Get pattern may not be empty")
+		}
+		args = append(args, "GET", get)
+	}
+
+	switch strings.ToUpper(o.Order) {
+	case "", "ASC":
+	case "DESC":
+		args = append(args, "DESC")
+	default:
+		return nil, fmt.Errorf("unknown Order %q", o.Order)
+	}
+
+	if strings.EqualFold(o.By, "nosort") && (o.Alpha || o.Order != "") {
+		return nil, errors.New("Alpha and Order can't be used when By is nosort")
+	}
+	if o.Alpha {
+		args = append(args, "ALPHA")
+	}
+	if o.Store != "" {
+		args = append(args, "STORE", o.Store)
+	}
+	return args, nil
+}
+
+// patternSlot returns the slot which a BY or GET pattern's keys will be in,
+// or false if that depends on the element, i.e. the hashed part of the key has
+// a * in it. The hash field part of a pattern, after ->, doesn't affect the
+// key.
+func patternSlot(pattern string) (uint16, bool) {
+	if i := strings.Index(pattern, "->"); i >= 0 {
+		pattern = pattern[:i]
+	}
+	if strings.Contains(cluster.HashTag(pattern), "*") {
+		return 0, false
+	}
+	return cluster.Slot(pattern), true
+}
+
+// checkSortSlots returns an error if any of the keys the SORT will touch are
+// in a different slot to key
+func (o SortOpts) checkSortSlots(key string) error {
+	slot := cluster.Slot(key)
+	patterns := o.Get
+	if o.By != "" && !strings.EqualFold(o.By, "nosort") {
+		patterns = append([]string{o.By}, patterns...)
+	}
+	for _, p := range patterns {
+		if p == "#" {
+			continue
+		} else if pSlot, ok := patternSlot(p); !ok {
+			return fmt.Errorf("pattern %q doesn't have a hash tag, so its keys may be in other slots", p)
+		} else if pSlot != slot {
+			return fmt.Errorf("pattern %q's keys are in a different slot than %q", p, key)
+		}
+	}
+	if o.Store != "" && cluster.Slot(o.Store) != slot {
+		return fmt.Errorf("Store key %q is in a different slot than %q", o.Store, key)
+	}
+	return nil
+}
+
+// Sort calls SORT on the list, set or sorted set at key. The result has a row
+// for each element, holding the values for each of the Get patterns in order,
+// or just the element if there are none. Values for GET patterns which don't
+// exist are returned as empty strings.
+//
+// If Store is set the rows are stored instead, flattened into a single list,
+// and the returned int is the length of that list. Otherwise the int is the
+// number of rows.
+//
+// If the Cmder is a Cluster then every key touched by the BY and GET patterns,
+// and Store, must be in the same slot as key. Since each element's keys are
+// made by replacing the * in the pattern this means each pattern must have a
+// hash tag without a * in it, e.g. "{user}:weight_*". An error is returned
+// before anything is sent if this isn't so.
+func Sort(c Cmder, key string, o SortOpts) ([][]string, int, error) {
+	args, err := o.args(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, ok := c.(*cluster.Cluster); ok {
+		if err := o.checkSortSlots(key); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	r := c.Cmd("SORT", args...)
+	if o.Store != "" {
+		n, err := r.Int()
+		return nil, n, err
+	}
+	rows, err := decodeSort(r, len(o.Get))
+	return rows, len(rows), err
+}
+
+func decodeSort(r *redis.Resp, gets int) ([][]string, error) {
+	arr, err := r.Array()
+	if err != nil {
+		return nil, err
+	}
+	if gets == 0 {
+		gets = 1
+	}
+	if len(arr)%gets != 0 {
+		return nil, fmt.Errorf("SORT returned %d values, which isn't a multiple of %d", len(arr), gets)
+	}
+
+	rows := make([][]string, 0, len(arr)/gets)
+	for i := 0; i < len(arr); i += gets {
+		row := make([]string, gets)
+		for j := range row {
+			if arr[i+j].IsType(redis.Nil) {
+				continue
+			} else if row[j], err = arr[i+j].Str(); err != nil {
+				return nil, err
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
@@ -0,0 +1,103 @@
+package util
+
+import (
+	"strconv"
+	. "testing"
+
+	"github.com/levenlabs/golib/testutil"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSortOptsArgs(t *T) {
+	args, err := SortOpts{
+		By:    "w_*",
+		Get:   []string{"#", "h_*->name"},
+		Limit: &SortLimit{Offset: 1, Count: 2},
+		Order: "desc",
+		Alpha: true,
+		Store: "dst",
+	}.args("k")
+	require.Nil(t, err)
+	assert.Equal(t, []interface{}{
+		"k", "BY", "w_*", "LIMIT", 1, 2, "GET", "#", "GET", "h_*->name",
+		"DESC", "ALPHA", "STORE", "dst",
+	}, args)
+
+	for _, o := range []SortOpts{
+		{Order: "sideways"},
+		{Limit: &SortLimit{Offset: -1, Count: 2}},
+		{Get: []string{""}},
+		{By: "nosort", Alpha: true},
+		{By: "NOSORT", Order: "DESC"},
+	} {
+		_, err := o.args("k")
+		assert.NotNil(t, err, "opts: %#v", o)
+	}
+}
+
+func TestSortSlots(t *T) {
+	assert.Nil(t, SortOpts{
+		By:    "{user}:w_*",
+		Get:   []string{"#", "{user}:h_*->name"},
+		Store: "{user}:dst",
+	}.checkSortSlots("{user}:ids"))
+	assert.Nil(t, SortOpts{By: "nosort"}.checkSortSlots("ids"))
+
+	for _, o := range []SortOpts{
+		{By: "w_*"},
+		{By: "{*}:w"},
+		{Get: []string{"{other}:h_*"}},
+		{Store: "dst"},
+	} {
+		assert.NotNil(t, o.checkSortSlots("{user}:ids"), "opts: %#v", o)
+	}
+}
+
+func TestSortScripted(t *T) {
+	sc := &scriptedCmder{replies: []*redis.Resp{
+		redis.NewResp([]interface{}{"1", "a", "2", nil}),
+		redis.NewResp([]interface{}{"1", "a", "2"}),
+		redis.NewResp(4),
+	}}
+
+	rows, n, err := Sort(sc, "k", SortOpts{Get: []string{"#", "n_*"}})
+	require.Nil(t, err)
+	assert.Equal(t, [][]string{{"1", "a"}, {"2", ""}}, rows)
+	assert.Equal(t, 2, n)
+
+	_, _, err = Sort(sc, "k", SortOpts{Get: []string{"#", "n_*"}})
+	assert.NotNil(t, err)
+
+	rows, n, err = Sort(sc, "k", SortOpts{Get: []string{"#", "n_*"}, Store: "dst"})
+	require.Nil(t, err)
+	assert.Nil(t, rows)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, []interface{}{"SORT", "k", "GET", "#", "GET", "n_*", "STORE", "dst"}, sc.calls[2])
+}
+
+func TestSort(t *T) {
+	c, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+	prefix := testutil.RandStr()
+	key := prefix + ":ids"
+	for i, name := range []string{"c", "a", "b"} {
+		id := strconv.Itoa(i + 1)
+		require.Nil(t, c.Cmd("RPUSH", key, id).Err)
+		require.Nil(t, c.Cmd("HSET", prefix+":h_"+id, "name", name).Err)
+	}
+
+	rows, n, err := Sort(c, key, SortOpts{
+		By:    prefix + ":h_*->name",
+		Get:   []string{"#", prefix + ":h_*->name"},
+		Alpha: true,
+	})
+	require.Nil(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, [][]string{{"2", "a"}, {"3", "b"}, {"1", "c"}}, rows)
+
+	rows, _, err = Sort(c, key, SortOpts{Order: "DESC", Limit: &SortLimit{Offset: 0, Count: 2}})
+	require.Nil(t, err)
+	assert.Equal(t, [][]string{{"3"}, {"2"}}, rows)
+}
@@ -0,0 +1,233 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// StreamID is the parsed form of the ID of an entry in a redis stream, which
+// is made up of a millisecond timestamp and a sequence number.
+type StreamID struct {
+	Time, Seq uint64
+}
+
+// ParseStreamID parses a stream entry ID of the form "<ms>-<seq>". An ID
+// without a sequence number (e.g. "1518951480106") is taken to have a
+// sequence number of zero, as redis does.
+func ParseStreamID(s string) (StreamID, error) {
+	var id StreamID
+	timeStr, seqStr := s, ""
+	i := strings.IndexByte(s, '-')
+	if i >= 0 {
+		timeStr, seqStr = s[:i], s[i+1:]
+	}
+
+	var err error
+	if id.Time, err = strconv.ParseUint(timeStr, 10, 64); err != nil {
+		return StreamID{}, fmt.Errorf("invalid stream id %q", s)
+	}
+	if i >= 0 {
+		if id.Seq, err = strconv.ParseUint(seqStr, 10, 64); err != nil {
+			return StreamID{}, fmt.Errorf("invalid stream id %q", s)
+		}
+	}
+	return id, nil
+}
+
+// String returns the StreamID in the form "<ms>-<seq>"
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Time, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Compare returns -1 if id comes before id2 in a stream, 1 if it comes after,
+// and 0 if they are the same
+func (id StreamID) Compare(id2 StreamID) int {
+	switch {
+	case id.Time < id2.Time:
+		return -1
+	case id.Time > id2.Time:
+		return 1
+	case id.Seq < id2.Seq:
+		return -1
+	case id.Seq > id2.Seq:
+		return 1
+	}
+	return 0
+}
+
+// Before returns true if id comes before id2 in a stream
+func (id StreamID) Before(id2 StreamID) bool {
+	return id.Compare(id2) < 0
+}
+
+// Next returns the smallest StreamID which comes after id. This is useful
+// for commands like XRANGE, whose ranges are inclusive.
+func (id StreamID) Next() StreamID {
+	if id.Seq == ^uint64(0) {
+		return StreamID{Time: id.Time + 1}
+	}
+	return StreamID{Time: id.Time, Seq: id.Seq + 1}
+}
+
+// StreamEntry is a single entry in a redis stream
+type StreamEntry struct {
+	// The stream the entry was read from. This is only filled in when it's
+	// known, e.g. by DecodeStreams.
+	Stream string
+
+	ID string
+
+	// Fields will be nil if the entry was deleted, which XREADGROUP indicates
+	// when reading from a consumer's pending entries
+	Fields map[string]string
+
+	// Reclaimed is set by StreamReader on entries which it claimed from
+	// another consumer, rather than reading them itself
+	Reclaimed bool
+}
+
+var errBadStreamEntry = errors.New("malformed stream entry")
+
+// DecodeStreamEntries decodes the response of an XRANGE or XREVRANGE command
+// (or anything else which returns a list of stream entries) into a list of
+// StreamEntrys.
+func DecodeStreamEntries(r *redis.Resp) ([]StreamEntry, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	arr, err := r.Array()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]StreamEntry, len(arr))
+	for i, er := range arr {
+		if entries[i], err = decodeStreamEntry(er); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+func decodeStreamEntry(r *redis.Resp) (StreamEntry, error) {
+	parts, err := r.Array()
+	if err != nil {
+		return StreamEntry{}, err
+	} else if len(parts) != 2 {
+		return StreamEntry{}, errBadStreamEntry
+	}
+
+	var e StreamEntry
+	if e.ID, err = parts[0].Str(); err != nil {
+		return StreamEntry{}, err
+	}
+	if parts[1].IsType(redis.Nil) {
+		return e, nil
+	}
+
+	fields, err := parts[1].List()
+	if err != nil {
+		return StreamEntry{}, err
+	} else if len(fields)%2 != 0 {
+		return StreamEntry{}, errBadStreamEntry
+	}
+	e.Fields = make(map[string]string, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		e.Fields[fields[i]] = fields[i+1]
+	}
+	return e, nil
+}
+
+// DecodeStreams decodes the response of an XREAD or XREADGROUP command into a
+// map of stream name to the entries read from that stream. A nil response,
+// which is given when a blocking read times out, results in an empty map.
+func DecodeStreams(r *redis.Resp) (map[string][]StreamEntry, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+
+	m := map[string][]StreamEntry{}
+	if r.IsType(redis.Nil) {
+		return m, nil
+	}
+
+	streams, err := r.Array()
+	if err != nil {
+		return nil, err
+	}
+	for _, sr := range streams {
+		parts, err := sr.Array()
+		if err != nil {
+			return nil, err
+		} else if len(parts) != 2 {
+			return nil, errors.New("malformed stream")
+		}
+		name, err := parts[0].Str()
+		if err != nil {
+			return nil, err
+		}
+		entries, err := DecodeStreamEntries(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		for i := range entries {
+			entries[i].Stream = name
+		}
+		m[name] = entries
+	}
+	return m, nil
+}
+
+// XAdd calls XADD on the given stream with the given ID (usually "*", to have
+// redis generate one), and returns the ID of the new entry.
+//
+// fields may be a map, in which case each key/value pair becomes a field, or a
+// struct (or pointer to one), in which case each exported field becomes a
+// field. The name of a struct's field may be changed using a `redis:"name"`
+// tag, and a field with a tag of "-" is skipped.
+func XAdd(c Cmder, stream, id string, fields interface{}) (string, error) {
+	args, err := fieldArgs(fields)
+	if err != nil {
+		return "", err
+	}
+	return c.Cmd("XADD", stream, id, args).Str()
+}
+
+// fieldArgs returns the given value as a list of field/value pairs, if it's a
+// struct. Anything else is returned as-is, and left to be flattened by the
+// redis package.
+func fieldArgs(v interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, errors.New("nil fields given")
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return v, nil
+	}
+
+	rt := rv.Type()
+	args := make([]interface{}, 0, rt.NumField()*2)
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		// skip unexported fields
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Tag.Get("redis")
+		if name == "-" {
+			continue
+		} else if name == "" {
+			name = f.Name
+		}
+		args = append(args, name, rv.Field(i).Interface())
+	}
+	return args, nil
+}
@@ -0,0 +1,116 @@
+package util
+
+import (
+	. "testing"
+
+	"github.com/levenlabs/golib/testutil"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStreamID(t *T) {
+	id, err := ParseStreamID("1518951480106-7")
+	require.Nil(t, err)
+	assert.Equal(t, StreamID{Time: 1518951480106, Seq: 7}, id)
+	assert.Equal(t, "1518951480106-7", id.String())
+
+	id, err = ParseStreamID("5")
+	require.Nil(t, err)
+	assert.Equal(t, StreamID{Time: 5}, id)
+
+	for _, s := range []string{"", "-", "a-1", "1-a", "1-"} {
+		_, err := ParseStreamID(s)
+		assert.NotNil(t, err, "s:%q", s)
+	}
+}
+
+func TestStreamIDCompare(t *T) {
+	a := StreamID{Time: 1, Seq: 5}
+	b := StreamID{Time: 2, Seq: 0}
+	assert.Equal(t, -1, a.Compare(b))
+	assert.Equal(t, 1, b.Compare(a))
+	assert.Equal(t, 0, a.Compare(a))
+	assert.True(t, a.Before(a.Next()))
+	assert.False(t, a.Before(a))
+	assert.Equal(t, StreamID{Time: 2}, StreamID{Time: 1, Seq: ^uint64(0)}.Next())
+}
+
+func TestDecodeStreams(t *T) {
+	r := redis.NewResp([]interface{}{
+		[]interface{}{"s1", []interface{}{
+			[]interface{}{"1-0", []string{"a", "1", "b", "2"}},
+			[]interface{}{"1-1", nil},
+		}},
+		[]interface{}{"s2", []interface{}{
+			[]interface{}{"2-0", []string{"c", "3"}},
+		}},
+	})
+	m, err := DecodeStreams(r)
+	require.Nil(t, err)
+	assert.Equal(t, map[string][]StreamEntry{
+		"s1": {
+			{Stream: "s1", ID: "1-0", Fields: map[string]string{"a": "1", "b": "2"}},
+			{Stream: "s1", ID: "1-1"},
+		},
+		"s2": {
+			{Stream: "s2", ID: "2-0", Fields: map[string]string{"c": "3"}},
+		},
+	}, m)
+
+	m, err = DecodeStreams(redis.NewResp(nil))
+	require.Nil(t, err)
+	assert.Empty(t, m)
+
+	_, err = DecodeStreamEntries(redis.NewResp([]interface{}{
+		[]interface{}{"1-0", []string{"a"}},
+	}))
+	assert.NotNil(t, err)
+}
+
+func TestFieldArgs(t *T) {
+	type foo struct {
+		A      string
+		B      int `redis:"bee"`
+		C      int `redis:"-"`
+		hidden int
+	}
+	args, err := fieldArgs(&foo{A: "a", B: 2, C: 3})
+	require.Nil(t, err)
+	assert.Equal(t, []interface{}{"A", "a", "bee", 2}, args)
+
+	m := map[string]string{"a": "b"}
+	args, err = fieldArgs(m)
+	require.Nil(t, err)
+	assert.Equal(t, m, args)
+}
+
+func TestXAdd(t *T) {
+	c, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+	stream := testutil.RandStr()
+
+	id1, err := XAdd(c, stream, "*", map[string]string{"a": "1"})
+	require.Nil(t, err)
+	id2, err := XAdd(c, stream, "*", struct{ B string }{"2"})
+	require.Nil(t, err)
+
+	sid1, err := ParseStreamID(id1)
+	require.Nil(t, err)
+	sid2, err := ParseStreamID(id2)
+	require.Nil(t, err)
+	assert.True(t, sid1.Before(sid2))
+
+	entries, err := DecodeStreamEntries(c.Cmd("XRANGE", stream, "-", "+"))
+	require.Nil(t, err)
+	assert.Equal(t, []StreamEntry{
+		{ID: id1, Fields: map[string]string{"a": "1"}},
+		{ID: id2, Fields: map[string]string{"B": "2"}},
+	}, entries)
+
+	m, err := DecodeStreams(c.Cmd("XREAD", "STREAMS", stream, id1))
+	require.Nil(t, err)
+	assert.Equal(t, map[string][]StreamEntry{
+		stream: {{Stream: stream, ID: id2, Fields: map[string]string{"B": "2"}}},
+	}, m)
+}
@@ -0,0 +1,494 @@
+package util
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/cluster"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// ErrStreamReaderClosed is returned from Next once Close has been called on
+// the StreamReader
+var ErrStreamReaderClosed = errors.New("stream reader closed")
+
+// StreamReaderOpts are the options which can be passed into NewStreamReader.
+// Streams, Group and Consumer are required.
+type StreamReaderOpts struct {
+	// The streams to read from. If reading from a Cluster all streams must
+	// belong to the same slot.
+	Streams []string
+
+	// The consumer group to read as, and the name of the consumer within it.
+	// The group will be created on each stream if it doesn't exist yet.
+	Group, Consumer string
+
+	// The ID the group should start reading from, if it needs to be created.
+	// Defaults to "$", meaning only entries added after the group was
+	// created will be read.
+	StartID string
+
+	// How long XREADGROUP should block for when there are no new entries. If
+	// zero Next will not block. This must be less than any read timeout set
+	// on the connection being used.
+	Block time.Duration
+
+	// The maximum number of entries to read per stream on each call to Next.
+	// If zero there's no limit.
+	Count int
+
+	// If set, entries which are pending for any consumer in the group and
+	// have been idle for at least this long are claimed by this consumer and
+	// returned from Next, with Reclaimed set. This allows entries which were
+	// read by a consumer which has since died to still be processed.
+	ClaimMinIdle time.Duration
+
+	// How often to check for idle entries to claim. Checks are done at the
+	// start of Next, so if Block is set they may happen less often than this.
+	// Defaults to ClaimMinIdle.
+	ClaimInterval time.Duration
+
+	// If set along with ClaimMinIdle, entries which would be claimed but have
+	// already been delivered MaxDeliveries times are instead acknowledged and
+	// passed to DeadLetter (if set), rather than being returned from Next.
+	// This prevents entries which cause their consumer to crash from being
+	// retried forever.
+	MaxDeliveries int
+	DeadLetter    func(StreamEntry)
+}
+
+// StreamReaderStats describes how many entries a StreamReader has reclaimed
+// from other consumers
+type StreamReaderStats struct {
+	// The number of entries which have been claimed and returned from Next
+	Claimed int64
+
+	// The number of entries which exceeded MaxDeliveries, and were
+	// acknowledged and passed to DeadLetter
+	DeadLettered int64
+}
+
+// StreamReader reads entries from one or more streams as a consumer in a
+// consumer group, using XREADGROUP. When first created it will read back the
+// consumer's pending entries (those which were read but never acknowledged)
+// before moving on to new entries, so that nothing is lost if a consumer
+// restarts.
+//
+// A StreamReader holds a connection of its own for its blocking reads, and so
+// should be closed when it's not needed anymore. Next and Close may be called
+// from different go-routines, but Next must not itself be called concurrently.
+type StreamReader struct {
+	c      Cmder
+	o      StreamReaderOpts
+	conn   streamConn
+	ids    map[string]string
+	closed chan struct{}
+
+	// put returns conn to the Pool or Cluster it was retrieved from, if any.
+	// nextL is held by Next, so that Close only does so once Next is done
+	// with it.
+	put   func(*redis.Client)
+	nextL sync.Mutex
+
+	closeOnce sync.Once
+
+	lastClaim   time.Time
+	noAutoClaim bool
+
+	// accessed atomically
+	claimed, deadLettered int64
+}
+
+// streamConn is the connection a StreamReader does its reads on, normally a
+// *redis.Client
+type streamConn interface {
+	Cmder
+	Close() error
+}
+
+// NewStreamReader returns a StreamReader for the given options, creating the
+// consumer group on each of the streams if it doesn't exist (creating the
+// streams too if needed).
+//
+// The Cmder may be a Client, Pool, Cluster or sentinel.Master. If it's a
+// Client the StreamReader will use that connection for its reads and close it
+// when the StreamReader is closed, otherwise a connection will be retrieved
+// from the Cmder.
+func NewStreamReader(c Cmder, o StreamReaderOpts) (*StreamReader, error) {
+	if len(o.Streams) == 0 || o.Group == "" || o.Consumer == "" {
+		return nil, errors.New("Streams, Group and Consumer are required")
+	}
+	if o.StartID == "" {
+		o.StartID = "$"
+	}
+	if o.ClaimInterval == 0 {
+		o.ClaimInterval = o.ClaimMinIdle
+	}
+
+	for _, stream := range o.Streams {
+		err := c.Cmd("XGROUP", "CREATE", stream, o.Group, o.StartID, "MKSTREAM").Err
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return nil, err
+		}
+	}
+
+	sr := &StreamReader{
+		c:      c,
+		o:      o,
+		ids:    make(map[string]string, len(o.Streams)),
+		closed: make(chan struct{}),
+	}
+	for _, stream := range o.Streams {
+		sr.ids[stream] = "0"
+	}
+
+	var err error
+	switch cc := c.(type) {
+	case *cluster.Cluster:
+		sr.conn, err = cc.GetForKey(o.Streams[0])
+		sr.put = cc.Put
+	case getPutter:
+		sr.conn, err = cc.Get()
+		sr.put = cc.Put
+	case *redis.Client:
+		sr.conn = cc
+	default:
+		err = errors.New("StreamReader needs a Client, Pool, Cluster, or sentinel.Master")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sr, nil
+}
+
+func (sr *StreamReader) isClosed() bool {
+	select {
+	case <-sr.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Next reads and returns the next set of entries. If the stream has no new
+// entries and Block is set then Next will block for up to that long, possibly
+// returning no entries.
+//
+// Entries which were pending and have since been deleted from the stream will
+// be returned with nil Fields, they should simply be acknowledged.
+func (sr *StreamReader) Next() ([]StreamEntry, error) {
+	sr.nextL.Lock()
+	defer sr.nextL.Unlock()
+	for {
+		if sr.isClosed() {
+			return nil, ErrStreamReaderClosed
+		}
+
+		if sr.o.ClaimMinIdle > 0 && time.Since(sr.lastClaim) >= sr.o.ClaimInterval {
+			entries, err := sr.claim()
+			if sr.isClosed() {
+				return nil, ErrStreamReaderClosed
+			} else if err != nil {
+				return nil, err
+			}
+			sr.lastClaim = time.Now()
+			if len(entries) > 0 {
+				return entries, nil
+			}
+		}
+
+		args := []interface{}{"GROUP", sr.o.Group, sr.o.Consumer}
+		if sr.o.Count > 0 {
+			args = append(args, "COUNT", sr.o.Count)
+		}
+		if sr.o.Block > 0 {
+			args = append(args, "BLOCK", durationMS(sr.o.Block))
+		}
+		args = append(args, "STREAMS", sr.o.Streams)
+		for _, stream := range sr.o.Streams {
+			args = append(args, sr.ids[stream])
+		}
+
+		r := sr.conn.Cmd("XREADGROUP", args...)
+		if sr.isClosed() {
+			return nil, ErrStreamReaderClosed
+		}
+		m, err := DecodeStreams(r)
+		if err != nil {
+			return nil, err
+		}
+
+		// While reading back pending entries the ID for a stream is the last
+		// one read, once there are no more it switches to reading new ones
+		var entries []StreamEntry
+		var switched bool
+		for _, stream := range sr.o.Streams {
+			streamEntries := m[stream]
+			if id := sr.ids[stream]; id != ">" {
+				if len(streamEntries) == 0 {
+					sr.ids[stream] = ">"
+					switched = true
+				} else {
+					sr.ids[stream] = streamEntries[len(streamEntries)-1].ID
+				}
+			}
+			entries = append(entries, streamEntries...)
+		}
+
+		// Don't return an empty set just because the pending entries were
+		// finished, go straight on to reading new ones
+		if len(entries) > 0 || !switched {
+			return entries, nil
+		}
+	}
+}
+
+// Stats returns the StreamReader's current StreamReaderStats. It may be called
+// at the same time as any other method.
+func (sr *StreamReader) Stats() StreamReaderStats {
+	return StreamReaderStats{
+		Claimed:      atomic.LoadInt64(&sr.claimed),
+		DeadLettered: atomic.LoadInt64(&sr.deadLettered),
+	}
+}
+
+// claim claims all idle pending entries on all streams. XAUTOCLAIM is used
+// when possible, but it doesn't give back delivery counts, so if MaxDeliveries
+// is set, or if redis is older than 6.2, XPENDING and XCLAIM are used instead.
+//
+// In both cases the claiming command only claims entries which are still idle
+// at the moment it runs, so an entry being claimed by two consumers at once
+// will only be given to one of them.
+func (sr *StreamReader) claim() ([]StreamEntry, error) {
+	var entries []StreamEntry
+	for _, stream := range sr.o.Streams {
+		var streamEntries []StreamEntry
+		var err error
+		if sr.o.MaxDeliveries == 0 && !sr.noAutoClaim {
+			streamEntries, err = sr.autoClaim(stream)
+			if err != nil && strings.Contains(strings.ToLower(err.Error()), "unknown command") {
+				sr.noAutoClaim = true
+				streamEntries, err = sr.pendingClaim(stream)
+			}
+		} else {
+			streamEntries, err = sr.pendingClaim(stream)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range streamEntries {
+			streamEntries[i].Stream = stream
+			streamEntries[i].Reclaimed = true
+		}
+		entries = append(entries, streamEntries...)
+	}
+	atomic.AddInt64(&sr.claimed, int64(len(entries)))
+	return entries, nil
+}
+
+const claimPageSize = 100
+
+func (sr *StreamReader) autoClaim(stream string) ([]StreamEntry, error) {
+	var entries []StreamEntry
+	cursor := "0-0"
+	for {
+		parts, err := sr.conn.Cmd(
+			"XAUTOCLAIM", stream, sr.o.Group, sr.o.Consumer,
+			durationMS(sr.o.ClaimMinIdle), cursor, "COUNT", claimPageSize,
+		).Array()
+		if err != nil {
+			return nil, err
+		} else if len(parts) < 2 {
+			return nil, errors.New("malformed XAUTOCLAIM response")
+		}
+
+		if cursor, err = parts[0].Str(); err != nil {
+			return nil, err
+		}
+		pageEntries, err := decodeClaimed(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, pageEntries...)
+
+		if cursor == "0-0" {
+			return entries, nil
+		}
+	}
+}
+
+func (sr *StreamReader) pendingClaim(stream string) ([]StreamEntry, error) {
+	// deliveries is the number of times each idle entry has been delivered
+	// already
+	deliveries := map[string]int{}
+	var ids []string
+	minIdle := durationMS(sr.o.ClaimMinIdle)
+
+	start := "-"
+	for {
+		pending, err := sr.conn.Cmd(
+			"XPENDING", stream, sr.o.Group, start, "+", claimPageSize,
+		).Array()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pr := range pending {
+			p, err := pr.Array()
+			if err != nil {
+				return nil, err
+			} else if len(p) < 4 {
+				return nil, errors.New("malformed XPENDING response")
+			}
+			id, err := p[0].Str()
+			if err != nil {
+				return nil, err
+			}
+			idle, err := p[2].Int64()
+			if err != nil {
+				return nil, err
+			}
+			count, err := p[3].Int()
+			if err != nil {
+				return nil, err
+			}
+			if idle >= minIdle {
+				ids = append(ids, id)
+				deliveries[id] = count
+			}
+
+			sid, err := ParseStreamID(id)
+			if err != nil {
+				return nil, err
+			}
+			start = sid.Next().String()
+		}
+
+		if len(pending) < claimPageSize {
+			break
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	claimed, err := decodeClaimed(sr.conn.Cmd(
+		"XCLAIM", stream, sr.o.Group, sr.o.Consumer, minIdle, ids,
+	))
+	if err != nil || sr.o.MaxDeliveries == 0 {
+		return claimed, err
+	}
+
+	var entries []StreamEntry
+	var deadIDs []string
+	for _, e := range claimed {
+		if deliveries[e.ID] >= sr.o.MaxDeliveries {
+			deadIDs = append(deadIDs, e.ID)
+		} else {
+			entries = append(entries, e)
+		}
+	}
+	if len(deadIDs) == 0 {
+		return entries, nil
+	}
+
+	if err := sr.conn.Cmd("XACK", stream, sr.o.Group, deadIDs).Err; err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&sr.deadLettered, int64(len(deadIDs)))
+	if sr.o.DeadLetter != nil {
+		for _, e := range claimed {
+			if deliveries[e.ID] >= sr.o.MaxDeliveries {
+				e.Stream = stream
+				sr.o.DeadLetter(e)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// decodeClaimed decodes the entries returned from XCLAIM or XAUTOCLAIM. Some
+// versions of redis return entries which have been deleted as nil, these are
+// skipped.
+func decodeClaimed(r *redis.Resp) ([]StreamEntry, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	arr, err := r.Array()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]StreamEntry, 0, len(arr))
+	for _, er := range arr {
+		if er.IsType(redis.Nil) {
+			continue
+		}
+		e, err := decodeStreamEntry(er)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Ack acknowledges the entries with the given IDs on the given stream, so that
+// they're no longer pending for the consumer. Ack uses the Cmder which was
+// passed into NewStreamReader, so if that was a Client Ack must not be called
+// at the same time as Next.
+func (sr *StreamReader) Ack(stream string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return sr.c.Cmd("XACK", stream, sr.o.Group, ids).Err
+}
+
+// AckEntries is like Ack, but acknowledges the given entries, as returned from
+// Next, on whichever streams they were read from. One XACK is sent per stream,
+// in the order the streams were given in StreamReaderOpts, and the first error
+// is returned. Nothing is acknowledged if any of the entries aren't from one of
+// the StreamReader's streams.
+func (sr *StreamReader) AckEntries(entries ...StreamEntry) error {
+	ids := make(map[string][]string, len(sr.o.Streams))
+	for _, stream := range sr.o.Streams {
+		ids[stream] = nil
+	}
+	for _, e := range entries {
+		if _, ok := ids[e.Stream]; !ok {
+			return errors.New("entry isn't from any of the StreamReader's streams: " + e.Stream)
+		}
+		ids[e.Stream] = append(ids[e.Stream], e.ID)
+	}
+	for _, stream := range sr.o.Streams {
+		if err := sr.Ack(stream, ids[stream]...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the StreamReader's connection, interrupting any call to Next
+// which is currently blocked.
+func (sr *StreamReader) Close() error {
+	var err error
+	sr.closeOnce.Do(func() {
+		close(sr.closed)
+		// the connection is closed rather than being reused, since it may be
+		// in the middle of a blocking read. Like with Transaction it's then
+		// put back, so the Pool or Cluster it came from knows it's gone.
+		err = sr.conn.Close()
+		if client, ok := sr.conn.(*redis.Client); ok && sr.put != nil {
+			sr.nextL.Lock()
+			client.LastCritical = ErrStreamReaderClosed
+			sr.put(client)
+			sr.nextL.Unlock()
+		}
+	})
+	return err
+}
@@ -0,0 +1,241 @@
+package util
+
+import (
+	"errors"
+	. "testing"
+	"time"
+
+	"github.com/levenlabs/golib/testutil"
+	"github.com/mediocregopher/radix.v2/cluster"
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func entryIDs(entries []StreamEntry) []string {
+	ids := make([]string, len(entries))
+	for i := range entries {
+		ids[i] = entries[i].ID
+	}
+	return ids
+}
+
+func TestStreamReader(t *T) {
+	p, err := pool.New("tcp", "127.0.0.1:6379", 10)
+	require.Nil(t, err)
+	c, err := cluster.New("127.0.0.1:7000")
+	require.Nil(t, err)
+
+	for _, c := range []Cmder{p, c} {
+		prefix := "{" + testutil.RandStr() + "}"
+		o := StreamReaderOpts{
+			Streams:  []string{prefix + "a", prefix + "b"},
+			Group:    "group",
+			Consumer: "consumer",
+			Block:    100 * time.Millisecond,
+		}
+		sr, err := NewStreamReader(c, o)
+		require.Nil(t, err)
+
+		// nothing there yet, so should block and return nothing
+		entries, err := sr.Next()
+		require.Nil(t, err)
+		assert.Empty(t, entries)
+
+		idA, err := XAdd(c, o.Streams[0], "*", map[string]string{"k": "a"})
+		require.Nil(t, err)
+		idB, err := XAdd(c, o.Streams[1], "*", map[string]string{"k": "b"})
+		require.Nil(t, err)
+
+		entries, err = sr.Next()
+		require.Nil(t, err)
+		assert.Equal(t, []StreamEntry{
+			{Stream: o.Streams[0], ID: idA, Fields: map[string]string{"k": "a"}},
+			{Stream: o.Streams[1], ID: idB, Fields: map[string]string{"k": "b"}},
+		}, entries)
+		require.Nil(t, sr.Ack(o.Streams[0], idA))
+		require.Nil(t, sr.Close())
+
+		// a new reader for the same consumer should get back the entry
+		// which wasn't acked, and then nothing more
+		sr, err = NewStreamReader(c, o)
+		require.Nil(t, err)
+		entries, err = sr.Next()
+		require.Nil(t, err)
+		assert.Equal(t, []string{idB}, entryIDs(entries))
+		require.Nil(t, sr.Ack(o.Streams[1], idB))
+
+		entries, err = sr.Next()
+		require.Nil(t, err)
+		assert.Empty(t, entries)
+		require.Nil(t, sr.Close())
+	}
+}
+
+func TestStreamReaderClose(t *T) {
+	p, err := pool.NewWithOpts(pool.Opts{
+		Network:    "tcp",
+		Addr:       "127.0.0.1:6379",
+		Size:       1,
+		MaxActive:  1,
+		GetTimeout: time.Second,
+	})
+	require.Nil(t, err)
+	defer p.Close()
+
+	sr, err := NewStreamReader(p, StreamReaderOpts{
+		Streams:  []string{testutil.RandStr()},
+		Group:    "group",
+		Consumer: "consumer",
+		Block:    10 * time.Second,
+	})
+	require.Nil(t, err)
+
+	errCh := make(chan error)
+	go func() {
+		_, err := sr.Next()
+		errCh <- err
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	require.Nil(t, sr.Close())
+	select {
+	case err := <-errCh:
+		assert.Equal(t, ErrStreamReaderClosed, err)
+	case <-time.After(time.Second):
+		t.Fatal("Next didn't return after Close")
+	}
+
+	// the closed connection was put back, freeing up its place in the pool
+	c, err := p.Get()
+	require.Nil(t, err)
+	p.Put(c)
+}
+
+type scriptedStreamConn struct {
+	*scriptedCmder
+}
+
+func (scriptedStreamConn) Close() error { return nil }
+
+func TestStreamReaderClaimScripted(t *T) {
+	sc := &scriptedCmder{replies: []*redis.Resp{
+		// XAUTOCLAIM isn't supported
+		redis.NewResp(errors.New("ERR unknown command 'XAUTOCLAIM'")),
+		// XPENDING
+		redis.NewResp([]interface{}{
+			[]interface{}{"1-0", "other", 5000, 1},
+			[]interface{}{"2-0", "other", 10, 1},
+			[]interface{}{"3-0", "other", 5000, 3},
+		}),
+		// XCLAIM, 3-0 was deleted
+		redis.NewResp([]interface{}{
+			[]interface{}{"1-0", []string{"a", "1"}},
+			nil,
+		}),
+	}}
+	sr := &StreamReader{
+		conn: scriptedStreamConn{sc},
+		o: StreamReaderOpts{
+			Streams:      []string{"s"},
+			Group:        "g",
+			Consumer:     "c",
+			ClaimMinIdle: time.Second,
+		},
+	}
+	entries, err := sr.claim()
+	require.Nil(t, err)
+	assert.Equal(t, []StreamEntry{{
+		Stream:    "s",
+		ID:        "1-0",
+		Fields:    map[string]string{"a": "1"},
+		Reclaimed: true,
+	}}, entries)
+	assert.True(t, sr.noAutoClaim)
+	assert.Equal(t, []interface{}{"XCLAIM", "s", "g", "c", int64(1000), []string{"1-0", "3-0"}}, sc.calls[2])
+	assert.Equal(t, StreamReaderStats{Claimed: 1}, sr.Stats())
+
+	// with MaxDeliveries set entries delivered too many times are acked and
+	// dead-lettered
+	sc = &scriptedCmder{replies: []*redis.Resp{
+		redis.NewResp([]interface{}{
+			[]interface{}{"1-0", "other", 5000, 1},
+			[]interface{}{"3-0", "other", 5000, 3},
+		}),
+		redis.NewResp([]interface{}{
+			[]interface{}{"1-0", []string{"a", "1"}},
+			[]interface{}{"3-0", []string{"a", "3"}},
+		}),
+		redis.NewResp(1), // XACK
+	}}
+	var dead []StreamEntry
+	sr.conn = scriptedStreamConn{sc}
+	sr.o.MaxDeliveries = 3
+	sr.o.DeadLetter = func(e StreamEntry) { dead = append(dead, e) }
+	entries, err = sr.claim()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"1-0"}, entryIDs(entries))
+	assert.Equal(t, []string{"3-0"}, entryIDs(dead))
+	assert.Equal(t, "s", dead[0].Stream)
+	assert.Equal(t, []interface{}{"XACK", "s", "g", []string{"3-0"}}, sc.calls[2])
+	assert.Equal(t, StreamReaderStats{Claimed: 2, DeadLettered: 1}, sr.Stats())
+}
+
+func TestStreamReaderClaim(t *T) {
+	p, err := pool.New("tcp", "127.0.0.1:6379", 10)
+	require.Nil(t, err)
+	stream := testutil.RandStr()
+
+	o := StreamReaderOpts{
+		Streams:  []string{stream},
+		Group:    "group",
+		Consumer: "dead",
+	}
+	dead, err := NewStreamReader(p, o)
+	require.Nil(t, err)
+	id, err := XAdd(p, stream, "*", map[string]string{"k": "v"})
+	require.Nil(t, err)
+	entries, err := dead.Next()
+	require.Nil(t, err)
+	assert.Equal(t, []string{id}, entryIDs(entries))
+	require.Nil(t, dead.Close())
+
+	time.Sleep(100 * time.Millisecond)
+	o.Consumer = "alive"
+	o.ClaimMinIdle = 50 * time.Millisecond
+	alive, err := NewStreamReader(p, o)
+	require.Nil(t, err)
+	entries, err = alive.Next()
+	require.Nil(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, id, entries[0].ID)
+	assert.True(t, entries[0].Reclaimed)
+	assert.Equal(t, int64(1), alive.Stats().Claimed)
+	require.Nil(t, alive.Ack(stream, id))
+	require.Nil(t, alive.Close())
+}
+
+func TestStreamReaderAckEntries(t *T) {
+	sc := &scriptedCmder{replies: []*redis.Resp{redis.NewResp(2), redis.NewResp(1)}}
+	sr := &StreamReader{
+		c: sc,
+		o: StreamReaderOpts{Streams: []string{"a", "b", "c"}, Group: "g"},
+	}
+	require.Nil(t, sr.AckEntries(
+		StreamEntry{Stream: "b", ID: "1-0"},
+		StreamEntry{Stream: "a", ID: "2-0"},
+		StreamEntry{Stream: "b", ID: "3-0"},
+	))
+	assert.Equal(t, [][]interface{}{
+		{"XACK", "a", "g", []string{"2-0"}},
+		{"XACK", "b", "g", []string{"1-0", "3-0"}},
+	}, sc.calls)
+
+	sc.calls = nil
+	assert.NotNil(t, sr.AckEntries(
+		StreamEntry{Stream: "a", ID: "1-0"},
+		StreamEntry{Stream: "d", ID: "1-0"},
+	))
+	assert.Empty(t, sc.calls)
+}
@@ -0,0 +1,153 @@
+package util
+
+import (
+	"errors"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// ErrTxConflict is returned from Transaction when the watched keys were
+// modified by someone else on every attempt at running the transaction
+var ErrTxConflict = errors.New("transaction conflicted on every attempt")
+
+// errTxAbandoned is set as the LastCritical of a connection which Transaction
+// gave up on part way through, so that its pool closes it rather than reusing
+// it
+var errTxAbandoned = errors.New("connection abandoned during transaction")
+
+type txCmd struct {
+	cmd  string
+	args []interface{}
+}
+
+// Tx is given to the callback passed into Transaction. It's used to read the
+// watched keys and queue up the commands which should be run atomically.
+type Tx struct {
+	c      Cmder
+	queued []txCmd
+}
+
+// Cmd runs the given command immediately on the transaction's connection, and
+// returns its response. This is generally used to read the values of the
+// watched keys.
+func (tx *Tx) Cmd(cmd string, args ...interface{}) *redis.Resp {
+	return tx.c.Cmd(cmd, args...)
+}
+
+// Queue adds the given command to the set which will be run atomically inside
+// a MULTI/EXEC once the callback has returned
+func (tx *Tx) Queue(cmd string, args ...interface{}) {
+	tx.queued = append(tx.queued, txCmd{cmd, args})
+}
+
+// Transaction runs an optimistic transaction (see
+// http://redis.io/topics/transactions) on a single connection retrieved from
+// the given Cmder. The given keys are WATCHed, and then fn is called. fn may
+// read the keys using the Tx, and queue the commands to be run using Queue.
+// The queued commands are then run inside a MULTI/EXEC, and their responses
+// are returned.
+//
+// If any of the watched keys were modified before EXEC was called the
+// transaction is thrown away and fn is called again from scratch, up to
+// maxRetries times, after which ErrTxConflict is returned. If fn returns an
+// error Transaction returns it immediately.
+//
+// If the connection came from a Pool, Cluster or sentinel Master it's returned
+// to it once the transaction is done. If fn or any of the transaction's
+// commands fail, or fn panics, the connection may have been left inside a
+// WATCH or MULTI, so it's closed rather than returned. A Client passed in as
+// the Cmder is never closed, and is left unwatched as far as possible. If the
+// Cmder is a Cluster then the first key is used to choose the connection, and
+// all the keys must belong to the same slot. If the Cmder isn't one
+// implemented in radix.v2 it's assumed to be a single connection.
+//
+//	_, err := util.Transaction(p, []string{"foo"}, func(tx *util.Tx) error {
+//		i, err := tx.Cmd("GET", "foo").Int()
+//		if err != nil {
+//			return err
+//		}
+//		tx.Queue("SET", "foo", i+1)
+//		return nil
+//	}, 10)
+func Transaction(
+	c Cmder, keys []string, fn func(*Tx) error, maxRetries int,
+) (
+	[]*redis.Resp, error,
+) {
+	var mainKey string
+	if len(keys) > 0 {
+		mainKey = keys[0]
+	}
+
+	var rr []*redis.Resp
+	var err error
+	_, single := c.(*redis.Client)
+	if cerr := withClientForKey(c, mainKey, func(cc Cmder) {
+		var done bool
+		defer func() {
+			if client, ok := cc.(*redis.Client); ok && !done && !single {
+				client.LastCritical = errTxAbandoned
+				client.Close()
+			}
+		}()
+
+		for i := 0; i <= maxRetries; i++ {
+			var ok bool
+			rr, ok, err = transactionAttempt(cc, keys, fn)
+			if err != nil {
+				return
+			} else if ok {
+				done = true
+				return
+			}
+		}
+		done = true
+		err = ErrTxConflict
+	}); cerr != nil {
+		return nil, cerr
+	}
+	return rr, err
+}
+
+// transactionAttempt returns false if the transaction failed due to the
+// watched keys being modified
+func transactionAttempt(
+	c Cmder, keys []string, fn func(*Tx) error,
+) (
+	[]*redis.Resp, bool, error,
+) {
+	if len(keys) > 0 {
+		if err := c.Cmd("WATCH", keys).Err; err != nil {
+			return nil, false, err
+		}
+	}
+
+	tx := &Tx{c: c}
+	if err := fn(tx); err != nil {
+		c.Cmd("UNWATCH")
+		return nil, false, err
+	} else if len(tx.queued) == 0 {
+		return nil, true, c.Cmd("UNWATCH").Err
+	}
+
+	if err := c.Cmd("MULTI").Err; err != nil {
+		c.Cmd("UNWATCH")
+		return nil, false, err
+	}
+	for _, q := range tx.queued {
+		if err := c.Cmd(q.cmd, q.args...).Err; err != nil {
+			// DISCARD also unwatches all keys
+			c.Cmd("DISCARD")
+			return nil, false, err
+		}
+	}
+
+	r := c.Cmd("EXEC")
+	if r.Err != nil {
+		return nil, false, r.Err
+	} else if r.IsType(redis.Nil) {
+		return nil, false, nil
+	}
+	rr, err := r.Array()
+	return rr, err == nil, err
+}
@@ -0,0 +1,197 @@
+package util
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	. "testing"
+
+	"github.com/levenlabs/golib/testutil"
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func txIncr(key string) func(*Tx) error {
+	return func(tx *Tx) error {
+		r := tx.Cmd("GET", key)
+		var i int
+		if !r.IsType(redis.Nil) {
+			var err error
+			if i, err = r.Int(); err != nil {
+				return err
+			}
+		}
+		tx.Queue("SET", key, i+1)
+		return nil
+	}
+}
+
+func TestTransaction(t *T) {
+	p, err := pool.New("tcp", "127.0.0.1:6379", 10)
+	require.Nil(t, err)
+	key := testutil.RandStr()
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				rr, err := Transaction(p, []string{key}, txIncr(key), 1000)
+				assert.Nil(t, err)
+				assert.Len(t, rr, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	i, err := p.Cmd("GET", key).Int()
+	require.Nil(t, err)
+	assert.Equal(t, 100, i)
+}
+
+func TestTransactionConflict(t *T) {
+	c, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+	c2, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+	key := testutil.RandStr()
+
+	var calls int
+	_, err = Transaction(c, []string{key}, func(tx *Tx) error {
+		calls++
+		require.Nil(t, c2.Cmd("SET", key, "other").Err)
+		tx.Queue("SET", key, "mine")
+		return nil
+	}, 2)
+	assert.Equal(t, ErrTxConflict, err)
+	assert.Equal(t, 3, calls)
+
+	// an error from fn should leave the connection unwatched, so a
+	// modification by someone else doesn't affect it later
+	fnErr := errors.New("fn error")
+	_, err = Transaction(c, []string{key}, func(tx *Tx) error {
+		return fnErr
+	}, 0)
+	assert.Equal(t, fnErr, err)
+	require.Nil(t, c2.Cmd("SET", key, "other").Err)
+	require.Nil(t, c.Cmd("MULTI").Err)
+	require.Nil(t, c.Cmd("SET", key, "mine").Err)
+	rr, err := c.Cmd("EXEC").Array()
+	require.Nil(t, err)
+	assert.Len(t, rr, 1)
+}
+
+func TestTransactionScripted(t *T) {
+	ok := redis.NewRespSimple("OK")
+	queued := redis.NewRespSimple("QUEUED")
+	c := &scriptedCmder{replies: []*redis.Resp{
+		ok,                               // WATCH
+		redis.NewResp("1"),               // GET
+		ok,                               // MULTI
+		queued,                           // SET
+		redis.NewResp(nil),               // EXEC, conflict
+		ok,                               // WATCH
+		redis.NewResp("2"),               // GET
+		ok,                               // MULTI
+		queued,                           // SET
+		redis.NewResp([]interface{}{ok}), // EXEC
+	}}
+	rr, err := Transaction(c, []string{"foo"}, txIncr("foo"), 1)
+	require.Nil(t, err)
+	assert.Len(t, rr, 1)
+
+	var cmds []interface{}
+	for _, call := range c.calls {
+		cmds = append(cmds, call[0])
+	}
+	assert.Equal(t, []interface{}{
+		"WATCH", "GET", "MULTI", "SET", "EXEC",
+		"WATCH", "GET", "MULTI", "SET", "EXEC",
+	}, cmds)
+	assert.Equal(t, []interface{}{"SET", "foo", 3}, c.calls[8])
+
+	// a failure while queueing should DISCARD
+	c = &scriptedCmder{replies: []*redis.Resp{
+		ok, redis.NewResp("1"), ok,
+		redis.NewResp(errors.New("ERR bad")),
+		ok,
+	}}
+	_, err = Transaction(c, []string{"foo"}, txIncr("foo"), 1)
+	assert.NotNil(t, err)
+	assert.Equal(t, "DISCARD", c.calls[4][0])
+}
+
+// txServer is a fake redis server which replies OK to everything, except EXEC
+// which gets an empty array
+func txServer(t *T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				rr := redis.NewRespReader(conn)
+				for {
+					args, err := rr.Read().List()
+					if err != nil {
+						return
+					}
+					r := redis.NewRespSimple("OK")
+					if strings.ToUpper(args[0]) == "EXEC" {
+						r = redis.NewResp([]string{})
+					}
+					r.WriteTo(conn)
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestTransactionDiscard(t *T) {
+	p, err := pool.New("tcp", txServer(t), 1)
+	require.Nil(t, err)
+	defer p.Close()
+
+	ok := func(tx *Tx) error {
+		tx.Queue("SET", "foo", "bar")
+		return nil
+	}
+	_, err = Transaction(p, []string{"foo"}, ok, 1)
+	require.Nil(t, err)
+	assert.Equal(t, 1, p.Avail())
+
+	fnErr := errors.New("fn failed")
+	_, err = Transaction(p, []string{"foo"}, func(tx *Tx) error {
+		return fnErr
+	}, 1)
+	assert.Equal(t, fnErr, err)
+	assert.Equal(t, 0, p.Avail())
+
+	func() {
+		defer func() { assert.NotNil(t, recover()) }()
+		Transaction(p, []string{"foo"}, func(tx *Tx) error {
+			panic("fn panicked")
+		}, 1)
+	}()
+	assert.Equal(t, 0, p.Avail())
+
+	// a Client passed in directly is never closed
+	c, err := p.Get()
+	require.Nil(t, err)
+	_, err = Transaction(c, []string{"foo"}, func(tx *Tx) error {
+		return fnErr
+	}, 1)
+	assert.Equal(t, fnErr, err)
+	assert.Nil(t, c.LastCritical)
+	assert.Nil(t, c.Cmd("PING").Err)
+	c.Close()
+}
@@ -6,17 +6,28 @@ import (
 	"github.com/mediocregopher/radix.v2/cluster"
 	"github.com/mediocregopher/radix.v2/pool"
 	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/mediocregopher/radix.v2/sentinel"
 )
 
-// Cmder is an interface which can be used to interchangeably work with either
-// redis.Client (the basic, single connection redis client), pool.Pool, or
-// cluster.Cluster. All three implement a Cmd method (although, as is the case
-// with Cluster, sometimes with different limitations), and therefore all three
-// are Cmders
+// Cmder is the same as redis.Cmder, and is kept so that existing code which
+// refers to util.Cmder continues to work. Any redis.Cmder is a Cmder, and vice
+// versa.
 type Cmder interface {
-	Cmd(cmd string, args ...interface{}) *redis.Resp
+	redis.Cmder
 }
 
+// getPutter is implemented by the Cmders which hand out individual connections,
+// namely pool.Pool and sentinel.Master
+type getPutter interface {
+	Get() (*redis.Client, error)
+	Put(*redis.Client)
+}
+
+var (
+	_ getPutter = &pool.Pool{}
+	_ getPutter = &sentinel.Master{}
+)
+
 // withClientForKey is useful for retrieving a single client which can handle
 // the given key and perform one or more requests on them, especially when the
 // passed in Cmder is actually a Cluster or Pool.
@@ -37,7 +48,7 @@ func withClientForKey(c Cmder, key string, fn func(c Cmder)) error {
 		defer cc.Put(client)
 		singleC = client
 
-	case *pool.Pool:
+	case getPutter:
 		client, err := cc.Get()
 		if err != nil {
 			return err
@@ -0,0 +1,77 @@
+package util
+
+import (
+	"errors"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// ZMember is a member of a sorted set along with its score
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// DecodeZMembers decodes a reply made up of members and their scores, such as
+// from ZRANGE with WITHSCORES, ZPOPMIN, ZPOPMAX, or ZRANDMEMBER with
+// WITHSCORES. Both the flat form (member, score, member, score...) and the
+// paired form (each member and score in an array of their own, as given by
+// RESP3) are handled.
+func DecodeZMembers(r *redis.Resp) ([]ZMember, error) {
+	arr, err := r.Array()
+	if err != nil {
+		return nil, err
+	}
+
+	// paired form
+	if len(arr) > 0 && arr[0].IsType(redis.Array) {
+		members := make([]ZMember, len(arr))
+		for i, pr := range arr {
+			pair, err := pr.Array()
+			if err != nil {
+				return nil, err
+			} else if len(pair) != 2 {
+				return nil, errors.New("sorted set member pair must have two elements")
+			}
+			if members[i], err = decodeZMember(pair[0], pair[1]); err != nil {
+				return nil, err
+			}
+		}
+		return members, nil
+	}
+
+	if len(arr)%2 != 0 {
+		return nil, errors.New("odd number of elements in member/score reply")
+	}
+	members := make([]ZMember, len(arr)/2)
+	for i := range members {
+		if members[i], err = decodeZMember(arr[i*2], arr[i*2+1]); err != nil {
+			return nil, err
+		}
+	}
+	return members, nil
+}
+
+func decodeZMember(mr, sr *redis.Resp) (ZMember, error) {
+	var zm ZMember
+	var err error
+	if zm.Member, err = mr.Str(); err != nil {
+		return ZMember{}, err
+	}
+	if zm.Score, err = sr.Float64(); err != nil {
+		return ZMember{}, err
+	}
+	return zm, nil
+}
+
+// ZMemberArgs returns the given members as arguments for ZADD, i.e. score,
+// member, score, member...
+//
+//	c.Cmd("ZADD", key, util.ZMemberArgs(members))
+func ZMemberArgs(members []ZMember) []interface{} {
+	args := make([]interface{}, 0, len(members)*2)
+	for _, zm := range members {
+		args = append(args, zm.Score, zm.Member)
+	}
+	return args
+}
@@ -0,0 +1,60 @@
+package util
+
+import (
+	"math"
+	. "testing"
+
+	"github.com/levenlabs/golib/testutil"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeZMembers(t *T) {
+	expected := []ZMember{{"a", 1}, {"b", 2.5}, {"c", math.Inf(-1)}}
+
+	members, err := DecodeZMembers(redis.NewResp([]string{"a", "1", "b", "2.5", "c", "-inf"}))
+	require.Nil(t, err)
+	assert.Equal(t, expected, members)
+
+	members, err = DecodeZMembers(redis.NewResp([]interface{}{
+		[]string{"a", "1"}, []string{"b", "2.5"}, []string{"c", "-inf"},
+	}))
+	require.Nil(t, err)
+	assert.Equal(t, expected, members)
+
+	members, err = DecodeZMembers(redis.NewResp([]string{}))
+	require.Nil(t, err)
+	assert.Empty(t, members)
+
+	_, err = DecodeZMembers(redis.NewResp([]string{"a", "1", "b"}))
+	assert.NotNil(t, err)
+	_, err = DecodeZMembers(redis.NewResp([]interface{}{[]string{"a"}}))
+	assert.NotNil(t, err)
+	_, err = DecodeZMembers(redis.NewResp([]string{"a", "notafloat"}))
+	assert.NotNil(t, err)
+}
+
+func TestZMemberArgs(t *T) {
+	assert.Equal(t,
+		[]interface{}{1.0, "a", 2.5, "b"},
+		ZMemberArgs([]ZMember{{"a", 1}, {"b", 2.5}}),
+	)
+}
+
+func TestZMembersRoundTrip(t *T) {
+	c, err := redis.Dial("tcp", "127.0.0.1:6379")
+	require.Nil(t, err)
+	key := testutil.RandStr()
+
+	members := []ZMember{{"a", 1}, {"b", 2.5}, {"c", 10}}
+	require.Nil(t, c.Cmd("ZADD", key, ZMemberArgs(members)).Err)
+
+	got, err := DecodeZMembers(c.Cmd("ZRANGE", key, 0, -1, "WITHSCORES"))
+	require.Nil(t, err)
+	assert.Equal(t, members, got)
+
+	got, err = DecodeZMembers(c.Cmd("ZPOPMAX", key))
+	require.Nil(t, err)
+	assert.Equal(t, []ZMember{{"c", 10}}, got)
+}